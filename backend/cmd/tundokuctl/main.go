@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os"
+
+	"tundoku-killer/backend/internal/app"
+)
+
+// tundokuctl は運用者向けの CLI。サーバーと同じ環境変数 (SUPABASE_URL など) を読み、同じ関数で DB を扱う。
+//
+//	go run ./cmd/tundokuctl users list
+func main() {
+	app.Init()
+	os.Exit(app.RunCtl(os.Args[1:]))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// web/ にフロントエンドのビルド結果を置いてビルドすると、バイナリに同梱して / から配信する (web/README.md)
+//
+//go:embed all:web
+var webFiles embed.FS
+
+// frontendHandler は同梱したフロントエンドを配信する。index.html がなければ nil。
+// ファイルがないパスは SPA のルーティングに任せるため index.html を返す (/api/ 以下は 404 のまま)。
+func frontendHandler() http.HandlerFunc {
+	site, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		log.Printf("[ERROR] cannot open embedded frontend: %v", err)
+		return nil
+	}
+	if _, err := fs.Stat(site, "index.html"); err != nil {
+		return nil
+	}
+	files := http.FileServerFS(site)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			http.NotFound(w, r)
+			return
+		}
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		if info, err := fs.Stat(site, name); name == "" || err != nil || info.IsDir() {
+			w.Header().Set("Cache-Control", "no-cache")
+			http.ServeFileFS(w, r, site, "index.html")
+			return
+		}
+		// Vite が出力する assets/ はファイル名にハッシュが入るので長くキャッシュしてよい
+		if strings.HasPrefix(name, "assets/") {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		files.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,779 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v5.29.3
+// source: tundoku/v1/books.proto
+
+package tundokuv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Book struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	BookId   string                 `protobuf:"bytes,1,opt,name=book_id,json=bookId,proto3" json:"book_id,omitempty"`
+	UserId   string                 `protobuf:"bytes,2,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Title    string                 `protobuf:"bytes,3,opt,name=title,proto3" json:"title,omitempty"`
+	Author   string                 `protobuf:"bytes,4,opt,name=author,proto3" json:"author,omitempty"`
+	Deadline *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=deadline,proto3" json:"deadline,omitempty"`
+	// unread, reading, insulted, completed, wishlist, abandoned
+	Status      string `protobuf:"bytes,6,opt,name=status,proto3" json:"status,omitempty"`
+	InsultLevel int32  `protobuf:"varint,7,opt,name=insult_level,json=insultLevel,proto3" json:"insult_level,omitempty"`
+	InsultTone  string `protobuf:"bytes,8,opt,name=insult_tone,json=insultTone,proto3" json:"insult_tone,omitempty"`
+	// paper, ebook, audiobook
+	Format          string                 `protobuf:"bytes,9,opt,name=format,proto3" json:"format,omitempty"`
+	PageCount       *int32                 `protobuf:"varint,10,opt,name=page_count,json=pageCount,proto3,oneof" json:"page_count,omitempty"`
+	DurationMinutes *int32                 `protobuf:"varint,11,opt,name=duration_minutes,json=durationMinutes,proto3,oneof" json:"duration_minutes,omitempty"`
+	Progress        int32                  `protobuf:"varint,12,opt,name=progress,proto3" json:"progress,omitempty"`
+	Series          string                 `protobuf:"bytes,13,opt,name=series,proto3" json:"series,omitempty"`
+	Volume          *int32                 `protobuf:"varint,14,opt,name=volume,proto3,oneof" json:"volume,omitempty"`
+	Price           *int32                 `protobuf:"varint,15,opt,name=price,proto3,oneof" json:"price,omitempty"`
+	Tags            []string               `protobuf:"bytes,16,rep,name=tags,proto3" json:"tags,omitempty"`
+	SortOrder       int32                  `protobuf:"varint,17,opt,name=sort_order,json=sortOrder,proto3" json:"sort_order,omitempty"`
+	CreatedAt       *timestamppb.Timestamp `protobuf:"bytes,18,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	UpdatedAt       *timestamppb.Timestamp `protobuf:"bytes,19,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *Book) Reset() {
+	*x = Book{}
+	mi := &file_tundoku_v1_books_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Book) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Book) ProtoMessage() {}
+
+func (x *Book) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_books_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Book.ProtoReflect.Descriptor instead.
+func (*Book) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_books_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Book) GetBookId() string {
+	if x != nil {
+		return x.BookId
+	}
+	return ""
+}
+
+func (x *Book) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *Book) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Book) GetAuthor() string {
+	if x != nil {
+		return x.Author
+	}
+	return ""
+}
+
+func (x *Book) GetDeadline() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Deadline
+	}
+	return nil
+}
+
+func (x *Book) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Book) GetInsultLevel() int32 {
+	if x != nil {
+		return x.InsultLevel
+	}
+	return 0
+}
+
+func (x *Book) GetInsultTone() string {
+	if x != nil {
+		return x.InsultTone
+	}
+	return ""
+}
+
+func (x *Book) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *Book) GetPageCount() int32 {
+	if x != nil && x.PageCount != nil {
+		return *x.PageCount
+	}
+	return 0
+}
+
+func (x *Book) GetDurationMinutes() int32 {
+	if x != nil && x.DurationMinutes != nil {
+		return *x.DurationMinutes
+	}
+	return 0
+}
+
+func (x *Book) GetProgress() int32 {
+	if x != nil {
+		return x.Progress
+	}
+	return 0
+}
+
+func (x *Book) GetSeries() string {
+	if x != nil {
+		return x.Series
+	}
+	return ""
+}
+
+func (x *Book) GetVolume() int32 {
+	if x != nil && x.Volume != nil {
+		return *x.Volume
+	}
+	return 0
+}
+
+func (x *Book) GetPrice() int32 {
+	if x != nil && x.Price != nil {
+		return *x.Price
+	}
+	return 0
+}
+
+func (x *Book) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Book) GetSortOrder() int32 {
+	if x != nil {
+		return x.SortOrder
+	}
+	return 0
+}
+
+func (x *Book) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *Book) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+type ListBooksRequest struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	UserId string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	// "", "wishlist" or "owned"
+	Tier          string `protobuf:"bytes,2,opt,name=tier,proto3" json:"tier,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListBooksRequest) Reset() {
+	*x = ListBooksRequest{}
+	mi := &file_tundoku_v1_books_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListBooksRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListBooksRequest) ProtoMessage() {}
+
+func (x *ListBooksRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_books_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListBooksRequest.ProtoReflect.Descriptor instead.
+func (*ListBooksRequest) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_books_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ListBooksRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *ListBooksRequest) GetTier() string {
+	if x != nil {
+		return x.Tier
+	}
+	return ""
+}
+
+type ListBooksResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Books         []*Book                `protobuf:"bytes,1,rep,name=books,proto3" json:"books,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListBooksResponse) Reset() {
+	*x = ListBooksResponse{}
+	mi := &file_tundoku_v1_books_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListBooksResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListBooksResponse) ProtoMessage() {}
+
+func (x *ListBooksResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_books_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListBooksResponse.ProtoReflect.Descriptor instead.
+func (*ListBooksResponse) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_books_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListBooksResponse) GetBooks() []*Book {
+	if x != nil {
+		return x.Books
+	}
+	return nil
+}
+
+type GetBookRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	BookId        string                 `protobuf:"bytes,2,opt,name=book_id,json=bookId,proto3" json:"book_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetBookRequest) Reset() {
+	*x = GetBookRequest{}
+	mi := &file_tundoku_v1_books_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetBookRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetBookRequest) ProtoMessage() {}
+
+func (x *GetBookRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_books_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetBookRequest.ProtoReflect.Descriptor instead.
+func (*GetBookRequest) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_books_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetBookRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *GetBookRequest) GetBookId() string {
+	if x != nil {
+		return x.BookId
+	}
+	return ""
+}
+
+type CreateBookRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Book          *Book                  `protobuf:"bytes,1,opt,name=book,proto3" json:"book,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateBookRequest) Reset() {
+	*x = CreateBookRequest{}
+	mi := &file_tundoku_v1_books_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateBookRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateBookRequest) ProtoMessage() {}
+
+func (x *CreateBookRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_books_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateBookRequest.ProtoReflect.Descriptor instead.
+func (*CreateBookRequest) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_books_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *CreateBookRequest) GetBook() *Book {
+	if x != nil {
+		return x.Book
+	}
+	return nil
+}
+
+type UpdateBookRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Book          *Book                  `protobuf:"bytes,1,opt,name=book,proto3" json:"book,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateBookRequest) Reset() {
+	*x = UpdateBookRequest{}
+	mi := &file_tundoku_v1_books_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateBookRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateBookRequest) ProtoMessage() {}
+
+func (x *UpdateBookRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_books_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateBookRequest.ProtoReflect.Descriptor instead.
+func (*UpdateBookRequest) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_books_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *UpdateBookRequest) GetBook() *Book {
+	if x != nil {
+		return x.Book
+	}
+	return nil
+}
+
+type DeleteBookRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	BookId        string                 `protobuf:"bytes,2,opt,name=book_id,json=bookId,proto3" json:"book_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteBookRequest) Reset() {
+	*x = DeleteBookRequest{}
+	mi := &file_tundoku_v1_books_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteBookRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteBookRequest) ProtoMessage() {}
+
+func (x *DeleteBookRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_books_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteBookRequest.ProtoReflect.Descriptor instead.
+func (*DeleteBookRequest) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_books_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *DeleteBookRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *DeleteBookRequest) GetBookId() string {
+	if x != nil {
+		return x.BookId
+	}
+	return ""
+}
+
+type DeleteBookResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteBookResponse) Reset() {
+	*x = DeleteBookResponse{}
+	mi := &file_tundoku_v1_books_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteBookResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteBookResponse) ProtoMessage() {}
+
+func (x *DeleteBookResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_books_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteBookResponse.ProtoReflect.Descriptor instead.
+func (*DeleteBookResponse) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_books_proto_rawDescGZIP(), []int{7}
+}
+
+type CompleteBookRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	BookId        string                 `protobuf:"bytes,1,opt,name=book_id,json=bookId,proto3" json:"book_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CompleteBookRequest) Reset() {
+	*x = CompleteBookRequest{}
+	mi := &file_tundoku_v1_books_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CompleteBookRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CompleteBookRequest) ProtoMessage() {}
+
+func (x *CompleteBookRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_books_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CompleteBookRequest.ProtoReflect.Descriptor instead.
+func (*CompleteBookRequest) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_books_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *CompleteBookRequest) GetBookId() string {
+	if x != nil {
+		return x.BookId
+	}
+	return ""
+}
+
+type CompleteBookResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CurrentStreak int32                  `protobuf:"varint,1,opt,name=current_streak,json=currentStreak,proto3" json:"current_streak,omitempty"`
+	Achievements  []string               `protobuf:"bytes,2,rep,name=achievements,proto3" json:"achievements,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CompleteBookResponse) Reset() {
+	*x = CompleteBookResponse{}
+	mi := &file_tundoku_v1_books_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CompleteBookResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CompleteBookResponse) ProtoMessage() {}
+
+func (x *CompleteBookResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_books_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CompleteBookResponse.ProtoReflect.Descriptor instead.
+func (*CompleteBookResponse) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_books_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *CompleteBookResponse) GetCurrentStreak() int32 {
+	if x != nil {
+		return x.CurrentStreak
+	}
+	return 0
+}
+
+func (x *CompleteBookResponse) GetAchievements() []string {
+	if x != nil {
+		return x.Achievements
+	}
+	return nil
+}
+
+var File_tundoku_v1_books_proto protoreflect.FileDescriptor
+
+const file_tundoku_v1_books_proto_rawDesc = "" +
+	"\n" +
+	"\x16tundoku/v1/books.proto\x12\n" +
+	"tundoku.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xb4\x05\n" +
+	"\x04Book\x12\x17\n" +
+	"\abook_id\x18\x01 \x01(\tR\x06bookId\x12\x17\n" +
+	"\auser_id\x18\x02 \x01(\tR\x06userId\x12\x14\n" +
+	"\x05title\x18\x03 \x01(\tR\x05title\x12\x16\n" +
+	"\x06author\x18\x04 \x01(\tR\x06author\x126\n" +
+	"\bdeadline\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\bdeadline\x12\x16\n" +
+	"\x06status\x18\x06 \x01(\tR\x06status\x12!\n" +
+	"\finsult_level\x18\a \x01(\x05R\vinsultLevel\x12\x1f\n" +
+	"\vinsult_tone\x18\b \x01(\tR\n" +
+	"insultTone\x12\x16\n" +
+	"\x06format\x18\t \x01(\tR\x06format\x12\"\n" +
+	"\n" +
+	"page_count\x18\n" +
+	" \x01(\x05H\x00R\tpageCount\x88\x01\x01\x12.\n" +
+	"\x10duration_minutes\x18\v \x01(\x05H\x01R\x0fdurationMinutes\x88\x01\x01\x12\x1a\n" +
+	"\bprogress\x18\f \x01(\x05R\bprogress\x12\x16\n" +
+	"\x06series\x18\r \x01(\tR\x06series\x12\x1b\n" +
+	"\x06volume\x18\x0e \x01(\x05H\x02R\x06volume\x88\x01\x01\x12\x19\n" +
+	"\x05price\x18\x0f \x01(\x05H\x03R\x05price\x88\x01\x01\x12\x12\n" +
+	"\x04tags\x18\x10 \x03(\tR\x04tags\x12\x1d\n" +
+	"\n" +
+	"sort_order\x18\x11 \x01(\x05R\tsortOrder\x129\n" +
+	"\n" +
+	"created_at\x18\x12 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x129\n" +
+	"\n" +
+	"updated_at\x18\x13 \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAtB\r\n" +
+	"\v_page_countB\x13\n" +
+	"\x11_duration_minutesB\t\n" +
+	"\a_volumeB\b\n" +
+	"\x06_price\"?\n" +
+	"\x10ListBooksRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x12\n" +
+	"\x04tier\x18\x02 \x01(\tR\x04tier\";\n" +
+	"\x11ListBooksResponse\x12&\n" +
+	"\x05books\x18\x01 \x03(\v2\x10.tundoku.v1.BookR\x05books\"B\n" +
+	"\x0eGetBookRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x17\n" +
+	"\abook_id\x18\x02 \x01(\tR\x06bookId\"9\n" +
+	"\x11CreateBookRequest\x12$\n" +
+	"\x04book\x18\x01 \x01(\v2\x10.tundoku.v1.BookR\x04book\"9\n" +
+	"\x11UpdateBookRequest\x12$\n" +
+	"\x04book\x18\x01 \x01(\v2\x10.tundoku.v1.BookR\x04book\"E\n" +
+	"\x11DeleteBookRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x17\n" +
+	"\abook_id\x18\x02 \x01(\tR\x06bookId\"\x14\n" +
+	"\x12DeleteBookResponse\".\n" +
+	"\x13CompleteBookRequest\x12\x17\n" +
+	"\abook_id\x18\x01 \x01(\tR\x06bookId\"a\n" +
+	"\x14CompleteBookResponse\x12%\n" +
+	"\x0ecurrent_streak\x18\x01 \x01(\x05R\rcurrentStreak\x12\"\n" +
+	"\fachievements\x18\x02 \x03(\tR\fachievements2\xae\x03\n" +
+	"\vBookService\x12H\n" +
+	"\tListBooks\x12\x1c.tundoku.v1.ListBooksRequest\x1a\x1d.tundoku.v1.ListBooksResponse\x127\n" +
+	"\aGetBook\x12\x1a.tundoku.v1.GetBookRequest\x1a\x10.tundoku.v1.Book\x12=\n" +
+	"\n" +
+	"CreateBook\x12\x1d.tundoku.v1.CreateBookRequest\x1a\x10.tundoku.v1.Book\x12=\n" +
+	"\n" +
+	"UpdateBook\x12\x1d.tundoku.v1.UpdateBookRequest\x1a\x10.tundoku.v1.Book\x12K\n" +
+	"\n" +
+	"DeleteBook\x12\x1d.tundoku.v1.DeleteBookRequest\x1a\x1e.tundoku.v1.DeleteBookResponse\x12Q\n" +
+	"\fCompleteBook\x12\x1f.tundoku.v1.CompleteBookRequest\x1a .tundoku.v1.CompleteBookResponseB1Z/tundoku-killer/backend/gen/tundoku/v1;tundokuv1b\x06proto3"
+
+var (
+	file_tundoku_v1_books_proto_rawDescOnce sync.Once
+	file_tundoku_v1_books_proto_rawDescData []byte
+)
+
+func file_tundoku_v1_books_proto_rawDescGZIP() []byte {
+	file_tundoku_v1_books_proto_rawDescOnce.Do(func() {
+		file_tundoku_v1_books_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_tundoku_v1_books_proto_rawDesc), len(file_tundoku_v1_books_proto_rawDesc)))
+	})
+	return file_tundoku_v1_books_proto_rawDescData
+}
+
+var file_tundoku_v1_books_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_tundoku_v1_books_proto_goTypes = []any{
+	(*Book)(nil),                  // 0: tundoku.v1.Book
+	(*ListBooksRequest)(nil),      // 1: tundoku.v1.ListBooksRequest
+	(*ListBooksResponse)(nil),     // 2: tundoku.v1.ListBooksResponse
+	(*GetBookRequest)(nil),        // 3: tundoku.v1.GetBookRequest
+	(*CreateBookRequest)(nil),     // 4: tundoku.v1.CreateBookRequest
+	(*UpdateBookRequest)(nil),     // 5: tundoku.v1.UpdateBookRequest
+	(*DeleteBookRequest)(nil),     // 6: tundoku.v1.DeleteBookRequest
+	(*DeleteBookResponse)(nil),    // 7: tundoku.v1.DeleteBookResponse
+	(*CompleteBookRequest)(nil),   // 8: tundoku.v1.CompleteBookRequest
+	(*CompleteBookResponse)(nil),  // 9: tundoku.v1.CompleteBookResponse
+	(*timestamppb.Timestamp)(nil), // 10: google.protobuf.Timestamp
+}
+var file_tundoku_v1_books_proto_depIdxs = []int32{
+	10, // 0: tundoku.v1.Book.deadline:type_name -> google.protobuf.Timestamp
+	10, // 1: tundoku.v1.Book.created_at:type_name -> google.protobuf.Timestamp
+	10, // 2: tundoku.v1.Book.updated_at:type_name -> google.protobuf.Timestamp
+	0,  // 3: tundoku.v1.ListBooksResponse.books:type_name -> tundoku.v1.Book
+	0,  // 4: tundoku.v1.CreateBookRequest.book:type_name -> tundoku.v1.Book
+	0,  // 5: tundoku.v1.UpdateBookRequest.book:type_name -> tundoku.v1.Book
+	1,  // 6: tundoku.v1.BookService.ListBooks:input_type -> tundoku.v1.ListBooksRequest
+	3,  // 7: tundoku.v1.BookService.GetBook:input_type -> tundoku.v1.GetBookRequest
+	4,  // 8: tundoku.v1.BookService.CreateBook:input_type -> tundoku.v1.CreateBookRequest
+	5,  // 9: tundoku.v1.BookService.UpdateBook:input_type -> tundoku.v1.UpdateBookRequest
+	6,  // 10: tundoku.v1.BookService.DeleteBook:input_type -> tundoku.v1.DeleteBookRequest
+	8,  // 11: tundoku.v1.BookService.CompleteBook:input_type -> tundoku.v1.CompleteBookRequest
+	2,  // 12: tundoku.v1.BookService.ListBooks:output_type -> tundoku.v1.ListBooksResponse
+	0,  // 13: tundoku.v1.BookService.GetBook:output_type -> tundoku.v1.Book
+	0,  // 14: tundoku.v1.BookService.CreateBook:output_type -> tundoku.v1.Book
+	0,  // 15: tundoku.v1.BookService.UpdateBook:output_type -> tundoku.v1.Book
+	7,  // 16: tundoku.v1.BookService.DeleteBook:output_type -> tundoku.v1.DeleteBookResponse
+	9,  // 17: tundoku.v1.BookService.CompleteBook:output_type -> tundoku.v1.CompleteBookResponse
+	12, // [12:18] is the sub-list for method output_type
+	6,  // [6:12] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_tundoku_v1_books_proto_init() }
+func file_tundoku_v1_books_proto_init() {
+	if File_tundoku_v1_books_proto != nil {
+		return
+	}
+	file_tundoku_v1_books_proto_msgTypes[0].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_tundoku_v1_books_proto_rawDesc), len(file_tundoku_v1_books_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_tundoku_v1_books_proto_goTypes,
+		DependencyIndexes: file_tundoku_v1_books_proto_depIdxs,
+		MessageInfos:      file_tundoku_v1_books_proto_msgTypes,
+	}.Build()
+	File_tundoku_v1_books_proto = out.File
+	file_tundoku_v1_books_proto_goTypes = nil
+	file_tundoku_v1_books_proto_depIdxs = nil
+}
@@ -0,0 +1,506 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v5.29.3
+// source: tundoku/v1/notifications.proto
+
+package tundokuv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type NotificationJob struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	JobId string                 `protobuf:"bytes,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	// insult, review_nudge, digest, milestone, monthly_report, group_shame
+	Kind       string   `protobuf:"bytes,2,opt,name=kind,proto3" json:"kind,omitempty"`
+	BookId     string   `protobuf:"bytes,3,opt,name=book_id,json=bookId,proto3" json:"book_id,omitempty"`
+	BookIds    []string `protobuf:"bytes,4,rep,name=book_ids,json=bookIds,proto3" json:"book_ids,omitempty"`
+	UserId     string   `protobuf:"bytes,5,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	LineUserId string   `protobuf:"bytes,6,opt,name=line_user_id,json=lineUserId,proto3" json:"line_user_id,omitempty"`
+	Message    string   `protobuf:"bytes,7,opt,name=message,proto3" json:"message,omitempty"`
+	Template   string   `protobuf:"bytes,8,opt,name=template,proto3" json:"template,omitempty"`
+	// pending, processing, sent, failed, skipped
+	Status        string                 `protobuf:"bytes,9,opt,name=status,proto3" json:"status,omitempty"`
+	Attempts      int32                  `protobuf:"varint,10,opt,name=attempts,proto3" json:"attempts,omitempty"`
+	LastError     string                 `protobuf:"bytes,11,opt,name=last_error,json=lastError,proto3" json:"last_error,omitempty"`
+	RunAt         *timestamppb.Timestamp `protobuf:"bytes,12,opt,name=run_at,json=runAt,proto3" json:"run_at,omitempty"`
+	SentAt        *timestamppb.Timestamp `protobuf:"bytes,13,opt,name=sent_at,json=sentAt,proto3" json:"sent_at,omitempty"`
+	CreatedAt     *timestamppb.Timestamp `protobuf:"bytes,14,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NotificationJob) Reset() {
+	*x = NotificationJob{}
+	mi := &file_tundoku_v1_notifications_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NotificationJob) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NotificationJob) ProtoMessage() {}
+
+func (x *NotificationJob) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_notifications_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NotificationJob.ProtoReflect.Descriptor instead.
+func (*NotificationJob) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_notifications_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *NotificationJob) GetJobId() string {
+	if x != nil {
+		return x.JobId
+	}
+	return ""
+}
+
+func (x *NotificationJob) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *NotificationJob) GetBookId() string {
+	if x != nil {
+		return x.BookId
+	}
+	return ""
+}
+
+func (x *NotificationJob) GetBookIds() []string {
+	if x != nil {
+		return x.BookIds
+	}
+	return nil
+}
+
+func (x *NotificationJob) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *NotificationJob) GetLineUserId() string {
+	if x != nil {
+		return x.LineUserId
+	}
+	return ""
+}
+
+func (x *NotificationJob) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *NotificationJob) GetTemplate() string {
+	if x != nil {
+		return x.Template
+	}
+	return ""
+}
+
+func (x *NotificationJob) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *NotificationJob) GetAttempts() int32 {
+	if x != nil {
+		return x.Attempts
+	}
+	return 0
+}
+
+func (x *NotificationJob) GetLastError() string {
+	if x != nil {
+		return x.LastError
+	}
+	return ""
+}
+
+func (x *NotificationJob) GetRunAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.RunAt
+	}
+	return nil
+}
+
+func (x *NotificationJob) GetSentAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.SentAt
+	}
+	return nil
+}
+
+func (x *NotificationJob) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+type CheckDeadlinesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckDeadlinesRequest) Reset() {
+	*x = CheckDeadlinesRequest{}
+	mi := &file_tundoku_v1_notifications_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckDeadlinesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckDeadlinesRequest) ProtoMessage() {}
+
+func (x *CheckDeadlinesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_notifications_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckDeadlinesRequest.ProtoReflect.Descriptor instead.
+func (*CheckDeadlinesRequest) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_notifications_proto_rawDescGZIP(), []int{1}
+}
+
+type CheckDeadlinesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Queued        int32                  `protobuf:"varint,1,opt,name=queued,proto3" json:"queued,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckDeadlinesResponse) Reset() {
+	*x = CheckDeadlinesResponse{}
+	mi := &file_tundoku_v1_notifications_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckDeadlinesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckDeadlinesResponse) ProtoMessage() {}
+
+func (x *CheckDeadlinesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_notifications_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckDeadlinesResponse.ProtoReflect.Descriptor instead.
+func (*CheckDeadlinesResponse) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_notifications_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *CheckDeadlinesResponse) GetQueued() int32 {
+	if x != nil {
+		return x.Queued
+	}
+	return 0
+}
+
+type ListJobsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Status        string                 `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	Limit         int32                  `protobuf:"varint,3,opt,name=limit,proto3" json:"limit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListJobsRequest) Reset() {
+	*x = ListJobsRequest{}
+	mi := &file_tundoku_v1_notifications_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListJobsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListJobsRequest) ProtoMessage() {}
+
+func (x *ListJobsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_notifications_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListJobsRequest.ProtoReflect.Descriptor instead.
+func (*ListJobsRequest) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_notifications_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListJobsRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *ListJobsRequest) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *ListJobsRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type ListJobsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Jobs          []*NotificationJob     `protobuf:"bytes,1,rep,name=jobs,proto3" json:"jobs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListJobsResponse) Reset() {
+	*x = ListJobsResponse{}
+	mi := &file_tundoku_v1_notifications_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListJobsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListJobsResponse) ProtoMessage() {}
+
+func (x *ListJobsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_notifications_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListJobsResponse.ProtoReflect.Descriptor instead.
+func (*ListJobsResponse) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_notifications_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ListJobsResponse) GetJobs() []*NotificationJob {
+	if x != nil {
+		return x.Jobs
+	}
+	return nil
+}
+
+type RetryJobRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	JobId         string                 `protobuf:"bytes,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RetryJobRequest) Reset() {
+	*x = RetryJobRequest{}
+	mi := &file_tundoku_v1_notifications_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RetryJobRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RetryJobRequest) ProtoMessage() {}
+
+func (x *RetryJobRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_notifications_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RetryJobRequest.ProtoReflect.Descriptor instead.
+func (*RetryJobRequest) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_notifications_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *RetryJobRequest) GetJobId() string {
+	if x != nil {
+		return x.JobId
+	}
+	return ""
+}
+
+var File_tundoku_v1_notifications_proto protoreflect.FileDescriptor
+
+const file_tundoku_v1_notifications_proto_rawDesc = "" +
+	"\n" +
+	"\x1etundoku/v1/notifications.proto\x12\n" +
+	"tundoku.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xd7\x03\n" +
+	"\x0fNotificationJob\x12\x15\n" +
+	"\x06job_id\x18\x01 \x01(\tR\x05jobId\x12\x12\n" +
+	"\x04kind\x18\x02 \x01(\tR\x04kind\x12\x17\n" +
+	"\abook_id\x18\x03 \x01(\tR\x06bookId\x12\x19\n" +
+	"\bbook_ids\x18\x04 \x03(\tR\abookIds\x12\x17\n" +
+	"\auser_id\x18\x05 \x01(\tR\x06userId\x12 \n" +
+	"\fline_user_id\x18\x06 \x01(\tR\n" +
+	"lineUserId\x12\x18\n" +
+	"\amessage\x18\a \x01(\tR\amessage\x12\x1a\n" +
+	"\btemplate\x18\b \x01(\tR\btemplate\x12\x16\n" +
+	"\x06status\x18\t \x01(\tR\x06status\x12\x1a\n" +
+	"\battempts\x18\n" +
+	" \x01(\x05R\battempts\x12\x1d\n" +
+	"\n" +
+	"last_error\x18\v \x01(\tR\tlastError\x121\n" +
+	"\x06run_at\x18\f \x01(\v2\x1a.google.protobuf.TimestampR\x05runAt\x123\n" +
+	"\asent_at\x18\r \x01(\v2\x1a.google.protobuf.TimestampR\x06sentAt\x129\n" +
+	"\n" +
+	"created_at\x18\x0e \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\"\x17\n" +
+	"\x15CheckDeadlinesRequest\"0\n" +
+	"\x16CheckDeadlinesResponse\x12\x16\n" +
+	"\x06queued\x18\x01 \x01(\x05R\x06queued\"X\n" +
+	"\x0fListJobsRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\x12\x14\n" +
+	"\x05limit\x18\x03 \x01(\x05R\x05limit\"C\n" +
+	"\x10ListJobsResponse\x12/\n" +
+	"\x04jobs\x18\x01 \x03(\v2\x1b.tundoku.v1.NotificationJobR\x04jobs\"(\n" +
+	"\x0fRetryJobRequest\x12\x15\n" +
+	"\x06job_id\x18\x01 \x01(\tR\x05jobId2\xfb\x01\n" +
+	"\x13NotificationService\x12W\n" +
+	"\x0eCheckDeadlines\x12!.tundoku.v1.CheckDeadlinesRequest\x1a\".tundoku.v1.CheckDeadlinesResponse\x12E\n" +
+	"\bListJobs\x12\x1b.tundoku.v1.ListJobsRequest\x1a\x1c.tundoku.v1.ListJobsResponse\x12D\n" +
+	"\bRetryJob\x12\x1b.tundoku.v1.RetryJobRequest\x1a\x1b.tundoku.v1.NotificationJobB1Z/tundoku-killer/backend/gen/tundoku/v1;tundokuv1b\x06proto3"
+
+var (
+	file_tundoku_v1_notifications_proto_rawDescOnce sync.Once
+	file_tundoku_v1_notifications_proto_rawDescData []byte
+)
+
+func file_tundoku_v1_notifications_proto_rawDescGZIP() []byte {
+	file_tundoku_v1_notifications_proto_rawDescOnce.Do(func() {
+		file_tundoku_v1_notifications_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_tundoku_v1_notifications_proto_rawDesc), len(file_tundoku_v1_notifications_proto_rawDesc)))
+	})
+	return file_tundoku_v1_notifications_proto_rawDescData
+}
+
+var file_tundoku_v1_notifications_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_tundoku_v1_notifications_proto_goTypes = []any{
+	(*NotificationJob)(nil),        // 0: tundoku.v1.NotificationJob
+	(*CheckDeadlinesRequest)(nil),  // 1: tundoku.v1.CheckDeadlinesRequest
+	(*CheckDeadlinesResponse)(nil), // 2: tundoku.v1.CheckDeadlinesResponse
+	(*ListJobsRequest)(nil),        // 3: tundoku.v1.ListJobsRequest
+	(*ListJobsResponse)(nil),       // 4: tundoku.v1.ListJobsResponse
+	(*RetryJobRequest)(nil),        // 5: tundoku.v1.RetryJobRequest
+	(*timestamppb.Timestamp)(nil),  // 6: google.protobuf.Timestamp
+}
+var file_tundoku_v1_notifications_proto_depIdxs = []int32{
+	6, // 0: tundoku.v1.NotificationJob.run_at:type_name -> google.protobuf.Timestamp
+	6, // 1: tundoku.v1.NotificationJob.sent_at:type_name -> google.protobuf.Timestamp
+	6, // 2: tundoku.v1.NotificationJob.created_at:type_name -> google.protobuf.Timestamp
+	0, // 3: tundoku.v1.ListJobsResponse.jobs:type_name -> tundoku.v1.NotificationJob
+	1, // 4: tundoku.v1.NotificationService.CheckDeadlines:input_type -> tundoku.v1.CheckDeadlinesRequest
+	3, // 5: tundoku.v1.NotificationService.ListJobs:input_type -> tundoku.v1.ListJobsRequest
+	5, // 6: tundoku.v1.NotificationService.RetryJob:input_type -> tundoku.v1.RetryJobRequest
+	2, // 7: tundoku.v1.NotificationService.CheckDeadlines:output_type -> tundoku.v1.CheckDeadlinesResponse
+	4, // 8: tundoku.v1.NotificationService.ListJobs:output_type -> tundoku.v1.ListJobsResponse
+	0, // 9: tundoku.v1.NotificationService.RetryJob:output_type -> tundoku.v1.NotificationJob
+	7, // [7:10] is the sub-list for method output_type
+	4, // [4:7] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_tundoku_v1_notifications_proto_init() }
+func file_tundoku_v1_notifications_proto_init() {
+	if File_tundoku_v1_notifications_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_tundoku_v1_notifications_proto_rawDesc), len(file_tundoku_v1_notifications_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_tundoku_v1_notifications_proto_goTypes,
+		DependencyIndexes: file_tundoku_v1_notifications_proto_depIdxs,
+		MessageInfos:      file_tundoku_v1_notifications_proto_msgTypes,
+	}.Build()
+	File_tundoku_v1_notifications_proto = out.File
+	file_tundoku_v1_notifications_proto_goTypes = nil
+	file_tundoku_v1_notifications_proto_depIdxs = nil
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	statusAbandoned      = "abandoned"
+	maxAbandonReasonSize = 500
+)
+
+// handleAbandon は POST /api/books/{id}/abandon。読むのを諦めた本を督促の対象から外す。
+func handleAbandon(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID string `json:"user_id"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if len([]rune(req.Reason)) > maxAbandonReasonSize {
+		http.Error(w, fmt.Sprintf("reason too long (max %d characters)", maxAbandonReasonSize), http.StatusBadRequest)
+		return
+	}
+
+	book, err := fetchOwnedBook(r.PathValue("id"), req.UserID)
+	if err != nil {
+		writeBookLookupError(w, err)
+		return
+	}
+	if book.Status == statusAbandoned || book.Status == "completed" {
+		http.Error(w, fmt.Sprintf("Book is already %s", book.Status), http.StatusConflict)
+		return
+	}
+
+	now := clock.Now()
+	rawResp, _, err := execute(supabaseClient.From("books").Update(map[string]interface{}{
+		"status":         statusAbandoned,
+		"abandon_reason": nullIfEmpty(req.Reason),
+		"abandoned_at":   now,
+		"updated_at":     now,
+	}, "", "").Eq("book_id", book.BookID).Eq("user_id", book.UserID))
+	if err != nil {
+		log.Printf("[ERROR] handleAbandon update error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to abandon book: %v", err), http.StatusInternalServerError)
+		return
+	}
+	emitBookRows("book.abandoned", rawResp)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Book abandoned"})
+}
+
+// handleRevive は POST /api/books/{id}/revive。諦めた本を新しい期限で積読に戻す。
+func handleRevive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID   string     `json:"user_id"`
+		Deadline *time.Time `json:"deadline"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	book, err := fetchOwnedBook(r.PathValue("id"), req.UserID)
+	if err != nil {
+		writeBookLookupError(w, err)
+		return
+	}
+	if book.Status != statusAbandoned {
+		http.Error(w, "Book is not abandoned", http.StatusConflict)
+		return
+	}
+
+	deadline := clock.Now().Add(defaultDeadlineOffset)
+	if req.Deadline != nil {
+		if !req.Deadline.After(clock.Now()) {
+			http.Error(w, "deadline must be in the future", http.StatusBadRequest)
+			return
+		}
+		deadline = *req.Deadline
+	}
+
+	rawResp, _, err := execute(supabaseClient.From("books").Update(map[string]interface{}{
+		"status":         "unread",
+		"deadline":       deadline,
+		"abandon_reason": nil,
+		"abandoned_at":   nil,
+		"updated_at":     clock.Now(),
+	}, "", "").Eq("book_id", book.BookID).Eq("user_id", book.UserID).Eq("status", statusAbandoned))
+	if err != nil {
+		log.Printf("[ERROR] handleRevive update error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to revive book: %v", err), http.StatusInternalServerError)
+		return
+	}
+	emitBookRows("book.updated", rawResp)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Book revived", "deadline": deadline})
+}
@@ -0,0 +1,195 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// アカウントの統合。LINE で作られたユーザーと Supabase Auth (メール) で作られたユーザーのように、
+// 同じ人の行が2つできてしまったときに、片方 (source) の本・履歴・実績などをもう片方 (target) に移して source を消す。
+// 移し替えは merge_users 関数 (schema.sql) の1トランザクションで行い、同じ中で audit_log に user.merge を残す。
+const actionMergeUsers = "user.merge"
+
+// 統合を実行した経路 (audit_log の payload.via)
+const (
+	mergeViaAdmin = "admin"
+	mergeViaUser  = "user"
+)
+
+var (
+	errMergeSameUser = errors.New("cannot merge a user into itself")
+	errMergeNotFound = errors.New("user not found")
+)
+
+// MergeResult は統合の結果。Moved はテーブルごとに移した行数。
+type MergeResult struct {
+	ActionID string         `json:"action_id"`
+	Moved    map[string]int `json:"moved"`
+}
+
+// mergeUsers は source を target に統合する。actorID は実行した管理者 (管理用トークンや本人なら空文字)。
+func mergeUsers(sourceID, targetID, actorID, via string) (*MergeResult, error) {
+	if sourceID == targetID {
+		return nil, errMergeSameUser
+	}
+	// 統合後に消える LINE ユーザーIDのキャッシュを消すため、先に控えておく
+	resp, _, err := execute(supabaseClient.From("users").Select("id, line_user_id", "", false).In("id", []string{sourceID, targetID}))
+	if err != nil {
+		return nil, err
+	}
+	var users []struct {
+		ID         string  `json:"id"`
+		LineUserID *string `json:"line_user_id"`
+	}
+	if err := json.Unmarshal(resp, &users); err != nil {
+		return nil, err
+	}
+	if len(users) != 2 {
+		return nil, errMergeNotFound
+	}
+
+	// 移し替えは冪等でないので再試行しない
+	out, _, err := executeOnce(rpcQuery{name: "merge_users", args: map[string]interface{}{
+		"p_source": sourceID, "p_target": targetID, "p_actor": nullIfEmpty(actorID), "p_via": via,
+	}})
+	if err != nil {
+		if strings.Contains(err.Error(), "P0002") {
+			return nil, errMergeNotFound
+		}
+		return nil, err
+	}
+	var result MergeResult
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, err
+	}
+
+	for _, u := range users {
+		if u.LineUserID != nil {
+			appCache.Delete(userCacheKey(*u.LineUserID), lineChannelCacheKey(*u.LineUserID))
+		}
+	}
+	invalidateBooks(sourceID)
+	invalidateBooks(targetID)
+	log.Printf("[INFO] merged user %s into %s via %s (action %s)", sourceID, targetID, via, result.ActionID)
+	return &result, nil
+}
+
+func writeMergeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errMergeSameUser):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, errMergeNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		log.Printf("[ERROR] merge users error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to merge users: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// handleAdminMergeUsers は POST /api/admin/users/merge {source_id, target_id}。source を target に統合する。
+func handleAdminMergeUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		SourceID string `json:"source_id"`
+		TargetID string `json:"target_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SourceID == "" || req.TargetID == "" {
+		http.Error(w, "source_id and target_id required", http.StatusBadRequest)
+		return
+	}
+	var actorID string
+	if session, err := authenticateSession(r); err == nil {
+		actorID = session.UserID
+	}
+	result, err := mergeUsers(req.SourceID, req.TargetID, actorID, mergeViaAdmin)
+	if err != nil {
+		writeMergeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Users merged", "user_id": req.TargetID, "action_id": result.ActionID, "moved": result.Moved})
+}
+
+// handleMergeMyAccount は POST /api/users/me/merge。ログイン中のユーザーに、もう1つのアカウントを統合する。
+// もう1つのアカウントの持ち主であることは LIFF の ID トークン (idToken) か Supabase Auth のアクセストークン (accessToken) で示す。
+// /api/users/me/relink や /api/auth/supabase が 409 (別のユーザーに本がある) を返したときの解決用。
+func handleMergeMyAccount(w http.ResponseWriter, r *http.Request) {
+	current, err := authenticateSession(r)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		IDToken     string `json:"idToken"`
+		AccessToken string `json:"accessToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.IDToken == "") == (req.AccessToken == "") {
+		http.Error(w, "exactly one of idToken or accessToken required", http.StatusBadRequest)
+		return
+	}
+
+	var column, subject string
+	if req.IDToken != "" {
+		claims, err := verifyLineIDToken(req.IDToken)
+		if err != nil {
+			if errors.Is(err, errInvalidIDToken) {
+				http.Error(w, "Invalid ID token", http.StatusUnauthorized)
+				return
+			}
+			log.Printf("[ERROR] handleMergeMyAccount verify error: %v", err)
+			http.Error(w, "failed to verify ID token", http.StatusBadGateway)
+			return
+		}
+		column, subject = "line_user_id", claims.Subject
+	} else {
+		claims, err := verifySupabaseJWT(req.AccessToken)
+		if err != nil {
+			if errors.Is(err, errInvalidAccessToken) {
+				http.Error(w, "Invalid access token", http.StatusUnauthorized)
+				return
+			}
+			log.Printf("[ERROR] handleMergeMyAccount verify error: %v", err)
+			http.Error(w, "failed to verify access token", http.StatusInternalServerError)
+			return
+		}
+		column, subject = "auth_user_id", claims.Subject
+	}
+
+	resp, _, err := execute(supabaseClient.From("users").Select("id", "", false).Eq(column, subject))
+	if err != nil {
+		log.Printf("[ERROR] handleMergeMyAccount lookup error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch user: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var users []struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(resp, &users)
+	if len(users) == 0 {
+		http.Error(w, "No other account to merge", http.StatusNotFound)
+		return
+	}
+	if users[0].ID == current.UserID {
+		http.Error(w, "That account is already yours", http.StatusConflict)
+		return
+	}
+
+	result, err := mergeUsers(users[0].ID, current.UserID, "", mergeViaUser)
+	if err != nil {
+		writeMergeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Accounts merged", "userId": current.UserID, "action_id": result.ActionID, "moved": result.Moved})
+}
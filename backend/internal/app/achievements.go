@@ -0,0 +1,134 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+)
+
+// achievementStats は実績判定に使う読了時点の集計値
+type achievementStats struct {
+	Completed int // 通算読了数 (今回を含む)
+	Streak    int // 期限内読了の連続数
+	DaysEarly int // 今回の読了が期限より何日早いか
+}
+
+// 実績の条件は achievementStats のどれか1つが min 以上になること。
+// PostgREST 経由の読了では同じ定義を complete_book (schema.sql) に渡して DB 側で判定する。
+const (
+	achievementStatCompleted = "completed"
+	achievementStatStreak    = "streak"
+	achievementStatDaysEarly = "days_early"
+)
+
+type achievementDef struct {
+	Code string `json:"code"`
+	Name string `json:"-"`
+	Stat string `json:"stat"`
+	Min  int    `json:"min"`
+}
+
+func (d achievementDef) unlocked(s achievementStats) bool {
+	switch d.Stat {
+	case achievementStatCompleted:
+		return s.Completed >= d.Min
+	case achievementStatStreak:
+		return s.Streak >= d.Min
+	case achievementStatDaysEarly:
+		return s.DaysEarly >= d.Min
+	}
+	return false
+}
+
+var achievementDefs = []achievementDef{
+	{"first_finish", "はじめての読了", achievementStatCompleted, 1},
+	{"five_finishes", "5冊読了", achievementStatCompleted, 5},
+	{"ten_finishes", "10冊読了", achievementStatCompleted, 10},
+	{"streak_3", "3冊連続で期限内に読了", achievementStatStreak, 3},
+	{"early_bird", "期限の7日以上前に読了", achievementStatDaysEarly, 7},
+}
+
+// handleAchievementDefinitions は GET /api/achievements。解除できる実績の一覧を返す。コードにある定義なのでデプロイまで変わらない。
+func handleAchievementDefinitions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if notModified(w, r, "", definitionsModifiedAt, "public, max-age=3600") {
+		return
+	}
+	defs := make([]map[string]string, 0, len(achievementDefs))
+	for _, def := range achievementDefs {
+		defs = append(defs, map[string]string{"code": def.Code, "name": def.Name})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(defs)
+}
+
+// eligibleAchievements は条件を満たす実績コードを返す (既に解除済みかは問わない)
+func eligibleAchievements(s achievementStats) []string {
+	var codes []string
+	for _, def := range achievementDefs {
+		if def.unlocked(s) {
+			codes = append(codes, def.Code)
+		}
+	}
+	return codes
+}
+
+// unlockAchievementsTx は未解除の実績だけを登録し、新たに解除したコードを返す
+func unlockAchievementsTx(ctx context.Context, tx *sql.Tx, userID string, codes []string) ([]string, error) {
+	var unlocked []string
+	for _, code := range codes {
+		var got string
+		err := tx.QueryRowContext(ctx,
+			`INSERT INTO user_achievements (user_id, code) VALUES ($1, $2) ON CONFLICT DO NOTHING RETURNING code`,
+			userID, code).Scan(&got)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		unlocked = append(unlocked, got)
+	}
+	return unlocked, nil
+}
+
+// unlockAchievementsREST は PostgREST 経由の版。解除済みを読んでから差分を挿入する。
+func unlockAchievementsREST(userID string, codes []string) ([]string, error) {
+	if len(codes) == 0 {
+		return nil, nil
+	}
+	resp, _, err := execute(supabaseClient.From("user_achievements").Select("code", "", false).Eq("user_id", userID))
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		return nil, err
+	}
+	have := make(map[string]bool)
+	for _, row := range rows {
+		have[row.Code] = true
+	}
+
+	var unlocked []string
+	var inserts []map[string]interface{}
+	for _, code := range codes {
+		if !have[code] {
+			unlocked = append(unlocked, code)
+			inserts = append(inserts, map[string]interface{}{"user_id": userID, "code": code, "unlocked_at": clock.Now()})
+		}
+	}
+	if len(inserts) == 0 {
+		return nil, nil
+	}
+	if _, _, err := executeOnce(supabaseClient.From("user_achievements").Insert(inserts, false, "", "", "")); err != nil {
+		return nil, err
+	}
+	return unlocked, nil
+}
@@ -0,0 +1,228 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// 運用者向けの異常検知。リクエストの集計から次の3つを見張り、Slack (ALERT_SLACK_WEBHOOK_URL) か
+// 管理者の LINE (ALERT_LINE_USER_ID) に知らせる。同じ異常は ALERT_COOLDOWN_MINUTES (既定 30) の間は繰り返し送らない。
+//   - 5xx の割合が ALERT_WINDOW_MINUTES (既定 5) の間に ALERT_ERROR_RATE % (既定 10) を超えた (ALERT_ERROR_MIN_REQUESTS 件以上のとき)
+//   - cron が ALERT_CRON_FAILURES 回 (既定 3) 続けて失敗した
+//   - LINE への送信失敗が ALERT_LINE_WINDOW_MINUTES (既定 15) の間に ALERT_LINE_FAILURES 件 (既定 20) を超えた
+const (
+	alertErrorRate   = "error_rate"
+	alertCronFailure = "cron_failure"
+	alertLineFailure = "line_delivery"
+
+	alertTimeout = 5 * time.Second
+	alertHistory = 50
+)
+
+var alertClient = &http.Client{Timeout: alertTimeout}
+
+// Alert は送った (または送ろうとした) 通知1件
+type Alert struct {
+	Kind    string    `json:"kind"`
+	Subject string    `json:"subject"` // ルートや cron の名前。cooldown はこの単位
+	Message string    `json:"message"`
+	At      time.Time `json:"at"`
+}
+
+// alertBucket は1分ごとの件数
+type alertBucket struct {
+	minute   int64
+	requests int
+	errors   int
+}
+
+type alertMonitor struct {
+	mu           sync.Mutex
+	requests     []alertBucket
+	lineFailures []time.Time
+	cronStreaks  map[string]int
+	lastSent     map[string]time.Time
+	recent       []Alert
+}
+
+var opsAlerts = &alertMonitor{cronStreaks: make(map[string]int), lastSent: make(map[string]time.Time)}
+
+func alertWindow() time.Duration {
+	return time.Duration(envInt("ALERT_WINDOW_MINUTES", 5)) * time.Minute
+}
+
+func alertLineWindow() time.Duration {
+	return time.Duration(envInt("ALERT_LINE_WINDOW_MINUTES", 15)) * time.Minute
+}
+
+// recordRequest はリクエスト1件の結果を数え、5xx の割合が閾値を超えたら知らせる
+func (m *alertMonitor) recordRequest(status int, now time.Time) {
+	minute := now.Unix() / 60
+	m.mu.Lock()
+	if n := len(m.requests); n == 0 || m.requests[n-1].minute != minute {
+		m.requests = append(m.requests, alertBucket{minute: minute})
+	}
+	b := &m.requests[len(m.requests)-1]
+	b.requests++
+	if status >= 500 {
+		b.errors++
+	}
+	// 5xx がなくても古い集計は毎回捨てる (数えるだけでは溜まり続けるので)
+	requests, errors := m.windowCounts(now)
+	m.mu.Unlock()
+	if status < 500 {
+		return
+	}
+
+	if requests < envInt("ALERT_ERROR_MIN_REQUESTS", 20) {
+		return
+	}
+	rate := float64(errors) * 100 / float64(requests)
+	if rate >= float64(envInt("ALERT_ERROR_RATE", 10)) {
+		m.fire(alertErrorRate, "api", fmt.Sprintf("エラー率が上がっています: 直近%sで %d / %d 件 (%.1f%%) が 5xx", alertWindow(), errors, requests, rate), now)
+	}
+}
+
+// windowCounts は期間外の集計を捨てて、期間内のリクエスト数と 5xx の数を返す。m.mu を持って呼ぶ。
+func (m *alertMonitor) windowCounts(now time.Time) (requests, errors int) {
+	from := now.Add(-alertWindow()).Unix() / 60
+	i := 0
+	for i < len(m.requests) && m.requests[i].minute <= from {
+		i++
+	}
+	m.requests = m.requests[i:]
+	for _, b := range m.requests {
+		requests += b.requests
+		errors += b.errors
+	}
+	return requests, errors
+}
+
+// recordCron は cron 1回の結果を記録し、続けて失敗した回数が閾値に達したら知らせる
+func (m *alertMonitor) recordCron(name string, err error, now time.Time) {
+	m.mu.Lock()
+	if err == nil {
+		delete(m.cronStreaks, name)
+		m.mu.Unlock()
+		return
+	}
+	m.cronStreaks[name]++
+	streak := m.cronStreaks[name]
+	m.mu.Unlock()
+
+	if streak >= envInt("ALERT_CRON_FAILURES", 3) {
+		m.fire(alertCronFailure, name, fmt.Sprintf("cron %s が%d回続けて失敗しています: %v", name, streak, err), now)
+	}
+}
+
+// recordLineFailure は LINE への送信失敗を数え、期間内の件数が閾値を超えたら知らせる
+func (m *alertMonitor) recordLineFailure(class string, now time.Time) {
+	m.mu.Lock()
+	from := now.Add(-alertLineWindow())
+	i := 0
+	for i < len(m.lineFailures) && !m.lineFailures[i].After(from) {
+		i++
+	}
+	m.lineFailures = append(m.lineFailures[i:], now)
+	failures := len(m.lineFailures)
+	m.mu.Unlock()
+
+	if failures >= envInt("ALERT_LINE_FAILURES", 20) {
+		m.fire(alertLineFailure, "line", fmt.Sprintf("LINE への送信が直近%sで%d件失敗しています (最後は %s)", alertLineWindow(), failures, class), now)
+	}
+}
+
+// fire は cooldown 中でなければ通知を送る。送り先がなければログだけ残す。
+func (m *alertMonitor) fire(kind, subject, message string, now time.Time) {
+	key := kind + ":" + subject
+	cooldown := time.Duration(envInt("ALERT_COOLDOWN_MINUTES", 30)) * time.Minute
+	m.mu.Lock()
+	if last, ok := m.lastSent[key]; ok && now.Sub(last) < cooldown {
+		m.mu.Unlock()
+		return
+	}
+	m.lastSent[key] = now
+	m.recent = append(m.recent, Alert{Kind: kind, Subject: subject, Message: message, At: now})
+	if len(m.recent) > alertHistory {
+		m.recent = m.recent[len(m.recent)-alertHistory:]
+	}
+	m.mu.Unlock()
+
+	log.Printf("[ERROR] ops alert (%s %s): %s", kind, subject, message)
+	go sendOpsAlert(fmt.Sprintf("[tundoku-killer] %s", message))
+}
+
+func (m *alertMonitor) snapshot(now time.Time) map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	requests, errors := m.windowCounts(now)
+	streaks := make(map[string]int, len(m.cronStreaks))
+	for k, v := range m.cronStreaks {
+		streaks[k] = v
+	}
+	lineFailures := 0
+	for _, t := range m.lineFailures {
+		if t.After(now.Add(-alertLineWindow())) {
+			lineFailures++
+		}
+	}
+	return map[string]interface{}{
+		"window_requests": requests,
+		"window_errors":   errors,
+		"cron_streaks":    streaks,
+		"line_failures":   lineFailures,
+		"recent":          append([]Alert{}, m.recent...),
+	}
+}
+
+// sendOpsAlert は設定された送り先すべてに送る。失敗はログだけ残す。
+func sendOpsAlert(text string) {
+	if webhook := os.Getenv("ALERT_SLACK_WEBHOOK_URL"); webhook != "" {
+		body, _ := json.Marshal(map[string]string{"text": text})
+		resp, err := alertClient.Post(webhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("[WARNING] failed to send Slack alert: %v", err)
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				log.Printf("[WARNING] Slack alert rejected with %d", resp.StatusCode)
+			}
+		}
+	}
+	if lineUserID := os.Getenv("ALERT_LINE_USER_ID"); lineUserID != "" {
+		// LINE の障害そのものを知らせるときは届かないことがあるので、Slack と併用するのが望ましい
+		if err := sendLineMessage(lineUserID, text); err != nil {
+			log.Printf("[WARNING] failed to send LINE alert: %v", err)
+		}
+	}
+}
+
+// handleAlerts は GET /api/admin/alerts。現在の集計と閾値、最近送った通知を返す。
+func handleAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	state := opsAlerts.snapshot(clock.Now())
+	state["thresholds"] = map[string]interface{}{
+		"window_minutes":      int(alertWindow() / time.Minute),
+		"error_rate_percent":  envInt("ALERT_ERROR_RATE", 10),
+		"error_min_requests":  envInt("ALERT_ERROR_MIN_REQUESTS", 20),
+		"cron_failures":       envInt("ALERT_CRON_FAILURES", 3),
+		"line_failures":       envInt("ALERT_LINE_FAILURES", 20),
+		"line_window_minutes": int(alertLineWindow() / time.Minute),
+		"cooldown_minutes":    envInt("ALERT_COOLDOWN_MINUTES", 30),
+	}
+	state["channels"] = map[string]bool{
+		"slack": os.Getenv("ALERT_SLACK_WEBHOOK_URL") != "",
+		"line":  os.Getenv("ALERT_LINE_USER_ID") != "",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
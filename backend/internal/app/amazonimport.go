@@ -0,0 +1,421 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// Amazon の注文履歴 CSV (「データのリクエスト」の Retail.OrderHistory / Digital Items など) から本の購入を拾い、
+// そのまま登録せずに下書き (import_drafts) にする。ユーザーが1件ずつ確定すると本として登録する。
+
+const (
+	draftStatusPending   = "pending"
+	draftStatusConfirmed = "confirmed"
+	draftStatusDismissed = "dismissed"
+)
+
+// amazonColumns は列名の候補 (英語版・日本語版・Digital Items で名前が違う)
+var amazonColumns = map[string][]string{
+	"title":    {"Product Name", "ProductName", "Title", "商品名"},
+	"asin":     {"ASIN", "ASIN/ISBN", "ASIN/ISBN（製品コード）"},
+	"date":     {"Order Date", "OrderDate", "注文日"},
+	"price":    {"Unit Price", "OurPrice", "Item Total", "価格"},
+	"category": {"Product Category", "Category", "ContentType", "Product Type", "カテゴリー", "カテゴリ"},
+	"order":    {"Order ID", "OrderId", "注文番号"},
+}
+
+// amazonBookKeywords がカテゴリや商品名に含まれていれば本とみなす
+var amazonBookKeywords = []string{"book", "kindle", "本", "コミック", "漫画", "雑誌"}
+
+// ImportDraft は注文履歴から作った登録の下書き
+type ImportDraft struct {
+	DraftID     string     `json:"draft_id"`
+	UserID      string     `json:"user_id"`
+	Source      string     `json:"source"`
+	SourceKey   string     `json:"source_key"` // 注文番号と ASIN。同じ行を二度取り込まないためのキー
+	Title       string     `json:"title"`
+	ISBN        *string    `json:"isbn"`
+	Format      string     `json:"format"`
+	Price       *int       `json:"price"`
+	PurchasedAt *time.Time `json:"purchased_at"`
+	Status      string     `json:"status"` // pending, confirmed, dismissed
+	BookID      *string    `json:"book_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// AmazonImportReport は POST /api/import/amazon の結果
+type AmazonImportReport struct {
+	Total      int           `json:"total"`
+	Drafted    int           `json:"drafted"`
+	NotBooks   int           `json:"not_books"`
+	Duplicates int           `json:"duplicates"` // 蔵書にある本・取り込み済みの行
+	Issues     []ImportIssue `json:"issues"`
+	Drafts     []ImportDraft `json:"drafts"`
+}
+
+// parseAmazonOrderCSV は注文履歴 CSV を読み、本と判定した行を下書きにする。本でない行の数も返す。
+func parseAmazonOrderCSV(r io.Reader) (drafts []ImportDraft, notBooks int, issues []ImportIssue, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	cr := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("read header: %w", err)
+	}
+	col := make(map[string]int)
+	for i, h := range header {
+		h = strings.TrimSpace(h)
+		for key, names := range amazonColumns {
+			for _, name := range names {
+				if _, ok := col[key]; !ok && strings.EqualFold(h, name) {
+					col[key] = i
+				}
+			}
+		}
+	}
+	if _, ok := col["title"]; !ok {
+		return nil, 0, nil, errors.New("not an Amazon order history export (missing product name column)")
+	}
+	field := func(rec []string, key string) string {
+		if i, ok := col[key]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			issues = append(issues, ImportIssue{Line: line, Reason: "malformed row"})
+			continue
+		}
+		title := field(rec, "title")
+		if title == "" {
+			issues = append(issues, ImportIssue{Line: line, Reason: "missing product name"})
+			continue
+		}
+		asin := strings.ToUpper(field(rec, "asin"))
+		format, ok := amazonBookFormat(title, asin, field(rec, "category"))
+		if !ok {
+			notBooks++
+			continue
+		}
+		d := ImportDraft{Source: "amazon", Title: cleanAmazonTitle(title), Format: format, Status: draftStatusPending}
+		if isbn, ok := normalizeISBN(asin); ok && validISBN(isbn) {
+			d.ISBN = &isbn
+		}
+		if p, ok := parseAmazonPrice(field(rec, "price")); ok {
+			d.Price = &p
+		}
+		if t, ok := parseAmazonDate(field(rec, "date")); ok {
+			d.PurchasedAt = &t
+		}
+		key := asin
+		if key == "" {
+			key = normalizeSearchText(d.Title)
+		}
+		d.SourceKey = field(rec, "order") + "|" + key
+		drafts = append(drafts, d)
+	}
+	return drafts, notBooks, issues, nil
+}
+
+// amazonBookFormat は行が本かどうかと形式を判定する。
+// 紙の本は ASIN が ISBN-10 になっているので、それ以外はカテゴリと商品名で見分ける。
+func amazonBookFormat(title, asin, category string) (string, bool) {
+	lowerTitle, lowerCategory := strings.ToLower(title), strings.ToLower(category)
+	ebook := strings.Contains(lowerTitle, "kindle版") || strings.Contains(lowerTitle, "kindle edition") ||
+		strings.Contains(lowerCategory, "kindle") || strings.Contains(lowerCategory, "ebook") || strings.Contains(lowerCategory, "digital_ebook")
+	if isbn, ok := normalizeISBN(asin); ok && validISBN(isbn) {
+		return "paperback", true
+	}
+	if ebook {
+		return "ebook", true
+	}
+	for _, kw := range amazonBookKeywords {
+		if strings.Contains(lowerCategory, kw) {
+			return "paperback", true
+		}
+	}
+	return "", false
+}
+
+// cleanAmazonTitle は商品名の末尾の「Kindle版」を落とす
+func cleanAmazonTitle(title string) string {
+	for _, suffix := range []string{"Kindle版", "(Kindle Edition)", "Kindle Edition"} {
+		title = strings.TrimSpace(strings.TrimSuffix(title, suffix))
+	}
+	return title
+}
+
+func parseAmazonPrice(s string) (int, bool) {
+	s = strings.NewReplacer("￥", "", "¥", "", "$", "", ",", "", "円", "", "JPY", "").Replace(s)
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || f < 0 {
+		return 0, false
+	}
+	return int(math.Round(f)), true
+}
+
+func parseAmazonDate(s string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05.000Z", "2006/01/02", "2006-01-02", "01/02/2006"} {
+		if t, err := time.ParseInLocation(layout, s, jst); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// handleAmazonImport は POST /api/import/amazon (multipart の file と user_id)。本の行を下書きにして返す。
+func handleAmazonImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportUploadBytes)
+	userID := r.FormValue("user_id")
+	file, _, err := r.FormFile("file")
+	if err != nil || userID == "" {
+		http.Error(w, "user_id and file required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	drafts, notBooks, issues, err := parseAmazonOrderCSV(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(drafts) > maxImportBooks {
+		http.Error(w, fmt.Sprintf("too many books (max %d)", maxImportBooks), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	existing, _, err := loadUserBooks(userID)
+	if err != nil {
+		log.Printf("[ERROR] handleAmazonImport library lookup error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to import orders: %v", err), http.StatusInternalServerError)
+		return
+	}
+	owned := make(map[string]bool, len(existing))
+	for _, b := range existing {
+		owned[normalizeSearchText(b.Title)] = true
+	}
+	resp, _, err := execute(supabaseClient.From("import_drafts").Select("source_key", "", false).Eq("user_id", userID).Eq("source", "amazon"))
+	if err != nil {
+		log.Printf("[ERROR] handleAmazonImport draft lookup error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to import orders: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var known []ImportDraft
+	json.Unmarshal(resp, &known)
+	seen := make(map[string]bool, len(known))
+	for _, d := range known {
+		seen[d.SourceKey] = true
+	}
+
+	report := AmazonImportReport{Total: len(drafts) + notBooks + len(issues), NotBooks: notBooks, Issues: issues, Drafts: []ImportDraft{}}
+	rows := []map[string]interface{}{}
+	for _, d := range drafts {
+		if seen[d.SourceKey] || owned[normalizeSearchText(d.Title)] {
+			report.Duplicates++
+			continue
+		}
+		seen[d.SourceKey] = true
+		rows = append(rows, map[string]interface{}{
+			"user_id":      userID,
+			"source":       d.Source,
+			"source_key":   d.SourceKey,
+			"title":        d.Title,
+			"isbn":         d.ISBN,
+			"format":       d.Format,
+			"price":        d.Price,
+			"purchased_at": d.PurchasedAt,
+			"status":       draftStatusPending,
+		})
+	}
+	if len(rows) > 0 {
+		resp, _, err := executeOnce(supabaseClient.From("import_drafts").Insert(rows, false, "", "", ""))
+		if err != nil {
+			log.Printf("[ERROR] handleAmazonImport insert error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to import orders: %v", err), http.StatusInternalServerError)
+			return
+		}
+		json.Unmarshal(resp, &report.Drafts)
+		report.Drafted = len(report.Drafts)
+	}
+	log.Printf("[INFO] drafted %d books from Amazon orders for user %s (%d not books, %d duplicates)", report.Drafted, userID, report.NotBooks, report.Duplicates)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// handleImportDrafts は GET /api/import/drafts?userId=... (確定待ちの下書きを購入日の新しい順に)
+func handleImportDrafts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+	resp, _, err := execute(supabaseClient.From("import_drafts").
+		Select("*", "", false).
+		Eq("user_id", userId).
+		Eq("status", draftStatusPending).
+		Order("purchased_at", &postgrest.OrderOpts{Ascending: false, NullsFirst: false}))
+	if err != nil {
+		log.Printf("[ERROR] handleImportDrafts error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch drafts: %v", err), http.StatusInternalServerError)
+		return
+	}
+	drafts := []ImportDraft{}
+	json.Unmarshal(resp, &drafts)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"drafts": drafts})
+}
+
+func fetchPendingDraft(draftID, userID string) (*ImportDraft, error) {
+	resp, _, err := execute(supabaseClient.From("import_drafts").Select("*", "", false).Eq("draft_id", draftID).Eq("user_id", userID).Eq("status", draftStatusPending))
+	if err != nil {
+		return nil, err
+	}
+	var drafts []ImportDraft
+	if err := json.Unmarshal(resp, &drafts); err != nil || len(drafts) == 0 {
+		return nil, err
+	}
+	return &drafts[0], nil
+}
+
+// handleImportDraft は /api/import/drafts/{id}。
+// POST {user_id, title, author, deadline} で確定して本を登録し (省いた項目は書誌と既定の期限で埋める)、DELETE {user_id} で見送る。
+func handleImportDraft(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID   string     `json:"user_id"`
+		Title    string     `json:"title"`
+		Author   string     `json:"author"`
+		Deadline *time.Time `json:"deadline"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	draft, err := fetchPendingDraft(r.PathValue("id"), req.UserID)
+	if err != nil {
+		log.Printf("[ERROR] handleImportDraft lookup error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch draft: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if draft == nil {
+		http.Error(w, "Draft not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if _, _, err := execute(supabaseClient.From("import_drafts").Update(map[string]interface{}{"status": draftStatusDismissed}, "minimal", "").Eq("draft_id", draft.DraftID)); err != nil {
+			log.Printf("[ERROR] handleImportDraft dismiss error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to dismiss draft: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Draft dismissed"})
+		return
+	}
+
+	book := draftBook(r.Context(), *draft, req.Title, req.Author)
+	if book.Author == "" {
+		// 書誌が見つからなければ著者だけ入力してもらう
+		http.Error(w, "author required", http.StatusUnprocessableEntity)
+		return
+	}
+	book.Deadline = clock.Now().Add(defaultDeadlineOffset)
+	if req.Deadline != nil {
+		book.Deadline = *req.Deadline
+	}
+	rawResp, _, err := executeOnce(supabaseClient.From("books").Insert(map[string]interface{}{
+		"user_id":      req.UserID,
+		"title":        book.Title,
+		"author":       book.Author,
+		"deadline":     book.Deadline,
+		"status":       "unread",
+		"insult_level": 0,
+		"format":       book.Format,
+		"page_count":   book.PageCount,
+		"price":        book.Price,
+	}, false, "", "", ""))
+	if err != nil {
+		log.Printf("[ERROR] handleImportDraft insert error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to register book: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var books []Book
+	json.Unmarshal(rawResp, &books)
+	if len(books) > 0 {
+		if _, _, err := execute(supabaseClient.From("import_drafts").
+			Update(map[string]interface{}{"status": draftStatusConfirmed, "book_id": books[0].BookID}, "minimal", "").
+			Eq("draft_id", draft.DraftID)); err != nil {
+			log.Printf("[ERROR] handleImportDraft confirm error: %v", err)
+		}
+	}
+	emitBookRows(eventBookCreated, rawResp)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Book registered", "books": books})
+}
+
+// draftBook は下書きから登録する本を組み立てる。著者や分量は ISBN (なければ書名) で書誌を引いて埋める。
+func draftBook(ctx context.Context, d ImportDraft, title, author string) Book {
+	book := Book{Title: d.Title, Author: author, Format: d.Format, Price: d.Price}
+	if title != "" {
+		book.Title = title
+	}
+	if book.Author != "" && d.ISBN == nil {
+		return book
+	}
+	query := book.Title
+	if d.ISBN != nil {
+		query = *d.ISBN
+	}
+	suggestions, err := externalSuggestions(ctx, query, 1)
+	if err != nil {
+		log.Printf("[WARNING] draft metadata lookup failed for %q: %v", query, err)
+	}
+	if len(suggestions) > 0 {
+		s := suggestions[0]
+		if book.Author == "" {
+			book.Author = s.Author
+		}
+		if title == "" && d.ISBN != nil && s.Title != "" {
+			book.Title = s.Title
+		}
+		book.PageCount = s.PageCount
+	}
+	return book
+}
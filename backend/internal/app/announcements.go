@@ -0,0 +1,357 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// お知らせバナー。メンテナンスや新機能を、LINE を送らずに画面の上部で知らせる。
+// フロントエンドは GET /api/announcements を定期的に読むので、表示中のお知らせは appCache に短く置く。
+const (
+	announcementInfo     = "info"
+	announcementWarning  = "warning"
+	announcementCritical = "critical"
+
+	defaultAnnouncementLocale = "ja"
+	maxAnnouncementLength     = 500
+	adminAnnouncementLimit    = 100
+	announcementsCacheKey     = "announcements:active"
+	announcementsCacheTTL     = time.Minute
+)
+
+// 重い順。一覧はこの順に並べる
+var announcementSeverities = []string{announcementCritical, announcementWarning, announcementInfo}
+
+var localePattern = regexp.MustCompile(`^[a-z]{2}(-[A-Z]{2})?$`)
+
+// Announcement は announcements の1行。Messages はロケール ("ja", "en", "en-US") ごとの本文。
+type Announcement struct {
+	AnnouncementID string            `json:"announcement_id"`
+	Severity       string            `json:"severity"` // info, warning, critical
+	Messages       map[string]string `json:"messages"`
+	StartsAt       time.Time         `json:"starts_at"`
+	EndsAt         time.Time         `json:"ends_at"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+}
+
+func (a Announcement) activeAt(now time.Time) bool {
+	return !now.Before(a.StartsAt) && now.Before(a.EndsAt)
+}
+
+// normalizeLocale は "EN_us" を "en-US" のように揃える。形が合わなければ空文字。
+func normalizeLocale(s string) string {
+	s = strings.ReplaceAll(strings.TrimSpace(s), "_", "-")
+	lang, region, _ := strings.Cut(s, "-")
+	s = strings.ToLower(lang)
+	if region != "" {
+		s += "-" + strings.ToUpper(region)
+	}
+	if !localePattern.MatchString(s) {
+		return ""
+	}
+	return s
+}
+
+// requestLocales は ?locale= と Accept-Language から希望するロケールを優先順に返す (q 値の順序は送られた順とみなす)
+func requestLocales(r *http.Request) []string {
+	var locales []string
+	if l := normalizeLocale(r.URL.Query().Get("locale")); l != "" {
+		locales = append(locales, l)
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, _, _ := strings.Cut(part, ";")
+		if l := normalizeLocale(tag); l != "" {
+			locales = append(locales, l)
+		}
+	}
+	return locales
+}
+
+// localized は希望に一番近い本文を選ぶ。完全一致 → 言語だけの一致 → 既定 (ja) → 残りのうちロケール順で最初のもの。
+func (a Announcement) localized(locales []string) (locale, text string) {
+	for _, l := range locales {
+		if t, ok := a.Messages[l]; ok {
+			return l, t
+		}
+		lang, _, _ := strings.Cut(l, "-")
+		if t, ok := a.Messages[lang]; ok {
+			return lang, t
+		}
+	}
+	if t, ok := a.Messages[defaultAnnouncementLocale]; ok {
+		return defaultAnnouncementLocale, t
+	}
+	keys := make([]string, 0, len(a.Messages))
+	for k := range a.Messages {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	if len(keys) == 0 {
+		return "", ""
+	}
+	return keys[0], a.Messages[keys[0]]
+}
+
+// validateAnnouncementMessages はロケールを揃え、空の本文を除く
+func validateAnnouncementMessages(in map[string]string) (map[string]string, error) {
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		l := normalizeLocale(k)
+		if l == "" {
+			return nil, fmt.Errorf("invalid locale %q", k)
+		}
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if len([]rune(v)) > maxAnnouncementLength {
+			return nil, fmt.Errorf("message for %s is too long (max %d characters)", l, maxAnnouncementLength)
+		}
+		out[l] = v
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("messages must have at least one locale")
+	}
+	return out, nil
+}
+
+// currentAnnouncements はまだ終わっていないお知らせ (開始前を含む) を返す。開始の判定は呼び出し側で行う。
+func currentAnnouncements() ([]Announcement, error) {
+	if cached, ok := appCache.Get(announcementsCacheKey); ok {
+		var rows []Announcement
+		if err := json.Unmarshal(cached, &rows); err == nil {
+			return rows, nil
+		}
+	}
+	resp, _, err := execute(supabaseClient.From("announcements").Select("*", "", false).
+		Gt("ends_at", clock.Now().Format(time.RFC3339)).
+		Order("starts_at", &postgrest.OrderOpts{Ascending: true}))
+	if err != nil {
+		return nil, err
+	}
+	var rows []Announcement
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		return nil, err
+	}
+	appCache.Set(announcementsCacheKey, resp, announcementsCacheTTL)
+	return rows, nil
+}
+
+// announcementsModifiedAt は now 時点の一覧が最後に変わった時刻。編集のほか、掲載の始まりと終わりでも変わる。
+func announcementsModifiedAt(rows []Announcement, now time.Time) time.Time {
+	var modified time.Time
+	for _, a := range rows {
+		for _, t := range []time.Time{a.UpdatedAt, a.StartsAt, a.EndsAt} {
+			if !t.After(now) && t.After(modified) {
+				modified = t
+			}
+		}
+	}
+	return modified
+}
+
+// handleAnnouncements は GET /api/announcements?locale=...。表示中のお知らせを重い順に、希望のロケールの本文で返す。
+// 削除は Last-Modified に表れないので、表示中の件数を含めた ETag も付ける。
+func handleAnnouncements(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rows, err := currentAnnouncements()
+	if err != nil {
+		log.Printf("[ERROR] handleAnnouncements query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch announcements: %v", err), http.StatusInternalServerError)
+		return
+	}
+	now := clock.Now()
+	modified := announcementsModifiedAt(rows, now)
+	rows = slices.DeleteFunc(rows, func(a Announcement) bool { return !a.activeAt(now) })
+	slices.SortStableFunc(rows, func(a, b Announcement) int {
+		return slices.Index(announcementSeverities, a.Severity) - slices.Index(announcementSeverities, b.Severity)
+	})
+
+	locales := requestLocales(r)
+	type announcementView struct {
+		AnnouncementID string    `json:"announcement_id"`
+		Severity       string    `json:"severity"`
+		Locale         string    `json:"locale"`
+		Text           string    `json:"text"`
+		StartsAt       time.Time `json:"starts_at"`
+		EndsAt         time.Time `json:"ends_at"`
+	}
+	views := make([]announcementView, 0, len(rows))
+	for _, a := range rows {
+		locale, text := a.localized(locales)
+		views = append(views, announcementView{a.AnnouncementID, a.Severity, locale, text, a.StartsAt, a.EndsAt})
+	}
+	w.Header().Set("Vary", "Accept-Language")
+	etag := fmt.Sprintf(`W/"%d-%x"`, len(rows), modified.UnixNano())
+	if notModified(w, r, etag, modified, fmt.Sprintf("public, max-age=%d", int(announcementsCacheTTL.Seconds()))) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(views)
+}
+
+// announcementRequest は作成・更新の本文。更新では送られてきた項目だけ変える。
+type announcementRequest struct {
+	Severity *string           `json:"severity"`
+	Messages map[string]string `json:"messages"`
+	StartsAt *time.Time        `json:"starts_at"`
+	EndsAt   *time.Time        `json:"ends_at"`
+}
+
+// apply は current (作成なら既定値) に req を重ねて検証し、保存する列を返す
+func (req announcementRequest) apply(current Announcement) (map[string]interface{}, error) {
+	if req.Severity != nil {
+		current.Severity = *req.Severity
+	}
+	if !slices.Contains(announcementSeverities, current.Severity) {
+		return nil, fmt.Errorf("severity must be info, warning or critical")
+	}
+	if req.Messages != nil {
+		current.Messages = req.Messages
+	}
+	messages, err := validateAnnouncementMessages(current.Messages)
+	if err != nil {
+		return nil, err
+	}
+	if req.StartsAt != nil {
+		current.StartsAt = *req.StartsAt
+	}
+	if req.EndsAt != nil {
+		current.EndsAt = *req.EndsAt
+	}
+	if current.EndsAt.IsZero() {
+		return nil, fmt.Errorf("ends_at required")
+	}
+	if !current.EndsAt.After(current.StartsAt) {
+		return nil, fmt.Errorf("ends_at must be after starts_at")
+	}
+	return map[string]interface{}{
+		"severity":   current.Severity,
+		"messages":   messages,
+		"starts_at":  current.StartsAt,
+		"ends_at":    current.EndsAt,
+		"updated_at": clock.Now(),
+	}, nil
+}
+
+// handleAdminAnnouncements は /api/admin/announcements。
+// GET で最近のお知らせを開始の新しい順に返し、POST {severity, messages, starts_at, ends_at} で作る (starts_at を省けば今から)。
+func handleAdminAnnouncements(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		resp, _, err := execute(supabaseClient.From("announcements").Select("*", "", false).
+			Order("starts_at", &postgrest.OrderOpts{Ascending: false}).
+			Limit(adminAnnouncementLimit, ""))
+		if err != nil {
+			log.Printf("[ERROR] handleAdminAnnouncements list error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch announcements: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+
+	case http.MethodPost:
+		var req announcementRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		row, err := req.apply(Announcement{Severity: announcementInfo, StartsAt: clock.Now()})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rawResp, _, err := executeOnce(supabaseClient.From("announcements").Insert(row, false, "", "", ""))
+		var rows []Announcement
+		if err == nil {
+			err = json.Unmarshal(rawResp, &rows)
+		}
+		if err != nil || len(rows) == 0 {
+			log.Printf("[ERROR] handleAdminAnnouncements insert error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to create announcement: %v", err), http.StatusInternalServerError)
+			return
+		}
+		appCache.Delete(announcementsCacheKey)
+		log.Printf("[INFO] announcement %s (%s) scheduled %s - %s", rows[0].AnnouncementID, rows[0].Severity, rows[0].StartsAt.Format(time.RFC3339), rows[0].EndsAt.Format(time.RFC3339))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rows[0])
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminAnnouncement は /api/admin/announcements/{id}。PUT で送られてきた項目を変え、DELETE で消す。
+// 表示を早めに終えたいときは ends_at を今にする。
+func handleAdminAnnouncement(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	switch r.Method {
+	case http.MethodPut:
+		var req announcementRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		resp, _, err := execute(supabaseClient.From("announcements").Select("*", "", false).Eq("announcement_id", id))
+		if err != nil {
+			log.Printf("[ERROR] handleAdminAnnouncement query error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch announcement: %v", err), http.StatusInternalServerError)
+			return
+		}
+		var rows []Announcement
+		if json.Unmarshal(resp, &rows); len(rows) == 0 {
+			http.Error(w, "Announcement not found", http.StatusNotFound)
+			return
+		}
+		row, err := req.apply(rows[0])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rawResp, _, err := execute(supabaseClient.From("announcements").Update(row, "", "").Eq("announcement_id", id))
+		if err != nil {
+			log.Printf("[ERROR] handleAdminAnnouncement update error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to update announcement: %v", err), http.StatusInternalServerError)
+			return
+		}
+		appCache.Delete(announcementsCacheKey)
+		var updated []Announcement
+		if json.Unmarshal(rawResp, &updated); len(updated) == 0 {
+			http.Error(w, "Announcement not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(updated[0])
+
+	case http.MethodDelete:
+		rawResp, _, err := execute(supabaseClient.From("announcements").Delete("", "").Eq("announcement_id", id))
+		if err != nil {
+			log.Printf("[ERROR] handleAdminAnnouncement delete error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to delete announcement: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if string(rawResp) == "[]" {
+			http.Error(w, "Announcement not found", http.StatusNotFound)
+			return
+		}
+		appCache.Delete(announcementsCacheKey)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Announcement deleted"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,263 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+const (
+	apiUsageFlushInterval = time.Minute
+	apiUsageTopLimit      = 100
+)
+
+// apiQuota は外部 API を呼ぶ重いエンドポイントの1日あたりの上限 (ユーザーごと、JST の日付で区切る)
+type apiQuota struct {
+	Endpoint string // r.Pattern
+	Env      string
+	Default  int
+}
+
+var (
+	apiQuotaInsultPreview = apiQuota{"/api/insults/preview", "API_QUOTA_INSULT_PREVIEW", 100}
+	apiQuotaMetadata      = apiQuota{"/api/books/autocomplete", "API_QUOTA_METADATA", 300}
+	apiQuotaImageUpload   = apiQuota{"/api/books/scan", "API_QUOTA_IMAGE_UPLOAD", 30}
+	apiQuotas             = []apiQuota{apiQuotaInsultPreview, apiQuotaMetadata, apiQuotaImageUpload}
+)
+
+func (q apiQuota) limit() int { return envInt(q.Env, q.Default) }
+
+type apiUsageKey struct {
+	UserID   string
+	Day      string
+	Endpoint string
+}
+
+type apiUsageCount struct {
+	Calls    int `json:"calls"`
+	Rejected int `json:"rejected"`
+}
+
+// apiUsageTracker はメモリ上で数えて apiUsageFlushInterval ごとに api_usage へ足し込む。
+// 上限の判定は DB の値 + 未反映分で行うので、複数インスタンスでは最大1回分の間隔だけ甘くなる。
+type apiUsageTracker struct {
+	mu      sync.Mutex
+	pending map[apiUsageKey]apiUsageCount
+	flushed map[apiUsageKey]apiUsageCount // 最後に読んだ DB の値 (quota 対象のみ)
+}
+
+var apiUsage = &apiUsageTracker{pending: make(map[apiUsageKey]apiUsageCount), flushed: make(map[apiUsageKey]apiUsageCount)}
+
+func usageDay(t time.Time) string { return t.In(jst).Format("2006-01-02") }
+
+// recordAPIUsage は quota 対象外のエンドポイントの呼び出しを数える (corsMiddleware から呼ぶ)
+func recordAPIUsage(r *http.Request) {
+	userID := r.URL.Query().Get("userId")
+	if userID == "" || r.Pattern == "" {
+		return
+	}
+	for _, q := range apiQuotas {
+		if q.Endpoint == r.Pattern {
+			return // consumeAPIQuota 側で数える
+		}
+	}
+	apiUsage.add(apiUsageKey{userID, usageDay(time.Now()), r.Pattern}, apiUsageCount{Calls: 1})
+}
+
+func (t *apiUsageTracker) add(key apiUsageKey, delta apiUsageCount) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.pending[key]
+	c.Calls += delta.Calls
+	c.Rejected += delta.Rejected
+	t.pending[key] = c
+}
+
+// consumeAPIQuota は1回分を数え、上限を超えていれば 429 を書いて false を返す
+func consumeAPIQuota(w http.ResponseWriter, userID string, q apiQuota) bool {
+	if userID == "" {
+		return true // userId 必須のチェックは各ハンドラーに任せる
+	}
+	key := apiUsageKey{userID, usageDay(time.Now()), q.Endpoint}
+	if err := apiUsage.load(key); err != nil {
+		log.Printf("[ERROR] failed to load API usage for %s: %v", userID, err)
+	}
+	limit := q.limit()
+	apiUsage.mu.Lock()
+	used := apiUsage.flushed[key].Calls + apiUsage.pending[key].Calls
+	allowed := used < limit
+	apiUsage.mu.Unlock()
+	if !allowed {
+		apiUsage.add(key, apiUsageCount{Rejected: 1})
+		log.Printf("[WARNING] API quota exceeded: user %s, %s (%d/%d)", userID, q.Endpoint, used, limit)
+		w.Header().Set("Retry-After", strconv.Itoa(secondsUntilNextDay(time.Now())))
+		http.Error(w, fmt.Sprintf("daily limit of %d requests reached for this feature", limit), http.StatusTooManyRequests)
+		return false
+	}
+	apiUsage.add(key, apiUsageCount{Calls: 1})
+	return true
+}
+
+func secondsUntilNextDay(now time.Time) int {
+	t := now.In(jst)
+	next := time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, jst)
+	return int(next.Sub(now).Seconds()) + 1
+}
+
+// load はその日まだ読んでいなければ DB の値を読む
+func (t *apiUsageTracker) load(key apiUsageKey) error {
+	t.mu.Lock()
+	_, ok := t.flushed[key]
+	t.mu.Unlock()
+	if ok {
+		return nil
+	}
+	current, err := fetchAPIUsage(key)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.flushed[key] = current
+	t.mu.Unlock()
+	return nil
+}
+
+func fetchAPIUsage(key apiUsageKey) (apiUsageCount, error) {
+	resp, _, err := execute(supabaseClient.From("api_usage").
+		Select("calls, rejected", "", false).
+		Eq("user_id", key.UserID).
+		Eq("day", key.Day).
+		Eq("endpoint", key.Endpoint))
+	if err != nil {
+		return apiUsageCount{}, err
+	}
+	var rows []apiUsageCount
+	json.Unmarshal(resp, &rows)
+	if len(rows) == 0 {
+		return apiUsageCount{}, nil
+	}
+	return rows[0], nil
+}
+
+// flush は未反映分を DB の値に足して書き戻す。失敗した分は次回に持ち越す。
+func (t *apiUsageTracker) flush() {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = make(map[apiUsageKey]apiUsageCount)
+	today := usageDay(time.Now())
+	for key := range t.flushed {
+		if key.Day != today {
+			delete(t.flushed, key)
+		}
+	}
+	t.mu.Unlock()
+
+	for key, delta := range pending {
+		current, err := fetchAPIUsage(key)
+		if err == nil {
+			current.Calls += delta.Calls
+			current.Rejected += delta.Rejected
+			_, _, err = execute(supabaseClient.From("api_usage").Insert(map[string]interface{}{
+				"user_id":    key.UserID,
+				"day":        key.Day,
+				"endpoint":   key.Endpoint,
+				"calls":      current.Calls,
+				"rejected":   current.Rejected,
+				"updated_at": time.Now(),
+			}, true, "user_id,day,endpoint", "minimal", ""))
+		}
+		if err != nil {
+			// 存在しない userId などは持ち越しても直らないので、当日分だけ持ち越す
+			log.Printf("[ERROR] failed to flush API usage for %s %s: %v", key.UserID, key.Endpoint, err)
+			if key.Day == today {
+				t.add(key, delta)
+			}
+			continue
+		}
+		t.mu.Lock()
+		if _, ok := t.flushed[key]; ok {
+			t.flushed[key] = current
+		}
+		t.mu.Unlock()
+	}
+}
+
+// startAPIUsageFlusher は呼び出し数を定期的に api_usage へ書き出す
+func startAPIUsageFlusher() {
+	go func() {
+		ticker := time.NewTicker(apiUsageFlushInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			apiUsage.flush()
+		}
+	}()
+}
+
+// handleAPIUsage は GET /api/admin/usage?day=YYYY-MM-DD&userId=...。
+// 呼び出しの多い順 (userId 指定時はそのユーザーの全エンドポイント) と、上限に当たったユーザーを返す。
+func handleAPIUsage(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	day := r.URL.Query().Get("day")
+	if day == "" {
+		day = usageDay(time.Now())
+	} else if _, err := time.Parse("2006-01-02", day); err != nil {
+		http.Error(w, "day must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	apiUsage.flush()
+
+	type usageRow struct {
+		UserID   string `json:"user_id"`
+		Endpoint string `json:"endpoint"`
+		Calls    int    `json:"calls"`
+		Rejected int    `json:"rejected"`
+	}
+	query := func(filter func(*postgrest.FilterBuilder) *postgrest.FilterBuilder, order string) ([]usageRow, error) {
+		q := supabaseClient.From("api_usage").Select("user_id, endpoint, calls, rejected", "", false).Eq("day", day)
+		q = filter(q)
+		resp, _, err := execute(q.Order(order, &postgrest.OrderOpts{Ascending: false}).Limit(apiUsageTopLimit, ""))
+		if err != nil {
+			return nil, err
+		}
+		rows := []usageRow{}
+		json.Unmarshal(resp, &rows)
+		return rows, nil
+	}
+	userID := r.URL.Query().Get("userId")
+	top, err := query(func(q *postgrest.FilterBuilder) *postgrest.FilterBuilder {
+		if userID != "" {
+			return q.Eq("user_id", userID)
+		}
+		return q
+	}, "calls")
+	if err != nil {
+		log.Printf("[ERROR] handleAPIUsage query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch usage: %v", err), http.StatusInternalServerError)
+		return
+	}
+	limited, err := query(func(q *postgrest.FilterBuilder) *postgrest.FilterBuilder { return q.Gt("rejected", "0") }, "rejected")
+	if err != nil {
+		log.Printf("[ERROR] handleAPIUsage query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch usage: %v", err), http.StatusInternalServerError)
+		return
+	}
+	quotas := make([]map[string]interface{}, 0, len(apiQuotas))
+	for _, q := range apiQuotas {
+		quotas = append(quotas, map[string]interface{}{"endpoint": q.Endpoint, "env": q.Env, "limit": q.limit()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"day": day, "quotas": quotas, "top": top, "rate_limited": limited})
+}
@@ -0,0 +1,116 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// アーカイブした本は記録として残すが、一覧・統計の冊数・督促の対象からは外す。
+// ステータスとは独立したフラグなので、読了済みでも積読中でもしまっておける。
+
+// excludeArchived はアーカイブしていない本だけを返す
+func excludeArchived(books []Book) []Book {
+	filtered := make([]Book, 0, len(books))
+	for _, b := range books {
+		if !b.Archived {
+			filtered = append(filtered, b)
+		}
+	}
+	return filtered
+}
+
+func archivedAt(b Book) time.Time {
+	if b.ArchivedAt != nil {
+		return *b.ArchivedAt
+	}
+	return b.UpdatedAt
+}
+
+// handleArchive は /api/books/{id}/archive。POST でアーカイブし、DELETE で一覧に戻す。
+func handleArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	book, err := fetchOwnedBook(r.PathValue("id"), req.UserID)
+	if err != nil {
+		writeBookLookupError(w, err)
+		return
+	}
+	archive := r.Method == http.MethodPost
+	if book.Archived == archive {
+		if archive {
+			http.Error(w, "Book is already archived", http.StatusConflict)
+		} else {
+			http.Error(w, "Book is not archived", http.StatusConflict)
+		}
+		return
+	}
+
+	now := clock.Now()
+	update := map[string]interface{}{"archived": archive, "archived_at": nil, "updated_at": now}
+	if archive {
+		update["archived_at"] = now
+	}
+	rawResp, _, err := execute(supabaseClient.From("books").Update(update, "", "").Eq("book_id", book.BookID).Eq("user_id", book.UserID))
+	if err != nil {
+		log.Printf("[ERROR] handleArchive update error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to update book: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if archive {
+		// 送信待ちの督促も止める
+		execute(supabaseClient.From("notification_jobs").
+			Update(map[string]interface{}{"status": "skipped", "last_error": "archived"}, "minimal", "").
+			Eq("book_id", book.BookID).
+			Eq("status", "pending"))
+	}
+	emitBookRows("book.updated", rawResp)
+
+	message := "Book unarchived"
+	if archive {
+		message = "Book archived"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+// handleArchivedBooks は GET /api/books/archived?userId=...。アーカイブした本をアーカイブした順に返す。
+func handleArchivedBooks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+	books, _, err := loadUserBooks(userId)
+	if err != nil {
+		log.Printf("[ERROR] handleArchivedBooks error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	archived := []Book{}
+	for _, b := range books {
+		if b.Archived {
+			archived = append(archived, b)
+		}
+	}
+	slices.SortStableFunc(archived, func(a, b Book) int { return archivedAt(b).Compare(archivedAt(a)) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(archived)
+}
@@ -0,0 +1,239 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// 本の添付ファイル (領収書の PDF、シラバスのページなど)。実体は Storage の attachments/{user}/{book}/ に置き、
+// 一覧のたびに署名付き URL を発行し直す。本を削除すると行は cascade で消え、ファイルも handleDeleteBook で消す。
+const (
+	maxAttachmentBytes    = 5 << 20
+	maxAttachmentUpload   = maxAttachmentBytes + 1<<20 // multipart の境界やヘッダーの分を足した本文の上限
+	maxAttachmentsPerBook = 20
+	maxAttachmentNameLen  = 200
+	attachmentURLTTL      = 15 * time.Minute
+)
+
+// 中身から判定した Content-Type がこのどれかでなければ受け付けない
+var attachmentContentTypes = []string{"application/pdf", "image/jpeg", "image/png", "image/webp", "image/gif", "text/plain; charset=utf-8"}
+
+// Attachment は book_attachments の1行
+type Attachment struct {
+	AttachmentID string    `json:"attachment_id"`
+	BookID       string    `json:"book_id"`
+	UserID       string    `json:"user_id"`
+	FileName     string    `json:"file_name"`
+	ContentType  string    `json:"content_type"`
+	SizeBytes    int       `json:"size_bytes"`
+	StoragePath  string    `json:"storage_path,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	URL          string    `json:"url,omitempty"`
+}
+
+func attachmentPath(userID, bookID, fileName string) (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	ext := strings.ToLower(path.Ext(fileName))
+	return fmt.Sprintf("attachments/%s/%s/%s%s", userID, bookID, hex.EncodeToString(buf), ext), nil
+}
+
+// attachmentFileName は表示用のファイル名。パスの部分と制御文字を落とす。
+func attachmentFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" || name == "." || name == "/" {
+		return "attachment"
+	}
+	if r := []rune(name); len(r) > maxAttachmentNameLen {
+		name = string(r[:maxAttachmentNameLen])
+	}
+	return name
+}
+
+// bookAttachmentPaths は本に添付されたファイルの Storage 上のパス
+func bookAttachmentPaths(bookID string) ([]string, error) {
+	resp, _, err := execute(supabaseClient.From("book_attachments").Select("storage_path", "", false).Eq("book_id", bookID))
+	if err != nil {
+		return nil, err
+	}
+	var rows []Attachment
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(rows))
+	for _, a := range rows {
+		paths = append(paths, a.StoragePath)
+	}
+	return paths, nil
+}
+
+// handleAttachments は /api/books/{id}/attachments。GET ?userId で一覧 (署名付き URL 付き)、
+// POST (multipart の user_id と file) で添付する。
+func handleAttachments(w http.ResponseWriter, r *http.Request) {
+	bookID := r.PathValue("id")
+	switch r.Method {
+	case http.MethodGet:
+		if _, err := fetchOwnedBook(bookID, r.URL.Query().Get("userId")); err != nil {
+			writeBookLookupError(w, err)
+			return
+		}
+		resp, _, err := execute(supabaseClient.From("book_attachments").
+			Select("*", "", false).
+			Eq("book_id", bookID).
+			Order("created_at", &postgrest.OrderOpts{Ascending: true}))
+		if err != nil {
+			log.Printf("[ERROR] handleAttachments list error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch attachments: %v", err), http.StatusInternalServerError)
+			return
+		}
+		attachments := []Attachment{}
+		if err := json.Unmarshal(resp, &attachments); err != nil {
+			http.Error(w, fmt.Sprintf("failed to parse attachments: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for i := range attachments {
+			u, err := signedStorageURL(attachments[i].StoragePath, attachmentURLTTL)
+			if err != nil {
+				log.Printf("[WARNING] failed to sign attachment %s: %v", attachments[i].AttachmentID, err)
+			} else {
+				attachments[i].URL = u + "&download=" + url.QueryEscape(attachments[i].FileName)
+			}
+			attachments[i].StoragePath = ""
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(attachments)
+
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentUpload)
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("file required (max %d MB)", maxAttachmentBytes>>20), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		book, err := fetchOwnedBook(bookID, r.FormValue("user_id"))
+		if err != nil {
+			writeBookLookupError(w, err)
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(file, maxAttachmentBytes+1))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to read file: %v", err), http.StatusBadRequest)
+			return
+		}
+		if len(data) == 0 {
+			http.Error(w, "file is empty", http.StatusBadRequest)
+			return
+		}
+		if len(data) > maxAttachmentBytes {
+			http.Error(w, fmt.Sprintf("file too large (max %d MB)", maxAttachmentBytes>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
+		contentType := http.DetectContentType(data)
+		if !slices.Contains(attachmentContentTypes, contentType) {
+			http.Error(w, fmt.Sprintf("unsupported file type %s (PDF, images or plain text only)", contentType), http.StatusUnsupportedMediaType)
+			return
+		}
+		_, n, err := execute(supabaseClient.From("book_attachments").Select("attachment_id", "exact", true).Eq("book_id", bookID))
+		if err != nil {
+			log.Printf("[ERROR] handleAttachments count error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to count attachments: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if n >= maxAttachmentsPerBook {
+			http.Error(w, fmt.Sprintf("too many attachments (max %d per book)", maxAttachmentsPerBook), http.StatusConflict)
+			return
+		}
+
+		name := attachmentFileName(header.Filename)
+		storagePath, err := attachmentPath(book.UserID, bookID, name)
+		if err != nil {
+			http.Error(w, "failed to generate path", http.StatusInternalServerError)
+			return
+		}
+		if err := uploadToStorage(storagePath, contentType, data); err != nil {
+			log.Printf("[ERROR] handleAttachments upload error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to store file: %v", err), http.StatusBadGateway)
+			return
+		}
+		rawResp, _, err := executeOnce(supabaseClient.From("book_attachments").Insert(map[string]interface{}{
+			"book_id":      bookID,
+			"user_id":      book.UserID,
+			"file_name":    name,
+			"content_type": contentType,
+			"size_bytes":   len(data),
+			"storage_path": storagePath,
+		}, false, "", "", ""))
+		if err != nil {
+			log.Printf("[ERROR] handleAttachments insert error: %v", err)
+			removeFromStorage(storagePath)
+			http.Error(w, fmt.Sprintf("failed to save attachment: %v", err), http.StatusInternalServerError)
+			return
+		}
+		var rows []Attachment
+		if err := json.Unmarshal(rawResp, &rows); err != nil || len(rows) == 0 {
+			http.Error(w, "failed to parse attachment", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[INFO] attached %s (%d bytes) to book %s", name, len(data), bookID)
+		rows[0].StoragePath = ""
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rows[0])
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAttachment は DELETE /api/books/{id}/attachments/{attachmentId}?userId
+func handleAttachment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+	rawResp, _, err := execute(supabaseClient.From("book_attachments").Delete("", "").
+		Eq("attachment_id", r.PathValue("attachmentId")).
+		Eq("book_id", r.PathValue("id")).
+		Eq("user_id", userId))
+	if err != nil {
+		log.Printf("[ERROR] handleAttachment delete error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to delete attachment: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var rows []Attachment
+	if json.Unmarshal(rawResp, &rows); len(rows) == 0 {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	if err := removeFromStorage(rows[0].StoragePath); err != nil {
+		log.Printf("[WARNING] failed to remove attachment file %s: %v", rows[0].StoragePath, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Attachment deleted successfully"})
+}
@@ -0,0 +1,388 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+const (
+	lineVerifyURL = "https://api.line.me/oauth2/v2.1/verify"
+	sessionTTL    = 30 * 24 * time.Hour
+)
+
+var errInvalidIDToken = errors.New("invalid ID token")
+
+// LineIDTokenClaims は LINE が検証した ID トークンの中身
+type LineIDTokenClaims struct {
+	Issuer   string `json:"iss"`
+	Subject  string `json:"sub"` // LINE のユーザーID
+	Audience string `json:"aud"` // LINE ログインのチャネルID
+	Expires  int64  `json:"exp"`
+	Name     string `json:"name"`
+}
+
+// verifyLineIDToken は LIFF の ID トークンを LINE の検証 API に渡して署名・audience・有効期限を確かめる。
+// 念のため返ってきたクレームの aud と exp も自前で確認する。
+func verifyLineIDToken(idToken string) (*LineIDTokenClaims, error) {
+	channelID := os.Getenv("LIFF_CHANNEL_ID")
+	if channelID == "" {
+		return nil, fmt.Errorf("LIFF_CHANNEL_ID is not set")
+	}
+	form := url.Values{"id_token": {idToken}, "client_id": {channelID}}
+	resp, err := lineAPIClient.PostForm(lineVerifyURL, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusBadRequest {
+		var body struct {
+			ErrorDescription string `json:"error_description"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return nil, fmt.Errorf("%w: %s", errInvalidIDToken, body.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("LINE verify API returned %d", resp.StatusCode)
+	}
+	var claims LineIDTokenClaims
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, err
+	}
+	if claims.Audience != channelID || claims.Subject == "" {
+		return nil, fmt.Errorf("%w: unexpected audience or subject", errInvalidIDToken)
+	}
+	if time.Unix(claims.Expires, 0).Before(time.Now()) {
+		return nil, fmt.Errorf("%w: expired", errInvalidIDToken)
+	}
+	return &claims, nil
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createSession はセッションを発行する。DB にはトークンのハッシュだけを保存する。
+func createSession(userID string, r *http.Request) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(buf)
+	expiresAt := time.Now().Add(sessionTTL)
+	_, _, err := executeOnce(supabaseClient.From("user_sessions").Insert(map[string]interface{}{
+		"user_id":    userID,
+		"token_hash": hashSessionToken(token),
+		"user_agent": snippet(r.UserAgent(), 200),
+		"expires_at": expiresAt,
+	}, false, "", "minimal", ""))
+	return token, expiresAt, err
+}
+
+// handleLiffAuth は POST /api/auth/liff。LIFF の ID トークンを検証し、バックエンドのセッショントークンと交換する。
+func handleLiffAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		IDToken string `json:"idToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.IDToken) == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := verifyLineIDToken(req.IDToken)
+	if err != nil {
+		if errors.Is(err, errInvalidIDToken) {
+			http.Error(w, "Invalid ID token", http.StatusUnauthorized)
+			return
+		}
+		log.Printf("[ERROR] handleLiffAuth verify error: %v", err)
+		http.Error(w, "failed to verify ID token", http.StatusBadGateway)
+		return
+	}
+
+	// すでにセッションがあるなら、新しい行を作らずにそのユーザーへ付け直す
+	var internalID string
+	if current, serr := authenticateSession(r); serr == nil {
+		if err := relinkLineAccount(current.UserID, claims.Subject); err != nil {
+			if errors.Is(err, errRelinkConflict) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			log.Printf("[ERROR] handleLiffAuth relink error: %v", err)
+			http.Error(w, "failed to relink account", http.StatusInternalServerError)
+			return
+		}
+		internalID = current.UserID
+	} else {
+		internalID, err = userIDForLine(claims.Subject)
+	}
+	if err != nil || internalID == "" {
+		log.Printf("[ERROR] handleLiffAuth user error: %v", err)
+		http.Error(w, "failed to resolve user", http.StatusInternalServerError)
+		return
+	}
+	token, expiresAt, err := createSession(internalID, r)
+	if err != nil {
+		log.Printf("[ERROR] handleLiffAuth session error: %v", err)
+		http.Error(w, "failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":      "Auth successful",
+		"userId":       internalID,
+		"sessionToken": token,
+		"expiresAt":    expiresAt,
+	})
+}
+
+var errNoSession = errors.New("no valid session")
+
+// UserSession は user_sessions の行 (token_hash は返さない)
+type UserSession struct {
+	SessionID  string     `json:"session_id"`
+	UserID     string     `json:"user_id"`
+	UserAgent  string     `json:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Current    bool       `json:"current"`
+}
+
+const sessionColumns = "session_id, user_id, user_agent, created_at, last_seen_at, expires_at, revoked_at"
+
+// sessionLastSeenInterval より短い間隔では last_seen_at を更新しない
+const sessionLastSeenInterval = 5 * time.Minute
+
+// authenticateSession は Authorization: Bearer <sessionToken> のセッションを返す。失効・期限切れは errNoSession。
+func authenticateSession(r *http.Request) (*UserSession, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, errNoSession
+	}
+	resp, _, err := execute(supabaseClient.From("user_sessions").
+		Select(sessionColumns, "", false).
+		Eq("token_hash", hashSessionToken(token)).
+		Is("revoked_at", "null").
+		Gt("expires_at", time.Now().Format(time.RFC3339)))
+	if err != nil {
+		return nil, err
+	}
+	var sessions []UserSession
+	if json.Unmarshal(resp, &sessions); len(sessions) == 0 {
+		return nil, errNoSession
+	}
+	s := &sessions[0]
+	s.Current = true
+	if time.Since(s.LastSeenAt) > sessionLastSeenInterval {
+		if _, _, err := execute(supabaseClient.From("user_sessions").
+			Update(map[string]interface{}{"last_seen_at": time.Now()}, "minimal", "").
+			Eq("session_id", s.SessionID)); err != nil {
+			log.Printf("[WARNING] failed to touch session %s: %v", s.SessionID, err)
+		}
+	}
+	return s, nil
+}
+
+// writeSessionError は authenticateSession のエラーを 401 / 500 にする
+func writeSessionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNoSession) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	log.Printf("[ERROR] session lookup error: %v", err)
+	http.Error(w, "failed to authenticate", http.StatusInternalServerError)
+}
+
+// handleMySessions は /api/users/me/sessions (GET: 有効なセッション一覧, DELETE: 今のセッション以外を全て失効)
+func handleMySessions(w http.ResponseWriter, r *http.Request) {
+	current, err := authenticateSession(r)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		resp, _, err := execute(supabaseClient.From("user_sessions").
+			Select(sessionColumns, "", false).
+			Eq("user_id", current.UserID).
+			Is("revoked_at", "null").
+			Gt("expires_at", time.Now().Format(time.RFC3339)).
+			Order("last_seen_at", &postgrest.OrderOpts{Ascending: false}))
+		if err != nil {
+			log.Printf("[ERROR] handleMySessions list error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch sessions: %v", err), http.StatusInternalServerError)
+			return
+		}
+		var sessions []UserSession
+		json.Unmarshal(resp, &sessions)
+		for i := range sessions {
+			sessions[i].Current = sessions[i].SessionID == current.SessionID
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"sessions": sessions})
+
+	case http.MethodDelete:
+		resp, _, err := execute(supabaseClient.From("user_sessions").
+			Update(map[string]interface{}{"revoked_at": time.Now()}, "", "").
+			Eq("user_id", current.UserID).
+			Neq("session_id", current.SessionID).
+			Is("revoked_at", "null"))
+		if err != nil {
+			log.Printf("[ERROR] handleMySessions revoke error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to revoke sessions: %v", err), http.StatusInternalServerError)
+			return
+		}
+		var revoked []UserSession
+		json.Unmarshal(resp, &revoked)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": "Other sessions revoked", "count": len(revoked)})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleMySession は DELETE /api/users/me/sessions/{id}。今のセッションを指定すればログアウトになる。
+func handleMySession(w http.ResponseWriter, r *http.Request) {
+	current, err := authenticateSession(r)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	resp, _, err := execute(supabaseClient.From("user_sessions").
+		Update(map[string]interface{}{"revoked_at": time.Now()}, "", "").
+		Eq("session_id", r.PathValue("id")).
+		Eq("user_id", current.UserID).
+		Is("revoked_at", "null"))
+	if err != nil {
+		log.Printf("[ERROR] handleMySession revoke error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to revoke session: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if string(resp) == "[]" {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Session revoked"})
+}
+
+// relinkLineAccount は既存ユーザーに LINE アカウントを付け替える。
+// その LINE ユーザーIDで別の行ができてしまっていた場合、本が1冊もなければその行を削除して統合する。
+// 本がある行は勝手に消さず、errRelinkConflict を返す。
+func relinkLineAccount(userID, lineUserID string) error {
+	resp, _, err := execute(supabaseClient.From("users").Select("id, line_user_id", "", false).Or(
+		fmt.Sprintf("id.eq.%s,line_user_id.eq.%s", userID, lineUserID), ""))
+	if err != nil {
+		return err
+	}
+	var users []struct {
+		ID         string `json:"id"`
+		LineUserID string `json:"line_user_id"`
+	}
+	json.Unmarshal(resp, &users)
+	var oldLineID string
+	found := false
+	for _, u := range users {
+		if u.ID == userID {
+			found = true
+			oldLineID = u.LineUserID
+			continue
+		}
+		// 同じ LINE アカウントで作られた別の行
+		_, books, err := execute(supabaseClient.From("books").Select("book_id", "exact", true).Eq("user_id", u.ID))
+		if err != nil {
+			return err
+		}
+		if books > 0 {
+			return errRelinkConflict
+		}
+		if _, _, err := execute(supabaseClient.From("users").Delete("minimal", "").Eq("id", u.ID)); err != nil {
+			return err
+		}
+		log.Printf("[INFO] removed duplicate user %s while relinking LINE account to %s", u.ID, userID)
+	}
+	if !found {
+		return fmt.Errorf("user %s not found", userID)
+	}
+	if oldLineID == lineUserID {
+		return nil
+	}
+	if _, _, err := execute(supabaseClient.From("users").
+		Update(map[string]interface{}{"line_user_id": lineUserID, "updated_at": time.Now()}, "minimal", "").
+		Eq("id", userID)); err != nil {
+		return err
+	}
+	appCache.Delete(userCacheKey(oldLineID))
+	appCache.Delete(userCacheKey(lineUserID))
+	go linkUserRichMenu(lineUserID)
+	return nil
+}
+
+var errRelinkConflict = errors.New("LINE account already belongs to a user with books")
+
+// handleRelinkLine は POST /api/users/me/relink。セッションのユーザーに、LIFF の ID トークンの LINE アカウントを付け直す。
+// ブロック解除後や別の端末からログインして別ユーザーになってしまった場合の復旧用。
+func handleRelinkLine(w http.ResponseWriter, r *http.Request) {
+	current, err := authenticateSession(r)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		IDToken string `json:"idToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.IDToken == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	claims, err := verifyLineIDToken(req.IDToken)
+	if err != nil {
+		if errors.Is(err, errInvalidIDToken) {
+			http.Error(w, "Invalid ID token", http.StatusUnauthorized)
+			return
+		}
+		log.Printf("[ERROR] handleRelinkLine verify error: %v", err)
+		http.Error(w, "failed to verify ID token", http.StatusBadGateway)
+		return
+	}
+
+	if err := relinkLineAccount(current.UserID, claims.Subject); err != nil {
+		if errors.Is(err, errRelinkConflict) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.Printf("[ERROR] handleRelinkLine relink error: %v", err)
+		http.Error(w, "failed to relink account", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "LINE account linked", "userId": current.UserID})
+}
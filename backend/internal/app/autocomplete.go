@@ -0,0 +1,102 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	autocompleteDefaultLimit = 8
+	autocompleteMaxLimit     = 20
+)
+
+// Suggestion は登録フォーム向けの軽量な候補。Source は library か書誌プロバイダー名。
+type Suggestion struct {
+	Title      string `json:"title"`
+	Author     string `json:"author"`
+	Source     string `json:"source"`
+	BookID     string `json:"book_id,omitempty"` // 自分の蔵書にある場合
+	ISBN       string `json:"isbn,omitempty"`
+	PageCount  *int   `json:"page_count,omitempty"`
+	CoverURL   string `json:"cover_url,omitempty"`
+	TitleYomi  string `json:"title_yomi,omitempty"` // 楽天など読みを返すプロバイダーのみ
+	AuthorYomi string `json:"author_yomi,omitempty"`
+}
+
+// mergeSuggestions は自分の蔵書を優先し、タイトルと著者が同じ候補を1件にまとめる
+func mergeSuggestions(limit int, lists ...[]Suggestion) []Suggestion {
+	merged := []Suggestion{}
+	seen := make(map[string]bool)
+	for _, list := range lists {
+		for _, s := range list {
+			key := normalizeSearchText(s.Title) + "|" + normalizeSearchText(s.Author)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			merged = append(merged, s)
+			if len(merged) == limit {
+				return merged
+			}
+		}
+	}
+	return merged
+}
+
+// handleAutocomplete は GET /api/books/autocomplete?userId=...&q=...。
+// 外部 API が遅い・落ちている場合は蔵書の候補だけを返す。
+func handleAutocomplete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if userId == "" || query == "" {
+		http.Error(w, "userId and q required", http.StatusBadRequest)
+		return
+	}
+	limit, _, err := pageParams(r, autocompleteDefaultLimit, autocompleteMaxLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !consumeAPIQuota(w, userId, apiQuotaMetadata) {
+		return
+	}
+
+	type externalResult struct {
+		suggestions []Suggestion
+		err         error
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), metadataTimeout)
+	defer cancel()
+	external := make(chan externalResult, 1)
+	go func() {
+		s, err := externalSuggestions(ctx, query, limit)
+		external <- externalResult{s, err}
+	}()
+
+	library := []Suggestion{}
+	books, _, err := loadUserBooks(userId)
+	if err != nil {
+		log.Printf("[WARNING] handleAutocomplete library lookup failed: %v", err)
+	}
+	for _, res := range searchBooks(books, query) {
+		library = append(library, Suggestion{Title: res.Book.Title, Author: res.Book.Author, Source: "library", BookID: res.Book.BookID, PageCount: res.Book.PageCount})
+	}
+
+	ext := <-external
+	if ext.err != nil {
+		log.Printf("[WARNING] handleAutocomplete metadata lookup failed: %v", ext.err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"query":       query,
+		"suggestions": mergeSuggestions(limit, library, ext.suggestions),
+	})
+}
@@ -0,0 +1,289 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// 既存の行の後追い埋め (バックフィル)。列を足したときに古い行が空のまま残らないよう、books を book_id 順に
+// 少しずつ読んで埋める。tundokuctl backfill か POST /api/admin/backfill (1回で1バッチ) から流す。
+// どのタスクも埋まっていない値だけを書くので、途中で止めても最初から流し直せる。
+const (
+	backfillCompletedAt   = "completed_at"   // 読了済みの本の completed_at を読了記録 (なければ更新日時) から
+	backfillYomi          = "yomi"           // かなだけの書名・著者名と、books_catalog に同じ書名がある本の読み
+	backfillTitles        = "titles"         // normalizeBookText より前に登録された書名・著者名の表記ゆれ
+	backfillStatusHistory = "status_history" // 読書中の本の read_started_at と、読了記録のない読了済みの本の book_completions
+
+	defaultBackfillBatch = 200
+	maxBackfillBatch     = 1000
+)
+
+var backfillTasks = []string{backfillCompletedAt, backfillYomi, backfillTitles, backfillStatusHistory}
+
+// backfillColumns は判断に使う books の列。review などの暗号化した列は読まない。
+const backfillColumns = "book_id, user_id, title, author, title_yomi, author_yomi, status, deadline, read_cycle, read_started_at, started_at, completed_at, updated_at"
+
+// BackfillProgress は1バッチ分の結果。NextCursor を after に渡すと続きから流せる。
+type BackfillProgress struct {
+	Tasks      []string       `json:"tasks"`
+	Total      int64          `json:"total"`     // books の行数
+	Processed  int            `json:"processed"` // このバッチで見た行数
+	Updated    map[string]int `json:"updated"`   // タスクごとに埋めた行数
+	NextCursor string         `json:"next_cursor"`
+	Done       bool           `json:"done"`
+	DryRun     bool           `json:"dry_run"`
+}
+
+// parseBackfillTasks はカンマ区切りのタスク名を読む。空ならすべて。
+func parseBackfillTasks(names []string) ([]string, error) {
+	if len(names) == 0 {
+		return backfillTasks, nil
+	}
+	for _, name := range names {
+		if !slices.Contains(backfillTasks, name) {
+			return nil, fmt.Errorf("unknown backfill task %q (tasks: %s)", name, strings.Join(backfillTasks, ", "))
+		}
+	}
+	return names, nil
+}
+
+// isKanaText はかな・長音・中黒・空白だけでできているか (読みがそのまま表記から決まるか)
+func isKanaText(s string) bool {
+	s = foldHalfwidthKana(s)
+	return strings.TrimSpace(s) != "" && !strings.ContainsFunc(s, func(r rune) bool {
+		return !(r >= 0x3041 && r <= 0x309F) && !(r >= 0x30A1 && r <= 0x30FC) && r != '・' && r != ' ' && r != 0x3000
+	})
+}
+
+// runBackfillBatch は after より後の book_id から batch 行を読み、tasks を当てる
+func runBackfillBatch(tasks []string, after string, batch int, dryRun bool) (*BackfillProgress, error) {
+	progress := &BackfillProgress{Tasks: tasks, Updated: make(map[string]int, len(tasks)), DryRun: dryRun}
+	q := supabaseClient.From("books").Select(backfillColumns, "exact", false)
+	if after != "" {
+		q = q.Gt("book_id", after)
+	}
+	resp, total, err := execute(q.Order("book_id", &postgrest.OrderOpts{Ascending: true}).Limit(batch, ""))
+	if err != nil {
+		return nil, err
+	}
+	var books []Book
+	if err := json.Unmarshal(resp, &books); err != nil {
+		return nil, err
+	}
+	progress.Processed = len(books)
+	progress.Done = len(books) < batch
+	if len(books) > 0 {
+		progress.NextCursor = books[len(books)-1].BookID
+	}
+	// 件数は after より後の行数になるので、最初のバッチのときだけ全体の数として返す
+	if after == "" {
+		progress.Total = total
+	}
+	if len(books) == 0 {
+		return progress, nil
+	}
+
+	ids := make([]string, len(books))
+	titles := make([]string, 0, len(books))
+	for i, b := range books {
+		ids[i] = b.BookID
+		titles = append(titles, b.Title)
+	}
+	completions, err := backfillCompletions(ids)
+	if err != nil {
+		return nil, err
+	}
+	catalog := map[string]CatalogEntry{}
+	if slices.Contains(tasks, backfillYomi) {
+		if catalog, err = backfillCatalogYomi(titles); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, b := range books {
+		update := map[string]interface{}{}
+		var completion *Completion
+		for _, task := range tasks {
+			before := len(update)
+			switch task {
+			case backfillCompletedAt:
+				if b.Status == "completed" && b.CompletedAt == nil {
+					at := b.UpdatedAt
+					if cs := completions[b.BookID]; len(cs) > 0 {
+						at = cs[len(cs)-1].CompletedAt
+					}
+					update["completed_at"] = at
+				}
+			case backfillYomi:
+				if b.TitleYomi == nil {
+					if isKanaText(b.Title) {
+						update["title_yomi"] = normalizeYomi(b.Title)
+					} else if e, ok := catalog[b.Title]; ok {
+						update["title_yomi"] = e.TitleYomi
+						if b.AuthorYomi == nil && e.Author == b.Author && e.AuthorYomi != "" {
+							update["author_yomi"] = e.AuthorYomi
+						}
+					}
+				}
+				if _, ok := update["author_yomi"]; !ok && b.AuthorYomi == nil && isKanaText(b.Author) {
+					update["author_yomi"] = normalizeYomi(b.Author)
+				}
+			case backfillTitles:
+				if t := normalizeBookText(b.Title); t != b.Title && t != "" {
+					update["title"] = t
+				}
+				if a := normalizeBookText(b.Author); a != b.Author && a != "" {
+					update["author"] = a
+				}
+			case backfillStatusHistory:
+				if b.Status == "reading" && b.ReadStartedAt == nil {
+					at := b.UpdatedAt
+					if b.StartedAt != nil {
+						at = *b.StartedAt
+					}
+					update["read_started_at"] = at
+				}
+				if b.Status == "completed" && !slices.ContainsFunc(completions[b.BookID], func(c Completion) bool { return c.ReadCycle == max(1, b.ReadCycle) }) {
+					at := b.UpdatedAt
+					if b.CompletedAt != nil {
+						at = *b.CompletedAt
+					}
+					c := newCompletion(b, at)
+					c.StartedAt = b.ReadStartedAt
+					completion = &c
+				}
+			}
+			if len(update) > before || (task == backfillStatusHistory && completion != nil) {
+				progress.Updated[task]++
+			}
+		}
+		if dryRun || (len(update) == 0 && completion == nil) {
+			continue
+		}
+		if err := applyBackfill(b, update, completion); err != nil {
+			return progress, fmt.Errorf("book %s: %w", b.BookID, err)
+		}
+	}
+	return progress, nil
+}
+
+// backfillCompletions は本ごとの読了記録を古い順に返す
+func backfillCompletions(bookIDs []string) (map[string][]Completion, error) {
+	resp, _, err := execute(supabaseClient.From("book_completions").
+		Select("book_id, completed_at, read_cycle", "", false).
+		In("book_id", bookIDs).
+		Order("completed_at", &postgrest.OrderOpts{Ascending: true}))
+	if err != nil {
+		return nil, err
+	}
+	var rows []Completion
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		return nil, err
+	}
+	out := make(map[string][]Completion)
+	for _, c := range rows {
+		out[c.BookID] = append(out[c.BookID], c)
+	}
+	return out, nil
+}
+
+// backfillCatalogYomi は書名が同じで読みのある books_catalog の行を書名ごとに返す
+func backfillCatalogYomi(titles []string) (map[string]CatalogEntry, error) {
+	resp, _, err := execute(supabaseClient.From("books_catalog").
+		Select("title, author, title_yomi, author_yomi", "", false).
+		In("title", titles).
+		Neq("title_yomi", ""))
+	if err != nil {
+		return nil, err
+	}
+	var rows []CatalogEntry
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		return nil, err
+	}
+	out := make(map[string]CatalogEntry, len(rows))
+	for _, e := range rows {
+		out[e.Title] = e
+	}
+	return out, nil
+}
+
+// applyBackfill は1冊分を書く。読んでから書くまでに編集された本は飛ばす (流し直せば拾う)。
+// updated_at は変えない (同期や並べ替えで利用者の編集と区別できなくなるため)。
+func applyBackfill(b Book, update map[string]interface{}, completion *Completion) error {
+	if len(update) > 0 {
+		q := supabaseClient.From("books").Update(update, "", "").Eq("book_id", b.BookID)
+		if b.UpdatedAt.IsZero() {
+			q = q.Is("updated_at", "null")
+		} else {
+			q = q.Eq("updated_at", b.UpdatedAt.Format(time.RFC3339Nano))
+		}
+		resp, _, err := execute(q)
+		if err != nil {
+			return err
+		}
+		if string(resp) == "[]" {
+			log.Printf("[INFO] backfill skipped book %s: changed since it was read", b.BookID)
+			return nil
+		}
+		invalidateBooks(b.UserID)
+	}
+	if completion != nil {
+		row := map[string]interface{}{
+			"book_id":      completion.BookID,
+			"user_id":      completion.UserID,
+			"completed_at": completion.CompletedAt,
+			"deadline":     completion.Deadline,
+			"days_early":   completion.DaysEarly,
+			"read_cycle":   completion.ReadCycle,
+			"started_at":   completion.StartedAt,
+		}
+		// 同じ行を2回入れないよう再試行しない
+		if _, _, err := executeOnce(supabaseClient.From("book_completions").Insert(row, false, "", "minimal", "")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleBackfill は POST /api/admin/backfill {tasks, after, batch_size, dry_run}。
+// 1回で1バッチだけ流すので、done になるまで next_cursor を after に渡して繰り返し呼ぶ。
+func handleBackfill(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Tasks     []string `json:"tasks"`
+		After     string   `json:"after"`
+		BatchSize int      `json:"batch_size"`
+		DryRun    bool     `json:"dry_run"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	tasks, err := parseBackfillTasks(req.Tasks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.BatchSize <= 0 {
+		req.BatchSize = defaultBackfillBatch
+	}
+	progress, err := runBackfillBatch(tasks, req.After, min(req.BatchSize, maxBackfillBatch), req.DryRun)
+	if err != nil {
+		log.Printf("[ERROR] handleBackfill error: %v", err)
+		http.Error(w, fmt.Sprintf("backfill failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[INFO] backfill batch after %q: processed %d, updated %v (dry run %v)", req.After, progress.Processed, progress.Updated, req.DryRun)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(progress)
+}
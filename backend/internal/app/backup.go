@@ -0,0 +1,370 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const (
+	backupURLTTL       = 24 * time.Hour
+	maxRestoreBytes    = 20 << 20
+	backupIDTimeLayout = "20060102-150405"
+)
+
+var backupIDPattern = regexp.MustCompile(`^\d{8}-\d{6}$`)
+
+// restoreStrategies は既にある本 (同じ book_id か同じタイトル・著者) と重なったときの扱い
+var restoreStrategies = map[string]bool{
+	"skip":      true, // 今の本を残す
+	"overwrite": true, // バックアップの内容で上書きする
+	"duplicate": true, // 別の本として追加する
+}
+
+// backupStatuses はバックアップから戻せるステータス
+var backupStatuses = map[string]bool{"unread": true, "reading": true, "completed": true, "insulted": true, statusWishlist: true, statusAbandoned: true, statusWaiting: true}
+
+// RestoreReport は POST /api/users/me/restore の結果。Issues の Line はバックアップ内の何冊目か。
+type RestoreReport struct {
+	Strategy    string        `json:"strategy"`
+	DryRun      bool          `json:"dry_run"`
+	Total       int           `json:"total"`
+	Restored    int           `json:"restored"`
+	Overwritten int           `json:"overwritten"`
+	Duplicated  int           `json:"duplicated"`
+	Skipped     int           `json:"skipped"`
+	Notes       int           `json:"notes"`
+	Issues      []ImportIssue `json:"issues"`
+}
+
+func backupPath(userID, backupID string) string {
+	return fmt.Sprintf("backups/%s/%s.json", userID, backupID)
+}
+
+// handleBackup は POST /api/users/me/backup。蔵書とメモのスナップショット (エクスポートと同じ版付きの形式) を
+// Storage に残して署名付き URL を返す。backup_id を restore に渡せばサーバー側のコピーから戻せる。
+func handleBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session, err := authenticateSession(r)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	snapshot, err := buildLibraryExport(session.UserID)
+	if err != nil {
+		log.Printf("[ERROR] handleBackup error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to create backup: %v", err), http.StatusInternalServerError)
+		return
+	}
+	backupID := snapshot.ExportedAt.In(jst).Format(backupIDTimeLayout)
+	data, _ := json.Marshal(snapshot)
+	url, err := storeAndSign(backupPath(session.UserID, backupID), "application/json", data, backupURLTTL, "tundoku-backup-"+backupID+".json")
+	if err != nil {
+		log.Printf("[ERROR] handleBackup storage error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to store backup: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[INFO] backup %s created for user %s (%d books)", backupID, session.UserID, len(snapshot.Books))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"backup_id":    backupID,
+		"version":      snapshot.Version,
+		"books":        len(snapshot.Books),
+		"download_url": url,
+		"expires_at":   time.Now().Add(backupURLTTL),
+	})
+}
+
+// validateBackup は形式の版と各書籍を確認し、戻せる本とその位置 (1始まり) を返す
+func validateBackup(b *LibraryExport) ([]ExportedBook, []int, []ImportIssue, error) {
+	if b.Version < 1 || b.Version > exportFormatVersion {
+		return nil, nil, nil, fmt.Errorf("unsupported backup version %d", b.Version)
+	}
+	if len(b.Books) > maxImportBooks {
+		return nil, nil, nil, fmt.Errorf("too many books (max %d)", maxImportBooks)
+	}
+	var valid []ExportedBook
+	var lines []int
+	var issues []ImportIssue
+	for i, book := range b.Books {
+		switch {
+		case book.Title == "" || book.Author == "":
+			issues = append(issues, ImportIssue{Line: i + 1, Title: book.Title, Reason: "title and author required"})
+		case !backupStatuses[book.Status]:
+			issues = append(issues, ImportIssue{Line: i + 1, Title: book.Title, Reason: fmt.Sprintf("unknown status %q", book.Status)})
+		case book.Deadline.IsZero() && !deadlineless(book.Status):
+			issues = append(issues, ImportIssue{Line: i + 1, Title: book.Title, Reason: "deadline required"})
+		default:
+			valid = append(valid, book)
+			lines = append(lines, i+1)
+		}
+	}
+	return valid, lines, issues, nil
+}
+
+// restoreBookRow はバックアップの本を books の行にする。book_id は呼び出し側で決める。
+func restoreBookRow(userID string, b Book) map[string]interface{} {
+	var deadline interface{}
+	if !b.Deadline.IsZero() {
+		deadline = b.Deadline
+	}
+	tags := b.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	cycle := b.ReadCycle
+	if cycle < 1 {
+		cycle = 1
+	}
+	format := b.Format
+	if format == "" {
+		format = "paperback"
+	}
+	return map[string]interface{}{
+		"user_id":          userID,
+		"title":            b.Title,
+		"author":           b.Author,
+		"title_yomi":       b.TitleYomi,
+		"author_yomi":      b.AuthorYomi,
+		"deadline":         deadline,
+		"status":           b.Status,
+		"insult_level":     b.InsultLevel,
+		"insult_tone":      b.InsultTone,
+		"rating":           b.Rating,
+		"review":           sealTextPtr("books", b.Review),
+		"sort_order":       b.SortOrder,
+		"format":           format,
+		"page_count":       b.PageCount,
+		"duration_minutes": b.DurationMinutes,
+		"progress":         b.Progress,
+		"series":           nullIfEmpty(b.Series),
+		"volume":           b.Volume,
+		"price":            b.Price,
+		"tags":             tags,
+		"location":         b.Location,
+		"notify_channel":   b.NotifyChannel,
+		"reminder_cadence": b.ReminderCadence,
+		"available_at":     b.AvailableAt,
+		"waiting_for":      b.WaitingFor,
+		"abandon_reason":   b.AbandonReason,
+		"abandoned_at":     b.AbandonedAt,
+		"read_cycle":       cycle,
+		"read_started_at":  b.ReadStartedAt,
+		"started_at":       b.StartedAt,
+		"completed_at":     b.CompletedAt,
+		"archived":         b.Archived,
+		"archived_at":      b.ArchivedAt,
+		"muted_at":         b.MutedAt,
+		"muted_until":      b.MutedUntil,
+		"updated_at":       clock.Now(),
+	}
+}
+
+func restoreNoteRows(userID, bookID string, notes []Note, keepIDs bool) []map[string]interface{} {
+	rows := make([]map[string]interface{}, 0, len(notes))
+	for _, n := range notes {
+		row := map[string]interface{}{
+			"book_id":    bookID,
+			"user_id":    userID,
+			"kind":       n.Kind,
+			"content":    sealText("book_notes", n.Content),
+			"page":       n.Page,
+			"created_at": n.CreatedAt,
+		}
+		if keepIDs && n.NoteID != "" {
+			row["note_id"] = n.NoteID
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// loadRestoreSource は ?backup=<backup_id> ならサーバーに残したバックアップを、なければ本文を読む
+func loadRestoreSource(w http.ResponseWriter, r *http.Request, userID string) (*LibraryExport, error) {
+	var snapshot LibraryExport
+	if id := r.URL.Query().Get("backup"); id != "" {
+		if !backupIDPattern.MatchString(id) {
+			return nil, errors.New("invalid backup id")
+		}
+		data, err := downloadFromStorage(backupPath(userID, id))
+		if err != nil {
+			return nil, fmt.Errorf("backup %s not available: %w", id, err)
+		}
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return nil, fmt.Errorf("backup %s is corrupted: %w", id, err)
+		}
+		return &snapshot, nil
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxRestoreBytes)
+	if err := json.NewDecoder(r.Body).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("invalid backup file: %w", err)
+	}
+	return &snapshot, nil
+}
+
+// handleRestore は POST /api/users/me/restore?strategy=skip|overwrite|duplicate&dry_run=true&backup=<backup_id>。
+// 消えた本は元の book_id で戻すので、メモもそのまま紐づく。dry_run では書き込まずに結果だけ返す。
+func handleRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session, err := authenticateSession(r)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	userID := session.UserID
+	report := RestoreReport{Strategy: r.URL.Query().Get("strategy"), DryRun: r.URL.Query().Get("dry_run") == "true", Issues: []ImportIssue{}}
+	if report.Strategy == "" {
+		report.Strategy = "skip"
+	}
+	if !restoreStrategies[report.Strategy] {
+		http.Error(w, fmt.Sprintf("unknown strategy %q (skip, overwrite, duplicate)", report.Strategy), http.StatusBadRequest)
+		return
+	}
+	snapshot, err := loadRestoreSource(w, r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	books, lines, issues, err := validateBackup(snapshot)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	report.Total = len(snapshot.Books)
+	report.Issues = append(report.Issues, issues...)
+
+	existing, _, err := loadUserBooks(userID)
+	if err != nil {
+		log.Printf("[ERROR] handleRestore library lookup error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to restore: %v", err), http.StatusInternalServerError)
+		return
+	}
+	byID := make(map[string]Book, len(existing))
+	byKey := make(map[string]Book, len(existing))
+	for _, b := range existing {
+		byID[b.BookID] = b
+		byKey[importKey(b.Title, b.Author)] = b
+	}
+	// 他のユーザーの本と同じ ID (別アカウントのバックアップなど) は使えないので新しい ID にする
+	ids := make([]string, 0, len(books))
+	for _, b := range books {
+		if b.BookID != "" {
+			ids = append(ids, b.BookID)
+		}
+	}
+	taken := make(map[string]bool)
+	if len(ids) > 0 {
+		resp, _, err := execute(supabaseClient.From("books").Select("book_id", "", false).In("book_id", ids))
+		if err != nil {
+			log.Printf("[ERROR] handleRestore id lookup error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to restore: %v", err), http.StatusInternalServerError)
+			return
+		}
+		var rows []Book
+		json.Unmarshal(resp, &rows)
+		for _, row := range rows {
+			taken[row.BookID] = true
+		}
+	}
+
+	var keptRows, keptNotes []map[string]interface{}
+	fail := func(line int, b ExportedBook, err error) {
+		log.Printf("[ERROR] handleRestore book %q: %v", b.Title, err)
+		report.Issues = append(report.Issues, ImportIssue{Line: line, Title: b.Title, Reason: err.Error()})
+	}
+	for i, b := range books {
+		conflict, ok := byID[b.BookID]
+		if !ok {
+			conflict, ok = byKey[importKey(b.Title, b.Author)]
+		}
+		switch {
+		case ok && report.Strategy == "skip":
+			report.Skipped++
+		case ok && report.Strategy == "overwrite":
+			report.Overwritten++
+			report.Notes += len(b.Notes)
+			if report.DryRun {
+				continue
+			}
+			if _, _, err := execute(supabaseClient.From("books").Update(restoreBookRow(userID, b.Book), "minimal", "").Eq("book_id", conflict.BookID).Eq("user_id", userID)); err != nil {
+				fail(lines[i], b, err)
+				continue
+			}
+			if len(b.Notes) > 0 {
+				// 同じメモは note_id で重ねる
+				keepIDs := conflict.BookID == b.BookID
+				if _, _, err := execute(supabaseClient.From("book_notes").Insert(restoreNoteRows(userID, conflict.BookID, b.Notes, keepIDs), keepIDs, "note_id", "minimal", "")); err != nil {
+					fail(lines[i], b, err)
+				}
+			}
+		case !ok && b.BookID != "" && !taken[b.BookID]:
+			report.Restored++
+			report.Notes += len(b.Notes)
+			row := restoreBookRow(userID, b.Book)
+			row["book_id"] = b.BookID
+			row["created_at"] = b.CreatedAt
+			keptRows = append(keptRows, row)
+			keptNotes = append(keptNotes, restoreNoteRows(userID, b.BookID, b.Notes, true)...)
+		default:
+			// 重なった本の複製と、元の ID を使えない本は新しい ID で1冊ずつ入れる
+			if ok {
+				report.Duplicated++
+			} else {
+				report.Restored++
+			}
+			report.Notes += len(b.Notes)
+			if report.DryRun {
+				continue
+			}
+			resp, _, err := executeOnce(supabaseClient.From("books").Insert(restoreBookRow(userID, b.Book), false, "", "", ""))
+			var inserted []Book
+			if err == nil {
+				json.Unmarshal(resp, &inserted)
+				if len(inserted) == 0 {
+					err = errors.New("insert returned no rows")
+				}
+			}
+			if err != nil {
+				fail(lines[i], b, err)
+				continue
+			}
+			if len(b.Notes) > 0 {
+				if _, _, err := executeOnce(supabaseClient.From("book_notes").Insert(restoreNoteRows(userID, inserted[0].BookID, b.Notes, false), false, "", "minimal", "")); err != nil {
+					fail(lines[i], b, err)
+				}
+			}
+		}
+	}
+	if !report.DryRun && len(keptRows) > 0 {
+		if err := insertBookRows(r.Context(), keptRows); err != nil {
+			log.Printf("[ERROR] handleRestore insert error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to restore books: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if len(keptNotes) > 0 {
+			if _, _, err := executeOnce(supabaseClient.From("book_notes").Insert(keptNotes, true, "note_id", "minimal", "")); err != nil {
+				log.Printf("[ERROR] handleRestore notes insert error: %v", err)
+				report.Issues = append(report.Issues, ImportIssue{Reason: fmt.Sprintf("notes were not restored: %v", err)})
+			}
+		}
+	}
+	if !report.DryRun {
+		emitBookEvent(BookEvent{Type: "book.restored", UserID: userID})
+		log.Printf("[INFO] restored library for user %s (%s): %d restored, %d overwritten, %d duplicated, %d skipped", userID, report.Strategy, report.Restored, report.Overwritten, report.Duplicated, report.Skipped)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
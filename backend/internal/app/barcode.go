@@ -0,0 +1,290 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+)
+
+var (
+	errNoBarcode         = errors.New("no ISBN barcode found")
+	errScanImageTooLarge = fmt.Errorf("image too large (max %d pixels)", maxScanPixels)
+)
+
+// eanDigitWidths は EAN-13 の L コード (白黒白黒の4本の幅, 計7モジュール)。
+// R コードは同じ幅で色が反転し、G コードは幅の並びが逆になる。
+var eanDigitWidths = [10][4]float64{
+	{3, 2, 1, 1}, {2, 2, 2, 1}, {2, 1, 2, 2}, {1, 4, 1, 1}, {1, 1, 3, 2},
+	{1, 2, 3, 1}, {1, 1, 1, 4}, {1, 3, 1, 2}, {1, 2, 1, 3}, {3, 1, 1, 2},
+}
+
+// eanFirstDigitParity は左6桁の L/G の並び (G を 1 とするビット列, 先頭桁が上位) から先頭の桁を決める
+var eanFirstDigitParity = map[int]int{
+	0x00: 0, 0x0B: 1, 0x0D: 2, 0x0E: 3, 0x13: 4,
+	0x19: 5, 0x1C: 6, 0x15: 7, 0x16: 8, 0x1A: 9,
+}
+
+const (
+	maxScanUploadBytes = 10 << 20
+	eanRuns            = 59 // ガード3 + 左6桁×4 + 中央ガード5 + 右6桁×4 + ガード3
+	eanMaxDigitDiff    = 2.0
+	barcodeScanRows    = 24
+	maxScanPixels      = 25_000_000 // 展開すると RGBA で約 100MB。スマホの写真 (1200万画素前後) は通る
+)
+
+// decodeISBNBarcode は画像から EAN-13 バーコードを探し、978/979 で始まる ISBN を返す。
+// 横・縦・上下逆さまの撮影に対応するため、複数の行と列を両方向から走査する。
+// 展開する前にヘッダーの縦横を見て、maxScanPixels を超える画像は読まずに断る。
+func decodeISBNBarcode(r io.ReadSeeker) (string, error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return "", err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > maxScanPixels/cfg.Height {
+		return "", errScanImageTooLarge
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return "", err
+	}
+	b := img.Bounds()
+	lines := make([][]float64, 0, barcodeScanRows*2)
+	for i := 1; i <= barcodeScanRows; i++ {
+		y := b.Min.Y + b.Dy()*i/(barcodeScanRows+1)
+		row := make([]float64, b.Dx())
+		for x := range row {
+			row[x] = luminance(img.At(b.Min.X+x, y))
+		}
+		lines = append(lines, row)
+
+		x := b.Min.X + b.Dx()*i/(barcodeScanRows+1)
+		col := make([]float64, b.Dy())
+		for y := range col {
+			col[y] = luminance(img.At(x, b.Min.Y+y))
+		}
+		lines = append(lines, col)
+	}
+
+	for _, line := range lines {
+		for _, runs := range [][]int{scanRuns(line), reverseRuns(scanRuns(line))} {
+			if code, ok := decodeEAN13Runs(runs); ok && (strings.HasPrefix(code, "978") || strings.HasPrefix(code, "979")) {
+				return code, nil
+			}
+		}
+	}
+	return "", errNoBarcode
+}
+
+func luminance(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	return 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+}
+
+// scanRuns は走査線を明暗で二値化し、黒から始まる連続幅の列を返す
+func scanRuns(line []float64) []int {
+	if len(line) == 0 {
+		return nil
+	}
+	lo, hi := line[0], line[0]
+	for _, v := range line {
+		lo = math.Min(lo, v)
+		hi = math.Max(hi, v)
+	}
+	threshold := (lo + hi) / 2
+
+	runs := []int{}
+	dark := true
+	count := 0
+	for _, v := range line {
+		isDark := v < threshold
+		if len(runs) == 0 && count == 0 && !isDark {
+			continue // 先頭の白は読み飛ばす
+		}
+		if isDark == dark {
+			count++
+			continue
+		}
+		runs = append(runs, count)
+		dark = isDark
+		count = 1
+	}
+	return append(runs, count)
+}
+
+// reverseRuns は逆さまに撮った画像用に走査方向を反転する (黒から始まるよう末尾の白は落とす)
+func reverseRuns(runs []int) []int {
+	if len(runs)%2 == 0 {
+		runs = runs[:len(runs)-1]
+	}
+	reversed := make([]int, len(runs))
+	for i, w := range runs {
+		reversed[len(runs)-1-i] = w
+	}
+	return reversed
+}
+
+// matchDigit は4本の幅に最も近い数字を返す。reversed なら G コードとして照合する。
+func matchDigit(widths []int, reversed bool) (int, float64) {
+	total := 0
+	for _, w := range widths {
+		total += w
+	}
+	best, bestDiff := -1, math.MaxFloat64
+	for d, pattern := range eanDigitWidths {
+		diff := 0.0
+		for i, w := range widths {
+			p := pattern[i]
+			if reversed {
+				p = pattern[3-i]
+			}
+			diff += math.Abs(float64(w)*7/float64(total) - p)
+		}
+		if diff < bestDiff {
+			best, bestDiff = d, diff
+		}
+	}
+	return best, bestDiff
+}
+
+// decodeEAN13Runs は黒から始まる幅の列 (偶数番目が黒) から EAN-13 を探す
+func decodeEAN13Runs(runs []int) (string, bool) {
+	for start := 0; start+eanRuns <= len(runs); start += 2 {
+		if code, ok := decodeEAN13At(runs[start : start+eanRuns]); ok {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+func decodeEAN13At(runs []int) (string, bool) {
+	module := float64(runs[0]+runs[1]+runs[2]) / 3
+	if module == 0 {
+		return "", false
+	}
+	for _, w := range runs[27:32] {
+		if math.Abs(float64(w)-module) > module {
+			return "", false
+		}
+	}
+
+	digits := make([]int, 13)
+	parity := 0
+	for i := 0; i < 6; i++ {
+		widths := runs[3+i*4 : 7+i*4]
+		l, lDiff := matchDigit(widths, false)
+		g, gDiff := matchDigit(widths, true)
+		parity <<= 1
+		if gDiff < lDiff {
+			l, lDiff = g, gDiff
+			parity |= 1
+		}
+		if lDiff > eanMaxDigitDiff {
+			return "", false
+		}
+		digits[i+1] = l
+	}
+	first, ok := eanFirstDigitParity[parity]
+	if !ok {
+		return "", false
+	}
+	digits[0] = first
+	for i := 0; i < 6; i++ {
+		d, diff := matchDigit(runs[32+i*4:36+i*4], false)
+		if diff > eanMaxDigitDiff {
+			return "", false
+		}
+		digits[i+7] = d
+	}
+
+	sum := 0
+	for i, d := range digits[:12] {
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	if (10-sum%10)%10 != digits[12] {
+		return "", false
+	}
+	var code strings.Builder
+	for _, d := range digits {
+		code.WriteByte(byte('0' + d))
+	}
+	return code.String(), true
+}
+
+// handleScanBook は POST /api/books/scan。裏表紙の写真 (multipart の image) からバーコードを読み、
+// 書誌を引いて登録フォーム用の下書きを返す。書誌が見つからなくても ISBN だけは返す。
+func handleScanBook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxScanUploadBytes)
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("image required (max %d MB)", maxScanUploadBytes>>20), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	if !consumeAPIQuota(w, r.FormValue("user_id"), apiQuotaImageUpload) {
+		return
+	}
+
+	isbn, err := decodeISBNBarcode(file)
+	if errors.Is(err, errNoBarcode) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if errors.Is(err, errScanImageTooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read image: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	draft := Book{
+		UserID: r.FormValue("user_id"),
+		Status: "unread",
+		Format: "paperback",
+	}
+	defaults, err := fetchUserDefaults(draft.UserID)
+	if err != nil {
+		log.Printf("[WARNING] handleScanBook defaults lookup failed for user %s: %v", draft.UserID, err)
+	}
+	defaults.applyTo(&draft, clock.Now())
+	source, coverURL := "", ""
+	suggestions, err := externalSuggestions(r.Context(), isbn, 1)
+	if err != nil {
+		log.Printf("[WARNING] handleScanBook metadata lookup failed for %s: %v", isbn, err)
+	}
+	if len(suggestions) > 0 {
+		s := suggestions[0]
+		draft.Title, draft.Author, draft.PageCount = s.Title, s.Author, s.PageCount
+		source, coverURL = s.Source, s.CoverURL
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"isbn":      isbn,
+		"found":     len(suggestions) > 0,
+		"source":    source,
+		"cover_url": coverURL,
+		"draft":     draft,
+	})
+}
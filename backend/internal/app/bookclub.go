@@ -0,0 +1,520 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// 読書会の課題本決め。ワークスペースの管理者が投票を始め、メンバーが候補を出して期限までに1人1票を投じる。
+// 締め切りは cron (/api/cron/book-club) が処理し、最多得票の本を全メンバーの積読に共通の期限で登録する。
+const (
+	jobKindBookClub = "book_club"
+
+	bookClubPollOpen   = "open"
+	bookClubPollClosed = "closed"
+
+	bookClubTag           = "読書会"
+	maxBookClubCandidates = 20
+	bookClubListLimit     = 50
+)
+
+var errBookClubPollNotFound = errors.New("poll not found")
+
+// BookClubPoll は book_club_polls の1行
+type BookClubPoll struct {
+	PollID            string     `json:"poll_id"`
+	WorkspaceID       string     `json:"workspace_id"`
+	Title             string     `json:"title"`
+	CreatedBy         *string    `json:"created_by"`
+	ClosesAt          time.Time  `json:"closes_at"`        // 候補の提案と投票の締め切り
+	ReadingDeadline   time.Time  `json:"reading_deadline"` // 課題本の期限 (全メンバー共通)
+	Status            string     `json:"status"`           // open, closed
+	WinnerCandidateID *string    `json:"winner_candidate_id"`
+	ClosedAt          *time.Time `json:"closed_at"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+// BookClubCandidate は book_club_candidates の1行。Votes は集計して埋める。
+type BookClubCandidate struct {
+	CandidateID string    `json:"candidate_id"`
+	PollID      string    `json:"poll_id"`
+	Title       string    `json:"title"`
+	Author      string    `json:"author"`
+	PageCount   *int      `json:"page_count"`
+	ProposedBy  *string   `json:"proposed_by"`
+	CreatedAt   time.Time `json:"created_at"`
+	Votes       int       `json:"votes"`
+}
+
+func (p BookClubPoll) accepting(now time.Time) bool {
+	return p.Status == bookClubPollOpen && now.Before(p.ClosesAt)
+}
+
+func fetchBookClubPoll(workspaceID, pollID string) (*BookClubPoll, error) {
+	resp, _, err := execute(supabaseClient.From("book_club_polls").Select("*", "", false).
+		Eq("poll_id", pollID).Eq("workspace_id", workspaceID))
+	if err != nil {
+		return nil, err
+	}
+	var rows []BookClubPoll
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errBookClubPollNotFound
+	}
+	return &rows[0], nil
+}
+
+// bookClubCandidates は候補を得票の多い順 (同数なら先に出た順) に返す。myVote は userID の投票先 (未投票なら空)。
+func bookClubCandidates(pollID, userID string) (candidates []BookClubCandidate, myVote string, err error) {
+	resp, _, err := execute(supabaseClient.From("book_club_candidates").Select("*", "", false).
+		Eq("poll_id", pollID).Order("created_at", &postgrest.OrderOpts{Ascending: true}))
+	if err != nil {
+		return nil, "", err
+	}
+	if err := json.Unmarshal(resp, &candidates); err != nil {
+		return nil, "", err
+	}
+	vResp, _, err := execute(supabaseClient.From("book_club_votes").Select("user_id, candidate_id", "", false).Eq("poll_id", pollID))
+	if err != nil {
+		return nil, "", err
+	}
+	var votes []struct {
+		UserID      string `json:"user_id"`
+		CandidateID string `json:"candidate_id"`
+	}
+	json.Unmarshal(vResp, &votes)
+	counts := make(map[string]int, len(candidates))
+	for _, v := range votes {
+		counts[v.CandidateID]++
+		if v.UserID == userID {
+			myVote = v.CandidateID
+		}
+	}
+	for i := range candidates {
+		candidates[i].Votes = counts[candidates[i].CandidateID]
+	}
+	// 安定ソートなので同数は提案順のまま
+	slices.SortStableFunc(candidates, func(a, b BookClubCandidate) int { return b.Votes - a.Votes })
+	return candidates, myVote, nil
+}
+
+// notifyBookClub はワークスペースのメンバー全員に LINE で知らせる
+func notifyBookClub(members []WorkspaceMember, message string) {
+	for _, m := range members {
+		lineUserID, err := lineUserIDFor(m.UserID)
+		if err != nil || lineUserID == "" {
+			continue
+		}
+		if err := enqueueJob(NotificationJob{Kind: jobKindBookClub, UserID: m.UserID, LineUserID: lineUserID, Message: message, RunAt: clock.Now()}); err != nil {
+			log.Printf("[ERROR] failed to enqueue book club message for user %s: %v", m.UserID, err)
+		}
+	}
+}
+
+// registerBookClubWinner は課題本を全メンバーの積読に投票で決めた期限で登録する。
+// 同じ本 (書名と著者) をアーカイブせずに持っている人には登録しない。登録した冊数を返す。
+func registerBookClubWinner(poll BookClubPoll, winner BookClubCandidate, members []WorkspaceMember) (int, error) {
+	userIDs := make([]string, 0, len(members))
+	for _, m := range members {
+		userIDs = append(userIDs, m.UserID)
+	}
+	resp, _, err := execute(supabaseClient.From("books").Select("user_id, title, author", "", false).
+		In("user_id", userIDs).Eq("archived", "false").Eq("title", normalizeBookText(winner.Title)))
+	if err != nil {
+		return 0, err
+	}
+	var existing []Book
+	json.Unmarshal(resp, &existing)
+	owned := make(map[string]bool, len(existing))
+	for _, b := range existing {
+		if importKey(b.Title, b.Author) == importKey(winner.Title, winner.Author) {
+			owned[b.UserID] = true
+		}
+	}
+
+	now := clock.Now()
+	var rows []map[string]interface{}
+	for _, uid := range userIDs {
+		if owned[uid] {
+			continue
+		}
+		defaults, err := fetchUserDefaults(uid)
+		if err != nil {
+			log.Printf("[WARNING] book club defaults lookup failed for user %s: %v", uid, err)
+		}
+		row := importRow(uid, importedBook{Book: Book{Title: winner.Title, Author: winner.Author, PageCount: winner.PageCount, Status: "unread"}}, now)
+		row["deadline"], row["insult_level"], row["tags"] = poll.ReadingDeadline, defaults.insultLevel(), normalizeTags(append(defaults.Tags, bookClubTag))
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	rawResp, _, err := executeOnce(supabaseClient.From("books").Insert(rows, false, "", "", ""))
+	if err != nil {
+		return 0, err
+	}
+	emitBookRows(eventBookCreated, rawResp)
+	return len(rows), nil
+}
+
+// closeBookClubPoll は投票を締め切り、最多得票の本を登録して結果を知らせる。
+// 先に status を closed にできたインスタンスだけが処理するので、cron が重なっても二重に登録しない。
+func closeBookClubPoll(poll BookClubPoll) error {
+	resp, _, err := execute(supabaseClient.From("book_club_polls").Update(map[string]interface{}{
+		"status":    bookClubPollClosed,
+		"closed_at": clock.Now(),
+	}, "", "").Eq("poll_id", poll.PollID).Eq("status", bookClubPollOpen))
+	if err != nil {
+		return err
+	}
+	var claimed []BookClubPoll
+	if json.Unmarshal(resp, &claimed); len(claimed) == 0 {
+		return nil
+	}
+	members, err := workspaceMembers(poll.WorkspaceID)
+	if err != nil {
+		return err
+	}
+	candidates, _, err := bookClubCandidates(poll.PollID, "")
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 0 || candidates[0].Votes == 0 {
+		log.Printf("[INFO] book club poll %s closed without votes", poll.PollID)
+		notifyBookClub(members, fmt.Sprintf("🗳 読書会「%s」の投票は票が入らないまま締め切られました。課題本は決まりませんでした。", poll.Title))
+		return nil
+	}
+	winner := candidates[0]
+	if _, _, err := execute(supabaseClient.From("book_club_polls").Update(map[string]interface{}{"winner_candidate_id": winner.CandidateID}, "minimal", "").
+		Eq("poll_id", poll.PollID)); err != nil {
+		return err
+	}
+	registered, err := registerBookClubWinner(poll, winner, members)
+	if err != nil {
+		return fmt.Errorf("register winner: %w", err)
+	}
+	log.Printf("[INFO] book club poll %s closed: %q won with %d votes, registered for %d/%d members", poll.PollID, winner.Title, winner.Votes, registered, len(members))
+	notifyBookClub(members, fmt.Sprintf("📚 読書会「%s」の課題本は『%s』(%d票) に決まりました。積読に登録したので、%sまでに読み切りましょう。",
+		poll.Title, winner.Title, winner.Votes, poll.ReadingDeadline.In(jst).Format("1/2")))
+	return nil
+}
+
+// closeDueBookClubPolls は締め切りを過ぎた投票をすべて締め切り、処理した数を返す
+func closeDueBookClubPolls() (int, error) {
+	resp, _, err := execute(supabaseClient.From("book_club_polls").Select("*", "", false).
+		Eq("status", bookClubPollOpen).Lte("closes_at", clock.Now().Format(time.RFC3339)))
+	if err != nil {
+		return 0, err
+	}
+	var polls []BookClubPoll
+	json.Unmarshal(resp, &polls)
+	closed := 0
+	for _, p := range polls {
+		if err := closeBookClubPoll(p); err != nil {
+			log.Printf("[ERROR] failed to close book club poll %s: %v", p.PollID, err)
+			continue
+		}
+		closed++
+	}
+	return closed, nil
+}
+
+// handleBookClubPolls は /api/workspaces/{id}/polls。
+// GET ?userId=... でメンバーが最近の投票を見て、POST {user_id, title, closes_at, reading_deadline} で管理者が投票を始める。
+func handleBookClubPolls(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("id")
+	switch r.Method {
+	case http.MethodGet:
+		if _, ok := requireWorkspaceRole(w, workspaceID, r.URL.Query().Get("userId"), false); !ok {
+			return
+		}
+		resp, _, err := execute(supabaseClient.From("book_club_polls").Select("*", "", false).
+			Eq("workspace_id", workspaceID).
+			Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+			Limit(bookClubListLimit, ""))
+		if err != nil {
+			log.Printf("[ERROR] handleBookClubPolls list error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch polls: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+
+	case http.MethodPost:
+		var req struct {
+			UserID          string    `json:"user_id"`
+			Title           string    `json:"title"`
+			ClosesAt        time.Time `json:"closes_at"`
+			ReadingDeadline time.Time `json:"reading_deadline"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if _, ok := requireWorkspaceRole(w, workspaceID, req.UserID, true); !ok {
+			return
+		}
+		req.Title = strings.TrimSpace(req.Title)
+		switch {
+		case req.Title == "":
+			http.Error(w, "title required", http.StatusBadRequest)
+			return
+		case !req.ClosesAt.After(clock.Now()):
+			http.Error(w, "closes_at must be in the future", http.StatusBadRequest)
+			return
+		case !req.ReadingDeadline.After(req.ClosesAt):
+			http.Error(w, "reading_deadline must be after closes_at", http.StatusBadRequest)
+			return
+		}
+		resp, _, err := executeOnce(supabaseClient.From("book_club_polls").Insert(map[string]interface{}{
+			"workspace_id":     workspaceID,
+			"title":            req.Title,
+			"created_by":       req.UserID,
+			"closes_at":        req.ClosesAt,
+			"reading_deadline": req.ReadingDeadline,
+		}, false, "", "", ""))
+		var rows []BookClubPoll
+		if err == nil {
+			err = json.Unmarshal(resp, &rows)
+		}
+		if err != nil || len(rows) == 0 {
+			log.Printf("[ERROR] handleBookClubPolls insert error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to create poll: %v", err), http.StatusInternalServerError)
+			return
+		}
+		poll := rows[0]
+		log.Printf("[INFO] book club poll %s opened in workspace %s", poll.PollID, workspaceID)
+		if members, err := workspaceMembers(workspaceID); err != nil {
+			log.Printf("[ERROR] failed to fetch members for poll %s: %v", poll.PollID, err)
+		} else {
+			notifyBookClub(members, fmt.Sprintf("🗳 読書会「%s」の課題本決めが始まりました。%sまで候補の提案と投票ができます。",
+				poll.Title, poll.ClosesAt.In(jst).Format("1/2 15:04")))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(poll)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// writeBookClubPollError は fetchBookClubPoll のエラーをレスポンスにする
+func writeBookClubPollError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errBookClubPollNotFound) {
+		http.Error(w, "Poll not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("[ERROR] book club poll lookup error: %v", err)
+	http.Error(w, fmt.Sprintf("failed to fetch poll: %v", err), http.StatusInternalServerError)
+}
+
+// handleBookClubPoll は /api/workspaces/{id}/polls/{pollId}?userId=...。
+// GET で候補と得票数と自分の投票先を返し、DELETE で管理者が締め切り前の投票を取り消す。
+func handleBookClubPoll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	workspaceID, userID := r.PathValue("id"), r.URL.Query().Get("userId")
+	if _, ok := requireWorkspaceRole(w, workspaceID, userID, r.Method == http.MethodDelete); !ok {
+		return
+	}
+	poll, err := fetchBookClubPoll(workspaceID, r.PathValue("pollId"))
+	if err != nil {
+		writeBookClubPollError(w, err)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if poll.Status != bookClubPollOpen {
+			http.Error(w, "Poll has already closed", http.StatusConflict)
+			return
+		}
+		if _, _, err := execute(supabaseClient.From("book_club_polls").Delete("minimal", "").Eq("poll_id", poll.PollID).Eq("status", bookClubPollOpen)); err != nil {
+			log.Printf("[ERROR] handleBookClubPoll delete error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to delete poll: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Poll deleted"})
+		return
+	}
+
+	candidates, myVote, err := bookClubCandidates(poll.PollID, userID)
+	if err != nil {
+		log.Printf("[ERROR] handleBookClubPoll candidates error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch candidates: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"poll":       poll,
+		"candidates": candidates,
+		"my_vote":    nullIfEmpty(myVote),
+	})
+}
+
+// handleBookClubCandidates は POST /api/workspaces/{id}/polls/{pollId}/candidates {user_id, title, author, page_count}。
+// メンバーなら誰でも締め切りまで候補を出せる。
+func handleBookClubCandidates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID    string `json:"user_id"`
+		Title     string `json:"title"`
+		Author    string `json:"author"`
+		PageCount *int   `json:"page_count"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	workspaceID := r.PathValue("id")
+	if _, ok := requireWorkspaceRole(w, workspaceID, req.UserID, false); !ok {
+		return
+	}
+	poll, err := fetchBookClubPoll(workspaceID, r.PathValue("pollId"))
+	if err != nil {
+		writeBookClubPollError(w, err)
+		return
+	}
+	if !poll.accepting(clock.Now()) {
+		http.Error(w, "Poll is closed", http.StatusConflict)
+		return
+	}
+	req.Title, req.Author = normalizeBookText(req.Title), normalizeBookText(req.Author)
+	if req.Title == "" || req.Author == "" {
+		http.Error(w, "title and author required", http.StatusBadRequest)
+		return
+	}
+	if req.PageCount != nil && *req.PageCount <= 0 {
+		http.Error(w, "page_count must be positive", http.StatusBadRequest)
+		return
+	}
+	candidates, _, err := bookClubCandidates(poll.PollID, "")
+	if err != nil {
+		log.Printf("[ERROR] handleBookClubCandidates list error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to add candidate: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if len(candidates) >= maxBookClubCandidates {
+		http.Error(w, fmt.Sprintf("too many candidates (max %d)", maxBookClubCandidates), http.StatusConflict)
+		return
+	}
+	for _, c := range candidates {
+		if importKey(c.Title, c.Author) == importKey(req.Title, req.Author) {
+			http.Error(w, "This book has already been proposed", http.StatusConflict)
+			return
+		}
+	}
+	rawResp, _, err := executeOnce(supabaseClient.From("book_club_candidates").Insert(map[string]interface{}{
+		"poll_id":     poll.PollID,
+		"title":       req.Title,
+		"author":      req.Author,
+		"page_count":  req.PageCount,
+		"proposed_by": req.UserID,
+	}, false, "", "", ""))
+	if err != nil {
+		log.Printf("[ERROR] handleBookClubCandidates insert error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to add candidate: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(rawResp)
+}
+
+// handleBookClubVote は /api/workspaces/{id}/polls/{pollId}/vote。
+// PUT {user_id, candidate_id} で投票 (締め切りまでは入れ直せる)、DELETE {user_id} で取り消す。
+func handleBookClubVote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID      string `json:"user_id"`
+		CandidateID string `json:"candidate_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	workspaceID := r.PathValue("id")
+	if _, ok := requireWorkspaceRole(w, workspaceID, req.UserID, false); !ok {
+		return
+	}
+	poll, err := fetchBookClubPoll(workspaceID, r.PathValue("pollId"))
+	if err != nil {
+		writeBookClubPollError(w, err)
+		return
+	}
+	if !poll.accepting(clock.Now()) {
+		http.Error(w, "Poll is closed", http.StatusConflict)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if _, _, err := execute(supabaseClient.From("book_club_votes").Delete("minimal", "").Eq("poll_id", poll.PollID).Eq("user_id", req.UserID)); err != nil {
+			log.Printf("[ERROR] handleBookClubVote delete error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to retract vote: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Vote retracted"})
+		return
+	}
+
+	_, n, err := execute(supabaseClient.From("book_club_candidates").Select("candidate_id", "exact", true).
+		Eq("candidate_id", req.CandidateID).Eq("poll_id", poll.PollID))
+	if err != nil {
+		log.Printf("[ERROR] handleBookClubVote candidate lookup error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to vote: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if n == 0 {
+		http.Error(w, "Candidate not found", http.StatusNotFound)
+		return
+	}
+	if _, _, err := executeOnce(supabaseClient.From("book_club_votes").Insert(map[string]interface{}{
+		"poll_id":      poll.PollID,
+		"user_id":      req.UserID,
+		"candidate_id": req.CandidateID,
+		"voted_at":     clock.Now(),
+	}, true, "poll_id,user_id", "minimal", "")); err != nil {
+		log.Printf("[ERROR] handleBookClubVote upsert error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to vote: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Vote recorded", "candidate_id": req.CandidateID})
+}
+
+// handleCloseBookClubPolls は /api/cron/book-club。締め切りを過ぎた読書会の投票を締め切る。
+func handleCloseBookClubPolls(w http.ResponseWriter, r *http.Request) {
+	if !authorizeCron(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	closed, err := closeDueBookClubPolls()
+	opsAlerts.recordCron("book-club", err, clock.Now())
+	if err != nil {
+		log.Printf("[ERROR] handleCloseBookClubPolls query error: %v", err)
+		http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Book club polls closed", "count": closed})
+}
@@ -0,0 +1,204 @@
+package app
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+const bookDetailInsultLimit = 20
+
+// BookDetail は GET /api/books/{id} の応答。一覧では重いので出さない関連データをまとめる。
+type BookDetail struct {
+	Book          Book              `json:"book"`
+	NotesCount    int               `json:"notes_count"`
+	Sessions      SessionsSummary   `json:"sessions"`
+	Insults       []NotificationJob `json:"insults"` // 新しい順に bookDetailInsultLimit 件
+	InsultsCount  int               `json:"insults_count"`
+	StatusHistory []StatusChange    `json:"status_history"`
+	Reminders     ReminderSchedule  `json:"reminders"`
+	Completions   []Completion      `json:"completions"`
+	FinishTimings []FinishTiming    `json:"finish_timings"` // completions と同じ順
+	Milestones    []Milestone       `json:"milestones"`
+	Errors        map[string]string `json:"errors,omitempty"` // 取得に失敗した項目 (他は返す)
+}
+
+// SessionsSummary は読書タイマーの集計
+type SessionsSummary struct {
+	Count        int        `json:"count"`
+	TotalSeconds int        `json:"total_seconds"`
+	TotalPages   int        `json:"total_pages"`
+	LastReadAt   *time.Time `json:"last_read_at"`
+	Open         bool       `json:"open"` // 計測中のセッションがある
+}
+
+// StatusChange は記録から組み立てたステータスの履歴 (専用のテーブルはない)
+type StatusChange struct {
+	Status string    `json:"status"`
+	At     time.Time `json:"at"`
+	Cycle  int       `json:"read_cycle,omitempty"`
+}
+
+// ReminderSchedule は今後の督促・通知の予定
+type ReminderSchedule struct {
+	Deadline      *time.Time        `json:"deadline"`
+	DaysRemaining *int              `json:"days_remaining"` // 負の値は期限超過
+	Overdue       bool              `json:"overdue"`        // 次の期限チェックで督促の対象になる
+	Pending       []NotificationJob `json:"pending"`        // 送信待ちのジョブ
+}
+
+// handleBookDetail は GET /api/books/{id}?userId=...。関連テーブルへの問い合わせは並列に行う。
+func handleBookDetail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	book, err := fetchOwnedBook(r.PathValue("id"), r.URL.Query().Get("userId"))
+	if err != nil {
+		writeBookLookupError(w, err)
+		return
+	}
+	d := &BookDetail{Book: book, Insults: []NotificationJob{}, Completions: []Completion{}, Milestones: []Milestone{}}
+	d.Reminders.Pending = []NotificationJob{}
+	bookID := book.BookID
+	// シリーズをまとめた督促は book_ids に入る
+	mentions := fmt.Sprintf("book_id.eq.%s,book_ids.cs.{%s}", bookID, bookID)
+
+	parts := map[string]func() error{
+		"notes": func() error {
+			_, n, err := execute(supabaseClient.From("book_notes").Select("note_id", "exact", true).Eq("book_id", bookID))
+			d.NotesCount = int(n)
+			return err
+		},
+		"sessions": func() error {
+			resp, _, err := execute(supabaseClient.From("reading_sessions").Select("started_at, ended_at, duration_seconds, pages", "", false).Eq("book_id", bookID))
+			if err != nil {
+				return err
+			}
+			var sessions []ReadingSession
+			json.Unmarshal(resp, &sessions)
+			s := &d.Sessions
+			for _, rs := range sessions {
+				s.Count++
+				s.TotalSeconds += rs.DurationSeconds
+				s.TotalPages += rs.Pages
+				if rs.EndedAt == nil {
+					s.Open = true
+				}
+				if s.LastReadAt == nil || rs.StartedAt.After(*s.LastReadAt) {
+					started := rs.StartedAt
+					s.LastReadAt = &started
+				}
+			}
+			return nil
+		},
+		"insults": func() error {
+			resp, n, err := execute(supabaseClient.From("notification_jobs").
+				Select("job_id, book_id, book_ids, template, insult_level, message, status, sent_at, created_at", "exact", false).
+				Eq("kind", jobKindInsult).
+				Eq("status", "sent").
+				Or(mentions, "").
+				Order("sent_at", &postgrest.OrderOpts{Ascending: false}).
+				Limit(bookDetailInsultLimit, ""))
+			if err != nil {
+				return err
+			}
+			d.InsultsCount = int(n)
+			return json.Unmarshal(resp, &d.Insults)
+		},
+		"completions": func() error {
+			resp, _, err := execute(supabaseClient.From("book_completions").Select("*", "", false).Eq("book_id", bookID).Order("completed_at", &postgrest.OrderOpts{Ascending: true}))
+			if err != nil {
+				return err
+			}
+			return json.Unmarshal(resp, &d.Completions)
+		},
+		"milestones": func() error {
+			resp, _, err := execute(supabaseClient.From("book_milestones").Select("*", "", false).Eq("book_id", bookID).Order("position", &postgrest.OrderOpts{Ascending: true}))
+			if err != nil {
+				return err
+			}
+			return json.Unmarshal(resp, &d.Milestones)
+		},
+		"pending": func() error {
+			resp, _, err := execute(supabaseClient.From("notification_jobs").
+				Select("job_id, kind, book_id, book_ids, milestone_id, status, run_at, created_at", "", false).
+				In("status", []string{"pending", "processing"}).
+				Or(mentions, "").
+				Order("run_at", &postgrest.OrderOpts{Ascending: true}))
+			if err != nil {
+				return err
+			}
+			return json.Unmarshal(resp, &d.Reminders.Pending)
+		},
+	}
+	// 1項目の失敗で全体を失敗にはせず、errors に載せて他の項目は返す
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for name, fetch := range parts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fetch(); err != nil {
+				log.Printf("[ERROR] book detail %s for %s: %v", name, bookID, err)
+				mu.Lock()
+				if d.Errors == nil {
+					d.Errors = make(map[string]string)
+				}
+				d.Errors[name] = err.Error()
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	d.StatusHistory = statusHistory(book, d.Completions, d.Insults)
+	d.FinishTimings = make([]FinishTiming, 0, len(d.Completions))
+	for _, c := range d.Completions {
+		d.FinishTimings = append(d.FinishTimings, c.timing())
+	}
+	if !deadlineless(book.Status) && !book.Deadline.IsZero() {
+		deadline := book.Deadline
+		days := int(deadline.Sub(clock.Now()).Hours() / 24)
+		d.Reminders.Deadline = &deadline
+		d.Reminders.DaysRemaining = &days
+		d.Reminders.Overdue = slices.Contains(activeStatuses, book.Status) && clock.Now().After(deadline)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+// statusHistory は登録日・読み始め・読了・督促・中断の記録を時系列に並べる
+func statusHistory(book Book, completions []Completion, insults []NotificationJob) []StatusChange {
+	history := []StatusChange{{Status: "registered", At: book.CreatedAt}}
+	for _, c := range completions {
+		if c.StartedAt != nil {
+			history = append(history, StatusChange{Status: "reading", At: *c.StartedAt, Cycle: c.ReadCycle})
+		}
+		history = append(history, StatusChange{Status: "completed", At: c.CompletedAt, Cycle: c.ReadCycle})
+	}
+	// 読了記録のない今回の読書
+	if book.ReadStartedAt != nil && book.Status != "completed" {
+		history = append(history, StatusChange{Status: "reading", At: *book.ReadStartedAt, Cycle: book.ReadCycle})
+	}
+	for _, j := range insults {
+		if j.SentAt != nil {
+			history = append(history, StatusChange{Status: "insulted", At: *j.SentAt})
+		}
+	}
+	if book.AbandonedAt != nil {
+		history = append(history, StatusChange{Status: statusAbandoned, At: *book.AbandonedAt})
+	}
+	slices.SortStableFunc(history, func(a, b StatusChange) int { return cmp.Compare(a.At.UnixNano(), b.At.UnixNano()) })
+	return history
+}
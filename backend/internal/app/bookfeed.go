@@ -0,0 +1,104 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"time"
+)
+
+// API を通らない書籍の変更 (フロントの Supabase クライアント・ダッシュボード・SQL) を拾うチェンジフィード。
+// books のトリガー (supabase/schema.sql の notify_book_change) が book_changes チャンネルに NOTIFY し、
+// それを LISTEN してドメインイベントとして発行するので、キャッシュ・SSE・実績などが API 経由の変更と同じように追従する。
+// API 自身の書き込みはトリガー側で除いている。BOOK_CHANGE_FEED=true と DATABASE_URL、-tags pgx のビルドが必要。
+const (
+	bookChangesChannel = "book_changes"
+
+	bookFeedRetryBase = time.Second
+	bookFeedRetryMax  = time.Minute
+)
+
+// errBookFeedUnsupported は pgx なしでビルドしたときに listenBookChanges が返す
+var errBookFeedUnsupported = errors.New("book change feed needs a build with -tags pgx")
+
+// bookChange は notify_book_change が送るペイロード
+type bookChange struct {
+	Op        string  `json:"op"` // INSERT, UPDATE, DELETE
+	BookID    string  `json:"book_id"`
+	UserID    string  `json:"user_id"`
+	OldStatus *string `json:"old_status"`
+	Status    *string `json:"status"`
+}
+
+// eventType は変更に対応するドメインイベント。読了に変わった更新は book.completed にする。
+func (c bookChange) eventType() string {
+	switch c.Op {
+	case "INSERT":
+		return eventBookCreated
+	case "DELETE":
+		return "book.deleted"
+	}
+	if c.Status != nil && *c.Status == "completed" && (c.OldStatus == nil || *c.OldStatus != "completed") {
+		return eventBookCompleted
+	}
+	return "book.updated"
+}
+
+// startBookChangeFeed は LISTEN を続ける goroutine を起動する。接続が切れたら間隔を延ばしながらつなぎ直す。
+func startBookChangeFeed() {
+	if os.Getenv("BOOK_CHANGE_FEED") != "true" {
+		return
+	}
+	// pooler のトランザクションモードでは LISTEN できないので、直接接続かセッションモードの URL を使う
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		log.Printf("[ERROR] BOOK_CHANGE_FEED=true but DATABASE_URL is not set, book change feed disabled")
+		return
+	}
+	go func() {
+		wait := bookFeedRetryBase
+		for {
+			started := time.Now()
+			err := listenBookChanges(context.Background(), dsn, handleBookChange)
+			if errors.Is(err, errBookFeedUnsupported) {
+				log.Printf("[ERROR] %v, book change feed disabled", err)
+				return
+			}
+			if time.Since(started) > bookFeedRetryMax {
+				wait = bookFeedRetryBase
+			}
+			// つながっていない間の変更は届かない。キャッシュは TTL で追いつく。
+			log.Printf("[WARNING] book change feed disconnected, reconnecting in %s: %v", wait, err)
+			time.Sleep(wait)
+			wait = min(wait*2, bookFeedRetryMax)
+		}
+	}()
+	log.Printf("[INFO] listening for book changes on %s", bookChangesChannel)
+}
+
+// handleBookChange は NOTIFY のペイロードを BookEvent にして発行する。削除以外は今の行を読み直して付ける。
+func handleBookChange(payload string) {
+	var c bookChange
+	if err := json.Unmarshal([]byte(payload), &c); err != nil || c.BookID == "" || c.UserID == "" {
+		log.Printf("[WARNING] ignoring malformed book change %q: %v", payload, err)
+		return
+	}
+	ev := BookEvent{Type: c.eventType(), BookID: c.BookID, UserID: c.UserID}
+	if c.Op != "DELETE" {
+		book, err := fetchOwnedBook(c.BookID, c.UserID)
+		if errors.Is(err, errBookNotFound) {
+			// 通知が届くまでに消された。削除の通知が後から来る。
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] failed to load changed book %s: %v", c.BookID, err)
+			invalidateBooks(c.UserID)
+			return
+		}
+		ev.Book = &book
+	}
+	log.Printf("[DEBUG] external book change %s for book %s", ev.Type, ev.BookID)
+	emitBookEvent(ev)
+}
@@ -0,0 +1,9 @@
+//go:build !pgx
+
+package app
+
+import "context"
+
+func listenBookChanges(ctx context.Context, dsn string, fn func(payload string)) error {
+	return errBookFeedUnsupported
+}
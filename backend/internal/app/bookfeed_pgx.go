@@ -0,0 +1,29 @@
+//go:build pgx
+
+package app
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// listenBookChanges は専用の接続で book_changes を LISTEN し、届いたペイロードを fn に渡す。
+// 接続が切れるか ctx が終わるまで戻らない。
+func listenBookChanges(ctx context.Context, dsn string, fn func(payload string)) error {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+	if _, err := conn.Exec(ctx, "LISTEN "+bookChangesChannel); err != nil {
+		return err
+	}
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		fn(n.Payload)
+	}
+}
@@ -0,0 +1,229 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	multicastBatchSize   = 500 // LINE multicast の宛先上限
+	maxBroadcastLength   = 5000
+	broadcastModeAuto    = ""
+	broadcastModeLINE    = "broadcast"
+	broadcastModeBatched = "multicast"
+)
+
+// BroadcastFilter は配信対象の絞り込み。全て省略すればお知らせを受け取る全ユーザー。
+type BroadcastFilter struct {
+	UserIDs          []string `json:"user_ids"`
+	HasOverdue       bool     `json:"has_overdue"`        // 期限切れの積読がある
+	ActiveWithinDays int      `json:"active_within_days"` // この日数以内に本を登録・更新した
+}
+
+func (f BroadcastFilter) empty() bool {
+	return len(f.UserIDs) == 0 && !f.HasOverdue && f.ActiveWithinDays == 0
+}
+
+// broadcastAudience は ch の友だちのうち、お知らせを受け取る (opt-out しておらずブロックもしていない) ユーザーの LINE ID を返す
+func broadcastAudience(f BroadcastFilter, ch LineChannel) ([]string, error) {
+	q := supabaseClient.From("users").
+		Select("id, line_user_id", "", false).
+		Eq("announcements_opt_in", "true").
+		Is("line_blocked_at", "null")
+	// LINE のユーザーIDはチャネルごとに違うので、送るチャネルの友だちだけに絞る
+	if ch.Name == defaultLineChannel {
+		q = q.Or("line_channel.is.null,line_channel.eq."+defaultLineChannel, "")
+	} else {
+		q = q.Eq("line_channel", ch.Name)
+	}
+	if len(f.UserIDs) > 0 {
+		q = q.In("id", f.UserIDs)
+	}
+	resp, _, err := execute(q)
+	if err != nil {
+		return nil, err
+	}
+	var users []struct {
+		ID         string `json:"id"`
+		LineUserID string `json:"line_user_id"`
+	}
+	if err := json.Unmarshal(resp, &users); err != nil {
+		return nil, err
+	}
+
+	keep := func(string) bool { return true }
+	if f.HasOverdue || f.ActiveWithinDays > 0 {
+		bq := supabaseClient.From("books").Select("user_id", "", false)
+		if f.HasOverdue {
+			bq = bq.In("status", activeStatuses).Lt("deadline", clock.Now().Format(time.RFC3339))
+		}
+		if f.ActiveWithinDays > 0 {
+			bq = bq.Gte("updated_at", clock.Now().AddDate(0, 0, -f.ActiveWithinDays).Format(time.RFC3339))
+		}
+		bResp, _, err := execute(bq)
+		if err != nil {
+			return nil, err
+		}
+		var books []Book
+		json.Unmarshal(bResp, &books)
+		matched := make(map[string]bool, len(books))
+		for _, b := range books {
+			matched[b.UserID] = true
+		}
+		keep = func(id string) bool { return matched[id] }
+	}
+
+	ids := make([]string, 0, len(users))
+	for _, u := range users {
+		if u.LineUserID != "" && keep(u.ID) {
+			ids = append(ids, u.LineUserID)
+		}
+	}
+	return ids, nil
+}
+
+// handleBroadcast は POST /api/admin/broadcast。メンテナンスや新機能のお知らせを送る。
+// mode=broadcast は LINE の一斉配信 (友だち全員に届き opt-out を反映できない) で、絞り込みと併用できない。
+// 既定は multicast で、お知らせを受け取るユーザーに 500 人ずつ送る。dry_run なら宛先数だけ返す。
+func handleBroadcast(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Message string          `json:"message"`
+		Mode    string          `json:"mode"`
+		DryRun  bool            `json:"dry_run"`
+		Filter  BroadcastFilter `json:"filter"`
+		Channel string          `json:"channel"` // 送る LINE チャネル。空なら default
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Message == "" || len([]rune(req.Message)) > maxBroadcastLength {
+		http.Error(w, fmt.Sprintf("message must be 1-%d characters", maxBroadcastLength), http.StatusBadRequest)
+		return
+	}
+	ch, err := lineChannelByName(req.Channel)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch req.Mode {
+	case broadcastModeAuto:
+		req.Mode = broadcastModeBatched
+	case broadcastModeBatched:
+	case broadcastModeLINE:
+		if !req.Filter.empty() {
+			http.Error(w, "filter cannot be used with mode=broadcast", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "mode must be broadcast or multicast", http.StatusBadRequest)
+		return
+	}
+
+	var audience []string
+	if req.Mode == broadcastModeBatched {
+		if audience, err = broadcastAudience(req.Filter, ch); err != nil {
+			log.Printf("[ERROR] handleBroadcast audience error: %v", err)
+			http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	result := map[string]interface{}{"mode": req.Mode, "channel": ch.Name, "dry_run": req.DryRun, "recipients": len(audience)}
+	if req.Mode == broadcastModeLINE {
+		result["recipients"] = "all friends"
+	}
+	if req.DryRun {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	messages := []LineMessage{LineTextMessage{Type: "text", Text: req.Message}}
+	sent := 0
+	var sendErr error
+	if req.Mode == broadcastModeLINE {
+		body, _ := json.Marshal(LineBroadcastRequest{Messages: messages})
+		_, sendErr = lineChannelAPI(ch, http.MethodPost, lineAPIBase+"/message/broadcast", "application/json", bytes.NewReader(body))
+	} else {
+		for start := 0; start < len(audience) && sendErr == nil; start += multicastBatchSize {
+			end := min(start+multicastBatchSize, len(audience))
+			body, _ := json.Marshal(LineMulticastRequest{To: audience[start:end], Messages: messages})
+			if _, sendErr = lineChannelAPI(ch, http.MethodPost, lineAPIBase+"/message/multicast", "application/json", bytes.NewReader(body)); sendErr == nil {
+				sent = end
+			}
+		}
+	}
+	appCache.Delete(quotaCacheKey)
+
+	if _, _, err := execute(supabaseClient.From("admin_broadcasts").Insert(map[string]interface{}{
+		"message":    req.Message,
+		"mode":       req.Mode,
+		"filter":     req.Filter,
+		"recipients": sent,
+		"error":      errorString(sendErr),
+	}, false, "", "minimal", "")); err != nil {
+		log.Printf("[ERROR] handleBroadcast failed to record broadcast: %v", err)
+	}
+
+	if sendErr != nil {
+		log.Printf("[ERROR] handleBroadcast send error after %d recipients: %v", sent, sendErr)
+		http.Error(w, fmt.Sprintf("failed after sending to %d users: %v", sent, sendErr), http.StatusBadGateway)
+		return
+	}
+	log.Printf("[INFO] broadcast sent (%s) to %v", req.Mode, result["recipients"])
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func errorString(err error) interface{} {
+	if err == nil {
+		return nil
+	}
+	return err.Error()
+}
+
+// handleAnnouncementsOptIn は PUT /api/users/announcements。運営からのお知らせを受け取るかを切り替える。
+func handleAnnouncementsOptIn(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID string `json:"user_id"`
+		OptIn  bool   `json:"opt_in"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	resp, _, err := execute(supabaseClient.From("users").
+		Update(map[string]interface{}{"announcements_opt_in": req.OptIn, "updated_at": clock.Now()}, "", "").
+		Eq("id", req.UserID))
+	if err != nil {
+		log.Printf("[ERROR] handleAnnouncementsOptIn update error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to update settings: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var users []map[string]interface{}
+	if json.Unmarshal(resp, &users); len(users) == 0 {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Announcement settings updated", "opt_in": req.OptIn})
+}
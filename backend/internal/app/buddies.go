@@ -0,0 +1,422 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+// 読書仲間 (同じ ISBN・同じタグの本を読んでいる2人) の通知。進捗の中継は本ごとに1日1通まで (jobDedupeKey)。
+const (
+	jobKindBuddyMatch    = "buddy_match"
+	jobKindBuddyProgress = "buddy_progress"
+)
+
+const (
+	buddyStatusWaiting = "waiting"
+	buddyStatusMatched = "matched"
+	buddyStatusEnded   = "ended"
+)
+
+// 一度に見る相手候補の数。先に取られていたら次の候補を試す。
+const buddyCandidateLimit = 5
+
+// ReadingBuddy は本ごとの読書仲間の申し込み (reading_buddies)。組になった2行は partner_entry_id で互いを指す。
+type ReadingBuddy struct {
+	EntryID        string     `json:"entry_id"`
+	UserID         string     `json:"user_id"`
+	BookID         string     `json:"book_id"`
+	MatchKey       string     `json:"match_key"`   // "isbn:9784..." または "tag:" + tagKey
+	MatchLabel     string     `json:"match_label"` // 通知に出す表記 (ISBN なら空)
+	Status         string     `json:"status"`      // waiting, matched, ended
+	PartnerEntryID *string    `json:"partner_entry_id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	MatchedAt      *time.Time `json:"matched_at"`
+	EndedAt        *time.Time `json:"ended_at"`
+}
+
+// subject は相手の本を匿名のまま指す言い方。ISBN で組んだなら同じ本、タグなら同じタグの本。
+func (e ReadingBuddy) subject() string {
+	if strings.HasPrefix(e.MatchKey, "tag:") {
+		return fmt.Sprintf("「%s」の本", e.MatchLabel)
+	}
+	return "同じ本"
+}
+
+// buddyProgressLabel は進捗を「45%」または「120ページ」の形で返す (分量が未設定の本は割合を出せない)
+func buddyProgressLabel(b Book) string {
+	if b.totalUnits() > 0 {
+		return fmt.Sprintf("%.0f%%", b.progressPercent())
+	}
+	if b.isTimeBased() {
+		return fmt.Sprintf("%d分", b.Progress)
+	}
+	return fmt.Sprintf("%dページ", b.Progress)
+}
+
+// bookISBN は注文履歴の取り込みで分かっている本の ISBN を返す。分からなければ空文字。
+func bookISBN(bookID string) (string, error) {
+	resp, _, err := execute(supabaseClient.From("import_drafts").Select("isbn", "", false).
+		Eq("book_id", bookID).Eq("status", "confirmed").Not("isbn", "is", "null").Limit(1, ""))
+	if err != nil {
+		return "", err
+	}
+	var rows []struct {
+		ISBN string `json:"isbn"`
+	}
+	if err := json.Unmarshal(resp, &rows); err != nil || len(rows) == 0 {
+		return "", err
+	}
+	return rows[0].ISBN, nil
+}
+
+// buddyMatchKey は申し込みの内容から組み合わせのキーと表記を決める。
+// タグは本に付いているものに限る。どちらも指定がなければ取り込み時の ISBN を使う。
+func buddyMatchKey(book Book, isbn, tag string) (key, label string, err error) {
+	if tag != "" {
+		for _, t := range book.Tags {
+			if tagKey(t) == tagKey(tag) {
+				return "tag:" + tagKey(t), t, nil
+			}
+		}
+		return "", "", fmt.Errorf("tag %q is not on this book", tag)
+	}
+	if isbn == "" {
+		known, err := bookISBN(book.BookID)
+		if err != nil {
+			return "", "", err
+		}
+		if known == "" {
+			return "", "", fmt.Errorf("isbn or tag required (no ISBN is known for this book)")
+		}
+		isbn = known
+	}
+	normalized, ok := normalizeISBN(isbn)
+	if !ok || !validISBN(normalized) {
+		return "", "", fmt.Errorf("invalid isbn")
+	}
+	return "isbn:" + normalized, "", nil
+}
+
+func decodeBuddies(resp []byte) ([]ReadingBuddy, error) {
+	var rows []ReadingBuddy
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// activeBuddyEntry は本の待機中または組んでいる申し込みを返す。なければ nil。
+func activeBuddyEntry(bookID string) (*ReadingBuddy, error) {
+	resp, _, err := execute(supabaseClient.From("reading_buddies").Select("*", "", false).
+		Eq("book_id", bookID).In("status", []string{buddyStatusWaiting, buddyStatusMatched}))
+	if err != nil {
+		return nil, err
+	}
+	rows, err := decodeBuddies(resp)
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	return &rows[0], nil
+}
+
+// buddyPartner は組んでいる相手の申し込みを返す。相手の本が削除されていれば nil。
+func buddyPartner(entry ReadingBuddy) (*ReadingBuddy, error) {
+	if entry.Status != buddyStatusMatched || entry.PartnerEntryID == nil {
+		return nil, nil
+	}
+	resp, _, err := execute(supabaseClient.From("reading_buddies").Select("*", "", false).
+		Eq("entry_id", *entry.PartnerEntryID).Eq("status", buddyStatusMatched))
+	if err != nil {
+		return nil, err
+	}
+	rows, err := decodeBuddies(resp)
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	return &rows[0], nil
+}
+
+// claimBuddyEntry は待機中の申し込みを partnerID と組んだことにする。先に他の人と組んでいれば false。
+func claimBuddyEntry(entryID, partnerID string) bool {
+	resp, _, err := execute(supabaseClient.From("reading_buddies").Update(map[string]interface{}{
+		"status":           buddyStatusMatched,
+		"partner_entry_id": partnerID,
+		"matched_at":       clock.Now(),
+	}, "", "").Eq("entry_id", entryID).Eq("status", buddyStatusWaiting))
+	if err != nil {
+		log.Printf("[ERROR] claimBuddyEntry %s: %v", entryID, err)
+		return false
+	}
+	rows, err := decodeBuddies(resp)
+	return err == nil && len(rows) > 0
+}
+
+// requeueBuddyEntry は相手がいなくなった申し込みを待機中に戻す
+func requeueBuddyEntry(entryID string) error {
+	_, _, err := execute(supabaseClient.From("reading_buddies").Update(map[string]interface{}{
+		"status":           buddyStatusWaiting,
+		"partner_entry_id": nil,
+		"matched_at":       nil,
+	}, "", "").Eq("entry_id", entryID))
+	return err
+}
+
+// matchBuddy は同じキーで一番長く待っている他のユーザーと組ませる。相手がいなければ nil (待機中のまま)。
+// 相手を先に押さえてから自分を押さえ、自分が先に取られていたら相手を戻す。
+func matchBuddy(entry ReadingBuddy) (*ReadingBuddy, error) {
+	resp, _, err := execute(supabaseClient.From("reading_buddies").Select("*", "", false).
+		Eq("match_key", entry.MatchKey).Eq("status", buddyStatusWaiting).
+		Neq("user_id", entry.UserID).
+		Order("created_at", &postgrest.OrderOpts{Ascending: true}).Limit(buddyCandidateLimit, ""))
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := decodeBuddies(resp)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range candidates {
+		if !claimBuddyEntry(c.EntryID, entry.EntryID) {
+			continue
+		}
+		if !claimBuddyEntry(entry.EntryID, c.EntryID) {
+			if err := requeueBuddyEntry(c.EntryID); err != nil {
+				log.Printf("[ERROR] failed to release buddy entry %s: %v", c.EntryID, err)
+			}
+			return nil, nil
+		}
+		return &c, nil
+	}
+	return nil, nil
+}
+
+// notifyBuddy は申し込んだ本人に LINE で知らせる。LINE に届かない人には送らない。
+func notifyBuddy(entry ReadingBuddy, kind, message string) {
+	settings, err := fetchNotifySettings(entry.UserID)
+	if err != nil {
+		log.Printf("[ERROR] failed to fetch notify settings for buddy %s: %v", entry.UserID, err)
+		return
+	}
+	if settings == nil || settings.lineUserID() == "" {
+		return
+	}
+	if err := enqueueJob(NotificationJob{Kind: kind, BookID: entry.BookID, UserID: entry.UserID, LineUserID: settings.lineUserID(), Message: message, RunAt: clock.Now()}); err != nil {
+		log.Printf("[ERROR] failed to enqueue %s for user %s: %v", kind, entry.UserID, err)
+	}
+}
+
+// endBuddyEntry は申し込みを終える。組んでいた相手には partnerMessage を送り、新しい相手を待つ状態に戻す。
+func endBuddyEntry(entry ReadingBuddy, partnerMessage string) error {
+	partner, err := buddyPartner(entry)
+	if err != nil {
+		return err
+	}
+	if _, _, err := execute(supabaseClient.From("reading_buddies").Update(map[string]interface{}{
+		"status":   buddyStatusEnded,
+		"ended_at": clock.Now(),
+	}, "", "").Eq("entry_id", entry.EntryID)); err != nil {
+		return err
+	}
+	if partner == nil {
+		return nil
+	}
+	if err := requeueBuddyEntry(partner.EntryID); err != nil {
+		return err
+	}
+	notifyBuddy(*partner, jobKindBuddyMatch, partnerMessage)
+	return nil
+}
+
+// relayBuddyProgress は progress.logged の購読者。組んでいる相手に、名前を伏せて進み具合を知らせる。
+func relayBuddyProgress(ev BookEvent) {
+	if ev.Book == nil {
+		return
+	}
+	entry, err := activeBuddyEntry(ev.BookID)
+	if err != nil {
+		log.Printf("[ERROR] failed to fetch buddy entry for book %s: %v", ev.BookID, err)
+		return
+	}
+	if entry == nil {
+		return
+	}
+	partner, err := buddyPartner(*entry)
+	if err != nil || partner == nil {
+		if err != nil {
+			log.Printf("[ERROR] failed to fetch buddy partner for book %s: %v", ev.BookID, err)
+		}
+		return
+	}
+	theirs, err := fetchOwnedBook(partner.BookID, partner.UserID)
+	if err != nil {
+		log.Printf("[ERROR] failed to fetch buddy book %s: %v", partner.BookID, err)
+		return
+	}
+	notifyBuddy(*partner, jobKindBuddyProgress, fmt.Sprintf("👀 読書仲間が%sを%sまで読み進めました。あなたの『%s』は%sです。置いていかれていませんか?",
+		partner.subject(), buddyProgressLabel(*ev.Book), theirs.Title, buddyProgressLabel(theirs)))
+}
+
+// finishBuddy は book.completed の購読者。待機中なら取り下げ、組んでいれば相手に読み終えたことを伝えて外れる。
+func finishBuddy(ev BookEvent) {
+	entry, err := activeBuddyEntry(ev.BookID)
+	if err != nil {
+		log.Printf("[ERROR] failed to fetch buddy entry for book %s: %v", ev.BookID, err)
+		return
+	}
+	if entry == nil {
+		return
+	}
+	msg := fmt.Sprintf("🏁 読書仲間が%sを読み終えました。次はあなたの番です。新しい仲間が見つかったらお知らせします。", entry.subject())
+	if err := endBuddyEntry(*entry, msg); err != nil {
+		log.Printf("[ERROR] failed to end buddy entry %s: %v", entry.EntryID, err)
+	}
+}
+
+// buddyView は本人に返す申し込みの状態。相手のユーザーや本は明かさず、進み具合だけを載せる。
+func buddyView(entry ReadingBuddy) (map[string]interface{}, error) {
+	view := map[string]interface{}{
+		"entry_id":    entry.EntryID,
+		"match_key":   entry.MatchKey,
+		"match_label": entry.MatchLabel,
+		"status":      entry.Status,
+		"created_at":  entry.CreatedAt,
+		"matched_at":  entry.MatchedAt,
+		"partner":     nil,
+	}
+	partner, err := buddyPartner(entry)
+	if err != nil || partner == nil {
+		return view, err
+	}
+	theirs, err := fetchOwnedBook(partner.BookID, partner.UserID)
+	if err != nil {
+		return view, err
+	}
+	view["partner"] = map[string]interface{}{
+		"progress":         theirs.Progress,
+		"unit":             theirs.progressUnit(),
+		"progress_percent": theirs.progressPercent(),
+		"completed":        theirs.Status == "completed",
+	}
+	return view, nil
+}
+
+// handleReadingBuddy は /api/books/{id}/buddy。
+// POST {user_id, isbn?, tag?} で仲間探しに申し込み、GET ?userId=... で状態を見て、DELETE {user_id} で抜ける。
+func handleReadingBuddy(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID string `json:"user_id"`
+		ISBN   string `json:"isbn"`
+		Tag    string `json:"tag"`
+	}
+	switch r.Method {
+	case http.MethodGet:
+		req.UserID = r.URL.Query().Get("userId")
+	case http.MethodPost, http.MethodDelete:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	book, err := fetchOwnedBook(r.PathValue("id"), req.UserID)
+	if err != nil {
+		writeBookLookupError(w, err)
+		return
+	}
+	entry, err := activeBuddyEntry(book.BookID)
+	if err != nil {
+		log.Printf("[ERROR] handleReadingBuddy query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch reading buddy: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+		if entry == nil {
+			http.Error(w, "Reading buddy not found", http.StatusNotFound)
+			return
+		}
+	case http.MethodDelete:
+		if entry == nil {
+			http.Error(w, "Reading buddy not found", http.StatusNotFound)
+			return
+		}
+		if err := endBuddyEntry(*entry, fmt.Sprintf("👋 読書仲間が%sの仲間探しをやめました。新しい仲間が見つかったらお知らせします。", entry.subject())); err != nil {
+			log.Printf("[ERROR] handleReadingBuddy end error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to leave reading buddy: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Left reading buddy"})
+		return
+	case http.MethodPost:
+		if entry != nil {
+			http.Error(w, "This book is already looking for or paired with a buddy", http.StatusConflict)
+			return
+		}
+		if book.Status == "completed" || book.Status == statusAbandoned || book.Status == statusWishlist {
+			http.Error(w, "Only books you are still reading can have a buddy", http.StatusConflict)
+			return
+		}
+		key, label, err := buddyMatchKey(book, req.ISBN, strings.TrimSpace(req.Tag))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, _, err := executeOnce(supabaseClient.From("reading_buddies").Insert(map[string]interface{}{
+			"user_id":     book.UserID,
+			"book_id":     book.BookID,
+			"match_key":   key,
+			"match_label": label,
+			"status":      buddyStatusWaiting,
+		}, false, "", "", ""))
+		if err != nil {
+			if strings.Contains(err.Error(), "23505") {
+				http.Error(w, "This book is already looking for or paired with a buddy", http.StatusConflict)
+				return
+			}
+			log.Printf("[ERROR] handleReadingBuddy insert error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to join reading buddy: %v", err), http.StatusInternalServerError)
+			return
+		}
+		rows, err := decodeBuddies(resp)
+		if err != nil || len(rows) == 0 {
+			http.Error(w, "failed to join reading buddy", http.StatusInternalServerError)
+			return
+		}
+		entry = &rows[0]
+		partner, err := matchBuddy(*entry)
+		if err != nil {
+			// 申し込みは残っているので、次に申し込んだ人と組める
+			log.Printf("[WARNING] failed to match reading buddy for book %s: %v", book.BookID, err)
+		}
+		if partner != nil {
+			log.Printf("[INFO] paired reading buddies on %s (entries %s, %s)", key, entry.EntryID, partner.EntryID)
+			msg := fmt.Sprintf("🤝 %sを読んでいる読書仲間が見つかりました。名前は伏せたまま、お互いの進み具合をお知らせします。負けずに読み進めましょう。", entry.subject())
+			notifyBuddy(*entry, jobKindBuddyMatch, msg)
+			notifyBuddy(*partner, jobKindBuddyMatch, msg)
+			if fresh, err := activeBuddyEntry(book.BookID); err == nil && fresh != nil {
+				entry = fresh
+			}
+		}
+		status = http.StatusCreated
+	}
+
+	view, err := buddyView(*entry)
+	if err != nil && !errors.Is(err, errBookNotFound) {
+		log.Printf("[WARNING] failed to load buddy partner for book %s: %v", book.BookID, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(view)
+}
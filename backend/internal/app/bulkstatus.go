@@ -0,0 +1,155 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+const maxBulkStatusItems = 100
+
+// bulkTargetStatuses は一括変更で指定できるステータス
+var bulkTargetStatuses = map[string]bool{"unread": true, "reading": true, "completed": true, statusAbandoned: true}
+
+// BulkStatusResult は一括変更の1冊分の結果。失敗した本は Error に理由が入る。
+type BulkStatusResult struct {
+	BookID string `json:"book_id"`
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// checkStatusTransition は一括変更で許す遷移かを判定する。
+// 専用の手続きがある遷移 (購入・復活・再読) はそちらに誘導する。
+func checkStatusTransition(from, to string) error {
+	switch {
+	case from == to:
+		return fmt.Errorf("already %s", to)
+	case from == statusWishlist:
+		return fmt.Errorf("wishlist books must be purchased first")
+	case from == statusWaiting:
+		return fmt.Errorf("waiting books must be marked as arrived first")
+	case from == statusAbandoned:
+		return fmt.Errorf("abandoned books must be revived first")
+	case from == "completed":
+		return fmt.Errorf("completed books must be re-read instead")
+	}
+	return nil
+}
+
+// handleBulkStatus は PATCH /api/books/status。選択した本のステータスをまとめて変更する。
+// 1冊ごとに所有者と遷移を検証し、失敗した本があっても残りは処理する。
+func handleBulkStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID  string   `json:"user_id"`
+		BookIDs []string `json:"book_ids"`
+		Status  string   `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" || len(req.BookIDs) == 0 {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if !bulkTargetStatuses[req.Status] {
+		http.Error(w, fmt.Sprintf("unsupported status %q", req.Status), http.StatusBadRequest)
+		return
+	}
+	if len(req.BookIDs) > maxBulkStatusItems {
+		http.Error(w, fmt.Sprintf("too many books (max %d)", maxBulkStatusItems), http.StatusBadRequest)
+		return
+	}
+
+	resp, _, err := execute(supabaseClient.From("books").Select("*", "", false).Eq("user_id", req.UserID).In("book_id", req.BookIDs))
+	if err != nil {
+		log.Printf("[ERROR] handleBulkStatus query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to update books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var owned []Book
+	if err := json.Unmarshal(resp, &owned); err != nil {
+		log.Printf("[ERROR] handleBulkStatus unmarshal error: %v", err)
+		http.Error(w, "failed to parse books", http.StatusInternalServerError)
+		return
+	}
+	byID := make(map[string]Book, len(owned))
+	for _, b := range owned {
+		byID[b.BookID] = b
+	}
+
+	results := make([]BulkStatusResult, 0, len(req.BookIDs))
+	undo := undoPayload{Status: req.Status}
+	updated := 0
+	seen := make(map[string]bool)
+	for _, id := range req.BookIDs {
+		res := BulkStatusResult{BookID: id}
+		book, ok := byID[id]
+		switch {
+		case seen[id]:
+			res.Error = "duplicate book id"
+		case !ok:
+			res.Error = errBookNotFound.Error()
+		default:
+			if err := checkStatusTransition(book.Status, req.Status); err != nil {
+				res.Error = err.Error()
+			} else if err := applyBookStatus(r, book, req.Status, &undo); err != nil {
+				log.Printf("[ERROR] handleBulkStatus book %s: %v", id, err)
+				res.Error = err.Error()
+			} else {
+				res.Status = req.Status
+				updated++
+			}
+		}
+		seen[id] = true
+		results = append(results, res)
+	}
+
+	var actionID string
+	if updated > 0 {
+		actionID = recordAction(req.UserID, actionBulkStatus, undo)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"updated":   updated,
+		"failed":    len(results) - updated,
+		"results":   results,
+		"action_id": nullIfEmpty(actionID),
+	})
+}
+
+// applyBookStatus は1冊のステータスを変更し、取り消し用に変更前の状態を undo に足す。
+// 読了は連続記録や実績も更新するため completeBook を通す。
+func applyBookStatus(r *http.Request, book Book, status string, undo *undoPayload) error {
+	if status == "completed" {
+		_, snap, err := completeBookUndoable(r.Context(), book.BookID)
+		if err == nil {
+			undo.Completions = append(undo.Completions, snap)
+		}
+		return err
+	}
+
+	now := clock.Now()
+	update := map[string]interface{}{"status": status, "updated_at": now}
+	eventType := "book.updated"
+	if status == statusAbandoned {
+		update["abandoned_at"] = now
+		eventType = "book.abandoned"
+	}
+	rawResp, _, err := execute(supabaseClient.From("books").Update(update, "", "").
+		Eq("book_id", book.BookID).
+		Eq("user_id", book.UserID).
+		Eq("status", book.Status))
+	if err != nil {
+		return err
+	}
+	var rows []Book
+	if err := json.Unmarshal(rawResp, &rows); err == nil && len(rows) == 0 {
+		return fmt.Errorf("status changed concurrently")
+	}
+	undo.Books = append(undo.Books, statusSnapshot{BookID: book.BookID, Status: book.Status, AbandonedAt: book.AbandonedAt})
+	emitBookRows(eventType, rawResp)
+	return nil
+}
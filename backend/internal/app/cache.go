@@ -0,0 +1,241 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	userCacheTTL  = 10 * time.Minute
+	booksCacheTTL = time.Minute
+)
+
+// cacheStore は読み取りの多いデータを Supabase の手前で保持するキャッシュ
+type cacheStore interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration)
+	Delete(keys ...string)
+}
+
+var appCache cacheStore = newMemoryCache()
+
+// initCache は REDIS_URL が設定されていれば Redis を、なければプロセス内キャッシュを使う
+func initCache() {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		return
+	}
+	c, err := newRedisCache(redisURL)
+	if err != nil {
+		log.Printf("[ERROR] invalid REDIS_URL, falling back to in-memory cache: %v", err)
+		return
+	}
+	appCache = c
+	log.Printf("[INFO] using redis cache at %s", c.addr)
+}
+
+func userCacheKey(lineUserID string) string { return "user:line:" + lineUserID }
+func booksCacheKey(userID string) string    { return "books:" + userID }
+
+// invalidateBooks はユーザーの書籍一覧キャッシュを破棄する
+func invalidateBooks(userID string) {
+	if userID != "" {
+		appCache.Delete(booksCacheKey(userID))
+	}
+}
+
+type memoryCacheItem struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+type memoryCache struct {
+	mu    sync.Mutex
+	items map[string]memoryCacheItem
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{items: make(map[string]memoryCacheItem)}
+}
+
+func (c *memoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(item.expiresAt) {
+		delete(c.items, key)
+		return nil, false
+	}
+	return item.value, true
+}
+
+func (c *memoryCache) Set(key string, value []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[key] = memoryCacheItem{value: value, expiresAt: time.Now().Add(ttl)}
+}
+
+func (c *memoryCache) Delete(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range keys {
+		delete(c.items, k)
+	}
+}
+
+// redisCache は複数インスタンス構成向けの最小限の RESP クライアント
+type redisCache struct {
+	mu       sync.Mutex
+	addr     string
+	password string
+	db       int
+	conn     net.Conn
+	reader   *bufio.Reader
+}
+
+func newRedisCache(rawURL string) (*redisCache, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	c := &redisCache{addr: u.Host}
+	if !strings.Contains(c.addr, ":") {
+		c.addr += ":6379"
+	}
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid db number %q", db)
+		}
+	}
+	return c, nil
+}
+
+func (c *redisCache) Get(key string) ([]byte, bool) {
+	reply, err := c.do("GET", key)
+	if err != nil {
+		log.Printf("[ERROR] redis GET %s: %v", key, err)
+		return nil, false
+	}
+	value, ok := reply.([]byte)
+	return value, ok
+}
+
+func (c *redisCache) Set(key string, value []byte, ttl time.Duration) {
+	if _, err := c.do("SET", key, string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10)); err != nil {
+		log.Printf("[ERROR] redis SET %s: %v", key, err)
+	}
+}
+
+func (c *redisCache) Delete(keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	if _, err := c.do("DEL", keys...); err != nil {
+		log.Printf("[ERROR] redis DEL %v: %v", keys, err)
+	}
+}
+
+// do はコマンドを1つ送って応答を読む。エラー時は接続を捨てて次回再接続する。
+func (c *redisCache) do(cmd string, args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := c.roundTrip(cmd, args...)
+	if err != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	return reply, err
+}
+
+func (c *redisCache) connect() error {
+	conn, err := net.DialTimeout("tcp", c.addr, 2*time.Second)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	if c.password != "" {
+		if _, err := c.roundTrip("AUTH", c.password); err != nil {
+			conn.Close()
+			c.conn = nil
+			return err
+		}
+	}
+	if c.db != 0 {
+		if _, err := c.roundTrip("SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			c.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *redisCache) roundTrip(cmd string, args ...string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n$%d\r\n%s\r\n", len(args)+1, len(cmd), cmd)
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+func (c *redisCache) readReply() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty redis reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("redis: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.reader, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	default:
+		return nil, fmt.Errorf("unexpected redis reply %q", line)
+	}
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// 期限切れ後の督促の間隔。本ごとの指定 (books.reminder_cadence) → ユーザーの設定 (users.reminder_cadence)
+// → REMINDER_CADENCE (未設定なら daily) の順に決め、前回届いた督促から間隔が空くまで cron で督促しない。
+const (
+	cadenceDaily      = "daily"
+	cadenceEvery3Days = "every_3_days"
+	cadenceWeekly     = "weekly"
+	cadenceBackoff    = "backoff" // 1日, 2日, 4日…と倍にしていく (最大 maxBackoffInterval)
+	cadenceOnce       = "once"    // 期限切れごとに1回だけ
+
+	maxBackoffInterval = 30 * 24 * time.Hour
+	// cron の実行時刻のずれで1日ずつ遅れないよう、間隔を少しだけ短く見る
+	cadenceSlack = time.Hour
+)
+
+var reminderCadences = []string{cadenceDaily, cadenceEvery3Days, cadenceWeekly, cadenceBackoff, cadenceOnce}
+
+// validReminderCadence は空文字 (指定なし) か既知の間隔なら true
+func validReminderCadence(c string) bool {
+	return c == "" || slices.Contains(reminderCadences, c)
+}
+
+func defaultReminderCadence() string {
+	if c := os.Getenv("REMINDER_CADENCE"); c != "" && validReminderCadence(c) {
+		return c
+	}
+	return cadenceDaily
+}
+
+// resolveCadence は本の指定 → ユーザーの設定 → 既定の順に間隔を決める
+func resolveCadence(bookCadence, userCadence *string) string {
+	if bookCadence != nil && *bookCadence != "" {
+		return *bookCadence
+	}
+	if userCadence != nil && *userCadence != "" {
+		return *userCadence
+	}
+	return defaultReminderCadence()
+}
+
+// cadenceDue は今回の期限切れで sent 回督促し、最後が last だったときに now で次を送ってよいか
+func cadenceDue(cadence string, sent int, last, now time.Time) bool {
+	if sent == 0 {
+		return true
+	}
+	var interval time.Duration
+	switch cadence {
+	case cadenceOnce:
+		return false
+	case cadenceEvery3Days:
+		interval = 3 * 24 * time.Hour
+	case cadenceWeekly:
+		interval = 7 * 24 * time.Hour
+	case cadenceBackoff:
+		interval = 24 * time.Hour
+		for i := 1; i < sent && interval < maxBackoffInterval; i++ {
+			interval *= 2
+		}
+		interval = min(interval, maxBackoffInterval)
+	default:
+		interval = 24 * time.Hour
+	}
+	return !now.Before(last.Add(interval - cadenceSlack))
+}
+
+// insultHistory は since 以降に本ごとに届いた督促の送信日時 (古い順)。シリーズの督促は全巻に数える。
+func insultHistory(userIDs []string, since time.Time) (map[string][]time.Time, error) {
+	history := make(map[string][]time.Time)
+	if len(userIDs) == 0 {
+		return history, nil
+	}
+	resp, _, err := execute(supabaseClient.From("notification_jobs").
+		Select("book_id, book_ids, sent_at", "", false).
+		Eq("kind", jobKindInsult).
+		Eq("status", "sent").
+		In("user_id", userIDs).
+		Gte("sent_at", since.Format(time.RFC3339)).
+		Order("sent_at", &postgrest.OrderOpts{Ascending: true}))
+	if err != nil {
+		return nil, err
+	}
+	var jobs []NotificationJob
+	if err := json.Unmarshal(resp, &jobs); err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		if job.SentAt == nil {
+			continue
+		}
+		for _, id := range job.targetBookIDs() {
+			history[id] = append(history[id], *job.SentAt)
+		}
+	}
+	return history, nil
+}
+
+// insultsSinceDeadline は今回の期限切れ以降に届いた督促の数と最後の日時
+func insultsSinceDeadline(history []time.Time, deadline time.Time) (int, time.Time) {
+	var sent int
+	var last time.Time
+	for _, at := range history {
+		if !at.Before(deadline) {
+			sent++
+			last = at
+		}
+	}
+	return sent, last
+}
@@ -0,0 +1,163 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// 今月の読書の余力。読書ペースで月末までに読める量から、進行中の本を期限までに読むために今月読む必要がある量を引き、
+// あと何冊 (どのくらいの厚さなら) 引き受けられるかを出す。登録時に今月の余力を超える本には警告を付ける。
+// 期限が来月以降の本は、残りを期限までの日数で割った今月分だけを数える。
+
+// capacitySizes は「あと何冊」を数える本の大きさ (ページ / オーディオブックは分)
+var capacitySizes = []struct {
+	label   string
+	pages   int
+	minutes int
+}{
+	{"short", 150, 300},
+	{"medium", 300, 600},
+	{"long", 500, 900},
+}
+
+// CapacitySize は大きさごとに今月あと何冊読めるか
+type CapacitySize struct {
+	Size  string `json:"size"`
+	Units int    `json:"units"`
+	Books int    `json:"books"`
+}
+
+// UnitCapacity はページか分のどちらかの単位での今月の余力
+type UnitCapacity struct {
+	Unit       string         `json:"unit"`      // pages, minutes
+	Available  int            `json:"available"` // 月末までにペースで読める量 (余裕を見込んだ値)
+	Committed  int            `json:"committed"` // 進行中の本のために今月読む必要がある量
+	Free       int            `json:"free"`      // 負なら引き受けすぎ
+	Additional []CapacitySize `json:"additional"`
+}
+
+// ReadingCapacity は今月の余力
+type ReadingCapacity struct {
+	Month    string       `json:"month"`
+	DaysLeft int          `json:"days_left"` // 今日を含む
+	Pace     readingPace  `json:"pace"`
+	Pages    UnitCapacity `json:"pages"`
+	Minutes  UnitCapacity `json:"minutes"`
+}
+
+// monthDaysLeft は now を含めた月末までの日数と、月末 (翌月1日 0時 JST)
+func monthDaysLeft(now time.Time) (int, time.Time) {
+	today := now.In(jst)
+	start := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, jst)
+	monthEnd := time.Date(today.Year(), today.Month()+1, 1, 0, 0, 0, 0, jst)
+	return int(math.Round(monthEnd.Sub(start).Hours() / 24)), monthEnd
+}
+
+// monthlyLoad は book を期限までに読み切るために今月読む必要がある量。分量が分からなければ 0。
+func monthlyLoad(b Book, now time.Time) float64 {
+	remaining := b.totalUnits() - b.Progress
+	if b.totalUnits() == 0 || remaining <= 0 || b.Deadline.IsZero() {
+		return 0
+	}
+	daysLeft, monthEnd := monthDaysLeft(now)
+	if !b.Deadline.After(monthEnd) {
+		return float64(remaining)
+	}
+	return float64(remaining) * float64(daysLeft) / math.Max(1, b.Deadline.Sub(now).Hours()/24)
+}
+
+// readingCapacity は books (アーカイブしていない進行中の本) と pace から今月の余力を求める
+func readingCapacity(books []Book, pace readingPace, now time.Time) ReadingCapacity {
+	daysLeft, _ := monthDaysLeft(now)
+	capacity := ReadingCapacity{Month: now.In(jst).Format("2006-01"), DaysLeft: daysLeft, Pace: pace}
+	var pages, minutes float64
+	for _, b := range books {
+		if b.Archived || !slices.Contains(activeStatuses, b.Status) {
+			continue
+		}
+		if b.isTimeBased() {
+			minutes += monthlyLoad(b, now)
+		} else {
+			pages += monthlyLoad(b, now)
+		}
+	}
+	capacity.Pages = unitCapacity("pages", pace.PagesPerDay*float64(daysLeft)/deadlineBuffer, pages, false)
+	capacity.Minutes = unitCapacity("minutes", pace.MinutesPerDay*float64(daysLeft)/deadlineBuffer, minutes, true)
+	return capacity
+}
+
+func unitCapacity(unit string, available, committed float64, timeBased bool) UnitCapacity {
+	c := UnitCapacity{Unit: unit, Available: int(available), Committed: int(math.Ceil(committed))}
+	c.Free = c.Available - c.Committed
+	for _, s := range capacitySizes {
+		size := s.pages
+		if timeBased {
+			size = s.minutes
+		}
+		c.Additional = append(c.Additional, CapacitySize{Size: s.label, Units: size, Books: max(0, c.Free) / size})
+	}
+	return c
+}
+
+// capacityWarning は登録する本が今月の余力を超えるなら警告を返す
+func capacityWarning(book Book) *Warning {
+	if book.UserID == "" || !slices.Contains(activeStatuses, book.Status) {
+		return nil
+	}
+	now := clock.Now()
+	load := monthlyLoad(book, now)
+	if load == 0 {
+		return nil
+	}
+	books, _, err := loadUserBooks(book.UserID)
+	if err != nil {
+		log.Printf("[WARNING] capacity check failed for user %s: %v", book.UserID, err)
+		return nil
+	}
+	pace, err := userReadingPace(book.UserID)
+	if err != nil {
+		log.Printf("[WARNING] capacity pace lookup failed for user %s: %v", book.UserID, err)
+	}
+	capacity := readingCapacity(books, pace, now)
+	free, unit := capacity.Pages.Free, "ページ"
+	if book.isTimeBased() {
+		free, unit = capacity.Minutes.Free, "分"
+	}
+	if load <= float64(free) {
+		return nil
+	}
+	if free <= 0 {
+		return &Warning{Code: "capacity_exceeded", Message: "今月はもう読める量を超えています。期限を来月以降にするか、ほかの本を先に片付けましょう。"}
+	}
+	return &Warning{Code: "capacity_exceeded", Message: fmt.Sprintf("この本は今月あと%.0f%s必要ですが、今月の余力は%d%sです。", math.Ceil(load), unit, free, unit)}
+}
+
+// handleReadingCapacity は GET /api/plan/capacity?userId=...
+func handleReadingCapacity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+	books, _, err := loadUserBooks(userId)
+	if err != nil {
+		log.Printf("[ERROR] handleReadingCapacity error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	pace, err := userReadingPace(userId)
+	if err != nil {
+		log.Printf("[ERROR] handleReadingCapacity pace error: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(readingCapacity(books, pace, clock.Now()))
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"os"
+	"sync"
+	"unicode"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// 統計カード・読了証の文字は CARD_FONT_PATH の OpenType フォント (TTF/OTF/TTC) で描く。
+// 既定は Debian/Ubuntu の fonts-noto-cjk が置く Noto Sans CJK で、TTC の先頭 (JP) を使うので日本語の書名もそのまま載る。
+// フォントが読めない環境 (開発機など) では 5x7 のビットマップフォント (cardGlyphs) に戻り、英数字だけを描く。
+const defaultCardFontPath = "/usr/share/fonts/opentype/noto/NotoSansCJK-Regular.ttc"
+
+var (
+	cardFontOnce sync.Once
+	cardFont     *opentype.Font
+	// cardFontSizeRatio は大文字の高さを1としたときの文字サイズ。ビットマップの scale と同じ大きさにそろえる。
+	cardFontSizeRatio float64
+)
+
+// loadCardFont はフォントを一度だけ読む。読めなければ nil。
+func loadCardFont() *opentype.Font {
+	cardFontOnce.Do(func() {
+		path := os.Getenv("CARD_FONT_PATH")
+		if path == "" {
+			path = defaultCardFontPath
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("[WARNING] card font %s not available, falling back to the bitmap font: %v", path, err)
+			return
+		}
+		collection, err := opentype.ParseCollection(data)
+		if err != nil {
+			log.Printf("[ERROR] failed to parse card font %s: %v", path, err)
+			return
+		}
+		f, err := collection.Font(0)
+		if err != nil {
+			log.Printf("[ERROR] failed to read card font %s: %v", path, err)
+			return
+		}
+		const probeSize = 100
+		face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: probeSize, DPI: 72})
+		if err != nil {
+			log.Printf("[ERROR] failed to open card font %s: %v", path, err)
+			return
+		}
+		capHeight := float64(face.Metrics().CapHeight) / 64
+		if capHeight <= 0 {
+			capHeight = probeSize * 0.7
+		}
+		cardFont, cardFontSizeRatio = f, probeSize/capHeight
+	})
+	return cardFont
+}
+
+// cardFace は scale 倍のビットマップと大文字の高さ (7*scale) がそろう大きさの面。フォントがなければ nil。
+// opentype の Face は並行に使えないので、描くたびに作る。
+func cardFace(scale int) font.Face {
+	f := loadCardFont()
+	if f == nil {
+		return nil
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: float64(7*scale) * cardFontSizeRatio, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil
+	}
+	return face
+}
+
+// cardCanDraw は face (nil ならビットマップフォント) で r を描けるか
+func cardCanDraw(face font.Face, r rune) bool {
+	if face != nil {
+		_, ok := face.GlyphAdvance(r)
+		return ok && !unicode.IsControl(r)
+	}
+	_, ok := cardGlyphs[unicode.ToUpper(r)]
+	return ok
+}
+
+// drawCardFontText は (x, y) を左上に OpenType フォントで描く。ビットマップと同じく大文字の上端を y にそろえる。
+func drawCardFontText(img draw.Image, face font.Face, s string, x, y, scale int, c color.Color) {
+	d := font.Drawer{Dst: img, Src: image.NewUniform(c), Face: face, Dot: fixed.P(x, y+7*scale)}
+	d.DrawString(s)
+}
@@ -0,0 +1,152 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// books_catalog は ISBN → 書誌の共有キャッシュ。ユーザーをまたいで同じ ISBN を何度も
+// Google Books などに問い合わせないようにする。見つからなかった ISBN も短い期間だけ覚えておく。
+const (
+	defaultCatalogTTLDays = 30
+	catalogNotFoundTTL    = 24 * time.Hour
+	catalogRefreshTimeout = 10 * time.Second
+)
+
+// CatalogEntry は books_catalog の1行
+type CatalogEntry struct {
+	ISBN       string    `json:"isbn"`
+	Title      string    `json:"title"`
+	Author     string    `json:"author"`
+	TitleYomi  string    `json:"title_yomi"`
+	AuthorYomi string    `json:"author_yomi"`
+	PageCount  *int      `json:"page_count"`
+	CoverURL   string    `json:"cover_url"`
+	Source     string    `json:"source"`
+	NotFound   bool      `json:"not_found"`
+	FetchedAt  time.Time `json:"fetched_at"`
+}
+
+func (e CatalogEntry) fresh(now time.Time) bool {
+	ttl := time.Duration(envInt("METADATA_CATALOG_TTL_DAYS", defaultCatalogTTLDays)) * 24 * time.Hour
+	if e.NotFound {
+		ttl = catalogNotFoundTTL
+	}
+	return now.Sub(e.FetchedAt) < ttl
+}
+
+func (e CatalogEntry) suggestions() []Suggestion {
+	if e.NotFound {
+		return nil
+	}
+	return []Suggestion{{Title: e.Title, Author: e.Author, Source: e.Source, ISBN: e.ISBN, PageCount: e.PageCount, CoverURL: e.CoverURL, TitleYomi: e.TitleYomi, AuthorYomi: e.AuthorYomi}}
+}
+
+func fetchCatalogEntry(isbn string) (*CatalogEntry, error) {
+	resp, _, err := execute(supabaseClient.From("books_catalog").Select("*", "", false).Eq("isbn", isbn))
+	if err != nil {
+		return nil, err
+	}
+	var entries []CatalogEntry
+	if err := json.Unmarshal(resp, &entries); err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	return &entries[0], nil
+}
+
+// storeCatalogEntry は取得した書誌 (見つからなければ not_found) を保存する
+func storeCatalogEntry(isbn string, suggestions []Suggestion, now time.Time) CatalogEntry {
+	entry := CatalogEntry{ISBN: isbn, NotFound: len(suggestions) == 0, FetchedAt: now}
+	if len(suggestions) > 0 {
+		s := suggestions[0]
+		entry.Title, entry.Author, entry.PageCount, entry.CoverURL, entry.Source = s.Title, s.Author, s.PageCount, s.CoverURL, s.Source
+		entry.TitleYomi, entry.AuthorYomi = s.TitleYomi, s.AuthorYomi
+	}
+	if _, _, err := execute(supabaseClient.From("books_catalog").Insert(entry, true, "isbn", "minimal", "")); err != nil {
+		log.Printf("[ERROR] failed to store catalog entry %s: %v", isbn, err)
+	}
+	return entry
+}
+
+// catalogSuggestions は books_catalog に新しい書誌があればそれを返し、なければプロバイダーに問い合わせて保存する。
+// プロバイダーが全て失敗したときは期限切れの書誌でも返す。
+func catalogSuggestions(ctx context.Context, isbn string) ([]Suggestion, error) {
+	now := time.Now()
+	cached, err := fetchCatalogEntry(isbn)
+	if err != nil {
+		log.Printf("[WARNING] catalog lookup failed for %s: %v", isbn, err)
+	}
+	if cached != nil && cached.fresh(now) {
+		return cached.suggestions(), nil
+	}
+	suggestions, err := providerSuggestions(ctx, isbn, 1)
+	if err != nil && len(suggestions) == 0 {
+		if cached != nil {
+			log.Printf("[WARNING] metadata providers failed for %s, serving stale catalog entry: %v", isbn, err)
+			return cached.suggestions(), nil
+		}
+		// 問い合わせの失敗は「見つからない」として覚えない
+		return nil, err
+	}
+	storeCatalogEntry(isbn, suggestions, now)
+	return suggestions, nil
+}
+
+// handleCatalogEntry は /api/admin/catalog/{isbn}。
+// GET でキャッシュ中の書誌を返し、DELETE で捨てる。DELETE ?refresh=true ならメモリのキャッシュも通さずに取り直す。
+func handleCatalogEntry(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	isbn, ok := normalizeISBN(r.PathValue("isbn"))
+	if !ok {
+		http.Error(w, "invalid ISBN", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		entry, err := fetchCatalogEntry(isbn)
+		if err != nil {
+			log.Printf("[ERROR] handleCatalogEntry query error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch catalog entry: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if entry == nil {
+			http.Error(w, "Catalog entry not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"entry": entry, "fresh": entry.fresh(time.Now())})
+
+	case http.MethodDelete:
+		if _, _, err := execute(supabaseClient.From("books_catalog").Delete("minimal", "").Eq("isbn", isbn)); err != nil {
+			log.Printf("[ERROR] handleCatalogEntry delete error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to delete catalog entry: %v", err), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[INFO] catalog entry %s busted", isbn)
+		if r.URL.Query().Get("refresh") != "true" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": "Catalog entry deleted"})
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.WithValue(r.Context(), bypassMetadataCache{}, true), catalogRefreshTimeout)
+		defer cancel()
+		suggestions, err := providerSuggestions(ctx, isbn, 1)
+		if err != nil && len(suggestions) == 0 {
+			http.Error(w, fmt.Sprintf("failed to refresh metadata: %v", err), http.StatusBadGateway)
+			return
+		}
+		entry := storeCatalogEntry(isbn, suggestions, time.Now())
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": "Catalog entry refreshed", "entry": entry})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,189 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// 読了証。読了したときに、書名・読了日・期限より何日早かったか (遅れたか) を載せた画像を作り、LINE で画像メッセージとして送る。
+// 督促ばかりだと嫌になるので、読み終えたときにも何か届くようにする。
+// users.completion_certificates を true にしたユーザーだけ (設定は /api/users/notify-channel の certificate)。
+// 画像は統計カード (statscard.go) と同じフォントで描く。CARD_FONT_PATH の日本語フォントがない環境では
+// 書名が英数字だけになるので、書名は一緒に送るテキストでも伝える。
+const (
+	jobKindCertificate = "certificate"
+	certificateTTL     = 30 * 24 * time.Hour // トークから後で開いても見られるように
+	certificateMargin  = 80
+)
+
+// CertificateData は読了証に載せる内容
+type CertificateData struct {
+	Title       string
+	CompletedAt time.Time
+	DaysEarly   int // 負の値は遅れた日数
+	ReadCycle   int
+}
+
+func certificateFor(book Book) (CertificateData, bool) {
+	if book.CompletedAt == nil {
+		return CertificateData{}, false
+	}
+	return CertificateData{Title: book.Title, CompletedAt: *book.CompletedAt, DaysEarly: daysEarly(book.Deadline, *book.CompletedAt), ReadCycle: book.ReadCycle}, true
+}
+
+// cardTitle は書名からフォントで描ける文字だけを残し、幅に収まるよう切り詰める。何も残らなければ空文字。
+// CARD_FONT_PATH の日本語フォントがあれば書名をそのまま、なければ英数字だけを大文字で載せる。
+func cardTitle(title string, scale, width int) string {
+	face := cardFace(scale)
+	if face == nil {
+		title = strings.ToUpper(title)
+	}
+	var b strings.Builder
+	space := false
+	for _, r := range title {
+		if unicode.IsSpace(r) || !cardCanDraw(face, r) {
+			space = b.Len() > 0
+			continue
+		}
+		if space {
+			b.WriteRune(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	s := []rune(b.String())
+	for len(s) > 0 && textWidth(string(s), scale) > width {
+		s = s[:len(s)-1]
+	}
+	return strings.TrimSpace(string(s))
+}
+
+// timingLabel は期限との差の表記 (画像用の英語とテキスト用の日本語)
+func (c CertificateData) timingLabel() (en, ja string) {
+	switch {
+	case c.DaysEarly > 0:
+		return fmt.Sprintf("%d DAYS EARLY", c.DaysEarly), fmt.Sprintf("期限より%d日早い読了です。", c.DaysEarly)
+	case c.DaysEarly == 0:
+		return "JUST IN TIME", "期限ぎりぎりの読了です。"
+	default:
+		return fmt.Sprintf("%d DAYS LATE", -c.DaysEarly), fmt.Sprintf("期限から%d日遅れましたが、読み切りました。", -c.DaysEarly)
+	}
+}
+
+// message は画像と一緒に送るテキスト
+func (c CertificateData) message() string {
+	_, timing := c.timingLabel()
+	msg := fmt.Sprintf("🎉 『%s』読了おめでとうございます！%s", c.Title, timing)
+	if c.ReadCycle > 1 {
+		msg += fmt.Sprintf(" (%d回目の読了)", c.ReadCycle)
+	}
+	return msg
+}
+
+// renderCertificate は読了証を 1200x630 の PNG にする
+func renderCertificate(c CertificateData) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, cardWidth, cardHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(cardBackground), image.Point{}, draw.Src)
+	// 金の縁取り
+	border := image.NewUniform(cardAccent)
+	for _, r := range []image.Rectangle{
+		image.Rect(24, 24, cardWidth-24, 36), image.Rect(24, cardHeight-36, cardWidth-24, cardHeight-24),
+		image.Rect(24, 24, 36, cardHeight-24), image.Rect(cardWidth-36, 24, cardWidth-24, cardHeight-24),
+	} {
+		draw.Draw(img, r, border, image.Point{}, draw.Src)
+	}
+
+	center := func(s string, y, scale int, col color.Color) {
+		drawText(img, s, (cardWidth-textWidth(s, scale))/2, y, scale, col)
+	}
+	center("CERTIFICATE OF COMPLETION", 80, 5, cardAccent)
+	center("TSUNDOKU KILLER", 140, 3, cardMuted)
+	if title := cardTitle(c.Title, 7, cardWidth-2*certificateMargin); title != "" {
+		center(title, 230, 7, cardText)
+	} else {
+		center("ONE MORE BOOK CONQUERED", 240, 5, cardText)
+	}
+	timing, _ := c.timingLabel()
+	center(timing, 360, 9, cardAccent)
+	finished := "FINISHED " + c.CompletedAt.In(jst).Format("2006-01-02")
+	if c.ReadCycle > 1 {
+		finished += fmt.Sprintf(" - READ %d", c.ReadCycle)
+	}
+	center(finished, 500, 4, cardText)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sendCompletionCertificate は book.completed の購読者。読了証を作って Storage に置き、画像メッセージのジョブを積む。
+func sendCompletionCertificate(book Book) {
+	c, ok := certificateFor(book)
+	if !ok {
+		return
+	}
+	settings, err := fetchNotifySettings(book.UserID)
+	if err != nil {
+		log.Printf("[ERROR] failed to check certificate setting for user %s: %v", book.UserID, err)
+		return
+	}
+	if settings == nil || !settings.CompletionCertificates {
+		return
+	}
+	lineUserID := settings.lineUserID()
+	if lineUserID == "" {
+		log.Printf("[INFO] skipping certificate for user %s: no deliverable LINE account", book.UserID)
+		return
+	}
+	card, err := renderCertificate(c)
+	if err != nil {
+		log.Printf("[ERROR] failed to render certificate for book %s: %v", book.BookID, err)
+		return
+	}
+	url, err := storeAndSign(fmt.Sprintf("certificates/%s/%s-%d.png", book.UserID, book.BookID, max(c.ReadCycle, 1)), "image/png", card, certificateTTL, "")
+	if err != nil {
+		log.Printf("[ERROR] failed to store certificate for book %s: %v", book.BookID, err)
+		return
+	}
+	if err := enqueueJob(NotificationJob{Kind: jobKindCertificate, BookID: book.BookID, UserID: book.UserID, LineUserID: lineUserID, Message: c.message(), ImageURL: url, RunAt: clock.Now()}); err != nil {
+		log.Printf("[ERROR] failed to enqueue certificate for book %s: %v", book.BookID, err)
+	}
+}
+
+// handleCertificate は GET /api/books/{id}/certificate.png?userId=...。読了済みの本の読了証を返す (シェア用)。
+func handleCertificate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	book, err := fetchOwnedBook(r.PathValue("id"), r.URL.Query().Get("userId"))
+	if err != nil {
+		writeBookLookupError(w, err)
+		return
+	}
+	c, ok := certificateFor(book)
+	if !ok || book.Status != "completed" {
+		http.Error(w, "Book is not completed", http.StatusConflict)
+		return
+	}
+	card, err := renderCertificate(c)
+	if err != nil {
+		log.Printf("[ERROR] handleCertificate render error: %v", err)
+		http.Error(w, "failed to render certificate", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "private, max-age=600")
+	w.Write(card)
+}
@@ -0,0 +1,434 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+const (
+	challengeMaxGoal        = 100
+	challengeListPastDays   = 30 // 終わったチャレンジを一覧に残す日数
+	challengeLeaderboardMax = 100
+)
+
+// Challenge は期間限定の読書チャレンジ (例: 「10月に3冊読み切る」)。管理者が作り、ユーザーが参加する。
+type Challenge struct {
+	ChallengeID string     `json:"challenge_id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	GoalBooks   int        `json:"goal_books"` // 期間中に読了する冊数
+	StartsAt    time.Time  `json:"starts_at"`
+	EndsAt      time.Time  `json:"ends_at"`
+	RewardCode  string     `json:"reward_code"` // 達成で解除する実績のコード ("challenge:<id>"、DB が生成する)
+	FinalizedAt *time.Time `json:"finalized_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// ChallengeParticipant は challenge_participants の1行
+type ChallengeParticipant struct {
+	ChallengeID string     `json:"challenge_id"`
+	UserID      string     `json:"user_id"`
+	Progress    int        `json:"progress"` // 期間中の読了数
+	JoinedAt    time.Time  `json:"joined_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+// challengeRow は参加者の行にチャレンジを埋め込んだもの
+type challengeRow struct {
+	ChallengeParticipant
+	Challenge Challenge `json:"challenges"`
+}
+
+var errChallengeNotFound = errors.New("challenge not found")
+
+func (c Challenge) active(now time.Time) bool {
+	return !now.Before(c.StartsAt) && now.Before(c.EndsAt)
+}
+
+func fetchChallenge(id string) (Challenge, error) {
+	resp, _, err := execute(supabaseClient.From("challenges").Select("*", "", false).Eq("challenge_id", id))
+	if err != nil {
+		return Challenge{}, err
+	}
+	var rows []Challenge
+	if json.Unmarshal(resp, &rows); len(rows) == 0 {
+		return Challenge{}, errChallengeNotFound
+	}
+	return rows[0], nil
+}
+
+// countChallengeCompletions は期間中の読了数を数える (再読も1冊として数える)
+func countChallengeCompletions(userID string, c Challenge) (int, error) {
+	_, n, err := execute(supabaseClient.From("book_completions").
+		Select("completion_id", "exact", true).
+		Eq("user_id", userID).
+		Gte("completed_at", c.StartsAt.Format(time.RFC3339)).
+		Lt("completed_at", c.EndsAt.Format(time.RFC3339)))
+	return int(n), err
+}
+
+// refreshChallengeProgress は1人分の進捗を数え直し、目標に届いたら達成を記録して実績を解除する。
+// 新たに達成したら true を返す。
+func refreshChallengeProgress(p ChallengeParticipant, c Challenge) (bool, error) {
+	progress, err := countChallengeCompletions(p.UserID, c)
+	if err != nil {
+		return false, err
+	}
+	update := map[string]interface{}{"progress": progress}
+	achieved := p.CompletedAt == nil && progress >= c.GoalBooks
+	if achieved {
+		update["completed_at"] = clock.Now()
+	}
+	if _, _, err := execute(supabaseClient.From("challenge_participants").Update(update, "minimal", "").
+		Eq("challenge_id", c.ChallengeID).
+		Eq("user_id", p.UserID)); err != nil {
+		return false, err
+	}
+	if !achieved {
+		return false, nil
+	}
+	if _, err := unlockAchievementsREST(p.UserID, []string{c.RewardCode}); err != nil {
+		log.Printf("[ERROR] failed to unlock challenge reward %s for user %s: %v", c.RewardCode, p.UserID, err)
+	}
+	return true, nil
+}
+
+// updateChallengeProgress は読了のたびに、参加中で開催中のチャレンジの進捗を更新する
+func updateChallengeProgress(userID string) {
+	resp, _, err := execute(supabaseClient.From("challenge_participants").
+		Select("*, challenges(*)", "", false).
+		Eq("user_id", userID).
+		Is("completed_at", "null"))
+	if err != nil {
+		log.Printf("[ERROR] challenge participation query error for user %s: %v", userID, err)
+		return
+	}
+	var rows []challengeRow
+	json.Unmarshal(resp, &rows)
+	now := clock.Now()
+	for _, row := range rows {
+		if !row.Challenge.active(now) {
+			continue
+		}
+		achieved, err := refreshChallengeProgress(row.ChallengeParticipant, row.Challenge)
+		if err != nil {
+			log.Printf("[ERROR] failed to update challenge %s for user %s: %v", row.ChallengeID, userID, err)
+			continue
+		}
+		if achieved {
+			log.Printf("[INFO] user %s completed challenge %s", userID, row.ChallengeID)
+			notifyChallenge(userID, fmt.Sprintf("🏆 チャレンジ「%s」達成！%d冊読み切りました。実績を解除しました。", row.Challenge.Title, row.Challenge.GoalBooks))
+		}
+	}
+}
+
+// notifyChallenge はチャレンジの結果を LINE に送る
+func notifyChallenge(userID, message string) {
+	lineUserID, err := lineUserIDFor(userID)
+	if err != nil || lineUserID == "" {
+		return
+	}
+	if err := enqueueJob(NotificationJob{Kind: jobKindChallenge, UserID: userID, LineUserID: lineUserID, Message: message, RunAt: clock.Now()}); err != nil {
+		log.Printf("[ERROR] failed to enqueue challenge message for user %s: %v", userID, err)
+	}
+}
+
+// handleChallenges は /api/challenges。
+// GET ?userId=... で開催中・開催予定・最近終わったチャレンジを参加状況付きで返す。POST は管理者がチャレンジを作る。
+func handleChallenges(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listChallenges(w, r)
+	case http.MethodPost:
+		createChallenge(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func listChallenges(w http.ResponseWriter, r *http.Request) {
+	since := clock.Now().AddDate(0, 0, -challengeListPastDays)
+	resp, _, err := execute(supabaseClient.From("challenges").
+		Select("*", "", false).
+		Gte("ends_at", since.Format(time.RFC3339)).
+		Order("starts_at", &postgrest.OrderOpts{Ascending: true}))
+	if err != nil {
+		log.Printf("[ERROR] listChallenges query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch challenges: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var challenges []Challenge
+	json.Unmarshal(resp, &challenges)
+
+	joined := make(map[string]ChallengeParticipant)
+	if userId := r.URL.Query().Get("userId"); userId != "" {
+		pResp, _, err := execute(supabaseClient.From("challenge_participants").Select("*", "", false).Eq("user_id", userId))
+		if err != nil {
+			log.Printf("[ERROR] listChallenges participation query error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch challenges: %v", err), http.StatusInternalServerError)
+			return
+		}
+		var rows []ChallengeParticipant
+		json.Unmarshal(pResp, &rows)
+		for _, p := range rows {
+			joined[p.ChallengeID] = p
+		}
+	}
+
+	type challengeView struct {
+		Challenge
+		Participation *ChallengeParticipant `json:"participation"` // 未参加なら null
+	}
+	views := make([]challengeView, 0, len(challenges))
+	for _, c := range challenges {
+		v := challengeView{Challenge: c}
+		if p, ok := joined[c.ChallengeID]; ok {
+			v.Participation = &p
+		}
+		views = append(views, v)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(views)
+}
+
+func createChallenge(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req Challenge
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Title = strings.TrimSpace(req.Title)
+	switch {
+	case req.Title == "":
+		http.Error(w, "title required", http.StatusBadRequest)
+		return
+	case req.GoalBooks < 1 || req.GoalBooks > challengeMaxGoal:
+		http.Error(w, fmt.Sprintf("goal_books must be between 1 and %d", challengeMaxGoal), http.StatusBadRequest)
+		return
+	case !req.EndsAt.After(req.StartsAt):
+		http.Error(w, "ends_at must be after starts_at", http.StatusBadRequest)
+		return
+	}
+
+	resp, _, err := executeOnce(supabaseClient.From("challenges").Insert(map[string]interface{}{
+		"title":       req.Title,
+		"description": strings.TrimSpace(req.Description),
+		"goal_books":  req.GoalBooks,
+		"starts_at":   req.StartsAt,
+		"ends_at":     req.EndsAt,
+	}, false, "", "", ""))
+	var rows []Challenge
+	if err == nil {
+		err = json.Unmarshal(resp, &rows)
+	}
+	if err != nil || len(rows) == 0 {
+		log.Printf("[ERROR] createChallenge insert error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to create challenge: %v", err), http.StatusInternalServerError)
+		return
+	}
+	c := rows[0]
+	log.Printf("[INFO] challenge %s created: %s (%d books)", c.ChallengeID, c.Title, c.GoalBooks)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+// handleChallengeJoin は /api/challenges/{id}/join。POST で参加し、DELETE で抜ける。
+// 参加前に読み終えた本も期間内なら数える。
+func handleChallengeJoin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	c, err := fetchChallenge(r.PathValue("id"))
+	if errors.Is(err, errChallengeNotFound) {
+		http.Error(w, "Challenge not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] handleChallengeJoin query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch challenge: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !clock.Now().Before(c.EndsAt) {
+		http.Error(w, "Challenge has ended", http.StatusConflict)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if _, _, err := execute(supabaseClient.From("challenge_participants").Delete("minimal", "").Eq("challenge_id", c.ChallengeID).Eq("user_id", req.UserID)); err != nil {
+			log.Printf("[ERROR] handleChallengeJoin leave error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to leave challenge: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Left challenge"})
+		return
+	}
+
+	p := ChallengeParticipant{ChallengeID: c.ChallengeID, UserID: req.UserID, JoinedAt: clock.Now()}
+	if _, _, err := executeOnce(supabaseClient.From("challenge_participants").Insert(map[string]interface{}{
+		"challenge_id": c.ChallengeID,
+		"user_id":      req.UserID,
+	}, false, "", "minimal", "")); err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			http.Error(w, "Already joined", http.StatusConflict)
+			return
+		}
+		log.Printf("[ERROR] handleChallengeJoin insert error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to join challenge: %v", err), http.StatusInternalServerError)
+		return
+	}
+	// 参加前の読了で既に目標に届いていれば、その場で達成にする
+	if achieved, err := refreshChallengeProgress(p, c); err != nil {
+		log.Printf("[ERROR] handleChallengeJoin progress error: %v", err)
+	} else if achieved {
+		now := clock.Now()
+		p.CompletedAt = &now
+	}
+	p.Progress, _ = countChallengeCompletions(p.UserID, c)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(p)
+}
+
+// LeaderboardEntry はランキングの1行。user_id は出さず表示名だけにする。
+type LeaderboardEntry struct {
+	Rank        int        `json:"rank"`
+	DisplayName string     `json:"display_name"`
+	Progress    int        `json:"progress"`
+	CompletedAt *time.Time `json:"completed_at"`
+	Me          bool       `json:"me,omitempty"`
+}
+
+// handleChallengeLeaderboard は GET /api/challenges/{id}/leaderboard?userId=...。
+// 読了数の多い順、同数なら先に達成した順。同じ順位は同じ rank にする。
+func handleChallengeLeaderboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	c, err := fetchChallenge(r.PathValue("id"))
+	if errors.Is(err, errChallengeNotFound) {
+		http.Error(w, "Challenge not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] handleChallengeLeaderboard query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch challenge: %v", err), http.StatusInternalServerError)
+		return
+	}
+	resp, _, err := execute(supabaseClient.From("challenge_participants").
+		Select("user_id, progress, completed_at, users(display_name)", "", false).
+		Eq("challenge_id", c.ChallengeID).
+		Order("progress", &postgrest.OrderOpts{Ascending: false}).
+		Order("completed_at", &postgrest.OrderOpts{Ascending: true, NullsFirst: false}).
+		Limit(challengeLeaderboardMax, ""))
+	if err != nil {
+		log.Printf("[ERROR] handleChallengeLeaderboard participants error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch leaderboard: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var rows []struct {
+		ChallengeParticipant
+		User struct {
+			DisplayName string `json:"display_name"`
+		} `json:"users"`
+	}
+	json.Unmarshal(resp, &rows)
+
+	me := r.URL.Query().Get("userId")
+	entries := make([]LeaderboardEntry, 0, len(rows))
+	for i, row := range rows {
+		rank := i + 1
+		if i > 0 && row.Progress == rows[i-1].Progress {
+			rank = entries[i-1].Rank
+		}
+		entries = append(entries, LeaderboardEntry{
+			Rank:        rank,
+			DisplayName: row.User.DisplayName,
+			Progress:    row.Progress,
+			CompletedAt: row.CompletedAt,
+			Me:          me != "" && row.UserID == me,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"challenge": c, "leaderboard": entries})
+}
+
+// handleFinalizeChallenges は /api/cron/challenges。終わったチャレンジの進捗を数え直して確定し、結果を送る。
+func handleFinalizeChallenges(w http.ResponseWriter, r *http.Request) {
+	if !authorizeCron(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	resp, _, err := execute(supabaseClient.From("challenges").
+		Select("*", "", false).
+		Lte("ends_at", clock.Now().Format(time.RFC3339)).
+		Is("finalized_at", "null"))
+	if err != nil {
+		log.Printf("[ERROR] handleFinalizeChallenges query error: %v", err)
+		opsAlerts.recordCron("challenges", err, clock.Now())
+		http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var challenges []Challenge
+	json.Unmarshal(resp, &challenges)
+
+	finalized := 0
+	for _, c := range challenges {
+		pResp, _, err := execute(supabaseClient.From("challenge_participants").Select("*", "", false).Eq("challenge_id", c.ChallengeID))
+		if err != nil {
+			log.Printf("[ERROR] challenge %s participants error: %v", c.ChallengeID, err)
+			continue
+		}
+		var participants []ChallengeParticipant
+		json.Unmarshal(pResp, &participants)
+		achievers := 0
+		for _, p := range participants {
+			// 読了の取り消しなどで途中の値がずれていることがあるので、最後に数え直す
+			if _, err := refreshChallengeProgress(p, c); err != nil {
+				log.Printf("[ERROR] challenge %s final count for user %s: %v", c.ChallengeID, p.UserID, err)
+				continue
+			}
+			progress, _ := countChallengeCompletions(p.UserID, c)
+			if progress >= c.GoalBooks {
+				achievers++
+				notifyChallenge(p.UserID, fmt.Sprintf("チャレンジ「%s」終了。%d冊読了で目標達成です！", c.Title, progress))
+			} else {
+				notifyChallenge(p.UserID, fmt.Sprintf("チャレンジ「%s」終了。%d冊中%d冊でした。次こそは。", c.Title, c.GoalBooks, progress))
+			}
+		}
+		if _, _, err := execute(supabaseClient.From("challenges").Update(map[string]interface{}{"finalized_at": clock.Now()}, "minimal", "").Eq("challenge_id", c.ChallengeID)); err != nil {
+			log.Printf("[ERROR] failed to finalize challenge %s: %v", c.ChallengeID, err)
+			continue
+		}
+		log.Printf("[INFO] challenge %s finalized: %d/%d participants achieved the goal", c.ChallengeID, achievers, len(participants))
+		finalized++
+	}
+
+	opsAlerts.recordCron("challenges", nil, clock.Now())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Challenges finalized", "count": finalized})
+}
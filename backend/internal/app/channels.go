@@ -0,0 +1,166 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLineChannel = "default"
+	lineChannelTTL     = 10 * time.Minute
+)
+
+// LineChannel は1つの LINE 公式アカウント (Messaging API チャネル) の認証情報。
+// default は従来の LINE_CHANNEL_ACCESS_TOKEN / LINE_CHANNEL_SECRET で、
+// LINE_CHANNELS=staging,kansai のように列挙したチャネルは LINE_CHANNEL_ACCESS_TOKEN_STAGING などから読む。
+type LineChannel struct {
+	Name        string
+	AccessToken string
+	Secret      string
+}
+
+// lineChannelByName は名前からチャネルを返す。空文字は default。
+func lineChannelByName(name string) (LineChannel, error) {
+	if name == "" || name == defaultLineChannel {
+		return LineChannel{Name: defaultLineChannel, AccessToken: os.Getenv("LINE_CHANNEL_ACCESS_TOKEN"), Secret: os.Getenv("LINE_CHANNEL_SECRET")}, nil
+	}
+	for _, n := range strings.Split(os.Getenv("LINE_CHANNELS"), ",") {
+		if strings.TrimSpace(n) != name {
+			continue
+		}
+		suffix := "_" + strings.ToUpper(name)
+		return LineChannel{Name: name, AccessToken: os.Getenv("LINE_CHANNEL_ACCESS_TOKEN" + suffix), Secret: os.Getenv("LINE_CHANNEL_SECRET" + suffix)}, nil
+	}
+	return LineChannel{}, fmt.Errorf("unknown LINE channel %q", name)
+}
+
+func lineChannelCacheKey(lineID string) string { return "line:channel:" + lineID }
+
+// lineChannelFor は送信先 (ユーザーまたはグループ) が友だちになっているチャネルを返す。
+// users.line_channel (グループは group_shame_members.line_channel) が未設定なら default。
+func lineChannelFor(lineID string) LineChannel {
+	name := ""
+	if cached, ok := appCache.Get(lineChannelCacheKey(lineID)); ok {
+		name = string(cached)
+	} else {
+		table, column := "users", "line_user_id"
+		// グループIDは C、ユーザーIDは U で始まる
+		if strings.HasPrefix(lineID, "C") {
+			table, column = "group_shame_members", "group_id"
+		}
+		resp, _, err := execute(supabaseClient.From(table).Select("line_channel", "", false).Eq(column, lineID).Limit(1, ""))
+		if err != nil {
+			log.Printf("[ERROR] failed to look up LINE channel for %s: %v", lineID, err)
+		} else {
+			var rows []struct {
+				LineChannel string `json:"line_channel"`
+			}
+			json.Unmarshal(resp, &rows)
+			if len(rows) > 0 {
+				name = rows[0].LineChannel
+			}
+			appCache.Set(lineChannelCacheKey(lineID), []byte(name), lineChannelTTL)
+		}
+	}
+	ch, err := lineChannelByName(name)
+	if err != nil {
+		log.Printf("[WARNING] %v for %s, using default", err, lineID)
+		ch, _ = lineChannelByName(defaultLineChannel)
+	}
+	return ch
+}
+
+// assignLineChannel はユーザーの送信チャネルを記録する (follow したチャネルや管理者の指定)
+func assignLineChannel(lineUserID, channel string) error {
+	_, _, err := execute(supabaseClient.From("users").
+		Update(map[string]interface{}{"line_channel": channel, "updated_at": clock.Now()}, "minimal", "").
+		Eq("line_user_id", lineUserID))
+	appCache.Delete(lineChannelCacheKey(lineUserID))
+	return err
+}
+
+// lineChannelAPI は ch の認証情報で Messaging API を呼ぶ
+func lineChannelAPI(ch LineChannel, method, url, contentType string, body io.Reader) ([]byte, error) {
+	// サンドボックスではメッセージの送信だけを横取りする (クォータやリッチメニューなどはそのまま呼ぶ)
+	if sandboxMode() && method == http.MethodPost && strings.HasPrefix(url, lineAPIBase+"/message/") {
+		return captureLineSend(ch, url, body)
+	}
+	if ch.AccessToken == "" {
+		return nil, fmt.Errorf("access token for LINE channel %q is not set", ch.Name)
+	}
+	if !lineBreaker.allow() {
+		return nil, errLineUnavailable
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+ch.AccessToken)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := lineAPIClient.Do(req)
+	if err != nil {
+		lineBreaker.failure()
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	// 4xx はリクエスト側の問題なので LINE の障害としては数えない
+	if resp.StatusCode >= 500 {
+		lineBreaker.failure()
+	} else {
+		lineBreaker.success()
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, newLineAPIError(method, url, resp, respBody)
+	}
+	return respBody, nil
+}
+
+// handleUserLineChannel は PUT /api/admin/users/{id}/line-channel。ユーザーの送信チャネルを付け替える。
+func handleUserLineChannel(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Channel string `json:"channel"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	ch, err := lineChannelByName(req.Channel)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lineUserID, err := lineUserIDFor(r.PathValue("id"))
+	if err != nil {
+		log.Printf("[ERROR] handleUserLineChannel user lookup error: %v", err)
+		http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if lineUserID == "" {
+		http.Error(w, "User not found or has no LINE account", http.StatusNotFound)
+		return
+	}
+	if err := assignLineChannel(lineUserID, ch.Name); err != nil {
+		log.Printf("[ERROR] handleUserLineChannel update error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to update channel: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "LINE channel updated", "channel": ch.Name})
+}
@@ -0,0 +1,130 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// 「今」の取得。期限・督促・スケジューラーなど日時で動く処理は time.Now() ではなく clock.Now() を使う。
+// QA がステージングで時間を進めて期限切れや督促の流れを確かめられるよう、実時間からのずらし (offset) を持てる。
+// ずらせるのは APP_ENV が production 以外のときだけ (config.go)。起動時の CLOCK_OFFSET (72h など) か
+// /api/admin/clock で変える。セッションやキャッシュの期限、ブレーカーなど基盤側は実時間のまま動く。
+// DB 側の NOW() (created_at の既定値など) もずれないので、created_at で絞る集計は実時間基準になる。
+type Clock interface {
+	Now() time.Time
+}
+
+// offsetClock は実時間に offset を足した時刻を返す
+type offsetClock struct {
+	offset atomic.Int64 // time.Duration
+}
+
+func (c *offsetClock) Now() time.Time {
+	return time.Now().Add(c.Offset())
+}
+
+func (c *offsetClock) Offset() time.Duration {
+	return time.Duration(c.offset.Load())
+}
+
+func (c *offsetClock) SetOffset(d time.Duration) {
+	c.offset.Store(int64(d))
+}
+
+var appClock = &offsetClock{}
+
+// clock はアプリ全体で使う時計。テストでは固定した時刻を返す Clock に差し替えられる。
+var clock Clock = appClock
+
+var errClockLocked = errors.New("clock offset is disabled in production")
+
+// setClockOffset は時計をずらす。production では常に拒否する。
+func setClockOffset(d time.Duration) error {
+	if appEnv() == envProduction {
+		return errClockLocked
+	}
+	appClock.SetOffset(d)
+	log.Printf("[WARNING] clock offset set to %s (now %s)", d, clock.Now().Format(time.RFC3339))
+	return nil
+}
+
+// initClock は起動時に CLOCK_OFFSET を反映する。initConfigProfile の後で呼ぶ。
+func initClock() {
+	v := os.Getenv("CLOCK_OFFSET")
+	if v == "" {
+		return
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("[ERROR] invalid CLOCK_OFFSET %q: %v", v, err)
+		return
+	}
+	if err := setClockOffset(d); err != nil {
+		log.Printf("[ERROR] ignoring CLOCK_OFFSET: %v", err)
+	}
+}
+
+func clockJSON() map[string]interface{} {
+	return map[string]interface{}{
+		"now":            clock.Now().Format(time.RFC3339),
+		"real_now":       time.Now().Format(time.RFC3339),
+		"offset":         appClock.Offset().String(),
+		"offset_seconds": int64(appClock.Offset().Seconds()),
+		"adjustable":     appEnv() != envProduction,
+	}
+}
+
+// handleAdminClock は /api/admin/clock。GET で今の時刻とずらしを返す。
+// PUT {offset: "48h"} か {now: RFC3339} でずらし、{advance: "24h"} で今のずらしからさらに進める。DELETE で実時間に戻す。
+func handleAdminClock(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req struct {
+			Offset  string     `json:"offset"`
+			Advance string     `json:"advance"`
+			Now     *time.Time `json:"now"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		var d time.Duration
+		var err error
+		switch {
+		case req.Now != nil:
+			d = time.Until(*req.Now)
+		case req.Offset != "":
+			d, err = time.ParseDuration(req.Offset)
+		case req.Advance != "":
+			d, err = time.ParseDuration(req.Advance)
+			d += appClock.Offset()
+		default:
+			http.Error(w, "one of offset, advance or now required", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "invalid duration", http.StatusBadRequest)
+			return
+		}
+		if err := setClockOffset(d); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	case http.MethodDelete:
+		if err := setClockOffset(0); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clockJSON())
+}
@@ -0,0 +1,195 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+var (
+	errBookNotFound     = errors.New("book not found")
+	errAlreadyCompleted = errors.New("book is already completed")
+)
+
+// Completion は読了記録 (book_completions テーブル)
+type Completion struct {
+	CompletionID string     `json:"completion_id"`
+	BookID       string     `json:"book_id"`
+	UserID       string     `json:"user_id"`
+	CompletedAt  time.Time  `json:"completed_at"`
+	Deadline     time.Time  `json:"deadline"`
+	DaysEarly    int        `json:"days_early"` // 負の値は期限超過日数
+	ReadCycle    int        `json:"read_cycle"` // 再読なら2以上
+	StartedAt    *time.Time `json:"started_at"`
+}
+
+type completionResult struct {
+	Book         Book       `json:"book"`
+	Completion   Completion `json:"completion"`
+	Streak       int        `json:"streak"`
+	Achievements []string   `json:"achievements"`
+}
+
+// bookRowJSON は RETURNING 句で行全体を JSON として返し、PostgREST と同じ形で Book に読み込むための式
+const bookRowJSON = "to_json(books.*)"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanBook(row rowScanner) (Book, error) {
+	var raw []byte
+	if err := row.Scan(&raw); err != nil {
+		return Book{}, err
+	}
+	var b Book
+	err := json.Unmarshal(raw, &b)
+	return b, err
+}
+
+// daysEarly は期限まで何日残して読了したかを返す (期限超過なら負)
+func daysEarly(deadline, completedAt time.Time) int {
+	return int(math.Floor(deadline.Sub(completedAt).Hours() / 24))
+}
+
+// FinishTiming は読了1回分の所要日数
+type FinishTiming struct {
+	ReadCycle   int       `json:"read_cycle"`
+	CompletedAt time.Time `json:"completed_at"`
+	DaysHeld    int       `json:"days_held"`  // 登録 (再読は読み直し開始) から読了までの日数
+	DaysEarly   int       `json:"days_early"` // 期限より何日早く読了したか (負の値は遅れた日数)
+}
+
+// timing は読了記録から所要日数を出す
+func (c Completion) timing() FinishTiming {
+	held := 0
+	if c.StartedAt != nil {
+		held = max(0, int(c.CompletedAt.Sub(*c.StartedAt).Hours()/24))
+	}
+	return FinishTiming{ReadCycle: c.ReadCycle, CompletedAt: c.CompletedAt, DaysHeld: held, DaysEarly: c.DaysEarly}
+}
+
+// newCompletion は現在の回の読了記録を作る。期限のない本 (読みたいリスト) は complete_book と同じく当日が期限とみなす。
+func newCompletion(book Book, now time.Time) Completion {
+	started := readStartedAt(book)
+	cycle := book.ReadCycle
+	if cycle < 1 {
+		cycle = 1
+	}
+	deadline := book.Deadline
+	if deadline.IsZero() {
+		deadline = now
+	}
+	return Completion{BookID: book.BookID, UserID: book.UserID, CompletedAt: now, Deadline: deadline, DaysEarly: daysEarly(deadline, now), ReadCycle: cycle, StartedAt: &started}
+}
+
+// nextStreak は期限内読了なら連続数を伸ばし、遅れたらリセットする
+func nextStreak(current, early int) int {
+	if early >= 0 {
+		return current + 1
+	}
+	return 0
+}
+
+// completeBook はステータス更新・読了記録・連続記録・実績をまとめて処理する。
+// 直接接続があればアプリ側の1トランザクションで、なければ complete_book (schema.sql) で DB 側の1トランザクションで行う。
+func completeBook(ctx context.Context, bookID string) (*completionResult, error) {
+	var (
+		result *completionResult
+		err    error
+	)
+	if sqlDB != nil {
+		result, err = completeBookTx(ctx, bookID)
+	} else {
+		result, err = completeBookRPC(bookID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	emitBookEvent(BookEvent{Type: eventBookCompleted, BookID: result.Book.BookID, UserID: result.Book.UserID, Book: &result.Book})
+	return result, nil
+}
+
+func completeBookTx(ctx context.Context, bookID string) (*completionResult, error) {
+	result := &completionResult{}
+	err := withTx(ctx, func(tx *sql.Tx) error {
+		book, err := scanBook(tx.QueryRowContext(ctx,
+			`UPDATE books SET status = 'completed', updated_at = NOW() WHERE book_id = $1 AND status <> 'completed' RETURNING `+bookRowJSON,
+			bookID))
+		if err == sql.ErrNoRows {
+			var exists bool
+			if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM books WHERE book_id = $1)`, bookID).Scan(&exists); err != nil {
+				return err
+			}
+			if exists {
+				return errAlreadyCompleted
+			}
+			return errBookNotFound
+		}
+		if err != nil {
+			return err
+		}
+		result.Book = book
+
+		c := newCompletion(book, clock.Now())
+		now := c.CompletedAt
+		if err := tx.QueryRowContext(ctx,
+			`INSERT INTO book_completions (book_id, user_id, completed_at, deadline, days_early, read_cycle, started_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING completion_id`,
+			c.BookID, c.UserID, c.CompletedAt, c.Deadline, c.DaysEarly, c.ReadCycle, c.StartedAt).Scan(&c.CompletionID); err != nil {
+			return fmt.Errorf("insert completion: %w", err)
+		}
+		result.Completion = c
+
+		var current, longest int
+		if err := tx.QueryRowContext(ctx, `SELECT current_streak, longest_streak FROM users WHERE id = $1 FOR UPDATE`, book.UserID).Scan(&current, &longest); err != nil {
+			return fmt.Errorf("load streak: %w", err)
+		}
+		result.Streak = nextStreak(current, c.DaysEarly)
+		if result.Streak > longest {
+			longest = result.Streak
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET current_streak = $2, longest_streak = $3, last_completed_at = $4, updated_at = NOW() WHERE id = $1`,
+			book.UserID, result.Streak, longest, now); err != nil {
+			return fmt.Errorf("update streak: %w", err)
+		}
+
+		var completed int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM book_completions WHERE user_id = $1`, book.UserID).Scan(&completed); err != nil {
+			return err
+		}
+		codes := eligibleAchievements(achievementStats{Completed: completed, Streak: result.Streak, DaysEarly: c.DaysEarly})
+		result.Achievements, err = unlockAchievementsTx(ctx, tx, book.UserID, codes)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// completeBookRPC は complete_book (schema.sql) を呼び、ステータス更新から実績までを DB の1トランザクションで行う。
+// 実績の条件は achievementDefs をそのまま渡す。
+func completeBookRPC(bookID string) (*completionResult, error) {
+	// 読了記録や連続記録を二重に付けないよう再試行しない
+	resp, _, err := executeOnce(rpcQuery{name: "complete_book", args: map[string]interface{}{
+		"p_book_id": bookID, "p_achievements": achievementDefs, "p_now": clock.Now(),
+	}})
+	switch {
+	case err != nil && strings.Contains(err.Error(), "P0002"):
+		return nil, errBookNotFound
+	case err != nil && strings.Contains(err.Error(), "55000"):
+		return nil, errAlreadyCompleted
+	case err != nil:
+		return nil, err
+	}
+	var result completionResult
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const compressionLevel = gzip.DefaultCompression
+
+// acceptedEncoding は Accept-Encoding から gzip / deflate を選ぶ (q=0 は拒否扱い)
+func acceptedEncoding(r *http.Request) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if _, q, ok := strings.Cut(strings.ReplaceAll(params, " ", ""), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		accepted[strings.ToLower(name)] = true
+	}
+	switch {
+	case accepted["gzip"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	}
+	return ""
+}
+
+// compressedResponseWriter は最初に本文を書くときに圧縮するかを決める。304 や 204 などの本文なしはそのまま通す。
+type compressedResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	writer   io.WriteCloser
+	decided  bool
+}
+
+func (cw *compressedResponseWriter) WriteHeader(code int) {
+	if !cw.decided {
+		cw.decided = true
+		h := cw.Header()
+		if code != http.StatusNoContent && code != http.StatusNotModified && h.Get("Content-Encoding") == "" {
+			h.Set("Content-Encoding", cw.encoding)
+			h.Del("Content-Length")
+			if cw.encoding == "gzip" {
+				cw.writer, _ = gzip.NewWriterLevel(cw.ResponseWriter, compressionLevel)
+			} else {
+				// HTTP の deflate は zlib 形式 (RFC 1950) で、生の DEFLATE ではない
+				cw.writer, _ = zlib.NewWriterLevel(cw.ResponseWriter, compressionLevel)
+			}
+		}
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *compressedResponseWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.writer == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.writer.Write(b)
+}
+
+func (cw *compressedResponseWriter) Unwrap() http.ResponseWriter { return cw.ResponseWriter }
+
+func (cw *compressedResponseWriter) close() {
+	if cw.writer != nil {
+		cw.writer.Close()
+	}
+}
+
+// withCompression は一覧・統計・エクスポートのような大きくなる JSON を Accept-Encoding に応じて圧縮する
+func withCompression(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r)
+		if encoding == "" || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+		cw := &compressedResponseWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+		next(cw, r)
+	}
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+)
+
+// 実行環境ごとの設定プロファイル。APP_ENV (production, staging, development。既定 production) で選ぶ。
+// まだ設定されていないキーにだけ、CONFIG_DIR (既定 config) の {APP_ENV}.env、次にプロファイルの既定値を入れる。
+// 環境変数と SECRETS_FILE の値が常に優先するので、本番の設定はこれまでどおり環境変数だけで決まる。
+const (
+	envProduction  = "production"
+	envStaging     = "staging"
+	envDevelopment = "development"
+)
+
+var appEnvs = []string{envProduction, envStaging, envDevelopment}
+
+// profileDefaults はプロファイルごとの既定値。本番のデータを写したステージングから誤って督促が届かないよう、
+// production 以外は送信を sandbox_inbox に溜める (sandbox.go)。
+var profileDefaults = map[string]map[string]string{
+	envStaging: {
+		"SANDBOX_MODE": "true",
+	},
+	envDevelopment: {
+		"SANDBOX_MODE":   "true",
+		"CRON_SCHEDULER": cronTriggerInternal,
+	},
+}
+
+// profileKeys はプロファイルから設定したキー (値はログや API に出さない)
+var profileKeys []string
+
+// appEnv は APP_ENV。未設定や不明な値なら production として扱う。
+func appEnv() string {
+	if env := os.Getenv("APP_ENV"); slices.Contains(appEnvs, env) {
+		return env
+	}
+	return envProduction
+}
+
+// initConfigProfile は起動時に1回、initSecrets の後で呼ぶ
+func initConfigProfile() {
+	env := appEnv()
+	if raw := os.Getenv("APP_ENV"); raw != "" && raw != env {
+		log.Printf("[WARNING] unknown APP_ENV %q, using %s", raw, env)
+	}
+	dir := os.Getenv("CONFIG_DIR")
+	if dir == "" {
+		dir = "config"
+	}
+	values, err := parseEnvFile(filepath.Join(dir, env+".env"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("[ERROR] failed to load config profile %s: %v", env, err)
+	}
+	if values == nil {
+		values = make(map[string]string)
+	}
+	for key, value := range profileDefaults[env] {
+		if _, ok := values[key]; !ok {
+			values[key] = value
+		}
+	}
+	for key, value := range values {
+		if _, ok := os.LookupEnv(key); ok {
+			continue
+		}
+		os.Setenv(key, value)
+		profileKeys = append(profileKeys, key)
+	}
+	sort.Strings(profileKeys)
+	log.Printf("[INFO] config profile %s applied (%d keys: %v)", env, len(profileKeys), profileKeys)
+	if sandboxMode() {
+		log.Printf("[WARNING] SANDBOX_MODE is on: LINE and email sends are captured to sandbox_inbox and not delivered")
+	}
+}
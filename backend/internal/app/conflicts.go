@@ -0,0 +1,91 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+const defaultWeeklyDeadlineLimit = 3
+
+var jst = time.FixedZone("JST", 9*60*60)
+
+// Warning は登録・更新を止めずに利用者へ伝える注意事項
+type Warning struct {
+	Code    string    `json:"code"`
+	Message string    `json:"message"`
+	Books   []BookRef `json:"books,omitempty"`
+}
+
+// BookRef は警告に添える書籍の要約
+type BookRef struct {
+	BookID   string    `json:"book_id"`
+	Title    string    `json:"title"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// weekBounds は JST で deadline を含む週 (月曜始まり) の範囲を返す
+func weekBounds(t time.Time) (time.Time, time.Time) {
+	t = t.In(jst)
+	offset := (int(t.Weekday()) + 6) % 7
+	start := time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, jst)
+	return start, start.AddDate(0, 0, 7)
+}
+
+// deadlineConflicts は同じ週に期限を持つ進行中の本が上限を超える場合に警告を返す。
+// excludeBookID は更新時の自分自身を除外するために使う。
+func deadlineConflicts(userID, excludeBookID string, deadline time.Time) (*Warning, error) {
+	if deadline.IsZero() {
+		return nil, nil
+	}
+	start, end := weekBounds(deadline)
+	resp, _, err := execute(supabaseClient.From("books").
+		Select("book_id, title, deadline", "", false).
+		Eq("user_id", userID).
+		In("status", activeStatuses).
+		Gte("deadline", start.Format(time.RFC3339)).
+		Lt("deadline", end.Format(time.RFC3339)))
+	if err != nil {
+		return nil, err
+	}
+	var books []Book
+	if err := json.Unmarshal(resp, &books); err != nil {
+		return nil, err
+	}
+
+	var refs []BookRef
+	for _, b := range books {
+		if b.BookID != excludeBookID {
+			refs = append(refs, BookRef{BookID: b.BookID, Title: b.Title, Deadline: b.Deadline})
+		}
+	}
+	limit := envInt("DEADLINE_WEEK_LIMIT", defaultWeeklyDeadlineLimit)
+	if len(refs) < limit {
+		return nil, nil
+	}
+	return &Warning{
+		Code:    "deadline_conflict",
+		Message: fmt.Sprintf("%s の週にはすでに%d冊の期限があります。期限をずらすことを検討してください。", start.Format("2006/01/02"), len(refs)),
+		Books:   refs,
+	}, nil
+}
+
+// bookWarnings は登録・更新レスポンスに添える警告を集める。取得に失敗しても登録自体は止めない。
+func bookWarnings(book Book) []Warning {
+	warnings := []Warning{}
+	if deadlineless(book.Status) || book.Status == "completed" {
+		return warnings
+	}
+	conflict, err := deadlineConflicts(book.UserID, book.BookID, book.Deadline)
+	if err != nil {
+		log.Printf("[ERROR] deadline conflict check failed: %v", err)
+	}
+	if conflict != nil {
+		warnings = append(warnings, *conflict)
+	}
+	if w := paceWarning(book); w != nil {
+		warnings = append(warnings, *w)
+	}
+	return warnings
+}
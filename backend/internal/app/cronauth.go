@@ -0,0 +1,91 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const cronSignatureMaxSkew = 5 * time.Minute
+
+// clientIP はリクエスト元の IP。TRUST_PROXY=true のときだけ X-Forwarded-For の先頭を信じる。
+func clientIP(r *http.Request) net.IP {
+	if os.Getenv("TRUST_PROXY") == "true" {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first, _, _ := strings.Cut(fwd, ",")
+			return net.ParseIP(strings.TrimSpace(first))
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// cronIPAllowed は CRON_ALLOWED_IPS (IP か CIDR のカンマ区切り) が設定されていれば送信元を確認する
+func cronIPAllowed(r *http.Request) bool {
+	allowed := os.Getenv("CRON_ALLOWED_IPS")
+	if allowed == "" {
+		return true
+	}
+	ip := clientIP(r)
+	if ip == nil {
+		return false
+	}
+	for _, entry := range strings.Split(allowed, ",") {
+		entry = strings.TrimSpace(entry)
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if allowedIP := net.ParseIP(entry); allowedIP != nil && allowedIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// cronSignature は "timestamp.METHOD.path" の HMAC-SHA256 (hex)
+func cronSignature(secret, timestamp, method, path string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + method + "." + path))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// cronSignatureValid は CRON_HMAC_SECRET が設定されていれば X-Cron-Timestamp (unix 秒) と
+// X-Cron-Signature を確認する。時刻のずれは cronSignatureMaxSkew まで、同じ署名の再利用は拒否する。
+func cronSignatureValid(r *http.Request) bool {
+	secret := os.Getenv("CRON_HMAC_SECRET")
+	if secret == "" {
+		return true
+	}
+	timestamp := r.Header.Get("X-Cron-Timestamp")
+	signature := r.Header.Get("X-Cron-Signature")
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || signature == "" {
+		return false
+	}
+	if skew := time.Since(time.Unix(ts, 0)); skew > cronSignatureMaxSkew || skew < -cronSignatureMaxSkew {
+		log.Printf("[WARNING] cron request rejected: timestamp skew %s", skew)
+		return false
+	}
+	expected := cronSignature(secret, timestamp, r.Method, r.URL.Path)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return false
+	}
+	replayKey := "cron:sig:" + expected
+	if _, seen := appCache.Get(replayKey); seen {
+		log.Printf("[WARNING] cron request rejected: replayed signature for %s", r.URL.Path)
+		return false
+	}
+	appCache.Set(replayKey, []byte("1"), 2*cronSignatureMaxSkew)
+	return true
+}
@@ -0,0 +1,191 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 期限チェックの実行記録と、アプリ内のスケジューラー。
+// 外部の cron から /api/cron/check を叩く代わりに、CRON_SCHEDULER=internal でアプリ内から定期実行できる。
+// 間隔は CRON_MIN_INTERVAL_MINUTES 〜 CRON_MAX_INTERVAL_MINUTES の間で決め、期限が集中する時間帯
+// (CRON_PEAK_HOURS: JST の時のカンマ区切り) と、これまでの実行で督促が多かった時間帯は短くする。
+const (
+	cronTriggerHTTP     = "http"
+	cronTriggerInternal = "internal"
+	cronTriggerGRPC     = "grpc"
+
+	defaultCronMinInterval = 5 * time.Minute
+	defaultCronMaxInterval = 60 * time.Minute
+	defaultCronPeakHours   = "0" // 期限は日付だけで登録されることが多く、JST 0時に切れる
+
+	cronRunHistory = 100
+	// 時間帯ごとの督促数の指数移動平均の重み。この平均が cronBusyLoad 以上の時間帯は混む時間帯として扱う。
+	cronLoadAlpha = 0.3
+	cronBusyLoad  = 1.0
+)
+
+// cronRun は期限チェック1回分の記録
+type cronRun struct {
+	Trigger    string    `json:"trigger"` // http, internal
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+	Found      int       `json:"found"`  // 期限切れの本
+	Queued     int       `json:"queued"` // 積んだ督促・催促
+	Error      string    `json:"error,omitempty"`
+}
+
+type cronStats struct {
+	mu       sync.Mutex
+	runs     []cronRun
+	hourLoad [24]float64 // JST の時ごとの督促数の指数移動平均
+}
+
+var deadlineCronStats = &cronStats{}
+
+func (s *cronStats) record(run cronRun) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs = append(s.runs, run)
+	if len(s.runs) > cronRunHistory {
+		s.runs = s.runs[len(s.runs)-cronRunHistory:]
+	}
+	if run.Error == "" {
+		h := run.StartedAt.In(jst).Hour()
+		s.hourLoad[h] = s.hourLoad[h]*(1-cronLoadAlpha) + float64(run.Queued)*cronLoadAlpha
+	}
+}
+
+func (s *cronStats) snapshot() ([]cronRun, [24]float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]cronRun(nil), s.runs...), s.hourLoad
+}
+
+// runDeadlineCheck は期限チェックを1回実行して記録し、期限切れの本の数と積んだ数を返す
+func runDeadlineCheck(ctx context.Context, trigger string) (int, int, error) {
+	started := clock.Now()
+	found, queued, err := checkDeadlines(ctx)
+	run := cronRun{Trigger: trigger, StartedAt: started, DurationMs: clock.Now().Sub(started).Milliseconds(), Found: found, Queued: queued}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	deadlineCronStats.record(run)
+	return found, queued, err
+}
+
+// cronIntervalBounds は CRON_MIN_INTERVAL_MINUTES と CRON_MAX_INTERVAL_MINUTES。min > max なら min に揃える。
+func cronIntervalBounds() (time.Duration, time.Duration) {
+	lo := time.Duration(envInt("CRON_MIN_INTERVAL_MINUTES", int(defaultCronMinInterval/time.Minute))) * time.Minute
+	hi := time.Duration(envInt("CRON_MAX_INTERVAL_MINUTES", int(defaultCronMaxInterval/time.Minute))) * time.Minute
+	return lo, max(lo, hi)
+}
+
+// cronPeakHours は CRON_PEAK_HOURS の JST の時。不正な値は捨てる。
+func cronPeakHours() map[int]bool {
+	v, ok := os.LookupEnv("CRON_PEAK_HOURS")
+	if !ok {
+		v = defaultCronPeakHours
+	}
+	peak := make(map[int]bool)
+	for _, f := range strings.Split(v, ",") {
+		if h, err := strconv.Atoi(strings.TrimSpace(f)); err == nil && h >= 0 && h < 24 {
+			peak[h] = true
+		}
+	}
+	return peak
+}
+
+// nextCronInterval は now から次の期限チェックまでの間隔。
+// 混む時間帯の中なら最短、そうでなければ最長の間隔にするが、次の混む時間帯の始まりは跨がない。
+// 前回の実行が長引いていれば、その2倍は空ける。
+func nextCronInterval(now time.Time) time.Duration {
+	lo, hi := cronIntervalBounds()
+	peak := cronPeakHours()
+	runs, load := deadlineCronStats.snapshot()
+	busy := func(t time.Time) bool {
+		h := t.In(jst).Hour()
+		return peak[h] || load[h] >= cronBusyLoad
+	}
+
+	interval := hi
+	if busy(now) {
+		interval = lo
+	} else {
+		for t := now.Truncate(time.Hour).Add(time.Hour); t.Before(now.Add(hi)); t = t.Add(time.Hour) {
+			if busy(t) {
+				interval = max(lo, t.Sub(now))
+				break
+			}
+		}
+	}
+	if len(runs) > 0 {
+		last := time.Duration(runs[len(runs)-1].DurationMs) * time.Millisecond
+		interval = min(max(interval, 2*last), hi)
+	}
+	return interval
+}
+
+// startCronScheduler は CRON_SCHEDULER=internal のとき期限チェック (と読書会の投票の締め切り、1日1回のデータの保持期間の処理) をアプリ内で回す。
+// 複数インスタンスで有効にすると重複して走るが、送信待ちのジョブがある本は積まないので督促は二重にならない。
+func startCronScheduler() {
+	if os.Getenv("CRON_SCHEDULER") != cronTriggerInternal {
+		return
+	}
+	go func() {
+		var lastRetention time.Time
+		for {
+			_, _, err := runDeadlineCheck(context.Background(), cronTriggerInternal)
+			opsAlerts.recordCron("check", err, clock.Now())
+			if err != nil {
+				log.Printf("[ERROR] scheduled deadline check failed: %v", err)
+			}
+			_, err = closeDueBookClubPolls()
+			opsAlerts.recordCron("book-club", err, clock.Now())
+			if err != nil {
+				log.Printf("[ERROR] scheduled book club poll closing failed: %v", err)
+			}
+			// データの保持期間の処理は1日1回
+			if now := clock.Now(); now.Sub(lastRetention) >= 24*time.Hour {
+				lastRetention = now
+				_, err := enforceRetention()
+				opsAlerts.recordCron("retention", err, clock.Now())
+				if err != nil {
+					log.Printf("[ERROR] scheduled retention failed: %v", err)
+				}
+				purgeExpiredData(now)
+			}
+			wait := nextCronInterval(clock.Now())
+			log.Printf("[DEBUG] next deadline check in %s", wait)
+			time.Sleep(wait)
+		}
+	}()
+	lo, hi := cronIntervalBounds()
+	log.Printf("[INFO] internal deadline scheduler started (interval %s-%s)", lo, hi)
+}
+
+// handleCronMetrics は GET /api/admin/cron/metrics。最近の期限チェックの記録と、時間帯ごとの督促数、次の間隔を返す。
+func handleCronMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	runs, load := deadlineCronStats.snapshot()
+	scheduler := os.Getenv("CRON_SCHEDULER")
+	if scheduler == "" {
+		scheduler = "external"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"scheduler":             scheduler,
+		"runs":                  runs,
+		"hour_load":             load,
+		"next_interval_seconds": int(nextCronInterval(clock.Now()).Seconds()),
+	})
+}
@@ -0,0 +1,368 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// 運用者向けの CLI (cmd/tundokuctl)。DB アクセスやジョブ投入はサーバーと同じ関数を使う。
+const ctlUsage = `usage: tundokuctl <command>
+
+commands:
+  users list [-limit N]      list users with their book counts
+  users delete -yes <id>     delete a user and all of their data
+  check                      run the deadline check once (same as /api/cron/check)
+  jobs resend <jobId>        put a failed or skipped notification back in the queue
+  migrate [-file path]       apply supabase/schema.sql over DATABASE_URL
+  backfill [-tasks a,b] [-batch N] [-pause D] [-dry-run]
+                             fill newly added columns on existing books (tasks: completed_at, yomi, titles, status_history)
+  seed                       create a demo user with sample books
+  seed -users N -books M     generate N synthetic users with M books each (load testing)
+  seed -clean                delete all synthetic users`
+
+// RunCtl はサブコマンドを実行して終了コードを返す。先に Init を呼んでおく。
+func RunCtl(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, ctlUsage)
+		return 2
+	}
+	var err error
+	switch cmd := strings.Join(args[:min(2, len(args))], " "); {
+	case cmd == "users list":
+		err = ctlListUsers(args[2:])
+	case cmd == "users delete":
+		err = ctlDeleteUser(args[2:])
+	case args[0] == "check":
+		err = ctlCheckDeadlines()
+	case cmd == "jobs resend":
+		err = ctlResendJob(args[2:])
+	case args[0] == "migrate":
+		err = ctlMigrate(args[1:])
+	case args[0] == "backfill":
+		err = ctlBackfill(args[1:])
+	case args[0] == "seed":
+		err = ctlSeed(args[1:])
+	default:
+		fmt.Fprintln(os.Stderr, ctlUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func ctlListUsers(args []string) error {
+	fs := flag.NewFlagSet("users list", flag.ContinueOnError)
+	limit := fs.Int("limit", 50, "max users to list")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	resp, _, err := execute(supabaseClient.From("users").
+		Select("id, display_name, line_user_id, line_blocked_at, created_at, books(count)", "", false).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(*limit, ""))
+	if err != nil {
+		return err
+	}
+	var users []struct {
+		ID            string     `json:"id"`
+		DisplayName   string     `json:"display_name"`
+		LineUserID    string     `json:"line_user_id"`
+		LineBlockedAt *time.Time `json:"line_blocked_at"`
+		CreatedAt     time.Time  `json:"created_at"`
+		Books         []struct {
+			Count int `json:"count"`
+		} `json:"books"`
+	}
+	if err := json.Unmarshal(resp, &users); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tLINE\tBOOKS\tCREATED")
+	for _, u := range users {
+		books := 0
+		if len(u.Books) > 0 {
+			books = u.Books[0].Count
+		}
+		line := u.LineUserID
+		if u.LineBlockedAt != nil {
+			line += " (blocked)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", u.ID, u.DisplayName, line, books, u.CreatedAt.In(jst).Format("2006-01-02"))
+	}
+	return tw.Flush()
+}
+
+func ctlDeleteUser(args []string) error {
+	fs := flag.NewFlagSet("users delete", flag.ContinueOnError)
+	yes := fs.Bool("yes", false, "confirm deletion")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: users delete -yes <userId>")
+	}
+	userID := fs.Arg(0)
+	if !*yes {
+		return fmt.Errorf("refusing to delete %s without -yes", userID)
+	}
+	// books などは users への外部キーで ON DELETE CASCADE
+	resp, _, err := execute(supabaseClient.From("users").Delete("", "").Eq("id", userID))
+	if err != nil {
+		return err
+	}
+	var deleted []struct {
+		LineUserID string `json:"line_user_id"`
+	}
+	if json.Unmarshal(resp, &deleted); len(deleted) == 0 {
+		return fmt.Errorf("user %s not found", userID)
+	}
+	appCache.Delete(userCacheKey(deleted[0].LineUserID))
+	invalidateBooks(userID)
+	fmt.Printf("deleted user %s\n", userID)
+	return nil
+}
+
+// ctlCheckDeadlines は cron と同じハンドラーを呼ぶ。送信はサーバー側のワーカーがキューから行う。
+func ctlCheckDeadlines() error {
+	req := httptest.NewRequest(http.MethodPost, "/api/cron/check", nil)
+	if secret := os.Getenv("CRON_SECRET"); secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	// IP 制限と署名はスケジューラーからのリクエスト向けなので、ここでは外す
+	os.Unsetenv("CRON_ALLOWED_IPS")
+	os.Unsetenv("CRON_HMAC_SECRET")
+	rec := httptest.NewRecorder()
+	handleCheckDeadlines(rec, req)
+	if rec.Code != http.StatusOK {
+		return fmt.Errorf("deadline check returned %d: %s", rec.Code, strings.TrimSpace(rec.Body.String()))
+	}
+	fmt.Print(rec.Body.String())
+	return nil
+}
+
+func ctlResendJob(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: jobs resend <jobId>")
+	}
+	if _, err := resendJob(args[0]); err != nil {
+		return fmt.Errorf("job %s: %w", args[0], err)
+	}
+	fmt.Printf("job %s queued again\n", args[0])
+	return nil
+}
+
+func ctlMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	file := fs.String("file", "../supabase/schema.sql", "schema file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if sqlDB == nil {
+		return fmt.Errorf("migrate needs a direct connection (DB_BACKEND=postgres, DATABASE_URL, built with -tags pgx)")
+	}
+	src, err := os.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	applied, skipped := 0, 0
+	for _, stmt := range splitSQLStatements(string(src)) {
+		if _, err := sqlDB.ExecContext(context.Background(), stmt); err != nil {
+			// schema.sql は追記式で CREATE POLICY などは IF NOT EXISTS を書けないため、既存のものは飛ばす
+			if strings.Contains(err.Error(), "already exists") {
+				skipped++
+				continue
+			}
+			return fmt.Errorf("%w\nin statement:\n%s", err, snippet(stmt, 300))
+		}
+		applied++
+	}
+	fmt.Printf("applied %d statements (%d already existed)\n", applied, skipped)
+	return nil
+}
+
+// ctlBackfill はバックフィルを最後まで流し、バッチごとに進み具合を出す。
+// 止めたら表示された cursor を -after に渡すと続きから流せる。
+func ctlBackfill(args []string) error {
+	fs := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	taskList := fs.String("tasks", "", "comma separated tasks (default: all)")
+	batch := fs.Int("batch", defaultBackfillBatch, "books per batch")
+	pause := fs.Duration("pause", 200*time.Millisecond, "wait between batches")
+	after := fs.String("after", "", "resume after this book_id")
+	dryRun := fs.Bool("dry-run", false, "count what would change without writing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var names []string
+	if *taskList != "" {
+		names = strings.Split(*taskList, ",")
+	}
+	tasks, err := parseBackfillTasks(names)
+	if err != nil {
+		return err
+	}
+	cursor, seen, total := *after, 0, int64(0)
+	updated := make(map[string]int, len(tasks))
+	for {
+		p, err := runBackfillBatch(tasks, cursor, min(max(*batch, 1), maxBackfillBatch), *dryRun)
+		if err != nil {
+			return fmt.Errorf("%w (resume with -after %s)", err, cursor)
+		}
+		if p.Total > 0 {
+			total = p.Total
+		}
+		seen += p.Processed
+		for task, n := range p.Updated {
+			updated[task] += n
+		}
+		if p.NextCursor != "" {
+			cursor = p.NextCursor
+		}
+		fmt.Printf("%d/%d books, updated %v, cursor %s\n", seen, total, updated, cursor)
+		if p.Done {
+			break
+		}
+		time.Sleep(*pause)
+	}
+	if *dryRun {
+		fmt.Println("dry run: nothing was written")
+	}
+	return nil
+}
+
+// splitSQLStatements は ; で文を分ける。文字列リテラル・$$ で囲んだ関数本体・コメント中の ; は区切りにしない。
+func splitSQLStatements(src string) []string {
+	var stmts []string
+	var cur strings.Builder
+	inQuote, inDollar, inComment := false, false, false
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case inComment:
+			if c == '\n' {
+				inComment = false
+			}
+			continue
+		case inQuote:
+			if c == '\'' {
+				inQuote = false
+			}
+		case inDollar:
+			if strings.HasPrefix(src[i:], "$$") {
+				inDollar = false
+				cur.WriteString("$$")
+				i++
+				continue
+			}
+		case c == '-' && strings.HasPrefix(src[i:], "--"):
+			inComment = true
+			continue
+		case c == '\'':
+			inQuote = true
+		case strings.HasPrefix(src[i:], "$$"):
+			inDollar = true
+			cur.WriteString("$$")
+			i++
+			continue
+		case c == ';':
+			if s := strings.TrimSpace(cur.String()); s != "" {
+				stmts = append(stmts, s)
+			}
+			cur.Reset()
+			continue
+		}
+		cur.WriteByte(c)
+	}
+	if s := strings.TrimSpace(cur.String()); s != "" {
+		stmts = append(stmts, s)
+	}
+	return stmts
+}
+
+// ctlSeed はデモ用のユーザーと、期限切れ・期限前・読書中・読了が混ざった本を作る。
+// LINE ID はダミーなので、送信されないようブロック済みにしておく。-users を付けると負荷試験用の合成データを作る。
+func ctlSeed(args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	synthUsers := fs.Int("users", 0, "synthetic users to generate")
+	synthBooks := fs.Int("books", 20, "books per synthetic user")
+	clean := fs.Bool("clean", false, "delete synthetic users")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *clean {
+		n, err := deleteSyntheticData()
+		if err == nil {
+			fmt.Printf("deleted %d synthetic users\n", n)
+		}
+		return err
+	}
+	if *synthUsers > 0 {
+		started := time.Now()
+		n, err := generateSyntheticData(*synthUsers, *synthBooks)
+		if err == nil {
+			fmt.Printf("generated %d users with %d books in %s\n", *synthUsers, n, time.Since(started).Round(time.Millisecond))
+		}
+		return err
+	}
+
+	lineUserID := fmt.Sprintf("demo-%d", clock.Now().Unix())
+	resp, _, err := executeOnce(supabaseClient.From("users").Insert(map[string]interface{}{
+		"line_user_id":    lineUserID,
+		"display_name":    "デモユーザー",
+		"line_blocked_at": clock.Now(),
+	}, false, "", "", ""))
+	if err != nil {
+		return err
+	}
+	var users []struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(resp, &users); len(users) == 0 {
+		return fmt.Errorf("failed to create demo user")
+	}
+	userID := users[0].ID
+
+	now := clock.Now()
+	samples := []struct {
+		title, author, status string
+		days                  int
+	}{
+		{"吾輩は猫である", "夏目漱石", "insulted", -42},
+		{"罪と罰", "ドストエフスキー", "unread", -10},
+		{"カラマーゾフの兄弟", "ドストエフスキー", "unread", -3},
+		{"銀河鉄道の夜", "宮沢賢治", "reading", 5},
+		{"人間失格", "太宰治", "unread", 14},
+		{"こころ", "夏目漱石", "unread", 30},
+		{"羅生門", "芥川龍之介", "completed", -20},
+		{"坊っちゃん", "夏目漱石", "wishlist", 60},
+	}
+	rows := make([]map[string]interface{}, 0, len(samples))
+	for i, s := range samples {
+		rows = append(rows, map[string]interface{}{
+			"user_id":      userID,
+			"title":        s.title,
+			"author":       s.author,
+			"status":       s.status,
+			"deadline":     now.AddDate(0, 0, s.days),
+			"insult_level": insultLevelDefault,
+			"sort_order":   i,
+		})
+	}
+	if _, _, err := executeOnce(supabaseClient.From("books").Insert(rows, false, "", "minimal", "")); err != nil {
+		return err
+	}
+	fmt.Printf("created demo user %s with %d books\n", userID, len(rows))
+	return nil
+}
@@ -0,0 +1,262 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+const (
+	customInsultMinLength = 5
+	customInsultMaxLength = 200
+	maxCustomInsults      = 50 // 1ユーザーあたり
+	// customInsultPrefix を付けたキーで notification_jobs.template に記録する
+	customInsultPrefix = "custom:"
+	// モデレーション API の障害中に登録された文の flag_reason (degrade.go)
+	customInsultPendingReview = "pending_review"
+)
+
+var legacyTitlePlaceholder = strings.NewReplacer("{title}", "{{.Title}}")
+
+var customInsultURLPattern = regexp.MustCompile(`(?i)(https?://|www\.|line\.me/)`)
+
+// CustomInsult はユーザーが登録した督促文。{{.Title}} などのテンプレート変数 (messages.go) が使える。
+// 管理者が flagged にしたものはローテーションに入らない。
+type CustomInsult struct {
+	InsultID   string    `json:"insult_id"`
+	UserID     string    `json:"user_id"`
+	Text       string    `json:"text"`
+	Flagged    bool      `json:"flagged"`
+	FlagReason *string   `json:"flag_reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// validateCustomInsult は長さと内容を確認する。URL や制御文字、安全フィルター (safety.go) に引っかかる文は受け付けない。
+// モデレーション API に問い合わせられなかったときは、整えた文と errModerationUnavailable を返す。
+func validateCustomInsult(ctx context.Context, text string) (string, error) {
+	text = strings.TrimSpace(text)
+	n := len([]rune(text))
+	if n < customInsultMinLength || n > customInsultMaxLength {
+		return "", fmt.Errorf("text must be %d-%d characters", customInsultMinLength, customInsultMaxLength)
+	}
+	for _, r := range text {
+		if unicode.IsControl(r) && r != '\n' {
+			return "", fmt.Errorf("text must not contain control characters")
+		}
+	}
+	if customInsultURLPattern.MatchString(text) {
+		return "", fmt.Errorf("text must not contain URLs")
+	}
+	// 以前の {title} 形式はテンプレート変数に置き換えて保存する
+	text = legacyTitlePlaceholder.Replace(text)
+	if err := validateMessageTemplate(text); err != nil {
+		return "", err
+	}
+	if err := checkInsultSafety(ctx, text); err != nil {
+		if errors.Is(err, errModerationUnavailable) {
+			log.Printf("[ERROR] custom insult moderation failed: %v", err)
+			return text, errModerationUnavailable
+		}
+		return "", fmt.Errorf("text was rejected by the content filter")
+	}
+	return text, nil
+}
+
+// template はセレクターで他の雛形と同じように扱えるようにする
+func (c CustomInsult) template() insultTemplate {
+	return insultTemplate{
+		Key:    customInsultPrefix + c.InsultID,
+		Weight: 1,
+		Text:   legacyTitlePlaceholder.Replace(c.Text),
+	}
+}
+
+// loadCustomInsults は userIDs の承認済み (flagged でない) 督促文をユーザーごとに返す
+func loadCustomInsults(userIDs []string) (map[string][]CustomInsult, error) {
+	result := make(map[string][]CustomInsult)
+	if len(userIDs) == 0 {
+		return result, nil
+	}
+	resp, _, err := execute(supabaseClient.From("custom_insults").
+		Select("insult_id, user_id, text", "", false).
+		In("user_id", userIDs).
+		Eq("flagged", "false"))
+	if err != nil {
+		return nil, err
+	}
+	var rows []CustomInsult
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		result[row.UserID] = append(result[row.UserID], row)
+	}
+	return result, nil
+}
+
+// handleCustomInsults は /api/insults/custom (GET: 一覧, POST: 追加)
+func handleCustomInsults(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		userId := r.URL.Query().Get("userId")
+		if userId == "" {
+			http.Error(w, "userId required", http.StatusBadRequest)
+			return
+		}
+		resp, _, err := execute(supabaseClient.From("custom_insults").
+			Select("*", "", false).
+			Eq("user_id", userId).
+			Order("created_at", &postgrest.OrderOpts{Ascending: true}))
+		if err != nil {
+			log.Printf("[ERROR] handleCustomInsults list error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch insults: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+
+	case http.MethodPost:
+		var req struct {
+			UserID string `json:"user_id"`
+			Text   string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		// モデレーション API の障害中は flagged で保存し、審査 (/api/admin/insults/custom) で承認されるまで使わない
+		text, err := validateCustomInsult(r.Context(), req.Text)
+		pendingReview := errors.Is(err, errModerationUnavailable)
+		if err != nil && !pendingReview {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, count, err := execute(supabaseClient.From("custom_insults").Select("insult_id", "exact", true).Eq("user_id", req.UserID))
+		if err != nil {
+			log.Printf("[ERROR] handleCustomInsults count error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to create insult: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if count >= maxCustomInsults {
+			http.Error(w, fmt.Sprintf("too many custom insults (max %d)", maxCustomInsults), http.StatusConflict)
+			return
+		}
+		row := map[string]interface{}{"user_id": req.UserID, "text": text}
+		if pendingReview {
+			row["flagged"], row["flag_reason"] = true, customInsultPendingReview
+		}
+		rawResp, _, err := executeOnce(supabaseClient.From("custom_insults").Insert(row, false, "", "", ""))
+		if err != nil {
+			log.Printf("[ERROR] handleCustomInsults insert error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to create insult: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(rawResp)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleCustomInsult は DELETE /api/insults/custom/{id}?userId=...
+func handleCustomInsult(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+	rawResp, _, err := execute(supabaseClient.From("custom_insults").Delete("", "").Eq("insult_id", r.PathValue("id")).Eq("user_id", userId))
+	if err != nil {
+		log.Printf("[ERROR] handleCustomInsult delete error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to delete insult: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if string(rawResp) == "[]" {
+		http.Error(w, "Insult not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Insult deleted successfully"})
+}
+
+// handleAdminCustomInsults は GET /api/admin/insults/custom?flagged=true|false。モデレーション用に全ユーザーの督促文を新しい順に返す。
+func handleAdminCustomInsults(w http.ResponseWriter, r *http.Request) {
+	if !authorizeModerator(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit, offset, err := pageParams(r, 50, 200)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := supabaseClient.From("custom_insults").Select("*", "", false)
+	if f := r.URL.Query().Get("flagged"); f == "true" || f == "false" {
+		q = q.Eq("flagged", f)
+	}
+	resp, _, err := execute(q.Order("created_at", &postgrest.OrderOpts{Ascending: false}).Range(offset, offset+limit-1, ""))
+	if err != nil {
+		log.Printf("[ERROR] handleAdminCustomInsults list error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch insults: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+// handleAdminCustomInsult は PATCH /api/admin/insults/custom/{id}。flagged を立てるとローテーションから外れる。
+func handleAdminCustomInsult(w http.ResponseWriter, r *http.Request) {
+	if !authorizeModerator(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Flagged bool   `json:"flagged"`
+		Reason  string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	var reason interface{}
+	if req.Flagged {
+		reason = nullIfEmpty(strings.TrimSpace(req.Reason))
+	}
+	rawResp, _, err := execute(supabaseClient.From("custom_insults").
+		Update(map[string]interface{}{"flagged": req.Flagged, "flag_reason": reason}, "", "").
+		Eq("insult_id", r.PathValue("id")))
+	if err != nil {
+		log.Printf("[ERROR] handleAdminCustomInsult update error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to update insult: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if string(rawResp) == "[]" {
+		http.Error(w, "Insult not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(rawResp)
+}
@@ -0,0 +1,254 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	dataRequestRetention = 7 * 24 * time.Hour // アーカイブを残す期間
+	dataRequestURLTTL    = time.Hour          // 署名付き URL の有効期間 (取得のたびに発行し直す)
+)
+
+// DataRequest は data_requests の1行。個人データ一式 (開示請求) のアーカイブ作成状況。
+type DataRequest struct {
+	RequestID   string     `json:"request_id"`
+	UserID      string     `json:"user_id"`
+	Status      string     `json:"status"` // processing, ready, failed
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	DownloadURL string     `json:"download_url,omitempty"`
+}
+
+// dataRequestRow は storage_path を読むための行 (API の応答には出さない)
+type dataRequestRow struct {
+	DataRequest
+	Path string `json:"storage_path"`
+}
+
+// dataPackageSection はアーカイブに入れる1ファイル分。user_id で絞れるテーブルを全て並べる。
+type dataPackageSection struct {
+	file    string
+	table   string
+	columns string
+	filter  map[string]string
+}
+
+var dataPackageSections = []dataPackageSection{
+	{"books.json", "books", "*", nil},
+	{"notes.json", "book_notes", "*", nil},
+	{"projects.json", "projects", "*", nil},
+	{"attachments.json", "book_attachments", "attachment_id, book_id, file_name, content_type, size_bytes, created_at", nil},
+	{"reading_sessions.json", "reading_sessions", "*", nil},
+	{"progress_logs.json", "progress_logs", "*", nil},
+	{"completions.json", "book_completions", "*", nil},
+	{"achievements.json", "user_achievements", "*", nil},
+	{"milestones.json", "book_milestones", "*", nil},
+	{"custom_insults.json", "custom_insults", "*", nil},
+	{"insult_history.json", "notification_jobs", "job_id, book_id, book_ids, template, insult_level, message, sent_at, created_at", map[string]string{"kind": jobKindInsult, "status": "sent"}},
+	{"notifications.json", "notification_jobs", "*", nil},
+	{"group_shame.json", "group_shame_members", "*", nil},
+	{"stakes.json", "book_stakes", "*", nil},
+	{"book_club_votes.json", "book_club_votes", "*", nil},
+	{"reading_buddies.json", "reading_buddies", "entry_id, book_id, match_key, match_label, status, created_at, matched_at, ended_at", nil}, // 相手の申し込みは含めない
+	{"import_drafts.json", "import_drafts", "*", nil},
+	{"workspace.json", "workspace_members", "*", nil},
+	{"login_sessions.json", "user_sessions", sessionColumns, nil},
+	{"notion.json", "notion_connections", "user_id, database_id, field_mapping, last_synced_at, created_at, updated_at", nil}, // アクセストークンは除く
+	{"audit_log.json", "audit_log", "*", nil},
+	{"support_access.json", "support_access_log", "resource, reason, created_at", nil},
+	{"api_usage.json", "api_usage", "*", nil},
+}
+
+const dataPackageReadme = `積読キラーが保存しているあなたのデータ一式です。
+各ファイルは JSON で、日時は UTC です。
+
+profile.json          アカウント情報
+books.json            登録した本
+notes.json            メモ・引用
+projects.json         プロジェクト (最終期限を共有する本のまとまり)
+attachments.json      添付ファイルの一覧 (ファイル本体は本の画面から取得できます)
+reading_sessions.json 読書タイマーの記録
+progress_logs.json    進捗の記録
+completions.json      読了の記録
+achievements.json     解除した実績
+milestones.json       マイルストーン
+custom_insults.json   自作の督促文
+insult_history.json   送信された督促
+notifications.json    LINE 通知の送信ログ (督促以外も含む)
+group_shame.json      晒しに同意したグループ
+stakes.json           本に掛けた賭け
+book_club_votes.json  読書会の課題本への投票
+reading_buddies.json  読書仲間探しの申し込み
+import_drafts.json    注文履歴から取り込んだ登録の下書き
+workspace.json        所属しているワークスペースと役割
+login_sessions.json   ログイン中・過去のセッション
+notion.json           Notion 連携の設定
+audit_log.json        削除・読了などの操作履歴
+support_access.json   サポート対応で管理者があなたのデータを閲覧した記録
+api_usage.json        API の利用回数
+`
+
+// buildDataPackage はユーザーについて保存している全データを zip にまとめる
+func buildDataPackage(userID string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	write := func(name string, data []byte) error {
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(data)
+		return err
+	}
+	writeJSON := func(name string, raw []byte) error {
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, raw, "", "  "); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return write(name, pretty.Bytes())
+	}
+
+	if err := write("README.txt", []byte(dataPackageReadme)); err != nil {
+		return nil, err
+	}
+	resp, _, err := execute(supabaseClient.From("users").Select("*", "", false).Eq("id", userID).Single())
+	if err != nil {
+		return nil, fmt.Errorf("profile: %w", err)
+	}
+	if err := writeJSON("profile.json", resp); err != nil {
+		return nil, err
+	}
+	for _, s := range dataPackageSections {
+		q := supabaseClient.From(s.table).Select(s.columns, "", false).Eq("user_id", userID)
+		for col, v := range s.filter {
+			q = q.Eq(col, v)
+		}
+		resp, _, err := execute(q)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.table, err)
+		}
+		if err := writeJSON(s.file, openRows(s.table, resp)); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// processDataRequest はアーカイブを作って Storage に置き、結果を data_requests に書く
+func processDataRequest(req DataRequest) {
+	update := map[string]interface{}{"completed_at": time.Now()}
+	path := fmt.Sprintf("data-requests/%s/%s.zip", req.UserID, req.RequestID)
+	archive, err := buildDataPackage(req.UserID)
+	if err == nil {
+		err = uploadToStorage(path, "application/zip", archive)
+	}
+	if err != nil {
+		log.Printf("[ERROR] data request %s for user %s failed: %v", req.RequestID, req.UserID, err)
+		update["status"] = "failed"
+		update["error"] = err.Error()
+	} else {
+		log.Printf("[INFO] data request %s for user %s is ready (%d bytes)", req.RequestID, req.UserID, len(archive))
+		update["status"] = "ready"
+		update["storage_path"] = path
+		update["expires_at"] = time.Now().Add(dataRequestRetention)
+	}
+	if _, _, err := execute(supabaseClient.From("data_requests").Update(update, "minimal", "").Eq("request_id", req.RequestID)); err != nil {
+		log.Printf("[ERROR] failed to record data request %s: %v", req.RequestID, err)
+	}
+}
+
+// handleDataRequest は POST /api/users/me/data-request。アーカイブの作成を受け付けて 202 を返す。
+// 作成中のものがあれば新しく作らずにそれを返す。
+func handleDataRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session, err := authenticateSession(r)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	resp, _, err := execute(supabaseClient.From("data_requests").Select("*", "", false).Eq("user_id", session.UserID).Eq("status", "processing"))
+	if err != nil {
+		log.Printf("[ERROR] handleDataRequest query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to create data request: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var requests []DataRequest
+	json.Unmarshal(resp, &requests)
+	if len(requests) == 0 {
+		resp, _, err = executeOnce(supabaseClient.From("data_requests").Insert(map[string]interface{}{
+			"user_id": session.UserID,
+			"status":  "processing",
+		}, false, "", "", ""))
+		if err == nil {
+			err = json.Unmarshal(resp, &requests)
+		}
+		if err != nil || len(requests) == 0 {
+			log.Printf("[ERROR] handleDataRequest insert error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to create data request: %v", err), http.StatusInternalServerError)
+			return
+		}
+		go processDataRequest(requests[0])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(requests[0])
+}
+
+// handleDataRequestStatus は GET /api/users/me/data-request/{id}。
+// 完成していれば dataRequestURLTTL だけ有効な署名付き URL を付けて返す。保存期間を過ぎたら 410。
+func handleDataRequestStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session, err := authenticateSession(r)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	resp, _, err := execute(supabaseClient.From("data_requests").Select("*", "", false).Eq("request_id", r.PathValue("id")).Eq("user_id", session.UserID))
+	if err != nil {
+		log.Printf("[ERROR] handleDataRequestStatus query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch data request: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var rows []dataRequestRow
+	json.Unmarshal(resp, &rows)
+	if len(rows) == 0 {
+		http.Error(w, "Data request not found", http.StatusNotFound)
+		return
+	}
+	req := rows[0].DataRequest
+	if req.Status == "ready" {
+		if req.ExpiresAt != nil && time.Now().After(*req.ExpiresAt) {
+			if err := removeFromStorage(rows[0].Path); err != nil {
+				log.Printf("[WARNING] failed to remove expired data package %s: %v", rows[0].Path, err)
+			}
+			http.Error(w, "Data package has expired; please request a new one", http.StatusGone)
+			return
+		}
+		if req.DownloadURL, err = signedStorageURL(rows[0].Path, dataRequestURLTTL); err != nil {
+			log.Printf("[ERROR] handleDataRequestStatus sign error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to create download URL: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(req)
+}
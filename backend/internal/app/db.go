@@ -0,0 +1,126 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sqlDB は DB_BACKEND=postgres のときだけ設定される Supabase Postgres への直接接続。
+// PostgREST では書けないトランザクション・一括INSERT・集計クエリに使い、nil の場合は
+// 各機能が PostgREST 経由の実装にフォールバックする。
+var sqlDB *sql.DB
+
+// initDirectDB は DATABASE_URL に pgx ドライバーで接続する。
+// ドライバーは db_pgx.go (build tag: pgx) で登録される。
+func initDirectDB() {
+	if os.Getenv("DB_BACKEND") != "postgres" {
+		return
+	}
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		log.Printf("[ERROR] DB_BACKEND=postgres but DATABASE_URL is not set, using PostgREST")
+		return
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		log.Printf("[ERROR] cannot open direct postgres connection (built without -tags pgx?), using PostgREST: %v", err)
+		return
+	}
+	db.SetMaxOpenConns(envInt("DATABASE_MAX_CONNS", 5))
+	db.SetConnMaxIdleTime(5 * time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		log.Printf("[ERROR] cannot reach postgres, using PostgREST: %v", err)
+		db.Close()
+		return
+	}
+
+	sqlDB = db
+	log.Printf("[INFO] using direct postgres connection")
+}
+
+// withTx は fn をトランザクション内で実行し、エラーならロールバックする
+func withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	if sqlDB == nil {
+		return fmt.Errorf("direct postgres connection is not configured")
+	}
+	tx, err := sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// books の変更フィード (bookfeed.go) に API 自身の書き込みを流さない
+	if _, err := tx.ExecContext(ctx, `SELECT set_config('tundoku.source', 'api', true)`); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			log.Printf("[ERROR] rollback failed: %v", rbErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertBooksBatch は books の行 (PostgREST に渡すのと同じ map) を1トランザクション・1文で登録する。
+// 列は先頭の行のキーに揃えるので、すべての行が同じキーを持つこと。
+func insertBooksBatch(ctx context.Context, rows []map[string]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	cols := make([]string, 0, len(rows[0]))
+	for c := range rows[0] {
+		cols = append(cols, c)
+	}
+	sort.Strings(cols)
+	payload, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	list := strings.Join(cols, ", ")
+	return withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO books (`+list+`) SELECT `+list+` FROM jsonb_populate_recordset(NULL::books, $1::jsonb)`, string(payload))
+		return err
+	})
+}
+
+// bookStatusCount はステータス・アーカイブ別の冊数と評価の集計
+type bookStatusCount struct {
+	Status    string
+	Archived  bool
+	Books     int
+	Rated     int
+	RatingSum int
+}
+
+// countBooksByStatus はユーザーの書籍数と評価をステータス・アーカイブ別に集計する
+func countBooksByStatus(ctx context.Context, userID string) ([]bookStatusCount, error) {
+	if sqlDB == nil {
+		return nil, fmt.Errorf("direct postgres connection is not configured")
+	}
+	rows, err := sqlDB.QueryContext(ctx, `SELECT status, archived, COUNT(*), COUNT(rating), COALESCE(SUM(rating), 0) FROM books WHERE user_id = $1 GROUP BY status, archived`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []bookStatusCount
+	for rows.Next() {
+		var c bookStatusCount
+		if err := rows.Scan(&c.Status, &c.Archived, &c.Books, &c.Rated, &c.RatingSum); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,6 @@
+//go:build pgx
+
+// 直接接続を使う場合は -tags pgx を付けてビルドする (pgx は go.mod にあり、CI でもこのタグでビルドする)
+package app
+
+import _ "github.com/jackc/pgx/v5/stdlib"
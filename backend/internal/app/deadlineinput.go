@@ -0,0 +1,192 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // コンテナにタイムゾーンのデータがなくても users.timezone を読めるようにする
+)
+
+// 利用者が書いた期限の読み取り。登録 API の deadline と LINE の「期限 来週末」で使う。
+// 日付はユーザーのタイムゾーン (users.timezone、未設定なら JST) でのその日の終わり (翌日 0時) とし、
+// 「+2w」「3日後」のような相対指定は今からの時間として既定の期限 (userdefaults.go) と同じに扱う。
+// 日と月の順が決まらない書き方は推測せずにエラーにする。
+var (
+	deadlineISODate   = regexp.MustCompile(`^(\d{4})[-/.](\d{1,2})[-/.](\d{1,2})$`)
+	deadlineKanjiDate = regexp.MustCompile(`^(?:(\d{4})年)?(\d{1,2})月(\d{1,2})日$`)
+	deadlineEraDate   = regexp.MustCompile(`^(令和|平成|昭和|[RHSrhs])\s*(\d{1,2}|元)[年./-](\d{1,2})[月./-](\d{1,2})日?$`)
+	deadlineMonthDay  = regexp.MustCompile(`^(\d{1,2})/(\d{1,2})$`)
+	deadlineYearLast  = regexp.MustCompile(`^(\d{1,2})[-/.](\d{1,2})[-/.](\d{2}|\d{4})$`)
+	deadlineShortYear = regexp.MustCompile(`^(\d{2})[-/.](\d{1,2})[-/.](\d{1,2})$`)
+	deadlineAfter     = regexp.MustCompile(`^(\d{1,3})\s*(日|週間|週|ヶ月|か月|カ月|ヵ月|ケ月)後$`)
+
+	errDeadlineAmbiguous = errors.New("ambiguous deadline")
+)
+
+// deadlineEras は和暦の元年の西暦
+var deadlineEras = map[string]int{
+	"令和": 2019, "R": 2019,
+	"平成": 1989, "H": 1989,
+	"昭和": 1926, "S": 1926,
+}
+
+// deadlineInputError は読めなかった期限。Message はそのまま利用者に返せる。
+type deadlineInputError struct {
+	Input   string
+	Message string
+	err     error
+}
+
+func (e *deadlineInputError) Error() string {
+	return fmt.Sprintf("deadline %q: %s", e.Input, e.Message)
+}
+
+func (e *deadlineInputError) Unwrap() error { return e.err }
+
+func deadlineInputErr(input, message string, err error) error {
+	return &deadlineInputError{Input: input, Message: message, err: err}
+}
+
+// parseDeadlineInput は s を期限の時刻にする。日付は loc でのその日の終わり、相対指定は now からの時間。
+func parseDeadlineInput(s string, now time.Time, loc *time.Location) (time.Time, error) {
+	input := strings.NewReplacer("／", "/", "－", "-", "＋", "+", "．", ".", " ", "").Replace(normalizeBookText(s))
+	if input == "" {
+		return time.Time{}, deadlineInputErr(s, "期限が空です", nil)
+	}
+	if t, err := time.Parse(time.RFC3339, input); err == nil {
+		return t, nil
+	}
+	today := now.In(loc)
+	date := func(y, m, d int) (time.Time, error) {
+		t := time.Date(y, time.Month(m), d, 0, 0, 0, 0, loc)
+		if t.Year() != y || int(t.Month()) != m || t.Day() != d {
+			return time.Time{}, deadlineInputErr(s, fmt.Sprintf("%d年%d月%d日という日はありません", y, m, d), nil)
+		}
+		return t.AddDate(0, 0, 1), nil
+	}
+	atoi := func(v string) int {
+		n, _ := strconv.Atoi(v)
+		return n
+	}
+
+	switch {
+	case strings.HasPrefix(input, "+"):
+		_, add, err := parseDeadlineOffset(input)
+		if err != nil {
+			return time.Time{}, deadlineInputErr(s, "相対指定は +10d、+2w、+1m のように書いてください", err)
+		}
+		return add(now), nil
+	case deadlineAfter.MatchString(input):
+		m := deadlineAfter.FindStringSubmatch(input)
+		var unit string
+		switch m[2] {
+		case "日":
+			unit = "d"
+		case "週間", "週":
+			unit = "w"
+		default:
+			unit = "m"
+		}
+		_, add, err := parseDeadlineOffset("+" + m[1] + unit)
+		if err != nil {
+			return time.Time{}, deadlineInputErr(s, fmt.Sprintf("期限は1日後から%d日後までにしてください", maxDeadlineOffsetDays), err)
+		}
+		return add(now), nil
+	case deadlineISODate.MatchString(input):
+		m := deadlineISODate.FindStringSubmatch(input)
+		return date(atoi(m[1]), atoi(m[2]), atoi(m[3]))
+	case deadlineEraDate.MatchString(input):
+		m := deadlineEraDate.FindStringSubmatch(input)
+		year := 1
+		if m[2] != "元" {
+			year = atoi(m[2])
+		}
+		return date(deadlineEras[strings.ToUpper(m[1])]+year-1, atoi(m[3]), atoi(m[4]))
+	case deadlineKanjiDate.MatchString(input):
+		m := deadlineKanjiDate.FindStringSubmatch(input)
+		if m[1] != "" {
+			return date(atoi(m[1]), atoi(m[2]), atoi(m[3]))
+		}
+		return nextMonthDay(s, today, atoi(m[2]), atoi(m[3]), date)
+	case deadlineMonthDay.MatchString(input):
+		m := deadlineMonthDay.FindStringSubmatch(input)
+		return nextMonthDay(s, today, atoi(m[1]), atoi(m[2]), date)
+	case deadlineYearLast.MatchString(input), deadlineShortYear.MatchString(input):
+		return time.Time{}, deadlineInputErr(s, "年・月・日の順が分からないので、2026-10-20 のように4桁の年から書いてください", errDeadlineAmbiguous)
+	}
+
+	// 言葉での指定
+	y, m, d := today.Date()
+	switch input {
+	case "今日":
+		return time.Date(y, m, d+1, 0, 0, 0, 0, loc), nil
+	case "明日":
+		return time.Date(y, m, d+2, 0, 0, 0, 0, loc), nil
+	case "明後日", "あさって":
+		return time.Date(y, m, d+3, 0, 0, 0, 0, loc), nil
+	case "週末", "今週末":
+		return weekendDeadline(today, 0), nil
+	case "来週末":
+		return weekendDeadline(today, 1), nil
+	case "再来週末":
+		return weekendDeadline(today, 2), nil
+	case "月末", "今月末":
+		return time.Date(y, m+1, 1, 0, 0, 0, 0, loc), nil
+	case "来月末":
+		return time.Date(y, m+2, 1, 0, 0, 0, 0, loc), nil
+	case "来週", "来月", "週明け":
+		return time.Time{}, deadlineInputErr(s, "何日か決まらないので「来週末」「+1w」「10/20」のように書いてください", errDeadlineAmbiguous)
+	}
+	return time.Time{}, deadlineInputErr(s, "2026-10-20、令和8年10月20日、10/20、来週末、+2w、3日後 のように書いてください", nil)
+}
+
+// nextMonthDay は年のない月日を今日以降で一番近い日にする
+func nextMonthDay(input string, today time.Time, month, day int, date func(y, m, d int) (time.Time, error)) (time.Time, error) {
+	t, err := date(today.Year(), month, day)
+	if err != nil && month == 2 && day == 29 {
+		// 今年にない 2/29 は次のうるう年まで待たずに聞き直す
+		return time.Time{}, deadlineInputErr(input, "年を付けて書いてください", errDeadlineAmbiguous)
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !t.After(today) {
+		return date(today.Year()+1, month, day)
+	}
+	return t, nil
+}
+
+// weekendDeadline は weeks 週後の日曜の終わり。日曜の今日に「今週末」なら今日の終わり。
+func weekendDeadline(today time.Time, weeks int) time.Time {
+	days := (7 - int(today.Weekday())) % 7
+	y, m, d := today.Date()
+	return time.Date(y, m, d+days+7*weeks+1, 0, 0, 0, 0, today.Location())
+}
+
+// defaultTimezone は users.timezone が未設定のときの名前 (jst と同じ)
+const defaultTimezone = "Asia/Tokyo"
+
+// userLocation は users.timezone。未設定・読めない値・取得失敗なら JST。
+func userLocation(userID string) *time.Location {
+	resp, _, err := execute(supabaseClient.From("users").Select("timezone", "", false).Eq("id", userID))
+	if err != nil {
+		log.Printf("[WARNING] timezone lookup failed for user %s: %v", userID, err)
+		return jst
+	}
+	var users []struct {
+		Timezone *string `json:"timezone"`
+	}
+	if json.Unmarshal(resp, &users); len(users) == 0 || users[0].Timezone == nil {
+		return jst
+	}
+	loc, err := time.LoadLocation(*users[0].Timezone)
+	if err != nil {
+		return jst
+	}
+	return loc
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// 登録・更新時の期限の妥当性チェック。年の打ち間違いなどで過去の期限が入ると、登録した直後に督促が飛んでしまう。
+// 問題があれば 422 で警告を返し、?confirmDeadline=true を付けて送り直したときだけそのまま保存する。
+const defaultDeadlineMaxYears = 3
+
+// deadlineSanityWarnings は期限の問題を警告にする。読了済みの本と期限のない本は見ない。
+func deadlineSanityWarnings(book Book, now time.Time) []Warning {
+	if deadlineless(book.Status) || book.Status == "completed" || book.Deadline.IsZero() {
+		return nil
+	}
+	today := now.In(jst)
+	tomorrow := time.Date(today.Year(), today.Month(), today.Day()+1, 0, 0, 0, 0, jst)
+	maxYears := envInt("DEADLINE_MAX_YEARS", defaultDeadlineMaxYears)
+	switch {
+	case book.Deadline.Before(now):
+		return []Warning{{Code: "deadline_past", Message: fmt.Sprintf("期限 %s はすでに過ぎています。保存するとすぐに督促が届きます。", book.Deadline.In(jst).Format("2006/01/02 15:04"))}}
+	case book.Deadline.Before(tomorrow):
+		return []Warning{{Code: "deadline_too_soon", Message: "期限が今日中です。明日以降の日付でないか確認してください。"}}
+	case book.Deadline.After(now.AddDate(maxYears, 0, 0)):
+		return []Warning{{Code: "deadline_too_far", Message: fmt.Sprintf("期限 %s は%d年以上先です。年の入力を確認してください。", book.Deadline.In(jst).Format("2006/01/02"), maxYears)}}
+	}
+	return nil
+}
+
+// rejectInsaneDeadline は確認なしで問題のある期限が送られてきたら 422 を返して true を返す。
+// 確認済みなら警告を返し、登録・更新のレスポンスに添える。
+func rejectInsaneDeadline(w http.ResponseWriter, r *http.Request, book Book) (bool, []Warning) {
+	warnings := deadlineSanityWarnings(book, clock.Now())
+	if len(warnings) == 0 || r.URL.Query().Get("confirmDeadline") == "true" {
+		return false, warnings
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":    "deadline needs confirmation; resend with ?confirmDeadline=true to save it anyway",
+		"warnings": warnings,
+	})
+	return true, nil
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// 外部 API の障害時の縮退。LINE とモデレーション API にもサーキットブレーカーを置き、開いている間は
+// ユーザーのリクエストを失敗させずに、督促は定型文だけにし、LINE への送信はジョブに積んで後で送る。
+const (
+	lineBreakerThreshold       = 5
+	lineBreakerCooldown        = time.Minute
+	moderationBreakerThreshold = 3
+	moderationBreakerCooldown  = time.Minute
+)
+
+var (
+	lineBreaker       = &circuitBreaker{name: "line", threshold: lineBreakerThreshold, cooldown: lineBreakerCooldown}
+	moderationBreaker = &circuitBreaker{name: "moderation", threshold: moderationBreakerThreshold, cooldown: moderationBreakerCooldown}
+
+	errLineUnavailable = errors.New("LINE API is unavailable (circuit breaker open)")
+)
+
+// breakerState は管理画面に出すブレーカーの状態
+type breakerState struct {
+	Open     bool       `json:"open"`
+	Failures int        `json:"consecutive_failures"`
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+	RetryAt  *time.Time `json:"retry_at,omitempty"`
+}
+
+func (cb *circuitBreaker) state() breakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	s := breakerState{Failures: cb.failures}
+	if cb.failures >= cb.threshold {
+		openedAt, retryAt := cb.openedAt, cb.openedAt.Add(cb.cooldown)
+		s.Open, s.OpenedAt, s.RetryAt = time.Now().Before(retryAt), &openedAt, &retryAt
+	}
+	return s
+}
+
+// degradedDependencies は縮退中の外部 API (LINE・モデレーション)
+func degradedDependencies() []string {
+	var degraded []string
+	if lineBreaker.isOpen() {
+		degraded = append(degraded, "line")
+	}
+	if moderationBreaker.isOpen() {
+		degraded = append(degraded, "moderation")
+	}
+	return degraded
+}
+
+// degradedFeatures は縮退中に振る舞いが変わっている機能
+func degradedFeatures() []string {
+	features := []string{}
+	if lineBreaker.isOpen() {
+		features = append(features, "line_delivery_queued")
+	}
+	if moderationBreaker.isOpen() {
+		features = append(features, "canned_insults_only", "custom_insults_pending_review")
+	}
+	return features
+}
+
+// handleAdminHealth は GET /api/admin/health。ブレーカーごとの状態と縮退中の機能を返す。
+func handleAdminHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	degraded := degradedDependencies()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ready":    !dbBreaker.isOpen(),
+		"degraded": len(degraded) > 0,
+		"dependencies": map[string]breakerState{
+			"supabase":   dbBreaker.state(),
+			"line":       lineBreaker.state(),
+			"moderation": moderationBreaker.state(),
+		},
+		"degraded_features": degradedFeatures(),
+		"environment":       appEnv(),
+		"sandbox":           sandboxMode(),
+		"clock_offset":      appClock.Offset().String(),
+	})
+}
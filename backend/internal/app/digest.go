@@ -0,0 +1,93 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// activeStatuses は積読として扱うステータス
+var activeStatuses = []string{"unread", "reading", "insulted"}
+
+// handleWeeklyDigest は外部スケジューラーから週1回呼ばれ、ユーザーごとの積読レポートを送信キューに積む
+func handleWeeklyDigest(w http.ResponseWriter, r *http.Request) {
+	if !authorizeCron(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	resp, _, err := execute(supabaseClient.From("books").Select("*", "", false).In("status", activeStatuses).Eq("archived", "false"))
+	if err != nil {
+		log.Printf("[ERROR] handleWeeklyDigest query error: %v", err)
+		opsAlerts.recordCron("digest", err, clock.Now())
+		http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var books []Book
+	if err := json.Unmarshal(resp, &books); err != nil {
+		log.Printf("[ERROR] handleWeeklyDigest unmarshal error: %v", err)
+	}
+
+	byUser := make(map[string][]Book)
+	for _, b := range books {
+		byUser[b.UserID] = append(byUser[b.UserID], b)
+	}
+
+	count := 0
+	for userID, userBooks := range byUser {
+		lineUserID, err := lineUserIDFor(userID)
+		if err != nil || lineUserID == "" {
+			log.Printf("[WARNING] skipping digest for user %s: %v", userID, err)
+			continue
+		}
+		pace, err := userReadingPace(userID)
+		if err != nil {
+			log.Printf("[WARNING] pace lookup failed for user %s: %v", userID, err)
+		}
+		err = enqueueJob(NotificationJob{
+			Kind:       jobKindDigest,
+			UserID:     userID,
+			LineUserID: lineUserID,
+			Message:    buildDigestMessage(userBooks, pace, clock.Now()),
+			RunAt:      clock.Now(),
+		})
+		if err != nil {
+			log.Printf("[ERROR] failed to enqueue digest for user %s: %v", userID, err)
+			continue
+		}
+		count++
+	}
+
+	log.Printf("[INFO] handleWeeklyDigest queued %d digests", count)
+	opsAlerts.recordCron("digest", nil, clock.Now())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": fmt.Sprintf("Queued %d weekly digests.", count)})
+}
+
+// buildDigestMessage は週報の本文を作る。次に読む本は GET /api/books/recommend-next と同じ基準で選ぶ。
+// 期限に間に合わなくなりそうな本 (triage.go の at_risk) があれば先頭に並べる。
+func buildDigestMessage(books []Book, pace readingPace, now time.Time) string {
+	data := MessageData{UnreadCount: len(books)}
+	for _, b := range books {
+		if b.Deadline.Before(now) {
+			data.OverdueCount++
+		}
+		if b.Price != nil {
+			data.MoneyWasted += *b.Price
+		}
+	}
+	if recs := recommendNext(books, pace, now); len(recs) > 0 {
+		data.NextTitle = recs[0].Book.Title
+		data.NextDeadline = recs[0].Book.Deadline.Format("2006/01/02")
+		data.NextReason = strings.Join(recs[0].Reasons, "・")
+	} else if next := nextUpBook(books); next != nil {
+		// 読書中の本しかなければ並び順で選ぶ
+		data.NextTitle = next.Title
+		data.NextDeadline = next.Deadline.Format("2006/01/02")
+	}
+	message := renderMessage(digestText, data, fmt.Sprintf("📚 今週の積読レポート\n積読: %d冊", len(books)))
+	return triageDigestSection(books, pace, now) + message + archiveDigestSection(books, now)
+}
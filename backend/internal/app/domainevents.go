@@ -0,0 +1,157 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ドメインイベント。book.* の変更イベントと同じ BookEvent で運ぶ。
+const (
+	eventBookCreated    = "book.created"
+	eventBookCompleted  = "book.completed"
+	eventDeadlineMissed = "deadline.missed" // 期限切れの督促を積んだとき (シリーズは代表の1冊)
+	eventInsultSent     = "insult.sent"     // 督促の送信に成功したとき
+	eventProgressLogged = "progress.logged" // 進捗を記録したとき
+	eventAny            = "*"
+)
+
+const domainWebhookTimeout = 10 * time.Second
+
+type domainHandler struct {
+	name string
+	fn   func(BookEvent)
+}
+
+// domainBus はハンドラーが発行したイベントを購読者 (キャッシュ・SSE・Notion・通知・Webhook) に配る。
+// 購読者は発行した goroutine で順に呼ばれるので、時間のかかる処理は自分で goroutine に逃がす。
+type domainBus struct {
+	mu       sync.RWMutex
+	handlers map[string][]domainHandler
+}
+
+var domainEvents = &domainBus{handlers: make(map[string][]domainHandler)}
+
+// subscribeDomain は eventType ("*" なら全イベント) の購読者を登録する
+func subscribeDomain(eventType, name string, fn func(BookEvent)) {
+	domainEvents.mu.Lock()
+	defer domainEvents.mu.Unlock()
+	domainEvents.handlers[eventType] = append(domainEvents.handlers[eventType], domainHandler{name: name, fn: fn})
+}
+
+// publishDomain は購読者を呼ぶ。1つの購読者の panic で発行元や他の購読者を止めない。
+func publishDomain(ev BookEvent) {
+	if ev.At.IsZero() {
+		ev.At = clock.Now()
+	}
+	domainEvents.mu.RLock()
+	handlers := append(append([]domainHandler{}, domainEvents.handlers[eventAny]...), domainEvents.handlers[ev.Type]...)
+	domainEvents.mu.RUnlock()
+	for _, h := range handlers {
+		func() {
+			defer func() {
+				if p := recover(); p != nil {
+					log.Printf("[ERROR] domain event consumer %s panicked on %s: %v", h.name, ev.Type, p)
+				}
+			}()
+			h.fn(ev)
+		}()
+	}
+}
+
+// initDomainConsumers は各サブシステムの購読者を登録する。HTTP ハンドラーはイベントを発行するだけにする。
+func initDomainConsumers() {
+	// 一覧・統計はどちらも書籍一覧キャッシュから作るので、まず破棄する
+	subscribeDomain(eventAny, "cache", func(ev BookEvent) { invalidateBooks(ev.UserID) })
+	subscribeDomain(eventAny, "sse", bookEvents.publish)
+	subscribeDomain(eventAny, "notion", func(ev BookEvent) {
+		// 督促系のイベントでは書籍の内容は変わらない
+		if strings.HasPrefix(ev.Type, "book.") {
+			queueNotionPush(ev)
+		}
+	})
+	subscribeDomain(eventBookCompleted, "review-nudge", func(ev BookEvent) {
+		if ev.Book != nil {
+			scheduleReviewNudge(*ev.Book)
+		}
+	})
+	subscribeDomain(eventBookCompleted, "pace", func(ev BookEvent) {
+		go func() {
+			if _, err := recalibrateReadingPace(ev.UserID); err != nil {
+				log.Printf("[ERROR] failed to recalibrate pace for user %s: %v", ev.UserID, err)
+			}
+		}()
+	})
+	subscribeDomain(eventBookCompleted, "challenges", func(ev BookEvent) { go updateChallengeProgress(ev.UserID) })
+	subscribeDomain(eventBookCompleted, "certificate", func(ev BookEvent) {
+		if ev.Book != nil {
+			go sendCompletionCertificate(*ev.Book)
+		}
+	})
+	subscribeDomain(eventBookCompleted, "buddy", func(ev BookEvent) { go finishBuddy(ev) })
+	subscribeDomain(eventProgressLogged, "buddy", func(ev BookEvent) { go relayBuddyProgress(ev) })
+	subscribeDomain(eventDeadlineMissed, "group-shame", enqueueGroupShameOnce)
+	subscribeDomain(eventDeadlineMissed, "stakes", triggerStake)
+	if url := os.Getenv("EVENT_WEBHOOK_URL"); url != "" {
+		subscribeDomain(eventAny, "webhook", func(ev BookEvent) { go postDomainWebhook(url, ev) })
+		log.Printf("[INFO] forwarding domain events to %s", url)
+	}
+}
+
+// enqueueGroupShameOnce は送信待ちの晒しジョブがなければ積む
+func enqueueGroupShameOnce(ev BookEvent) {
+	if ev.Book == nil {
+		return
+	}
+	pending, err := pendingJobBookIDs([]string{ev.UserID}, jobKindGroupShame)
+	if err != nil {
+		log.Printf("[ERROR] pending group shame query error for book %s: %v", ev.BookID, err)
+		return
+	}
+	if pending[ev.BookID] {
+		return
+	}
+	if err := enqueueGroupShame(*ev.Book, ev.At); err != nil {
+		log.Printf("[ERROR] Failed to enqueue group shame for book %s: %v", ev.BookID, err)
+	}
+}
+
+// postDomainWebhook は EVENT_WEBHOOK_URL にイベントを POST する。
+// EVENT_WEBHOOK_SECRET があれば本文の HMAC-SHA256 を X-Tundoku-Signature に付ける。
+func postDomainWebhook(url string, ev BookEvent) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("[ERROR] domain webhook marshal error: %v", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[ERROR] domain webhook request error: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Tundoku-Event", ev.Type)
+	if secret := os.Getenv("EVENT_WEBHOOK_SECRET"); secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-Tundoku-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	client := &http.Client{Timeout: domainWebhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("[WARNING] domain webhook %s for book %s failed: %v", ev.Type, ev.BookID, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("[WARNING] domain webhook %s for book %s returned %d", ev.Type, ev.BookID, resp.StatusCode)
+	}
+}
@@ -0,0 +1,210 @@
+package app
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	effectivenessDefaultWindow   = 7  // 督促から何日以内の反応を数えるか
+	effectivenessDefaultLookback = 90 // 何日前までの督促を集計するか
+)
+
+// InsultEffectiveness は督促の種類ごとの反応率
+type InsultEffectiveness struct {
+	Key            string  `json:"key"` // テンプレートキー、または insult_level
+	Sent           int     `json:"sent"`
+	Completed      int     `json:"completed"`  // window 日以内に読了した
+	Progressed     int     `json:"progressed"` // window 日以内に進捗記録か読書タイマーがあった (読了を含む)
+	CompletionRate float64 `json:"completion_rate"`
+	ProgressRate   float64 `json:"progress_rate"`
+}
+
+// authorizeAdmin は管理用 API の認証。管理用トークンか、admin ロールのユーザーのセッション (rbac.go) なら通す。
+func authorizeAdmin(r *http.Request) bool {
+	return roleAtLeast(actorRole(r), roleAdmin)
+}
+
+// adminTokenValid は ADMIN_API_TOKEN を確かめる。未設定なら誰も通さず、admin ロールのセッションだけが管理用 API を呼べる。
+func adminTokenValid(r *http.Request) bool {
+	token := os.Getenv("ADMIN_API_TOKEN")
+	if token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) == 1
+}
+
+// activityTimes は book_id ごとの記録時刻を since 以降について集める
+func activityTimes(table, column string, since time.Time) (map[string][]time.Time, error) {
+	resp, _, err := execute(supabaseClient.From(table).
+		Select("book_id, "+column, "", false).
+		Gte(column, since.Format(time.RFC3339)))
+	if err != nil {
+		return nil, err
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		return nil, err
+	}
+	times := make(map[string][]time.Time)
+	for _, row := range rows {
+		bookID, _ := row["book_id"].(string)
+		raw, _ := row[column].(string)
+		t, err := time.Parse(time.RFC3339, raw)
+		if bookID == "" || err != nil {
+			continue
+		}
+		times[bookID] = append(times[bookID], t)
+	}
+	return times, nil
+}
+
+// anyWithin は ids のいずれかに [from, to] の記録があるか
+func anyWithin(times map[string][]time.Time, ids []string, from, to time.Time) bool {
+	for _, id := range ids {
+		for _, t := range times[id] {
+			if !t.Before(from) && !t.After(to) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sortedEffectiveness(byKey map[string]*InsultEffectiveness) []InsultEffectiveness {
+	result := make([]InsultEffectiveness, 0, len(byKey))
+	for _, e := range byKey {
+		e.CompletionRate = float64(e.Completed) / float64(e.Sent)
+		e.ProgressRate = float64(e.Progressed) / float64(e.Sent)
+		result = append(result, *e)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CompletionRate != result[j].CompletionRate {
+			return result[i].CompletionRate > result[j].CompletionRate
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// handleInsultEffectiveness は GET /api/admin/insults/effectiveness?window=7&days=90。
+// 送信済みの督促ごとに window 日以内に読了・進捗があったかを調べ、テンプレート別と insult_level 別に集計する。
+// window 日が経っていない督促はまだ結果が出ていないので数えない。
+func handleInsultEffectiveness(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	window, lookback := effectivenessDefaultWindow, effectivenessDefaultLookback
+	for name, dst := range map[string]*int{"window": &window, "days": &lookback} {
+		if v := r.URL.Query().Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > 365 {
+				http.Error(w, name+" must be between 1 and 365", http.StatusBadRequest)
+				return
+			}
+			*dst = n
+		}
+	}
+
+	now := clock.Now()
+	since := now.AddDate(0, 0, -lookback)
+	cutoff := now.AddDate(0, 0, -window)
+
+	resp, _, err := execute(supabaseClient.From("notification_jobs").
+		Select("job_id, book_id, book_ids, template, insult_level, sent_at", "", false).
+		Eq("kind", jobKindInsult).
+		Eq("status", "sent").
+		Gte("sent_at", since.Format(time.RFC3339)).
+		Lte("sent_at", cutoff.Format(time.RFC3339)))
+	if err != nil {
+		log.Printf("[ERROR] handleInsultEffectiveness job query error: %v", err)
+		http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var jobs []NotificationJob
+	if err := json.Unmarshal(resp, &jobs); err != nil {
+		log.Printf("[ERROR] handleInsultEffectiveness unmarshal error: %v", err)
+	}
+
+	completions, err := activityTimes("book_completions", "completed_at", since)
+	if err != nil {
+		log.Printf("[ERROR] handleInsultEffectiveness completions query error: %v", err)
+		http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	progress, err := activityTimes("progress_logs", "logged_at", since)
+	if err != nil {
+		log.Printf("[ERROR] handleInsultEffectiveness progress query error: %v", err)
+		http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	sessions, err := activityTimes("reading_sessions", "ended_at", since)
+	if err != nil {
+		log.Printf("[ERROR] handleInsultEffectiveness sessions query error: %v", err)
+		http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	byTemplate := map[string]*InsultEffectiveness{}
+	byLevel := map[string]*InsultEffectiveness{}
+	for _, job := range jobs {
+		if job.SentAt == nil {
+			continue
+		}
+		ids := job.targetBookIDs()
+		end := job.SentAt.AddDate(0, 0, window)
+		completed := anyWithin(completions, ids, *job.SentAt, end)
+		progressed := completed || anyWithin(progress, ids, *job.SentAt, end) || anyWithin(sessions, ids, *job.SentAt, end)
+
+		template := job.Template
+		switch {
+		case template == "":
+			template = "unknown" // テンプレートを記録する前の督促
+		case strings.HasPrefix(template, customInsultPrefix):
+			template = "custom" // ユーザーが登録した督促文はまとめて1行にする
+		}
+		level := "unknown"
+		if job.InsultLevel != nil {
+			level = strconv.Itoa(*job.InsultLevel)
+		}
+		for _, g := range []struct {
+			groups map[string]*InsultEffectiveness
+			key    string
+		}{{byTemplate, template}, {byLevel, level}} {
+			e, ok := g.groups[g.key]
+			if !ok {
+				e = &InsultEffectiveness{Key: g.key}
+				g.groups[g.key] = e
+			}
+			e.Sent++
+			if completed {
+				e.Completed++
+			}
+			if progressed {
+				e.Progressed++
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"window_days":   window,
+		"lookback_days": lookback,
+		"total_sent":    len(jobs),
+		"by_template":   sortedEffectiveness(byTemplate),
+		"by_level":      sortedEffectiveness(byLevel),
+	})
+}
@@ -0,0 +1,258 @@
+package app
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	errorReportTimeout  = 5 * time.Second
+	errorReportBodySize = 1024 // 5xx の本文 (http.Error のメッセージ) をこの長さまで送る
+)
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// sentryTarget は SENTRY_DSN (https://<key>@<host>/<project>) から組み立てた送信先。
+// GlitchTip など Sentry 互換のサービスも同じ DSN 形式で使える。
+type sentryTarget struct {
+	storeURL string
+	key      string
+}
+
+func parseSentryDSN(dsn string) (*sentryTarget, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	project := strings.Trim(u.Path, "/")
+	if u.User == nil || u.User.Username() == "" || project == "" {
+		return nil, fmt.Errorf("SENTRY_DSN must look like https://<key>@<host>/<project>")
+	}
+	return &sentryTarget{
+		storeURL: fmt.Sprintf("%s://%s/api/%s/store/", u.Scheme, u.Host, project),
+		key:      u.User.Username(),
+	}, nil
+}
+
+// errorSampleRate は SENTRY_SAMPLE_RATE (0〜1, 既定 1)。panic は常に送る。
+func errorSampleRate() float64 {
+	v := os.Getenv("SENTRY_SAMPLE_RATE")
+	if v == "" {
+		return 1
+	}
+	rate, err := strconv.ParseFloat(v, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		log.Printf("[WARNING] invalid SENTRY_SAMPLE_RATE=%q, using 1", v)
+		return 1
+	}
+	return rate
+}
+
+// errorReport は1件分のリクエスト文脈
+type errorReport struct {
+	Message   string
+	Level     string // error, fatal
+	Route     string
+	Method    string
+	URL       string
+	UserID    string
+	RequestID string
+	Stack     []map[string]interface{}
+}
+
+// captureError は SENTRY_DSN が設定されていれば非同期で送る。送信の失敗はログだけ残す。
+func captureError(rep errorReport) {
+	dsn := os.Getenv("SENTRY_DSN")
+	if dsn == "" {
+		return
+	}
+	if rep.Level != "fatal" && rand.Float64() >= errorSampleRate() {
+		return
+	}
+	target, err := parseSentryDSN(dsn)
+	if err != nil {
+		log.Printf("[ERROR] error reporting disabled: %v", err)
+		return
+	}
+	event := map[string]interface{}{
+		"event_id":    newEventID(),
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+		"platform":    "go",
+		"level":       rep.Level,
+		"logger":      "tundoku-killer",
+		"message":     rep.Message,
+		"environment": os.Getenv("SENTRY_ENVIRONMENT"),
+		"release":     os.Getenv("SENTRY_RELEASE"),
+		"transaction": rep.Route,
+		"tags":        map[string]string{"route": rep.Route, "request_id": rep.RequestID},
+		"request":     map[string]string{"method": rep.Method, "url": rep.URL},
+	}
+	if rep.UserID != "" {
+		event["user"] = map[string]string{"id": rep.UserID}
+	}
+	if len(rep.Stack) > 0 {
+		event["exception"] = map[string]interface{}{"values": []interface{}{map[string]interface{}{
+			"type":       "panic",
+			"value":      rep.Message,
+			"stacktrace": map[string]interface{}{"frames": rep.Stack},
+		}}}
+	}
+	go sendSentryEvent(target, event)
+}
+
+func sendSentryEvent(target *sentryTarget, event map[string]interface{}) {
+	body, _ := json.Marshal(event)
+	req, err := http.NewRequest(http.MethodPost, target.storeURL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[ERROR] error report request error: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=tundoku-killer/1.0, sentry_key=%s", target.key))
+	client := &http.Client{Timeout: errorReportTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("[WARNING] failed to send error report: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("[WARNING] error report rejected with %d", resp.StatusCode)
+	}
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// panicStack は Sentry の frames 形式 (古い呼び出しが先) でスタックを返す
+func panicStack(skip int) []map[string]interface{} {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var out []map[string]interface{}
+	for {
+		f, more := frames.Next()
+		out = append(out, map[string]interface{}{
+			"function": f.Function,
+			"filename": f.File,
+			"lineno":   f.Line,
+			"in_app":   strings.HasPrefix(f.Function, "main."),
+		})
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// requestID は X-Request-Id を引き継ぐ。なければ (または不正なら) 採番する。
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-Id"); requestIDPattern.MatchString(id) {
+		return id
+	}
+	b := make([]byte, 8)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// reportingResponseWriter は 5xx のステータスと本文の先頭を覚えておく
+type reportingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *reportingResponseWriter) WriteHeader(code int) {
+	if rw.status == 0 {
+		rw.status = code
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *reportingResponseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	if rw.status >= 500 && rw.body.Len() < errorReportBodySize {
+		rw.body.Write(b[:min(len(b), errorReportBodySize-rw.body.Len())])
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// Flush は SSE (handleEvents) のために必要
+func (rw *reportingResponseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rw *reportingResponseWriter) Unwrap() http.ResponseWriter { return rw.ResponseWriter }
+
+// withErrorReporting はリクエストIDを振り、panic と 5xx をルート・ユーザー・リクエストID付きで報告する
+func withErrorReporting(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := requestID(r)
+		w.Header().Set("X-Request-Id", id)
+		rw := &reportingResponseWriter{ResponseWriter: w}
+		report := func(level, message string, stack []map[string]interface{}) {
+			route := r.Pattern
+			if route == "" {
+				route = r.URL.Path
+			}
+			captureError(errorReport{
+				Message:   message,
+				Level:     level,
+				Route:     route,
+				Method:    r.Method,
+				URL:       r.URL.Path,
+				UserID:    r.URL.Query().Get("userId"),
+				RequestID: id,
+				Stack:     stack,
+			})
+		}
+		// 異常検知 (alerts.go) の集計。panic から戻した後の 500 も数えるよう最後に走らせる。
+		defer func() {
+			status := rw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			opsAlerts.recordRequest(status, clock.Now())
+		}()
+		defer func() {
+			if p := recover(); p != nil {
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				log.Printf("[ERROR] panic in %s %s (request %s): %v", r.Method, r.URL.Path, id, p)
+				report("fatal", fmt.Sprint(p), panicStack(4))
+				if rw.status == 0 {
+					http.Error(rw, "Internal server error (request id: "+id+")", http.StatusInternalServerError)
+				}
+				return
+			}
+			if rw.status >= 500 {
+				report("error", fmt.Sprintf("%d %s %s: %s", rw.status, r.Method, r.URL.Path, strings.TrimSpace(rw.body.String())), nil)
+			}
+		}()
+		next(rw, r)
+	}
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+// 督促を無視し続けたときのエスカレーション。いつもの送り先に K 回督促しても期限切れのままなら、
+// ユーザーが決めた2つ目の送り先 (users.escalate_to) に1回だけ知らせる。期限を延ばすと数え直す。
+const escalateToPartner = "partner" // 本の賭けで同意済みの立会人 (stakes.go)
+
+var escalationTargets = []string{notifyChannelLine, notifyChannelEmail, escalateToPartner}
+
+func validEscalationTarget(to string) bool {
+	return slices.Contains(escalationTargets, to)
+}
+
+// sentInsultCount は今回の期限切れ以降に primary で届いた督促の数
+func sentInsultCount(book Book, primary string) (int64, error) {
+	_, n, err := execute(supabaseClient.From("notification_jobs").
+		Select("job_id", "exact", true).
+		Eq("kind", jobKindInsult).
+		Eq("status", "sent").
+		Eq("channel", primary).
+		Or(fmt.Sprintf("book_id.eq.%s,book_ids.cs.{%s}", book.BookID, book.BookID), "").
+		Gte("created_at", book.Deadline.Format(time.RFC3339)))
+	return n, err
+}
+
+func escalatedSince(book Book) (bool, error) {
+	_, n, err := execute(supabaseClient.From("notification_jobs").
+		Select("job_id", "exact", true).
+		Eq("kind", jobKindEscalation).
+		Eq("book_id", book.BookID).
+		Gte("created_at", book.Deadline.Format(time.RFC3339)))
+	return n > 0, err
+}
+
+// maybeEscalate は primary に督促を積んだ後に呼び、無視された回数が閾値に達していればエスカレーションを積む
+func maybeEscalate(book Book, settings *NotifySettings, primary string, now time.Time) {
+	if settings == nil || settings.EscalateAfter == nil || settings.EscalateTo == nil {
+		return
+	}
+	to := *settings.EscalateTo
+	if to == primary {
+		return
+	}
+	sent, err := sentInsultCount(book, primary)
+	if err != nil {
+		log.Printf("[ERROR] failed to count insults for book %s: %v", book.BookID, err)
+		return
+	}
+	if sent < int64(*settings.EscalateAfter) {
+		return
+	}
+	if done, err := escalatedSince(book); err != nil || done {
+		return
+	}
+
+	job := NotificationJob{Kind: jobKindEscalation, BookID: book.BookID, UserID: book.UserID, RunAt: now}
+	switch to {
+	case escalateToPartner:
+		stake, err := fetchStake(book.BookID)
+		if err != nil || stake == nil || stake.ContactUserID == nil || stake.ConsentedAt == nil {
+			log.Printf("[INFO] cannot escalate book %s to a partner: no consenting contact", book.BookID)
+			return
+		}
+		if job.LineUserID, err = lineUserIDFor(*stake.ContactUserID); err != nil || job.LineUserID == "" {
+			return
+		}
+		job.Channel = notifyChannelLine
+		job.Message = fmt.Sprintf("📣 %sさんは『%s』の期限を過ぎたまま、督促を%d回無視しています。立会人として声をかけてあげてください。", stakeOwnerName(book.UserID), book.Title, sent)
+	default:
+		if !settings.available(to) {
+			log.Printf("[INFO] cannot escalate book %s to %s: channel not available", book.BookID, to)
+			return
+		}
+		job.Channel, job.LineUserID = to, settings.lineUserID()
+		job.Message = fmt.Sprintf("📣 『%s』の督促を%d回無視しています。いい加減に読みましょう。", book.Title, sent)
+	}
+	if err := enqueueJob(job); err != nil {
+		log.Printf("[ERROR] failed to enqueue escalation for book %s: %v", book.BookID, err)
+		return
+	}
+	log.Printf("[INFO] escalated book %s to %s after %d ignored insults", book.BookID, to, sent)
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// definitionsModifiedAt はコードに持っている定義 (トーン・実績など) の Last-Modified。デプロイでしか変わらないので起動時刻にする。
+var definitionsModifiedAt = time.Now()
+
+// booksETag は一覧の弱い ETag を max(updated_at) と件数から計算する
+func booksETag(books []Book) string {
+	var latest int64
+	for _, b := range books {
+		if t := b.UpdatedAt.UnixNano(); t > latest {
+			latest = t
+		}
+	}
+	return fmt.Sprintf(`W/"%d-%x"`, len(books), latest)
+}
+
+// etagMatches は If-None-Match ヘッダーが etag に一致するかを弱い比較で判定する
+func etagMatches(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == want {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified は Cache-Control と、あれば ETag・Last-Modified を付け、クライアントの持っている版から変わっていなければ
+// 304 を返して true にする。If-None-Match があれば If-Modified-Since より優先する。
+// modified がゼロ (一度も更新がない) なら Last-Modified は付けない。
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time, cacheControl string) bool {
+	w.Header().Set("Cache-Control", cacheControl)
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	// HTTP の日付は秒単位なので切り捨てて比べる
+	modified = modified.UTC().Truncate(time.Second)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	}
+	unchanged := false
+	if r.Header.Get("If-None-Match") != "" {
+		unchanged = etag != "" && etagMatches(r, etag)
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.IsZero() {
+		unchanged = !modified.After(since)
+	}
+	if unchanged {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return unchanged
+}
@@ -0,0 +1,142 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const sseHeartbeatInterval = 25 * time.Second
+
+// BookEvent は書籍の変更や督促のドメインイベント。SSE でもそのまま配信する。
+type BookEvent struct {
+	Type   string    `json:"type"` // book.created, book.updated, book.completed, book.deleted, deadline.missed, insult.sent, progress.logged
+	BookID string    `json:"book_id"`
+	UserID string    `json:"user_id"`
+	Book   *Book     `json:"book,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// eventBroker はユーザーごとの購読者にイベントを配る in-process のブローカー
+type eventBroker struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[chan BookEvent]struct{}
+}
+
+var bookEvents = &eventBroker{subscribers: make(map[string]map[chan BookEvent]struct{})}
+
+func (b *eventBroker) subscribe(userID string) chan BookEvent {
+	ch := make(chan BookEvent, 16)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = make(map[chan BookEvent]struct{})
+	}
+	b.subscribers[userID][ch] = struct{}{}
+	return ch
+}
+
+func (b *eventBroker) unsubscribe(userID string, ch chan BookEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers[userID], ch)
+	if len(b.subscribers[userID]) == 0 {
+		delete(b.subscribers, userID)
+	}
+}
+
+// publish は購読者へイベントを送る。詰まっている購読者への送信は捨てる。
+func (b *eventBroker) publish(ev BookEvent) {
+	if ev.At.IsZero() {
+		ev.At = clock.Now()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subscribers[ev.UserID] {
+		select {
+		case ch <- ev:
+		default:
+			log.Printf("[WARNING] dropping %s event for slow subscriber of user %s", ev.Type, ev.UserID)
+		}
+	}
+}
+
+// emitBookEvent は書き込み後に呼ぶ。キャッシュ破棄や配信は domainevents.go の購読者が行う。
+func emitBookEvent(ev BookEvent) {
+	publishDomain(ev)
+}
+
+// emitBookRows は PostgREST の representation レスポンスから書籍ごとにイベントを発行する
+func emitBookRows(eventType string, rawResp []byte) {
+	var books []Book
+	if err := json.Unmarshal(openRows("books", rawResp), &books); err != nil {
+		log.Printf("[ERROR] emitBookRows unmarshal error: %v", err)
+		return
+	}
+	for i := range books {
+		emitBookEvent(BookEvent{Type: eventType, BookID: books[i].BookID, UserID: books[i].UserID, Book: &books[i]})
+	}
+}
+
+// handleEvents は GET /api/events?access_token=<セッショントークン>。ログイン中のユーザーの書籍イベントを SSE で流す。
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// EventSource はヘッダーを付けられないため、セッショントークンはクエリ (?access_token=) でも受け取る。
+	// 購読するユーザーはセッションから決め、クエリの userId は信用しない。
+	if token := r.URL.Query().Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	session, err := authenticateSession(r)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	userId := session.UserID
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// 接続し続けるので、サーバーの WriteTimeout で切られないようにする
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("[WARNING] handleEvents cannot clear write deadline: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := bookEvents.subscribe(userId)
+	defer bookEvents.unsubscribe(userId, ch)
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case ev := <-ch:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				log.Printf("[ERROR] handleEvents marshal error: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	exportFormatVersion = 1
+	exportURLTTL        = time.Hour
+)
+
+// ExportedBook はエクスポート形式での書籍 (メモ・引用を含む)
+type ExportedBook struct {
+	Book
+	Notes []Note `json:"notes"`
+}
+
+// LibraryExport はユーザーの蔵書エクスポート
+type LibraryExport struct {
+	Version    int            `json:"version"`
+	ExportedAt time.Time      `json:"exported_at"`
+	UserID     string         `json:"user_id"`
+	Books      []ExportedBook `json:"books"`
+}
+
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+
+	export, err := buildLibraryExport(userId)
+	if err != nil {
+		log.Printf("[ERROR] handleExport error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to export: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// 大きな蔵書でもハンドラーを占有しないよう Storage に置いて署名付き URL を返す。
+	// ?inline=true か Storage に書けなかったときだけ本文で返す。
+	if r.URL.Query().Get("inline") != "true" {
+		data, _ := json.Marshal(export)
+		url, err := storeAndSign(fmt.Sprintf("exports/%s/library.json", userId), "application/json", data, exportURLTTL, "tundoku-export.json")
+		if err == nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"download_url": url,
+				"expires_at":   clock.Now().Add(exportURLTTL),
+				"books":        len(export.Books),
+			})
+			return
+		}
+		log.Printf("[WARNING] handleExport storage error, returning inline: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="tundoku-export.json"`)
+	json.NewEncoder(w).Encode(export)
+}
+
+func buildLibraryExport(userID string) (*LibraryExport, error) {
+	resp, _, err := execute(supabaseClient.From("books").Select("*", "", false).Eq("user_id", userID))
+	if err != nil {
+		return nil, err
+	}
+	var books []Book
+	if err := json.Unmarshal(openRows("books", resp), &books); err != nil {
+		return nil, err
+	}
+
+	nResp, _, err := execute(supabaseClient.From("book_notes").Select("*", "", false).Eq("user_id", userID))
+	if err != nil {
+		return nil, err
+	}
+	var notes []Note
+	if err := json.Unmarshal(openRows("book_notes", nResp), &notes); err != nil {
+		return nil, err
+	}
+	notesByBook := make(map[string][]Note)
+	for _, n := range notes {
+		notesByBook[n.BookID] = append(notesByBook[n.BookID], n)
+	}
+
+	export := &LibraryExport{Version: exportFormatVersion, ExportedAt: clock.Now(), UserID: userID, Books: []ExportedBook{}}
+	for _, b := range books {
+		export.Books = append(export.Books, ExportedBook{Book: b, Notes: notesByBook[b.BookID]})
+	}
+	return export, nil
+}
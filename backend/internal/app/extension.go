@@ -0,0 +1,319 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// 期限の延長。督促に LINE で「あと1週間」(または「期限 来週末」のような日付) と返すと、その督促の本の期限を延ばし、ユーザーが選んだペナルティを科す。
+// アプリからは POST /api/books/{id}/extend で同じことができる。延長は本ごとに DEADLINE_MAX_EXTENSIONS 回 (既定 3) まで。
+const (
+	extensionPenaltyNone     = "none"
+	extensionPenaltyInsultUp = "insult_up" // insult_level を1つ上げる (既定)
+
+	defaultMaxExtensions = 3
+	maxExtensionDays     = 31
+	extensionReplyWindow = 72 * time.Hour // この時間内に届いた督促への返信だけを延長として扱う
+)
+
+var (
+	validExtensionPenalties = map[string]bool{extensionPenaltyNone: true, extensionPenaltyInsultUp: true}
+
+	extensionPattern = regexp.MustCompile(`^あと\s*([0-9]{1,2}|[一二三四五六七八九十]{1,2})\s*(日|週間|週|ヶ月|か月|カ月|ヵ月|ケ月)$`)
+	kanjiDigits      = map[rune]int{'一': 1, '二': 2, '三': 3, '四': 4, '五': 5, '六': 6, '七': 7, '八': 8, '九': 9}
+
+	// deadlineCommandPattern は「期限 来週末」「期限は10/20まで」のような日付での延長 (deadlineinput.go)
+	deadlineCommandPattern = regexp.MustCompile(`^期限\s*(?:は|を|:|：)?\s*(.+?)\s*(?:まで|に(?:して|延長)?)?$`)
+
+	errExtensionLimit  = errors.New("extension limit reached")
+	errNotExtendable   = errors.New("book has no deadline to extend")
+	errNoRecentInsult  = errors.New("no recent insult to reply to")
+	errExtensionLength = fmt.Errorf("extension must be between 1 and %d days", maxExtensionDays)
+)
+
+// parseExtensionCommand は「あと1週間」「あと３日」「あと一ヶ月」を日数にする。該当しなければ ok = false。
+func parseExtensionCommand(text string) (days int, ok bool) {
+	m := extensionPattern.FindStringSubmatch(normalizeBookText(text))
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		n = parseKanjiNumber(m[1])
+	}
+	switch m[2] {
+	case "日":
+		days = n
+	case "週間", "週":
+		days = n * 7
+	default:
+		days = n * 30
+	}
+	return days, true
+}
+
+// parseKanjiNumber は 1〜19 の漢数字 (「十」「十二」など) を読む
+func parseKanjiNumber(s string) int {
+	n := 0
+	for _, r := range s {
+		if r == '十' {
+			n = max(n, 1) * 10
+			continue
+		}
+		n += kanjiDigits[r]
+	}
+	return n
+}
+
+// userExtensionPenalty は users.extension_penalty。未設定なら insult_up。
+func userExtensionPenalty(userID string) (string, error) {
+	resp, _, err := execute(supabaseClient.From("users").Select("extension_penalty", "", false).Eq("id", userID))
+	if err != nil {
+		return extensionPenaltyInsultUp, err
+	}
+	var users []struct {
+		Penalty *string `json:"extension_penalty"`
+	}
+	json.Unmarshal(resp, &users)
+	if len(users) == 0 || users[0].Penalty == nil || !validExtensionPenalties[*users[0].Penalty] {
+		return extensionPenaltyInsultUp, nil
+	}
+	return *users[0].Penalty, nil
+}
+
+// extensionResult は延長1件分の結果
+type extensionResult struct {
+	BookID         string    `json:"book_id"`
+	Title          string    `json:"title"`
+	Deadline       time.Time `json:"deadline"`
+	Penalty        string    `json:"penalty"`
+	InsultLevel    int       `json:"insult_level"`
+	ExtensionCount int       `json:"extension_count"`
+}
+
+// extensionBase は延長を数え始める時刻。期限を過ぎていれば今。
+func extensionBase(book Book, now time.Time) time.Time {
+	if book.Deadline.Before(now) {
+		return now
+	}
+	return book.Deadline
+}
+
+// extendDeadline は book の期限を days 日延ばしてペナルティを科す。期限を過ぎていれば今から数える。
+func extendDeadline(book Book, days int) (*extensionResult, error) {
+	if days < 1 || days > maxExtensionDays {
+		return nil, errExtensionLength
+	}
+	return extendDeadlineTo(book, extensionBase(book, clock.Now()).AddDate(0, 0, days))
+}
+
+// extendDeadlineTo は book の期限を deadline まで延ばしてペナルティを科す。延ばせるのは今の期限 (過ぎていれば今) から maxExtensionDays 日まで。
+func extendDeadlineTo(book Book, deadline time.Time) (*extensionResult, error) {
+	if book.Deadline.IsZero() || (book.Status != "unread" && book.Status != "reading" && book.Status != "insulted") {
+		return nil, errNotExtendable
+	}
+	if base := extensionBase(book, clock.Now()); !deadline.After(base) || deadline.After(base.AddDate(0, 0, maxExtensionDays)) {
+		return nil, errExtensionLength
+	}
+	if book.ExtensionCount >= envInt("DEADLINE_MAX_EXTENSIONS", defaultMaxExtensions) {
+		return nil, errExtensionLimit
+	}
+	penalty, err := userExtensionPenalty(book.UserID)
+	if err != nil {
+		log.Printf("[ERROR] extension penalty lookup failed for user %s: %v", book.UserID, err)
+	}
+
+	now := clock.Now()
+	result := &extensionResult{
+		BookID:         book.BookID,
+		Title:          book.Title,
+		Deadline:       deadline,
+		Penalty:        penalty,
+		InsultLevel:    clampInsultLevel(book.InsultLevel),
+		ExtensionCount: book.ExtensionCount + 1,
+	}
+	if penalty == extensionPenaltyInsultUp {
+		result.InsultLevel = min(result.InsultLevel+1, insultLevelMax)
+	}
+	update := map[string]interface{}{
+		"deadline":        result.Deadline,
+		"insult_level":    result.InsultLevel,
+		"extension_count": result.ExtensionCount,
+		"updated_at":      now,
+	}
+	// 督促済みの本は新しい期限までは未読に戻す
+	if book.Status == "insulted" {
+		update["status"] = "unread"
+	}
+	// 延長回数で条件を付け、同時に2回延長されても1回分しか進まないようにする
+	rawResp, _, err := execute(supabaseClient.From("books").Update(update, "", "").
+		Eq("book_id", book.BookID).
+		Eq("user_id", book.UserID).
+		Eq("extension_count", strconv.Itoa(book.ExtensionCount)))
+	if err != nil {
+		return nil, err
+	}
+	var updated []Book
+	if json.Unmarshal(rawResp, &updated); len(updated) == 0 {
+		return nil, errExtensionLimit
+	}
+	emitBookRows("book.updated", rawResp)
+	log.Printf("[INFO] extended deadline of book %s to %s (penalty %s)", book.BookID, deadline.Format(time.RFC3339), penalty)
+	return result, nil
+}
+
+// handleExtendDeadline は POST /api/books/{id}/extend {user_id, days}
+func handleExtendDeadline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID string `json:"user_id"`
+		Days   int    `json:"days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	book, err := fetchOwnedBook(r.PathValue("id"), req.UserID)
+	if err != nil {
+		writeBookLookupError(w, err)
+		return
+	}
+	result, err := extendDeadline(book, req.Days)
+	switch {
+	case errors.Is(err, errExtensionLength):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, errNotExtendable), errors.Is(err, errExtensionLimit):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("[ERROR] handleExtendDeadline error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to extend deadline: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Deadline extended", "extension": result})
+}
+
+// recentInsultBookIDs は直近に LINE へ送った督促の本 (シリーズをまとめた督促なら全巻) を返す
+func recentInsultBookIDs(userID, lineUserID string) ([]string, error) {
+	resp, _, err := execute(supabaseClient.From("notification_jobs").
+		Select("book_id, book_ids", "", false).
+		Eq("user_id", userID).
+		Eq("line_user_id", lineUserID).
+		Eq("kind", jobKindInsult).
+		Eq("status", "sent").
+		Gte("sent_at", clock.Now().Add(-extensionReplyWindow).Format(time.RFC3339)).
+		Order("sent_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(1, ""))
+	if err != nil {
+		return nil, err
+	}
+	var jobs []NotificationJob
+	if err := json.Unmarshal(resp, &jobs); err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 || jobs[0].BookID == "" {
+		return nil, errNoRecentInsult
+	}
+	if len(jobs[0].BookIDs) > 0 {
+		return jobs[0].BookIDs, nil
+	}
+	return []string{jobs[0].BookID}, nil
+}
+
+// parseDeadlineCommand は「期限 来週末」の日付の部分を返す。該当しなければ ok = false。
+func parseDeadlineCommand(text string) (input string, ok bool) {
+	m := deadlineCommandPattern.FindStringSubmatch(normalizeBookText(text))
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// extensionCommandReply は督促への「あと1週間」「期限 来週末」の返信で期限を延ばし、新しい期限とペナルティを返信する。
+// 該当しないメッセージなら ok = false。
+func extensionCommandReply(lineUserID, text string) (reply string, ok bool) {
+	days, ok := parseExtensionCommand(text)
+	input, byDate := parseDeadlineCommand(text)
+	if !ok && !byDate {
+		return "", false
+	}
+	resp, _, err := execute(supabaseClient.From("users").Select("id", "", false).Eq("line_user_id", lineUserID))
+	if err != nil {
+		log.Printf("[ERROR] extension user lookup error: %v", err)
+		return "エラーが発生しました。時間をおいてもう一度送ってください。", true
+	}
+	var users []struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(resp, &users); len(users) == 0 {
+		return "", false
+	}
+	userID := users[0].ID
+	var until time.Time
+	if byDate {
+		var inputErr *deadlineInputError
+		if until, err = parseDeadlineInput(input, clock.Now(), userLocation(userID)); errors.As(err, &inputErr) {
+			return inputErr.Message + "。", true
+		}
+	} else if days < 1 || days > maxExtensionDays {
+		return fmt.Sprintf("延長できるのは1日から%d日までです。", maxExtensionDays), true
+	}
+
+	bookIDs, err := recentInsultBookIDs(userID, lineUserID)
+	if errors.Is(err, errNoRecentInsult) {
+		return "延長する本が分かりませんでした。督促が届いてから「あと1週間」のように返信してください。", true
+	}
+	if err != nil {
+		log.Printf("[ERROR] extension insult lookup error: %v", err)
+		return "エラーが発生しました。時間をおいてもう一度送ってください。", true
+	}
+
+	var lines []string
+	for _, bookID := range bookIDs {
+		book, err := fetchOwnedBook(bookID, userID)
+		if err != nil {
+			continue
+		}
+		var result *extensionResult
+		if byDate {
+			result, err = extendDeadlineTo(book, until)
+		} else {
+			result, err = extendDeadline(book, days)
+		}
+		switch {
+		case errors.Is(err, errExtensionLength):
+			lines = append(lines, fmt.Sprintf("『%s』は今の期限より後の、%d日以内の日付にしか延ばせません。", book.Title, maxExtensionDays))
+		case errors.Is(err, errExtensionLimit):
+			lines = append(lines, fmt.Sprintf("『%s』はもう延長できません。観念して読みましょう。", book.Title))
+		case errors.Is(err, errNotExtendable):
+			continue
+		case err != nil:
+			log.Printf("[ERROR] extension of book %s failed: %v", book.BookID, err)
+			lines = append(lines, fmt.Sprintf("『%s』の延長に失敗しました。時間をおいてもう一度送ってください。", book.Title))
+		default:
+			line := fmt.Sprintf("『%s』の期限を%sまで延ばしました。", result.Title, result.Deadline.In(jst).Format("1月2日"))
+			if result.Penalty == extensionPenaltyInsultUp {
+				line += fmt.Sprintf("ペナルティとして督促レベルが%dになります。", result.InsultLevel)
+			}
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return "延長できる本がありませんでした。", true
+	}
+	return strings.Join(lines, "\n"), true
+}
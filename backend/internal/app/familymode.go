@@ -0,0 +1,180 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// 家族向けモード (子どもが使う場合など)。ユーザー自身 (users.family_safe) か、所属するワークスペース
+// (workspaces.family_safe) のどちらかで有効になり、督促をやさしい声かけだけにする。
+// 個々の機能に任せず、描画 (renderMessage) と送信ジョブの登録 (enqueueJob) の2か所で強制する:
+//   - renderMessage は FamilySafe の MessageData には許可した雛形だけを使い、それ以外はやさしい言い換えにする
+//   - enqueueJob は晒し・賭け・エスカレーションを積まず、やさしい雛形でない督促は定型の声かけに差し替え、督促レベルを抑える
+const (
+	// familySafeTemplatePrefix はやさしい雛形のキー。enqueueJob はこれ以外の督促を差し替える。
+	familySafeTemplatePrefix = insultToneGentle + "_"
+	familySafeFallbackKey    = familySafeTemplatePrefix + "fallback"
+	familySafeSeriesKey      = familySafeTemplatePrefix + "series"
+	familySafeMaxLevel       = 2 // insultLevelTone の 1・2 はやさしい言い回し
+
+	gentleFallbackText   = "「{{.Title}}」の期限が過ぎています。今日は1ページだけでも読んでみませんか？"
+	gentleSeriesText     = "「{{.Series}}」シリーズの{{.Count}}冊が期限を過ぎています。続きを少しずつ楽しみましょう。"
+	gentleMilestoneText  = "「{{.Title}}」の中間目標『{{.MilestoneTitle}}』の日になりました。少しずつ進めていきましょう。"
+	gentleFallbackPlain  = "期限を過ぎた本があります。今日は1ページだけでも読んでみませんか？"
+	familySafeCacheValue = "1"
+)
+
+// familySafeBlockedKinds は家族向けモードでは積まない通知 (他人に知らせる・罰を与えるもの)
+var familySafeBlockedKinds = map[string]bool{jobKindGroupShame: true, jobKindEscalation: true, jobKindStake: true}
+
+// familySafeTemplates は家族向けモードで描画してよい雛形と、置き換える雛形。値が空ならそのまま使う。
+// ここにない雛形 (ユーザーやワークスペースが登録した督促文を含む) は gentleFallbackText になる。
+var familySafeTemplates = map[string]string{
+	digestText:            "",
+	reviewNudgeText:       "",
+	gentleFallbackText:    "",
+	gentleSeriesText:      "",
+	gentleMilestoneText:   "",
+	milestoneReminderText: gentleMilestoneText,
+	seriesInsultText:      gentleSeriesText,
+}
+
+func familySafeCacheKey(userID string) string { return "family_safe:" + userID }
+
+// familySafe はユーザーに家族向けモードが効いているか。確認できなければ安全側 (true) に倒す。
+func familySafe(userID string) bool {
+	if userID == "" {
+		return false
+	}
+	if v, ok := appCache.Get(familySafeCacheKey(userID)); ok {
+		return string(v) == familySafeCacheValue
+	}
+	safe, err := lookupFamilySafe(userID)
+	if err != nil {
+		log.Printf("[ERROR] family-safe lookup failed for user %s, treating as family-safe: %v", userID, err)
+		return true
+	}
+	v := "0"
+	if safe {
+		v = familySafeCacheValue
+	}
+	appCache.Set(familySafeCacheKey(userID), []byte(v), userCacheTTL)
+	return safe
+}
+
+func lookupFamilySafe(userID string) (bool, error) {
+	resp, _, err := execute(supabaseClient.From("users").Select("family_safe", "", false).Eq("id", userID))
+	if err != nil {
+		return false, err
+	}
+	var users []struct {
+		FamilySafe bool `json:"family_safe"`
+	}
+	if json.Unmarshal(resp, &users); len(users) > 0 && users[0].FamilySafe {
+		return true, nil
+	}
+	m, err := workspaceMembership(userID)
+	if err != nil || m == nil {
+		return false, err
+	}
+	ws, err := fetchWorkspace(m.WorkspaceID)
+	if err != nil {
+		return false, err
+	}
+	return ws.FamilySafe, nil
+}
+
+// familySafeTemplate は家族向けモードで text の代わりに描画する雛形。gentle トーンの雛形はそのまま使う。
+func familySafeTemplate(text string) string {
+	for _, t := range insultTonePools[insultToneGentle] {
+		if t.Text == text {
+			return text
+		}
+	}
+	replacement, ok := familySafeTemplates[text]
+	switch {
+	case !ok:
+		return gentleFallbackText
+	case replacement != "":
+		return replacement
+	}
+	return text
+}
+
+// applyFamilySafe は家族向けモードのユーザーの送信ジョブを整える。積まないなら false。
+func applyFamilySafe(job *NotificationJob) bool {
+	if !familySafe(job.UserID) {
+		return true
+	}
+	if familySafeBlockedKinds[job.Kind] {
+		log.Printf("[INFO] not sending %s notification for family-safe user %s", job.Kind, job.UserID)
+		return false
+	}
+	if job.Kind != jobKindInsult {
+		return true
+	}
+	if !strings.HasPrefix(job.Template, familySafeTemplatePrefix) && job.Template != overdueBatchTemplate {
+		log.Printf("[WARNING] replacing %q insult for family-safe user %s", job.Template, job.UserID)
+		job.Template, job.Message, job.Payload = familySafeFallbackKey, gentleFallbackPlain, nil
+	}
+	level := clampInsultLevel(0)
+	if job.InsultLevel != nil {
+		level = clampInsultLevel(*job.InsultLevel)
+	}
+	level = min(level, familySafeMaxLevel)
+	job.InsultLevel, job.Sticker = &level, stickerForLevel(level)
+	return true
+}
+
+// handleWorkspaceSettings は /api/workspaces/{id}/settings。
+// GET ?userId=... はメンバーなら誰でも、PUT {user_id, family_safe} は管理者だけ。
+func handleWorkspaceSettings(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("id")
+	switch r.Method {
+	case http.MethodGet:
+		if _, ok := requireWorkspaceRole(w, workspaceID, r.URL.Query().Get("userId"), false); !ok {
+			return
+		}
+	case http.MethodPut:
+		var req struct {
+			UserID     string `json:"user_id"`
+			FamilySafe *bool  `json:"family_safe"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.FamilySafe == nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if _, ok := requireWorkspaceRole(w, workspaceID, req.UserID, true); !ok {
+			return
+		}
+		if _, _, err := execute(supabaseClient.From("workspaces").
+			Update(map[string]interface{}{"family_safe": *req.FamilySafe}, "minimal", "").
+			Eq("workspace_id", workspaceID)); err != nil {
+			log.Printf("[ERROR] handleWorkspaceSettings update error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to update workspace: %v", err), http.StatusInternalServerError)
+			return
+		}
+		members, err := workspaceMembers(workspaceID)
+		if err != nil {
+			log.Printf("[ERROR] handleWorkspaceSettings members error: %v", err)
+		}
+		for _, m := range members {
+			appCache.Delete(familySafeCacheKey(m.UserID))
+		}
+		log.Printf("[INFO] workspace %s family_safe set to %v by %s", workspaceID, *req.FamilySafe, req.UserID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ws, err := fetchWorkspace(workspaceID)
+	if err != nil {
+		log.Printf("[ERROR] handleWorkspaceSettings workspace error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch workspace: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"family_safe": ws.FamilySafe})
+}
@@ -0,0 +1,243 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+const (
+	feedMaxEntries    = 30
+	feedReviewSnippet = 200
+	feedCacheMaxAge   = 5 * time.Minute
+)
+
+// atomFeed / atomEntry は Atom 1.0 (RFC 4287) の最小構成
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Published string      `xml:"published"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// feedCompletion は読了記録と本の情報をまとめて取得した行
+type feedCompletion struct {
+	CompletionID string    `json:"completion_id"`
+	CompletedAt  time.Time `json:"completed_at"`
+	ReadCycle    int       `json:"read_cycle"`
+	Book         struct {
+		Title  string  `json:"title"`
+		Author string  `json:"author"`
+		Rating *int    `json:"rating"`
+		Review *string `json:"review"`
+	} `json:"books"`
+}
+
+// ratingStars は 1〜5 の評価を星で表す
+func ratingStars(rating *int) string {
+	if rating == nil {
+		return ""
+	}
+	return strings.Repeat("★", *rating) + strings.Repeat("☆", 5-*rating)
+}
+
+// snippet は先頭 n 文字に切り詰める
+func snippet(s string, n int) string {
+	runes := []rune(strings.TrimSpace(s))
+	if len(runes) <= n {
+		return string(runes)
+	}
+	return string(runes[:n]) + "…"
+}
+
+func newFeedToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// requestBaseURL はリバースプロキシ越しでも外から見える URL を組み立てる
+func requestBaseURL(r *http.Request) string {
+	scheme := "https"
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	} else if r.TLS == nil {
+		scheme = "http"
+	}
+	return scheme + "://" + r.Host
+}
+
+// handleFeedToken は /api/feeds/token (POST: 発行・再発行, DELETE: 無効化)。再発行すると古い URL は使えなくなる。
+func handleFeedToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	var token interface{}
+	if r.Method == http.MethodPost {
+		t, err := newFeedToken()
+		if err != nil {
+			http.Error(w, "failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		token = t
+	}
+	resp, _, err := execute(supabaseClient.From("users").
+		Update(map[string]interface{}{"feed_token": token, "updated_at": clock.Now()}, "", "").
+		Eq("id", req.UserID))
+	if err != nil {
+		log.Printf("[ERROR] handleFeedToken update error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to update feed token: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var users []map[string]interface{}
+	if json.Unmarshal(resp, &users); len(users) == 0 {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if token == nil {
+		json.NewEncoder(w).Encode(map[string]string{"message": "Feed disabled"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"token":     token.(string),
+		"url":       fmt.Sprintf("%s/api/feeds/%s/completed.xml", requestBaseURL(r), token),
+		"tasks_url": fmt.Sprintf("%s/api/feeds/%s/tasks.ics", requestBaseURL(r), token),
+	})
+}
+
+// handleCompletedFeed は GET /api/feeds/{token}/completed.xml。最近の読了を評価とレビューの抜粋つきで Atom で返す。
+func handleCompletedFeed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := r.PathValue("token")
+	if token == "" {
+		http.NotFound(w, r)
+		return
+	}
+	uResp, _, err := execute(supabaseClient.From("users").Select("id, display_name", "", false).Eq("feed_token", token))
+	if err != nil {
+		log.Printf("[ERROR] handleCompletedFeed user lookup error: %v", err)
+		http.Error(w, "failed to load feed", http.StatusInternalServerError)
+		return
+	}
+	var users []struct {
+		ID          string `json:"id"`
+		DisplayName string `json:"display_name"`
+	}
+	if json.Unmarshal(uResp, &users); len(users) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	user := users[0]
+
+	cResp, _, err := execute(supabaseClient.From("book_completions").
+		Select("completion_id, completed_at, read_cycle, books(title, author, rating, review)", "", false).
+		Eq("user_id", user.ID).
+		Order("completed_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(feedMaxEntries, ""))
+	if err != nil {
+		log.Printf("[ERROR] handleCompletedFeed query error: %v", err)
+		http.Error(w, "failed to load feed", http.StatusInternalServerError)
+		return
+	}
+	var completions []feedCompletion
+	if err := json.Unmarshal(cResp, &completions); err != nil {
+		log.Printf("[ERROR] handleCompletedFeed unmarshal error: %v", err)
+	}
+
+	self := fmt.Sprintf("%s/api/feeds/%s/completed.xml", requestBaseURL(r), token)
+	feed := atomFeed{
+		ID:      "urn:tundoku:feed:" + user.ID,
+		Title:   user.DisplayName + "の読了記録",
+		Updated: clock.Now().UTC().Format(time.RFC3339),
+		Link:    []atomLink{{Rel: "self", Href: self}},
+		Author:  atomAuthor{Name: user.DisplayName},
+	}
+	if len(completions) > 0 {
+		feed.Updated = completions[0].CompletedAt.UTC().Format(time.RFC3339)
+	}
+	for _, c := range completions {
+		title := fmt.Sprintf("『%s』%s", c.Book.Title, c.Book.Author)
+		if c.ReadCycle > 1 {
+			title += fmt.Sprintf(" (%d回目)", c.ReadCycle)
+		}
+		if stars := ratingStars(c.Book.Rating); stars != "" {
+			title += " " + stars
+		}
+		body := "読了しました。"
+		if c.Book.Review != nil {
+			review, err := openText("books", *c.Book.Review)
+			if err != nil {
+				log.Printf("[ERROR] handleCompletedFeed review error: %v", err)
+			}
+			if strings.TrimSpace(review) != "" {
+				body = snippet(review, feedReviewSnippet)
+			}
+		}
+		at := c.CompletedAt.UTC().Format(time.RFC3339)
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:        "urn:tundoku:completion:" + c.CompletionID,
+			Title:     title,
+			Updated:   at,
+			Published: at,
+			Content:   atomContent{Type: "text", Body: body},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(feedCacheMaxAge.Seconds())))
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		log.Printf("[ERROR] handleCompletedFeed encode error: %v", err)
+	}
+}
@@ -0,0 +1,252 @@
+package app
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// メモとレビューはアプリ側で AES-256-GCM で暗号化してから保存する (service role のキーで DB を読んでも本文は見えない)。
+// 鍵は FIELD_ENCRYPTION_KEYS="2:<base64 32バイト>,1:<base64>" のように ID 付きで並べ、先頭で暗号化する。
+// 残りは復号だけに使うので、新しい鍵を先頭に足して /api/admin/encryption/rotate を流し、終わったら古い鍵を外す。
+// KMS やシークレットマネージャーの鍵は SECRETS_FILE (secrets.go) で渡せば、読み直したときに新しい鍵が使われる。
+// 未設定なら従来どおり平文で保存する。
+// 鍵はユーザーではなく列に結び付ける (アカウント統合で行が別のユーザーに移っても読めるように)。
+const sealedPrefix = "enc:v1:"
+
+// sealedColumns は暗号化して保存する列。キーはテーブル、値は列と主キー。
+var sealedColumns = map[string]struct{ column, key string }{
+	"books":      {"review", "book_id"},
+	"book_notes": {"content", "note_id"},
+}
+
+var errSealedKeyMissing = errors.New("encryption key not configured")
+
+type fieldKeyring struct {
+	activeID string
+	keys     map[string]cipher.AEAD
+}
+
+// 鍵は env の値が変わったときだけ読み直す。読み直した値が壊れていたら前の鍵を使い続ける。
+var fieldKeyringCache struct {
+	sync.Mutex
+	raw  string
+	ring *fieldKeyring
+}
+
+// initFieldEncryption は起動時に鍵を読む。壊れていたら起動しない。
+func initFieldEncryption() {
+	ring, err := parseFieldKeyring(os.Getenv("FIELD_ENCRYPTION_KEYS"))
+	if err != nil {
+		log.Fatalf("invalid FIELD_ENCRYPTION_KEYS: %v", err)
+	}
+	fieldKeyringCache.raw, fieldKeyringCache.ring = os.Getenv("FIELD_ENCRYPTION_KEYS"), ring
+	if ring.activeID == "" {
+		log.Printf("[WARNING] FIELD_ENCRYPTION_KEYS is not set; notes and reviews are stored in plaintext")
+	}
+}
+
+func currentFieldKeyring() *fieldKeyring {
+	fieldKeyringCache.Lock()
+	defer fieldKeyringCache.Unlock()
+	raw := os.Getenv("FIELD_ENCRYPTION_KEYS")
+	if fieldKeyringCache.ring == nil || raw != fieldKeyringCache.raw {
+		fieldKeyringCache.raw = raw
+		ring, err := parseFieldKeyring(raw)
+		if err != nil {
+			log.Printf("[ERROR] reloaded FIELD_ENCRYPTION_KEYS is invalid, keeping the previous keys: %v", err)
+		} else {
+			fieldKeyringCache.ring = ring
+		}
+		if fieldKeyringCache.ring == nil {
+			fieldKeyringCache.ring = &fieldKeyring{keys: map[string]cipher.AEAD{}}
+		}
+	}
+	return fieldKeyringCache.ring
+}
+
+func parseFieldKeyring(raw string) (*fieldKeyring, error) {
+	ring := &fieldKeyring{keys: make(map[string]cipher.AEAD)}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("FIELD_ENCRYPTION_KEYS: entries must be <id>:<base64 key>")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("FIELD_ENCRYPTION_KEYS: key %s must be 32 bytes of base64", id)
+		}
+		if _, dup := ring.keys[id]; dup {
+			return nil, fmt.Errorf("FIELD_ENCRYPTION_KEYS: duplicate key id %s", id)
+		}
+		block, _ := aes.NewCipher(key)
+		aead, _ := cipher.NewGCM(block)
+		ring.keys[id] = aead
+		if ring.activeID == "" {
+			ring.activeID = id
+		}
+	}
+	return ring, nil
+}
+
+// sealText は table の暗号化する列に保存する値を返す。鍵がなければ平文のまま。
+func sealText(table, plaintext string) string {
+	ring := currentFieldKeyring()
+	if ring.activeID == "" || plaintext == "" {
+		return plaintext
+	}
+	aead := ring.keys[ring.activeID]
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(table+"."+sealedColumns[table].column))
+	return sealedPrefix + ring.activeID + ":" + base64.StdEncoding.EncodeToString(sealed)
+}
+
+// sealTextPtr は NULL を許す列用の sealText
+func sealTextPtr(table string, plaintext *string) *string {
+	if plaintext == nil {
+		return nil
+	}
+	sealed := sealText(table, *plaintext)
+	return &sealed
+}
+
+// openText は sealText で保存した値を平文に戻す。暗号化されていない値はそのまま返す。
+func openText(table, value string) (string, error) {
+	rest, ok := strings.CutPrefix(value, sealedPrefix)
+	if !ok {
+		return value, nil
+	}
+	id, encoded, _ := strings.Cut(rest, ":")
+	aead, ok := currentFieldKeyring().keys[id]
+	if !ok {
+		return "", fmt.Errorf("%w (key id %s)", errSealedKeyMissing, id)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("malformed sealed value")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(table+"."+sealedColumns[table].column))
+	if err != nil {
+		return "", fmt.Errorf("decrypt %s.%s: %w", table, sealedColumns[table].column, err)
+	}
+	return string(plain), nil
+}
+
+// openRows は table の行の配列 (PostgREST の応答) の暗号化した列を平文にする。
+// 復号できない値はログに残して空にする (暗号文をそのまま画面に出さない)。
+func openRows(table string, resp []byte) []byte {
+	sealed, ok := sealedColumns[table]
+	if !ok || !bytes.Contains(resp, []byte(sealedPrefix)) {
+		return resp
+	}
+	column := sealed.column
+	var rows []map[string]json.RawMessage
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		log.Printf("[ERROR] openRows %s unmarshal error: %v", table, err)
+		return resp
+	}
+	for _, row := range rows {
+		var value *string
+		if json.Unmarshal(row[column], &value) != nil || value == nil {
+			continue
+		}
+		plain, err := openText(table, *value)
+		if err != nil {
+			log.Printf("[ERROR] failed to open %s.%s: %v", table, column, err)
+			plain = ""
+		}
+		row[column], _ = json.Marshal(plain)
+	}
+	opened, err := json.Marshal(rows)
+	if err != nil {
+		return resp
+	}
+	return opened
+}
+
+// handleRotateEncryption は POST /api/admin/encryption/rotate。
+// 先頭の鍵で暗号化されていない値 (古い鍵や平文) を先頭の鍵で暗号化し直す。1回で最大 rotateBatchSize 件ずつ、
+// 残りがあれば remaining が true になるので繰り返し呼ぶ。
+func handleRotateEncryption(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ring := currentFieldKeyring()
+	if ring.activeID == "" {
+		http.Error(w, "FIELD_ENCRYPTION_KEYS is not set", http.StatusConflict)
+		return
+	}
+	resealed := make(map[string]int, len(sealedColumns))
+	remaining := false
+	for table, c := range sealedColumns {
+		n, more, err := resealTable(table, ring.activeID)
+		resealed[table] = n
+		if err != nil {
+			log.Printf("[ERROR] handleRotateEncryption %s error: %v", table, err)
+			http.Error(w, fmt.Sprintf("failed to re-encrypt %s.%s: %v", table, c.column, err), http.StatusInternalServerError)
+			return
+		}
+		remaining = remaining || more
+	}
+	log.Printf("[INFO] re-encrypted %v with key %s", resealed, ring.activeID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"key_id": ring.activeID, "resealed": resealed, "remaining": remaining})
+}
+
+const rotateBatchSize = 200
+
+// resealTable は table の列のうち activeID で暗号化されていない行を暗号化し直す。件数と、まだ残りがあるかを返す。
+func resealTable(table, activeID string) (int, bool, error) {
+	c := sealedColumns[table]
+	resp, _, err := execute(supabaseClient.From(table).Select(c.key+", updated_at, "+c.column, "", false).
+		Not(c.column, "is", "null").
+		Neq(c.column, "").
+		Not(c.column, "like", sealedPrefix+activeID+":*").
+		Limit(rotateBatchSize, ""))
+	if err != nil {
+		return 0, false, err
+	}
+	var rows []map[string]*string
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		return 0, false, err
+	}
+	n := 0
+	for _, row := range rows {
+		if row[c.key] == nil || row[c.column] == nil {
+			continue
+		}
+		plain, err := openText(table, *row[c.column])
+		if err != nil {
+			return n, true, err
+		}
+		sealed := sealText(table, plain)
+		// 読んでから書くまでに編集された行は上書きしない (次の回で拾う)
+		q := supabaseClient.From(table).Update(map[string]interface{}{c.column: sealed}, "minimal", "").Eq(c.key, *row[c.key])
+		if row["updated_at"] != nil {
+			q = q.Eq("updated_at", *row["updated_at"])
+		} else {
+			q = q.Is("updated_at", "null")
+		}
+		if _, _, err := execute(q); err != nil {
+			return n, true, err
+		}
+		n++
+	}
+	return n, len(rows) == rotateBatchSize, nil
+}
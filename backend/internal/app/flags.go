@@ -0,0 +1,192 @@
+package app
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const flagsRefreshInterval = 30 * time.Second
+
+// 機能フラグのキー。feature_flags に行がなければ flagDefaults の値を使う。
+const (
+	flagGroupShame    = "group_shame"
+	flagCustomInsults = "custom_insults"
+)
+
+// flagDefaults は行がないときの値 (既存の機能は有効のまま)
+var flagDefaults = map[string]bool{
+	flagGroupShame:    true,
+	flagCustomInsults: true,
+}
+
+// FeatureFlag は feature_flags の行。enabled=false なら誰にも有効にならない (キルスイッチ)。
+// enabled=true なら user_ids に含まれるユーザーと、rollout_percent の割合のユーザーに有効になる。
+type FeatureFlag struct {
+	Key            string    `json:"key"`
+	Enabled        bool      `json:"enabled"`
+	RolloutPercent int       `json:"rollout_percent"` // 0-100
+	UserIDs        []string  `json:"user_ids"`
+	Description    string    `json:"description"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+var featureFlags struct {
+	sync.RWMutex
+	flags    map[string]FeatureFlag
+	loadedAt time.Time
+}
+
+// refreshFlags は flagsRefreshInterval ごとに feature_flags を読み直す。失敗したら前回の値を使い続ける。
+func refreshFlags(force bool) map[string]FeatureFlag {
+	featureFlags.RLock()
+	flags, fresh := featureFlags.flags, time.Since(featureFlags.loadedAt) < flagsRefreshInterval
+	featureFlags.RUnlock()
+	if fresh && !force {
+		return flags
+	}
+
+	featureFlags.Lock()
+	defer featureFlags.Unlock()
+	featureFlags.loadedAt = time.Now()
+	resp, _, err := execute(supabaseClient.From("feature_flags").Select("*", "", false))
+	if err != nil {
+		log.Printf("[ERROR] failed to load feature flags: %v", err)
+		return featureFlags.flags
+	}
+	var rows []FeatureFlag
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		log.Printf("[ERROR] failed to parse feature flags: %v", err)
+		return featureFlags.flags
+	}
+	loaded := make(map[string]FeatureFlag, len(rows))
+	for _, f := range rows {
+		loaded[f.Key] = f
+	}
+	featureFlags.flags = loaded
+	return loaded
+}
+
+// rolloutBucket はキーとユーザーから 0-99 を決める。同じユーザーは割合を上げても外れない。
+func rolloutBucket(key, userID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key + ":" + userID))
+	return int(h.Sum32() % 100)
+}
+
+// flagEnabled は userID に対して機能が有効かを返す
+func flagEnabled(key, userID string) bool {
+	f, ok := refreshFlags(false)[key]
+	if !ok {
+		return flagDefaults[key]
+	}
+	return f.evaluate(userID)
+}
+
+func (f FeatureFlag) evaluate(userID string) bool {
+	if !f.Enabled {
+		return false
+	}
+	if slices.Contains(f.UserIDs, userID) {
+		return true
+	}
+	return rolloutBucket(f.Key, userID) < f.RolloutPercent
+}
+
+// handleFlags は GET /api/admin/flags。?userId を付けるとそのユーザーでの評価結果も返す。
+func handleFlags(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flags := refreshFlags(true)
+	result := make([]map[string]interface{}, 0, len(flags)+len(flagDefaults))
+	userID := r.URL.Query().Get("userId")
+	seen := make(map[string]bool)
+	add := func(key string, f *FeatureFlag) {
+		seen[key] = true
+		item := map[string]interface{}{"key": key, "flag": f, "default": flagDefaults[key]}
+		if userID != "" {
+			item["enabled_for_user"] = flagEnabled(key, userID)
+		}
+		result = append(result, item)
+	}
+	for key, f := range flags {
+		add(key, &f)
+	}
+	for key := range flagDefaults {
+		if !seen[key] {
+			add(key, nil)
+		}
+	}
+	slices.SortFunc(result, func(a, b map[string]interface{}) int {
+		return cmp.Compare(a["key"].(string), b["key"].(string))
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"flags": result})
+}
+
+// handleFlag は PUT /api/admin/flags/{key} (作成・更新) と DELETE (行を消して既定値に戻す)。
+// 変更はこのインスタンスでは即座に、他のインスタンスでも flagsRefreshInterval 以内に反映される。
+func handleFlag(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	key := r.PathValue("key")
+	switch r.Method {
+	case http.MethodPut:
+		var f FeatureFlag
+		if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if f.RolloutPercent < 0 || f.RolloutPercent > 100 {
+			http.Error(w, "rollout_percent must be 0-100", http.StatusBadRequest)
+			return
+		}
+		if f.UserIDs == nil {
+			f.UserIDs = []string{}
+		}
+		rawResp, _, err := execute(supabaseClient.From("feature_flags").Insert(map[string]interface{}{
+			"key":             key,
+			"enabled":         f.Enabled,
+			"rollout_percent": f.RolloutPercent,
+			"user_ids":        f.UserIDs,
+			"description":     f.Description,
+			"updated_at":      time.Now(),
+		}, true, "key", "", ""))
+		if err != nil {
+			log.Printf("[ERROR] handleFlag upsert error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to save flag: %v", err), http.StatusInternalServerError)
+			return
+		}
+		refreshFlags(true)
+		log.Printf("[INFO] feature flag %s updated: enabled=%t rollout=%d%% users=%d", key, f.Enabled, f.RolloutPercent, len(f.UserIDs))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(rawResp)
+
+	case http.MethodDelete:
+		if _, _, err := execute(supabaseClient.From("feature_flags").Delete("minimal", "").Eq("key", key)); err != nil {
+			log.Printf("[ERROR] handleFlag delete error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to delete flag: %v", err), http.StatusInternalServerError)
+			return
+		}
+		refreshFlags(true)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": "Flag reset to default", "default": flagDefaults[key]})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
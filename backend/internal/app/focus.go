@@ -0,0 +1,329 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// 集中読書モード。「読書開始」で読書タイマーを始め、設定した分数の後に何ページ読んだかを尋ねる。
+// 数字で返信すると、その回の記録としてタイマーを止める。
+const (
+	focusStartCommand = "読書開始"
+	minFocusMinutes   = 5
+	maxFocusMinutes   = 180
+)
+
+var (
+	errFocusNoBook  = errors.New("no book to read")
+	errFocusRunning = errors.New("session already running")
+
+	// 「30」「30ページ」「30p」などの返信
+	focusPagesPattern = regexp.MustCompile(`^(\d{1,4})\s*(ページ|頁|p|P)?$`)
+)
+
+// userFocusMinutes は集中読書モードの間隔 (分) を返す。オフなら 0。
+func userFocusMinutes(userID string) (int, error) {
+	resp, _, err := execute(supabaseClient.From("users").Select("focus_minutes", "", false).Eq("id", userID))
+	if err != nil {
+		return 0, err
+	}
+	var users []struct {
+		FocusMinutes *int `json:"focus_minutes"`
+	}
+	if json.Unmarshal(resp, &users); len(users) == 0 || users[0].FocusMinutes == nil {
+		return 0, nil
+	}
+	return *users[0].FocusMinutes, nil
+}
+
+// pickFocusBook は query に当てはまる本、なければ読書中の本のうち最近触った本を選ぶ
+func pickFocusBook(userID, query string) (Book, error) {
+	books, _, err := loadUserBooks(userID)
+	if err != nil {
+		return Book{}, err
+	}
+	books = excludeArchived(books)
+	if query != "" {
+		for _, res := range searchBooks(books, query) {
+			if slices.Contains(activeStatuses, res.Book.Status) {
+				return res.Book, nil
+			}
+		}
+		return Book{}, errFocusNoBook
+	}
+	var picked *Book
+	for i, b := range books {
+		if b.Status == "reading" && (picked == nil || b.UpdatedAt.After(picked.UpdatedAt)) {
+			picked = &books[i]
+		}
+	}
+	if picked == nil {
+		return Book{}, errFocusNoBook
+	}
+	return *picked, nil
+}
+
+// startFocusSession は読書タイマーを始め、確認のメッセージを minutes 分後に積む
+func startFocusSession(userID, lineUserID string, book Book, minutes int) (ReadingSession, error) {
+	open, err := openSession(book.BookID)
+	if err != nil {
+		return ReadingSession{}, err
+	}
+	if open != nil {
+		return *open, errFocusRunning
+	}
+	now := clock.Now()
+	rawResp, _, err := executeOnce(supabaseClient.From("reading_sessions").Insert(map[string]interface{}{
+		"book_id":    book.BookID,
+		"user_id":    userID,
+		"started_at": now,
+		"read_cycle": book.ReadCycle,
+		"focus":      true,
+	}, false, "", "", ""))
+	if err != nil {
+		return ReadingSession{}, err
+	}
+	var sessions []ReadingSession
+	if json.Unmarshal(rawResp, &sessions); len(sessions) == 0 {
+		return ReadingSession{}, fmt.Errorf("session insert returned no rows")
+	}
+	if lineUserID != "" {
+		msg := fmt.Sprintf("⏰ %d分経ちました。『%s』は何ページ読みましたか？数字だけで返信してください。", minutes, book.Title)
+		if err := enqueueJob(NotificationJob{Kind: jobKindFocusCheckin, BookID: book.BookID, UserID: userID, LineUserID: lineUserID, Message: msg, RunAt: now.Add(time.Duration(minutes) * time.Minute)}); err != nil {
+			log.Printf("[ERROR] failed to enqueue focus check-in for book %s: %v", book.BookID, err)
+		}
+	}
+	return sessions[0], nil
+}
+
+// openFocusSession はユーザーの集中読書中のタイマーのうち一番新しいものを返す
+func openFocusSession(userID string) (*ReadingSession, error) {
+	resp, _, err := execute(supabaseClient.From("reading_sessions").
+		Select("*", "", false).
+		Eq("user_id", userID).
+		Eq("focus", "true").
+		Is("ended_at", "null").
+		Order("started_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(1, ""))
+	if err != nil {
+		return nil, err
+	}
+	var sessions []ReadingSession
+	if err := json.Unmarshal(resp, &sessions); err != nil || len(sessions) == 0 {
+		return nil, err
+	}
+	return &sessions[0], nil
+}
+
+// focusSessionOpen は確認を送る時点でまだタイマーが動いているか (止めていれば確認は送らない)
+func focusSessionOpen(bookID string) bool {
+	open, err := openSession(bookID)
+	return err != nil || open != nil
+}
+
+// handleFocusSettings は /api/users/focus。GET ?userId=... で確認、PUT {user_id, minutes} で設定 (0 でオフ)。
+func handleFocusSettings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		userId := r.URL.Query().Get("userId")
+		if userId == "" {
+			http.Error(w, "userId required", http.StatusBadRequest)
+			return
+		}
+		minutes, err := userFocusMinutes(userId)
+		if err != nil {
+			log.Printf("[ERROR] handleFocusSettings query error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch settings: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"enabled": minutes > 0, "minutes": minutes})
+
+	case http.MethodPut:
+		var req struct {
+			UserID  string `json:"user_id"`
+			Minutes int    `json:"minutes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if req.Minutes != 0 && (req.Minutes < minFocusMinutes || req.Minutes > maxFocusMinutes) {
+			http.Error(w, fmt.Sprintf("minutes must be 0 or between %d and %d", minFocusMinutes, maxFocusMinutes), http.StatusBadRequest)
+			return
+		}
+		var focusMinutes interface{} // 0 は NULL (オフ) として保存する
+		if req.Minutes > 0 {
+			focusMinutes = req.Minutes
+		}
+		resp, _, err := execute(supabaseClient.From("users").
+			Update(map[string]interface{}{"focus_minutes": focusMinutes, "updated_at": clock.Now()}, "", "").
+			Eq("id", req.UserID))
+		if err != nil {
+			log.Printf("[ERROR] handleFocusSettings update error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to update settings: %v", err), http.StatusInternalServerError)
+			return
+		}
+		var users []map[string]interface{}
+		if json.Unmarshal(resp, &users); len(users) == 0 {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": "Focus settings updated", "enabled": req.Minutes > 0, "minutes": req.Minutes})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleFocusStart は POST /api/focus/start {user_id, book_id}。book_id を省くと読書中の本を選ぶ。
+func handleFocusStart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID string `json:"user_id"`
+		BookID string `json:"book_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	minutes, err := userFocusMinutes(req.UserID)
+	if err != nil {
+		log.Printf("[ERROR] handleFocusStart settings error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch settings: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if minutes == 0 {
+		http.Error(w, "Focus mode is disabled", http.StatusConflict)
+		return
+	}
+	var book Book
+	if req.BookID != "" {
+		book, err = fetchOwnedBook(req.BookID, req.UserID)
+		if err != nil {
+			writeBookLookupError(w, err)
+			return
+		}
+	} else if book, err = pickFocusBook(req.UserID, ""); errors.Is(err, errFocusNoBook) {
+		http.Error(w, "No book is being read; specify book_id", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Printf("[ERROR] handleFocusStart books error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	lineUserID, _ := lineUserIDFor(req.UserID)
+	session, err := startFocusSession(req.UserID, lineUserID, book, minutes)
+	if errors.Is(err, errFocusRunning) {
+		http.Error(w, "Session already running", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] handleFocusStart error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to start session: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"session": session, "check_in_at": session.StartedAt.Add(time.Duration(minutes) * time.Minute)})
+}
+
+// parseFocusCommand は「読書開始 [書名]」(isStart) か読んだページ数の返信かを判定する
+func parseFocusCommand(text string) (query string, isStart bool, pages int, ok bool) {
+	text = strings.TrimSpace(strings.ReplaceAll(text, "　", " "))
+	query, isStart = strings.CutPrefix(text, focusStartCommand)
+	if isStart {
+		return strings.TrimSpace(query), true, 0, true
+	}
+	m := focusPagesPattern.FindStringSubmatch(text)
+	if m == nil {
+		return "", false, 0, false
+	}
+	pages, _ = strconv.Atoi(m[1])
+	return "", false, pages, true
+}
+
+// focusCommandReply は LINE の「読書開始 [書名]」と、確認への数字の返信を処理する。
+// 該当しないメッセージなら ok = false。
+func focusCommandReply(lineUserID, text string) (reply string, ok bool) {
+	query, isStart, pages, ok := parseFocusCommand(text)
+	if !ok {
+		return "", false
+	}
+	resp, _, err := execute(supabaseClient.From("users").Select("id", "", false).Eq("line_user_id", lineUserID))
+	if err != nil {
+		log.Printf("[ERROR] focus user lookup error: %v", err)
+		return "エラーが発生しました。時間をおいてもう一度送ってください。", true
+	}
+	var users []struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(resp, &users); len(users) == 0 {
+		if !isStart {
+			return "", false
+		}
+		return "まだ積読キラーに登録されていないようです。先にメニューからアカウントを連携してください。", true
+	}
+	userID := users[0].ID
+
+	if !isStart {
+		// 集中読書中でなければただの数字として無視する
+		open, err := openFocusSession(userID)
+		if err != nil || open == nil {
+			return "", false
+		}
+		now := clock.Now()
+		if _, _, err := execute(supabaseClient.From("reading_sessions").Update(map[string]interface{}{
+			"ended_at":         now,
+			"duration_seconds": int(now.Sub(open.StartedAt).Seconds()),
+			"pages":            pages,
+		}, "minimal", "").Eq("session_id", open.SessionID)); err != nil {
+			log.Printf("[ERROR] focus session stop error: %v", err)
+			return "エラーが発生しました。時間をおいてもう一度送ってください。", true
+		}
+		log.Printf("[INFO] focus session %s logged: %d pages", open.SessionID, pages)
+		return fmt.Sprintf("記録しました。%d分で%dページ。続けるならまた「%s」と送ってください。", int(now.Sub(open.StartedAt).Minutes()), pages, focusStartCommand), true
+	}
+
+	minutes, err := userFocusMinutes(userID)
+	if err != nil {
+		log.Printf("[ERROR] focus settings lookup error: %v", err)
+		return "エラーが発生しました。時間をおいてもう一度送ってください。", true
+	}
+	if minutes == 0 {
+		return "集中読書モードがオフになっています。アプリの設定で有効にしてください。", true
+	}
+	book, err := pickFocusBook(userID, query)
+	if errors.Is(err, errFocusNoBook) {
+		if query != "" {
+			return fmt.Sprintf("「%s」に当てはまる未読の本は見つかりませんでした。", query), true
+		}
+		return fmt.Sprintf("読書中の本がありません。「%s 書名」で読む本を指定してください。", focusStartCommand), true
+	}
+	if err != nil {
+		log.Printf("[ERROR] focus book lookup error: %v", err)
+		return "エラーが発生しました。時間をおいてもう一度送ってください。", true
+	}
+	if _, err := startFocusSession(userID, lineUserID, book, minutes); errors.Is(err, errFocusRunning) {
+		return fmt.Sprintf("『%s』のタイマーはもう動いています。読み終えたらページ数を数字で送ってください。", book.Title), true
+	} else if err != nil {
+		log.Printf("[ERROR] focus session start error: %v", err)
+		return "エラーが発生しました。時間をおいてもう一度送ってください。", true
+	}
+	return fmt.Sprintf("📖『%s』の読書を始めました。%d分後に様子を聞きます。", book.Title, minutes), true
+}
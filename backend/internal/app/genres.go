@@ -0,0 +1,150 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	maxTagsPerBook = 10
+	maxTagLength   = 30
+	// genreRemark で比べるのに必要な1タグあたりの冊数
+	genreRemarkMinBooks = 3
+)
+
+// GenreStat はタグごとの積読・読了の内訳
+type GenreStat struct {
+	Tag            string  `json:"tag"`
+	TotalBooks     int     `json:"total_books"` // 欲しい本は含まない
+	UnreadBooks    int     `json:"unread_books"`
+	CompletedBooks int     `json:"completed_books"`
+	AbandonedBooks int     `json:"abandoned_books"`
+	CompletionRate float64 `json:"completion_rate"` // completed / total (0〜1)
+}
+
+// normalizeTags は前後の空白を除き、大文字小文字と全角半角の違いだけのタグを1つにまとめる。表記は最初に出てきたものを残す。
+func normalizeTags(tags []string) []string {
+	result := []string{}
+	seen := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if runes := []rune(t); len(runes) > maxTagLength {
+			t = string(runes[:maxTagLength])
+		}
+		key := tagKey(t)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, t)
+		if len(result) == maxTagsPerBook {
+			break
+		}
+	}
+	return result
+}
+
+func tagKey(tag string) string {
+	return strings.ToLower(normalizeSearchText(tag))
+}
+
+// genreStats はタグごとに集計し、冊数の多い順に並べる。タグのない本と欲しい本は数えない。
+func genreStats(books []Book) []GenreStat {
+	byKey := map[string]*GenreStat{}
+	var order []string
+	for _, b := range books {
+		if b.Status == statusWishlist {
+			continue
+		}
+		for _, tag := range b.Tags {
+			key := tagKey(tag)
+			gs, ok := byKey[key]
+			if !ok {
+				gs = &GenreStat{Tag: tag}
+				byKey[key] = gs
+				order = append(order, key)
+			}
+			gs.TotalBooks++
+			switch b.Status {
+			case "completed":
+				gs.CompletedBooks++
+			case statusAbandoned:
+				gs.AbandonedBooks++
+			default:
+				gs.UnreadBooks++
+			}
+		}
+	}
+
+	stats := make([]GenreStat, 0, len(order))
+	for _, key := range order {
+		gs := byKey[key]
+		gs.CompletionRate = float64(gs.CompletedBooks) / float64(gs.TotalBooks)
+		stats = append(stats, *gs)
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].TotalBooks > stats[j].TotalBooks })
+	return stats
+}
+
+// genreRemark は督促に添える一言。この本のタグが、よく読み切れているタグと比べて明らかに読了率が低い場合だけ返す。
+func genreRemark(book Book) string {
+	if len(book.Tags) == 0 {
+		return ""
+	}
+	books, _, err := loadUserBooks(book.UserID)
+	if err != nil {
+		log.Printf("[ERROR] genreRemark failed to load books for user %s: %v", book.UserID, err)
+		return ""
+	}
+	var best, worst *GenreStat
+	stats := genreStats(books)
+	for i := range stats {
+		gs := &stats[i]
+		if gs.TotalBooks < genreRemarkMinBooks {
+			continue
+		}
+		if best == nil || gs.CompletionRate > best.CompletionRate {
+			best = gs
+		}
+		for _, tag := range book.Tags {
+			if tagKey(tag) == tagKey(gs.Tag) && (worst == nil || gs.CompletionRate < worst.CompletionRate) {
+				worst = gs
+			}
+		}
+	}
+	if best == nil || worst == nil || best.CompletionRate-worst.CompletionRate < 0.3 {
+		return ""
+	}
+	return fmt.Sprintf("%sは%d%%読み切るのに、%sは%d%%しか読み切れていませんね。",
+		best.Tag, int(best.CompletionRate*100), worst.Tag, int(worst.CompletionRate*100))
+}
+
+// handleGenreStats は GET /api/stats/genres?userId=...。タグごとの積読数・読了数・読了率を返す。
+func handleGenreStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+
+	books, _, err := loadUserBooks(userId)
+	if err != nil {
+		log.Printf("[ERROR] handleGenreStats query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to compute stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"genres": genreStats(books)})
+}
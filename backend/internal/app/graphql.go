@@ -0,0 +1,733 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// /api/graphql はダッシュボードを1往復で取るための読み取り専用の GraphQL。
+// query 操作のフィールド・引数・エイリアス・変数と、名前付きフラグメント・インラインフラグメントを解釈する
+// (ディレクティブと mutation は未対応)。フィールドごとに返す型は1つなので、フラグメントの型条件は照合しない。
+// フィールド名は JSON と同じ snake_case でも camelCase でもよい。
+//
+//	query($limit: Int) {
+//	  books(status: "unread") { book_id title deadline }
+//	  stats { totalBooks currentStreak }
+//	  insultHistory(limit: $limit) { message sentAt }
+//	  settings { insultTone }
+//	  ...overdueBooks
+//	}
+//
+//	fragment overdueBooks on Query { overdue: books(status: "insulted") { title } }
+
+const maxGraphQLDepth = 8
+
+// gqlField は選択された1つのフィールド。Spread はパース中だけ使う名前付きフラグメントの参照で、
+// parseGraphQL が返す前に展開する。
+type gqlField struct {
+	Alias     string
+	Name      string
+	Args      map[string]gqlValue
+	Selection []gqlField
+	Spread    string
+}
+
+// key は応答の JSON でのキー (エイリアスがあればエイリアス)
+func (f gqlField) key() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// gqlValue はリテラルか変数参照
+type gqlValue struct {
+	Variable string
+	Literal  interface{}
+}
+
+func (v gqlValue) resolve(vars map[string]interface{}) interface{} {
+	if v.Variable != "" {
+		return vars[v.Variable]
+	}
+	return v.Literal
+}
+
+type gqlResolver func(r *http.Request, userID string, args map[string]interface{}) (interface{}, error)
+
+// graphqlQueryFields はルートのフィールド
+var graphqlQueryFields = map[string]gqlResolver{
+	"books": gqlBooks,
+	"stats": func(_ *http.Request, userID string, _ map[string]interface{}) (interface{}, error) {
+		return loadUserStats(userID)
+	},
+	"genres":         gqlGenres,
+	"insult_history": gqlInsultHistory,
+	"settings":       gqlSettings,
+}
+
+func gqlBooks(_ *http.Request, userID string, args map[string]interface{}) (interface{}, error) {
+	books, _, err := loadUserBooks(userID)
+	if err != nil {
+		return nil, err
+	}
+	if archived, _ := args["archived"].(bool); !archived {
+		books = excludeArchived(books)
+	}
+	if tier, _ := args["tier"].(string); tier != "" {
+		books = filterByTier(books, tier)
+	}
+	status, _ := args["status"].(string)
+	tag, _ := args["tag"].(string)
+	filtered := make([]Book, 0, len(books))
+	for _, b := range books {
+		if status != "" && b.Status != status {
+			continue
+		}
+		if tag != "" && !containsTag(b.Tags, tag) {
+			continue
+		}
+		filtered = append(filtered, b)
+	}
+	return filtered, nil
+}
+
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if tagKey(t) == tagKey(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func gqlGenres(_ *http.Request, userID string, _ map[string]interface{}) (interface{}, error) {
+	books, _, err := loadUserBooks(userID)
+	if err != nil {
+		return nil, err
+	}
+	return genreStats(books), nil
+}
+
+func gqlInsultHistory(_ *http.Request, userID string, args map[string]interface{}) (interface{}, error) {
+	limit := 20
+	if n, ok := args["limit"].(float64); ok && n > 0 {
+		limit = min(int(n), 100)
+	}
+	resp, _, err := execute(supabaseClient.From("notification_jobs").
+		Select("job_id, book_id, book_ids, template, insult_level, message, status, sent_at, created_at", "", false).
+		Eq("user_id", userID).
+		Eq("kind", jobKindInsult).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(limit, ""))
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(resp), nil
+}
+
+func gqlSettings(_ *http.Request, userID string, _ map[string]interface{}) (interface{}, error) {
+	resp, _, err := execute(supabaseClient.From("users").
+		Select("id, display_name, insult_tone, announcements_opt_in, line_channel, created_at", "", false).
+		Eq("id", userID))
+	if err != nil {
+		return nil, err
+	}
+	var users []map[string]interface{}
+	if json.Unmarshal(resp, &users); len(users) == 0 {
+		return nil, errors.New("user not found")
+	}
+	return users[0], nil
+}
+
+// handleGraphQL は POST /api/graphql ({"query", "variables"})。GET ?query= も受け付ける。
+// 認証は他の API と同じく、セッションがあればそのユーザー、なければ ?userId。
+func handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables"`
+	}
+	switch r.Method {
+	case http.MethodGet:
+		req.Query = r.URL.Query().Get("query")
+		if v := r.URL.Query().Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				http.Error(w, "invalid variables", http.StatusBadRequest)
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("userId")
+	if session, err := authenticateSession(r); err == nil {
+		userID = session.UserID
+	} else if !errors.Is(err, errNoSession) {
+		writeSessionError(w, err)
+		return
+	}
+	if userID == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fields, defaults, err := parseGraphQL(req.Query)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": []map[string]string{{"message": err.Error()}}})
+		return
+	}
+	vars := defaults
+	for k, v := range req.Variables {
+		vars[k] = v
+	}
+
+	data := make(map[string]interface{}, len(fields))
+	var gqlErrors []map[string]interface{}
+	for _, f := range fields {
+		key := f.key()
+		resolver, ok := graphqlQueryFields[snakeCase(f.Name)]
+		if !ok {
+			gqlErrors = append(gqlErrors, map[string]interface{}{"message": fmt.Sprintf("Cannot query field %q on type \"Query\"", f.Name), "path": []string{key}})
+			data[key] = nil
+			continue
+		}
+		args := make(map[string]interface{}, len(f.Args))
+		for name, v := range f.Args {
+			args[snakeCase(name)] = v.resolve(vars)
+		}
+		value, err := resolver(r, userID, args)
+		if err == nil {
+			data[key], err = projectGraphQL(value, f.Selection)
+		}
+		if err != nil {
+			log.Printf("[ERROR] handleGraphQL %s error: %v", f.Name, err)
+			gqlErrors = append(gqlErrors, map[string]interface{}{"message": err.Error(), "path": []string{key}})
+			data[key] = nil
+		}
+	}
+
+	resp := map[string]interface{}{"data": data}
+	if len(gqlErrors) > 0 {
+		resp["errors"] = gqlErrors
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// projectGraphQL は resolver の戻り値を JSON の形にしてから、選択されたフィールドだけを残す
+func projectGraphQL(value interface{}, selection []gqlField) (interface{}, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(raw, &generic); err != nil {
+		return nil, err
+	}
+	return selectGraphQL(generic, selection, "")
+}
+
+func selectGraphQL(value interface{}, selection []gqlField, path string) (interface{}, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			projected, err := selectGraphQL(item, selection, path)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = projected
+		}
+		return out, nil
+	case map[string]interface{}:
+		if len(selection) == 0 {
+			return nil, fmt.Errorf("field %q of object type must have a selection of subfields", strings.TrimPrefix(path, "."))
+		}
+		out := make(map[string]interface{}, len(selection))
+		for _, f := range selection {
+			key := f.key()
+			child, ok := v[f.Name]
+			if !ok {
+				child, ok = v[snakeCase(f.Name)]
+			}
+			if !ok {
+				return nil, fmt.Errorf("cannot query field %q at %q", f.Name, strings.TrimPrefix(path, "."))
+			}
+			projected, err := selectGraphQL(child, f.Selection, path+"."+key)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = projected
+		}
+		return out, nil
+	default:
+		if len(selection) > 0 && value != nil {
+			return nil, fmt.Errorf("field %q is a scalar and cannot have subfields", strings.TrimPrefix(path, "."))
+		}
+		return value, nil
+	}
+}
+
+// snakeCase は totalBooks を total_books にする
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// gqlParser は query 文書の再帰下降パーサー
+type gqlParser struct {
+	src string
+	pos int
+}
+
+// parseGraphQL はルートの選択とデフォルト値付きの変数を返す。
+// 文書には操作1つと、その前後に任意の数の fragment 定義を書ける。フラグメントは展開して返す。
+func parseGraphQL(query string) ([]gqlField, map[string]interface{}, error) {
+	p := &gqlParser{src: query}
+	defaults := make(map[string]interface{})
+	fragments := make(map[string][]gqlField)
+	var fields []gqlField
+	operations := 0
+	for {
+		p.skipIgnored()
+		if p.pos >= len(p.src) {
+			break
+		}
+		if p.peekName() == "fragment" {
+			name, sel, err := p.parseFragmentDefinition()
+			if err != nil {
+				return nil, nil, err
+			}
+			if _, dup := fragments[name]; dup {
+				return nil, nil, fmt.Errorf("fragment %q is defined more than once", name)
+			}
+			fragments[name] = sel
+			continue
+		}
+		if operations++; operations > 1 {
+			return nil, nil, p.errorf("unexpected %q after the operation (only one operation per request)", p.src[p.pos:min(p.pos+10, len(p.src))])
+		}
+		var err error
+		if fields, err = p.parseOperation(defaults); err != nil {
+			return nil, nil, err
+		}
+	}
+	if operations == 0 {
+		return nil, nil, errors.New("no query operation in the document")
+	}
+	fields, err := expandGraphQLFragments(fields, fragments, make(map[string]bool), 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fields, defaults, nil
+}
+
+// parseOperation は query 操作 (キーワードを省いた { ... } も可) を読む
+func (p *gqlParser) parseOperation(defaults map[string]interface{}) ([]gqlField, error) {
+	if name := p.peekName(); name != "" {
+		if name != "query" {
+			return nil, fmt.Errorf("only query operations are supported, got %q", name)
+		}
+		p.readName()
+		p.skipIgnored()
+		p.readName() // 操作名 (省略可)
+		p.skipIgnored()
+		if p.peek() == '(' {
+			if err := p.parseVariableDefinitions(defaults); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return p.parseSelectionSet(0)
+}
+
+// parseFragmentDefinition は fragment Name on Type { ... } を読む
+func (p *gqlParser) parseFragmentDefinition() (string, []gqlField, error) {
+	p.readName() // fragment
+	p.skipIgnored()
+	name := p.readName()
+	if name == "" || name == "on" {
+		return "", nil, p.errorf("fragment name expected")
+	}
+	p.skipIgnored()
+	if p.readName() != "on" {
+		return "", nil, p.errorf("expected \"on\" after fragment %q", name)
+	}
+	p.skipIgnored()
+	if p.readName() == "" {
+		return "", nil, p.errorf("type condition expected")
+	}
+	sel, err := p.parseSelectionSet(0)
+	return name, sel, err
+}
+
+// parseFragmentSelection は選択の中の ...Name と ... on Type { ... } (型条件は省略可) を読む。
+// インラインフラグメントはその場で親の選択に混ぜ、名前付きは Spread として残す。
+func (p *gqlParser) parseFragmentSelection(depth int) ([]gqlField, error) {
+	if !strings.HasPrefix(p.src[p.pos:], "...") {
+		return nil, p.errorf("expected \"...\"")
+	}
+	p.pos += len("...")
+	p.skipIgnored()
+	switch name := p.peekName(); {
+	case name == "on":
+		p.readName()
+		p.skipIgnored()
+		if p.readName() == "" {
+			return nil, p.errorf("type condition expected")
+		}
+		return p.parseSelectionSet(depth + 1)
+	case name != "":
+		p.readName()
+		return []gqlField{{Spread: name}}, nil
+	case p.peek() == '{':
+		return p.parseSelectionSet(depth + 1)
+	}
+	return nil, p.errorf("fragment name or inline fragment expected after \"...\"")
+}
+
+// expandGraphQLFragments は名前付きフラグメントを展開し、同じキーのフィールドを1つにまとめる。
+// active は展開中のフラグメントで、自分自身を含むフラグメントを見つける。
+func expandGraphQLFragments(fields []gqlField, fragments map[string][]gqlField, active map[string]bool, depth int) ([]gqlField, error) {
+	if depth > maxGraphQLDepth {
+		return nil, errors.New("query is nested too deeply")
+	}
+	var out []gqlField
+	for _, f := range fields {
+		if f.Spread != "" {
+			sel, ok := fragments[f.Spread]
+			if !ok {
+				return nil, fmt.Errorf("unknown fragment %q", f.Spread)
+			}
+			if active[f.Spread] {
+				return nil, fmt.Errorf("fragment %q spreads itself", f.Spread)
+			}
+			active[f.Spread] = true
+			expanded, err := expandGraphQLFragments(sel, fragments, active, depth)
+			delete(active, f.Spread)
+			if err != nil {
+				return nil, err
+			}
+			if out, err = mergeGraphQLFields(out, expanded...); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if len(f.Selection) > 0 {
+			sel, err := expandGraphQLFragments(f.Selection, fragments, active, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			f.Selection = sel
+		}
+		var err error
+		if out, err = mergeGraphQLFields(out, f); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// mergeGraphQLFields は fields を dst に足す。同じキーのフィールドは子の選択を合わせ、
+// 別のフィールドや別の引数を同じキーで選んでいればエラーにする。
+func mergeGraphQLFields(dst []gqlField, fields ...gqlField) ([]gqlField, error) {
+	for _, f := range fields {
+		i := slices.IndexFunc(dst, func(d gqlField) bool { return d.key() == f.key() })
+		if i < 0 {
+			dst = append(dst, f)
+			continue
+		}
+		if dst[i].Name != f.Name || !sameGraphQLArgs(dst[i].Args, f.Args) {
+			return nil, fmt.Errorf("fields %q conflict: they select different fields or arguments", f.key())
+		}
+		merged, err := mergeGraphQLFields(slices.Clone(dst[i].Selection), f.Selection...)
+		if err != nil {
+			return nil, err
+		}
+		dst[i].Selection = merged
+	}
+	return dst, nil
+}
+
+func sameGraphQLArgs(a, b map[string]gqlValue) bool {
+	return (len(a) == 0 && len(b) == 0) || reflect.DeepEqual(a, b)
+}
+
+func (p *gqlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("syntax error at %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// skipIgnored は空白・カンマ・コメントを読み飛ばす
+func (p *gqlParser) skipIgnored() {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *gqlParser) peek() byte {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *gqlParser) expect(c byte) error {
+	p.skipIgnored()
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+func isNameByte(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+func (p *gqlParser) peekName() string {
+	end := p.pos
+	for end < len(p.src) && isNameByte(p.src[end], end == p.pos) {
+		end++
+	}
+	return p.src[p.pos:end]
+}
+
+func (p *gqlParser) readName() string {
+	name := p.peekName()
+	p.pos += len(name)
+	return name
+}
+
+func (p *gqlParser) parseVariableDefinitions(defaults map[string]interface{}) error {
+	p.pos++ // (
+	for {
+		p.skipIgnored()
+		if p.peek() == ')' {
+			p.pos++
+			return nil
+		}
+		if err := p.expect('$'); err != nil {
+			return err
+		}
+		name := p.readName()
+		if name == "" {
+			return p.errorf("variable name expected")
+		}
+		if err := p.expect(':'); err != nil {
+			return err
+		}
+		// 型は検証しないので読み飛ばす (Int, [String!]! など)
+		p.skipIgnored()
+		for c := p.peek(); c != 0 && (strings.IndexByte("[]!", c) >= 0 || isNameByte(c, false)); c = p.peek() {
+			p.pos++
+		}
+		p.skipIgnored()
+		if p.peek() == '=' {
+			p.pos++
+			v, err := p.parseValue()
+			if err != nil {
+				return err
+			}
+			if v.Variable != "" {
+				return p.errorf("default value cannot be a variable")
+			}
+			defaults[name] = v.Literal
+		}
+	}
+}
+
+func (p *gqlParser) parseSelectionSet(depth int) ([]gqlField, error) {
+	if depth > maxGraphQLDepth {
+		return nil, p.errorf("query is nested too deeply")
+	}
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
+	var fields []gqlField
+	for {
+		p.skipIgnored()
+		switch c := p.peek(); {
+		case c == '}':
+			p.pos++
+			if len(fields) == 0 {
+				return nil, p.errorf("empty selection set")
+			}
+			return fields, nil
+		case c == '.':
+			sel, err := p.parseFragmentSelection(depth)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, sel...)
+			continue
+		case c == '@':
+			return nil, p.errorf("directives are not supported")
+		case c == 0:
+			return nil, p.errorf("unexpected end of query")
+		}
+		f, err := p.parseField(depth)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+}
+
+func (p *gqlParser) parseField(depth int) (gqlField, error) {
+	var f gqlField
+	name := p.readName()
+	if name == "" {
+		return f, p.errorf("field name expected")
+	}
+	p.skipIgnored()
+	if p.peek() == ':' {
+		p.pos++
+		p.skipIgnored()
+		f.Alias = name
+		if name = p.readName(); name == "" {
+			return f, p.errorf("field name expected after alias")
+		}
+		p.skipIgnored()
+	}
+	f.Name = name
+	if p.peek() == '(' {
+		p.pos++
+		f.Args = make(map[string]gqlValue)
+		for {
+			p.skipIgnored()
+			if p.peek() == ')' {
+				p.pos++
+				break
+			}
+			arg := p.readName()
+			if arg == "" {
+				return f, p.errorf("argument name expected")
+			}
+			if err := p.expect(':'); err != nil {
+				return f, err
+			}
+			v, err := p.parseValue()
+			if err != nil {
+				return f, err
+			}
+			f.Args[arg] = v
+		}
+		p.skipIgnored()
+	}
+	if p.peek() == '{' {
+		sel, err := p.parseSelectionSet(depth + 1)
+		if err != nil {
+			return f, err
+		}
+		f.Selection = sel
+	}
+	return f, nil
+}
+
+func (p *gqlParser) parseValue() (gqlValue, error) {
+	p.skipIgnored()
+	switch c := p.peek(); {
+	case c == '$':
+		p.pos++
+		name := p.readName()
+		if name == "" {
+			return gqlValue{}, p.errorf("variable name expected")
+		}
+		return gqlValue{Variable: name}, nil
+	case c == '"':
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && p.src[p.pos] != '"' {
+			if p.src[p.pos] == '\\' {
+				p.pos++
+			}
+			p.pos++
+		}
+		if p.pos >= len(p.src) {
+			return gqlValue{}, p.errorf("unterminated string")
+		}
+		p.pos++
+		s, err := strconv.Unquote(p.src[start:p.pos])
+		if err != nil {
+			return gqlValue{}, p.errorf("invalid string")
+		}
+		return gqlValue{Literal: s}, nil
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && strings.IndexByte("0123456789.eE+-", p.src[p.pos]) >= 0 {
+			p.pos++
+		}
+		n, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return gqlValue{}, p.errorf("invalid number")
+		}
+		return gqlValue{Literal: n}, nil
+	case c == '[':
+		p.pos++
+		var list []interface{}
+		for {
+			p.skipIgnored()
+			if p.peek() == ']' {
+				p.pos++
+				return gqlValue{Literal: list}, nil
+			}
+			v, err := p.parseValue()
+			if err != nil {
+				return gqlValue{}, err
+			}
+			if v.Variable != "" {
+				return gqlValue{}, p.errorf("variables inside lists are not supported")
+			}
+			list = append(list, v.Literal)
+		}
+	case isNameByte(c, true):
+		switch name := p.readName(); name {
+		case "true":
+			return gqlValue{Literal: true}, nil
+		case "false":
+			return gqlValue{Literal: false}, nil
+		case "null":
+			return gqlValue{}, nil
+		default:
+			return gqlValue{Literal: name}, nil // enum はそのまま文字列として扱う
+		}
+	}
+	return gqlValue{}, p.errorf("value expected")
+}
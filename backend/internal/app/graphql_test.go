@@ -0,0 +1,193 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// gqlShape は選択を alias:name(args){...} の文字列にして比べやすくする
+func gqlShape(fields []gqlField) string {
+	parts := make([]string, 0, len(fields))
+	for _, f := range fields {
+		s := f.Name
+		if f.Alias != "" {
+			s = f.Alias + ":" + s
+		}
+		if len(f.Args) > 0 {
+			args := make([]string, 0, len(f.Args))
+			for name := range f.Args {
+				args = append(args, name)
+			}
+			slices.Sort(args)
+			s += "(" + strings.Join(args, ",") + ")"
+		}
+		if len(f.Selection) > 0 {
+			s += "{" + gqlShape(f.Selection) + "}"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestParseGraphQL(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"shorthand", `{ stats { totalBooks } }`, "stats{totalBooks}"},
+		{"aliases and arguments", `query Dash { unread: books(status: "unread") { title } done: books(status: "completed") { title } }`,
+			`unread:books(status){title} done:books(status){title}`},
+		{"comments and commas", "{\n  # 統計\n  stats { totalBooks, currentStreak }\n}", "stats{totalBooks currentStreak}"},
+		{"named fragment", `query { books { ...bookParts deadline } } fragment bookParts on Book { title author }`,
+			"books{title author deadline}"},
+		{"fragment defined first", `fragment s on Stats { totalBooks } { stats { ...s } }`, "stats{totalBooks}"},
+		{"nested fragments", `{ books { ...a } } fragment a on Book { title ...b } fragment b on Book { author }`,
+			"books{title author}"},
+		{"inline fragment", `{ books { title ... on Book { author } ... { deadline } } }`, "books{title author deadline}"},
+		{"root fragment", `{ ...dash } fragment dash on Query { stats { totalBooks } settings { insultTone } }`,
+			"stats{totalBooks} settings{insultTone}"},
+		{"merges the same field", `{ books { title } ...more } fragment more on Query { books { title author } }`,
+			"books{title author}"},
+	}
+	for _, tt := range tests {
+		fields, _, err := parseGraphQL(tt.query)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := gqlShape(fields); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseGraphQLVariables(t *testing.T) {
+	fields, defaults, err := parseGraphQL(`query($limit: Int = 5, $status: String!, $tags: [String!]) { insultHistory(limit: $limit) { message } books(status: $status, archived: true) { title } }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(defaults, map[string]interface{}{"limit": 5.0}) {
+		t.Errorf("defaults = %v", defaults)
+	}
+	if got := fields[0].Args["limit"]; got.Variable != "limit" {
+		t.Errorf("limit arg = %+v", got)
+	}
+	args := fields[1].Args
+	if args["status"].resolve(map[string]interface{}{"status": "unread"}) != "unread" || args["archived"].Literal != true {
+		t.Errorf("books args = %+v", args)
+	}
+}
+
+func TestParseGraphQLErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"mutation", `mutation { deleteBook(id: "1") { ok } }`, "only query operations"},
+		{"directive", `{ books @include(if: true) { title } }`, "directives are not supported"},
+		{"unknown fragment", `{ books { ...missing } }`, `unknown fragment "missing"`},
+		{"self spread", `{ books { ...a } } fragment a on Book { title ...a }`, `fragment "a" spreads itself`},
+		{"fragment cycle", `{ books { ...a } } fragment a on Book { ...b } fragment b on Book { ...a }`, "spreads itself"},
+		{"duplicate fragment", `{ books { ...a } } fragment a on Book { title } fragment a on Book { author }`, "defined more than once"},
+		{"missing type condition", `{ books { ...a } } fragment a { title }`, `expected "on"`},
+		{"conflicting alias", `{ x: books { title } x: stats { totalBooks } }`, `fields "x" conflict`},
+		{"conflicting arguments", `{ books(status: "unread") { title } ...f } fragment f on Query { books(status: "completed") { title } }`, "conflict"},
+		{"two operations", `{ stats { totalBooks } } { books { title } }`, "only one operation"},
+		{"no operation", `fragment a on Book { title }`, "no query operation"},
+		{"empty selection", `{ books { } }`, "empty selection set"},
+		{"too deep", `{ a { b { c { d { e { f { g { h { i { j } } } } } } } } } }`, "nested too deeply"},
+		{"unterminated", `{ books(status: "unread) { title } }`, "unterminated string"},
+	}
+	for _, tt := range tests {
+		_, _, err := parseGraphQL(tt.query)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want it to contain %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestSelectGraphQL(t *testing.T) {
+	fields, _, err := parseGraphQL(`{ books { name: title series { title } ...extra } } fragment extra on Book { pageCount }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := []map[string]interface{}{
+		{"title": "坂の上の雲 1", "page_count": 350, "series": map[string]interface{}{"title": "坂の上の雲"}, "author": "司馬遼太郎"},
+		{"title": "失敗の科学", "page_count": 400, "series": nil},
+	}
+	got, err := projectGraphQL(value, fields[0].Selection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := json.Marshal(got)
+	want := `[{"name":"坂の上の雲 1","pageCount":350,"series":{"title":"坂の上の雲"}},{"name":"失敗の科学","pageCount":400,"series":null}]`
+	if string(raw) != want {
+		t.Errorf("got %s, want %s", raw, want)
+	}
+
+	for _, query := range []string{`{ books { title { x } } }`, `{ books { series } }`, `{ books { isbn } }`} {
+		fields, _, err := parseGraphQL(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := projectGraphQL(value, fields[0].Selection); err == nil {
+			t.Errorf("%s: expected a selection error", query)
+		}
+	}
+}
+
+func TestHandleGraphQL(t *testing.T) {
+	saved := graphqlQueryFields
+	t.Cleanup(func() { graphqlQueryFields = saved })
+	var gotArgs map[string]interface{}
+	graphqlQueryFields = map[string]gqlResolver{
+		"books": func(_ *http.Request, userID string, args map[string]interface{}) (interface{}, error) {
+			gotArgs = args
+			return []Book{{BookID: "b1", UserID: userID, Title: "失敗の科学", Status: "unread"}}, nil
+		},
+		"stats": func(*http.Request, string, map[string]interface{}) (interface{}, error) {
+			return nil, errors.New("stats unavailable")
+		},
+	}
+
+	body := `{"query":"query($s: String) { unread: books(status: $s) { ...b } stats { totalBooks } nope } fragment b on Book { bookId title }","variables":{"s":"unread"}}`
+	req := httptest.NewRequest(http.MethodPost, "/api/graphql?userId=user-1", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handleGraphQL(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Data   map[string]json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string   `json:"message"`
+			Path    []string `json:"path"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(resp.Data["unread"]); got != `[{"bookId":"b1","title":"失敗の科学"}]` {
+		t.Errorf("unread = %s", got)
+	}
+	if gotArgs["status"] != "unread" {
+		t.Errorf("books args = %v", gotArgs)
+	}
+	if string(resp.Data["stats"]) != "null" || string(resp.Data["nope"]) != "null" || len(resp.Errors) != 2 {
+		t.Errorf("expected stats and nope to fail: %s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	handleGraphQL(rec, httptest.NewRequest(http.MethodGet, "/api/graphql?userId=user-1&query="+strings.ReplaceAll("{ books { ...missing } }", " ", "+"), nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown fragment: status = %d", rec.Code)
+	}
+}
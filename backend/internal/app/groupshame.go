@@ -0,0 +1,199 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// グループ内で送るコマンド。送った本人だけが対象になる。
+const (
+	groupShameOptIn  = "積読を晒す"
+	groupShameOptOut = "晒すのをやめる"
+)
+
+const groupShameText = "📢 {{.DisplayName}}さんは『{{.Title}}』を{{.DaysOverdue}}日放置しています。"
+
+// GroupShameConsent はユーザーがグループでの公開に同意した記録 (group_shame_members)
+type GroupShameConsent struct {
+	GroupID     string    `json:"group_id"`
+	UserID      string    `json:"user_id"`
+	DisplayName string    `json:"display_name"`
+	ConsentedAt time.Time `json:"consented_at"`
+}
+
+// groupMemberName はグループでの表示名を LINE から取得する。取れなければ空文字。
+func groupMemberName(ch LineChannel, groupID, lineUserID string) string {
+	body, err := lineChannelAPI(ch, http.MethodGet, fmt.Sprintf("%s/group/%s/member/%s", lineAPIBase, groupID, lineUserID), "", nil)
+	if err != nil {
+		log.Printf("[WARNING] failed to fetch group member profile: %v", err)
+		return ""
+	}
+	var profile struct {
+		DisplayName string `json:"displayName"`
+	}
+	json.Unmarshal(body, &profile)
+	return profile.DisplayName
+}
+
+// handleGroupEvent はグループでのイベントを処理する。
+// join で使い方を案内し、メンバーが「積読を晒す」と送ったときだけその人の期限切れをこのグループに投稿する。
+func handleGroupEvent(ch LineChannel, ev lineWebhookEvent) {
+	groupID := ev.Source.GroupID
+	if groupID == "" {
+		return
+	}
+	switch ev.Type {
+	case "join":
+		if err := replyLineMessage(ch, ev.ReplyToken, fmt.Sprintf(
+			"積読キラーです。このグループで期限切れの本を晒してほしい人は「%s」と送ってください。\nやめるときは「%s」です。同意した人以外の本は投稿しません。",
+			groupShameOptIn, groupShameOptOut)); err != nil {
+			log.Printf("[ERROR] failed to reply to group join %s: %v", groupID, err)
+		}
+	case "leave":
+		if _, _, err := execute(supabaseClient.From("group_shame_members").Delete("minimal", "").Eq("group_id", groupID)); err != nil {
+			log.Printf("[ERROR] failed to clear consents for group %s: %v", groupID, err)
+		}
+	case "memberLeft":
+		// グループを抜けた人の同意は取り消す
+		for _, m := range ev.Left.Members {
+			resp, _, err := execute(supabaseClient.From("users").Select("id", "", false).Eq("line_user_id", m.UserID))
+			if err != nil {
+				log.Printf("[ERROR] group member lookup error: %v", err)
+				continue
+			}
+			var users []struct {
+				ID string `json:"id"`
+			}
+			json.Unmarshal(resp, &users)
+			for _, u := range users {
+				execute(supabaseClient.From("group_shame_members").Delete("minimal", "").Eq("group_id", groupID).Eq("user_id", u.ID))
+			}
+		}
+	case "message":
+		text := strings.TrimSpace(ev.Message.Text)
+		if ev.Message.Type != "text" || ev.Source.UserID == "" || (text != groupShameOptIn && text != groupShameOptOut) {
+			return
+		}
+		reply := setGroupShameConsent(ch, groupID, ev.Source.UserID, text == groupShameOptIn)
+		if err := replyLineMessage(ch, ev.ReplyToken, reply); err != nil {
+			log.Printf("[ERROR] failed to reply in group %s: %v", groupID, err)
+		}
+	}
+}
+
+// setGroupShameConsent は同意を記録または取り消し、グループへの返信文を返す
+func setGroupShameConsent(ch LineChannel, groupID, lineUserID string, consent bool) string {
+	resp, _, err := execute(supabaseClient.From("users").Select("id", "", false).Eq("line_user_id", lineUserID))
+	if err != nil {
+		log.Printf("[ERROR] group shame user lookup error: %v", err)
+		return "エラーが発生しました。時間をおいてもう一度送ってください。"
+	}
+	var users []struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(resp, &users); len(users) == 0 {
+		return "まだ積読キラーに登録されていないようです。先に1対1のトークでアカウントを連携してください。"
+	}
+	userID := users[0].ID
+
+	if !consent {
+		if _, _, err := execute(supabaseClient.From("group_shame_members").Delete("minimal", "").Eq("group_id", groupID).Eq("user_id", userID)); err != nil {
+			log.Printf("[ERROR] group shame opt-out error: %v", err)
+			return "エラーが発生しました。時間をおいてもう一度送ってください。"
+		}
+		return "了解です。このグループではもう晒しません。"
+	}
+
+	name := groupMemberName(ch, groupID, lineUserID)
+	if _, _, err := execute(supabaseClient.From("group_shame_members").Insert(map[string]interface{}{
+		"group_id":     groupID,
+		"user_id":      userID,
+		"display_name": nullIfEmpty(name),
+		"line_channel": ch.Name,
+		"consented_at": clock.Now(),
+	}, true, "group_id,user_id", "minimal", "")); err != nil {
+		log.Printf("[ERROR] group shame opt-in error: %v", err)
+		return "エラーが発生しました。時間をおいてもう一度送ってください。"
+	}
+	if name == "" {
+		name = "あなた"
+	}
+	return fmt.Sprintf("覚悟は受け取りました。%sさんの期限切れの本は、今後このグループに晒されます。", name)
+}
+
+// enqueueGroupShame は同意しているグループに期限切れを投稿するジョブを積む
+func enqueueGroupShame(book Book, now time.Time) error {
+	if !flagEnabled(flagGroupShame, book.UserID) {
+		return nil
+	}
+	resp, _, err := execute(supabaseClient.From("group_shame_members").Select("group_id, display_name", "", false).Eq("user_id", book.UserID))
+	if err != nil {
+		return err
+	}
+	var consents []GroupShameConsent
+	json.Unmarshal(resp, &consents)
+	for _, c := range consents {
+		name := c.DisplayName
+		if name == "" {
+			name = "メンバーの誰か"
+		}
+		data := bookMessageData(book, now)
+		data.DisplayName = name
+		msg := renderMessage(groupShameText, data, fmt.Sprintf("%sさんは『%s』を%d日放置しています。", name, book.Title, data.DaysOverdue))
+		if err := enqueueJob(NotificationJob{Kind: jobKindGroupShame, BookID: book.BookID, UserID: book.UserID, LineUserID: c.GroupID, Message: msg, RunAt: now}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleGroupShame は /api/users/group-shame (同意中のグループ一覧・取り消し)
+func handleGroupShame(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		http.Error(w, "userId is required", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		resp, _, err := execute(supabaseClient.From("group_shame_members").Select("*", "", false).Eq("user_id", userID))
+		if err != nil {
+			log.Printf("[ERROR] handleGroupShame list error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch groups: %v", err), http.StatusInternalServerError)
+			return
+		}
+		var consents []GroupShameConsent
+		json.Unmarshal(resp, &consents)
+		if consents == nil {
+			consents = []GroupShameConsent{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"groups": consents})
+
+	case http.MethodDelete:
+		groupID := r.URL.Query().Get("groupId")
+		if groupID == "" {
+			http.Error(w, "groupId is required", http.StatusBadRequest)
+			return
+		}
+		resp, _, err := execute(supabaseClient.From("group_shame_members").Delete("", "").Eq("group_id", groupID).Eq("user_id", userID))
+		if err != nil {
+			log.Printf("[ERROR] handleGroupShame delete error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to revoke consent: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if string(resp) == "[]" {
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Consent revoked"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
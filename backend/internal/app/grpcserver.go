@@ -0,0 +1,467 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+
+	tundokuv1 "tundoku-killer/backend/gen/tundoku/v1"
+)
+
+// gRPC の BookService と NotificationService (proto/tundoku/v1)。GRPC_PORT を設定すると HTTP サーバーと並べて起動する。
+// 一覧・取得・読了・ジョブは REST ハンドラーと同じ関数を呼ぶ。作成・更新・削除は検証・警告・イベント・取り消しを
+// REST と揃えるため、ctl.go と同じく REST ハンドラーをプロセス内で呼ぶ。
+// 認証は authorization メタデータ ("Bearer <token>")。BookService はセッショントークン (自分の本だけ) か ADMIN_API_TOKEN、
+// NotificationService は ADMIN_API_TOKEN か CRON_SECRET。
+const (
+	grpcMaxJobs     = 200
+	grpcDefaultJobs = 50
+)
+
+// startGRPCServer は GRPC_PORT が設定されていれば gRPC サーバーを起動する
+func startGRPCServer() {
+	port := os.Getenv("GRPC_PORT")
+	if port == "" {
+		return
+	}
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatalf("cannot listen on GRPC_PORT %s: %v", port, err)
+	}
+	srv := grpc.NewServer()
+	tundokuv1.RegisterBookServiceServer(srv, bookServiceServer{})
+	tundokuv1.RegisterNotificationServiceServer(srv, notificationServiceServer{})
+	go func() {
+		log.Printf("[INFO] gRPC server starting on port %s", port)
+		if err := srv.Serve(lis); err != nil {
+			log.Printf("[ERROR] gRPC server stopped: %v", err)
+		}
+	}()
+}
+
+// grpcHTTPRequest は gRPC の呼び出しを REST の認証やハンドラーに渡すための *http.Request。
+// authorization メタデータを Authorization ヘッダーに、接続元を RemoteAddr に写す。
+func grpcHTTPRequest(ctx context.Context, method, target string, body io.Reader) *http.Request {
+	r := httptest.NewRequest(method, target, body).WithContext(ctx)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			r.Header.Set("Authorization", v[0])
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		r.RemoteAddr = p.Addr.String()
+	}
+	return r
+}
+
+// grpcCaller は BookService の呼び出し元。admin なら userID は空で、誰の本でも扱える。
+func grpcCaller(ctx context.Context) (userID string, admin bool, err error) {
+	r := grpcHTTPRequest(ctx, http.MethodGet, "/", nil)
+	if adminTokenValid(r) {
+		return "", true, nil
+	}
+	session, err := authenticateSession(r)
+	if errors.Is(err, errNoSession) {
+		return "", false, status.Error(codes.Unauthenticated, "valid session token required")
+	}
+	if err != nil {
+		return "", false, status.Errorf(codes.Internal, "failed to verify session: %v", err)
+	}
+	return session.UserID, false, nil
+}
+
+// grpcAuthorizeUser は呼び出し元が userID の本を扱えるか確かめる
+func grpcAuthorizeUser(ctx context.Context, userID string) error {
+	if userID == "" {
+		return status.Error(codes.InvalidArgument, "user_id required")
+	}
+	caller, admin, err := grpcCaller(ctx)
+	if err != nil {
+		return err
+	}
+	if !admin && caller != userID {
+		return status.Error(codes.PermissionDenied, "user_id does not match the session")
+	}
+	return nil
+}
+
+// grpcAuthorizeCron は NotificationService の認証。CRON_SECRET が未設定でも通さない。
+// HTTP の X-Cron-Signature は method と path に署名したものなので gRPC では使えず、CRON_HMAC_SECRET があっても Bearer だけを確かめる。
+func grpcAuthorizeCron(ctx context.Context) error {
+	r := grpcHTTPRequest(ctx, http.MethodPost, "/", nil)
+	if adminTokenValid(r) || (os.Getenv("CRON_SECRET") != "" && cronIPAllowed(r) && cronSecretValid(r)) {
+		return nil
+	}
+	return status.Error(codes.Unauthenticated, "admin or cron token required")
+}
+
+// grpcStatusFromHTTP は REST ハンドラーの応答を gRPC のステータスにする
+func grpcStatusFromHTTP(rec *httptest.ResponseRecorder) error {
+	if rec.Code < http.StatusBadRequest {
+		return nil
+	}
+	message := strings.TrimSpace(rec.Body.String())
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(rec.Body.Bytes(), &body) == nil && body.Error != "" {
+		message = body.Error
+	}
+	code := codes.Internal
+	switch rec.Code {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.AlreadyExists
+	case http.StatusUnprocessableEntity:
+		code = codes.FailedPrecondition
+	case http.StatusRequestEntityTooLarge:
+		code = codes.ResourceExhausted
+	}
+	return status.Error(code, message)
+}
+
+// grpcServeREST は REST ハンドラーを body の JSON でプロセス内で呼び、応答の JSON を out に読む。
+// confirm-deadline: true のメタデータは ?confirmDeadline=true (deadlinesanity.go) として渡す。
+func grpcServeREST(ctx context.Context, method string, handler http.HandlerFunc, body interface{}, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid request: %v", err)
+	}
+	target := "/api/books"
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("confirm-deadline"); len(v) > 0 && v[0] == "true" {
+			target += "?confirmDeadline=true"
+		}
+	}
+	r := grpcHTTPRequest(ctx, method, target, bytes.NewReader(payload))
+	r.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler(rec, r)
+	if err := grpcStatusFromHTTP(rec); err != nil {
+		return err
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			return status.Errorf(codes.Internal, "unexpected response: %v", err)
+		}
+	}
+	return nil
+}
+
+func grpcTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func grpcTimestampPtr(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return grpcTimestamp(*t)
+}
+
+func int32Ptr(v *int) *int32 {
+	if v == nil {
+		return nil
+	}
+	n := int32(*v)
+	return &n
+}
+
+func intPtr(v *int32) *int {
+	if v == nil {
+		return nil
+	}
+	n := int(*v)
+	return &n
+}
+
+func bookToProto(b Book) *tundokuv1.Book {
+	pb := &tundokuv1.Book{
+		BookId:          b.BookID,
+		UserId:          b.UserID,
+		Title:           b.Title,
+		Author:          b.Author,
+		Deadline:        grpcTimestamp(b.Deadline),
+		Status:          b.Status,
+		InsultLevel:     int32(b.InsultLevel),
+		Format:          b.Format,
+		PageCount:       int32Ptr(b.PageCount),
+		DurationMinutes: int32Ptr(b.DurationMinutes),
+		Progress:        int32(b.Progress),
+		Series:          b.Series,
+		Volume:          int32Ptr(b.Volume),
+		Price:           int32Ptr(b.Price),
+		Tags:            b.Tags,
+		CreatedAt:       grpcTimestamp(b.CreatedAt),
+		UpdatedAt:       grpcTimestamp(b.UpdatedAt),
+	}
+	if b.InsultTone != nil {
+		pb.InsultTone = *b.InsultTone
+	}
+	if b.SortOrder != nil {
+		pb.SortOrder = int32(*b.SortOrder)
+	}
+	return pb
+}
+
+// bookFromProto は作成・更新の本文にする Book。insult_tone は空ならユーザーの設定のまま (送らない)。
+func bookFromProto(pb *tundokuv1.Book) Book {
+	b := Book{
+		BookID:          pb.GetBookId(),
+		UserID:          pb.GetUserId(),
+		Title:           pb.GetTitle(),
+		Author:          pb.GetAuthor(),
+		Status:          pb.GetStatus(),
+		InsultLevel:     int(pb.GetInsultLevel()),
+		Format:          pb.GetFormat(),
+		PageCount:       intPtr(pb.PageCount),
+		DurationMinutes: intPtr(pb.DurationMinutes),
+		Progress:        int(pb.GetProgress()),
+		Series:          pb.GetSeries(),
+		Volume:          intPtr(pb.Volume),
+		Price:           intPtr(pb.Price),
+		Tags:            pb.GetTags(),
+	}
+	if pb.GetDeadline() != nil {
+		b.Deadline = pb.GetDeadline().AsTime()
+	}
+	if tone := pb.GetInsultTone(); tone != "" {
+		b.InsultTone = &tone
+	}
+	return b
+}
+
+func jobToProto(j NotificationJob) *tundokuv1.NotificationJob {
+	return &tundokuv1.NotificationJob{
+		JobId:      j.JobID,
+		Kind:       j.Kind,
+		BookId:     j.BookID,
+		BookIds:    j.BookIDs,
+		UserId:     j.UserID,
+		LineUserId: j.LineUserID,
+		Message:    j.Message,
+		Template:   j.Template,
+		Status:     j.Status,
+		Attempts:   int32(j.Attempts),
+		LastError:  j.LastError,
+		RunAt:      grpcTimestamp(j.RunAt),
+		SentAt:     grpcTimestampPtr(j.SentAt),
+		CreatedAt:  grpcTimestamp(j.CreatedAt),
+	}
+}
+
+type bookServiceServer struct {
+	tundokuv1.UnimplementedBookServiceServer
+}
+
+func (bookServiceServer) ListBooks(ctx context.Context, req *tundokuv1.ListBooksRequest) (*tundokuv1.ListBooksResponse, error) {
+	if err := grpcAuthorizeUser(ctx, req.GetUserId()); err != nil {
+		return nil, err
+	}
+	books, _, err := loadUserBooks(req.GetUserId())
+	if err != nil {
+		log.Printf("[ERROR] grpc ListBooks error: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to fetch books: %v", err)
+	}
+	books = excludeArchived(books)
+	switch tier := req.GetTier(); tier {
+	case "":
+	case "wishlist", "owned":
+		books = filterByTier(books, tier)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "tier must be wishlist or owned")
+	}
+	resp := &tundokuv1.ListBooksResponse{Books: make([]*tundokuv1.Book, 0, len(books))}
+	for _, b := range books {
+		resp.Books = append(resp.Books, bookToProto(b))
+	}
+	return resp, nil
+}
+
+func (bookServiceServer) GetBook(ctx context.Context, req *tundokuv1.GetBookRequest) (*tundokuv1.Book, error) {
+	if err := grpcAuthorizeUser(ctx, req.GetUserId()); err != nil {
+		return nil, err
+	}
+	book, err := fetchOwnedBook(req.GetBookId(), req.GetUserId())
+	if errors.Is(err, errBookNotFound) {
+		return nil, status.Error(codes.NotFound, "book not found")
+	}
+	if err != nil {
+		log.Printf("[ERROR] grpc GetBook error: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to fetch book: %v", err)
+	}
+	return bookToProto(book), nil
+}
+
+// grpcWriteResponse は handleRegisterBook / handleUpdateBook の応答
+type grpcWriteResponse struct {
+	Book *Book `json:"book"`
+}
+
+func (bookServiceServer) CreateBook(ctx context.Context, req *tundokuv1.CreateBookRequest) (*tundokuv1.Book, error) {
+	book := bookFromProto(req.GetBook())
+	if err := grpcAuthorizeUser(ctx, book.UserID); err != nil {
+		return nil, err
+	}
+	// handleRegisterBook は deadline を文字列で受け取る (deadlineinput.go)。未指定ならユーザーの既定値になる。
+	body := struct {
+		Book
+		Deadline string `json:"deadline,omitempty"`
+	}{Book: book}
+	if !book.Deadline.IsZero() {
+		body.Deadline = book.Deadline.Format(time.RFC3339)
+	}
+	var out grpcWriteResponse
+	if err := grpcServeREST(ctx, http.MethodPost, handleRegisterBook, body, &out); err != nil {
+		return nil, err
+	}
+	if out.Book == nil {
+		return nil, status.Error(codes.Internal, "book was not returned")
+	}
+	return bookToProto(*out.Book), nil
+}
+
+func (bookServiceServer) UpdateBook(ctx context.Context, req *tundokuv1.UpdateBookRequest) (*tundokuv1.Book, error) {
+	book := bookFromProto(req.GetBook())
+	if err := grpcAuthorizeUser(ctx, book.UserID); err != nil {
+		return nil, err
+	}
+	var out grpcWriteResponse
+	if err := grpcServeREST(ctx, http.MethodPut, handleUpdateBook, book, &out); err != nil {
+		return nil, err
+	}
+	if out.Book == nil {
+		return nil, status.Error(codes.NotFound, "book not found")
+	}
+	return bookToProto(*out.Book), nil
+}
+
+func (bookServiceServer) DeleteBook(ctx context.Context, req *tundokuv1.DeleteBookRequest) (*tundokuv1.DeleteBookResponse, error) {
+	if err := grpcAuthorizeUser(ctx, req.GetUserId()); err != nil {
+		return nil, err
+	}
+	body := map[string]string{"book_id": req.GetBookId(), "user_id": req.GetUserId()}
+	if err := grpcServeREST(ctx, http.MethodDelete, handleDeleteBook, body, nil); err != nil {
+		return nil, err
+	}
+	return &tundokuv1.DeleteBookResponse{}, nil
+}
+
+func (bookServiceServer) CompleteBook(ctx context.Context, req *tundokuv1.CompleteBookRequest) (*tundokuv1.CompleteBookResponse, error) {
+	caller, admin, err := grpcCaller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !admin {
+		// 他人の本は存在も明かさない
+		if _, err := fetchOwnedBook(req.GetBookId(), caller); errors.Is(err, errBookNotFound) {
+			return nil, status.Error(codes.NotFound, "book not found")
+		} else if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to fetch book: %v", err)
+		}
+	}
+	result, snap, err := completeBookUndoable(ctx, req.GetBookId())
+	switch {
+	case errors.Is(err, errBookNotFound):
+		return nil, status.Error(codes.NotFound, "book not found")
+	case errors.Is(err, errAlreadyCompleted):
+		return nil, status.Error(codes.FailedPrecondition, "book already completed")
+	case err != nil:
+		log.Printf("[ERROR] grpc CompleteBook error: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to complete book: %v", err)
+	}
+	recordAction(result.Book.UserID, actionCompleteBook, undoPayload{Completions: []completionSnapshot{snap}})
+	return &tundokuv1.CompleteBookResponse{CurrentStreak: int32(result.Streak), Achievements: result.Achievements}, nil
+}
+
+type notificationServiceServer struct {
+	tundokuv1.UnimplementedNotificationServiceServer
+}
+
+func (notificationServiceServer) CheckDeadlines(ctx context.Context, _ *tundokuv1.CheckDeadlinesRequest) (*tundokuv1.CheckDeadlinesResponse, error) {
+	if err := grpcAuthorizeCron(ctx); err != nil {
+		return nil, err
+	}
+	_, queued, err := runDeadlineCheck(ctx, cronTriggerGRPC)
+	opsAlerts.recordCron("check", err, clock.Now())
+	if err != nil {
+		log.Printf("[ERROR] grpc CheckDeadlines error: %v", err)
+		return nil, status.Errorf(codes.Internal, "deadline check failed: %v", err)
+	}
+	return &tundokuv1.CheckDeadlinesResponse{Queued: int32(queued)}, nil
+}
+
+func (notificationServiceServer) ListJobs(ctx context.Context, req *tundokuv1.ListJobsRequest) (*tundokuv1.ListJobsResponse, error) {
+	if err := grpcAuthorizeCron(ctx); err != nil {
+		return nil, err
+	}
+	limit := int(req.GetLimit())
+	if limit <= 0 {
+		limit = grpcDefaultJobs
+	}
+	q := supabaseClient.From("notification_jobs").Select("*", "", false)
+	if req.GetUserId() != "" {
+		q = q.Eq("user_id", req.GetUserId())
+	}
+	if req.GetStatus() != "" {
+		q = q.Eq("status", req.GetStatus())
+	}
+	resp, _, err := execute(q.Order("created_at", &postgrest.OrderOpts{Ascending: false}).Limit(min(limit, grpcMaxJobs), ""))
+	if err != nil {
+		log.Printf("[ERROR] grpc ListJobs error: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to fetch jobs: %v", err)
+	}
+	var jobs []NotificationJob
+	if err := json.Unmarshal(resp, &jobs); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to decode jobs: %v", err)
+	}
+	out := &tundokuv1.ListJobsResponse{Jobs: make([]*tundokuv1.NotificationJob, 0, len(jobs))}
+	for _, j := range jobs {
+		out.Jobs = append(out.Jobs, jobToProto(j))
+	}
+	return out, nil
+}
+
+func (notificationServiceServer) RetryJob(ctx context.Context, req *tundokuv1.RetryJobRequest) (*tundokuv1.NotificationJob, error) {
+	if err := grpcAuthorizeCron(ctx); err != nil {
+		return nil, err
+	}
+	job, err := resendJob(req.GetJobId())
+	if errors.Is(err, errJobNotRetryable) {
+		return nil, status.Error(codes.FailedPrecondition, fmt.Sprintf("job %s: %v", req.GetJobId(), err))
+	}
+	if err != nil {
+		log.Printf("[ERROR] grpc RetryJob error: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to retry job: %v", err)
+	}
+	return jobToProto(job), nil
+}
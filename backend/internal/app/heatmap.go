@@ -0,0 +1,131 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// HeatmapDay はヒートマップの1マス。Level は 0〜4 の濃さ (GitHub の contributions と同じ段階)。
+type HeatmapDay struct {
+	Date            string `json:"date"`
+	Count           int    `json:"count"`
+	Completions     int    `json:"completions"`
+	ProgressUpdates int    `json:"progress_updates"`
+	Level           int    `json:"level"`
+}
+
+// Heatmap は日曜始まりの週ごとに並べた直近1年分の活動
+type Heatmap struct {
+	From  string         `json:"from"`
+	To    string         `json:"to"`
+	Total int            `json:"total"`
+	Max   int            `json:"max"`
+	Weeks [][]HeatmapDay `json:"weeks"`
+}
+
+type heatmapRow struct {
+	Day             string `json:"day"`
+	Completions     int    `json:"completions"`
+	ProgressUpdates int    `json:"progress_updates"`
+}
+
+// loadHeatmapRows は activity_heatmap 関数で日ごとの件数を1回の集計クエリで取得する
+func loadHeatmapRows(r *http.Request, userID string, since time.Time) ([]heatmapRow, error) {
+	if sqlDB != nil {
+		rows, err := sqlDB.QueryContext(r.Context(), `SELECT day::text, completions, progress_updates FROM activity_heatmap($1, $2)`, userID, since)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var result []heatmapRow
+		for rows.Next() {
+			var row heatmapRow
+			if err := rows.Scan(&row.Day, &row.Completions, &row.ProgressUpdates); err != nil {
+				return nil, err
+			}
+			result = append(result, row)
+		}
+		return result, rows.Err()
+	}
+
+	resp, _, err := execute(rpcQuery{name: "activity_heatmap", args: map[string]interface{}{"p_user_id": userID, "p_since": since}})
+	if err != nil {
+		return nil, err
+	}
+	var result []heatmapRow
+	err = json.Unmarshal(resp, &result)
+	return result, err
+}
+
+// heatmapLevel は最大値に対する割合で 1〜4 の段階に分ける
+func heatmapLevel(count, max int) int {
+	if count == 0 || max == 0 {
+		return 0
+	}
+	level := (count*4 + max - 1) / max
+	if level > 4 {
+		level = 4
+	}
+	return level
+}
+
+// buildHeatmap は集計結果を from から to までの全日を埋めた週の配列にする
+func buildHeatmap(rows []heatmapRow, from, to time.Time) Heatmap {
+	byDay := make(map[string]heatmapRow, len(rows))
+	h := Heatmap{From: from.Format("2006-01-02"), To: to.Format("2006-01-02"), Weeks: [][]HeatmapDay{}}
+	for _, row := range rows {
+		byDay[row.Day] = row
+		if n := row.Completions + row.ProgressUpdates; n > h.Max {
+			h.Max = n
+		}
+	}
+
+	var week []HeatmapDay
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		key := d.Format("2006-01-02")
+		row := byDay[key]
+		count := row.Completions + row.ProgressUpdates
+		h.Total += count
+		week = append(week, HeatmapDay{Date: key, Count: count, Completions: row.Completions, ProgressUpdates: row.ProgressUpdates, Level: heatmapLevel(count, h.Max)})
+		if d.Weekday() == time.Saturday {
+			h.Weeks = append(h.Weeks, week)
+			week = nil
+		}
+	}
+	if len(week) > 0 {
+		h.Weeks = append(h.Weeks, week)
+	}
+	return h
+}
+
+// handleHeatmap は GET /api/stats/heatmap?userId=...。読了と進捗記録の日ごとの件数を直近1年分返す。
+func handleHeatmap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+
+	now := clock.Now().In(jst)
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, jst)
+	// GitHub と同じく、1年前の日曜日から始めて週の列を揃える
+	from := to.AddDate(-1, 0, 1)
+	from = from.AddDate(0, 0, -int(from.Weekday()))
+
+	rows, err := loadHeatmapRows(r, userId, from)
+	if err != nil {
+		log.Printf("[ERROR] handleHeatmap query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to load activity: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildHeatmap(rows, from, to))
+}
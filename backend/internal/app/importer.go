@@ -0,0 +1,303 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxImportUploadBytes = 5 << 20
+	maxImportBooks       = 2000
+)
+
+// ImportIssue は取り込めなかった行の理由
+type ImportIssue struct {
+	Line   int    `json:"line"`
+	Title  string `json:"title,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// ImportReport は POST /api/import/{provider} の結果
+type ImportReport struct {
+	Provider   string        `json:"provider"`
+	Total      int           `json:"total"`
+	Imported   int           `json:"imported"`
+	Duplicates int           `json:"duplicates"`
+	Skipped    int           `json:"skipped"`
+	Issues     []ImportIssue `json:"issues"`
+}
+
+// importedBook は外部サービスの1行を Book に写したもの
+type importedBook struct {
+	Line        int
+	Book        Book
+	CompletedAt *time.Time
+}
+
+// importParsers は provider ごとの CSV パーサー
+var importParsers = map[string]func(io.Reader) ([]importedBook, []ImportIssue, error){
+	"goodreads": parseGoodreadsCSV,
+	"booklog":   parseBooklogCSV,
+}
+
+// goodreadsShelfStatus は Goodreads の Exclusive Shelf を積読ステータスに写す (独自の棚は unread)
+var goodreadsShelfStatus = map[string]string{
+	"read":              "completed",
+	"currently-reading": "reading",
+	"to-read":           "unread",
+}
+
+// booklogShelfStatus はブクログの読書状況を積読ステータスに写す
+var booklogShelfStatus = map[string]string{
+	"読み終わった": "completed",
+	"いま読んでる": "reading",
+	"積読":     "unread",
+	"読みたい":   statusWishlist,
+}
+
+// parseGoodreadsCSV は Goodreads の「Export Library」CSV を読む (1行目がヘッダー)
+func parseGoodreadsCSV(r io.Reader) ([]importedBook, []ImportIssue, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read header: %w", err)
+	}
+	col := make(map[string]int, len(header))
+	for i, h := range header {
+		col[strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))] = i
+	}
+	for _, required := range []string{"Title", "Author", "Exclusive Shelf"} {
+		if _, ok := col[required]; !ok {
+			return nil, nil, fmt.Errorf("not a Goodreads export: missing %q column", required)
+		}
+	}
+	field := func(rec []string, name string) string {
+		if i, ok := col[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+
+	var books []importedBook
+	var issues []ImportIssue
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			issues = append(issues, ImportIssue{Line: line, Reason: err.Error()})
+			continue
+		}
+		status, ok := goodreadsShelfStatus[field(rec, "Exclusive Shelf")]
+		if !ok {
+			status = "unread"
+		}
+		b := importedBook{Line: line, Book: Book{Title: field(rec, "Title"), Author: field(rec, "Author"), Status: status}}
+		if n, err := strconv.Atoi(field(rec, "Number of Pages")); err == nil && n > 0 {
+			b.Book.PageCount = &n
+		}
+		if n, err := strconv.Atoi(field(rec, "My Rating")); err == nil && n >= 1 && n <= 5 {
+			b.Book.Rating = &n
+		}
+		if review := field(rec, "My Review"); review != "" {
+			b.Book.Review = &review
+		}
+		if t, err := time.ParseInLocation("2006/01/02", field(rec, "Date Read"), jst); err == nil {
+			b.CompletedAt = &t
+		}
+		books = append(books, b)
+	}
+	return books, issues, nil
+}
+
+// parseBooklogCSV はブクログのエクスポート CSV (ヘッダーなし) を読む。
+// ブクログは Shift_JIS で出力するため、UTF-8 に変換したファイルだけを受け付ける。
+func parseBooklogCSV(r io.Reader) ([]importedBook, []ImportIssue, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !utf8.Valid(data) {
+		return nil, nil, errors.New("booklog export must be converted from Shift_JIS to UTF-8")
+	}
+	cr := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	cr.FieldsPerRecord = -1
+
+	// 列: サービスID, アイテムID, ISBN, カテゴリ, 評価, 読書状況, レビュー, タグ, 読書メモ, 登録日時, 読了日時, タイトル, 作者名, 出版社名, 発行年, ジャンル, ページ数
+	const (
+		colRating    = 4
+		colStatus    = 5
+		colReview    = 6
+		colReadAt    = 10
+		colTitle     = 11
+		colAuthor    = 12
+		colPages     = 16
+		minBooklogFs = 13
+	)
+	var books []importedBook
+	var issues []ImportIssue
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil || len(rec) < minBooklogFs {
+			issues = append(issues, ImportIssue{Line: line, Reason: "not a Booklog export row"})
+			continue
+		}
+		status, ok := booklogShelfStatus[strings.TrimSpace(rec[colStatus])]
+		if !ok {
+			status = "unread"
+		}
+		b := importedBook{Line: line, Book: Book{Title: strings.TrimSpace(rec[colTitle]), Author: strings.TrimSpace(rec[colAuthor]), Status: status}}
+		if n, err := strconv.Atoi(strings.TrimSpace(rec[colRating])); err == nil && n >= 1 && n <= 5 {
+			b.Book.Rating = &n
+		}
+		if review := strings.TrimSpace(rec[colReview]); review != "" {
+			b.Book.Review = &review
+		}
+		if t, err := time.ParseInLocation("2006-01-02 15:04:05", strings.TrimSpace(rec[colReadAt]), jst); err == nil {
+			b.CompletedAt = &t
+		}
+		if len(rec) > colPages {
+			if n, err := strconv.Atoi(strings.TrimSpace(rec[colPages])); err == nil && n > 0 {
+				b.Book.PageCount = &n
+			}
+		}
+		books = append(books, b)
+	}
+	return books, issues, nil
+}
+
+// importKey はタイトルと著者で重複を判定するためのキー
+func importKey(title, author string) string {
+	return normalizeSearchText(title) + "|" + normalizeSearchText(author)
+}
+
+// importRow は取り込む本を books テーブルの行にする。
+// 読了済みの本は読了日を期限として記録し、連続記録や実績の対象にはしない。
+func importRow(userID string, b importedBook, now time.Time) map[string]interface{} {
+	row := map[string]interface{}{
+		"user_id":      userID,
+		"title":        normalizeBookText(b.Book.Title),
+		"author":       normalizeBookText(b.Book.Author),
+		"status":       b.Book.Status,
+		"insult_level": 0,
+		"format":       "paperback",
+		"page_count":   b.Book.PageCount,
+		"rating":       b.Book.Rating,
+		"review":       sealTextPtr("books", b.Book.Review),
+	}
+	switch b.Book.Status {
+	case statusWishlist:
+		row["deadline"] = nil
+	case "completed":
+		row["deadline"] = now
+		if b.CompletedAt != nil {
+			row["deadline"] = *b.CompletedAt
+		}
+	default:
+		row["deadline"] = now.Add(defaultDeadlineOffset)
+	}
+	return row
+}
+
+// insertBookRows は取り込み・復元の本をまとめて登録する。直接接続があれば insertBooksBatch (db.go) で1文にする。
+func insertBookRows(ctx context.Context, rows []map[string]interface{}) error {
+	if sqlDB != nil {
+		return insertBooksBatch(ctx, rows)
+	}
+	// 途中まで入って重複しないよう再試行しない
+	_, _, err := executeOnce(supabaseClient.From("books").Insert(rows, false, "", "minimal", ""))
+	return err
+}
+
+// handleImport は POST /api/import/{provider} (multipart の file と user_id)。
+// 棚をステータスに写し、既存の蔵書やファイル内で重複する本は取り込まずにレポートへ載せる。
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	provider := r.PathValue("provider")
+	parse, ok := importParsers[provider]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unsupported import provider %q", provider), http.StatusNotFound)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportUploadBytes)
+	userID := r.FormValue("user_id")
+	file, _, err := r.FormFile("file")
+	if err != nil || userID == "" {
+		http.Error(w, "user_id and file required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	parsed, issues, err := parse(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(parsed) > maxImportBooks {
+		http.Error(w, fmt.Sprintf("too many books (max %d)", maxImportBooks), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	existing, _, err := loadUserBooks(userID)
+	if err != nil {
+		log.Printf("[ERROR] handleImport library lookup error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to import books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	seen := make(map[string]bool, len(existing)+len(parsed))
+	for _, b := range existing {
+		seen[importKey(b.Title, b.Author)] = true
+	}
+
+	report := ImportReport{Provider: provider, Total: len(parsed) + len(issues), Issues: issues}
+	now := clock.Now()
+	rows := []map[string]interface{}{}
+	for _, b := range parsed {
+		if b.Book.Title == "" || b.Book.Author == "" {
+			report.Issues = append(report.Issues, ImportIssue{Line: b.Line, Title: b.Book.Title, Reason: "title and author required"})
+			continue
+		}
+		key := importKey(b.Book.Title, b.Book.Author)
+		if seen[key] {
+			report.Duplicates++
+			report.Issues = append(report.Issues, ImportIssue{Line: b.Line, Title: b.Book.Title, Reason: "duplicate"})
+			continue
+		}
+		seen[key] = true
+		rows = append(rows, importRow(userID, b, now))
+	}
+	report.Skipped = len(report.Issues) - report.Duplicates
+
+	if len(rows) > 0 {
+		if err := insertBookRows(r.Context(), rows); err != nil {
+			log.Printf("[ERROR] handleImport insert error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to import books: %v", err), http.StatusInternalServerError)
+			return
+		}
+		report.Imported = len(rows)
+		emitBookEvent(BookEvent{Type: "book.imported", UserID: userID})
+	}
+	log.Printf("[INFO] imported %d books from %s for user %s (%d duplicates, %d skipped)", report.Imported, provider, userID, report.Duplicates, report.Skipped)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,325 @@
+package app
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// メールで登録: ユーザーごとの宛先 (register+<token>@INBOUND_EMAIL_DOMAIN) に
+// ネット書店の注文確認メールを転送すると、買った本を既定の期限で登録する。
+// 受信は SendGrid Inbound Parse などの Webhook (multipart の to / subject / text / html) で受ける。
+const (
+	maxInboundEmailBytes = 10 << 20
+	maxInboundBooks      = 20
+	inboundLocalPart     = "register"
+)
+
+var (
+	// 「ISBN」の後の ISBN-10/13 と、ラベルなしの 978/979 で始まる13桁。ハイフンや空白入りも拾い、normalizeISBN で確かめる
+	inboundISBNPattern     = regexp.MustCompile(`(?i)\bISBN(?:-1[03])?[:：\s]*([\d][\d\-\s]{8,15}[\dX])\b`)
+	inboundBareISBNPattern = regexp.MustCompile(`\b(97[89](?:[-\s]?\d){10})\b`)
+	// 『書名』 / 「書名」
+	inboundBracketPattern = regexp.MustCompile(`[『「]([^』」\n]{2,100})[』」]`)
+	// 「商品名：書名」のようなラベル付きの行
+	inboundLabelPattern = regexp.MustCompile(`^\s*(?:商品名|書名|タイトル|商品)\s*[:：]\s*(.{2,100})$`)
+	htmlTagPattern      = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// orderItem は注文確認メールから取り出した1冊分の手がかり
+type orderItem struct {
+	ISBN  string
+	Title string
+}
+
+// parseOrderEmail は注文確認メールの本文から ISBN と書名を取り出す。同じものは1回だけ返す。
+func parseOrderEmail(body string) []orderItem {
+	var items []orderItem
+	seen := make(map[string]bool)
+	add := func(item orderItem) {
+		key := item.ISBN
+		if key == "" {
+			key = normalizeSearchText(item.Title)
+		}
+		if key == "" || seen[key] || len(items) >= maxInboundBooks {
+			return
+		}
+		seen[key] = true
+		items = append(items, item)
+	}
+	for _, pattern := range []*regexp.Regexp{inboundISBNPattern, inboundBareISBNPattern} {
+		for _, m := range pattern.FindAllStringSubmatch(body, -1) {
+			if isbn, ok := normalizeISBN(m[1]); ok && validISBN(isbn) {
+				add(orderItem{ISBN: isbn})
+			}
+		}
+	}
+	for _, line := range strings.Split(body, "\n") {
+		// 転送メールの引用記号を外す
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), ">"))
+		if m := inboundLabelPattern.FindStringSubmatch(line); m != nil {
+			add(orderItem{Title: strings.TrimSpace(m[1])})
+			continue
+		}
+		for _, m := range inboundBracketPattern.FindAllStringSubmatch(line, -1) {
+			add(orderItem{Title: strings.TrimSpace(m[1])})
+		}
+	}
+	return items
+}
+
+// validISBN はチェックディジットを確かめる (注文番号などの数字の並びを除くため)
+func validISBN(isbn string) bool {
+	switch len(isbn) {
+	case 13:
+		sum := 0
+		for i := 0; i < 13; i++ {
+			d := int(isbn[i] - '0')
+			if i%2 == 1 {
+				d *= 3
+			}
+			sum += d
+		}
+		return sum%10 == 0 && (strings.HasPrefix(isbn, "978") || strings.HasPrefix(isbn, "979"))
+	case 10:
+		sum := 0
+		for i := 0; i < 10; i++ {
+			d := int(isbn[i] - '0')
+			if isbn[i] == 'X' {
+				d = 10
+			}
+			sum += d * (10 - i)
+		}
+		return sum%11 == 0
+	}
+	return false
+}
+
+// inboundEmailText はテキスト本文を優先し、HTML しかなければタグを外して使う
+func inboundEmailText(text, htmlBody string) string {
+	if strings.TrimSpace(text) != "" {
+		return text
+	}
+	s := strings.NewReplacer("<br>", "\n", "<br/>", "\n", "<br />", "\n", "</p>", "\n", "</tr>", "\n", "</div>", "\n").Replace(htmlBody)
+	return html.UnescapeString(htmlTagPattern.ReplaceAllString(s, ""))
+}
+
+// inboundTokenFromRecipients は宛先の中から register+<token>@ の token を探す
+func inboundTokenFromRecipients(to string) string {
+	addrs, err := mail.ParseAddressList(to)
+	if err != nil {
+		addrs = []*mail.Address{{Address: to}}
+	}
+	for _, a := range addrs {
+		local, _, ok := strings.Cut(strings.TrimSpace(a.Address), "@")
+		if !ok {
+			continue
+		}
+		if token, ok := strings.CutPrefix(strings.ToLower(local), inboundLocalPart+"+"); ok && token != "" {
+			return token
+		}
+	}
+	return ""
+}
+
+// inboundAddress はユーザーに案内する転送先のアドレス
+func inboundAddress(token string) string {
+	return fmt.Sprintf("%s+%s@%s", inboundLocalPart, token, os.Getenv("INBOUND_EMAIL_DOMAIN"))
+}
+
+// handleInboundEmailAddress は /api/users/inbound-email (POST: 発行・再発行, DELETE: 無効化)。再発行すると古いアドレスは使えなくなる。
+func handleInboundEmailAddress(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if os.Getenv("INBOUND_EMAIL_DOMAIN") == "" {
+		http.Error(w, "Email registration is not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	var token interface{}
+	if r.Method == http.MethodPost {
+		// メールアドレスのローカル部は大文字小文字を区別しない扱いが多いので、小文字の hex にする
+		t, err := newFeedToken()
+		if err != nil {
+			http.Error(w, "failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		token = t[:20]
+	}
+	resp, _, err := execute(supabaseClient.From("users").
+		Update(map[string]interface{}{"inbound_email_token": token, "updated_at": clock.Now()}, "", "").
+		Eq("id", req.UserID))
+	if err != nil {
+		log.Printf("[ERROR] handleInboundEmailAddress update error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to update address: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var users []map[string]interface{}
+	if json.Unmarshal(resp, &users); len(users) == 0 {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if token == nil {
+		json.NewEncoder(w).Encode(map[string]string{"message": "Email registration disabled"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"address": inboundAddress(token.(string))})
+}
+
+// handleInboundEmail は POST /api/inbound/email?key=... (INBOUND_EMAIL_SECRET)。
+// プロバイダーは 2xx 以外を再送するので、宛先不明や本が見つからないメールも 200 で受け取って捨てる。
+func handleInboundEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	secret := os.Getenv("INBOUND_EMAIL_SECRET")
+	if secret == "" || subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("key")), []byte(secret)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxInboundEmailBytes)
+	if err := r.ParseMultipartForm(maxInboundEmailBytes); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	respond := func(message string, registered int) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": message, "registered": registered})
+	}
+
+	token := inboundTokenFromRecipients(r.FormValue("to"))
+	if token == "" {
+		log.Printf("[WARNING] inbound email without a registration address: %q", r.FormValue("to"))
+		respond("No registration address", 0)
+		return
+	}
+	resp, _, err := execute(supabaseClient.From("users").Select("id", "", false).Eq("inbound_email_token", token))
+	if err != nil {
+		// DB の障害なら再送してもらう
+		log.Printf("[ERROR] inbound email user lookup error: %v", err)
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	var users []struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(resp, &users); len(users) == 0 {
+		log.Printf("[WARNING] inbound email for unknown token")
+		respond("Unknown address", 0)
+		return
+	}
+	userID := users[0].ID
+
+	items := parseOrderEmail(inboundEmailText(r.FormValue("text"), r.FormValue("html")))
+	if len(items) == 0 {
+		log.Printf("[INFO] inbound email for user %s had no books (subject %q)", userID, r.FormValue("subject"))
+		respond("No books found", 0)
+		return
+	}
+	titles, err := registerOrderItems(r.Context(), userID, items)
+	if err != nil {
+		log.Printf("[ERROR] inbound email register error for user %s: %v", userID, err)
+		http.Error(w, "failed to register books", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[INFO] registered %d of %d books from inbound email for user %s", len(titles), len(items), userID)
+	if len(titles) > 0 {
+		notifyInboundRegistered(userID, titles)
+	}
+	respond("Processed", len(titles))
+}
+
+// registerOrderItems は書誌を引いて本を登録し、登録した書名を返す。蔵書にある本と書誌が引けない ISBN は飛ばす。
+func registerOrderItems(ctx context.Context, userID string, items []orderItem) ([]string, error) {
+	existing, _, err := loadUserBooks(userID)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(existing))
+	seenTitle := make(map[string]bool, len(existing))
+	for _, b := range existing {
+		seen[importKey(b.Title, b.Author)] = true
+		seenTitle[normalizeSearchText(b.Title)] = true
+	}
+
+	defaults, err := fetchUserDefaults(userID)
+	if err != nil {
+		log.Printf("[WARNING] inbound email defaults lookup failed for user %s: %v", userID, err)
+	}
+
+	now := clock.Now()
+	var rows []map[string]interface{}
+	var titles []string
+	for _, item := range items {
+		query := item.ISBN
+		if query == "" {
+			query = item.Title
+		}
+		book := importedBook{Book: Book{Title: item.Title, Status: "unread"}}
+		suggestions, err := externalSuggestions(ctx, query, 1)
+		if err != nil {
+			log.Printf("[WARNING] inbound email metadata lookup failed for %q: %v", query, err)
+		}
+		if len(suggestions) > 0 {
+			s := suggestions[0]
+			book.Book.Title, book.Book.Author, book.Book.PageCount = s.Title, s.Author, s.PageCount
+		}
+		if book.Book.Title == "" {
+			continue
+		}
+		if seen[importKey(book.Book.Title, book.Book.Author)] || seenTitle[normalizeSearchText(book.Book.Title)] {
+			continue
+		}
+		seen[importKey(book.Book.Title, book.Book.Author)] = true
+		seenTitle[normalizeSearchText(book.Book.Title)] = true
+		row := importRow(userID, book, now)
+		row["deadline"], row["insult_level"], row["tags"] = defaults.deadline(now), defaults.insultLevel(), normalizeTags(defaults.Tags)
+		rows = append(rows, row)
+		titles = append(titles, book.Book.Title)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	if _, _, err := executeOnce(supabaseClient.From("books").Insert(rows, false, "", "minimal", "")); err != nil {
+		return nil, err
+	}
+	emitBookEvent(BookEvent{Type: "book.imported", UserID: userID})
+	return titles, nil
+}
+
+// notifyInboundRegistered はメールから登録した本を LINE で知らせる
+func notifyInboundRegistered(userID string, titles []string) {
+	lineUserID, err := lineUserIDFor(userID)
+	if err != nil || lineUserID == "" {
+		return
+	}
+	defaults, _ := fetchUserDefaults(userID)
+	var b strings.Builder
+	fmt.Fprintf(&b, "📩 注文メールから%d冊を積読に登録しました。期限は%sです。", len(titles), defaults.deadline(clock.Now()).In(jst).Format("1/2"))
+	for _, t := range titles {
+		fmt.Fprintf(&b, "\n・%s", t)
+	}
+	if err := enqueueJob(NotificationJob{Kind: jobKindInboundEmail, UserID: userID, LineUserID: lineUserID, Message: b.String(), RunAt: clock.Now()}); err != nil {
+		log.Printf("[ERROR] failed to notify inbound registration for user %s: %v", userID, err)
+	}
+}
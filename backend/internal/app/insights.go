@@ -0,0 +1,181 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	insightMinSamples    = 5   // 割合の観察に必要な読了数
+	insightLongBookPages = 400 // 「分厚い本」とみなすページ数
+	insightLastMinute    = 3   // 期限前この日数以内の読了を「駆け込み」とみなす
+	insightStaleDays     = 180 // 登録からこの日数を過ぎた積読を「化石」とみなす
+	insightRecentWindow  = 90  // 買うペースと読むペースを比べる日数
+)
+
+// Insight は過去の記録から見つけた先延ばしの傾向。Taunt は督促に添える言い回し。
+type Insight struct {
+	Code    string  `json:"code"`
+	Message string  `json:"message"`
+	Taunt   string  `json:"taunt"`
+	Value   float64 `json:"value"` // 観察の根拠になった数値 (割合や冊数)
+}
+
+// computeInsights は蔵書と読了記録から傾向を挙げる。記録が少なくて言い切れないものは出さない。
+func computeInsights(books []Book, completions []Completion, now time.Time) []Insight {
+	insights := []Insight{}
+	completed := make(map[string]bool, len(completions))
+	for _, c := range completions {
+		completed[c.BookID] = true
+	}
+
+	// 分厚い本を読み切れない
+	longOwned, longDone := 0, 0
+	for _, b := range books {
+		if b.isTimeBased() || b.PageCount == nil || *b.PageCount <= insightLongBookPages || b.Status == statusWishlist {
+			continue
+		}
+		longOwned++
+		if completed[b.BookID] {
+			longDone++
+		}
+	}
+	if longOwned >= 3 && longDone == 0 {
+		insights = append(insights, Insight{
+			Code:    "never_finishes_long_books",
+			Message: fmt.Sprintf("%dページを超える本を%d冊持っていますが、1冊も読み終えていません。", insightLongBookPages, longOwned),
+			Taunt:   fmt.Sprintf("ちなみに%dページ超えの本の読了実績はゼロです。", insightLongBookPages),
+			Value:   float64(longOwned),
+		})
+	}
+
+	if len(completions) >= insightMinSamples {
+		lastMinute, late := 0, 0
+		for _, c := range completions {
+			switch {
+			case c.DaysEarly < 0:
+				late++
+			case c.DaysEarly < insightLastMinute:
+				lastMinute++
+			}
+		}
+		// 期限前の駆け込み
+		if share := float64(lastMinute) / float64(len(completions)); share >= 0.5 {
+			insights = append(insights, Insight{
+				Code:    "last_minute_finisher",
+				Message: fmt.Sprintf("読了の%d%%は期限前%d日以内の駆け込みです。", int(share*100), insightLastMinute),
+				Taunt:   fmt.Sprintf("どうせ今回も期限直前に慌てて読むんでしょう。読了の%d%%がそうですから。", int(share*100)),
+				Value:   share,
+			})
+		}
+		// 期限を守れない
+		if share := float64(late) / float64(len(completions)); share >= 0.5 {
+			insights = append(insights, Insight{
+				Code:    "usually_late",
+				Message: fmt.Sprintf("読了の%d%%は期限を過ぎてからです。", int(share*100)),
+				Taunt:   fmt.Sprintf("期限を守れたのは読了のうち%d%%だけ。期限の意味、分かってます？", 100-int(share*100)),
+				Value:   share,
+			})
+		}
+	}
+
+	// 途中で諦める
+	abandoned := 0
+	for _, b := range books {
+		if b.Status == statusAbandoned {
+			abandoned++
+		}
+	}
+	if finished := len(completed) + abandoned; finished >= insightMinSamples {
+		if share := float64(abandoned) / float64(finished); share >= 0.3 {
+			insights = append(insights, Insight{
+				Code:    "frequent_abandoner",
+				Message: fmt.Sprintf("手を付けた本の%d%%を途中で諦めています。", int(share*100)),
+				Taunt:   fmt.Sprintf("この本も諦めリスト入りですか？すでに%d冊も並んでいますよ。", abandoned),
+				Value:   share,
+			})
+		}
+	}
+
+	// 化石になった積読
+	stale := 0
+	for _, b := range books {
+		if slices.Contains(activeStatuses, b.Status) && !b.Archived && now.Sub(b.CreatedAt) > insightStaleDays*24*time.Hour {
+			stale++
+		}
+	}
+	if stale >= 3 {
+		insights = append(insights, Insight{
+			Code:    "fossil_pile",
+			Message: fmt.Sprintf("登録から半年以上読んでいない本が%d冊あります。", stale),
+			Taunt:   fmt.Sprintf("半年以上放置している本が%d冊。化石の発掘でも始めますか？", stale),
+			Value:   float64(stale),
+		})
+	}
+
+	// 読むより買う方が速い
+	since := now.AddDate(0, 0, -insightRecentWindow)
+	bought, read := 0, 0
+	for _, b := range books {
+		if b.Status != statusWishlist && b.CreatedAt.After(since) {
+			bought++
+		}
+	}
+	for _, c := range completions {
+		if c.CompletedAt.After(since) {
+			read++
+		}
+	}
+	if bought >= insightMinSamples && bought >= 2*max(read, 1) {
+		insights = append(insights, Insight{
+			Code:    "buys_faster_than_reads",
+			Message: fmt.Sprintf("この%d日で%d冊積んで、読み終えたのは%d冊です。", insightRecentWindow, bought, read),
+			Taunt:   fmt.Sprintf("この%d日で積んだ本が%d冊、読んだ本は%d冊。買うのをやめれば解決しますよ。", insightRecentWindow, bought, read),
+			Value:   float64(bought) / float64(max(read, 1)),
+		})
+	}
+	return insights
+}
+
+// loadUserInsights はユーザーの蔵書と読了記録を読んで傾向を返す
+func loadUserInsights(userID string) ([]Insight, error) {
+	books, _, err := loadUserBooks(userID)
+	if err != nil {
+		return nil, err
+	}
+	resp, _, err := execute(supabaseClient.From("book_completions").Select("book_id, completed_at, days_early", "", false).Eq("user_id", userID))
+	if err != nil {
+		return nil, err
+	}
+	var completions []Completion
+	if err := json.Unmarshal(resp, &completions); err != nil {
+		return nil, err
+	}
+	return computeInsights(books, completions, clock.Now()), nil
+}
+
+// handleInsights は GET /api/stats/insights?userId=...
+func handleInsights(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+	insights, err := loadUserInsights(userId)
+	if err != nil {
+		log.Printf("[ERROR] handleInsights error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to compute insights: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"insights": insights})
+}
@@ -0,0 +1,513 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	insultRepeatWindowDefault = 14 // 同じテンプレートを同じユーザーに繰り返さない日数
+	insultLevelDefault        = 3  // books.insult_level の既定値。0 (未設定) もこれとして扱う
+	insultLevelMax            = 5
+)
+
+// insultLevelTone は insult_level ごとに本文の前後に付ける言い回し。3 は雛形そのまま。
+var insultLevelTone = map[int]struct{ prefix, suffix string }{
+	1: {"お忙しいところ恐縮ですが、", "\n少しずつでも読んでみませんか？"},
+	2: {"", "\nそろそろ開いてみてもいい頃では？"},
+	4: {"", "\n言い訳は聞き飽きました。"},
+	5: {"【最終警告】", "\n今日読まないなら、その本は手放してください。"},
+}
+
+// clampInsultLevel は insult_level を 1〜insultLevelMax に収める
+func clampInsultLevel(level int) int {
+	if level <= 0 {
+		return insultLevelDefault
+	}
+	if level > insultLevelMax {
+		return insultLevelMax
+	}
+	return level
+}
+
+// withInsultLevel は insult_level に応じて言い回しを強弱させる
+func withInsultLevel(msg string, level int) string {
+	tone := insultLevelTone[clampInsultLevel(level)]
+	return tone.prefix + msg + tone.suffix
+}
+
+// insultTemplate は督促文の雛形。Key は効果測定 (effectiveness.go) の集計単位になるので変えないこと。
+// Weight は選ばれやすさの相対値で、INSULT_TEMPLATE_WEIGHTS (例: "rotten=3,graveyard=0") で上書きできる。
+type insultTemplate struct {
+	Key    string
+	Weight float64
+	Text   string // messages.go のテンプレート
+}
+
+// render は本文を描画する。壊れたテンプレートでも督促は送れるよう、固定文に差し替える。
+func (t insultTemplate) render(data MessageData) string {
+	return renderMessage(t.Text, data, fmt.Sprintf("「%s」の期限を過ぎています。", data.Title))
+}
+
+// 督促のトーン。ユーザーごとに選べ、本ごとに上書きできる (books.insult_tone)。
+const (
+	insultToneStandard  = "standard"
+	insultTonePolite    = "polite"    // 丁寧
+	insultToneKansai    = "kansai"    // 関西弁
+	insultToneSadistic  = "sadistic"  // ドS
+	insultToneCorporate = "corporate" // 慇懃無礼なビジネス文書
+	insultToneGentle    = "gentle"    // やさしい声かけ。家族向けモード (familymode.go) ではこれだけになる
+)
+
+// insultToneLabels は設定画面に出す表示名 (並び順も兼ねる)
+var insultToneLabels = []struct {
+	Tone  string `json:"tone"`
+	Label string `json:"label"`
+}{
+	{insultToneStandard, "標準"},
+	{insultTonePolite, "丁寧"},
+	{insultToneKansai, "関西弁"},
+	{insultToneSadistic, "ドS"},
+	{insultToneCorporate, "慇懃無礼"},
+	{insultToneGentle, "やさしい"},
+}
+
+// insultTonePools はトーンごとの雛形。standard 以外のキーにはトーン名を前置して効果測定で区別できるようにする。
+var insultTonePools = map[string][]insultTemplate{
+	insultToneStandard: {
+		{"waste_of_time", 1, "その本、まだ読んでないんですか？時間の無駄ですね。"},
+		{"never_read", 1, "積読ですか。残念ですね。その本は二度と読まれないでしょう。"},
+		{"rotten", 1, "知識は鮮度が命。その本はもう腐っています。"},
+		{"not_a_priority", 1, "「{{.Title}}」を読むというタスクは、あなたの優先順位リストに存在しないようですね。"},
+		{"graveyard", 1, "あなたの本棚、もはや墓場ですね。未完の志が眠る場所。"},
+		{"known_pattern", 1, "「{{.Title}}」の期限を過ぎました。{{if .Insight}}{{.Insight}}{{else}}いつものことですね。{{end}}"},
+		{"money_wasted", 1, "{{if .MoneyWasted}}積読{{.UnreadCount}}冊、{{yen .MoneyWasted}}分の紙束ですね。{{else}}積読{{.UnreadCount}}冊。本棚は倉庫ではありません。{{end}}"},
+	},
+	insultTonePolite: {
+		{"polite_reminder", 1, "恐れ入りますが、「{{.Title}}」の読了期限を{{.DaysOverdue}}日過ぎております。ご都合のよろしいときにお手に取っていただけますと幸いです。"},
+		{"polite_waiting", 1, "大変申し上げにくいのですが、本棚の一冊がお客様を心よりお待ちしております。"},
+		{"polite_concern", 1, "お忙しい日々かと存じますが、積まれたままの本が少々寂しそうにしております。"},
+	},
+	insultToneKansai: {
+		{"kansai_still", 1, "「{{.Title}}」、まだ読んでへんの？ほんまに読む気あるんか？"},
+		{"kansai_decoration", 1, "その本、もう本棚の飾りになっとるやん。インテリアちゃうで。"},
+		{"kansai_excuse", 1, "「忙しい」言うてる暇あったら1ページでも読まんかい。"},
+	},
+	insultToneSadistic: {
+		{"sadistic_pathetic", 1, "「{{.Title}}」すら読めないなんて、本当に情けないですね。"},
+		{"sadistic_beg", 1, "読ませてください、とお願いするまで許しませんよ。"},
+		{"sadistic_expected", 1, "期限切れ？知っていました。あなたに期待などしていませんから。"},
+	},
+	insultToneCorporate: {
+		{"corporate_status", 1, "「{{.Title}}」の読了タスクについて、その後の進捗はいかがでしょうか。念のため再送いたします。"},
+		{"corporate_priority", 1, "ご認識の齟齬があるといけませんので確認ですが、読了期限は既に{{.DaysOverdue}}日過ぎております。優先度のご判断をお願いできますと幸いです。"},
+		{"corporate_per_my_last", 1, "前回のご連絡の繰り返しとなり恐縮ですが、本件まだご対応いただけていないようです。"},
+	},
+	insultToneGentle: {
+		{"gentle_one_page", 1, "「{{.Title}}」、今日は1ページだけ読んでみませんか？"},
+		{"gentle_waiting", 1, "「{{.Title}}」が本棚で待っています。少しだけ開いてみましょう。"},
+		{"gentle_cheer", 1, "期限は過ぎてしまったけれど大丈夫。「{{.Title}}」を今日から少しずつ読んでいきましょう。"},
+		{"gentle_five_minutes", 1, "「{{.Title}}」を5分だけ読んでみましょう。続きが気になってくるかもしれません。"},
+	},
+}
+
+// validInsultTone は空文字 (未設定) または既知のトーンかを返す
+func validInsultTone(tone string) bool {
+	_, ok := insultTonePools[tone]
+	return tone == "" || ok
+}
+
+// insultTemplateSeries はシリーズをまとめた督促 (seriesInsult) のテンプレートキー
+const insultTemplateSeries = "series"
+
+// insultWeights は INSULT_TEMPLATE_WEIGHTS で上書きした重み。書式の誤りはログに出して既定値を使う。
+func insultWeights() map[string]float64 {
+	weights := make(map[string]float64)
+	for _, pool := range insultTonePools {
+		for _, t := range pool {
+			weights[t.Key] = t.Weight
+		}
+	}
+	for _, pair := range strings.Split(os.Getenv("INSULT_TEMPLATE_WEIGHTS"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		w, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		key = strings.TrimSpace(key)
+		if _, known := weights[key]; !ok || err != nil || w < 0 || !known {
+			log.Printf("[WARNING] ignoring invalid INSULT_TEMPLATE_WEIGHTS entry %q", pair)
+			continue
+		}
+		weights[key] = w
+	}
+	return weights
+}
+
+// insultSelector は1回の督促チェックの間だけ使うテンプレート選択器。
+// 直近に送ったテンプレートを避けつつ重みに従って選ぶ。乱数はリクエストごとに作る。
+type insultSelector struct {
+	rng      *rand.Rand
+	weights  map[string]float64
+	recent   map[string]map[string]bool  // user_id -> 最近使ったテンプレート
+	custom   map[string][]insultTemplate // user_id -> ユーザーが登録した督促文
+	shared   map[string][]insultTemplate // user_id -> 所属するワークスペースの督促文 (workspaces.go)
+	tones    map[string]string           // user_id -> users.insult_tone
+	family   map[string]bool             // user_id -> 家族向けモード (familymode.go)
+	insights map[string][]Insight        // user_id -> 先延ばしの傾向 (必要になったときに読む)
+}
+
+// newInsultSelector は userIDs に最近送った督促のテンプレートを読み込む。
+// 取得に失敗しても、重み付きの選択だけはできるセレクターを返す。
+func newInsultSelector(userIDs []string) (*insultSelector, error) {
+	s := &insultSelector{
+		rng:     rand.New(rand.NewSource(clock.Now().UnixNano())),
+		weights: insultWeights(),
+		recent:  make(map[string]map[string]bool),
+	}
+	if len(userIDs) == 0 {
+		return s, nil
+	}
+	uResp, _, err := execute(supabaseClient.From("users").Select("id, insult_tone", "", false).In("id", userIDs))
+	if err != nil {
+		return s, err
+	}
+	var users []struct {
+		ID         string  `json:"id"`
+		InsultTone *string `json:"insult_tone"`
+	}
+	json.Unmarshal(uResp, &users)
+	s.tones = make(map[string]string, len(users))
+	s.family = make(map[string]bool, len(users))
+	for _, u := range users {
+		if u.InsultTone != nil {
+			s.tones[u.ID] = *u.InsultTone
+		}
+		s.family[u.ID] = familySafe(u.ID)
+	}
+	custom, err := loadCustomInsults(userIDs)
+	if err != nil {
+		return s, err
+	}
+	s.custom = make(map[string][]insultTemplate, len(custom))
+	for userID, rows := range custom {
+		for _, c := range rows {
+			s.custom[userID] = append(s.custom[userID], c.template())
+		}
+	}
+	if s.shared, err = loadWorkspaceInsults(userIDs); err != nil {
+		return s, err
+	}
+	since := clock.Now().AddDate(0, 0, -envInt("INSULT_REPEAT_WINDOW_DAYS", insultRepeatWindowDefault))
+	resp, _, err := execute(supabaseClient.From("notification_jobs").
+		Select("user_id, template", "", false).
+		Eq("kind", jobKindInsult).
+		In("user_id", userIDs).
+		Gte("created_at", since.Format(time.RFC3339)))
+	if err != nil {
+		return s, err
+	}
+	var jobs []NotificationJob
+	if err := json.Unmarshal(resp, &jobs); err != nil {
+		return s, err
+	}
+	for _, job := range jobs {
+		s.remember(job.UserID, job.Template)
+	}
+	return s, nil
+}
+
+func (s *insultSelector) remember(userID, key string) {
+	if key == "" {
+		return
+	}
+	if s.recent[userID] == nil {
+		s.recent[userID] = make(map[string]bool)
+	}
+	s.recent[userID][key] = true
+}
+
+// weight は INSULT_TEMPLATE_WEIGHTS で上書きされていればその値、なければ雛形の既定値
+func (s *insultSelector) weight(t insultTemplate) float64 {
+	if w, ok := s.weights[t.Key]; ok {
+		return w
+	}
+	return t.Weight
+}
+
+// familySafe はユーザーに家族向けモードが効いているか。読み込めなかったユーザーは問い合わせ直す。
+func (s *insultSelector) familySafe(userID string) bool {
+	if safe, ok := s.family[userID]; ok {
+		return safe
+	}
+	return familySafe(userID)
+}
+
+// tone は本の設定、ユーザーの設定、standard の順に決める。家族向けモードなら常に gentle。
+func (s *insultSelector) tone(book Book) string {
+	if s.familySafe(book.UserID) {
+		return insultToneGentle
+	}
+	if book.InsultTone != nil && insultTonePools[*book.InsultTone] != nil {
+		return *book.InsultTone
+	}
+	if t := s.tones[book.UserID]; insultTonePools[t] != nil {
+		return t
+	}
+	return insultToneStandard
+}
+
+// pool はトーンの雛形にワークスペースとユーザーが登録した督促文を混ぜた候補。
+// 家族向けモードでは登録された督促文の中身を保証できないので gentle の雛形だけにする。
+func (s *insultSelector) pool(book Book) []insultTemplate {
+	pool := append([]insultTemplate{}, insultTonePools[s.tone(book)]...)
+	if s.familySafe(book.UserID) {
+		return pool
+	}
+	pool = append(pool, s.shared[book.UserID]...)
+	if flagEnabled(flagCustomInsults, book.UserID) {
+		pool = append(pool, s.custom[book.UserID]...)
+	}
+	return pool
+}
+
+// pick はテンプレートを選び、キーと本文を返す。定型文以外は安全フィルターを通し、不合格ならトーンの定型文から選び直す。
+func (s *insultSelector) pick(ctx context.Context, book Book, data MessageData) (string, string) {
+	if moderationBreaker.isOpen() {
+		// モデレーション API の障害中は確認できない文を候補に入れない
+		return s.pickFrom(book, insultTonePools[s.tone(book)], data)
+	}
+	key, msg := s.pickFrom(book, s.pool(book), data)
+	if isCannedInsult(key) {
+		return key, msg
+	}
+	if err := checkInsultSafety(ctx, msg); err != nil {
+		log.Printf("[WARNING] insult %s for user %s rejected, falling back to canned templates: %v", key, book.UserID, err)
+		return s.pickFrom(book, insultTonePools[s.tone(book)], data)
+	}
+	return key, msg
+}
+
+// isCannedInsult はコードに持っている定型文か (ユーザー登録の文や生成した文でないか)
+func isCannedInsult(key string) bool {
+	return !strings.HasPrefix(key, customInsultPrefix) && !strings.HasPrefix(key, workspaceInsultPrefix)
+}
+
+// pickFrom は pool から選ぶ。全て最近使っていれば重みだけで選ぶ。
+func (s *insultSelector) pickFrom(book Book, pool []insultTemplate, data MessageData) (string, string) {
+	recent := s.recent[book.UserID]
+	candidates := make([]insultTemplate, 0, len(pool))
+	for _, t := range pool {
+		if !recent[t.Key] && s.weight(t) > 0 {
+			candidates = append(candidates, t)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, t := range pool {
+			if s.weight(t) > 0 {
+				candidates = append(candidates, t)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = pool // 全て重み0にされていても督促は止めない
+	}
+
+	total := 0.0
+	for _, t := range candidates {
+		total += s.weight(t)
+	}
+	chosen := candidates[s.rng.Intn(len(candidates))]
+	if total > 0 {
+		x := s.rng.Float64() * total
+		for _, t := range candidates {
+			if x -= s.weight(t); x < 0 {
+				chosen = t
+				break
+			}
+		}
+	}
+	s.remember(book.UserID, chosen.Key)
+	return chosen.Key, chosen.render(data)
+}
+
+// insight はユーザーの傾向から督促に添える一言を1つ選ぶ。傾向がなければ空文字。
+func (s *insultSelector) insight(userID string) string {
+	if s.insights == nil {
+		s.insights = make(map[string][]Insight)
+	}
+	insights, ok := s.insights[userID]
+	if !ok {
+		var err error
+		if insights, err = loadUserInsights(userID); err != nil {
+			log.Printf("[ERROR] failed to load insights for user %s: %v", userID, err)
+		}
+		s.insights[userID] = insights
+	}
+	if len(insights) == 0 {
+		return ""
+	}
+	return insights[s.rng.Intn(len(insights))].Taunt
+}
+
+// compose は督促1通分の本文を組み立てる。シリーズは1通にまとめ、単巻なら放置日数とジャンルの一言を添える。
+// lastRead は本ごとの最後の読書タイマー終了時刻 (lastReadAt)。
+func (s *insultSelector) compose(ctx context.Context, group []Book, lastRead map[string]time.Time) (string, string) {
+	book := group[0]
+	data := bookMessageData(book, clock.Now())
+	if data.FamilySafe {
+		// 傾向の一言やジャンルの一言は皮肉なので付けない
+		book.InsultLevel = min(clampInsultLevel(book.InsultLevel), familySafeMaxLevel)
+		if len(group) > 1 {
+			return familySafeSeriesKey, withInsultLevel(seriesInsult(group, data), book.InsultLevel)
+		}
+		key, msg := s.pick(ctx, book, data)
+		return key, withInsultLevel(msg, book.InsultLevel)
+	}
+	if len(group) > 1 {
+		return insultTemplateSeries, withInsultLevel(seriesInsult(group, data), book.InsultLevel)
+	}
+	data.Insight = s.insight(book.UserID)
+	key, msg := s.pick(ctx, book, data)
+	if data.Insight != "" && !strings.Contains(msg, data.Insight) && s.rng.Intn(2) == 0 {
+		msg += "\n" + data.Insight
+	}
+	if last, ok := lastRead[book.BookID]; ok {
+		if idle := int(clock.Now().Sub(last).Hours() / 24); idle >= 7 {
+			msg += fmt.Sprintf("\nちなみに最後にこの本を開いてから%d日経っています。", idle)
+		}
+	}
+	if remark := genreRemark(book); remark != "" {
+		msg += "\n" + remark
+	}
+	return key, withInsultLevel(msg, book.InsultLevel)
+}
+
+// handleInsultPreview は GET /api/insults/preview?bookId=...&userId=...&level=...&tone=...。
+// 督促を送らずに、その本に届く文面を返す。level・tone を省略すると本とユーザーの設定で組み立てる。
+func handleInsultPreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	book, err := fetchOwnedBook(q.Get("bookId"), q.Get("userId"))
+	if err != nil {
+		writeBookLookupError(w, err)
+		return
+	}
+	if !consumeAPIQuota(w, book.UserID, apiQuotaInsultPreview) {
+		return
+	}
+	if v := q.Get("level"); v != "" {
+		level, err := strconv.Atoi(v)
+		if err != nil || level < 1 || level > insultLevelMax {
+			http.Error(w, fmt.Sprintf("level must be between 1 and %d", insultLevelMax), http.StatusBadRequest)
+			return
+		}
+		book.InsultLevel = level
+	}
+	if v := q.Get("tone"); v != "" {
+		if !validInsultTone(v) {
+			http.Error(w, "unknown tone", http.StatusBadRequest)
+			return
+		}
+		book.InsultTone = &v
+	}
+
+	lastRead, err := lastReadAt([]string{book.BookID})
+	if err != nil {
+		log.Printf("[ERROR] handleInsultPreview sessions query error: %v", err)
+	}
+	selector, err := newInsultSelector([]string{book.UserID})
+	if err != nil {
+		log.Printf("[ERROR] handleInsultPreview recent insults query error: %v", err)
+	}
+	template, message := selector.compose(r.Context(), []Book{book}, lastRead)
+
+	pool := selector.pool(book)
+	data := bookMessageData(book, clock.Now())
+	examples := make([]map[string]string, 0, len(pool))
+	for _, t := range pool {
+		examples = append(examples, map[string]string{"template": t.Key, "message": withInsultLevel(t.render(data), book.InsultLevel)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"level":    clampInsultLevel(book.InsultLevel),
+		"tone":     selector.tone(book),
+		"template": template,
+		"message":  message,
+		"examples": examples,
+	})
+}
+
+// handleInsultTones は /api/insults/tones (GET: 選べるトーンと現在の設定, PUT: ユーザーのトーンを変更)
+func handleInsultTones(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		current := insultToneStandard
+		userId := r.URL.Query().Get("userId")
+		if userId == "" {
+			// トーンの一覧だけならデプロイまで変わらない
+			if notModified(w, r, "", definitionsModifiedAt, "public, max-age=3600") {
+				return
+			}
+		} else {
+			resp, _, err := execute(supabaseClient.From("users").Select("insult_tone", "", false).Eq("id", userId))
+			if err != nil {
+				log.Printf("[ERROR] handleInsultTones user query error: %v", err)
+				http.Error(w, fmt.Sprintf("failed to load tone: %v", err), http.StatusInternalServerError)
+				return
+			}
+			var users []struct {
+				InsultTone *string `json:"insult_tone"`
+			}
+			if json.Unmarshal(resp, &users); len(users) > 0 && users[0].InsultTone != nil {
+				current = *users[0].InsultTone
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"tones": insultToneLabels, "current": current})
+
+	case http.MethodPut:
+		var req struct {
+			UserID string `json:"user_id"`
+			Tone   string `json:"tone"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if !validInsultTone(req.Tone) {
+			http.Error(w, "unknown tone", http.StatusBadRequest)
+			return
+		}
+		resp, _, err := execute(supabaseClient.From("users").
+			Update(map[string]interface{}{"insult_tone": nullIfEmpty(req.Tone), "updated_at": clock.Now()}, "", "").
+			Eq("id", req.UserID))
+		if err != nil {
+			log.Printf("[ERROR] handleInsultTones update error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to update tone: %v", err), http.StatusInternalServerError)
+			return
+		}
+		var users []map[string]interface{}
+		if json.Unmarshal(resp, &users); len(users) == 0 {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Insult tone updated", "tone": req.Tone})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,498 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	notifyPollInterval = 5 * time.Second
+	notifyBatchSize    = 50
+	notifyMaxAttempts  = 5
+	notifyRetryBase    = time.Minute
+	notifyStaleLock    = 5 * time.Minute
+)
+
+const (
+	jobKindInsult       = "insult"
+	jobKindReviewNudge  = "review_nudge"
+	jobKindDigest       = "digest"
+	jobKindMilestone    = "milestone"
+	jobKindMonthly      = "monthly_report"
+	jobKindGroupShame   = "group_shame" // line_user_id にはグループIDが入る
+	jobKindChallenge    = "challenge"
+	jobKindStake        = "stake" // 賭けの発動。立会人宛てのときも user_id は賭けた本人
+	jobKindFocusCheckin = "focus_checkin"
+	jobKindInboundEmail = "inbound_email" // メールから登録した本のお知らせ
+	jobKindEscalation   = "escalation"    // 督促を無視し続けたときの2つ目の送り先 (escalation.go)
+	jobKindTestNotify   = "test"          // LINE の障害中に積んだテスト通知 (degrade.go)
+)
+
+// NotificationJob は notification_jobs テーブルに積まれる送信ジョブ
+type NotificationJob struct {
+	JobID       string          `json:"job_id"`
+	Kind        string          `json:"kind"`     // insult, review_nudge, digest, milestone, monthly_report, group_shame
+	BookID      string          `json:"book_id"`  // digest では空
+	BookIDs     []string        `json:"book_ids"` // シリーズをまとめた督促では全巻
+	MilestoneID string          `json:"milestone_id"`
+	Template    string          `json:"template"`     // 督促のテンプレートキー (効果測定用)
+	InsultLevel *int            `json:"insult_level"` // 督促した時点の本の insult_level
+	Sticker     string          `json:"sticker"`      // 本文の後に送るスタンプ "packageId:stickerId"
+	ImageURL    string          `json:"image_url"`    // 本文の後に送る画像 (読了証)
+	UserID      string          `json:"user_id"`
+	Channel     string          `json:"channel"`         // line, email (notifychannel.go)。空なら line
+	LineUserID  string          `json:"line_user_id"`    // メールで送るジョブでは空のことがある
+	Message     string          `json:"message"`         // Flex Message では通知欄の代替テキスト
+	Payload     json.RawMessage `json:"payload"`         // Flex Message の contents (テキスト送信なら空)
+	Version     int             `json:"payload_version"` // 積んだときのメッセージの形 (payloads.go)
+	Status      string          `json:"status"`          // pending, processing, sent, failed, skipped
+	Attempts    int             `json:"attempts"`
+	LastError   string          `json:"last_error"`
+	ErrorClass  string          `json:"error_class"` // LINE のエラーの分類 (lineerrors.go)
+	RunAt       time.Time       `json:"run_at"`
+	LockedAt    *time.Time      `json:"locked_at"`
+	SentAt      *time.Time      `json:"sent_at"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// enqueueNotification は督促メッセージの送信ジョブを pending で登録する。
+// books が複数ならシリーズをまとめた1通として扱う。
+func enqueueNotification(books []Book, channel, lineUserID, message, template string) error {
+	ids := make([]string, 0, len(books))
+	for _, b := range books {
+		ids = append(ids, b.BookID)
+	}
+	level := books[0].InsultLevel
+	return enqueueJob(NotificationJob{Kind: jobKindInsult, BookID: books[0].BookID, BookIDs: ids, UserID: books[0].UserID, Channel: channel, LineUserID: lineUserID, Message: message, Template: template, InsultLevel: &level, Sticker: stickerForLevel(level), RunAt: clock.Now()})
+}
+
+// jobDedupeKey は同じ日に同じ本について同じ種類の通知を二重に積まないためのキー
+// (送信日 (JST)・種類・ユーザー・本・中間目標・送り先)。本に紐づかないジョブでは空文字。
+// notification_jobs.dedupe_key の一意インデックスが、cron の再実行や複数インスタンスの重複を DB 側で弾く。
+// 失敗・スキップしたジョブはインデックスの対象外なので、同じ日でも積み直せる。
+func jobDedupeKey(job NotificationJob, channel string) string {
+	if job.BookID == "" {
+		return ""
+	}
+	runAt := job.RunAt
+	if runAt.IsZero() {
+		runAt = clock.Now()
+	}
+	recipient := job.LineUserID
+	if recipient == "" {
+		recipient = channel
+	}
+	return strings.Join([]string{runAt.In(jst).Format("2006-01-02"), job.Kind, job.UserID, job.BookID, job.MilestoneID, recipient}, ":")
+}
+
+// isDuplicateJob は dedupe_key の一意制約に引っかかったエラーか
+func isDuplicateJob(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "23505") || strings.Contains(err.Error(), "duplicate key")) &&
+		strings.Contains(err.Error(), "dedupe_key")
+}
+
+// enqueueJob は run_at 以降に送信されるジョブを登録する。同じ日に同じ通知が積まれていれば何もしない。
+// 家族向けモードのユーザーには送れない通知は積まず、督促の文面とレベルを抑える (familymode.go)。
+func enqueueJob(job NotificationJob) error {
+	if !applyFamilySafe(&job) {
+		return nil
+	}
+	var bookID interface{}
+	if job.BookID != "" {
+		bookID = job.BookID
+	}
+	channel := job.Channel
+	if channel == "" {
+		channel = notifyChannelLine
+	}
+	// 重複判定は積んだ日で行い、送信時刻の調整 (sendtime.go) で翌日に回っても同じキーになるようにする
+	dedupeKey := jobDedupeKey(job, channel)
+	job.RunAt = scheduleForUser(job)
+	row := map[string]interface{}{
+		"kind":            job.Kind,
+		"channel":         channel,
+		"book_id":         bookID,
+		"book_ids":        job.BookIDs,
+		"milestone_id":    nullIfEmpty(job.MilestoneID),
+		"template":        nullIfEmpty(job.Template),
+		"insult_level":    job.InsultLevel,
+		"sticker":         nullIfEmpty(job.Sticker),
+		"image_url":       nullIfEmpty(job.ImageURL),
+		"user_id":         job.UserID,
+		"line_user_id":    nullIfEmpty(job.LineUserID),
+		"message":         job.Message,
+		"payload":         job.Payload,
+		"payload_version": payloadVersion,
+		"status":          "pending",
+		"run_at":          job.RunAt,
+		"dedupe_key":      nullIfEmpty(dedupeKey),
+	}
+	_, _, err := executeOnce(supabaseClient.From("notification_jobs").Insert(row, false, "", "", ""))
+	if isDuplicateJob(err) {
+		log.Printf("[INFO] suppressed duplicate %s notification for book %s (user %s)", job.Kind, job.BookID, job.UserID)
+		return nil
+	}
+	return err
+}
+
+// pendingJobBookIDs は kind の未送信ジョブが残っている書籍IDを返す (二重投入防止)。
+// userIDs が nil なら全ユーザーを対象にする。
+func pendingJobBookIDs(userIDs []string, kind string) (map[string]bool, error) {
+	pending := make(map[string]bool)
+	if userIDs != nil && len(userIDs) == 0 {
+		return pending, nil
+	}
+	q := supabaseClient.From("notification_jobs").
+		Select("book_id, book_ids", "", false).
+		Eq("kind", kind).
+		In("status", []string{"pending", "processing"})
+	if userIDs != nil {
+		q = q.In("user_id", userIDs)
+	}
+	resp, _, err := execute(q)
+	if err != nil {
+		return nil, err
+	}
+	var rows []NotificationJob
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		for _, id := range row.targetBookIDs() {
+			pending[id] = true
+		}
+	}
+	return pending, nil
+}
+
+// targetBookIDs はジョブが対象とする書籍ID (シリーズなら全巻)
+func (job NotificationJob) targetBookIDs() []string {
+	if len(job.BookIDs) > 0 {
+		return job.BookIDs
+	}
+	if job.BookID != "" {
+		return []string{job.BookID}
+	}
+	return nil
+}
+
+// anyPending はグループ内に送信待ちの書籍があるかを返す
+func anyPending(books []Book, pending map[string]bool) bool {
+	for _, b := range books {
+		if pending[b.BookID] {
+			return true
+		}
+	}
+	return false
+}
+
+// startNotificationWorkers はキューを読むディスパッチャーと送信ワーカー群を起動する
+func startNotificationWorkers() {
+	workers := envInt("NOTIFY_WORKERS", 2)
+	ratePerSec := envInt("NOTIFY_RATE_PER_SEC", 10)
+
+	jobs := make(chan dispatchedJob)
+	limiter := time.NewTicker(time.Second / time.Duration(ratePerSec))
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for d := range jobs {
+				<-limiter.C
+				processNotificationJob(d.job, d.run)
+				d.run.wg.Done()
+			}
+		}()
+	}
+
+	go func() {
+		ticker := time.NewTicker(notifyPollInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			dispatchNotificationJobs(jobs)
+		}
+	}()
+
+	log.Printf("[INFO] notification workers started (workers=%d, rate=%d/s)", workers, ratePerSec)
+}
+
+// dispatchedJob はワーカーに渡すジョブと、それを取り出した dispatch の回
+type dispatchedJob struct {
+	job NotificationJob
+	run *dispatchRun
+}
+
+// dispatchRun は1回の dispatch で督促を送れた本を集め、全ジョブが終わってから1回の Update で insulted にする
+type dispatchRun struct {
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	bookIDs []string
+}
+
+func (run *dispatchRun) markInsulted(job NotificationJob) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.bookIDs = append(run.bookIDs, job.targetBookIDs()...)
+}
+
+// flush は集めた本をまとめて insulted にする。読了などに変わった本は status の条件で外れる。
+func (run *dispatchRun) flush() {
+	run.wg.Wait()
+	if len(run.bookIDs) == 0 {
+		return
+	}
+	log.Printf("[DEBUG] Updating %d books to status 'insulted'", len(run.bookIDs))
+	bResp, _, err := execute(supabaseClient.From("books").
+		Update(map[string]interface{}{"status": "insulted", "updated_at": clock.Now()}, "", "").
+		In("book_id", run.bookIDs).
+		In("status", []string{"unread", "insulted"}))
+	if err != nil {
+		log.Printf("[ERROR] failed to update %d books after notification: %v", len(run.bookIDs), err)
+		return
+	}
+	emitBookRows("book.updated", bResp)
+}
+
+func dispatchNotificationJobs(jobs chan<- dispatchedJob) {
+	// ワーカーが落ちて processing のまま残ったジョブを戻す
+	execute(supabaseClient.From("notification_jobs").
+		Update(map[string]interface{}{"status": "pending"}, "", "").
+		Eq("status", "processing").
+		Lt("locked_at", clock.Now().Add(-notifyStaleLock).Format(time.RFC3339)))
+
+	resp, _, err := execute(supabaseClient.From("notification_jobs").
+		Select("*", "", false).
+		Eq("status", "pending").
+		Lte("run_at", clock.Now().Format(time.RFC3339)).
+		Limit(notifyBatchSize, ""))
+	if err != nil {
+		log.Printf("[ERROR] dispatchNotificationJobs query error: %v", err)
+		return
+	}
+	var due []NotificationJob
+	if err := json.Unmarshal(resp, &due); err != nil {
+		log.Printf("[ERROR] dispatchNotificationJobs unmarshal error: %v", err)
+		return
+	}
+
+	run := &dispatchRun{}
+	for _, job := range due {
+		// 新しいバージョンのインスタンスが積んだジョブは、そちらに送らせる
+		if job.Version > payloadVersion {
+			continue
+		}
+		if claimed, ok := claimNotificationJob(job); ok {
+			run.wg.Add(1)
+			jobs <- dispatchedJob{job: claimed, run: run}
+		}
+	}
+	// 次のポーリングを止めないよう、書き戻しは送信が終わるのを別に待つ
+	go run.flush()
+}
+
+// claimNotificationJob は status と attempts を条件に更新し、他インスタンスとの取り合いを防ぐ
+func claimNotificationJob(job NotificationJob) (NotificationJob, bool) {
+	resp, _, err := execute(supabaseClient.From("notification_jobs").
+		Update(map[string]interface{}{
+			"status":    "processing",
+			"attempts":  job.Attempts + 1,
+			"locked_at": clock.Now(),
+		}, "", "").
+		Eq("job_id", job.JobID).
+		Eq("status", "pending").
+		Eq("attempts", strconv.Itoa(job.Attempts)))
+	if err != nil {
+		log.Printf("[ERROR] claimNotificationJob %s: %v", job.JobID, err)
+		return job, false
+	}
+	var rows []NotificationJob
+	if err := json.Unmarshal(resp, &rows); err != nil || len(rows) == 0 {
+		return job, false
+	}
+	return rows[0], true
+}
+
+func processNotificationJob(job NotificationJob, run *dispatchRun) {
+	if (job.Kind == jobKindReviewNudge && hasReview(job.BookID)) || (job.Kind == jobKindFocusCheckin && !focusSessionOpen(job.BookID)) {
+		execute(supabaseClient.From("notification_jobs").Update(map[string]interface{}{"status": "skipped"}, "", "").Eq("job_id", job.JobID))
+		return
+	}
+
+	if !quietHoursAllow(job, clock.Now()) {
+		log.Printf("[INFO] skipping job %s (%s): quiet hours for user %s", job.JobID, job.Kind, job.UserID)
+		execute(supabaseClient.From("notification_jobs").Update(map[string]interface{}{"status": "skipped", "last_error": quietHoursReason}, "", "").Eq("job_id", job.JobID))
+		return
+	}
+
+	var err error
+	if job.Channel == notifyChannelEmail {
+		log.Printf("[DEBUG] Sending email for job %s to user %s", job.JobID, job.UserID)
+		if err = sendJobEmail(job); errors.Is(err, errNoNotifyEmail) {
+			log.Printf("[INFO] skipping job %s: user %s has no notification email", job.JobID, job.UserID)
+			execute(supabaseClient.From("notification_jobs").Update(map[string]interface{}{"status": "skipped", "last_error": "no email"}, "", "").Eq("job_id", job.JobID))
+			return
+		}
+	} else {
+		if !lineDeliverable(job.LineUserID) {
+			log.Printf("[INFO] skipping job %s: %s has blocked the bot", job.JobID, job.LineUserID)
+			execute(supabaseClient.From("notification_jobs").Update(map[string]interface{}{"status": "skipped", "last_error": "blocked"}, "", "").Eq("job_id", job.JobID))
+			return
+		}
+
+		if lineBreaker.isOpen() {
+			postponeNotificationJob(job, lineBreakerCooldown)
+			return
+		}
+
+		if !quotaAllows(job.Kind) {
+			log.Printf("[WARNING] skipping job %s (%s): LINE message quota nearly exhausted", job.JobID, job.Kind)
+			execute(supabaseClient.From("notification_jobs").Update(map[string]interface{}{"status": "skipped", "last_error": quotaDegradeReason}, "", "").Eq("job_id", job.JobID))
+			return
+		}
+
+		log.Printf("[DEBUG] Sending LINE message to %s: %s", job.LineUserID, job.Message)
+		err = pushLineMessages(job.LineUserID, jobLineMessages(job))
+	}
+	if err != nil {
+		failNotificationJob(job, err)
+		return
+	}
+
+	now := clock.Now()
+	if _, _, err := execute(supabaseClient.From("notification_jobs").
+		Update(map[string]interface{}{"status": "sent", "sent_at": now, "last_error": nil}, "", "").
+		Eq("job_id", job.JobID)); err != nil {
+		log.Printf("[ERROR] failed to mark job %s as sent: %v", job.JobID, err)
+	}
+	if job.Kind == jobKindMilestone {
+		execute(supabaseClient.From("book_milestones").Update(map[string]interface{}{"reminded_at": now}, "", "").Eq("milestone_id", job.MilestoneID))
+	}
+	if job.Kind != jobKindInsult {
+		return
+	}
+	emitBookEvent(BookEvent{Type: eventInsultSent, BookID: job.BookID, UserID: job.UserID, At: now})
+	run.markInsulted(job)
+}
+
+// jobLineMessages はジョブを LINE で送るメッセージにする。
+// Flex の payload があればそれだけを、なければ本文の後に画像かスタンプを続ける。
+func jobLineMessages(job NotificationJob) []LineMessage {
+	switch {
+	case len(job.Payload) > 0 && string(job.Payload) != "null":
+		return []LineMessage{LineFlexMessage{Type: "flex", AltText: plainText(job.Message), Contents: job.Payload}}
+	case job.ImageURL != "":
+		return []LineMessage{lineTextMessage(job.Message), LineImageMessage{Type: "image", OriginalContentURL: job.ImageURL, PreviewImageURL: job.ImageURL}}
+	case job.Sticker != "":
+		return []LineMessage{lineTextMessage(job.Message), lineStickerMessage(job.Sticker)}
+	}
+	return []LineMessage{lineTextMessage(job.Message)}
+}
+
+// postponeNotificationJob は LINE の障害中に取り出したジョブを送らずに戻す。試行回数には数えない。
+func postponeNotificationJob(job NotificationJob, wait time.Duration) {
+	log.Printf("[WARNING] postponing job %s (%s) for %s: LINE API circuit breaker is open", job.JobID, job.Kind, wait)
+	if _, _, err := execute(supabaseClient.From("notification_jobs").
+		Update(map[string]interface{}{"status": "pending", "run_at": clock.Now().Add(wait), "attempts": job.Attempts - 1, "locked_at": nil}, "", "").
+		Eq("job_id", job.JobID)); err != nil {
+		log.Printf("[ERROR] failed to postpone job %s: %v", job.JobID, err)
+	}
+}
+
+// failNotificationJob は指数バックオフで再投入し、上限に達したら failed にする。メッセージの不備は再送しない。
+// LINE のエラーは分類 (lineerrors.go) を error_class に残し、分類ごとに扱いを変える。
+func failNotificationJob(job NotificationJob, sendErr error) {
+	update := map[string]interface{}{"last_error": sendErr.Error()}
+	permanent := errors.Is(sendErr, errInvalidPayload)
+	var lineErr *lineAPIError
+	if errors.As(sendErr, &lineErr) {
+		update["error_class"] = lineErr.Class
+		if lineErr.Class != lineErrBlockedUser {
+			opsAlerts.recordLineFailure(lineErr.Class, clock.Now())
+		}
+		switch lineErr.Class {
+		case lineErrBlockedUser:
+			// 宛先がグループのジョブ (group_shame) では該当するユーザーがいないので何も変わらない
+			if err := setLineBlocked(job.LineUserID, true); err != nil {
+				log.Printf("[ERROR] failed to mark %s as blocked: %v", job.LineUserID, err)
+			}
+			log.Printf("[INFO] skipping job %s: LINE cannot deliver to %s", job.JobID, job.LineUserID)
+			update["status"], update["last_error"] = "skipped", "blocked"
+		case lineErrRateLimited:
+			// 月の上限に達した場合もあるので、送信数を読み直させる
+			appCache.Delete(quotaCacheKey)
+			wait := lineErr.RetryAfter
+			if wait == 0 {
+				wait = lineRateLimitRetry
+			}
+			update["status"], update["run_at"], update["attempts"] = "pending", clock.Now().Add(wait), job.Attempts-1
+			log.Printf("[WARNING] notification job %s rate limited by LINE, retrying in %s", job.JobID, wait)
+		case lineErrInvalidToken:
+			alertLineError(job, lineErr)
+		case lineErrMalformed:
+			alertLineError(job, lineErr)
+			permanent = true
+		}
+	}
+	if errors.Is(sendErr, errLineUnavailable) {
+		// 送る前にブレーカーに止められただけなので回数に数えない
+		update["status"], update["run_at"], update["attempts"] = "pending", clock.Now().Add(lineBreakerCooldown), job.Attempts-1
+	}
+	if update["status"] == nil {
+		if job.Attempts >= notifyMaxAttempts || permanent {
+			update["status"] = "failed"
+			log.Printf("[ERROR] notification job %s failed permanently after %d attempts: %v", job.JobID, job.Attempts, sendErr)
+		} else {
+			backoff := notifyRetryBase << (job.Attempts - 1)
+			update["status"] = "pending"
+			update["run_at"] = clock.Now().Add(backoff)
+			log.Printf("[WARNING] notification job %s failed (attempt %d), retrying in %s: %v", job.JobID, job.Attempts, backoff, sendErr)
+		}
+	}
+	if _, _, err := execute(supabaseClient.From("notification_jobs").Update(update, "", "").Eq("job_id", job.JobID)); err != nil {
+		log.Printf("[ERROR] failed to record failure for job %s: %v", job.JobID, err)
+	}
+}
+
+// errJobNotRetryable は再送しようとしたジョブがない、または failed / skipped でない
+var errJobNotRetryable = errors.New("job not found or not failed/skipped")
+
+// resendJob は failed / skipped のジョブを pending に戻し、すぐ送り直させる
+func resendJob(jobID string) (NotificationJob, error) {
+	resp, _, err := execute(supabaseClient.From("notification_jobs").
+		Update(map[string]interface{}{
+			"status":     "pending",
+			"attempts":   0,
+			"last_error": nil,
+			"locked_at":  nil,
+			"run_at":     clock.Now(),
+		}, "", "").
+		Eq("job_id", jobID).
+		In("status", []string{"failed", "skipped"}))
+	if err != nil {
+		return NotificationJob{}, err
+	}
+	var jobs []NotificationJob
+	if json.Unmarshal(resp, &jobs); len(jobs) == 0 {
+		return NotificationJob{}, errJobNotRetryable
+	}
+	return jobs[0], nil
+}
+
+// envInt は正の整数の環境変数を読む。未設定や不正値ならデフォルトを返す。
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Printf("[WARNING] invalid %s=%q, using %d", key, v, def)
+		return def
+	}
+	return n
+}
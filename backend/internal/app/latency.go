@@ -0,0 +1,285 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ルートごとの応答時間の上限 (レイテンシ予算)。超えたら 504 を返し、ハンドラーには r.Context() の期限切れで知らせる。
+// 書き込み (POST・PUT・PATCH・DELETE) は 504 を返しても処理が最後まで進んで反映されてしまい、クライアントの再送で二重になるので、
+// 打ち切らずに超過の記録だけにする。
+// 一覧は短く、インポートのように外部 API や大きなファイルを扱うものは長くする。0 は無制限。
+// LATENCY_BUDGETS="GET /api/books=3s,/api/import/{provider}=45s,/api/stats=off" で上書きでき、
+// それ以外のルートは LATENCY_BUDGET_DEFAULT (既定 10s)。
+const defaultLatencyBudget = 10 * time.Second
+
+// latencyBudgets のキーは "METHOD パターン" か、メソッドを問わない "パターン"
+var latencyBudgets = map[string]time.Duration{
+	"GET /api/books":                  2 * time.Second,
+	"/api/books/search":               2 * time.Second,
+	"/api/books/archived":             2 * time.Second,
+	"/api/books/locations":            2 * time.Second,
+	"/api/series":                     2 * time.Second,
+	"/api/notifications":              2 * time.Second,
+	"/api/announcements":              2 * time.Second,
+	"GET /api/books/{id}/notes":       2 * time.Second,
+	"/api/import/{provider}":          30 * time.Second,
+	"/api/import/amazon":              30 * time.Second,
+	"/api/books/tasks/import":         30 * time.Second,
+	"/api/books/scan":                 30 * time.Second,
+	"/api/users/me/restore":           30 * time.Second,
+	"/api/users/me/backup":            30 * time.Second,
+	"/api/export":                     30 * time.Second,
+	"/api/admin/loadtest/data":        30 * time.Second,
+	"/api/admin/encryption/rotate":    30 * time.Second,
+	"/api/integrations/notion/sync":   30 * time.Second,
+	"/api/admin/broadcast":            30 * time.Second,
+	"/api/admin/richmenus/sync":       30 * time.Second,
+	"/api/admin/richmenus/{id}/image": 30 * time.Second,
+	// SSE は接続し続ける
+	"/api/events": 0,
+}
+
+// latencyBudgetFor は r のルートの予算を返す。cron は途中で 504 を返すと外部の cron が再実行してしまうので無制限。
+func latencyBudgetFor(r *http.Request) time.Duration {
+	if r.Pattern == "" || strings.HasPrefix(r.Pattern, "/api/cron/") {
+		return 0
+	}
+	overrides := parseLatencyBudgets(os.Getenv("LATENCY_BUDGETS"))
+	for _, key := range []string{r.Method + " " + r.Pattern, r.Pattern} {
+		if d, ok := overrides[key]; ok {
+			return d
+		}
+		if d, ok := latencyBudgets[key]; ok {
+			return d
+		}
+	}
+	return defaultRouteLatencyBudget()
+}
+
+func defaultRouteLatencyBudget() time.Duration {
+	v := os.Getenv("LATENCY_BUDGET_DEFAULT")
+	if v == "" {
+		return defaultLatencyBudget
+	}
+	d, err := parseLatencyBudget(v)
+	if err != nil {
+		log.Printf("[WARNING] invalid LATENCY_BUDGET_DEFAULT=%q, using %s", v, defaultLatencyBudget)
+		return defaultLatencyBudget
+	}
+	return d
+}
+
+func parseLatencyBudget(v string) (time.Duration, error) {
+	if v == "off" || v == "0" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("must be a duration like 2s or off")
+	}
+	return d, nil
+}
+
+// parseLatencyBudgets は LATENCY_BUDGETS を読む。壊れた項目はログに残して無視する。
+func parseLatencyBudgets(raw string) map[string]time.Duration {
+	out := make(map[string]time.Duration)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(entry, "=")
+		d, err := parseLatencyBudget(strings.TrimSpace(value))
+		if !ok || err != nil {
+			log.Printf("[WARNING] ignoring LATENCY_BUDGETS entry %q", entry)
+			continue
+		}
+		out[strings.TrimSpace(key)] = d
+	}
+	return out
+}
+
+// routeLatency はルートごとの予算超過の記録
+type routeLatency struct {
+	Route           string     `json:"route"`
+	BudgetMs        int64      `json:"budget_ms"`
+	Requests        int        `json:"requests"`
+	Violations      int        `json:"violations"`
+	MaxMs           int64      `json:"max_ms"`
+	LastViolationAt *time.Time `json:"last_violation_at,omitempty"`
+}
+
+type latencyStats struct {
+	mu     sync.Mutex
+	routes map[string]*routeLatency
+}
+
+var routeLatencyStats = &latencyStats{routes: make(map[string]*routeLatency)}
+
+func (s *latencyStats) record(route string, budget, elapsed time.Duration, violated bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rl, ok := s.routes[route]
+	if !ok {
+		rl = &routeLatency{Route: route}
+		s.routes[route] = rl
+	}
+	rl.BudgetMs = budget.Milliseconds()
+	rl.Requests++
+	rl.MaxMs = max(rl.MaxMs, elapsed.Milliseconds())
+	if violated {
+		rl.Violations++
+		now := time.Now()
+		rl.LastViolationAt = &now
+	}
+}
+
+func (s *latencyStats) snapshot() []routeLatency {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]routeLatency, 0, len(s.routes))
+	for _, rl := range s.routes {
+		out = append(out, *rl)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Violations != out[j].Violations {
+			return out[i].Violations > out[j].Violations
+		}
+		return out[i].Route < out[j].Route
+	})
+	return out
+}
+
+// budgetResponseWriter は期限までの応答を溜めておき、間に合ったときだけ本物の ResponseWriter に書く
+type budgetResponseWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	timedOut bool
+}
+
+func (bw *budgetResponseWriter) Header() http.Header { return bw.header }
+
+func (bw *budgetResponseWriter) WriteHeader(code int) {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	if bw.status == 0 && !bw.timedOut {
+		bw.status = code
+	}
+}
+
+func (bw *budgetResponseWriter) Write(b []byte) (int, error) {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	if bw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	return bw.body.Write(b)
+}
+
+// withLatencyBudget は予算のあるルートでハンドラーを別の goroutine で動かし、期限を過ぎたら 504 を返す。
+// Supabase の呼び出しは context を見ないので、期限後もハンドラーは最後まで動く (書き込みは捨てる)。
+// 書き込みのメソッドは打ち切らずにそのまま動かし、超過を記録するだけにする。
+func withLatencyBudget(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	budget := latencyBudgetFor(r)
+	if budget <= 0 {
+		next(w, r)
+		return
+	}
+	if !isReadOnlyMethod(r.Method) {
+		start := time.Now()
+		next(w, r)
+		elapsed := time.Since(start)
+		routeLatencyStats.record(r.Pattern, budget, elapsed, elapsed > budget)
+		if elapsed > budget {
+			log.Printf("[WARNING] %s %s exceeded its latency budget of %s (took %s)", r.Method, r.URL.Path, budget, elapsed)
+		}
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), budget)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	bw := &budgetResponseWriter{header: w.Header().Clone()}
+	done := make(chan struct{})
+	panicked := make(chan interface{}, 1)
+	start := time.Now()
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				if p != http.ErrAbortHandler {
+					p = fmt.Sprintf("%v\n\n%s", p, debug.Stack())
+				}
+				panicked <- p
+			}
+		}()
+		next(bw, r)
+		close(done)
+	}()
+
+	select {
+	case p := <-panicked:
+		panic(p)
+	case <-done:
+		routeLatencyStats.record(r.Pattern, budget, time.Since(start), false)
+		bw.mu.Lock()
+		defer bw.mu.Unlock()
+		dst := w.Header()
+		for k, v := range bw.header {
+			dst[k] = v
+		}
+		if bw.status == 0 {
+			bw.status = http.StatusOK
+		}
+		w.WriteHeader(bw.status)
+		w.Write(bw.body.Bytes())
+	case <-ctx.Done():
+		bw.mu.Lock()
+		defer bw.mu.Unlock()
+		bw.timedOut = true
+		if ctx.Err() != context.DeadlineExceeded {
+			// クライアントが切断した
+			return
+		}
+		routeLatencyStats.record(r.Pattern, budget, time.Since(start), true)
+		log.Printf("[WARNING] %s %s exceeded its latency budget of %s", r.Method, r.URL.Path, budget)
+		http.Error(w, fmt.Sprintf("request exceeded its latency budget of %s (request id: %s)", budget, w.Header().Get("X-Request-Id")), http.StatusGatewayTimeout)
+	}
+}
+
+// handleLatencyMetrics は GET /api/admin/latency。ルートごとの予算と超過回数、最大の応答時間を返す。
+func handleLatencyMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"default_budget_ms": defaultRouteLatencyBudget().Milliseconds(),
+		"routes":            routeLatencyStats.snapshot(),
+	})
+}
+
+// isReadOnlyMethod は途中で 504 を返しても副作用の残らない読み取りのメソッドか
+func isReadOnlyMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
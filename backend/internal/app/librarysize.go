@@ -0,0 +1,150 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 積読の冊数のゆるい上限。ACTIVE_BOOK_SOFT_LIMIT (既定 300、0 で無効) を超えても登録は断らず、
+// 登録時に警告を返し、週報でアーカイブか諦めるのを勧める候補を挙げる。
+const (
+	activeBookSoftLimitDefault     = 300
+	archiveSuggestionCount         = 3 // 週報に載せる数
+	archiveSuggestionsDefaultLimit = 10
+	archiveSuggestionsMaxLimit     = 50
+)
+
+// ArchiveSuggestion はアーカイブ・諦めを勧める本と理由
+type ArchiveSuggestion struct {
+	Book     Book   `json:"book"`
+	IdleDays int    `json:"idle_days"` // 最後に更新してからの日数
+	Reason   string `json:"reason"`
+	score    float64
+}
+
+func activeBookSoftLimit() int {
+	return envInt("ACTIVE_BOOK_SOFT_LIMIT", activeBookSoftLimitDefault)
+}
+
+// countActiveBooks はアーカイブしていない積読の冊数
+func countActiveBooks(userID string) (int, error) {
+	_, n, err := execute(supabaseClient.From("books").
+		Select("book_id", "exact", true).
+		Eq("user_id", userID).
+		In("status", activeStatuses).
+		Eq("archived", "false"))
+	return int(n), err
+}
+
+// librarySizeWarning は積読がゆるい上限を超えていれば警告を返す
+func librarySizeWarning(userID string) *Warning {
+	limit := activeBookSoftLimit()
+	if limit <= 0 || userID == "" {
+		return nil
+	}
+	n, err := countActiveBooks(userID)
+	if err != nil {
+		log.Printf("[WARNING] failed to count active books for user %s: %v", userID, err)
+		return nil
+	}
+	if n < limit {
+		return nil
+	}
+	return &Warning{
+		Code:    "library_too_large",
+		Message: fmt.Sprintf("積読が%d冊あります (目安は%d冊まで)。読まない本はアーカイブするか諦めましょう。", n, limit),
+	}
+}
+
+// archiveSuggestions は手を付けていない期間が長い本から順に候補を選ぶ。読書中・進捗のある本・プロジェクトの本は外す。
+func archiveSuggestions(books []Book, now time.Time, limit int) []ArchiveSuggestion {
+	var suggestions []ArchiveSuggestion
+	for _, b := range excludeArchived(books) {
+		if !slices.Contains(activeStatuses, b.Status) || b.Status == "reading" || b.Progress > 0 || b.ProjectID != nil {
+			continue
+		}
+		idle := int(now.Sub(b.UpdatedAt).Hours() / 24)
+		s := ArchiveSuggestion{Book: b, IdleDays: idle, score: float64(idle)}
+		reasons := []string{fmt.Sprintf("%d日手付かず", idle)}
+		if !b.Deadline.IsZero() && b.Deadline.Before(now) {
+			overdue := int(now.Sub(b.Deadline).Hours() / 24)
+			s.score += math.Min(float64(overdue), 365)
+			reasons = append(reasons, fmt.Sprintf("期限を%d日過ぎている", overdue))
+		}
+		s.Reason = strings.Join(reasons, "・")
+		suggestions = append(suggestions, s)
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool { return suggestions[i].score > suggestions[j].score })
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions
+}
+
+// archiveDigestSection は積読が上限を超えているときに週報に足す段落
+func archiveDigestSection(books []Book, now time.Time) string {
+	limit := activeBookSoftLimit()
+	if limit <= 0 || len(books) < limit {
+		return ""
+	}
+	suggestions := archiveSuggestions(books, now, archiveSuggestionCount)
+	if len(suggestions) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n\n🗄 積読が%d冊を超えています。読まない本はアーカイブか諦めませんか？", limit)
+	for _, s := range suggestions {
+		fmt.Fprintf(&b, "\n・『%s』(%s)", s.Book.Title, s.Reason)
+	}
+	return b.String()
+}
+
+// handleArchiveSuggestions は GET /api/books/archive-suggestions?userId=…&limit=…。
+// 上限を超えていなくても候補と今の冊数を返す。
+func handleArchiveSuggestions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+	limit := archiveSuggestionsDefaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > archiveSuggestionsMaxLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", archiveSuggestionsMaxLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	books, _, err := loadUserBooks(userId)
+	if err != nil {
+		log.Printf("[ERROR] handleArchiveSuggestions error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	active := 0
+	for _, b := range excludeArchived(books) {
+		if slices.Contains(activeStatuses, b.Status) {
+			active++
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"active_books": active,
+		"soft_limit":   activeBookSoftLimit(),
+		"over_limit":   activeBookSoftLimit() > 0 && active >= activeBookSoftLimit(),
+		"suggestions":  archiveSuggestions(books, clock.Now(), limit),
+	})
+}
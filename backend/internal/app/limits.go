@@ -0,0 +1,55 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultMaxRequestBody = 1 << 20
+
+	serverReadHeaderTimeout = 10 * time.Second
+	serverReadTimeout       = 30 * time.Second
+	serverWriteTimeout      = 60 * time.Second
+	serverIdleTimeout       = 120 * time.Second
+)
+
+// uploadBodyLimits はファイルを受け取るルートの上限。それ以外は MAX_REQUEST_BODY_BYTES (既定 1MB)。
+var uploadBodyLimits = map[string]int64{
+	"/api/books/scan":             maxScanUploadBytes,
+	"/api/books/{id}/attachments": maxAttachmentUpload,
+	"/api/books/tasks/import":     maxTaskImportBytes,
+	"/api/import/{provider}":      maxImportUploadBytes,
+	"/api/import/amazon":          maxImportUploadBytes, // {provider} より優先されるので別に書く
+	"/api/users/me/restore":       maxRestoreBytes,
+	"/api/inbound/email":          maxInboundEmailBytes,
+	// 1MB を超えた画像は handleRichMenuImage で LINE の上限として弾く
+	"/api/admin/richmenus/{id}/image": richMenuMaxImage + 1,
+}
+
+// limitRequestBody は本文の大きさを制限する。Content-Length で上限を超えると分かれば読む前に 413 を返す。
+func limitRequestBody(w http.ResponseWriter, r *http.Request) bool {
+	limit, ok := uploadBodyLimits[r.Pattern]
+	if !ok {
+		limit = int64(envInt("MAX_REQUEST_BODY_BYTES", defaultMaxRequestBody))
+	}
+	if r.ContentLength > limit {
+		http.Error(w, fmt.Sprintf("request body too large (max %d bytes)", limit), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	return true
+}
+
+// newServer は遅いクライアントにソケットを握られ続けないようタイムアウトを設定したサーバー。
+// SSE (/api/events) は handleEvents で書き込み期限を外している。
+func newServer(addr string) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		ReadHeaderTimeout: serverReadHeaderTimeout,
+		ReadTimeout:       serverReadTimeout,
+		WriteTimeout:      serverWriteTimeout,
+		IdleTimeout:       serverIdleTimeout,
+	}
+}
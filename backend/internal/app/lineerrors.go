@@ -0,0 +1,93 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LINE Messaging API のエラー応答 ({"message": ..., "details": [{"message", "property"}]}) の分類。
+// 分類は notification_jobs.error_class に残し、ジョブの扱い (jobs.go の failNotificationJob) を変える。
+const (
+	lineErrInvalidToken = "invalid_token"     // 401 / 403。トークンの失効や権限不足。管理者に知らせて再試行する
+	lineErrBlockedUser  = "blocked_user"      // 宛先に送れない。送信不可として記録し、以降は送らない
+	lineErrRateLimited  = "rate_limited"      // 429。回数に数えずに後で送り直す
+	lineErrMalformed    = "malformed_message" // その他の 4xx。送り直しても直らないので管理者に知らせる
+	lineErrServer       = "server_error"      // 5xx。通常どおり指数バックオフで再試行する
+
+	lineRateLimitRetry = 5 * time.Minute // Retry-After がないとき
+)
+
+// lineAPIError は LINE API が 2xx 以外を返したときのエラー
+type lineAPIError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Message    string
+	Details    []lineErrorDetail
+	Body       string
+	RetryAfter time.Duration
+	Class      string
+}
+
+type lineErrorDetail struct {
+	Message  string `json:"message"`
+	Property string `json:"property"`
+}
+
+func (e *lineAPIError) Error() string {
+	return fmt.Sprintf("LINE API %s %s returned %d (%s): %s", e.Method, e.URL, e.StatusCode, e.Class, e.Body)
+}
+
+// newLineAPIError は応答の本文を読んで分類する
+func newLineAPIError(method, url string, resp *http.Response, body []byte) *lineAPIError {
+	e := &lineAPIError{Method: method, URL: url, StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	var parsed struct {
+		Message string            `json:"message"`
+		Details []lineErrorDetail `json:"details"`
+	}
+	if json.Unmarshal(body, &parsed) == nil {
+		e.Message, e.Details = parsed.Message, parsed.Details
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+	e.Class = classifyLineError(e.StatusCode, e.Message, e.Details)
+	return e
+}
+
+func classifyLineError(status int, message string, details []lineErrorDetail) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return lineErrInvalidToken
+	case status == http.StatusTooManyRequests:
+		return lineErrRateLimited
+	case status >= 500:
+		return lineErrServer
+	}
+	// 宛先の指定が通らない (友だちでない・退会済み) と "to" の不備か "Failed to send messages" になる
+	if strings.Contains(message, "Failed to send messages") {
+		return lineErrBlockedUser
+	}
+	for _, d := range details {
+		if d.Property == "to" {
+			return lineErrBlockedUser
+		}
+	}
+	return lineErrMalformed
+}
+
+// alertLineError は管理者の対応が必要なエラーを Sentry (SENTRY_DSN) に送る
+func alertLineError(job NotificationJob, e *lineAPIError) {
+	log.Printf("[ERROR] LINE rejected job %s (%s) with %s: %s", job.JobID, job.Kind, e.Class, e.Body)
+	captureError(errorReport{
+		Message: fmt.Sprintf("LINE %s for notification job %s (%s): %d %s", e.Class, job.JobID, job.Kind, e.StatusCode, e.Message),
+		Level:   "error",
+		Route:   "notification_jobs",
+		UserID:  job.UserID,
+	})
+}
@@ -0,0 +1,180 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	loadTestUserPrefix  = "loadtest-"
+	loadTestInsertBatch = 500
+	loadTestMaxUsers    = 10000
+	loadTestMaxBooks    = 500
+)
+
+// loadTestStatuses は生成する本のステータスと重み。実データに近づけて積読を多めにする。
+var loadTestStatuses = []struct {
+	status string
+	weight int
+}{
+	{"unread", 45}, {"insulted", 15}, {"reading", 15}, {"completed", 15}, {"wishlist", 7}, {statusAbandoned, 3},
+}
+
+// loadTestEnabled は LOADTEST_MODE=true のときだけ合成データの生成を許す (本番で誤って叩かないように)
+func loadTestEnabled() bool {
+	return os.Getenv("LOADTEST_MODE") == "true"
+}
+
+func randomLoadTestStatus(rng *rand.Rand) string {
+	total := 0
+	for _, s := range loadTestStatuses {
+		total += s.weight
+	}
+	n := rng.Intn(total)
+	for _, s := range loadTestStatuses {
+		if n < s.weight {
+			return s.status
+		}
+		n -= s.weight
+	}
+	return "unread"
+}
+
+// generateSyntheticData は users 人のユーザーにそれぞれ booksPerUser 冊の本を作る。
+// 期限は前後60日に散らし、cron で督促対象になる期限切れも混ざるようにする。
+// LINE ID はダミーなので、送信されないようブロック済みにしておく。
+func generateSyntheticData(users, booksPerUser int) (int, error) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	run := time.Now().Format("20060102150405")
+	now := time.Now()
+
+	userRows := make([]map[string]interface{}, 0, users)
+	for i := 0; i < users; i++ {
+		userRows = append(userRows, map[string]interface{}{
+			"line_user_id":    fmt.Sprintf("%s%s-%d", loadTestUserPrefix, run, i),
+			"display_name":    fmt.Sprintf("%s%d", loadTestUserPrefix, i),
+			"line_blocked_at": now,
+		})
+	}
+	var userIDs []string
+	for start := 0; start < len(userRows); start += loadTestInsertBatch {
+		end := min(start+loadTestInsertBatch, len(userRows))
+		resp, _, err := executeOnce(supabaseClient.From("users").Insert(userRows[start:end], false, "", "", ""))
+		if err != nil {
+			return 0, fmt.Errorf("insert users: %w", err)
+		}
+		var created []struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal(resp, &created)
+		for _, u := range created {
+			userIDs = append(userIDs, u.ID)
+		}
+	}
+
+	var batch []map[string]interface{}
+	books := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, _, err := executeOnce(supabaseClient.From("books").Insert(batch, false, "", "minimal", "")); err != nil {
+			return fmt.Errorf("insert books: %w", err)
+		}
+		books += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	for _, userID := range userIDs {
+		for j := 0; j < booksPerUser; j++ {
+			batch = append(batch, map[string]interface{}{
+				"user_id":      userID,
+				"title":        fmt.Sprintf("合成データの本 %d", j+1),
+				"author":       fmt.Sprintf("著者 %d", rng.Intn(200)),
+				"status":       randomLoadTestStatus(rng),
+				"deadline":     now.AddDate(0, 0, rng.Intn(121)-60),
+				"insult_level": 1 + rng.Intn(insultLevelMax),
+				"sort_order":   j,
+			})
+			if len(batch) >= loadTestInsertBatch {
+				if err := flush(); err != nil {
+					return books, err
+				}
+			}
+		}
+	}
+	return books, flush()
+}
+
+// deleteSyntheticData は合成したユーザーを削除する (本などは ON DELETE CASCADE で消える)
+func deleteSyntheticData() (int, error) {
+	resp, _, err := execute(supabaseClient.From("users").Delete("", "").Like("line_user_id", loadTestUserPrefix+"*"))
+	if err != nil {
+		return 0, err
+	}
+	var deleted []struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(resp, &deleted)
+	for _, u := range deleted {
+		invalidateBooks(u.ID)
+	}
+	return len(deleted), nil
+}
+
+// handleLoadTestData は /api/admin/loadtest/data。POST {users, books_per_user} で合成データを作り、DELETE で消す。
+// 管理者トークンに加えて LOADTEST_MODE=true が必要。
+func handleLoadTestData(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !loadTestEnabled() {
+		http.Error(w, "load test mode is disabled (set LOADTEST_MODE=true)", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			Users        int `json:"users"`
+			BooksPerUser int `json:"books_per_user"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if req.Users < 1 || req.Users > loadTestMaxUsers || req.BooksPerUser < 0 || req.BooksPerUser > loadTestMaxBooks {
+			http.Error(w, fmt.Sprintf("users must be 1-%d and books_per_user 0-%d", loadTestMaxUsers, loadTestMaxBooks), http.StatusBadRequest)
+			return
+		}
+		started := time.Now()
+		books, err := generateSyntheticData(req.Users, req.BooksPerUser)
+		if err != nil {
+			log.Printf("[ERROR] handleLoadTestData generate error after %d books: %v", books, err)
+			http.Error(w, fmt.Sprintf("failed after %d books: %v", books, err), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[INFO] generated %d synthetic users with %d books in %s", req.Users, books, time.Since(started))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"users": req.Users, "books": books, "elapsed_ms": time.Since(started).Milliseconds()})
+
+	case http.MethodDelete:
+		n, err := deleteSyntheticData()
+		if err != nil {
+			log.Printf("[ERROR] handleLoadTestData delete error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to delete synthetic data: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": "Synthetic data deleted", "users": n})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,156 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	maxLocationLength = 100
+	whereMaxResults   = 3
+)
+
+// 本の置き場所は「部屋/棚/箱」のように / 区切りで持つ。
+// 絞り込みは階層の前方一致で、「書斎」で「書斎/本棚2」も拾う。
+
+// normalizeLocation は区切りの全角スラッシュや余分な空白を揃える
+func normalizeLocation(s string) string {
+	var parts []string
+	for _, p := range strings.FieldsFunc(s, func(r rune) bool { return r == '/' || r == '／' }) {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	loc := strings.Join(parts, "/")
+	if runes := []rune(loc); len(runes) > maxLocationLength {
+		loc = string(runes[:maxLocationLength])
+	}
+	return loc
+}
+
+// locationMatches は location が filter と同じ場所か、その中の場所か
+func locationMatches(location *string, filter string) bool {
+	if location == nil {
+		return false
+	}
+	loc, f := normalizeSearchText(*location), normalizeSearchText(normalizeLocation(filter))
+	return loc == f || strings.HasPrefix(loc, f+"/")
+}
+
+// filterByLocation は filter の場所 (とその中) にある本だけを返す
+func filterByLocation(books []Book, filter string) []Book {
+	filtered := []Book{}
+	for _, b := range books {
+		if locationMatches(b.Location, filter) {
+			filtered = append(filtered, b)
+		}
+	}
+	return filtered
+}
+
+// LocationCount は置き場所ごとの冊数 (絞り込みの候補)
+type LocationCount struct {
+	Location string `json:"location"`
+	Count    int    `json:"count"`
+}
+
+// handleLocations は GET /api/books/locations?userId=...。使われている置き場所を冊数の多い順に返す。
+func handleLocations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+	books, _, err := loadUserBooks(userId)
+	if err != nil {
+		log.Printf("[ERROR] handleLocations error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	counts := make(map[string]int)
+	for _, b := range excludeArchived(books) {
+		if b.Location != nil && *b.Location != "" {
+			counts[*b.Location]++
+		}
+	}
+	locations := make([]LocationCount, 0, len(counts))
+	for loc, n := range counts {
+		locations = append(locations, LocationCount{Location: loc, Count: n})
+	}
+	slices.SortFunc(locations, func(a, b LocationCount) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return strings.Compare(a.Location, b.Location)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(locations)
+}
+
+// parseWhereQuery は「〇〇 どこ」「〇〇はどこ？」「どこ 〇〇」から書名を取り出す
+func parseWhereQuery(text string) (string, bool) {
+	text = strings.TrimSpace(strings.ReplaceAll(text, "　", " "))
+	text = strings.TrimRight(text, "?？")
+	if q, ok := strings.CutPrefix(text, "どこ"); ok {
+		return strings.TrimSpace(q), strings.TrimSpace(q) != ""
+	}
+	q, ok := strings.CutSuffix(text, "どこ")
+	if !ok {
+		return "", false
+	}
+	q = strings.TrimSpace(q)
+	for _, particle := range []string{"は", "って"} {
+		q = strings.TrimSuffix(q, particle)
+	}
+	q = strings.TrimSpace(q)
+	return q, q != ""
+}
+
+// whereIsReply は LINE の「どこ」コマンドへの返信文を作る
+func whereIsReply(lineUserID, query string) string {
+	resp, _, err := execute(supabaseClient.From("users").Select("id", "", false).Eq("line_user_id", lineUserID))
+	if err != nil {
+		log.Printf("[ERROR] where-is user lookup error: %v", err)
+		return "エラーが発生しました。時間をおいてもう一度送ってください。"
+	}
+	var users []struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(resp, &users); len(users) == 0 {
+		return "まだ積読キラーに登録されていないようです。先にメニューからアカウントを連携してください。"
+	}
+	books, _, err := loadUserBooks(users[0].ID)
+	if err != nil {
+		log.Printf("[ERROR] where-is books lookup error: %v", err)
+		return "エラーが発生しました。時間をおいてもう一度送ってください。"
+	}
+	results := searchBooks(books, query)
+	if len(results) == 0 {
+		return fmt.Sprintf("「%s」に当てはまる本は登録されていません。", query)
+	}
+	var b strings.Builder
+	for i, res := range results {
+		if i == whereMaxResults {
+			fmt.Fprintf(&b, "\nほか%d冊", len(results)-i)
+			break
+		}
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if res.Book.Location != nil && *res.Book.Location != "" {
+			fmt.Fprintf(&b, "『%s』は %s にあります", res.Book.Title, *res.Book.Location)
+		} else {
+			fmt.Fprintf(&b, "『%s』は置き場所が登録されていません", res.Book.Title)
+		}
+	}
+	return b.String()
+}
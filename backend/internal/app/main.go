@@ -0,0 +1,1133 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+var supabaseClient *postgrest.Client
+
+type LineAuthRequest struct {
+	LineAccessToken string `json:"lineAccessToken"`
+	LineUserID      string `json:"lineUserID"`
+}
+
+// Book は書籍データを表す構造体 (Supabase/PostgreSQL用)
+type Book struct {
+	BookID          string     `json:"book_id" db:"book_id"`
+	UserID          string     `json:"user_id" db:"user_id"`
+	Title           string     `json:"title" db:"title"`
+	Author          string     `json:"author" db:"author"`
+	TitleYomi       *string    `json:"title_yomi" db:"title_yomi"`   // 書名の読み (ひらがな)。あいうえお順の並べ替えと検索に使う
+	AuthorYomi      *string    `json:"author_yomi" db:"author_yomi"` // 著者名の読み
+	Deadline        time.Time  `json:"deadline" db:"deadline"`
+	Status          string     `json:"status" db:"status"`
+	InsultLevel     int        `json:"insult_level" db:"insult_level"`
+	InsultTone      *string    `json:"insult_tone" db:"insult_tone"` // 未設定ならユーザーのトーン
+	Rating          *int       `json:"rating" db:"rating"`
+	Review          *string    `json:"review" db:"review"`
+	SortOrder       *int       `json:"sort_order" db:"sort_order"`
+	Format          string     `json:"format" db:"format"` // paperback, hardcover, ebook, audiobook
+	PageCount       *int       `json:"page_count" db:"page_count"`
+	DurationMinutes *int       `json:"duration_minutes" db:"duration_minutes"` // オーディオブックの再生時間
+	Progress        int        `json:"progress" db:"progress"`                 // 読んだページ数 (オーディオブックは聴いた分数)
+	Series          string     `json:"series" db:"series"`
+	Volume          *int       `json:"volume" db:"volume"`
+	Price           *int       `json:"price" db:"price"`       // 円。督促の {{.MoneyWasted}} に使う
+	Tags            []string   `json:"tags" db:"tags"`         // ジャンルなど。normalizeTags で正規化して保存する
+	Location        *string    `json:"location" db:"location"` // 置き場所 (「部屋/棚/箱」)。normalizeLocation で正規化して保存する
+	AbandonReason   *string    `json:"abandon_reason" db:"abandon_reason"`
+	AbandonedAt     *time.Time `json:"abandoned_at" db:"abandoned_at"`
+	ReadCycle       int        `json:"read_cycle" db:"read_cycle"` // 何回目の読書か (再読で増える)
+	ReadStartedAt   *time.Time `json:"read_started_at" db:"read_started_at"`
+	Archived        bool       `json:"archived" db:"archived"` // 一覧・統計・督促から外す (archive.go)
+	ArchivedAt      *time.Time `json:"archived_at" db:"archived_at"`
+	MutedAt         *time.Time `json:"muted_at" db:"muted_at"`         // 督促を一時停止した日時 (mute.go)
+	MutedUntil      *time.Time `json:"muted_until" db:"muted_until"`   // 未設定なら unmute するまで止める
+	StartedAt       *time.Time `json:"started_at" db:"started_at"`     // 今回の回で読書中にした日時 (DB のトリガーが記録する)
+	CompletedAt     *time.Time `json:"completed_at" db:"completed_at"` // 読了した日時 (読了中のみ)
+	NotionPageID    *string    `json:"notion_page_id" db:"notion_page_id"`
+	AvailableAt     *time.Time `json:"available_at" db:"available_at"`         // 入荷待ちの本が届く予定日 (waiting.go)
+	WaitingFor      *string    `json:"waiting_for" db:"waiting_for"`           // 入荷待ちの理由 (library, preorder)
+	ProjectID       *string    `json:"project_id" db:"project_id"`             // 最終期限を共有するプロジェクト (projects.go)
+	NotifyChannel   *string    `json:"notify_channel" db:"notify_channel"`     // 督促の送り先 (line, email)。未設定ならユーザーの設定 (notifychannel.go)
+	ReminderCadence *string    `json:"reminder_cadence" db:"reminder_cadence"` // 期限切れ後の督促の間隔。未設定ならユーザーの設定 (cadence.go)
+	ExtensionCount  int        `json:"extension_count" db:"extension_count"`   // 期限を延長した回数 (extension.go)
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// Init は環境変数から Supabase クライアント・直接接続・キャッシュなどを用意する。サーバーと tundokuctl の両方が最初に呼ぶ。
+func Init() {
+	initSecrets()
+	initFieldEncryption()
+	initConfigProfile()
+	initClock()
+
+	// Supabase クライアントの初期化
+	supabaseURL := os.Getenv("SUPABASE_URL")
+	supabaseKey := os.Getenv("SUPABASE_SERVICE_ROLE_KEY")
+
+	if supabaseURL == "" || supabaseKey == "" {
+		log.Fatalf("SUPABASE_URL and SUPABASE_SERVICE_ROLE_KEY environment variables must be set")
+	}
+
+	var err error
+	supabaseClient, err = newRESTClient(map[string]string{"apikey": supabaseKey, "Authorization": "Bearer " + supabaseKey})
+	if err != nil {
+		log.Fatalf("cannot initialize supabase client: %v", err)
+	}
+
+	initDirectDB()
+	initCache()
+	initDomainConsumers()
+}
+
+// Serve はワーカーと cron を起動して API サーバーを動かす。site は同梱したフロントエンド (なければ nil)。
+func Serve(site http.HandlerFunc) {
+	startNotificationWorkers()
+	startNotionPushWorker()
+	startAPIUsageFlusher()
+	startBookChangeFeed()
+	startCronScheduler()
+	startGRPCServer()
+
+	if site != nil {
+		http.HandleFunc("/", site)
+		log.Printf("[INFO] serving embedded frontend")
+	} else {
+		http.HandleFunc("/", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, "Hello from Backend (Supabase Edition)!")
+		}))
+	}
+
+	http.HandleFunc("/health", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "OK")
+	}))
+
+	http.HandleFunc("/readyz", corsMiddleware(handleReady))
+
+	http.HandleFunc("/api/auth/line", corsMiddleware(handleLineAuth))
+	http.HandleFunc("/api/auth/liff", corsMiddleware(handleLiffAuth))
+	http.HandleFunc("/api/auth/supabase", corsMiddleware(handleSupabaseAuth))
+	http.HandleFunc("/api/line/webhook", handleLineWebhook)
+	http.HandleFunc("/api/line/webhook/{channel}", handleLineWebhook)
+	http.HandleFunc("/api/users/me/sessions", corsMiddleware(handleMySessions))
+	http.HandleFunc("/api/users/me/sessions/{id}", corsMiddleware(handleMySession))
+	http.HandleFunc("/api/users/me/relink", corsMiddleware(handleRelinkLine))
+	http.HandleFunc("/api/users/me/merge", corsMiddleware(handleMergeMyAccount))
+	http.HandleFunc("/api/users/me/data-request", corsMiddleware(handleDataRequest))
+	http.HandleFunc("/api/users/me/data-request/{id}", corsMiddleware(handleDataRequestStatus))
+	http.HandleFunc("/api/users/me/backup", corsMiddleware(handleBackup))
+	http.HandleFunc("/api/users/me/restore", corsMiddleware(handleRestore))
+	http.HandleFunc("/api/users/me/timeline", corsMiddleware(handleTimeline))
+	http.HandleFunc("/api/users/me/preferences", corsMiddleware(handleMyPreferences))
+	http.HandleFunc("/api/onboarding", corsMiddleware(handleOnboarding))
+	http.HandleFunc("/api/users/me/tokens", corsMiddleware(handleMyTokens))
+	http.HandleFunc("/api/users/me/tokens/{id}", corsMiddleware(handleMyToken))
+	http.HandleFunc("/api/public/books", corsMiddleware(requireAccessToken(scopeReadBooks, handlePublicBooks)))
+	http.HandleFunc("/api/public/stats", corsMiddleware(requireAccessToken(scopeReadStats, handlePublicStats)))
+	http.HandleFunc("/api/public/triggers/{trigger}", corsMiddleware(handleTrigger))
+	http.HandleFunc("/ifttt/v1/triggers/{trigger}", handleTrigger)
+	http.HandleFunc("/ifttt/v1/status", handleIFTTTStatus)
+	http.HandleFunc("/ifttt/v1/user/info", handleIFTTTUserInfo)
+	http.HandleFunc("/ifttt/v1/test/setup", handleIFTTTTestSetup)
+	http.HandleFunc("/api/books", corsMiddleware(withCompression(handleBooks)))
+	http.HandleFunc("/api/books/complete", corsMiddleware(handleCompleteBook))
+	http.HandleFunc("/api/books/{id}", corsMiddleware(handleBookDetail))
+	http.HandleFunc("/api/cron/check", corsMiddleware(handleCheckDeadlines))
+	http.HandleFunc("/api/events", corsMiddleware(handleEvents))
+	http.HandleFunc("/api/books/{id}/sessions", corsMiddleware(handleListSessions))
+	http.HandleFunc("/api/books/{id}/sessions/start", corsMiddleware(handleStartSession))
+	http.HandleFunc("/api/books/{id}/sessions/stop", corsMiddleware(handleStopSession))
+	http.HandleFunc("/api/stats", corsMiddleware(withCompression(handleStats)))
+	http.HandleFunc("/api/books/{id}/notes", corsMiddleware(handleNotes))
+	http.HandleFunc("/api/books/{id}/notes/{noteId}", corsMiddleware(handleNote))
+	http.HandleFunc("/api/projects", corsMiddleware(handleProjects))
+	http.HandleFunc("/api/projects/{id}", corsMiddleware(handleProject))
+	http.HandleFunc("/api/books/{id}/attachments", corsMiddleware(handleAttachments))
+	http.HandleFunc("/api/books/{id}/attachments/{attachmentId}", corsMiddleware(handleAttachment))
+	http.HandleFunc("/api/export", corsMiddleware(withCompression(handleExport)))
+	http.HandleFunc("/api/books/{id}/review", corsMiddleware(handleReview))
+	http.HandleFunc("/api/books/reorder", corsMiddleware(handleReorderBooks))
+	http.HandleFunc("/api/books/{id}/progress", corsMiddleware(handleProgress))
+	http.HandleFunc("/api/cron/digest", corsMiddleware(handleWeeklyDigest))
+	http.HandleFunc("/api/series", corsMiddleware(withCompression(handleListSeries)))
+	http.HandleFunc("/api/books/{id}/purchase", corsMiddleware(handlePurchase))
+	http.HandleFunc("/api/books/{id}/arrived", corsMiddleware(handleArrived))
+	http.HandleFunc("/api/books/suggest-deadline", corsMiddleware(handleSuggestDeadline))
+	http.HandleFunc("/api/books/overdue-summary", corsMiddleware(handleOverdueSummary))
+	http.HandleFunc("/api/books/recommend-next", corsMiddleware(handleRecommendNext))
+	http.HandleFunc("/api/books/plan.pdf", corsMiddleware(handleReadingPlanPDF))
+	http.HandleFunc("/api/books/triage", corsMiddleware(handleBookTriage))
+	http.HandleFunc("/api/plan/capacity", corsMiddleware(handleReadingCapacity))
+	http.HandleFunc("/api/books/archive-suggestions", corsMiddleware(handleArchiveSuggestions))
+	http.HandleFunc("/api/books/archived", corsMiddleware(handleArchivedBooks))
+	http.HandleFunc("/api/books/locations", corsMiddleware(withCompression(handleLocations)))
+	http.HandleFunc("/api/books/{id}/archive", corsMiddleware(handleArchive))
+	http.HandleFunc("/api/books/{id}/mute", corsMiddleware(handleMute))
+	http.HandleFunc("/api/books/{id}/unmute", corsMiddleware(handleMute))
+	http.HandleFunc("/api/books/{id}/extend", corsMiddleware(handleExtendDeadline))
+	http.HandleFunc("/api/books/{id}/milestones", corsMiddleware(handleMilestones))
+	http.HandleFunc("/api/books/{id}/milestones/{milestoneId}", corsMiddleware(handleMilestone))
+	http.HandleFunc("/api/books/{id}/abandon", corsMiddleware(handleAbandon))
+	http.HandleFunc("/api/books/{id}/revive", corsMiddleware(handleRevive))
+	http.HandleFunc("/api/books/{id}/reread", corsMiddleware(handleReread))
+	http.HandleFunc("/api/books/{id}/reads", corsMiddleware(handleListReads))
+	http.HandleFunc("/api/books/status", corsMiddleware(handleBulkStatus))
+	http.HandleFunc("/api/books/shift-deadlines", corsMiddleware(handleShiftDeadlines))
+	http.HandleFunc("/api/actions/{id}/undo", corsMiddleware(handleUndoAction))
+	http.HandleFunc("/api/books/search", corsMiddleware(withCompression(handleSearchBooks)))
+	http.HandleFunc("/api/books/autocomplete", corsMiddleware(handleAutocomplete))
+	http.HandleFunc("/api/books/scan", corsMiddleware(handleScanBook))
+	http.HandleFunc("/api/import/{provider}", corsMiddleware(handleImport))
+	http.HandleFunc("/api/import/amazon", corsMiddleware(handleAmazonImport))
+	http.HandleFunc("/api/import/drafts", corsMiddleware(handleImportDrafts))
+	http.HandleFunc("/api/import/drafts/{id}", corsMiddleware(handleImportDraft))
+	http.HandleFunc("/api/admin/catalog/{isbn}", corsMiddleware(requireRole(roleAdmin, handleCatalogEntry)))
+	http.HandleFunc("/api/integrations/notion", corsMiddleware(handleNotionConnection))
+	http.HandleFunc("/api/integrations/notion/sync", corsMiddleware(handleNotionSync))
+	http.HandleFunc("/api/cron/notion-sync", corsMiddleware(handleNotionSyncCron))
+	http.HandleFunc("/api/feeds/token", corsMiddleware(handleFeedToken))
+	http.HandleFunc("/api/feeds/{token}/completed.xml", corsMiddleware(handleCompletedFeed))
+	http.HandleFunc("/api/feeds/{token}/tasks.ics", corsMiddleware(handleTaskFeed))
+	http.HandleFunc("/api/books/tasks/import", corsMiddleware(handleTaskImport))
+	http.HandleFunc("/api/profile/public", corsMiddleware(handlePublicProfileSettings))
+	http.HandleFunc("/api/public/users/{slug}", corsMiddleware(handlePublicProfile))
+	http.HandleFunc("/api/stats/card.png", corsMiddleware(handleStatsCard))
+	http.HandleFunc("/api/books/{id}/certificate.png", corsMiddleware(handleCertificate))
+	http.HandleFunc("/api/stats/heatmap", corsMiddleware(withCompression(handleHeatmap)))
+	http.HandleFunc("/api/stats/genres", corsMiddleware(withCompression(handleGenreStats)))
+	http.HandleFunc("/api/stats/insights", corsMiddleware(handleInsights))
+	http.HandleFunc("/api/graphql", corsMiddleware(withCompression(handleGraphQL)))
+	http.HandleFunc("/api/admin/insults/effectiveness", corsMiddleware(requireRole(roleAdmin, handleInsultEffectiveness)))
+	http.HandleFunc("/api/insults/preview", corsMiddleware(handleInsultPreview))
+	http.HandleFunc("/api/insults/tones", corsMiddleware(handleInsultTones))
+	http.HandleFunc("/api/insults/custom", corsMiddleware(handleCustomInsults))
+	http.HandleFunc("/api/insults/custom/{id}", corsMiddleware(handleCustomInsult))
+	http.HandleFunc("/api/admin/insults/custom", corsMiddleware(requireRole(roleModerator, handleAdminCustomInsults)))
+	http.HandleFunc("/api/admin/insults/custom/{id}", corsMiddleware(requireRole(roleModerator, handleAdminCustomInsult)))
+	http.HandleFunc("/api/admin/richmenus", corsMiddleware(requireRole(roleAdmin, handleRichMenus)))
+	http.HandleFunc("/api/admin/line/quota", corsMiddleware(requireRole(roleAdmin, handleLineQuota)))
+	http.HandleFunc("/api/admin/broadcast", corsMiddleware(requireRole(roleAdmin, handleBroadcast)))
+	http.HandleFunc("/api/admin/secrets/reload", corsMiddleware(requireRole(roleAdmin, handleReloadSecrets)))
+	http.HandleFunc("/api/admin/loadtest/data", corsMiddleware(requireRole(roleAdmin, handleLoadTestData)))
+	http.HandleFunc("/api/admin/usage", corsMiddleware(requireRole(roleAdmin, handleAPIUsage)))
+	http.HandleFunc("/api/admin/flags", corsMiddleware(requireRole(roleAdmin, handleFlags)))
+	http.HandleFunc("/api/admin/flags/{key}", corsMiddleware(requireRole(roleAdmin, handleFlag)))
+	http.HandleFunc("/api/admin/users/{id}/line-channel", corsMiddleware(requireRole(roleAdmin, handleUserLineChannel)))
+	http.HandleFunc("/api/users/announcements", corsMiddleware(handleAnnouncementsOptIn))
+	http.HandleFunc("/api/users/group-shame", corsMiddleware(handleGroupShame))
+	http.HandleFunc("/api/admin/richmenus/sync", corsMiddleware(requireRole(roleAdmin, handleRichMenuSync)))
+	http.HandleFunc("/api/admin/richmenus/{id}", corsMiddleware(requireRole(roleAdmin, handleRichMenu)))
+	http.HandleFunc("/api/admin/richmenus/{id}/image", corsMiddleware(requireRole(roleAdmin, handleRichMenuImage)))
+	http.HandleFunc("/api/cron/monthly-report", corsMiddleware(handleMonthlyReport))
+	http.HandleFunc("/api/challenges", corsMiddleware(handleChallenges))
+	http.HandleFunc("/api/challenges/{id}/join", corsMiddleware(handleChallengeJoin))
+	http.HandleFunc("/api/challenges/{id}/leaderboard", corsMiddleware(handleChallengeLeaderboard))
+	http.HandleFunc("/api/cron/challenges", corsMiddleware(handleFinalizeChallenges))
+	http.HandleFunc("/api/books/{id}/stake", corsMiddleware(handleStake))
+	http.HandleFunc("/api/books/{id}/buddy", corsMiddleware(handleReadingBuddy))
+	http.HandleFunc("/api/notifications", corsMiddleware(handleNotifications))
+	http.HandleFunc("/api/admin/workspaces", corsMiddleware(requireRole(roleAdmin, handleAdminWorkspaces)))
+	http.HandleFunc("/api/admin/roles", corsMiddleware(requireRole(roleAdmin, handleRoles)))
+	http.HandleFunc("/api/admin/users/{id}/role", corsMiddleware(requireRole(roleAdmin, handleUserRole)))
+	http.HandleFunc("/api/admin/users/merge", corsMiddleware(requireRole(roleAdmin, handleAdminMergeUsers)))
+	http.HandleFunc("/api/admin/cron/metrics", corsMiddleware(requireRole(roleAdmin, handleCronMetrics)))
+	http.HandleFunc("/api/admin/latency", corsMiddleware(requireRole(roleAdmin, handleLatencyMetrics)))
+	http.HandleFunc("/api/admin/alerts", corsMiddleware(requireRole(roleAdmin, handleAlerts)))
+	http.HandleFunc("/api/admin/backfill", corsMiddleware(requireRole(roleAdmin, handleBackfill)))
+	http.HandleFunc("/api/admin/health", corsMiddleware(requireRole(roleAdmin, handleAdminHealth)))
+	http.HandleFunc("/api/admin/sandbox/inbox", corsMiddleware(requireRole(roleAdmin, handleSandboxInbox)))
+	http.HandleFunc("/api/admin/clock", corsMiddleware(requireRole(roleAdmin, handleAdminClock)))
+	http.HandleFunc("/api/admin/announcements", corsMiddleware(requireRole(roleAdmin, handleAdminAnnouncements)))
+	http.HandleFunc("/api/admin/announcements/{id}", corsMiddleware(requireRole(roleAdmin, handleAdminAnnouncement)))
+	http.HandleFunc("/api/announcements", corsMiddleware(handleAnnouncements))
+	http.HandleFunc("/api/achievements", corsMiddleware(handleAchievementDefinitions))
+	http.HandleFunc("/api/admin/encryption/rotate", corsMiddleware(requireRole(roleAdmin, handleRotateEncryption)))
+	http.HandleFunc("/api/admin/webhook-events", corsMiddleware(requireRole(roleAdmin, handleAdminWebhookEvents)))
+	http.HandleFunc("/api/admin/webhook-events/{id}", corsMiddleware(requireRole(roleAdmin, handleAdminWebhookEvent)))
+	http.HandleFunc("/api/admin/webhook-events/{id}/replay", corsMiddleware(requireRole(roleAdmin, handleAdminWebhookEventReplay)))
+	http.HandleFunc("/api/admin/users/{id}/books", corsMiddleware(requireRole(roleAdmin, requireSupportAccess("books", handleSupportBooks))))
+	http.HandleFunc("/api/admin/users/{id}/notifications", corsMiddleware(requireRole(roleAdmin, requireSupportAccess("notifications", handleSupportNotifications))))
+	http.HandleFunc("/api/admin/users/{id}/settings", corsMiddleware(requireRole(roleAdmin, requireSupportAccess("settings", handleSupportSettings))))
+	http.HandleFunc("/api/admin/support-access", corsMiddleware(requireRole(roleAdmin, handleSupportAccessLog)))
+	http.HandleFunc("/api/workspaces/me", corsMiddleware(handleMyWorkspace))
+	http.HandleFunc("/api/workspaces/join", corsMiddleware(handleJoinWorkspace))
+	http.HandleFunc("/api/workspaces/{id}/settings", corsMiddleware(handleWorkspaceSettings))
+	http.HandleFunc("/api/workspaces/{id}/members", corsMiddleware(handleWorkspaceMembers))
+	http.HandleFunc("/api/workspaces/{id}/members/{userId}", corsMiddleware(handleWorkspaceMember))
+	http.HandleFunc("/api/workspaces/{id}/insults", corsMiddleware(handleWorkspaceInsults))
+	http.HandleFunc("/api/workspaces/{id}/insults/{insultId}", corsMiddleware(handleWorkspaceInsult))
+	http.HandleFunc("/api/workspaces/{id}/books", corsMiddleware(handleWorkspaceBooks))
+	http.HandleFunc("/api/workspaces/{id}/polls", corsMiddleware(handleBookClubPolls))
+	http.HandleFunc("/api/workspaces/{id}/polls/{pollId}", corsMiddleware(handleBookClubPoll))
+	http.HandleFunc("/api/workspaces/{id}/polls/{pollId}/candidates", corsMiddleware(handleBookClubCandidates))
+	http.HandleFunc("/api/workspaces/{id}/polls/{pollId}/vote", corsMiddleware(handleBookClubVote))
+	http.HandleFunc("/api/cron/book-club", corsMiddleware(handleCloseBookClubPolls))
+	http.HandleFunc("/api/cron/retention", corsMiddleware(handleRetention))
+	http.HandleFunc("/api/notifications/test", corsMiddleware(handleTestNotification))
+	http.HandleFunc("/api/users/notify-channel", corsMiddleware(handleNotifySettings))
+	http.HandleFunc("/api/users/focus", corsMiddleware(handleFocusSettings))
+	http.HandleFunc("/api/focus/start", corsMiddleware(handleFocusStart))
+	http.HandleFunc("/api/users/inbound-email", corsMiddleware(handleInboundEmailAddress))
+	http.HandleFunc("/api/inbound/email", corsMiddleware(handleInboundEmail))
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8081"
+	}
+
+	fmt.Printf("Server starting on port %s...\n", port)
+	log.Fatal(newServer(":" + port).ListenAndServe())
+}
+
+func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return withErrorReporting(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("[INFO] %s %s", r.Method, r.URL.Path)
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, PATCH, DELETE")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, If-None-Match, If-Modified-Since")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Request-Id")
+
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if !rlsRouteAllowed(r.Pattern) {
+			http.Error(w, "Not available in RLS mode", http.StatusForbidden)
+			return
+		}
+		if !limitRequestBody(w, r) {
+			return
+		}
+		recordAPIUsage(r)
+
+		withLatencyBudget(w, r, next)
+	})
+}
+
+func handleLineAuth(w http.ResponseWriter, r *http.Request) {
+	var req LineAuthRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	internalID, err := userIDForLine(req.LineUserID)
+	if err != nil {
+		log.Printf("[ERROR] handleLineAuth error: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeAuthResponse(w, internalID)
+}
+
+// userIDForLine は LINE のユーザーIDに対応する内部IDを返す。初めてのユーザーなら作成する。
+func userIDForLine(lineUserID string) (string, error) {
+	if cached, ok := appCache.Get(userCacheKey(lineUserID)); ok {
+		return string(cached), nil
+	}
+
+	resp, _, err := execute(supabaseClient.From("users").Select("*", "exact", false).Eq("line_user_id", lineUserID))
+	if err != nil {
+		return "", fmt.Errorf("failed to query user: %v", err)
+	}
+
+	var results []map[string]interface{}
+	json.Unmarshal(resp, &results)
+	log.Printf("[DEBUG] Results from users table: %+v", results)
+
+	var internalID string
+	if len(results) == 0 {
+		newUser := map[string]interface{}{
+			"line_user_id": lineUserID,
+			"display_name": "LINE User",
+		}
+		log.Printf("[DEBUG] Creating new user: %+v", newUser)
+		insertResp, _, err := execute(supabaseClient.From("users").Insert(newUser, false, "", "", ""))
+		if err != nil {
+			return "", fmt.Errorf("failed to create user: %v", err)
+		}
+		var insertResults []map[string]interface{}
+		json.Unmarshal(insertResp, &insertResults)
+		log.Printf("[DEBUG] Insert results: %+v", insertResults)
+		// 連携したユーザーには連携後のメニューを出す
+		go linkUserRichMenu(lineUserID)
+		if len(insertResults) > 0 {
+			internalID = insertResults[0]["id"].(string)
+		} else {
+			fResp, _, _ := execute(supabaseClient.From("users").Select("id", "exact", false).Eq("line_user_id", lineUserID))
+			var fResults []map[string]interface{}
+			json.Unmarshal(fResp, &fResults)
+			log.Printf("[DEBUG] Fallback fetch results: %+v", fResults)
+			if len(fResults) > 0 {
+				internalID = fResults[0]["id"].(string)
+			}
+		}
+	} else {
+		internalID = results[0]["id"].(string)
+	}
+
+	log.Printf("[DEBUG] userIDForLine returning internalID: %s for lineUserID: %s", internalID, lineUserID)
+	if internalID != "" {
+		appCache.Set(userCacheKey(lineUserID), []byte(internalID), userCacheTTL)
+	}
+	return internalID, nil
+}
+
+func writeAuthResponse(w http.ResponseWriter, internalID string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Auth successful",
+		"userId":  internalID,
+	})
+}
+
+func handleBooks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleGetBooks(w, r)
+	case http.MethodPost:
+		handleRegisterBook(w, r)
+	case http.MethodPut:
+		handleUpdateBook(w, r)
+	case http.MethodDelete:
+		handleDeleteBook(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// fetchOwnedBook は userID が所有する書籍を取得する。存在しなければ errBookNotFound。
+func fetchOwnedBook(bookID, userID string) (Book, error) {
+	if bookID == "" || userID == "" {
+		return Book{}, errBookNotFound
+	}
+	resp, _, err := execute(supabaseClient.From("books").Select("*", "", false).Eq("book_id", bookID).Eq("user_id", userID))
+	if err != nil {
+		return Book{}, err
+	}
+	var books []Book
+	if err := json.Unmarshal(openRows("books", resp), &books); err != nil {
+		return Book{}, err
+	}
+	if len(books) == 0 {
+		return Book{}, errBookNotFound
+	}
+	return books[0], nil
+}
+
+// deadlineValue は欲しい本 (wishlist) と入荷待ち (waiting) の期限を NULL として保存する
+func deadlineValue(book Book) interface{} {
+	if deadlineless(book.Status) {
+		return nil
+	}
+	return book.Deadline
+}
+
+// nullIfEmpty は空文字列を NULL として保存するために nil に変換する
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// firstBookRow は書き込みが返した行の先頭。行がなければ nil (JSON では null)。
+func firstBookRow(rawResp []byte) *Book {
+	var books []Book
+	if json.Unmarshal(openRows("books", rawResp), &books); len(books) == 0 {
+		return nil
+	}
+	return &books[0]
+}
+
+func writeBookLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errBookNotFound) {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("[ERROR] book lookup error: %v", err)
+	http.Error(w, fmt.Sprintf("failed to fetch book: %v", err), http.StatusInternalServerError)
+}
+
+func handleGetBooks(w http.ResponseWriter, r *http.Request) {
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+
+	db, err := userDB(r)
+	if err != nil {
+		writeUserDBError(w, err)
+		return
+	}
+	var books []Book
+	var resp []byte
+	if rlsMode() {
+		// 他人のキャッシュを返さないよう、RLS モードでは毎回ユーザーの権限で読む
+		books, resp, err = queryUserBooks(db, userId)
+	} else {
+		books, resp, err = loadUserBooks(userId)
+	}
+	if err != nil {
+		log.Printf("[ERROR] handleGetBooks error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	// アーカイブした本は GET /api/books/archived で返す
+	if visible := excludeArchived(books); len(visible) != len(books) {
+		books = visible
+		resp, _ = json.Marshal(books)
+	}
+	switch tier := r.URL.Query().Get("tier"); tier {
+	case "":
+	case "wishlist", "owned":
+		books = filterByTier(books, tier)
+		resp, _ = json.Marshal(books)
+	default:
+		http.Error(w, "tier must be wishlist or owned", http.StatusBadRequest)
+		return
+	}
+	if location := r.URL.Query().Get("location"); location != "" {
+		books = filterByLocation(books, location)
+		resp, _ = json.Marshal(books)
+	}
+	// 既定は sort_order と期限の順。sort=title / author で読みのあいうえお順にする。
+	switch sortBy := r.URL.Query().Get("sort"); sortBy {
+	case "":
+	case "title", "author":
+		sortBooksByYomi(books, sortBy)
+		resp, _ = json.Marshal(books)
+	default:
+		http.Error(w, "sort must be title or author", http.StatusBadRequest)
+		return
+	}
+	etag := booksETag(books)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+// loadUserBooks はユーザーの本を並び順で返す。生の JSON はキャッシュに載せ、そのまま返せるようにする。
+func loadUserBooks(userID string) ([]Book, []byte, error) {
+	// キャッシュには暗号化したままの行を置き、平文のメモは読むたびに復号する
+	sealed, ok := appCache.Get(booksCacheKey(userID))
+	if !ok {
+		var err error
+		if sealed, err = fetchUserBookRows(supabaseClient, userID); err != nil {
+			return nil, nil, err
+		}
+		appCache.Set(booksCacheKey(userID), sealed, booksCacheTTL)
+	}
+	books, resp := decodeUserBooks(sealed)
+	return books, resp, nil
+}
+
+// queryUserBooks はキャッシュを通さずに db の権限でユーザーの本を読む
+func queryUserBooks(db dbClient, userID string) ([]Book, []byte, error) {
+	sealed, err := fetchUserBookRows(db, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	books, resp := decodeUserBooks(sealed)
+	return books, resp, nil
+}
+
+// fetchUserBookRows はユーザーの本の行を暗号化したまま返す
+func fetchUserBookRows(db dbClient, userID string) ([]byte, error) {
+	resp, _, err := execute(db.From("books").
+		Select("*", "exact", false).
+		Eq("user_id", userID).
+		Order("sort_order", &postgrest.OrderOpts{Ascending: true}).
+		Order("deadline", &postgrest.OrderOpts{Ascending: true}))
+	return resp, err
+}
+
+// decodeUserBooks は fetchUserBookRows の行を復号して Book にする
+func decodeUserBooks(sealed []byte) ([]Book, []byte) {
+	resp := openRows("books", sealed)
+	var books []Book
+	if err := json.Unmarshal(resp, &books); err != nil {
+		log.Printf("[ERROR] loadUserBooks unmarshal error: %v", err)
+	}
+	return books, resp
+}
+
+func handleRegisterBook(w http.ResponseWriter, r *http.Request) {
+	db, err := userDB(r)
+	if err != nil {
+		writeUserDBError(w, err)
+		return
+	}
+	var req struct {
+		Book
+		Deadline string `json:"deadline"` // RFC 3339 のほか 2026-10-20、令和8年10月20日、来週末、+2w なども受け付ける (deadlineinput.go)
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("[ERROR] handleRegisterBook decode error: %v", err)
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	book := req.Book
+
+	log.Printf("[DEBUG] handleRegisterBook received: %+v (deadline %q)", book, req.Deadline)
+
+	book.Title, book.Author = normalizeBookText(book.Title), normalizeBookText(book.Author)
+	if book.Title == "" || book.Author == "" || book.UserID == "" {
+		log.Printf("[ERROR] handleRegisterBook missing fields: title=%s, author=%s, userId=%s", book.Title, book.Author, book.UserID)
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+	if req.Deadline != "" {
+		if book.Deadline, err = parseDeadlineInput(req.Deadline, clock.Now(), userLocation(book.UserID)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if book.Status == "" {
+		book.Status = "unread"
+	}
+	// 期限・insult_level・タグを省いたらユーザーの既定値 (userdefaults.go) を使う
+	if (book.Deadline.IsZero() && book.Status != statusWishlist) || book.InsultLevel == 0 || book.Tags == nil {
+		defaults, err := fetchUserDefaults(book.UserID)
+		if err != nil {
+			log.Printf("[WARNING] handleRegisterBook defaults lookup failed for user %s: %v", book.UserID, err)
+		}
+		defaults.applyTo(&book, clock.Now())
+	}
+	if err := validateFormat(&book); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rejected, deadlineWarnings := rejectInsaneDeadline(w, r, book)
+	if rejected {
+		return
+	}
+	if book.Price != nil && *book.Price < 0 {
+		http.Error(w, "price must not be negative", http.StatusBadRequest)
+		return
+	}
+	var tone interface{}
+	if book.InsultTone != nil {
+		if !validInsultTone(*book.InsultTone) {
+			http.Error(w, "unknown insult_tone", http.StatusBadRequest)
+			return
+		}
+		tone = nullIfEmpty(*book.InsultTone)
+	}
+	if book.NotifyChannel != nil && !validNotifyChannel(*book.NotifyChannel) {
+		http.Error(w, "notify_channel must be line or email", http.StatusBadRequest)
+		return
+	}
+	if book.ReminderCadence != nil && !validReminderCadence(*book.ReminderCadence) {
+		http.Error(w, "unknown reminder_cadence", http.StatusBadRequest)
+		return
+	}
+	if book.WaitingFor != nil && !validWaitingFor(*book.WaitingFor) {
+		http.Error(w, "waiting_for must be library or preorder", http.StatusBadRequest)
+		return
+	}
+	if book.ProjectID != nil {
+		if err := validProjectRef(*book.ProjectID, book.UserID); err != nil {
+			writeProjectLookupError(w, err)
+			return
+		}
+	}
+
+	insertData := map[string]interface{}{
+		"user_id":          book.UserID,
+		"title":            book.Title,
+		"author":           book.Author,
+		"deadline":         deadlineValue(book),
+		"status":           book.Status,
+		"insult_level":     book.InsultLevel,
+		"insult_tone":      tone,
+		"format":           book.Format,
+		"page_count":       book.PageCount,
+		"duration_minutes": book.DurationMinutes,
+		"series":           nullIfEmpty(book.Series),
+		"volume":           book.Volume,
+		"tags":             normalizeTags(book.Tags),
+		"price":            book.Price,
+	}
+	if book.NotifyChannel != nil {
+		insertData["notify_channel"] = nullIfEmpty(*book.NotifyChannel)
+	}
+	if book.ReminderCadence != nil {
+		insertData["reminder_cadence"] = nullIfEmpty(*book.ReminderCadence)
+	}
+	if book.TitleYomi != nil {
+		insertData["title_yomi"] = nullIfEmpty(normalizeYomi(*book.TitleYomi))
+	}
+	if book.AuthorYomi != nil {
+		insertData["author_yomi"] = nullIfEmpty(normalizeYomi(*book.AuthorYomi))
+	}
+	if book.Location != nil {
+		insertData["location"] = nullIfEmpty(normalizeLocation(*book.Location))
+	}
+	if book.ProjectID != nil {
+		insertData["project_id"] = nullIfEmpty(*book.ProjectID)
+	}
+	if book.Status == statusWaiting {
+		insertData["available_at"] = book.AvailableAt
+		if book.WaitingFor != nil {
+			insertData["waiting_for"] = nullIfEmpty(*book.WaitingFor)
+		}
+	}
+
+	warnings := append(bookWarnings(book), deadlineWarnings...)
+	if sizeWarning := librarySizeWarning(book.UserID); sizeWarning != nil && slices.Contains(activeStatuses, book.Status) {
+		warnings = append(warnings, *sizeWarning)
+	}
+	if capWarning := capacityWarning(book); capWarning != nil {
+		warnings = append(warnings, *capWarning)
+	}
+
+	rawResp, _, err := executeOnce(db.From("books").Insert(insertData, false, "", "", ""))
+	if err != nil {
+		log.Printf("[ERROR] handleRegisterBook database error: %v, body: %s", err, string(rawResp))
+		http.Error(w, fmt.Sprintf("failed to register book: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	emitBookRows(eventBookCreated, rawResp)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Book registered successfully", "warnings": warnings, "book": firstBookRow(rawResp)})
+}
+
+func handleUpdateBook(w http.ResponseWriter, r *http.Request) {
+	db, err := userDB(r)
+	if err != nil {
+		writeUserDBError(w, err)
+		return
+	}
+	var book Book
+	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	updateData := map[string]interface{}{
+		"title":        normalizeBookText(book.Title),
+		"author":       normalizeBookText(book.Author),
+		"deadline":     deadlineValue(book),
+		"status":       book.Status,
+		"insult_level": book.InsultLevel,
+		"series":       nullIfEmpty(book.Series),
+		"volume":       book.Volume,
+		"updated_at":   clock.Now(),
+	}
+	if book.Price != nil {
+		if *book.Price < 0 {
+			http.Error(w, "price must not be negative", http.StatusBadRequest)
+			return
+		}
+		updateData["price"] = book.Price
+	}
+	// トーンは送られてきた場合のみ更新する。空文字でユーザーの設定に戻す。
+	if book.InsultTone != nil {
+		if !validInsultTone(*book.InsultTone) {
+			http.Error(w, "unknown insult_tone", http.StatusBadRequest)
+			return
+		}
+		updateData["insult_tone"] = nullIfEmpty(*book.InsultTone)
+	}
+	// 送り先も送られてきた場合のみ更新する。空文字でユーザーの設定に戻す。
+	if book.NotifyChannel != nil {
+		if !validNotifyChannel(*book.NotifyChannel) {
+			http.Error(w, "notify_channel must be line or email", http.StatusBadRequest)
+			return
+		}
+		updateData["notify_channel"] = nullIfEmpty(*book.NotifyChannel)
+	}
+	// 督促の間隔も同様
+	if book.ReminderCadence != nil {
+		if !validReminderCadence(*book.ReminderCadence) {
+			http.Error(w, "unknown reminder_cadence", http.StatusBadRequest)
+			return
+		}
+		updateData["reminder_cadence"] = nullIfEmpty(*book.ReminderCadence)
+	}
+	// 読みも送られてきた場合のみ更新する。空文字で消す。
+	if book.TitleYomi != nil {
+		updateData["title_yomi"] = nullIfEmpty(normalizeYomi(*book.TitleYomi))
+	}
+	if book.AuthorYomi != nil {
+		updateData["author_yomi"] = nullIfEmpty(normalizeYomi(*book.AuthorYomi))
+	}
+	// タグも送られてきた場合のみ更新する。空配列なら全て外す。
+	if book.Tags != nil {
+		updateData["tags"] = normalizeTags(book.Tags)
+	}
+	// 置き場所も同様。空文字で未登録に戻す。
+	if book.Location != nil {
+		updateData["location"] = nullIfEmpty(normalizeLocation(*book.Location))
+	}
+	// 入荷待ちの予定日と理由も送られてきた場合のみ更新する
+	if book.AvailableAt != nil {
+		updateData["available_at"] = book.AvailableAt
+	}
+	if book.WaitingFor != nil {
+		if !validWaitingFor(*book.WaitingFor) {
+			http.Error(w, "waiting_for must be library or preorder", http.StatusBadRequest)
+			return
+		}
+		updateData["waiting_for"] = nullIfEmpty(*book.WaitingFor)
+	}
+	// プロジェクトも同様。空文字で外す。
+	if book.ProjectID != nil {
+		if err := validProjectRef(*book.ProjectID, book.UserID); err != nil {
+			writeProjectLookupError(w, err)
+			return
+		}
+		updateData["project_id"] = nullIfEmpty(*book.ProjectID)
+	}
+	// 形式は指定された場合のみ更新する (既存クライアントは送ってこない)
+	if book.Format != "" {
+		if err := validateFormat(&book); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		updateData["format"] = book.Format
+		updateData["page_count"] = book.PageCount
+		updateData["duration_minutes"] = book.DurationMinutes
+	}
+
+	// 期限を変えていない更新 (期限切れの本のタイトル修正など) では期限を確かめない
+	var deadlineWarnings []Warning
+	if len(deadlineSanityWarnings(book, clock.Now())) > 0 {
+		if current, err := fetchOwnedBook(book.BookID, book.UserID); err != nil || !current.Deadline.Equal(book.Deadline) {
+			var rejected bool
+			if rejected, deadlineWarnings = rejectInsaneDeadline(w, r, book); rejected {
+				return
+			}
+		}
+	}
+	warnings := append(bookWarnings(book), deadlineWarnings...)
+
+	rawResp, _, err := execute(db.From("books").Update(updateData, "", "").Eq("book_id", book.BookID).Eq("user_id", book.UserID))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to update book: %v", err), http.StatusInternalServerError)
+		return
+	}
+	emitBookRows("book.updated", rawResp)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Book updated successfully", "warnings": warnings, "book": firstBookRow(rawResp)})
+}
+
+func handleDeleteBook(w http.ResponseWriter, r *http.Request) {
+	db, err := userDB(r)
+	if err != nil {
+		writeUserDBError(w, err)
+		return
+	}
+	var req struct {
+		BookID string `json:"book_id"`
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("[ERROR] handleDeleteBook decode error: %v", err)
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	log.Printf("[DEBUG] handleDeleteBook received: %+v", req)
+
+	// 行は cascade で消えるので、添付ファイルのパスは先に控えておく
+	attachments, err := bookAttachmentPaths(req.BookID)
+	if err != nil {
+		log.Printf("[WARNING] handleDeleteBook attachment lookup error: %v", err)
+	}
+
+	rawResp, _, err := execute(db.From("books").Delete("", "").Eq("book_id", req.BookID).Eq("user_id", req.UserID))
+	if err != nil {
+		log.Printf("[ERROR] handleDeleteBook database error: %v, body: %s", err, string(rawResp))
+		http.Error(w, fmt.Sprintf("failed to delete book: %v", err), http.StatusInternalServerError)
+		return
+	}
+	deleted := BookEvent{Type: "book.deleted", BookID: req.BookID, UserID: req.UserID}
+	var rows []Book
+	if err := json.Unmarshal(rawResp, &rows); err == nil && len(rows) > 0 {
+		deleted.Book = &rows[0]
+	}
+	emitBookEvent(deleted)
+	if deleted.Book != nil && len(attachments) > 0 {
+		if err := removeFromStorage(attachments...); err != nil {
+			log.Printf("[WARNING] failed to remove attachments of book %s: %v", req.BookID, err)
+		}
+	}
+
+	result := map[string]string{"message": "Book deleted successfully"}
+	var raw []json.RawMessage
+	if json.Unmarshal(rawResp, &raw); len(raw) > 0 {
+		if id := recordAction(req.UserID, actionDeleteBook, undoPayload{Rows: raw}); id != "" {
+			result["action_id"] = id
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func handleCompleteBook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		BookID string `json:"book_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("[ERROR] handleCompleteBook decode error: %v", err)
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	log.Printf("[DEBUG] handleCompleteBook received: %+v", req)
+
+	result, snap, err := completeBookUndoable(r.Context(), req.BookID)
+	switch {
+	case errors.Is(err, errBookNotFound):
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errAlreadyCompleted):
+		http.Error(w, "Book already completed", http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("[ERROR] handleCompleteBook database error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to complete book: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":      "Book marked as completed",
+		"completion":   result.Completion,
+		"streak":       result.Streak,
+		"achievements": result.Achievements,
+		"action_id":    nullIfEmpty(recordAction(result.Book.UserID, actionCompleteBook, undoPayload{Completions: []completionSnapshot{snap}})),
+	})
+}
+
+// authorizeCron は CRON_SECRET が設定されていれば Bearer トークンを検証する。
+// ローテーション中は CRON_SECRET_PREVIOUS (旧シークレット) も受け付け、スケジューラー側の切り替えを待つ。
+// CRON_ALLOWED_IPS と CRON_HMAC_SECRET が設定されていれば送信元と署名も確認する (cronauth.go)。
+func authorizeCron(r *http.Request) bool {
+	if !cronIPAllowed(r) {
+		log.Printf("[WARNING] cron request rejected: %s is not in CRON_ALLOWED_IPS", clientIP(r))
+		return false
+	}
+	return cronSignatureValid(r) && cronSecretValid(r)
+}
+
+// cronSecretValid は Authorization: Bearer が CRON_SECRET (入れ替え中は CRON_SECRET_PREVIOUS も) と一致するか。未設定なら通す。
+func cronSecretValid(r *http.Request) bool {
+	cronSecret := os.Getenv("CRON_SECRET")
+	if cronSecret == "" {
+		return true
+	}
+	auth := []byte(r.Header.Get("Authorization"))
+	if subtle.ConstantTimeCompare(auth, []byte("Bearer "+cronSecret)) == 1 {
+		return true
+	}
+	previous := os.Getenv("CRON_SECRET_PREVIOUS")
+	return previous != "" && subtle.ConstantTimeCompare(auth, []byte("Bearer "+previous)) == 1
+}
+
+func handleCheckDeadlines(w http.ResponseWriter, r *http.Request) {
+	if !authorizeCron(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	found, count, err := runDeadlineCheck(r.Context(), cronTriggerHTTP)
+	opsAlerts.recordCron("check", err, clock.Now())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": fmt.Sprintf("Checked deadlines. Queued %d notifications.", count),
+		"overdue": found,
+		"queued":  count,
+	})
+}
+
+// checkDeadlines は期限切れの本の督促と中間目標の催促を積み、対象の本の数と積んだ数を返す
+func checkDeadlines(ctx context.Context) (int, int, error) {
+	resp, _, err := execute(supabaseClient.From("books").
+		Select("*", "exact", false).
+		In("status", []string{"unread", "insulted"}).
+		Eq("archived", "false").
+		Lt("deadline", clock.Now().Format(time.RFC3339)))
+	if err != nil {
+		log.Printf("[ERROR] handleCheckDeadlines query error: %v", err)
+		return 0, 0, err
+	}
+
+	log.Printf("[DEBUG] handleCheckDeadlines raw response: %s", string(resp))
+
+	var books []Book
+	if err := json.Unmarshal(resp, &books); err != nil {
+		log.Printf("[ERROR] handleCheckDeadlines unmarshal error: %v", err)
+	}
+	// 督促を一時停止している本は飛ばす
+	books = slices.DeleteFunc(books, func(b Book) bool { return b.isMuted(clock.Now()) })
+	log.Printf("[DEBUG] handleCheckDeadlines found %d books in unmarshaled slice", len(books))
+
+	// 送り先の設定はこの実行の中で使い回す
+	users := newUserResolver()
+	count := 0
+	if len(books) > 0 {
+		queued, err := enqueueOverdueInsults(ctx, books, users)
+		if err != nil {
+			return 0, 0, err
+		}
+		count += queued
+	} else {
+		// 期限切れの本がなければ送信待ちや督促履歴を読みに行かない (外部の cron は数分ごとに叩くため)
+		log.Printf("[INFO] handleCheckDeadlines found no overdue books, skipping insults")
+	}
+
+	arrived, err := activateWaitingBooks(users, clock.Now())
+	if err != nil {
+		log.Printf("[ERROR] handleCheckDeadlines waiting books error: %v", err)
+	}
+	count += arrived
+
+	projectReminders, err := enqueueProjectReminders(users, clock.Now())
+	if err != nil {
+		log.Printf("[ERROR] handleCheckDeadlines project reminders error: %v", err)
+	}
+	count += projectReminders
+
+	milestonePending, err := pendingJobBookIDs(nil, jobKindMilestone)
+	if err == nil {
+		var reminded int
+		reminded, err = enqueueMilestoneReminders(milestonePending, users)
+		count += reminded
+	}
+	if err != nil {
+		log.Printf("[ERROR] handleCheckDeadlines milestone reminders error: %v", err)
+	}
+
+	log.Printf("[INFO] handleCheckDeadlines completed. Found %d books, queued %d notifications.", len(books), count)
+	return len(books), count, nil
+}
+
+// enqueueOverdueInsults は期限切れの本の督促を積み、積んだ数を返す
+func enqueueOverdueInsults(ctx context.Context, books []Book, users *userResolver) (int, error) {
+	bookIDs := make([]string, 0, len(books))
+	userIDs := make([]string, 0, len(books))
+	for _, book := range books {
+		bookIDs = append(bookIDs, book.BookID)
+		userIDs = append(userIDs, book.UserID)
+	}
+	slices.Sort(userIDs)
+	userIDs = slices.Compact(userIDs)
+	// 送り先の設定は本ごとに引かず、対象ユーザーをまとめて読む
+	if err := users.prefetch(userIDs); err != nil {
+		log.Printf("[ERROR] handleCheckDeadlines users query error: %v", err)
+		return 0, err
+	}
+	pending, err := pendingJobBookIDs(userIDs, jobKindInsult)
+	if err != nil {
+		log.Printf("[ERROR] handleCheckDeadlines pending jobs query error: %v", err)
+		return 0, err
+	}
+	lastRead, err := lastReadAt(bookIDs)
+	if err != nil {
+		log.Printf("[ERROR] handleCheckDeadlines sessions query error: %v", err)
+	}
+
+	// 期限切れ後の督促の間隔 (cadence.go) を見るため、一番古い期限以降に届いた督促を集める
+	since := clock.Now()
+	for _, book := range books {
+		if book.Deadline.Before(since) {
+			since = book.Deadline
+		}
+	}
+	history, err := insultHistory(userIDs, since)
+	if err != nil {
+		log.Printf("[ERROR] handleCheckDeadlines insult history query error: %v", err)
+		return 0, err
+	}
+
+	selector, err := newInsultSelector(userIDs)
+	if err != nil {
+		log.Printf("[ERROR] handleCheckDeadlines recent insults query error: %v", err)
+	}
+
+	var due []dueReminder
+	// 同じシリーズの複数巻は1通にまとめる
+	for _, group := range groupBySeries(books) {
+		book := group[0]
+		if anyPending(group, pending) {
+			log.Printf("[DEBUG] Skipping book %s: notification already queued", book.BookID)
+			continue
+		}
+		settings, err := users.notifySettings(book.UserID)
+		if err != nil {
+			log.Printf("[ERROR] Failed to fetch user %s: %v", book.UserID, err)
+			continue
+		}
+		if settings == nil {
+			log.Printf("[WARNING] User %s not found", book.UserID)
+			continue
+		}
+		cadence := resolveCadence(book.ReminderCadence, settings.ReminderCadence)
+		if sent, last := insultsSinceDeadline(history[book.BookID], book.Deadline); !cadenceDue(cadence, sent, last, clock.Now()) {
+			log.Printf("[DEBUG] Skipping book %s: not due under %s cadence (%d sent, last %s)", book.BookID, cadence, sent, last.Format(time.RFC3339))
+			continue
+		}
+		log.Printf("[DEBUG] Processing book: %s (ID: %s, %d in group) for UserID: %s", book.Title, book.BookID, len(group), book.UserID)
+		template, insultMsg := selector.compose(ctx, group, lastRead)
+
+		// 本の指定 → ユーザーの設定 → 既定の順に送り先を決める。
+		// Supabase Auth だけで登録したユーザーは line_user_id が null なので、メールがなければ送れない。
+		channel := settings.resolve(book.NotifyChannel)
+		if channel != "" {
+			due = append(due, dueReminder{group: group, template: template, message: insultMsg, channel: channel, lineUserID: settings.lineUserID(), settings: settings})
+		} else {
+			log.Printf("[WARNING] User %s has neither a deliverable LINE account nor a notification email", book.UserID)
+		}
+	}
+	// 同じユーザーにたまった督促はまとめて1通にする (overduebatch.go)
+	return enqueueDueReminders(due, clock.Now()), nil
+}
+
+func sendLineMessage(lineUserID, message string) error {
+	return pushLineMessages(lineUserID, []LineMessage{lineTextMessage(message)})
+}
+
+// pushLineMessages は宛先が友だちになっているチャネルのトークンで push する。
+// 送る前にメッセージを確かめ、LINE に弾かれたときは応答の本文をエラーに含める。
+func pushLineMessages(lineUserID string, messages []LineMessage) error {
+	if err := validateLineMessages(messages); err != nil {
+		return err
+	}
+	body, err := json.Marshal(LinePushRequest{To: lineUserID, Messages: messages})
+	if err != nil {
+		return err
+	}
+	_, err = lineChannelAPI(lineChannelFor(lineUserID), http.MethodPost, lineAPIBase+"/message/push", "application/json", bytes.NewReader(body))
+	return err
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const maxMessageTemplateLength = 1000
+
+// 督促以外の送信メッセージ。督促文は insults.go の insultTonePools にある。
+const (
+	digestText = "📚 今週の積読レポート\n" +
+		"積読: {{.UnreadCount}}冊 (うち期限切れ {{.OverdueCount}}冊)\n" +
+		"{{if .MoneyWasted}}積んでいる金額: {{yen .MoneyWasted}}\n{{end}}" +
+		"{{if .NextTitle}}次に読むべき本: 「{{.NextTitle}}」(期限 {{.NextDeadline}}){{end}}" +
+		"{{if .NextReason}}\n理由: {{.NextReason}}{{end}}"
+	milestoneReminderText = "「{{.Title}}」の中間目標『{{.MilestoneTitle}}』({{.MilestoneDate}}) を過ぎています。最終期限まであと{{.DaysLeft}}日、このペースで間に合うと思っているんですか？"
+	reviewNudgeText       = "「{{.Title}}」読了から2日経ちました。忘れる前に評価と感想を残しておきませんか？"
+)
+
+// MessageData は送信メッセージのテンプレート ({{.Title}} など) に渡す値。
+// 取得できなかった値はゼロ値のままにし、テンプレート側で {{if}} を使って出し分ける。
+type MessageData struct {
+	Title          string
+	Author         string
+	Series         string
+	Volumes        string // シリーズをまとめた督促で「1巻・2巻」のように並べたもの
+	Count          int    // まとめて督促する冊数
+	DaysOverdue    int
+	DaysLeft       int // 最終期限までの日数
+	UnreadCount    int // ユーザーの積読冊数 (欲しい本・読了・諦めた本を除く)
+	OverdueCount   int
+	MoneyWasted    int // 積読の価格合計 (円)。価格が登録されていない本は数えない
+	MilestoneTitle string
+	MilestoneDate  string
+	NextTitle      string
+	NextDeadline   string
+	NextReason     string // 次に読む本を選んだ理由 (recommend.go)
+	DisplayName    string // グループに晒すときの表示名
+	Insight        string // 過去の記録から見つけた傾向の一言 (insights.go)。督促でだけ埋める
+	FamilySafe     bool   // 家族向けモード (familymode.go)。描画する雛形をやさしいものに限る
+}
+
+// sampleMessageData は保存時の検証で使う値。全てのフィールドを埋めておく。
+var sampleMessageData = MessageData{
+	Title: "サンプル", Author: "著者", Series: "シリーズ", Volumes: "1巻・2巻", Count: 2,
+	DaysOverdue: 3, DaysLeft: 10, UnreadCount: 5, OverdueCount: 2, MoneyWasted: 4980,
+	MilestoneTitle: "第1章", MilestoneDate: "1/2", NextTitle: "次の本", NextDeadline: "2006/01/02", NextReason: "期限まであと3日",
+	DisplayName: "読書家", Insight: "読了の80%は期限前3日以内の駆け込みです。",
+}
+
+var messageFuncs = template.FuncMap{
+	// emoji は LINE 絵文字 (productId, emojiId) を埋め込む。例: {{emoji "5ac1bfd5040ab15980c9b435" "001"}}
+	"emoji": emojiMarker,
+	// yen は 4980 を "4,980円" にする
+	"yen": func(n int) string {
+		s := strconv.Itoa(n)
+		for i := len(s) - 3; i > 0 && s[i-1] != '-'; i -= 3 {
+			s = s[:i] + "," + s[i:]
+		}
+		return s + "円"
+	},
+}
+
+var messageTemplates sync.Map // テンプレート文字列 -> *template.Template
+
+// parseMessageTemplate は解析済みのテンプレートをキャッシュして返す
+func parseMessageTemplate(text string) (*template.Template, error) {
+	if t, ok := messageTemplates.Load(text); ok {
+		return t.(*template.Template), nil
+	}
+	t, err := template.New("message").Funcs(messageFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	messageTemplates.Store(text, t)
+	return t, nil
+}
+
+// validateMessageTemplate は保存前にテンプレートを検証する。存在しない変数は実行しないと分からないので、サンプル値で一度描画する。
+func validateMessageTemplate(text string) error {
+	if len([]rune(text)) > maxMessageTemplateLength {
+		return fmt.Errorf("template too long (max %d characters)", maxMessageTemplateLength)
+	}
+	t, err := parseMessageTemplate(text)
+	if err != nil {
+		return fmt.Errorf("invalid template: %v", err)
+	}
+	if err := t.Execute(&bytes.Buffer{}, sampleMessageData); err != nil {
+		return fmt.Errorf("invalid template: %v", err)
+	}
+	return nil
+}
+
+// renderMessage はテンプレートを描画する。失敗したら fallback を返す (通知自体は止めない)。
+// 家族向けモードでは許可した雛形だけを描画し、失敗したときもやさしい定型文にする。
+func renderMessage(text string, data MessageData, fallback string) string {
+	if data.FamilySafe {
+		text, fallback = familySafeTemplate(text), gentleFallbackPlain
+	}
+	t, err := parseMessageTemplate(text)
+	if err == nil {
+		var buf bytes.Buffer
+		if err = t.Execute(&buf, data); err == nil {
+			return buf.String()
+		}
+	}
+	log.Printf("[ERROR] failed to render message template %q: %v", text, err)
+	return fallback
+}
+
+// bookMessageData は本の情報とユーザーの積読状況から MessageData を作る。
+// 積読一覧が取得できなければ、ユーザー単位の値はゼロのままにする。
+func bookMessageData(book Book, now time.Time) MessageData {
+	data := MessageData{Title: book.Title, Author: book.Author, Series: book.Series, Count: 1, FamilySafe: familySafe(book.UserID)}
+	if book.Deadline.Before(now) {
+		data.DaysOverdue = int(now.Sub(book.Deadline).Hours() / 24)
+	} else {
+		data.DaysLeft = int(book.Deadline.Sub(now).Hours() / 24)
+	}
+	books, _, err := loadUserBooks(book.UserID)
+	if err != nil {
+		log.Printf("[ERROR] bookMessageData failed to load books for user %s: %v", book.UserID, err)
+		return data
+	}
+	for _, b := range books {
+		if !slices.Contains(activeStatuses, b.Status) {
+			continue
+		}
+		data.UnreadCount++
+		if b.Deadline.Before(now) {
+			data.OverdueCount++
+		}
+		if b.Price != nil {
+			data.MoneyWasted += *b.Price
+		}
+	}
+	return data
+}
@@ -0,0 +1,394 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	metadataTimeout  = 2 * time.Second
+	metadataCacheTTL = time.Hour
+
+	// defaultMetadataProviders は METADATA_PROVIDERS 未設定時の優先順
+	defaultMetadataProviders = "openbd,rakuten,google_books,open_library"
+)
+
+// metadataProvider は書誌情報の取得元。対応しない検索は nil, nil を返す。
+type metadataProvider interface {
+	Name() string
+	Search(ctx context.Context, query string, limit int) ([]Suggestion, error)
+	LookupISBN(ctx context.Context, isbn string) ([]Suggestion, error)
+}
+
+var metadataClient = &http.Client{Timeout: metadataTimeout}
+
+// metadataProviders は METADATA_PROVIDERS (カンマ区切り) の順に利用可能なプロバイダーを返す。
+// API キーが必要なプロバイダーは未設定なら外す。
+func metadataProviders() []metadataProvider {
+	names := os.Getenv("METADATA_PROVIDERS")
+	if names == "" {
+		names = defaultMetadataProviders
+	}
+	var providers []metadataProvider
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "openbd":
+			providers = append(providers, openBDProvider{})
+		case "google_books":
+			providers = append(providers, googleBooksProvider{apiKey: os.Getenv("GOOGLE_BOOKS_API_KEY")})
+		case "rakuten":
+			if appID := os.Getenv("RAKUTEN_APP_ID"); appID != "" {
+				providers = append(providers, rakutenProvider{appID: appID})
+			}
+		case "open_library":
+			providers = append(providers, openLibraryProvider{})
+		case "":
+		default:
+			log.Printf("[WARNING] unknown metadata provider %q", name)
+		}
+	}
+	return providers
+}
+
+// externalSuggestions は優先順にプロバイダーへ問い合わせ、最初に候補を返したものを採用する。
+// ISBN なら書誌の特定 (共有の books_catalog を先に見る)、それ以外はタイトル検索として扱う。
+func externalSuggestions(ctx context.Context, query string, limit int) ([]Suggestion, error) {
+	if isbn, ok := normalizeISBN(query); ok {
+		return catalogSuggestions(ctx, isbn)
+	}
+	return providerSuggestions(ctx, query, limit)
+}
+
+// providerSuggestions はキャッシュを通さずにプロバイダーへ問い合わせる。
+// ISBN で特定した書誌に書影がなければ、後ろのプロバイダーから書影だけを補う (古い和書は Google Books に書影がないことが多い)。
+func providerSuggestions(ctx context.Context, query string, limit int) ([]Suggestion, error) {
+	isbn, isISBN := normalizeISBN(query)
+	var lastErr error
+	providers := metadataProviders()
+	for i, p := range providers {
+		var (
+			suggestions []Suggestion
+			err         error
+		)
+		if isISBN {
+			suggestions, err = p.LookupISBN(ctx, isbn)
+		} else {
+			suggestions, err = p.Search(ctx, query, limit)
+		}
+		if err != nil {
+			log.Printf("[WARNING] metadata provider %s failed: %v", p.Name(), err)
+			lastErr = err
+			continue
+		}
+		if len(suggestions) > 0 {
+			if isISBN && suggestions[0].CoverURL == "" {
+				suggestions[0].CoverURL = fallbackCover(ctx, providers[i+1:], isbn)
+			}
+			return suggestions, nil
+		}
+	}
+	return nil, lastErr
+}
+
+// fallbackCover は providers を順に見て、最初に見つかった書影の URL を返す
+func fallbackCover(ctx context.Context, providers []metadataProvider, isbn string) string {
+	for _, p := range providers {
+		suggestions, err := p.LookupISBN(ctx, isbn)
+		if err != nil {
+			log.Printf("[WARNING] cover fallback via %s failed: %v", p.Name(), err)
+			continue
+		}
+		for _, s := range suggestions {
+			if s.CoverURL != "" {
+				return s.CoverURL
+			}
+		}
+	}
+	return ""
+}
+
+// normalizeISBN はハイフンと空白を除き、ISBN-10/13 の形なら返す
+func normalizeISBN(s string) (string, bool) {
+	isbn := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(s))
+	if len(isbn) != 10 && len(isbn) != 13 {
+		return "", false
+	}
+	for i, c := range isbn {
+		if c >= '0' && c <= '9' || (c == 'X' && i == len(isbn)-1) {
+			continue
+		}
+		return "", false
+	}
+	return isbn, true
+}
+
+// bypassMetadataCache の付いた ctx では appCache を読まない (管理者による書誌の取り直し)
+type bypassMetadataCache struct{}
+
+// fetchMetadataJSON は外部 API を叩いて JSON を v に読み込む。結果は appCache に1時間載せる。
+func fetchMetadataJSON(ctx context.Context, endpoint string, v interface{}) error {
+	key := "metadata:" + endpoint
+	body, ok := appCache.Get(key)
+	if ctx.Value(bypassMetadataCache{}) != nil {
+		ok = false
+	}
+	if !ok {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := metadataClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("metadata API returned %d", resp.StatusCode)
+		}
+		var raw json.RawMessage
+		if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+			return err
+		}
+		body = raw
+		appCache.Set(key, body, metadataCacheTTL)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// googleBooksProvider は Google Books API。キーなしでも少量なら使える。
+type googleBooksProvider struct {
+	apiKey string
+}
+
+func (googleBooksProvider) Name() string { return "google_books" }
+
+func (p googleBooksProvider) Search(ctx context.Context, query string, limit int) ([]Suggestion, error) {
+	return p.volumes(ctx, query, limit)
+}
+
+func (p googleBooksProvider) LookupISBN(ctx context.Context, isbn string) ([]Suggestion, error) {
+	return p.volumes(ctx, "isbn:"+isbn, 1)
+}
+
+func (p googleBooksProvider) volumes(ctx context.Context, query string, limit int) ([]Suggestion, error) {
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("maxResults", strconv.Itoa(limit))
+	params.Set("printType", "books")
+	if p.apiKey != "" {
+		params.Set("key", p.apiKey)
+	}
+	var result struct {
+		Items []struct {
+			VolumeInfo struct {
+				Title               string   `json:"title"`
+				Authors             []string `json:"authors"`
+				PageCount           int      `json:"pageCount"`
+				IndustryIdentifiers []struct {
+					Type       string `json:"type"`
+					Identifier string `json:"identifier"`
+				} `json:"industryIdentifiers"`
+				ImageLinks struct {
+					Thumbnail string `json:"thumbnail"`
+				} `json:"imageLinks"`
+			} `json:"volumeInfo"`
+		} `json:"items"`
+	}
+	if err := fetchMetadataJSON(ctx, "https://www.googleapis.com/books/v1/volumes?"+params.Encode(), &result); err != nil {
+		return nil, err
+	}
+
+	suggestions := make([]Suggestion, 0, len(result.Items))
+	for _, item := range result.Items {
+		info := item.VolumeInfo
+		if info.Title == "" {
+			continue
+		}
+		s := Suggestion{Title: info.Title, Author: strings.Join(info.Authors, ", "), Source: "google_books", CoverURL: info.ImageLinks.Thumbnail}
+		if info.PageCount > 0 {
+			pages := info.PageCount
+			s.PageCount = &pages
+		}
+		for _, id := range info.IndustryIdentifiers {
+			if id.Type == "ISBN_13" || (id.Type == "ISBN_10" && s.ISBN == "") {
+				s.ISBN = id.Identifier
+			}
+		}
+		suggestions = append(suggestions, s)
+	}
+	return suggestions, nil
+}
+
+// openBDProvider は openBD。ISBN による書誌の特定のみでタイトル検索は持たない。
+type openBDProvider struct{}
+
+func (openBDProvider) Name() string { return "openbd" }
+
+func (openBDProvider) Search(ctx context.Context, query string, limit int) ([]Suggestion, error) {
+	return nil, nil
+}
+
+func (openBDProvider) LookupISBN(ctx context.Context, isbn string) ([]Suggestion, error) {
+	var result []*struct {
+		Summary struct {
+			ISBN   string `json:"isbn"`
+			Title  string `json:"title"`
+			Author string `json:"author"`
+			Cover  string `json:"cover"`
+		} `json:"summary"`
+	}
+	if err := fetchMetadataJSON(ctx, "https://api.openbd.jp/v1/get?isbn="+url.QueryEscape(isbn), &result); err != nil {
+		return nil, err
+	}
+	suggestions := []Suggestion{}
+	for _, r := range result {
+		if r == nil || r.Summary.Title == "" {
+			continue
+		}
+		suggestions = append(suggestions, Suggestion{Title: r.Summary.Title, Author: r.Summary.Author, Source: "openbd", ISBN: r.Summary.ISBN, CoverURL: r.Summary.Cover})
+	}
+	return suggestions, nil
+}
+
+// rakutenProvider は楽天ブックス書籍検索 API。和書の収録数と書影が充実している。
+type rakutenProvider struct {
+	appID string
+}
+
+func (rakutenProvider) Name() string { return "rakuten" }
+
+func (p rakutenProvider) Search(ctx context.Context, query string, limit int) ([]Suggestion, error) {
+	return p.books(ctx, url.Values{"title": {query}}, limit)
+}
+
+func (p rakutenProvider) LookupISBN(ctx context.Context, isbn string) ([]Suggestion, error) {
+	if len(isbn) != 13 {
+		return nil, nil // 楽天は ISBN-13 のみ
+	}
+	return p.books(ctx, url.Values{"isbn": {isbn}}, 1)
+}
+
+func (p rakutenProvider) books(ctx context.Context, params url.Values, limit int) ([]Suggestion, error) {
+	params.Set("applicationId", p.appID)
+	params.Set("format", "json")
+	params.Set("formatVersion", "2")
+	params.Set("hits", strconv.Itoa(limit))
+	var result struct {
+		Items []struct {
+			Title         string `json:"title"`
+			Author        string `json:"author"`
+			TitleKana     string `json:"titleKana"`
+			AuthorKana    string `json:"authorKana"`
+			ISBN          string `json:"isbn"`
+			LargeImageURL string `json:"largeImageUrl"`
+		} `json:"Items"`
+	}
+	if err := fetchMetadataJSON(ctx, "https://app.rakuten.co.jp/services/api/BooksBook/Search/20170404?"+params.Encode(), &result); err != nil {
+		return nil, err
+	}
+	suggestions := make([]Suggestion, 0, len(result.Items))
+	for _, item := range result.Items {
+		if item.Title == "" {
+			continue
+		}
+		// 楽天の著者は "著者A/著者B" 形式
+		author := strings.ReplaceAll(item.Author, "/", ", ")
+		suggestions = append(suggestions, Suggestion{
+			Title: item.Title, Author: author, Source: "rakuten", ISBN: item.ISBN, CoverURL: item.LargeImageURL,
+			TitleYomi: normalizeYomi(item.TitleKana), AuthorYomi: normalizeYomi(item.AuthorKana),
+		})
+	}
+	return suggestions, nil
+}
+
+// openLibraryProvider は Open Library。キー不要で、他にない古い本の書影が見つかることがある。
+type openLibraryProvider struct{}
+
+func (openLibraryProvider) Name() string { return "open_library" }
+
+func openLibraryCoverURL(coverID int) string {
+	if coverID <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("https://covers.openlibrary.org/b/id/%d-L.jpg", coverID)
+}
+
+func (openLibraryProvider) Search(ctx context.Context, query string, limit int) ([]Suggestion, error) {
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("limit", strconv.Itoa(limit))
+	params.Set("fields", "title,author_name,isbn,cover_i,number_of_pages_median")
+	var result struct {
+		Docs []struct {
+			Title      string   `json:"title"`
+			AuthorName []string `json:"author_name"`
+			ISBN       []string `json:"isbn"`
+			CoverID    int      `json:"cover_i"`
+			Pages      int      `json:"number_of_pages_median"`
+		} `json:"docs"`
+	}
+	if err := fetchMetadataJSON(ctx, "https://openlibrary.org/search.json?"+params.Encode(), &result); err != nil {
+		return nil, err
+	}
+	suggestions := make([]Suggestion, 0, len(result.Docs))
+	for _, doc := range result.Docs {
+		if doc.Title == "" {
+			continue
+		}
+		s := Suggestion{Title: doc.Title, Author: strings.Join(doc.AuthorName, ", "), Source: "open_library", CoverURL: openLibraryCoverURL(doc.CoverID)}
+		if doc.Pages > 0 {
+			pages := doc.Pages
+			s.PageCount = &pages
+		}
+		for _, id := range doc.ISBN {
+			if len(id) == 13 || (len(id) == 10 && s.ISBN == "") {
+				s.ISBN = id
+			}
+		}
+		suggestions = append(suggestions, s)
+	}
+	return suggestions, nil
+}
+
+func (openLibraryProvider) LookupISBN(ctx context.Context, isbn string) ([]Suggestion, error) {
+	key := "ISBN:" + isbn
+	var result map[string]struct {
+		Title   string `json:"title"`
+		Authors []struct {
+			Name string `json:"name"`
+		} `json:"authors"`
+		Pages int `json:"number_of_pages"`
+		Cover struct {
+			Large  string `json:"large"`
+			Medium string `json:"medium"`
+		} `json:"cover"`
+	}
+	if err := fetchMetadataJSON(ctx, "https://openlibrary.org/api/books?format=json&jscmd=data&bibkeys="+url.QueryEscape(key), &result); err != nil {
+		return nil, err
+	}
+	book, ok := result[key]
+	if !ok || book.Title == "" {
+		return []Suggestion{}, nil
+	}
+	authors := make([]string, 0, len(book.Authors))
+	for _, a := range book.Authors {
+		authors = append(authors, a.Name)
+	}
+	s := Suggestion{Title: book.Title, Author: strings.Join(authors, ", "), Source: "open_library", ISBN: isbn, CoverURL: book.Cover.Large}
+	if s.CoverURL == "" {
+		s.CoverURL = book.Cover.Medium
+	}
+	if book.Pages > 0 {
+		pages := book.Pages
+		s.PageCount = &pages
+	}
+	return []Suggestion{s}, nil
+}
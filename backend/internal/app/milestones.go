@@ -0,0 +1,257 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+const milestoneRemindInterval = 3 * 24 * time.Hour
+
+// Milestone は章ごとの中間目標
+type Milestone struct {
+	MilestoneID string     `json:"milestone_id"`
+	BookID      string     `json:"book_id"`
+	UserID      string     `json:"user_id"`
+	Title       string     `json:"title"`
+	Position    int        `json:"position"`
+	TargetDate  time.Time  `json:"target_date"`
+	Completed   bool       `json:"completed"`
+	CompletedAt *time.Time `json:"completed_at"`
+	RemindedAt  *time.Time `json:"reminded_at"`
+}
+
+func validateMilestone(m *Milestone, book Book) error {
+	m.Title = strings.TrimSpace(m.Title)
+	if m.Title == "" {
+		return fmt.Errorf("title required")
+	}
+	if m.TargetDate.IsZero() {
+		return fmt.Errorf("target_date required")
+	}
+	if !book.Deadline.IsZero() && m.TargetDate.After(book.Deadline) {
+		return fmt.Errorf("target_date must not be after the book deadline")
+	}
+	return nil
+}
+
+// handleMilestones は /api/books/{id}/milestones (一覧・作成)
+func handleMilestones(w http.ResponseWriter, r *http.Request) {
+	bookID := r.PathValue("id")
+	switch r.Method {
+	case http.MethodGet:
+		if _, err := fetchOwnedBook(bookID, r.URL.Query().Get("userId")); err != nil {
+			writeBookLookupError(w, err)
+			return
+		}
+		resp, _, err := execute(supabaseClient.From("book_milestones").
+			Select("*", "", false).
+			Eq("book_id", bookID).
+			Order("position", &postgrest.OrderOpts{Ascending: true}).
+			Order("target_date", &postgrest.OrderOpts{Ascending: true}))
+		if err != nil {
+			log.Printf("[ERROR] handleMilestones list error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch milestones: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+
+	case http.MethodPost:
+		var m Milestone
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		book, err := fetchOwnedBook(bookID, m.UserID)
+		if err != nil {
+			writeBookLookupError(w, err)
+			return
+		}
+		if err := validateMilestone(&m, book); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rawResp, _, err := executeOnce(supabaseClient.From("book_milestones").Insert(map[string]interface{}{
+			"book_id":     bookID,
+			"user_id":     m.UserID,
+			"title":       m.Title,
+			"position":    m.Position,
+			"target_date": m.TargetDate,
+		}, false, "", "", ""))
+		if err != nil {
+			log.Printf("[ERROR] handleMilestones insert error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to create milestone: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(rawResp)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleMilestone は /api/books/{id}/milestones/{milestoneId} (更新・完了・削除)
+func handleMilestone(w http.ResponseWriter, r *http.Request) {
+	bookID := r.PathValue("id")
+	milestoneID := r.PathValue("milestoneId")
+	switch r.Method {
+	case http.MethodPut:
+		var m Milestone
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		book, err := fetchOwnedBook(bookID, m.UserID)
+		if err != nil {
+			writeBookLookupError(w, err)
+			return
+		}
+		if err := validateMilestone(&m, book); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		update := map[string]interface{}{
+			"title":        m.Title,
+			"position":     m.Position,
+			"target_date":  m.TargetDate,
+			"completed":    m.Completed,
+			"completed_at": nil,
+		}
+		if m.Completed {
+			update["completed_at"] = clock.Now()
+		}
+		rawResp, _, err := execute(supabaseClient.From("book_milestones").Update(update, "", "").
+			Eq("milestone_id", milestoneID).
+			Eq("book_id", bookID))
+		if err != nil {
+			log.Printf("[ERROR] handleMilestone update error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to update milestone: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if string(rawResp) == "[]" {
+			http.Error(w, "Milestone not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(rawResp)
+
+	case http.MethodDelete:
+		if _, err := fetchOwnedBook(bookID, r.URL.Query().Get("userId")); err != nil {
+			writeBookLookupError(w, err)
+			return
+		}
+		rawResp, _, err := execute(supabaseClient.From("book_milestones").Delete("", "").
+			Eq("milestone_id", milestoneID).
+			Eq("book_id", bookID))
+		if err != nil {
+			log.Printf("[ERROR] handleMilestone delete error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to delete milestone: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if string(rawResp) == "[]" {
+			http.Error(w, "Milestone not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Milestone deleted successfully"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// enqueueMilestoneReminders は最終期限前の本について、過ぎてしまった次の中間目標を督促する。
+// 同じ中間目標への督促は milestoneRemindInterval に1回まで。
+func enqueueMilestoneReminders(pending map[string]bool, users *userResolver) (int, error) {
+	now := clock.Now()
+	resp, _, err := execute(supabaseClient.From("book_milestones").
+		Select("*", "", false).
+		Eq("completed", "false").
+		Lt("target_date", now.Format(time.RFC3339)).
+		Or("reminded_at.is.null,reminded_at.lt."+now.Add(-milestoneRemindInterval).Format(time.RFC3339), "").
+		Order("position", &postgrest.OrderOpts{Ascending: true}).
+		Order("target_date", &postgrest.OrderOpts{Ascending: true}))
+	if err != nil {
+		return 0, err
+	}
+	var milestones []Milestone
+	if err := json.Unmarshal(resp, &milestones); err != nil {
+		return 0, err
+	}
+
+	// 本ごとに最初の (次に取り組むべき) 中間目標だけを残す
+	next := make(map[string]Milestone)
+	var bookIDs []string
+	for _, m := range milestones {
+		if _, ok := next[m.BookID]; !ok && !pending[m.BookID] {
+			next[m.BookID] = m
+			bookIDs = append(bookIDs, m.BookID)
+		}
+	}
+	if len(bookIDs) == 0 {
+		return 0, nil
+	}
+
+	bResp, _, err := execute(supabaseClient.From("books").
+		Select("*", "", false).
+		In("book_id", bookIDs).
+		In("status", activeStatuses).
+		Eq("archived", "false").
+		Gte("deadline", now.Format(time.RFC3339)))
+	if err != nil {
+		return 0, err
+	}
+	var books []Book
+	if err := json.Unmarshal(bResp, &books); err != nil {
+		return 0, err
+	}
+
+	userIDs := make([]string, 0, len(books))
+	for _, book := range books {
+		userIDs = append(userIDs, book.UserID)
+	}
+	if err := users.prefetch(userIDs); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, book := range books {
+		if book.isMuted(now) {
+			continue
+		}
+		m := next[book.BookID]
+		channel, lineUserID, ok := notifyTarget(users, book)
+		if !ok {
+			log.Printf("[WARNING] cannot remind milestone %s for user %s: no deliverable channel", m.MilestoneID, book.UserID)
+			continue
+		}
+		data := bookMessageData(book, now)
+		data.MilestoneTitle = m.Title
+		data.MilestoneDate = m.TargetDate.In(jst).Format("1/2")
+		msg := renderMessage(milestoneReminderText, data, fmt.Sprintf("「%s」の中間目標『%s』を過ぎています。", book.Title, m.Title))
+		err = enqueueJob(NotificationJob{
+			Kind:        jobKindMilestone,
+			BookID:      book.BookID,
+			MilestoneID: m.MilestoneID,
+			UserID:      book.UserID,
+			Channel:     channel,
+			LineUserID:  lineUserID,
+			Message:     msg,
+			RunAt:       now,
+		})
+		if err != nil {
+			log.Printf("[ERROR] failed to enqueue milestone reminder %s: %v", m.MilestoneID, err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,312 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+const (
+	monthlyReportMaxTitles = 5
+	monthlyReportCardTTL   = 30 * 24 * time.Hour // LINE のトークからしばらく後に開いても見られるように
+)
+
+// MonthlyReport は前月の振り返り
+type MonthlyReport struct {
+	Month          time.Time
+	Finished       []string // 読了したタイトル (新しい順)
+	FinishedCount  int
+	Finishing      FinishingStats // 今月の読了の所要日数
+	PrevFinished   int            // 前々月の読了数 (比較用)
+	InsultsCount   int
+	Procrastinated *BookRef // 期限を最も長く過ぎている本
+	DaysOverdue    int
+	CardURL        string // 統計カード画像の署名付き URL (作れなければ空)
+}
+
+// monthRange は JST の暦月の [start, end)
+func monthRange(month time.Time) (time.Time, time.Time) {
+	start := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, jst)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// buildMonthlyReport は month の読了・督促と、現時点で最も放置している本をまとめる
+func buildMonthlyReport(userID string, month time.Time, now time.Time) (*MonthlyReport, error) {
+	start, end := monthRange(month)
+	prevStart, _ := monthRange(start.AddDate(0, -1, 0))
+	report := &MonthlyReport{Month: start}
+
+	cResp, _, err := execute(supabaseClient.From("book_completions").
+		Select("completed_at, started_at, days_early, read_cycle, books(title)", "", false).
+		Eq("user_id", userID).
+		Gte("completed_at", start.Format(time.RFC3339)).
+		Lt("completed_at", end.Format(time.RFC3339)).
+		Order("completed_at", &postgrest.OrderOpts{Ascending: false}))
+	if err != nil {
+		return nil, err
+	}
+	var completions []struct {
+		Completion
+		Book struct {
+			Title string `json:"title"`
+		} `json:"books"`
+	}
+	if err := json.Unmarshal(cResp, &completions); err != nil {
+		return nil, err
+	}
+	report.FinishedCount = len(completions)
+	finished := make([]Completion, 0, len(completions))
+	for _, c := range completions {
+		report.Finished = append(report.Finished, c.Book.Title)
+		finished = append(finished, c.Completion)
+	}
+	report.Finishing = summarizeFinishing(finished)
+
+	_, prev, err := execute(supabaseClient.From("book_completions").
+		Select("completion_id", "exact", true).
+		Eq("user_id", userID).
+		Gte("completed_at", prevStart.Format(time.RFC3339)).
+		Lt("completed_at", start.Format(time.RFC3339)))
+	if err != nil {
+		return nil, err
+	}
+	report.PrevFinished = int(prev)
+
+	_, insults, err := execute(supabaseClient.From("notification_jobs").
+		Select("job_id", "exact", true).
+		Eq("user_id", userID).
+		Eq("kind", jobKindInsult).
+		Eq("status", "sent").
+		Gte("sent_at", start.Format(time.RFC3339)).
+		Lt("sent_at", end.Format(time.RFC3339)))
+	if err != nil {
+		return nil, err
+	}
+	report.InsultsCount = int(insults)
+
+	bResp, _, err := execute(supabaseClient.From("books").
+		Select("book_id, title, deadline", "", false).
+		Eq("user_id", userID).
+		In("status", activeStatuses).
+		Eq("archived", "false").
+		Lt("deadline", now.Format(time.RFC3339)).
+		Order("deadline", &postgrest.OrderOpts{Ascending: true}).
+		Limit(1, ""))
+	if err != nil {
+		return nil, err
+	}
+	var overdue []Book
+	json.Unmarshal(bResp, &overdue)
+	if len(overdue) > 0 {
+		report.Procrastinated = &BookRef{BookID: overdue[0].BookID, Title: overdue[0].Title}
+		report.DaysOverdue = int(now.Sub(overdue[0].Deadline).Hours() / 24)
+	}
+	return report, nil
+}
+
+// isEmpty は伝えることがない月か (読了も督促も放置本もない)
+func (r *MonthlyReport) isEmpty() bool {
+	return r.FinishedCount == 0 && r.PrevFinished == 0 && r.InsultsCount == 0 && r.Procrastinated == nil
+}
+
+// comparison は前月比の一言
+func (r *MonthlyReport) comparison() string {
+	diff := r.FinishedCount - r.PrevFinished
+	switch {
+	case diff > 0:
+		return fmt.Sprintf("先月より%d冊多い！やればできるじゃないか", diff)
+	case diff < 0:
+		return fmt.Sprintf("先月より%d冊少ない。サボったな？", -diff)
+	default:
+		return "先月と同じ冊数。可もなく不可もなく"
+	}
+}
+
+// altText は通知欄とトーク一覧に出る要約
+func (r *MonthlyReport) altText() string {
+	return fmt.Sprintf("%d月の積読レポート: 読了%d冊・督促%d回", int(r.Month.Month()), r.FinishedCount, r.InsultsCount)
+}
+
+func flexText(text string, extra map[string]interface{}) map[string]interface{} {
+	m := map[string]interface{}{"type": "text", "text": text, "wrap": true}
+	for k, v := range extra {
+		m[k] = v
+	}
+	return m
+}
+
+func flexRow(label, value string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":   "box",
+		"layout": "horizontal",
+		"contents": []interface{}{
+			flexText(label, map[string]interface{}{"size": "sm", "color": "#888888", "flex": 3}),
+			flexText(value, map[string]interface{}{"size": "sm", "weight": "bold", "align": "end", "flex": 2}),
+		},
+	}
+}
+
+// flexBubble はレポートを LINE の Flex Message (bubble) にする
+func (r *MonthlyReport) flexBubble() (json.RawMessage, error) {
+	body := []interface{}{
+		flexRow("読了した本", fmt.Sprintf("%d冊", r.FinishedCount)),
+		flexRow("前月", fmt.Sprintf("%d冊", r.PrevFinished)),
+		flexRow("受けた督促", fmt.Sprintf("%d回", r.InsultsCount)),
+		flexText(r.comparison(), map[string]interface{}{"size": "sm", "margin": "md"}),
+	}
+	if f := r.Finishing; f.Completions > 0 {
+		body = append(body,
+			flexRow("読了までの平均", fmt.Sprintf("%.0f日", f.AvgDaysHeld)),
+			flexRow("期限に遅れた本", fmt.Sprintf("%d冊", f.Late)),
+		)
+		if f.Late > 0 {
+			body = append(body, flexRow("平均の遅れ", fmt.Sprintf("%.0f日", f.AvgDaysLate)))
+		}
+	}
+	if len(r.Finished) > 0 {
+		body = append(body, map[string]interface{}{"type": "separator", "margin": "lg"})
+		for i, title := range r.Finished {
+			if i == monthlyReportMaxTitles {
+				body = append(body, flexText(fmt.Sprintf("ほか%d冊", len(r.Finished)-i), map[string]interface{}{"size": "xs", "color": "#888888"}))
+				break
+			}
+			body = append(body, flexText("📗 "+title, map[string]interface{}{"size": "sm", "margin": "sm"}))
+		}
+	}
+	if r.Procrastinated != nil {
+		body = append(body,
+			map[string]interface{}{"type": "separator", "margin": "lg"},
+			flexText("最も放置している本", map[string]interface{}{"size": "xs", "color": "#888888", "margin": "lg"}),
+			flexText(fmt.Sprintf("『%s』期限から%d日経過", r.Procrastinated.Title, r.DaysOverdue), map[string]interface{}{"size": "sm", "weight": "bold", "color": "#e0533d"}),
+		)
+	}
+
+	bubble := map[string]interface{}{
+		"type": "bubble",
+		"header": map[string]interface{}{
+			"type":            "box",
+			"layout":          "vertical",
+			"backgroundColor": "#1f2a44",
+			"contents": []interface{}{
+				flexText(fmt.Sprintf("%d年%d月の積読レポート", r.Month.Year(), int(r.Month.Month())), map[string]interface{}{"color": "#ffffff", "weight": "bold", "size": "lg"}),
+			},
+		},
+		"body": map[string]interface{}{
+			"type":     "box",
+			"layout":   "vertical",
+			"spacing":  "sm",
+			"contents": body,
+		},
+	}
+	if r.CardURL != "" {
+		bubble["footer"] = map[string]interface{}{
+			"type":   "box",
+			"layout": "vertical",
+			"contents": []interface{}{map[string]interface{}{
+				"type":   "button",
+				"style":  "link",
+				"action": map[string]string{"type": "uri", "label": "統計カードを見る", "uri": r.CardURL},
+			}},
+		}
+	}
+	return json.Marshal(bubble)
+}
+
+// storeReportCard は月の統計カードを Storage に置き、LINE から開ける署名付き URL を返す
+func storeReportCard(userID string, month time.Time) (string, error) {
+	ms, err := loadMonthlyStats(userID, month)
+	if err != nil {
+		return "", err
+	}
+	card, err := renderStatsCard(ms)
+	if err != nil {
+		return "", err
+	}
+	return storeAndSign(fmt.Sprintf("reports/%s/%s.png", userID, month.Format("2006-01")), "image/png", card, monthlyReportCardTTL, "")
+}
+
+// handleMonthlyReport は /api/cron/monthly-report。毎月1日にスケジューラーから呼び、前月の振り返りを送る。
+// 同じ月に2回呼ばれても、今月すでにレポートを積んだユーザーには送らない。
+func handleMonthlyReport(w http.ResponseWriter, r *http.Request) {
+	if !authorizeCron(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	now := clock.Now().In(jst)
+	thisMonth, _ := monthRange(now)
+	lastMonth := thisMonth.AddDate(0, -1, 0)
+
+	jResp, _, err := execute(supabaseClient.From("notification_jobs").
+		Select("user_id", "", false).
+		Eq("kind", jobKindMonthly).
+		Gte("created_at", thisMonth.Format(time.RFC3339)))
+	if err != nil {
+		log.Printf("[ERROR] handleMonthlyReport job query error: %v", err)
+		opsAlerts.recordCron("monthly-report", err, clock.Now())
+		http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var sent []NotificationJob
+	json.Unmarshal(jResp, &sent)
+	alreadySent := make(map[string]bool, len(sent))
+	for _, j := range sent {
+		alreadySent[j.UserID] = true
+	}
+
+	uResp, _, err := execute(supabaseClient.From("users").Select("id, line_user_id", "", false))
+	if err != nil {
+		log.Printf("[ERROR] handleMonthlyReport user query error: %v", err)
+		opsAlerts.recordCron("monthly-report", err, clock.Now())
+		http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var users []struct {
+		ID         string `json:"id"`
+		LineUserID string `json:"line_user_id"`
+	}
+	json.Unmarshal(uResp, &users)
+
+	count := 0
+	for _, u := range users {
+		if alreadySent[u.ID] || u.LineUserID == "" {
+			continue
+		}
+		report, err := buildMonthlyReport(u.ID, lastMonth, now)
+		if err != nil {
+			log.Printf("[ERROR] monthly report for user %s: %v", u.ID, err)
+			continue
+		}
+		if report.isEmpty() {
+			continue
+		}
+		if report.CardURL, err = storeReportCard(u.ID, lastMonth); err != nil {
+			log.Printf("[WARNING] monthly report card for user %s: %v", u.ID, err)
+		}
+		payload, err := report.flexBubble()
+		if err != nil {
+			log.Printf("[ERROR] monthly report flex for user %s: %v", u.ID, err)
+			continue
+		}
+		if err := enqueueJob(NotificationJob{
+			Kind:       jobKindMonthly,
+			UserID:     u.ID,
+			LineUserID: u.LineUserID,
+			Message:    report.altText(),
+			Payload:    payload,
+			RunAt:      clock.Now(),
+		}); err != nil {
+			log.Printf("[ERROR] failed to enqueue monthly report for user %s: %v", u.ID, err)
+			continue
+		}
+		count++
+	}
+	log.Printf("[INFO] monthly reports enqueued for %d users", count)
+
+	opsAlerts.recordCron("monthly-report", nil, clock.Now())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Monthly reports enqueued", "count": count})
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// 本ごとの督促の一時停止。翻訳待ちなど、読むのをやめたわけではないが今は催促されたくない本に使う。
+// 止めている間は期限切れの督促と中間目標の催促を送らず、until を過ぎれば自動で再開する。
+
+// isMuted は now の時点で督促を止めているか
+func (b Book) isMuted(now time.Time) bool {
+	return b.MutedAt != nil && (b.MutedUntil == nil || now.Before(*b.MutedUntil))
+}
+
+// handleMute は POST /api/books/{id}/mute {user_id, until} と POST /api/books/{id}/unmute {user_id}。
+// until を省くと unmute するまで止める。
+func handleMute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID string     `json:"user_id"`
+		Until  *time.Time `json:"until"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	book, err := fetchOwnedBook(r.PathValue("id"), req.UserID)
+	if err != nil {
+		writeBookLookupError(w, err)
+		return
+	}
+
+	now := clock.Now()
+	mute := r.Pattern == "/api/books/{id}/mute"
+	update := map[string]interface{}{"muted_at": nil, "muted_until": nil, "updated_at": now}
+	if mute {
+		if req.Until != nil && !req.Until.After(now) {
+			http.Error(w, "until must be in the future", http.StatusBadRequest)
+			return
+		}
+		update["muted_at"], update["muted_until"] = now, req.Until
+	} else if !book.isMuted(now) {
+		http.Error(w, "Book is not muted", http.StatusConflict)
+		return
+	}
+	rawResp, _, err := execute(supabaseClient.From("books").Update(update, "", "").Eq("book_id", book.BookID).Eq("user_id", book.UserID))
+	if err != nil {
+		log.Printf("[ERROR] handleMute update error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to update book: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if mute {
+		// 送信待ちの督促も止める
+		execute(supabaseClient.From("notification_jobs").
+			Update(map[string]interface{}{"status": "skipped", "last_error": "muted"}, "minimal", "").
+			Eq("book_id", book.BookID).
+			In("kind", []string{jobKindInsult, jobKindMilestone, jobKindEscalation}).
+			Eq("status", "pending"))
+	}
+	emitBookRows("book.updated", rawResp)
+
+	w.Header().Set("Content-Type", "application/json")
+	if !mute {
+		json.NewEncoder(w).Encode(map[string]string{"message": "Book unmuted"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Book muted", "muted_until": req.Until})
+}
@@ -0,0 +1,160 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+const maxNoteLength = 10000
+
+// Note は書籍に紐づくメモ・引用
+type Note struct {
+	NoteID    string    `json:"note_id"`
+	BookID    string    `json:"book_id"`
+	UserID    string    `json:"user_id"`
+	Kind      string    `json:"kind"` // note, quote
+	Content   string    `json:"content"`
+	Page      *int      `json:"page"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func validateNote(n *Note) error {
+	if n.Kind == "" {
+		n.Kind = "note"
+	}
+	if n.Kind != "note" && n.Kind != "quote" {
+		return fmt.Errorf("kind must be note or quote")
+	}
+	n.Content = strings.TrimSpace(n.Content)
+	if n.Content == "" {
+		return fmt.Errorf("content required")
+	}
+	if len([]rune(n.Content)) > maxNoteLength {
+		return fmt.Errorf("content too long (max %d characters)", maxNoteLength)
+	}
+	if n.Page != nil && *n.Page < 0 {
+		return fmt.Errorf("page must not be negative")
+	}
+	return nil
+}
+
+// handleNotes は /api/books/{id}/notes (一覧・作成)
+func handleNotes(w http.ResponseWriter, r *http.Request) {
+	bookID := r.PathValue("id")
+	switch r.Method {
+	case http.MethodGet:
+		userId := r.URL.Query().Get("userId")
+		if _, err := fetchOwnedBook(bookID, userId); err != nil {
+			writeBookLookupError(w, err)
+			return
+		}
+		resp, _, err := execute(supabaseClient.From("book_notes").
+			Select("*", "", false).
+			Eq("book_id", bookID).
+			Order("created_at", &postgrest.OrderOpts{Ascending: true}))
+		if err != nil {
+			log.Printf("[ERROR] handleNotes list error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch notes: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openRows("book_notes", resp))
+
+	case http.MethodPost:
+		var note Note
+		if err := json.NewDecoder(r.Body).Decode(&note); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := validateNote(&note); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := fetchOwnedBook(bookID, note.UserID); err != nil {
+			writeBookLookupError(w, err)
+			return
+		}
+		rawResp, _, err := executeOnce(supabaseClient.From("book_notes").Insert(map[string]interface{}{
+			"book_id": bookID,
+			"user_id": note.UserID,
+			"kind":    note.Kind,
+			"content": sealText("book_notes", note.Content),
+			"page":    note.Page,
+		}, false, "", "", ""))
+		if err != nil {
+			log.Printf("[ERROR] handleNotes insert error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to create note: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(openRows("book_notes", rawResp))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleNote は /api/books/{id}/notes/{noteId} (更新・削除)
+func handleNote(w http.ResponseWriter, r *http.Request) {
+	bookID := r.PathValue("id")
+	noteID := r.PathValue("noteId")
+	switch r.Method {
+	case http.MethodPut:
+		var note Note
+		if err := json.NewDecoder(r.Body).Decode(&note); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := validateNote(&note); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rawResp, _, err := execute(supabaseClient.From("book_notes").Update(map[string]interface{}{
+			"kind":       note.Kind,
+			"content":    sealText("book_notes", note.Content),
+			"page":       note.Page,
+			"updated_at": clock.Now(),
+		}, "", "").Eq("note_id", noteID).Eq("book_id", bookID).Eq("user_id", note.UserID))
+		if err != nil {
+			log.Printf("[ERROR] handleNote update error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to update note: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if string(rawResp) == "[]" {
+			http.Error(w, "Note not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openRows("book_notes", rawResp))
+
+	case http.MethodDelete:
+		userId := r.URL.Query().Get("userId")
+		if userId == "" {
+			http.Error(w, "userId required", http.StatusBadRequest)
+			return
+		}
+		rawResp, _, err := execute(supabaseClient.From("book_notes").Delete("", "").Eq("note_id", noteID).Eq("book_id", bookID).Eq("user_id", userId))
+		if err != nil {
+			log.Printf("[ERROR] handleNote delete error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to delete note: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if string(rawResp) == "[]" {
+			http.Error(w, "Note not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Note deleted successfully"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,254 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// 通知の配信記録。notification_jobs の status と last_error (見送った理由) から配信状態を求める。
+const (
+	deliveryQueued         = "queued"
+	deliverySent           = "sent"
+	deliveryFailed         = "failed"
+	deliveryQuietHours     = "suppressed_quiet_hours"
+	deliveryQuota          = "suppressed_quota"
+	deliverySkipped        = "skipped" // ブロック中・アーカイブ済みなど。理由は error に入る
+	quietHoursReason       = "quiet_hours"
+	testNotifyInterval     = time.Minute
+	testNotifyMessage      = "🔔 テスト通知です。期限を過ぎた本があると、積読キラーからこのように督促が届きます。"
+	defaultReceiptsLimit   = 50
+	maxReceiptsLimit       = 200
+	notificationJobColumns = "job_id, kind, book_id, book_ids, channel, status, last_error, error_class, attempts, run_at, sent_at, created_at"
+)
+
+// NotificationReceipt は1件の通知の配信状態
+type NotificationReceipt struct {
+	JobID      string     `json:"job_id"`
+	Kind       string     `json:"kind"`
+	BookID     string     `json:"book_id,omitempty"`
+	BookIDs    []string   `json:"book_ids,omitempty"`
+	Channel    string     `json:"channel"`
+	State      string     `json:"state"`
+	Error      string     `json:"error,omitempty"`
+	ErrorClass string     `json:"error_class,omitempty"`
+	Attempts   int        `json:"attempts"`
+	RunAt      time.Time  `json:"run_at"`
+	SentAt     *time.Time `json:"sent_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// LastReminder は本ごとの最後に届いた督促
+type LastReminder struct {
+	At      time.Time `json:"at"`
+	Channel string    `json:"channel"`
+	Kind    string    `json:"kind"`
+}
+
+func deliveryState(job NotificationJob) string {
+	switch job.Status {
+	case "pending", "processing":
+		return deliveryQueued
+	case "sent":
+		return deliverySent
+	case "failed":
+		return deliveryFailed
+	}
+	switch job.LastError {
+	case quietHoursReason:
+		return deliveryQuietHours
+	case quotaDegradeReason:
+		return deliveryQuota
+	}
+	return deliverySkipped
+}
+
+func receiptFor(job NotificationJob) NotificationReceipt {
+	channel := job.Channel
+	if channel == "" {
+		channel = notifyChannelLine
+	}
+	r := NotificationReceipt{
+		JobID: job.JobID, Kind: job.Kind, BookID: job.BookID, Channel: channel, State: deliveryState(job),
+		Attempts: job.Attempts, RunAt: job.RunAt, SentAt: job.SentAt, CreatedAt: job.CreatedAt,
+	}
+	if len(job.BookIDs) > 1 {
+		r.BookIDs = job.BookIDs
+	}
+	if r.State != deliverySent {
+		r.Error, r.ErrorClass = job.LastError, job.ErrorClass
+	}
+	return r
+}
+
+// inQuietHours は now (JST) が start 時〜end 時の間か。start > end なら日をまたぐ (22〜8 時など)。
+func inQuietHours(start, end int, now time.Time) bool {
+	h := now.In(jst).Hour()
+	if start == end {
+		return false
+	}
+	if start < end {
+		return h >= start && h < end
+	}
+	return h >= start || h < end
+}
+
+// quietHoursAllow はユーザーのおやすみ時間帯でなければ true。
+// 集中読書の確認・メール登録の返事・読了証はユーザーの操作への応答なので止めない。
+func quietHoursAllow(job NotificationJob, now time.Time) bool {
+	if job.Kind == jobKindFocusCheckin || job.Kind == jobKindInboundEmail || job.Kind == jobKindCertificate {
+		return true
+	}
+	settings, err := fetchNotifySettings(job.UserID)
+	if err != nil {
+		log.Printf("[WARNING] failed to check quiet hours for user %s: %v", job.UserID, err)
+		return true
+	}
+	if settings == nil || settings.QuietHoursStart == nil || settings.QuietHoursEnd == nil {
+		return true
+	}
+	return !inQuietHours(*settings.QuietHoursStart, *settings.QuietHoursEnd, now)
+}
+
+// handleNotifications は GET /api/notifications?userId=...[&bookId=...][&limit=50]。
+// 新しい順の配信記録と、本ごとの最後に届いた督促 (督促・中間目標) を返す。
+func handleNotifications(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+	limit := defaultReceiptsLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxReceiptsLimit)
+	}
+	q := supabaseClient.From("notification_jobs").Select(notificationJobColumns, "", false).Eq("user_id", userId)
+	if bookID := r.URL.Query().Get("bookId"); bookID != "" {
+		// Or のフィルター文字列に埋め込むので UUID の文字だけを通す
+		if strings.Trim(bookID, "0123456789abcdefABCDEF-") != "" {
+			http.Error(w, "invalid bookId", http.StatusBadRequest)
+			return
+		}
+		q = q.Or(fmt.Sprintf("book_id.eq.%s,book_ids.cs.{%s}", bookID, bookID), "")
+	}
+	resp, _, err := execute(q.Order("created_at", &postgrest.OrderOpts{Ascending: false}).Limit(limit, ""))
+	if err != nil {
+		log.Printf("[ERROR] handleNotifications query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch notifications: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var jobs []NotificationJob
+	if err := json.Unmarshal(resp, &jobs); err != nil {
+		log.Printf("[ERROR] handleNotifications unmarshal error: %v", err)
+	}
+
+	receipts := make([]NotificationReceipt, 0, len(jobs))
+	lastReminded := map[string]LastReminder{}
+	for _, job := range jobs {
+		receipt := receiptFor(job)
+		receipts = append(receipts, receipt)
+		if receipt.State != deliverySent || receipt.SentAt == nil || !slices.Contains([]string{jobKindInsult, jobKindMilestone}, job.Kind) {
+			continue
+		}
+		for _, id := range job.targetBookIDs() {
+			// 新しい順に並んでいるので最初に見つかったものが最後の督促
+			if _, ok := lastReminded[id]; !ok {
+				lastReminded[id] = LastReminder{At: *receipt.SentAt, Channel: receipt.Channel, Kind: job.Kind}
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"notifications": receipts, "last_reminded": lastReminded})
+}
+
+func testNotifyCacheKey(userID string) string { return "notify:test:" + userID }
+
+// handleTestNotification は POST /api/notifications/test {user_id, channel}。
+// 見本の督促をその場で送り、LINE 連携やメールアドレスが正しいか確かめられるようにする。
+// channel を省くと督促と同じ決め方 (ユーザーの設定 → 既定) で送る。おやすみ時間帯や送信数の制限は見ない。
+func handleTestNotification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID  string `json:"user_id"`
+		Channel string `json:"channel"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if !validNotifyChannel(req.Channel) {
+		http.Error(w, "channel must be line or email", http.StatusBadRequest)
+		return
+	}
+	settings, err := fetchNotifySettings(req.UserID)
+	if err != nil {
+		log.Printf("[ERROR] handleTestNotification settings error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch settings: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if settings == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	channel := req.Channel
+	if channel == "" {
+		channel = settings.resolve(nil)
+	}
+	if channel == "" || !settings.available(channel) {
+		http.Error(w, "No deliverable channel: link LINE or register a notification email", http.StatusUnprocessableEntity)
+		return
+	}
+	// 連打で LINE の送信数を使い切らないよう1分に1回まで
+	if _, ok := appCache.Get(testNotifyCacheKey(req.UserID)); ok {
+		http.Error(w, "Test notification already sent; try again in a minute", http.StatusTooManyRequests)
+		return
+	}
+	appCache.Set(testNotifyCacheKey(req.UserID), []byte("1"), testNotifyInterval)
+
+	if channel == notifyChannelLine && lineBreaker.isOpen() {
+		// LINE の障害中はジョブに積み、復旧後に送る
+		err := enqueueJob(NotificationJob{Kind: jobKindTestNotify, UserID: req.UserID, Channel: channel, LineUserID: settings.lineUserID(), Message: testNotifyMessage, RunAt: clock.Now()})
+		if err != nil {
+			log.Printf("[ERROR] handleTestNotification enqueue error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to queue test notification: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": "LINE is temporarily unavailable; test notification queued", "channel": channel, "queued": true})
+		return
+	}
+	if channel == notifyChannelEmail {
+		err = sendEmail(*settings.NotifyEmail, "【積読キラー】テスト通知", plainText(testNotifyMessage))
+	} else {
+		err = sendLineMessage(settings.lineUserID(), testNotifyMessage)
+	}
+	if err != nil {
+		log.Printf("[WARNING] test notification via %s failed for user %s: %v", channel, req.UserID, err)
+		http.Error(w, fmt.Sprintf("failed to send test notification via %s: %v", channel, err), http.StatusBadGateway)
+		return
+	}
+	log.Printf("[INFO] test notification sent via %s to user %s", channel, req.UserID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Test notification sent", "channel": channel})
+}
@@ -0,0 +1,351 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"slices"
+	"time"
+)
+
+// 督促の送り先チャネル。本ごとの指定 (books.notify_channel) → ユーザーの設定 (users.notify_channel)
+// → NOTIFY_DEFAULT_CHANNEL (未設定なら LINE) の順に決める。
+// 決まったチャネルで届けられない (LINE 未連携・ブロック中、メールアドレス未登録) ときはもう一方に回す。
+const (
+	notifyChannelLine  = "line"
+	notifyChannelEmail = "email"
+
+	emailSendTimeout = 15 * time.Second
+)
+
+var (
+	notifyChannels = []string{notifyChannelLine, notifyChannelEmail}
+
+	errNoNotifyEmail = errors.New("no notification email address")
+
+	emailClient = &http.Client{Timeout: emailSendTimeout}
+)
+
+// validNotifyChannel は空文字 (指定なし) か既知のチャネルなら true
+func validNotifyChannel(ch string) bool {
+	return ch == "" || slices.Contains(notifyChannels, ch)
+}
+
+func defaultNotifyChannel() string {
+	if ch := os.Getenv("NOTIFY_DEFAULT_CHANNEL"); ch != "" && validNotifyChannel(ch) {
+		return ch
+	}
+	return notifyChannelLine
+}
+
+// notifySettingsColumns は NotifySettings に読む users の列
+const notifySettingsColumns = "id, line_user_id, line_blocked_at, notify_channel, notify_email, quiet_hours_start, quiet_hours_end, escalate_after, escalate_to, reminder_cadence, preferred_send_hour, completion_certificates"
+
+// NotifySettings はユーザーの送り先の設定
+type NotifySettings struct {
+	UserID        string     `json:"id"`
+	LineUserID    *string    `json:"line_user_id"`
+	LineBlockedAt *time.Time `json:"line_blocked_at"`
+	NotifyChannel *string    `json:"notify_channel"`
+	NotifyEmail   *string    `json:"notify_email"`
+	// おやすみ時間帯 (JST の時)。両方あるときだけ督促を見送る (notifications.go)
+	QuietHoursStart *int `json:"quiet_hours_start"`
+	QuietHoursEnd   *int `json:"quiet_hours_end"`
+	// 督促を escalate_after 回無視したら escalate_to (line, email, partner) にも知らせる (escalation.go)
+	EscalateAfter *int    `json:"escalate_after"`
+	EscalateTo    *string `json:"escalate_to"`
+	// 期限切れ後の督促の間隔 (cadence.go)
+	ReminderCadence *string `json:"reminder_cadence"`
+	// 急ぎでない通知を送る時刻 (JST の時)。無ければ LINE の操作履歴から学習する (sendtime.go)
+	PreferredSendHour *int `json:"preferred_send_hour"`
+	// 読了したときに読了証の画像を送るか (certificate.go)
+	CompletionCertificates bool `json:"completion_certificates"`
+}
+
+func fetchNotifySettings(userID string) (*NotifySettings, error) {
+	resp, _, err := execute(supabaseClient.From("users").
+		Select(notifySettingsColumns, "", false).
+		Eq("id", userID))
+	if err != nil {
+		return nil, err
+	}
+	var users []NotifySettings
+	if err := json.Unmarshal(resp, &users); err != nil || len(users) == 0 {
+		return nil, err
+	}
+	return &users[0], nil
+}
+
+// lineUserID は LINE で届けられる宛先。未連携やブロック中なら空文字。
+func (s NotifySettings) lineUserID() string {
+	if s.LineUserID == nil || s.LineBlockedAt != nil {
+		return ""
+	}
+	return *s.LineUserID
+}
+
+func (s NotifySettings) available(ch string) bool {
+	switch ch {
+	case notifyChannelLine:
+		return s.lineUserID() != ""
+	case notifyChannelEmail:
+		return s.NotifyEmail != nil && *s.NotifyEmail != ""
+	}
+	return false
+}
+
+// resolve は本の指定 (nil なら指定なし) を踏まえて送り先のチャネルを返す。どちらにも届けられなければ空文字。
+func (s NotifySettings) resolve(bookChannel *string) string {
+	ch := defaultNotifyChannel()
+	if s.NotifyChannel != nil && *s.NotifyChannel != "" {
+		ch = *s.NotifyChannel
+	}
+	if bookChannel != nil && *bookChannel != "" {
+		ch = *bookChannel
+	}
+	if s.available(ch) {
+		return ch
+	}
+	for _, other := range notifyChannels {
+		if s.available(other) {
+			return other
+		}
+	}
+	return ""
+}
+
+// notifyTarget は本の督促の送り先を決める。ok = false ならどこにも送れない。
+func notifyTarget(users *userResolver, book Book) (channel, lineUserID string, ok bool) {
+	settings, err := users.notifySettings(book.UserID)
+	if err != nil {
+		log.Printf("[ERROR] failed to fetch notify settings for user %s: %v", book.UserID, err)
+		return "", "", false
+	}
+	if settings == nil {
+		return "", "", false
+	}
+	channel = settings.resolve(book.NotifyChannel)
+	return channel, settings.lineUserID(), channel != ""
+}
+
+// notifyEmailSubject は通知メールの件名
+func notifyEmailSubject(job NotificationJob) string {
+	switch job.Kind {
+	case jobKindInsult:
+		return "【積読キラー】期限切れの本があります"
+	case jobKindMilestone:
+		return "【積読キラー】中間目標を過ぎています"
+	case jobKindEscalation:
+		return "【積読キラー】督促を無視し続けている本があります"
+	case jobKindProject:
+		return "【積読キラー】プロジェクトの進み具合"
+	}
+	return "【積読キラー】お知らせ"
+}
+
+// sendJobEmail はジョブの本文をユーザーの通知用アドレスにメールで送る
+func sendJobEmail(job NotificationJob) error {
+	settings, err := fetchNotifySettings(job.UserID)
+	if err != nil {
+		return err
+	}
+	if settings == nil || !settings.available(notifyChannelEmail) {
+		return errNoNotifyEmail
+	}
+	return sendEmail(*settings.NotifyEmail, notifyEmailSubject(job), plainText(job.Message))
+}
+
+// sendEmail は SendGrid の Mail Send API でテキストメールを送る (SENDGRID_API_KEY, NOTIFY_EMAIL_FROM)
+func sendEmail(to, subject, body string) error {
+	if sandboxMode() {
+		return captureEmail(to, subject, body)
+	}
+	apiKey, from := os.Getenv("SENDGRID_API_KEY"), os.Getenv("NOTIFY_EMAIL_FROM")
+	if apiKey == "" || from == "" {
+		return fmt.Errorf("SENDGRID_API_KEY or NOTIFY_EMAIL_FROM is not set")
+	}
+	mail := newEmailPayload(from, to, subject, body)
+	if err := mail.validate(); err != nil {
+		return err
+	}
+	payload, _ := json.Marshal(mail)
+	req, _ := http.NewRequest(http.MethodPost, "https://api.sendgrid.com/v3/mail/send", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := emailClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("SendGrid API error: %d %s", resp.StatusCode, msg)
+	}
+	return nil
+}
+
+// quietHoursJSON は設定 API で返すおやすみ時間帯 ([開始, 終了] の時、未設定なら null)
+func (s NotifySettings) quietHoursJSON() []int {
+	if s.QuietHoursStart == nil || s.QuietHoursEnd == nil {
+		return nil
+	}
+	return []int{*s.QuietHoursStart, *s.QuietHoursEnd}
+}
+
+// escalationJSON は設定 API で返すエスカレーションの設定 (未設定なら null)
+func (s NotifySettings) escalationJSON() map[string]interface{} {
+	if s.EscalateAfter == nil || s.EscalateTo == nil {
+		return nil
+	}
+	return map[string]interface{}{"after": *s.EscalateAfter, "to": *s.EscalateTo}
+}
+
+// handleNotifySettings は /api/users/notify-channel。
+// GET ?userId=... で確認、PUT {user_id, channel, email, quiet_hours} で設定する (channel の空文字は既定に戻す)。
+// quiet_hours は [22, 8] のような JST の時の組で、空配列で解除する。
+// escalation は {"after": 3, "to": "email"} で、after を 0 にすると止める。
+// cadence は期限切れ後の督促の間隔 (daily, every_3_days, weekly, backoff, once)。空文字で既定に戻す。
+// send_hour は週報などを送る JST の時で、-1 にすると操作履歴から学習した時刻に戻す。
+// certificate を true にすると、読了したときに読了証の画像が LINE に届く。
+func handleNotifySettings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		userId := r.URL.Query().Get("userId")
+		if userId == "" {
+			http.Error(w, "userId required", http.StatusBadRequest)
+			return
+		}
+		settings, err := fetchNotifySettings(userId)
+		if err != nil {
+			log.Printf("[ERROR] handleNotifySettings query error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch settings: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if settings == nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"channel":         settings.NotifyChannel,
+			"email":           settings.NotifyEmail,
+			"default_channel": defaultNotifyChannel(),
+			"effective":       settings.resolve(nil),
+			"quiet_hours":     settings.quietHoursJSON(),
+			"escalation":      settings.escalationJSON(),
+			"cadence":         resolveCadence(nil, settings.ReminderCadence),
+			"send_hour":       settings.sendHourJSON(clock.Now()),
+			"certificate":     settings.CompletionCertificates,
+		})
+
+	case http.MethodPut:
+		var req struct {
+			UserID     string  `json:"user_id"`
+			Channel    string  `json:"channel"`
+			Email      *string `json:"email"`       // 送られてきた場合のみ更新する。空文字で消す。
+			QuietHours *[]int  `json:"quiet_hours"` // 送られてきた場合のみ更新する
+			Escalation *struct {
+				After int    `json:"after"`
+				To    string `json:"to"`
+			} `json:"escalation"` // 送られてきた場合のみ更新する
+			Cadence     *string `json:"cadence"`     // 送られてきた場合のみ更新する
+			SendHour    *int    `json:"send_hour"`   // 送られてきた場合のみ更新する
+			Certificate *bool   `json:"certificate"` // 送られてきた場合のみ更新する
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if !validNotifyChannel(req.Channel) {
+			http.Error(w, "channel must be line or email", http.StatusBadRequest)
+			return
+		}
+		update := map[string]interface{}{"notify_channel": nullIfEmpty(req.Channel), "updated_at": clock.Now()}
+		if req.Email != nil {
+			if *req.Email != "" {
+				addr, err := mail.ParseAddress(*req.Email)
+				if err != nil {
+					http.Error(w, "invalid email", http.StatusBadRequest)
+					return
+				}
+				*req.Email = addr.Address
+			}
+			update["notify_email"] = nullIfEmpty(*req.Email)
+		}
+		if req.QuietHours != nil {
+			switch hours := *req.QuietHours; {
+			case len(hours) == 0:
+				update["quiet_hours_start"], update["quiet_hours_end"] = nil, nil
+			case len(hours) == 2 && hours[0] >= 0 && hours[0] < 24 && hours[1] >= 0 && hours[1] < 24 && hours[0] != hours[1]:
+				update["quiet_hours_start"], update["quiet_hours_end"] = hours[0], hours[1]
+			default:
+				http.Error(w, "quiet_hours must be [start, end] hours between 0 and 23", http.StatusBadRequest)
+				return
+			}
+		}
+		if e := req.Escalation; e != nil {
+			switch {
+			case e.After == 0:
+				update["escalate_after"], update["escalate_to"] = nil, nil
+			case e.After < 1 || !validEscalationTarget(e.To):
+				http.Error(w, "escalation needs after >= 1 and to of line, email or partner", http.StatusBadRequest)
+				return
+			default:
+				update["escalate_after"], update["escalate_to"] = e.After, e.To
+			}
+		}
+		if req.Cadence != nil {
+			if !validReminderCadence(*req.Cadence) {
+				http.Error(w, "unknown cadence", http.StatusBadRequest)
+				return
+			}
+			update["reminder_cadence"] = nullIfEmpty(*req.Cadence)
+		}
+		if h := req.SendHour; h != nil {
+			switch {
+			case *h == -1:
+				update["preferred_send_hour"] = nil
+			case *h < 0 || *h > 23:
+				http.Error(w, "send_hour must be between 0 and 23, or -1 to use the learned hour", http.StatusBadRequest)
+				return
+			default:
+				update["preferred_send_hour"] = *h
+			}
+		}
+		if req.Certificate != nil {
+			update["completion_certificates"] = *req.Certificate
+		}
+		resp, _, err := execute(supabaseClient.From("users").Update(update, "", "").Eq("id", req.UserID))
+		if err != nil {
+			log.Printf("[ERROR] handleNotifySettings update error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to update settings: %v", err), http.StatusInternalServerError)
+			return
+		}
+		var users []NotifySettings
+		if json.Unmarshal(resp, &users); len(users) == 0 {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":     "Notification settings updated",
+			"channel":     users[0].NotifyChannel,
+			"email":       users[0].NotifyEmail,
+			"effective":   users[0].resolve(nil),
+			"quiet_hours": users[0].quietHoursJSON(),
+			"escalation":  users[0].escalationJSON(),
+			"cadence":     resolveCadence(nil, users[0].ReminderCadence),
+			"send_hour":   users[0].sendHourJSON(clock.Now()),
+			"certificate": users[0].CompletionCertificates,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,626 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	notionAPIBase       = "https://api.notion.com/v1"
+	notionAPIVersion    = "2022-06-28"
+	notionTimeout       = 10 * time.Second
+	notionMaxPages      = 1000
+	notionConnCacheTTL  = 10 * time.Minute
+	notionPushQueueSize = 256
+)
+
+// NotionFieldMapping は Notion データベースのプロパティ名と積読ステータスの対応
+type NotionFieldMapping struct {
+	Title        string            `json:"title"`       // タイトル型
+	Author       string            `json:"author"`      // テキスト型 (任意)
+	Status       string            `json:"status"`      // セレクト型またはステータス型
+	StatusType   string            `json:"status_type"` // select, status
+	Deadline     string            `json:"deadline"`    // 日付型
+	StatusValues map[string]string `json:"status_values"`
+}
+
+// NotionConnection はユーザーごとの Notion 連携設定 (notion_connections テーブル)
+type NotionConnection struct {
+	UserID       string             `json:"user_id"`
+	AccessToken  string             `json:"access_token,omitempty"`
+	DatabaseID   string             `json:"database_id"`
+	FieldMapping NotionFieldMapping `json:"field_mapping"`
+	LastSyncedAt *time.Time         `json:"last_synced_at"`
+	CreatedAt    time.Time          `json:"created_at"`
+	UpdatedAt    time.Time          `json:"updated_at"`
+}
+
+// withDefaults は未指定のプロパティ名を Notion の読書リストテンプレートに合わせて埋める
+func (m NotionFieldMapping) withDefaults() NotionFieldMapping {
+	if m.Title == "" {
+		m.Title = "Name"
+	}
+	if m.Status == "" {
+		m.Status = "Status"
+	}
+	if m.StatusType == "" {
+		m.StatusType = "select"
+	}
+	if m.Deadline == "" {
+		m.Deadline = "Deadline"
+	}
+	if m.StatusValues == nil {
+		m.StatusValues = map[string]string{
+			"unread":        "積読",
+			"insulted":      "積読",
+			"reading":       "読書中",
+			"completed":     "読了",
+			statusWishlist:  "欲しい",
+			statusAbandoned: "挫折",
+			statusWaiting:   "入荷待ち",
+		}
+	}
+	return m
+}
+
+// statusFromNotion は Notion の選択肢を積読ステータスに戻す。対応がなければ空文字列。
+func (m NotionFieldMapping) statusFromNotion(name string) string {
+	if name == "" {
+		return ""
+	}
+	// insulted は督促済みの unread なので、戻すときは unread を優先する
+	if m.StatusValues["unread"] == name {
+		return "unread"
+	}
+	for status, v := range m.StatusValues {
+		if v == name {
+			return status
+		}
+	}
+	return ""
+}
+
+// notionPage は databases/{id}/query の結果の1ページ
+type notionPage struct {
+	ID             string                    `json:"id"`
+	Archived       bool                      `json:"archived"`
+	LastEditedTime time.Time                 `json:"last_edited_time"`
+	Properties     map[string]notionProperty `json:"properties"`
+}
+
+type notionProperty struct {
+	Title    []notionText `json:"title"`
+	RichText []notionText `json:"rich_text"`
+	Select   *notionName  `json:"select"`
+	Status   *notionName  `json:"status"`
+	Date     *struct {
+		Start string `json:"start"`
+	} `json:"date"`
+}
+
+type notionText struct {
+	PlainText string `json:"plain_text"`
+}
+
+type notionName struct {
+	Name string `json:"name"`
+}
+
+func joinNotionText(texts []notionText) string {
+	var b strings.Builder
+	for _, t := range texts {
+		b.WriteString(t.PlainText)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// notionFields はページから読み取った同期対象の値
+type notionFields struct {
+	Title    string
+	Author   string
+	Status   string
+	Deadline *time.Time
+}
+
+func (m NotionFieldMapping) read(page notionPage) notionFields {
+	f := notionFields{
+		Title:  joinNotionText(page.Properties[m.Title].Title),
+		Author: joinNotionText(page.Properties[m.Author].RichText),
+	}
+	status := page.Properties[m.Status]
+	if status.Select != nil {
+		f.Status = m.statusFromNotion(status.Select.Name)
+	} else if status.Status != nil {
+		f.Status = m.statusFromNotion(status.Status.Name)
+	}
+	if d := page.Properties[m.Deadline].Date; d != nil {
+		if t, err := time.ParseInLocation("2006-01-02", d.Start, jst); err == nil {
+			f.Deadline = &t
+		} else if t, err := time.Parse(time.RFC3339, d.Start); err == nil {
+			f.Deadline = &t
+		}
+	}
+	return f
+}
+
+// properties は書籍を Notion のプロパティ値にする
+func (m NotionFieldMapping) properties(book Book) map[string]interface{} {
+	props := map[string]interface{}{
+		m.Title: map[string]interface{}{"title": []interface{}{map[string]interface{}{"text": map[string]string{"content": book.Title}}}},
+	}
+	if m.Author != "" {
+		props[m.Author] = map[string]interface{}{"rich_text": []interface{}{map[string]interface{}{"text": map[string]string{"content": book.Author}}}}
+	}
+	if name, ok := m.StatusValues[book.Status]; ok {
+		props[m.Status] = map[string]interface{}{m.StatusType: map[string]string{"name": name}}
+	}
+	if book.Deadline.IsZero() || deadlineless(book.Status) {
+		props[m.Deadline] = map[string]interface{}{"date": nil}
+	} else {
+		props[m.Deadline] = map[string]interface{}{"date": map[string]string{"start": book.Deadline.In(jst).Format("2006-01-02")}}
+	}
+	return props
+}
+
+var notionClient = &http.Client{Timeout: notionTimeout}
+
+// notionRequest は Notion API を呼び、成功時のレスポンスを out に読み込む
+func notionRequest(ctx context.Context, token, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, notionAPIBase+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Notion-Version", notionAPIVersion)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := notionClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("notion API error (%d %s): %s", resp.StatusCode, apiErr.Code, apiErr.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// queryNotionPages はデータベースの全ページをページングしながら取得する
+func queryNotionPages(ctx context.Context, conn NotionConnection) ([]notionPage, error) {
+	var pages []notionPage
+	cursor := ""
+	for len(pages) < notionMaxPages {
+		body := map[string]interface{}{"page_size": 100}
+		if cursor != "" {
+			body["start_cursor"] = cursor
+		}
+		var result struct {
+			Results    []notionPage `json:"results"`
+			HasMore    bool         `json:"has_more"`
+			NextCursor string       `json:"next_cursor"`
+		}
+		if err := notionRequest(ctx, conn.AccessToken, http.MethodPost, "/databases/"+conn.DatabaseID+"/query", body, &result); err != nil {
+			return nil, err
+		}
+		pages = append(pages, result.Results...)
+		if !result.HasMore {
+			break
+		}
+		cursor = result.NextCursor
+	}
+	return pages, nil
+}
+
+// pushBookToNotion は書籍をページに書き出す。未連携ならページを作り notion_page_id を保存する。
+func pushBookToNotion(ctx context.Context, conn NotionConnection, book Book) error {
+	props := conn.FieldMapping.properties(book)
+	if book.NotionPageID != nil && *book.NotionPageID != "" {
+		return notionRequest(ctx, conn.AccessToken, http.MethodPatch, "/pages/"+*book.NotionPageID, map[string]interface{}{"properties": props}, nil)
+	}
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := notionRequest(ctx, conn.AccessToken, http.MethodPost, "/pages", map[string]interface{}{
+		"parent":     map[string]string{"database_id": conn.DatabaseID},
+		"properties": props,
+	}, &created); err != nil {
+		return err
+	}
+	// updated_at は変えない (次回同期で Notion 側の変更と誤認しないため)
+	if _, _, err := execute(supabaseClient.From("books").Update(map[string]interface{}{"notion_page_id": created.ID}, "minimal", "").Eq("book_id", book.BookID)); err != nil {
+		return err
+	}
+	invalidateBooks(book.UserID)
+	return nil
+}
+
+// NotionSyncReport は1ユーザー分の同期結果
+type NotionSyncReport struct {
+	Pulled  int      `json:"pulled"`  // Notion → 積読
+	Pushed  int      `json:"pushed"`  // 積読 → Notion
+	Created int      `json:"created"` // Notion のページから新規登録した本
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// syncNotion は双方向に同期する。前回同期以降に両方で変更された場合は新しい方を採用する。
+// Notion の last_edited_time は分単位に丸められるため、同じ分の変更は積読側を優先する。
+func syncNotion(ctx context.Context, conn NotionConnection) (*NotionSyncReport, error) {
+	startedAt := time.Now()
+	pages, err := queryNotionPages(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	books, _, err := loadUserBooks(conn.UserID)
+	if err != nil {
+		return nil, err
+	}
+	var since time.Time
+	if conn.LastSyncedAt != nil {
+		since = *conn.LastSyncedAt
+	}
+
+	byPage := make(map[string]Book)
+	for _, b := range books {
+		if b.NotionPageID != nil && *b.NotionPageID != "" {
+			byPage[*b.NotionPageID] = b
+		}
+	}
+	report := &NotionSyncReport{}
+	fail := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		log.Printf("[WARNING] notion sync for user %s: %s", conn.UserID, msg)
+		report.Errors = append(report.Errors, msg)
+	}
+
+	seenPages := make(map[string]bool, len(pages))
+	for _, page := range pages {
+		seenPages[page.ID] = true
+		if page.Archived {
+			continue
+		}
+		fields := conn.FieldMapping.read(page)
+		book, linked := byPage[page.ID]
+		switch {
+		case !linked:
+			if fields.Title == "" {
+				continue
+			}
+			if err := createBookFromNotion(conn, page.ID, fields); err != nil {
+				fail("create from page %s: %v", page.ID, err)
+				continue
+			}
+			report.Created++
+		case page.LastEditedTime.After(since) && page.LastEditedTime.After(book.UpdatedAt):
+			changed, err := pullNotionFields(ctx, book, fields)
+			if err != nil {
+				fail("pull page %s: %v", page.ID, err)
+				continue
+			}
+			if changed {
+				report.Pulled++
+			}
+		case book.UpdatedAt.After(since):
+			if err := pushBookToNotion(ctx, conn, book); err != nil {
+				fail("push book %s: %v", book.BookID, err)
+				continue
+			}
+			report.Pushed++
+		}
+	}
+
+	// Notion 側にまだない本はページを作る (削除されたページに紐づいていた本も作り直す)
+	for _, book := range books {
+		if book.NotionPageID != nil && seenPages[*book.NotionPageID] {
+			continue
+		}
+		book.NotionPageID = nil
+		if err := pushBookToNotion(ctx, conn, book); err != nil {
+			fail("push book %s: %v", book.BookID, err)
+			continue
+		}
+		report.Pushed++
+	}
+
+	if _, _, err := execute(supabaseClient.From("notion_connections").
+		Update(map[string]interface{}{"last_synced_at": startedAt}, "minimal", "").
+		Eq("user_id", conn.UserID)); err != nil {
+		return report, err
+	}
+	appCache.Delete(notionCacheKey(conn.UserID))
+	if report.Created > 0 || report.Pulled > 0 {
+		emitBookEvent(BookEvent{Type: "book.synced", UserID: conn.UserID})
+	}
+	return report, nil
+}
+
+// createBookFromNotion は Notion にだけある本を登録する
+func createBookFromNotion(conn NotionConnection, pageID string, f notionFields) error {
+	book := Book{Title: f.Title, Author: f.Author, Status: f.Status}
+	if book.Author == "" {
+		book.Author = "不明"
+	}
+	if book.Status == "" || book.Status == "insulted" {
+		book.Status = "unread"
+	}
+	book.Deadline = clock.Now().Add(defaultDeadlineOffset)
+	if f.Deadline != nil {
+		book.Deadline = *f.Deadline
+	}
+	_, _, err := executeOnce(supabaseClient.From("books").Insert(map[string]interface{}{
+		"user_id":        conn.UserID,
+		"title":          book.Title,
+		"author":         book.Author,
+		"status":         book.Status,
+		"deadline":       deadlineValue(book),
+		"insult_level":   0,
+		"format":         "paperback",
+		"notion_page_id": pageID,
+	}, false, "", "minimal", ""))
+	return err
+}
+
+// pullNotionFields は Notion の値で書籍を更新する。読了への変更は completeBook を通して記録を残す。
+func pullNotionFields(ctx context.Context, book Book, f notionFields) (bool, error) {
+	update := map[string]interface{}{}
+	if f.Title != "" && f.Title != book.Title {
+		update["title"] = f.Title
+	}
+	if f.Deadline != nil && !deadlineless(book.Status) && !f.Deadline.Equal(book.Deadline) &&
+		f.Deadline.In(jst).Format("2006-01-02") != book.Deadline.In(jst).Format("2006-01-02") {
+		update["deadline"] = *f.Deadline
+	}
+	completing := f.Status == "completed" && book.Status != "completed"
+	if f.Status != "" && f.Status != book.Status && !completing && !(f.Status == "unread" && book.Status == "insulted") {
+		update["status"] = f.Status
+	}
+
+	if len(update) > 0 {
+		update["updated_at"] = clock.Now()
+		rawResp, _, err := execute(supabaseClient.From("books").Update(update, "", "").Eq("book_id", book.BookID))
+		if err != nil {
+			return false, err
+		}
+		emitBookRows("book.updated", rawResp)
+	}
+	if completing {
+		if _, err := completeBook(ctx, book.BookID); err != nil && err != errAlreadyCompleted {
+			return len(update) > 0, err
+		}
+		return true, nil
+	}
+	return len(update) > 0, nil
+}
+
+func notionCacheKey(userID string) string { return "notion:" + userID }
+
+// notionConnectionFor はユーザーの連携設定を返す。未連携なら nil。
+func notionConnectionFor(userID string) (*NotionConnection, error) {
+	raw, ok := appCache.Get(notionCacheKey(userID))
+	if !ok {
+		var err error
+		raw, _, err = execute(supabaseClient.From("notion_connections").Select("*", "", false).Eq("user_id", userID))
+		if err != nil {
+			return nil, err
+		}
+		appCache.Set(notionCacheKey(userID), raw, notionConnCacheTTL)
+	}
+	var conns []NotionConnection
+	if err := json.Unmarshal(raw, &conns); err != nil {
+		return nil, err
+	}
+	if len(conns) == 0 {
+		return nil, nil
+	}
+	conn := conns[0]
+	conn.FieldMapping = conn.FieldMapping.withDefaults()
+	return &conn, nil
+}
+
+// notionPushes は書籍イベントを Notion へ即時反映するためのキュー
+var notionPushes = make(chan BookEvent, notionPushQueueSize)
+
+// queueNotionPush は emitBookEvent から呼ばれる。キューが詰まっていれば次回の定期同期に任せる。
+func queueNotionPush(ev BookEvent) {
+	if ev.Book == nil {
+		return
+	}
+	select {
+	case notionPushes <- ev:
+	default:
+		log.Printf("[WARNING] notion push queue full, dropping %s for book %s", ev.Type, ev.BookID)
+	}
+}
+
+// startNotionPushWorker は書籍の変更を連携済みユーザーの Notion へ書き出すワーカーを起動する
+func startNotionPushWorker() {
+	go func() {
+		for ev := range notionPushes {
+			conn, err := notionConnectionFor(ev.UserID)
+			if err != nil {
+				log.Printf("[ERROR] notion connection lookup for user %s: %v", ev.UserID, err)
+				continue
+			}
+			if conn == nil {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), notionTimeout)
+			if ev.Type == "book.deleted" {
+				if ev.Book.NotionPageID != nil {
+					err = notionRequest(ctx, conn.AccessToken, http.MethodPatch, "/pages/"+*ev.Book.NotionPageID, map[string]interface{}{"archived": true}, nil)
+				}
+			} else {
+				err = pushBookToNotion(ctx, *conn, *ev.Book)
+			}
+			cancel()
+			if err != nil {
+				log.Printf("[WARNING] notion push %s for book %s failed: %v", ev.Type, ev.BookID, err)
+			}
+		}
+	}()
+}
+
+// handleNotionConnection は /api/integrations/notion (GET: 設定の確認, PUT: 連携・マッピング更新, DELETE: 解除)
+func handleNotionConnection(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		userId := r.URL.Query().Get("userId")
+		if userId == "" {
+			http.Error(w, "userId required", http.StatusBadRequest)
+			return
+		}
+		conn, err := notionConnectionFor(userId)
+		if err != nil {
+			log.Printf("[ERROR] handleNotionConnection query error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch notion connection: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if conn == nil {
+			http.Error(w, "Notion is not connected", http.StatusNotFound)
+			return
+		}
+		conn.AccessToken = "" // トークンは返さない
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(conn)
+
+	case http.MethodPut:
+		var conn NotionConnection
+		if err := json.NewDecoder(r.Body).Decode(&conn); err != nil || conn.UserID == "" || conn.AccessToken == "" || conn.DatabaseID == "" {
+			http.Error(w, "user_id, access_token and database_id required", http.StatusBadRequest)
+			return
+		}
+		conn.FieldMapping = conn.FieldMapping.withDefaults()
+		if conn.FieldMapping.StatusType != "select" && conn.FieldMapping.StatusType != "status" {
+			http.Error(w, "status_type must be select or status", http.StatusBadRequest)
+			return
+		}
+		// トークンとデータベースが有効かを先に確かめる
+		if err := notionRequest(r.Context(), conn.AccessToken, http.MethodGet, "/databases/"+conn.DatabaseID, nil, nil); err != nil {
+			http.Error(w, fmt.Sprintf("cannot access Notion database: %v", err), http.StatusBadRequest)
+			return
+		}
+		_, _, err := execute(supabaseClient.From("notion_connections").Insert(map[string]interface{}{
+			"user_id":       conn.UserID,
+			"access_token":  conn.AccessToken,
+			"database_id":   conn.DatabaseID,
+			"field_mapping": conn.FieldMapping,
+			"updated_at":    clock.Now(),
+		}, true, "user_id", "minimal", ""))
+		if err != nil {
+			log.Printf("[ERROR] handleNotionConnection upsert error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to save notion connection: %v", err), http.StatusInternalServerError)
+			return
+		}
+		appCache.Delete(notionCacheKey(conn.UserID))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Notion connected"})
+
+	case http.MethodDelete:
+		userId := r.URL.Query().Get("userId")
+		if userId == "" {
+			http.Error(w, "userId required", http.StatusBadRequest)
+			return
+		}
+		if _, _, err := execute(supabaseClient.From("notion_connections").Delete("minimal", "").Eq("user_id", userId)); err != nil {
+			log.Printf("[ERROR] handleNotionConnection delete error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to disconnect notion: %v", err), http.StatusInternalServerError)
+			return
+		}
+		appCache.Delete(notionCacheKey(userId))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Notion disconnected"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleNotionSync は POST /api/integrations/notion/sync。ユーザーが手動で同期する。
+func handleNotionSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	conn, err := notionConnectionFor(req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to fetch notion connection: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if conn == nil {
+		http.Error(w, "Notion is not connected", http.StatusNotFound)
+		return
+	}
+	report, err := syncNotion(r.Context(), *conn)
+	if err != nil {
+		log.Printf("[ERROR] handleNotionSync user %s: %v", req.UserID, err)
+		http.Error(w, fmt.Sprintf("notion sync failed: %v", err), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// handleNotionSyncCron は /api/cron/notion-sync。連携済みの全ユーザーを順に同期する。
+func handleNotionSyncCron(w http.ResponseWriter, r *http.Request) {
+	if !authorizeCron(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	resp, _, err := execute(supabaseClient.From("notion_connections").Select("*", "", false))
+	if err != nil {
+		log.Printf("[ERROR] handleNotionSyncCron query error: %v", err)
+		opsAlerts.recordCron("notion-sync", err, clock.Now())
+		http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var conns []NotionConnection
+	if err := json.Unmarshal(resp, &conns); err != nil {
+		log.Printf("[ERROR] handleNotionSyncCron unmarshal error: %v", err)
+	}
+
+	synced, failed := 0, 0
+	for _, conn := range conns {
+		conn.FieldMapping = conn.FieldMapping.withDefaults()
+		if _, err := syncNotion(r.Context(), conn); err != nil {
+			log.Printf("[ERROR] notion sync for user %s failed: %v", conn.UserID, err)
+			failed++
+			continue
+		}
+		synced++
+	}
+	log.Printf("[INFO] notion sync finished: %d synced, %d failed", synced, failed)
+
+	opsAlerts.recordCron("notion-sync", nil, clock.Now())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Notion sync completed", "synced": synced, "failed": failed})
+}
@@ -0,0 +1,278 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 初回の案内 (オンボーディング)。LINE 連携 → 既定値の設定 → 本を3冊登録 → 最初の期限、の順に進める。
+// 進み具合は user_onboarding に保存し、アプリ (GET/POST /api/onboarding) と LINE (友だち追加時と「はじめかた」) の
+// どちらから来ても続きから案内する。実際に済んでいる手順 (連携済み・登録済みなど) は見に行ったときに自動で済みにする。
+const (
+	onboardingStepLinkLine      = "link_line"
+	onboardingStepPreferences   = "preferences"
+	onboardingStepRegisterBooks = "register_books"
+	onboardingStepFirstDeadline = "first_deadline"
+	onboardingStepDone          = "done"
+
+	onboardingBooksRequired = 3
+	onboardingLineCommand   = "はじめかた"
+)
+
+var onboardingSteps = []string{onboardingStepLinkLine, onboardingStepPreferences, onboardingStepRegisterBooks, onboardingStepFirstDeadline}
+
+// onboardingPrompts は手順ごとの LINE での案内
+var onboardingPrompts = map[string]string{
+	onboardingStepLinkLine:      "メニューの「アカウント連携」から LINE とアカウントをつなぎましょう。",
+	onboardingStepPreferences:   "アプリの設定で、登録するときの期限の目安と督促の厳しさを決めましょう。",
+	onboardingStepRegisterBooks: "積んでいる本を%d冊登録しましょう (あと%d冊)。",
+	onboardingStepFirstDeadline: "登録した本に読了期限を付けましょう。期限を過ぎると督促が届きます。",
+}
+
+// Onboarding は user_onboarding の1行
+type Onboarding struct {
+	UserID         string     `json:"user_id"`
+	CurrentStep    string     `json:"current_step"`
+	CompletedSteps []string   `json:"completed_steps"`
+	SkippedSteps   []string   `json:"skipped_steps"`
+	StartedAt      time.Time  `json:"started_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	CompletedAt    *time.Time `json:"completed_at"`
+}
+
+// onboardingFacts は手順が実際に済んでいるかを判断する材料
+type onboardingFacts struct {
+	lineLinked     bool
+	hasPreferences bool
+	books          int
+	hasDeadline    bool
+}
+
+func (f onboardingFacts) satisfied(step string) bool {
+	switch step {
+	case onboardingStepLinkLine:
+		return f.lineLinked
+	case onboardingStepPreferences:
+		return f.hasPreferences
+	case onboardingStepRegisterBooks:
+		return f.books >= onboardingBooksRequired
+	case onboardingStepFirstDeadline:
+		return f.hasDeadline
+	}
+	return false
+}
+
+func loadOnboardingFacts(userID string) (onboardingFacts, error) {
+	var f onboardingFacts
+	resp, _, err := execute(supabaseClient.From("users").
+		Select("line_user_id, default_deadline_offset, default_insult_level, default_tags", "", false).
+		Eq("id", userID))
+	if err != nil {
+		return f, err
+	}
+	var users []struct {
+		LineUserID *string `json:"line_user_id"`
+		UserDefaults
+	}
+	if json.Unmarshal(resp, &users); len(users) > 0 {
+		u := users[0]
+		f.lineLinked = u.LineUserID != nil && *u.LineUserID != ""
+		f.hasPreferences = u.DeadlineOffset != nil || u.InsultLevel != nil || len(u.Tags) > 0
+	}
+	_, n, err := execute(supabaseClient.From("books").Select("book_id", "exact", true).Eq("user_id", userID))
+	if err != nil {
+		return f, err
+	}
+	f.books = int(n)
+	_, n, err = execute(supabaseClient.From("books").Select("book_id", "exact", true).Eq("user_id", userID).Not("deadline", "is", "null"))
+	if err != nil {
+		return f, err
+	}
+	f.hasDeadline = n > 0
+	return f, nil
+}
+
+// advance は済んだ手順を completed_steps に入れ、current_step を最初の未完了の手順にする。変わったら true。
+func (o *Onboarding) advance(f onboardingFacts, now time.Time) bool {
+	before := o.CurrentStep
+	o.CurrentStep = onboardingStepDone
+	for _, step := range onboardingSteps {
+		if slices.Contains(o.CompletedSteps, step) || slices.Contains(o.SkippedSteps, step) {
+			continue
+		}
+		if f.satisfied(step) {
+			o.CompletedSteps = append(o.CompletedSteps, step)
+			continue
+		}
+		o.CurrentStep = step
+		break
+	}
+	if o.CurrentStep == onboardingStepDone && o.CompletedAt == nil {
+		o.CompletedAt = &now
+	}
+	return o.CurrentStep != before
+}
+
+// loadOnboarding はユーザーの進み具合を読み、実際に済んでいる手順を反映して保存する。初めてなら作る。
+func loadOnboarding(userID string) (*Onboarding, onboardingFacts, error) {
+	facts, err := loadOnboardingFacts(userID)
+	if err != nil {
+		return nil, facts, err
+	}
+	resp, _, err := execute(supabaseClient.From("user_onboarding").Select("*", "", false).Eq("user_id", userID))
+	if err != nil {
+		return nil, facts, err
+	}
+	var rows []Onboarding
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		return nil, facts, err
+	}
+	now := clock.Now()
+	o := &Onboarding{UserID: userID, CompletedSteps: []string{}, SkippedSteps: []string{}, StartedAt: now}
+	if len(rows) > 0 {
+		o = &rows[0]
+	}
+	if o.advance(facts, now) || len(rows) == 0 {
+		if err := saveOnboarding(o, now); err != nil {
+			return nil, facts, err
+		}
+	}
+	return o, facts, nil
+}
+
+func saveOnboarding(o *Onboarding, now time.Time) error {
+	o.UpdatedAt = now
+	_, _, err := execute(supabaseClient.From("user_onboarding").Insert(map[string]interface{}{
+		"user_id":         o.UserID,
+		"current_step":    o.CurrentStep,
+		"completed_steps": o.CompletedSteps,
+		"skipped_steps":   o.SkippedSteps,
+		"started_at":      o.StartedAt,
+		"updated_at":      now,
+		"completed_at":    o.CompletedAt,
+	}, true, "user_id", "minimal", ""))
+	return err
+}
+
+// onboardingJSON は画面に出す形。手順ごとに done / skipped / current / pending を付ける。
+func onboardingJSON(o *Onboarding, f onboardingFacts) map[string]interface{} {
+	steps := make([]map[string]interface{}, 0, len(onboardingSteps))
+	for _, step := range onboardingSteps {
+		status := "pending"
+		switch {
+		case slices.Contains(o.CompletedSteps, step):
+			status = "done"
+		case slices.Contains(o.SkippedSteps, step):
+			status = "skipped"
+		case step == o.CurrentStep:
+			status = "current"
+		}
+		steps = append(steps, map[string]interface{}{"step": step, "status": status})
+	}
+	return map[string]interface{}{
+		"current_step":     o.CurrentStep,
+		"steps":            steps,
+		"books_registered": f.books,
+		"books_required":   onboardingBooksRequired,
+		"started_at":       o.StartedAt,
+		"completed_at":     o.CompletedAt,
+	}
+}
+
+// handleOnboarding は /api/onboarding。
+// GET: 進み具合を返す。
+// POST {action, step}: complete (既定値の設定をそのまま使う場合など、手順を済みにする。ほかの手順は実際に済んでいる必要がある)、
+// skip (手順を飛ばす)、restart (最初からやり直す)。
+func handleOnboarding(w http.ResponseWriter, r *http.Request) {
+	session, err := authenticateSession(r)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	o, facts, err := loadOnboarding(session.UserID)
+	if err != nil {
+		log.Printf("[ERROR] handleOnboarding query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to load onboarding: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if r.Method == http.MethodPost {
+		var req struct {
+			Action string `json:"action"`
+			Step   string `json:"step"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if req.Action != "restart" && !slices.Contains(onboardingSteps, req.Step) {
+			http.Error(w, fmt.Sprintf("step must be one of %s", strings.Join(onboardingSteps, ", ")), http.StatusBadRequest)
+			return
+		}
+		now := clock.Now()
+		switch req.Action {
+		case "complete":
+			if req.Step != onboardingStepPreferences && !facts.satisfied(req.Step) {
+				http.Error(w, fmt.Sprintf("step %s is not finished yet", req.Step), http.StatusConflict)
+				return
+			}
+			if !slices.Contains(o.CompletedSteps, req.Step) {
+				o.CompletedSteps = append(o.CompletedSteps, req.Step)
+			}
+		case "skip":
+			if !slices.Contains(o.CompletedSteps, req.Step) && !slices.Contains(o.SkippedSteps, req.Step) {
+				o.SkippedSteps = append(o.SkippedSteps, req.Step)
+			}
+		case "restart":
+			o.CompletedSteps, o.SkippedSteps, o.StartedAt, o.CompletedAt = []string{}, []string{}, now, nil
+		default:
+			http.Error(w, "action must be complete, skip or restart", http.StatusBadRequest)
+			return
+		}
+		o.advance(facts, now)
+		if err := saveOnboarding(o, now); err != nil {
+			log.Printf("[ERROR] handleOnboarding update error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to update onboarding: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(onboardingJSON(o, facts))
+}
+
+// onboardingLineReply は LINE ユーザーの次の手順の案内を返す。まだアカウントがない、または終わっていれば ok = false。
+func onboardingLineReply(lineUserID string) (reply string, ok bool) {
+	resp, _, err := execute(supabaseClient.From("users").Select("id", "", false).Eq("line_user_id", lineUserID))
+	if err != nil {
+		log.Printf("[ERROR] onboarding user lookup error: %v", err)
+		return "", false
+	}
+	var users []struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(resp, &users); len(users) == 0 {
+		return "", false
+	}
+	o, facts, err := loadOnboarding(users[0].ID)
+	if err != nil {
+		log.Printf("[ERROR] onboarding lookup error for %s: %v", lineUserID, err)
+		return "", false
+	}
+	if o.CurrentStep == onboardingStepDone {
+		return "", false
+	}
+	prompt := onboardingPrompts[o.CurrentStep]
+	if o.CurrentStep == onboardingStepRegisterBooks {
+		prompt = fmt.Sprintf(prompt, onboardingBooksRequired, onboardingBooksRequired-facts.books)
+	}
+	done := len(o.CompletedSteps) + len(o.SkippedSteps)
+	return fmt.Sprintf("【はじめかた %d/%d】%s", done+1, len(onboardingSteps), prompt), true
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	overdueSoonDays     = 7  // 「今週が期限」とみなす残り日数
+	overdueRecentDays   = 7  // これ未満の超過は「最近過ぎた」
+	overdueHopelessDays = 30 // これを超えたら「手遅れ」
+)
+
+// OverdueSummary はホーム画面用の期限の集計。GET /api/books/overdue-summary の応答。
+type OverdueSummary struct {
+	Buckets      OverdueBuckets `json:"buckets"`
+	MostOverdue  *DeadlineBook  `json:"most_overdue"`  // 期限を最も長く過ぎている本
+	NextDeadline *DeadlineBook  `json:"next_deadline"` // 次に期限が来る本
+}
+
+// OverdueBuckets は読書中・未読の本を期限までの日数で分けた冊数
+type OverdueBuckets struct {
+	DueThisWeek int `json:"due_this_week"` // 7日以内に期限
+	DueLater    int `json:"due_later"`     // 期限まで7日以上
+	Overdue7d   int `json:"overdue_lt_7d"` // 超過7日未満
+	Overdue30d  int `json:"overdue_7_30d"` // 超過7〜30日
+	Hopeless    int `json:"hopeless"`      // 超過30日超
+}
+
+// DeadlineBook は期限付きの本と期限までの日数 (負の値は超過日数)
+type DeadlineBook struct {
+	BookRef
+	DaysRemaining int `json:"days_remaining"`
+}
+
+// summarizeOverdue は期限の集計を作る。期限のない本・アーカイブした本と読了・中断・欲しい本は数えない。
+func summarizeOverdue(books []Book, now time.Time) OverdueSummary {
+	var s OverdueSummary
+	for _, b := range books {
+		if !slices.Contains(activeStatuses, b.Status) || b.Deadline.IsZero() || b.Archived {
+			continue
+		}
+		ref := &DeadlineBook{BookRef: BookRef{BookID: b.BookID, Title: b.Title, Deadline: b.Deadline}}
+		if b.Deadline.After(now) {
+			ref.DaysRemaining = int(b.Deadline.Sub(now).Hours() / 24)
+			if ref.DaysRemaining < overdueSoonDays {
+				s.Buckets.DueThisWeek++
+			} else {
+				s.Buckets.DueLater++
+			}
+			if s.NextDeadline == nil || b.Deadline.Before(s.NextDeadline.Deadline) {
+				s.NextDeadline = ref
+			}
+			continue
+		}
+		overdue := int(now.Sub(b.Deadline).Hours() / 24)
+		ref.DaysRemaining = -overdue
+		switch {
+		case overdue < overdueRecentDays:
+			s.Buckets.Overdue7d++
+		case overdue <= overdueHopelessDays:
+			s.Buckets.Overdue30d++
+		default:
+			s.Buckets.Hopeless++
+		}
+		if s.MostOverdue == nil || b.Deadline.Before(s.MostOverdue.Deadline) {
+			s.MostOverdue = ref
+		}
+	}
+	return s
+}
+
+// handleOverdueSummary は GET /api/books/overdue-summary?userId=...
+func handleOverdueSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+	books, _, err := loadUserBooks(userId)
+	if err != nil {
+		log.Printf("[ERROR] handleOverdueSummary error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch books: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summarizeOverdue(books, clock.Now()))
+}
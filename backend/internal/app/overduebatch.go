@@ -0,0 +1,217 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 1回の期限チェックで同じユーザーの督促が OVERDUE_BATCH_THRESHOLD 件 (既定 5、0 で無効) 以上になったら、
+// 本ごとに push せず、放置の長い順に並べた「ワースト5」の Flex カルーセル1通にまとめる。
+// 全件は LIFF の一覧 (/books?filter=overdue) で見られる。まとめた督促は全巻を book_ids に持つので、送信後は全部 insulted になる。
+const (
+	overdueBatchTemplate = "worst5" // 効果測定 (effectiveness.go) で個別の督促と分けるためのテンプレートキー
+	overdueBatchSize     = 5
+	overdueListPath      = "/books?filter=overdue"
+)
+
+// dueReminder は今回の期限チェックで送ることになった督促1通 (シリーズなら複数巻)
+type dueReminder struct {
+	group      []Book
+	template   string
+	message    string
+	channel    string
+	lineUserID string
+	settings   *NotifySettings
+}
+
+func overdueBatchThreshold() int {
+	return envInt("OVERDUE_BATCH_THRESHOLD", overdueBatchSize)
+}
+
+// batchKey はまとめる単位。送り先のチャネルが違う督促は別に送る。
+func (d dueReminder) batchKey() string {
+	return d.group[0].UserID + ":" + d.channel
+}
+
+// rankOverdue は放置の長い順 (期限の古い順)、同じなら督促レベルの高い順に並べる
+func rankOverdue(reminders []dueReminder) {
+	slices.SortStableFunc(reminders, func(a, b dueReminder) int {
+		if c := a.group[0].Deadline.Compare(b.group[0].Deadline); c != 0 {
+			return c
+		}
+		return b.group[0].InsultLevel - a.group[0].InsultLevel
+	})
+}
+
+func daysOverdue(book Book, now time.Time) int {
+	return int(now.Sub(book.Deadline).Hours() / 24)
+}
+
+// overdueListURL は全件を見られる LIFF のページ。LIFF_URL も FRONTEND_URL もなければ空文字。
+func overdueListURL() string {
+	if u := liffURL(overdueListPath); u != overdueListPath {
+		return u
+	}
+	return ""
+}
+
+// reminderLabel はまとめた督促での1行分の書名。シリーズなら巻数を添える。
+func reminderLabel(d dueReminder) string {
+	if len(d.group) > 1 {
+		return fmt.Sprintf("『%s』ほか%d巻", d.group[0].Title, len(d.group)-1)
+	}
+	return fmt.Sprintf("『%s』", d.group[0].Title)
+}
+
+// overdueBatchText は通知欄の代替テキストとメールの本文
+func overdueBatchText(ranked []dueReminder, total int, now time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "📚 期限切れの本が%d冊たまっています。放置ワースト%d:", total, min(len(ranked), overdueBatchSize))
+	for i, d := range ranked[:min(len(ranked), overdueBatchSize)] {
+		fmt.Fprintf(&b, "\n%d. %s 期限から%d日", i+1, reminderLabel(d), daysOverdue(d.group[0], now))
+	}
+	if u := overdueListURL(); u != "" {
+		fmt.Fprintf(&b, "\n全部見る: %s", u)
+	}
+	return b.String()
+}
+
+// overdueBatchCarousel はワースト5を1冊1枚のカルーセルにする。残りがあれば最後に「ほかN冊」の1枚を付ける。
+func overdueBatchCarousel(ranked []dueReminder, total int, now time.Time) (json.RawMessage, error) {
+	listURL := overdueListURL()
+	bubbles := make([]interface{}, 0, overdueBatchSize+1)
+	for i, d := range ranked[:min(len(ranked), overdueBatchSize)] {
+		book := d.group[0]
+		body := []interface{}{
+			flexText(fmt.Sprintf("ワースト%d", i+1), map[string]interface{}{"size": "xs", "color": "#e0533d", "weight": "bold"}),
+			flexText(reminderLabel(d), map[string]interface{}{"size": "md", "weight": "bold", "margin": "sm"}),
+		}
+		if book.Author != "" {
+			body = append(body, flexText(book.Author, map[string]interface{}{"size": "xs", "color": "#888888"}))
+		}
+		body = append(body,
+			map[string]interface{}{"type": "separator", "margin": "md"},
+			flexRow("期限から", fmt.Sprintf("%d日", daysOverdue(book, now))),
+			flexRow("督促レベル", fmt.Sprintf("%d", book.InsultLevel)),
+		)
+		if book.Price != nil {
+			body = append(body, flexRow("眠っている金額", fmt.Sprintf("%d円", *book.Price)))
+		}
+		bubble := map[string]interface{}{
+			"type": "bubble",
+			"size": "kilo",
+			"body": map[string]interface{}{"type": "box", "layout": "vertical", "spacing": "sm", "contents": body},
+		}
+		bubbles = append(bubbles, bubble)
+	}
+	if rest := total - min(len(ranked), overdueBatchSize); rest > 0 || listURL != "" {
+		body := []interface{}{flexText("期限切れの本の一覧", map[string]interface{}{"size": "md", "weight": "bold"})}
+		if rest > 0 {
+			body = append(body, flexText(fmt.Sprintf("ほか%d件の督促があります", rest), map[string]interface{}{"size": "sm", "margin": "md"}))
+		}
+		bubble := map[string]interface{}{
+			"type": "bubble",
+			"size": "kilo",
+			"body": map[string]interface{}{"type": "box", "layout": "vertical", "spacing": "sm", "contents": body},
+		}
+		if listURL != "" {
+			bubble["footer"] = flexLinkFooter("全部見る", listURL)
+		}
+		bubbles = append(bubbles, bubble)
+	}
+	return json.Marshal(map[string]interface{}{"type": "carousel", "contents": bubbles})
+}
+
+func flexLinkFooter(label, uri string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":   "box",
+		"layout": "vertical",
+		"contents": []interface{}{map[string]interface{}{
+			"type":   "button",
+			"style":  "link",
+			"action": map[string]string{"type": "uri", "label": label, "uri": uri},
+		}},
+	}
+}
+
+// enqueueOverdueBatch は同じユーザー・同じ送り先の督促をまとめた1通を積む
+func enqueueOverdueBatch(reminders []dueReminder, now time.Time) error {
+	rankOverdue(reminders)
+	var ids []string
+	for _, d := range reminders {
+		for _, b := range d.group {
+			ids = append(ids, b.BookID)
+		}
+	}
+	worst := reminders[0]
+	job := NotificationJob{
+		Kind:        jobKindInsult,
+		BookID:      worst.group[0].BookID,
+		BookIDs:     ids,
+		UserID:      worst.group[0].UserID,
+		Channel:     worst.channel,
+		LineUserID:  worst.lineUserID,
+		Message:     overdueBatchText(reminders, len(reminders), now),
+		Template:    overdueBatchTemplate,
+		InsultLevel: &worst.group[0].InsultLevel,
+	}
+	if worst.channel == notifyChannelLine {
+		payload, err := overdueBatchCarousel(reminders, len(reminders), now)
+		if err != nil {
+			return err
+		}
+		job.Payload = payload
+	}
+	job.RunAt = now
+	return enqueueJob(job)
+}
+
+// enqueueDueReminders は集めた督促を積み、積んだ通数を返す。閾値以上たまった送り先はまとめて1通にする。
+// エスカレーションと deadline_missed のイベントは、まとめたかどうかに関わらず督促ごとに扱う。
+func enqueueDueReminders(due []dueReminder, now time.Time) int {
+	var keys []string
+	byKey := make(map[string][]dueReminder)
+	for _, d := range due {
+		k := d.batchKey()
+		if _, ok := byKey[k]; !ok {
+			keys = append(keys, k)
+		}
+		byKey[k] = append(byKey[k], d)
+	}
+
+	count := 0
+	threshold := overdueBatchThreshold()
+	for _, k := range keys {
+		reminders := byKey[k]
+		if threshold > 0 && len(reminders) >= threshold {
+			if err := enqueueOverdueBatch(reminders, now); err != nil {
+				log.Printf("[ERROR] Failed to enqueue batched notification for user %s (%d books): %v", reminders[0].group[0].UserID, len(reminders), err)
+				continue
+			}
+			log.Printf("[INFO] batched %d overdue reminders into one %s message for user %s", len(reminders), reminders[0].channel, reminders[0].group[0].UserID)
+			count++
+		} else {
+			sent := reminders[:0:0]
+			for _, d := range reminders {
+				if err := enqueueNotification(d.group, d.channel, d.lineUserID, d.message, d.template); err != nil {
+					log.Printf("[ERROR] Failed to enqueue notification for book %s: %v", d.group[0].BookID, err)
+					continue
+				}
+				sent = append(sent, d)
+				count++
+			}
+			reminders = sent
+		}
+		for _, d := range reminders {
+			book := d.group[0]
+			maybeEscalate(book, d.settings, d.channel, now)
+			// グループへの晒しなどは購読者側で行う (シリーズでも代表の1冊だけ)
+			emitBookEvent(BookEvent{Type: eventDeadlineMissed, BookID: book.BookID, UserID: book.UserID, Book: &book})
+		}
+	}
+	return count
+}
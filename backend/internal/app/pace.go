@@ -0,0 +1,251 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+const paceRecalibrateAfter = 24 * time.Hour // 保存したペースをこれより古ければ計算し直す
+
+// paceSample は1冊について直近の期間に読んだ量
+type paceSample struct {
+	pages   float64
+	minutes float64
+}
+
+// bookAmountRef は読書量の計算に使う本の形式と分量 (PostgREST の埋め込み)
+type bookAmountRef struct {
+	Format          string `json:"format"`
+	PageCount       *int   `json:"page_count"`
+	DurationMinutes *int   `json:"duration_minutes"`
+}
+
+func (b bookAmountRef) book() Book {
+	return Book{Format: b.Format, PageCount: b.PageCount, DurationMinutes: b.DurationMinutes}
+}
+
+// userReadingPace はプロフィールに保存したペースを返す。未計算か古ければ計算し直して保存する。
+func userReadingPace(userID string) (readingPace, error) {
+	resp, _, err := execute(supabaseClient.From("users").
+		Select("reading_pages_per_day, reading_minutes_per_day, pace_calibrated_at", "", false).
+		Eq("id", userID))
+	if err != nil {
+		return readingPace{PagesPerDay: defaultPagesPerDay, MinutesPerDay: defaultMinutesPerDay}, err
+	}
+	var users []struct {
+		PagesPerDay   *float64   `json:"reading_pages_per_day"`
+		MinutesPerDay *float64   `json:"reading_minutes_per_day"`
+		CalibratedAt  *time.Time `json:"pace_calibrated_at"`
+	}
+	json.Unmarshal(resp, &users)
+	if len(users) > 0 && users[0].CalibratedAt != nil && clock.Now().Sub(*users[0].CalibratedAt) < paceRecalibrateAfter {
+		u := users[0]
+		pace := readingPace{PagesPerDay: defaultPagesPerDay, MinutesPerDay: defaultMinutesPerDay, CalibratedAt: u.CalibratedAt}
+		if u.PagesPerDay != nil {
+			pace.PagesPerDay, pace.FromHistory = *u.PagesPerDay, true
+		}
+		if u.MinutesPerDay != nil {
+			pace.MinutesPerDay, pace.FromHistory = *u.MinutesPerDay, true
+		}
+		return pace, nil
+	}
+	return recalibrateReadingPace(userID)
+}
+
+// recalibrateReadingPace はペースを計算し直してプロフィールに保存する
+func recalibrateReadingPace(userID string) (readingPace, error) {
+	pace, err := calibrateReadingPace(userID)
+	if err != nil {
+		return pace, err
+	}
+	now := clock.Now()
+	pace.CalibratedAt = &now
+	// 記録がなければ NULL にして、既定値で見積もっていることが分かるようにする
+	update := map[string]interface{}{"reading_pages_per_day": nil, "reading_minutes_per_day": nil, "pace_calibrated_at": now}
+	if pace.FromHistory {
+		update["reading_pages_per_day"] = math.Round(pace.PagesPerDay*10) / 10
+		update["reading_minutes_per_day"] = math.Round(pace.MinutesPerDay*10) / 10
+	}
+	if _, _, err := execute(supabaseClient.From("users").Update(update, "minimal", "").Eq("id", userID)); err != nil {
+		log.Printf("[ERROR] failed to store reading pace for user %s: %v", userID, err)
+	}
+	return pace, nil
+}
+
+// calibrateReadingPace は直近90日の読書タイマー・進捗の記録・読了から1日あたりの読書量を求める。
+// 同じ読書が複数の記録に現れるので、本ごとに一番多い量を採って足し合わせる。
+func calibrateReadingPace(userID string) (readingPace, error) {
+	pace := readingPace{PagesPerDay: defaultPagesPerDay, MinutesPerDay: defaultMinutesPerDay}
+	since := clock.Now().Add(-paceWindow)
+	earliest := clock.Now()
+	samples := make(map[string]*paceSample)
+	sample := func(bookID string, at time.Time) *paceSample {
+		if at.Before(earliest) {
+			earliest = at
+		}
+		if samples[bookID] == nil {
+			samples[bookID] = &paceSample{}
+		}
+		return samples[bookID]
+	}
+
+	// 読書タイマー
+	resp, _, err := execute(supabaseClient.From("reading_sessions").
+		Select("book_id, started_at, duration_seconds, pages", "", false).
+		Eq("user_id", userID).
+		Gte("started_at", since.Format(time.RFC3339)))
+	if err != nil {
+		return pace, err
+	}
+	var sessions []ReadingSession
+	if err := json.Unmarshal(resp, &sessions); err != nil {
+		return pace, err
+	}
+	sessionTotals := make(map[string]*paceSample)
+	for _, s := range sessions {
+		sample(s.BookID, s.StartedAt)
+		if sessionTotals[s.BookID] == nil {
+			sessionTotals[s.BookID] = &paceSample{}
+		}
+		sessionTotals[s.BookID].pages += float64(s.Pages)
+		sessionTotals[s.BookID].minutes += float64(s.DurationSeconds) / 60
+	}
+
+	// 進捗の記録 (前回の記録からの増分。期間内の最初の記録は基準にだけ使う)
+	resp, _, err = execute(supabaseClient.From("progress_logs").
+		Select("book_id, progress, logged_at, books(format, page_count, duration_minutes)", "", false).
+		Eq("user_id", userID).
+		Gte("logged_at", since.Format(time.RFC3339)).
+		Order("logged_at", &postgrest.OrderOpts{Ascending: true}))
+	if err != nil {
+		return pace, err
+	}
+	var logs []struct {
+		BookID   string        `json:"book_id"`
+		Progress int           `json:"progress"`
+		LoggedAt time.Time     `json:"logged_at"`
+		Book     bookAmountRef `json:"books"`
+	}
+	if err := json.Unmarshal(resp, &logs); err != nil {
+		return pace, err
+	}
+	last := make(map[string]int)
+	progressTotals := make(map[string]*paceSample)
+	for _, l := range logs {
+		prev, seen := last[l.BookID]
+		last[l.BookID] = l.Progress
+		if !seen || l.Progress <= prev {
+			continue
+		}
+		sample(l.BookID, l.LoggedAt)
+		if progressTotals[l.BookID] == nil {
+			progressTotals[l.BookID] = &paceSample{}
+		}
+		if l.Book.book().isTimeBased() {
+			progressTotals[l.BookID].minutes += float64(l.Progress - prev)
+		} else {
+			progressTotals[l.BookID].pages += float64(l.Progress - prev)
+		}
+	}
+
+	// 読了 (読み始めが期間内なら1冊分を読んだとみなす)
+	resp, _, err = execute(supabaseClient.From("book_completions").
+		Select("book_id, completed_at, started_at, books(format, page_count, duration_minutes)", "", false).
+		Eq("user_id", userID).
+		Gte("completed_at", since.Format(time.RFC3339)))
+	if err != nil {
+		return pace, err
+	}
+	var completions []struct {
+		Completion
+		Book bookAmountRef `json:"books"`
+	}
+	if err := json.Unmarshal(resp, &completions); err != nil {
+		return pace, err
+	}
+	for _, c := range completions {
+		b := c.Book.book()
+		if c.StartedAt == nil || c.StartedAt.Before(since) || b.totalUnits() == 0 {
+			continue
+		}
+		s := sample(c.BookID, *c.StartedAt)
+		if b.isTimeBased() {
+			s.minutes = math.Max(s.minutes, float64(b.totalUnits()))
+		} else {
+			s.pages = math.Max(s.pages, float64(b.totalUnits()))
+		}
+	}
+
+	pages, minutes := 0.0, 0.0
+	for bookID, s := range samples {
+		for _, other := range []*paceSample{sessionTotals[bookID], progressTotals[bookID]} {
+			if other != nil {
+				s.pages = math.Max(s.pages, other.pages)
+				s.minutes = math.Max(s.minutes, other.minutes)
+			}
+		}
+		pages += s.pages
+		minutes += s.minutes
+	}
+	days := math.Max(minPaceWindowDays, clock.Now().Sub(earliest).Hours()/24)
+	if pages > 0 {
+		pace.PagesPerDay = pages / days
+		pace.FromHistory = true
+	}
+	if minutes > 0 {
+		pace.MinutesPerDay = minutes / days
+		pace.FromHistory = true
+	}
+	return pace, nil
+}
+
+// perDay は本の単位 (ページまたは分) での1日あたりの読書量
+func (p readingPace) perDay(b Book) float64 {
+	if b.isTimeBased() {
+		return p.MinutesPerDay
+	}
+	return p.PagesPerDay
+}
+
+// forecastFinish は今のペースで読み続けた場合の読了予定日。分量が分からなければ nil。
+func forecastFinish(b Book, pace readingPace, now time.Time) *time.Time {
+	remaining := b.totalUnits() - b.Progress
+	if b.totalUnits() == 0 || remaining <= 0 || pace.perDay(b) <= 0 {
+		return nil
+	}
+	days := math.Ceil(float64(remaining) / pace.perDay(b))
+	finish := now.AddDate(0, 0, int(days))
+	return &finish
+}
+
+// paceWarning は期限までに必要な1日あたりの量が普段のペースを超えるときの警告
+func paceWarning(book Book) *Warning {
+	remaining := book.totalUnits() - book.Progress
+	if book.Deadline.IsZero() || book.totalUnits() == 0 || remaining <= 0 || book.UserID == "" {
+		return nil
+	}
+	pace, err := userReadingPace(book.UserID)
+	if err != nil {
+		log.Printf("[ERROR] pace lookup failed for user %s: %v", book.UserID, err)
+		return nil
+	}
+	days := math.Max(1, math.Ceil(book.Deadline.Sub(clock.Now()).Hours()/24))
+	needed := int(math.Ceil(float64(remaining) / days))
+	if float64(needed) <= pace.perDay(book) {
+		return nil
+	}
+	unit := "ページ"
+	if book.isTimeBased() {
+		unit = "分"
+	}
+	message := fmt.Sprintf("この期限に間に合わせるには1日%d%s読む必要があります (最近のペースは1日%.0f%s)。", needed, unit, pace.perDay(book), unit)
+	if !pace.FromHistory {
+		message = fmt.Sprintf("この期限に間に合わせるには1日%d%s読む必要があります。", needed, unit)
+	}
+	return &Warning{Code: "pace_required", Message: message}
+}
@@ -0,0 +1,184 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// 送信するメッセージ (LINE のテキスト・スタンプ・Flex、メール) の型。
+// 送る前に validate で LINE / SendGrid の制約を確かめ、API に 400 で弾かれる内容はここで具体的なエラーにする。
+// 形を変えるときは payloadVersion を上げる。notification_jobs.payload_version がこれより新しいジョブは、
+// ローリングデプロイ中の古いインスタンスでは送らずに新しいインスタンスに任せる (jobs.go)。
+const payloadVersion = 2 // 2: 読了証の画像 (notification_jobs.image_url)
+
+const (
+	maxLineTextLength     = 5000 // テキストメッセージの文字数の上限
+	maxLineAltTextLength  = 400  // Flex Message の代替テキストの上限
+	maxLineMessages       = 5    // 1回の push / reply / multicast で送れるメッセージ数
+	maxLineImageURLLength = 2000
+)
+
+// errInvalidPayload は送る前に見つかったメッセージの不備。再送しても直らない。
+var errInvalidPayload = errors.New("invalid message payload")
+
+// LineMessage は LINE Messaging API のメッセージオブジェクト
+type LineMessage interface {
+	validate() error
+}
+
+type LineEmoji struct {
+	Index     int    `json:"index"` // UTF-16 の位置
+	ProductID string `json:"productId"`
+	EmojiID   string `json:"emojiId"`
+}
+
+type LineTextMessage struct {
+	Type   string      `json:"type"` // text
+	Text   string      `json:"text"`
+	Emojis []LineEmoji `json:"emojis,omitempty"`
+}
+
+func (m LineTextMessage) validate() error {
+	if m.Text == "" {
+		return fmt.Errorf("%w: empty text", errInvalidPayload)
+	}
+	if n := utf8.RuneCountInString(m.Text); n > maxLineTextLength {
+		return fmt.Errorf("%w: text has %d characters (max %d)", errInvalidPayload, n, maxLineTextLength)
+	}
+	if len(m.Emojis) > maxLineEmojis {
+		return fmt.Errorf("%w: %d emojis (max %d)", errInvalidPayload, len(m.Emojis), maxLineEmojis)
+	}
+	for _, e := range m.Emojis {
+		if !emojiProductID.MatchString(e.ProductID) || !emojiID.MatchString(e.EmojiID) {
+			return fmt.Errorf("%w: emoji %s/%s", errInvalidPayload, e.ProductID, e.EmojiID)
+		}
+	}
+	return nil
+}
+
+type LineStickerMessage struct {
+	Type      string `json:"type"` // sticker
+	PackageID string `json:"packageId"`
+	StickerID string `json:"stickerId"`
+}
+
+func (m LineStickerMessage) validate() error {
+	if !stickerSpecPattern.MatchString(m.PackageID + ":" + m.StickerID) {
+		return fmt.Errorf("%w: sticker %s:%s", errInvalidPayload, m.PackageID, m.StickerID)
+	}
+	return nil
+}
+
+type LineFlexMessage struct {
+	Type     string          `json:"type"` // flex
+	AltText  string          `json:"altText"`
+	Contents json.RawMessage `json:"contents"` // bubble か carousel
+}
+
+func (m LineFlexMessage) validate() error {
+	if m.AltText == "" || utf8.RuneCountInString(m.AltText) > maxLineAltTextLength {
+		return fmt.Errorf("%w: altText must be 1-%d characters", errInvalidPayload, maxLineAltTextLength)
+	}
+	var contents struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(m.Contents, &contents); err != nil {
+		return fmt.Errorf("%w: flex contents: %v", errInvalidPayload, err)
+	}
+	if contents.Type != "bubble" && contents.Type != "carousel" {
+		return fmt.Errorf("%w: flex contents type %q", errInvalidPayload, contents.Type)
+	}
+	return nil
+}
+
+// LineImageMessage は画像メッセージ。URL は LINE が取りに来るので HTTPS で公開されている必要がある。
+type LineImageMessage struct {
+	Type               string `json:"type"` // image
+	OriginalContentURL string `json:"originalContentUrl"`
+	PreviewImageURL    string `json:"previewImageUrl"`
+}
+
+func (m LineImageMessage) validate() error {
+	for _, u := range []string{m.OriginalContentURL, m.PreviewImageURL} {
+		if !strings.HasPrefix(u, "https://") || len(u) > maxLineImageURLLength {
+			return fmt.Errorf("%w: image URL must be https and at most %d characters", errInvalidPayload, maxLineImageURLLength)
+		}
+	}
+	return nil
+}
+
+// validateLineMessages は1回で送るメッセージ全体を確かめる
+func validateLineMessages(messages []LineMessage) error {
+	if len(messages) == 0 || len(messages) > maxLineMessages {
+		return fmt.Errorf("%w: %d messages (1-%d)", errInvalidPayload, len(messages), maxLineMessages)
+	}
+	for _, m := range messages {
+		if err := m.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LINE の送信 API の本文
+type LinePushRequest struct {
+	To       string        `json:"to"`
+	Messages []LineMessage `json:"messages"`
+}
+
+type LineMulticastRequest struct {
+	To       []string      `json:"to"`
+	Messages []LineMessage `json:"messages"`
+}
+
+type LineBroadcastRequest struct {
+	Messages []LineMessage `json:"messages"`
+}
+
+type LineReplyRequest struct {
+	ReplyToken string        `json:"replyToken"`
+	Messages   []LineMessage `json:"messages"`
+}
+
+// EmailPayload は SendGrid v3 の mail/send の本文
+type EmailPayload struct {
+	Personalizations []EmailPersonalization `json:"personalizations"`
+	From             EmailAddress           `json:"from"`
+	Subject          string                 `json:"subject"`
+	Content          []EmailContent         `json:"content"`
+}
+
+type EmailPersonalization struct {
+	To []EmailAddress `json:"to"`
+}
+
+type EmailAddress struct {
+	Email string `json:"email"`
+}
+
+type EmailContent struct {
+	Type  string `json:"type"` // text/plain
+	Value string `json:"value"`
+}
+
+func newEmailPayload(from, to, subject, body string) EmailPayload {
+	return EmailPayload{
+		Personalizations: []EmailPersonalization{{To: []EmailAddress{{Email: to}}}},
+		From:             EmailAddress{Email: from},
+		Subject:          subject,
+		Content:          []EmailContent{{Type: "text/plain", Value: body}},
+	}
+}
+
+func (p EmailPayload) validate() error {
+	if len(p.Personalizations) == 0 || len(p.Personalizations[0].To) == 0 || p.Personalizations[0].To[0].Email == "" {
+		return fmt.Errorf("%w: no email recipient", errInvalidPayload)
+	}
+	if p.Subject == "" || len(p.Content) == 0 || p.Content[0].Value == "" {
+		return fmt.Errorf("%w: empty email subject or body", errInvalidPayload)
+	}
+	return nil
+}
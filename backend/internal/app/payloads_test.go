@@ -0,0 +1,187 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 送信するメッセージの形を testdata/*.golden と比べる。意図して変えたときは
+//
+//	go test -run TestPayloadGolden -update
+//
+// で書き直し、差分を確かめてからコミットする。形が変わるなら payloadVersion も上げる。
+var updateGolden = flag.Bool("update", false, "rewrite testdata/*.golden")
+
+var goldenNow = time.Date(2026, 10, 16, 9, 0, 0, 0, jst)
+
+func insultTemplateByKey(t *testing.T, tone, key string) insultTemplate {
+	t.Helper()
+	for _, tmpl := range insultTonePools[tone] {
+		if tmpl.Key == key {
+			return tmpl
+		}
+	}
+	t.Fatalf("no %s template %q", tone, key)
+	return insultTemplate{}
+}
+
+func goldenBook(id, title, author string, daysOverdue, level int, price *int) Book {
+	return Book{
+		BookID: id, UserID: "user-1", Title: title, Author: author,
+		Deadline: goldenNow.AddDate(0, 0, -daysOverdue), Status: "insulted", InsultLevel: level, Price: price,
+	}
+}
+
+// payloadCases は golden ファイル名と、送るメッセージ (LINE の push かメール) を作る関数
+func payloadCases(t *testing.T) map[string]func() (interface{}, error) {
+	price := 2980
+	return map[string]func() (interface{}, error){
+		"line_text_insult": func() (interface{}, error) {
+			data := MessageData{Title: "失敗の科学", Count: 1, DaysOverdue: 12, UnreadCount: 7, MoneyWasted: 12340}
+			message := insultTemplateByKey(t, insultToneStandard, "money_wasted").render(data)
+			job := NotificationJob{Kind: jobKindInsult, LineUserID: "U0001", Message: message}
+			return lineRequest(job)
+		},
+		"line_text_emoji_sticker": func() (interface{}, error) {
+			message := renderMessage(`{{emoji "5ac1bfd5040ab15980c9b435" "001"}}「{{.Title}}」の期限を{{.DaysOverdue}}日過ぎました`,
+				MessageData{Title: "坂の上の雲", DaysOverdue: 3}, "")
+			job := NotificationJob{Kind: jobKindInsult, LineUserID: "U0001", Message: message, Sticker: "446:1988"}
+			return lineRequest(job)
+		},
+		"line_text_family_safe": func() (interface{}, error) {
+			data := MessageData{Title: "はらぺこあおむし", DaysOverdue: 2, FamilySafe: true}
+			message := insultTemplateByKey(t, insultToneStandard, "rotten").render(data)
+			return lineRequest(NotificationJob{Kind: jobKindInsult, LineUserID: "U0001", Message: message})
+		},
+		"line_text_digest": func() (interface{}, error) {
+			data := MessageData{UnreadCount: 5, OverdueCount: 2, MoneyWasted: 4980, NextTitle: "銃・病原菌・鉄", NextDeadline: "2026/10/20", NextReason: "期限まであと4日"}
+			return lineRequest(NotificationJob{Kind: jobKindDigest, LineUserID: "U0001", Message: renderMessage(digestText, data, "")})
+		},
+		"line_image_certificate": func() (interface{}, error) {
+			job := NotificationJob{Kind: jobKindCertificate, LineUserID: "U0001", Message: "『失敗の科学』読了おめでとうございます！", ImageURL: "https://example.supabase.co/storage/v1/object/sign/certificates/cert.png?token=abc"}
+			return lineRequest(job)
+		},
+		"line_flex_monthly_report": func() (interface{}, error) {
+			report := &MonthlyReport{
+				Month:          time.Date(2026, 9, 1, 0, 0, 0, 0, jst),
+				Finished:       []string{"失敗の科学", "坂の上の雲 1", "坂の上の雲 2", "銃・病原菌・鉄", "サピエンス全史", "利己的な遺伝子"},
+				FinishedCount:  6,
+				Finishing:      FinishingStats{Completions: 6, AvgDaysHeld: 18.4, Late: 2, AvgDaysLate: 3.5},
+				PrevFinished:   4,
+				InsultsCount:   9,
+				Procrastinated: &BookRef{BookID: "book-9", Title: "カラマーゾフの兄弟"},
+				DaysOverdue:    41,
+				CardURL:        "https://example.supabase.co/storage/v1/object/sign/reports/card.png?token=abc",
+			}
+			contents, err := report.flexBubble()
+			if err != nil {
+				return nil, err
+			}
+			return lineRequest(NotificationJob{Kind: jobKindMonthly, LineUserID: "U0001", Message: report.altText(), Payload: contents})
+		},
+		"line_flex_overdue_batch": func() (interface{}, error) {
+			ranked := []dueReminder{
+				{group: []Book{goldenBook("b1", "カラマーゾフの兄弟", "ドストエフスキー", 41, 5, &price)}},
+				{group: []Book{goldenBook("b2", "坂の上の雲 1", "司馬遼太郎", 20, 3, nil), goldenBook("b3", "坂の上の雲 2", "司馬遼太郎", 20, 3, nil)}},
+				{group: []Book{goldenBook("b4", "失敗の科学", "", 7, 2, nil)}},
+			}
+			contents, err := overdueBatchCarousel(ranked, 8, goldenNow)
+			if err != nil {
+				return nil, err
+			}
+			job := NotificationJob{Kind: jobKindInsult, LineUserID: "U0001", Message: overdueBatchText(ranked, 8, goldenNow), Payload: contents}
+			return lineRequest(job)
+		},
+		"email_insult": func() (interface{}, error) {
+			message := renderMessage(`{{emoji "5ac1bfd5040ab15980c9b435" "001"}}「{{.Title}}」の期限を{{.DaysOverdue}}日過ぎました`,
+				MessageData{Title: "坂の上の雲", DaysOverdue: 3}, "")
+			job := NotificationJob{Kind: jobKindInsult, Channel: notifyChannelEmail, Message: message}
+			return emailRequest(job)
+		},
+		"email_milestone": func() (interface{}, error) {
+			data := MessageData{Title: "統計学入門", MilestoneTitle: "第3章", MilestoneDate: "10/10", DaysLeft: 9}
+			job := NotificationJob{Kind: jobKindMilestone, Channel: notifyChannelEmail, Message: renderMessage(milestoneReminderText, data, "")}
+			return emailRequest(job)
+		},
+	}
+}
+
+// lineRequest は processNotificationJob が push する本文。送る前の検証も通す。
+func lineRequest(job NotificationJob) (interface{}, error) {
+	messages := jobLineMessages(job)
+	if err := validateLineMessages(messages); err != nil {
+		return nil, err
+	}
+	return LinePushRequest{To: job.LineUserID, Messages: messages}, nil
+}
+
+// emailRequest は sendJobEmail が SendGrid に送る本文
+func emailRequest(job NotificationJob) (interface{}, error) {
+	mail := newEmailPayload("noreply@example.com", "reader@example.com", notifyEmailSubject(job), plainText(job.Message))
+	if err := mail.validate(); err != nil {
+		return nil, err
+	}
+	return mail, nil
+}
+
+func TestPayloadGolden(t *testing.T) {
+	t.Setenv("LIFF_URL", "https://liff.line.me/0000000000-golden")
+	for name, build := range payloadCases(t) {
+		t.Run(name, func(t *testing.T) {
+			payload, err := build()
+			if err != nil {
+				t.Fatalf("build payload: %v", err)
+			}
+			got, err := json.MarshalIndent(payload, "", "  ")
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			got = append(got, '\n')
+			path := filepath.Join("testdata", name+".golden")
+			if *updateGolden {
+				if err := os.MkdirAll("testdata", 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read golden (run with -update to create it): %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s differs from the golden file; if the change is intended, run go test -run TestPayloadGolden -update\n--- got\n%s\n--- want\n%s", name, got, want)
+			}
+		})
+	}
+}
+
+func TestPayloadValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []LineMessage
+	}{
+		{"no messages", nil},
+		{"too many messages", []LineMessage{lineTextMessage("1"), lineTextMessage("2"), lineTextMessage("3"), lineTextMessage("4"), lineTextMessage("5"), lineTextMessage("6")}},
+		{"empty text", []LineMessage{lineTextMessage("")}},
+		{"bad sticker", []LineMessage{lineStickerMessage("abc")}},
+		{"flex without alt text", []LineMessage{LineFlexMessage{Type: "flex", Contents: json.RawMessage(`{"type":"bubble"}`)}}},
+		{"flex with unknown container", []LineMessage{LineFlexMessage{Type: "flex", AltText: "x", Contents: json.RawMessage(`{"type":"box"}`)}}},
+		{"http image", []LineMessage{LineImageMessage{Type: "image", OriginalContentURL: "http://example.com/a.png", PreviewImageURL: "http://example.com/a.png"}}},
+	}
+	for _, tt := range tests {
+		if err := validateLineMessages(tt.messages); err == nil {
+			t.Errorf("%s: expected a validation error", tt.name)
+		}
+	}
+	if err := newEmailPayload("noreply@example.com", "", "subject", "body").validate(); err == nil {
+		t.Error("email without recipient: expected a validation error")
+	}
+}
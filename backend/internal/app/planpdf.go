@@ -0,0 +1,249 @@
+package app
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// 紙の読書計画。期限の近い順に、残りの量と期限に間に合わせるための1日の目標を A4 1枚の PDF にする (冷蔵庫に貼る用)。
+// 日本語はフォントを埋め込まず、PDF ビューアーが持っている標準の日本語フォント (HeiseiKakuGo-W5) で描かせる。
+// UniJIS-UCS2-H は基本多言語面の文字しか表せないので、絵文字などは 〓 にする。
+const (
+	planPageWidth   = 595 // A4 (pt)
+	planPageHeight  = 842
+	planMargin      = 48
+	planRowHeight   = 22
+	planFontSize    = 10
+	planTitleSize   = 18
+	planCheckDays   = 7 // 1日ごとに塗りつぶすチェック欄の日数
+	planTitleWidth  = 185
+	planMissingRune = '〓'
+)
+
+// planRow は読書計画の1行
+type planRow struct {
+	Title     string
+	Deadline  time.Time
+	Remaining int    // 残りの量。分量が分からなければ 0
+	Unit      string // ページ, 分
+	Target    int    // 期限に間に合わせるための1日の量。分量が分からなければ 0
+	OverPace  bool   // 最近のペースを超えている
+	Overdue   bool
+}
+
+// buildReadingPlan は期限のある未読・読書中の本を期限の近い順に並べ、1日の目標を付ける
+func buildReadingPlan(books []Book, pace readingPace, now time.Time) []planRow {
+	rows := []planRow{}
+	for _, b := range books {
+		if (b.Status != "unread" && b.Status != "reading" && b.Status != "insulted") || b.Archived || b.Deadline.IsZero() {
+			continue
+		}
+		row := planRow{Title: b.Title, Deadline: b.Deadline, Unit: "ページ", Overdue: b.Deadline.Before(now)}
+		if b.isTimeBased() {
+			row.Unit = "分"
+		}
+		if remaining := b.totalUnits() - b.Progress; b.totalUnits() > 0 && remaining > 0 {
+			row.Remaining = remaining
+			// 過ぎた本は今日中に読み切る前提
+			days := math.Max(1, math.Ceil(b.Deadline.Sub(now).Hours()/24))
+			row.Target = int(math.Ceil(float64(remaining) / days))
+			row.OverPace = pace.FromHistory && float64(row.Target) > pace.perDay(b)
+		}
+		rows = append(rows, row)
+	}
+	slices.SortStableFunc(rows, func(a, b planRow) int { return cmp.Compare(a.Deadline.Unix(), b.Deadline.Unix()) })
+	return rows
+}
+
+// handleReadingPlanPDF は GET /api/books/plan.pdf?userId=...
+func handleReadingPlanPDF(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+	books, _, err := loadUserBooks(userId)
+	if err != nil {
+		log.Printf("[ERROR] handleReadingPlanPDF error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	pace, err := userReadingPace(userId)
+	if err != nil {
+		log.Printf("[ERROR] handleReadingPlanPDF pace error: %v", err)
+	}
+	now := clock.Now()
+	pdf := renderReadingPlanPDF(buildReadingPlan(books, pace, now), pace, now)
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `inline; filename="reading-plan.pdf"`)
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Write(pdf)
+}
+
+// renderReadingPlanPDF は1ページの PDF を組み立てる。入りきらない行は「ほか N冊」にまとめる。
+func renderReadingPlanPDF(rows []planRow, pace readingPace, now time.Time) []byte {
+	var c planCanvas
+	today := now.In(jst)
+	y := float64(planPageHeight - planMargin - planTitleSize)
+	c.text(planMargin, y, planTitleSize, "読書計画")
+	y -= 20
+	sub := fmt.Sprintf("%d年%d月%d日 作成", today.Year(), int(today.Month()), today.Day())
+	if pace.FromHistory {
+		sub += fmt.Sprintf(" ・ 最近のペース: 1日%.0fページ / %.0f分", pace.PagesPerDay, pace.MinutesPerDay)
+	}
+	c.text(planMargin, y, planFontSize, sub)
+	y -= 30
+
+	// 列: 期限, 書名, 残り, 1日の目標, チェック欄
+	colDeadline := float64(planMargin)
+	colTitle := colDeadline + 50
+	colRemaining := colTitle + planTitleWidth + 8
+	colTarget := colRemaining + 62
+	colCheck := colTarget + 72
+	boxSize := float64(planRowHeight - 10)
+	boxStep := boxSize + 5
+
+	c.text(colDeadline, y, planFontSize, "期限")
+	c.text(colTitle, y, planFontSize, "書名")
+	c.text(colRemaining, y, planFontSize, "残り")
+	c.text(colTarget, y, planFontSize, "1日の目標")
+	for i := range planCheckDays {
+		d := today.AddDate(0, 0, i)
+		c.text(colCheck+float64(i)*boxStep, y, 7, fmt.Sprintf("%d/%d", int(d.Month()), d.Day()))
+	}
+	y -= 6
+	c.line(planMargin, y, planPageWidth-planMargin, y)
+
+	if len(rows) == 0 {
+		c.text(planMargin, y-planRowHeight, planFontSize, "期限のある未読の本はありません。")
+	}
+	maxRows := int((y - planMargin - planRowHeight) / planRowHeight)
+	overPace := false
+	for i, row := range rows {
+		if i == maxRows {
+			c.text(planMargin, y-planRowHeight+6, planFontSize, fmt.Sprintf("ほか%d冊", len(rows)-maxRows))
+			break
+		}
+		y -= planRowHeight
+		baseline := y + 7
+		deadline := row.Deadline.In(jst)
+		c.text(colDeadline, baseline, planFontSize, fmt.Sprintf("%d/%d", int(deadline.Month()), deadline.Day()))
+		c.text(colTitle, baseline, planFontSize, planFit(row.Title, planTitleWidth, planFontSize))
+		remaining, target := "-", "-"
+		if row.Remaining > 0 {
+			remaining = fmt.Sprintf("%d%s", row.Remaining, row.Unit)
+			target = fmt.Sprintf("%d%s", row.Target, row.Unit)
+			if row.OverPace {
+				target += " !"
+				overPace = true
+			}
+		}
+		if row.Overdue {
+			target = "期限切れ"
+		}
+		c.text(colRemaining, baseline, planFontSize, remaining)
+		c.text(colTarget, baseline, planFontSize, target)
+		for d := range planCheckDays {
+			c.rect(colCheck+float64(d)*boxStep, y+4, boxSize, boxSize)
+		}
+		c.line(planMargin, y, planPageWidth-planMargin, y)
+	}
+	if overPace {
+		c.text(planMargin, planMargin-16, 8, "! は最近のペースより多く読む必要がある本です。")
+	}
+	return c.document()
+}
+
+// planFit は幅に収まるよう末尾を … で切り詰める
+func planFit(s string, width, size float64) string {
+	runes := []rune(s)
+	if planTextWidth(string(runes), size) <= width {
+		return s
+	}
+	for len(runes) > 0 && planTextWidth(string(runes)+"…", size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
+
+// planTextWidth は半角を 0.5em、それ以外を 1em として幅を見積もる (フォントの /W と同じ)
+func planTextWidth(s string, size float64) float64 {
+	w := 0.0
+	for _, r := range s {
+		if r >= 0x20 && r < 0x7f {
+			w += 0.5
+		} else {
+			w += 1
+		}
+	}
+	return w * size
+}
+
+// planCanvas はページの描画命令 (コンテンツストリーム) を溜める
+type planCanvas struct {
+	ops bytes.Buffer
+}
+
+func (c *planCanvas) text(x, y, size float64, s string) {
+	fmt.Fprintf(&c.ops, "BT /F1 %.1f Tf %.2f %.2f Td <%s> Tj ET\n", size, x, y, planHex(s))
+}
+
+func (c *planCanvas) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&c.ops, "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+func (c *planCanvas) rect(x, y, w, h float64) {
+	fmt.Fprintf(&c.ops, "0.5 w %.2f %.2f %.2f %.2f re S\n", x, y, w, h)
+}
+
+// planHex は UniJIS-UCS2-H 用に UTF-16BE の16進にする
+func planHex(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r > 0xffff || utf16.IsSurrogate(r) {
+			r = planMissingRune
+		}
+		fmt.Fprintf(&b, "%04X", r)
+	}
+	return b.String()
+}
+
+// document は1ページ分の PDF を組み立てる
+func (c *planCanvas) document() []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 5 0 R >> >> /Contents 4 0 R >>", planPageWidth, planPageHeight),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", c.ops.Len(), c.ops.String()),
+		"<< /Type /Font /Subtype /Type0 /BaseFont /HeiseiKakuGo-W5-UniJIS-UCS2-H /Encoding /UniJIS-UCS2-H /DescendantFonts [6 0 R] >>",
+		"<< /Type /Font /Subtype /CIDFontType0 /BaseFont /HeiseiKakuGo-W5 /CIDSystemInfo << /Registry (Adobe) /Ordering (Japan1) /Supplement 2 >> /FontDescriptor 7 0 R /DW 1000 /W [1 95 500 231 632 500] >>",
+		"<< /Type /FontDescriptor /FontName /HeiseiKakuGo-W5 /Flags 4 /FontBBox [-92 -250 1010 922] /ItalicAngle 0 /Ascent 752 /Descent -221 /CapHeight 737 /StemV 114 >>",
+	}
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return out.Bytes()
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// 保存するデータを減らすユーザーごとの設定。反映は /api/cron/retention (1日1回を想定) が行う。
+// 送信ログは cadence の最長間隔 (maxBackoffInterval) より短くは消さない (督促の間隔が分からなくなる)。
+const (
+	minNotificationLogRetentionDays = 30
+	maxNotificationLogRetentionDays = 3650
+	retentionBatchSize              = 100 // In() に並べるユーザー数 (URL の長さを抑える)
+)
+
+// 送信が終わった (もう送らない) ジョブの状態
+var finishedJobStatuses = []string{"sent", "failed", "skipped"}
+
+// PrivacySettings は users のデータ保持の設定
+type PrivacySettings struct {
+	UserID                       string `json:"id"`
+	RetainInsultHistory          bool   `json:"retain_insult_history"`           // false なら送った督促の本文を消す (日時とテンプレートは間隔の計算に残す)
+	NotificationLogRetentionDays *int   `json:"notification_log_retention_days"` // 未設定なら送信ログを消さない
+}
+
+func (p PrivacySettings) settingsJSON() map[string]interface{} {
+	return map[string]interface{}{
+		"retain_insult_history":           p.RetainInsultHistory,
+		"notification_log_retention_days": p.NotificationLogRetentionDays,
+	}
+}
+
+const privacyColumns = "id, retain_insult_history, notification_log_retention_days"
+
+func fetchPrivacySettings(userID string) (PrivacySettings, error) {
+	resp, _, err := execute(supabaseClient.From("users").Select(privacyColumns, "", false).Eq("id", userID))
+	if err != nil {
+		return PrivacySettings{}, err
+	}
+	var rows []PrivacySettings
+	if err := json.Unmarshal(resp, &rows); err != nil || len(rows) == 0 {
+		return PrivacySettings{RetainInsultHistory: true}, err
+	}
+	return rows[0], nil
+}
+
+// validNotificationLogRetention は 0 (消さない) か許容範囲の日数なら true
+func validNotificationLogRetention(days int) bool {
+	return days == 0 || (days >= minNotificationLogRetentionDays && days <= maxNotificationLogRetentionDays)
+}
+
+// redactInsultHistory は履歴を残さない設定のユーザーについて、送信が終わった督促の本文を消す。消した件数を返す。
+func redactInsultHistory(userIDs []string) (int64, error) {
+	if len(userIDs) == 0 {
+		return 0, nil
+	}
+	_, n, err := execute(supabaseClient.From("notification_jobs").Update(map[string]interface{}{
+		"message": "",
+		"payload": nil,
+	}, "minimal", "exact").
+		Eq("kind", jobKindInsult).
+		In("status", finishedJobStatuses).
+		In("user_id", userIDs).
+		Neq("message", ""))
+	return n, err
+}
+
+// purgeNotificationLogs は userIDs の送信が終わったジョブのうち days 日より前に積んだものを消す。消した件数を返す。
+func purgeNotificationLogs(userIDs []string, days int, now time.Time) (int64, error) {
+	_, n, err := execute(supabaseClient.From("notification_jobs").Delete("minimal", "exact").
+		In("user_id", userIDs).
+		In("status", finishedJobStatuses).
+		Lt("created_at", now.AddDate(0, 0, -days).Format(time.RFC3339)))
+	return n, err
+}
+
+// RetentionReport は保持期限の処理の結果
+type RetentionReport struct {
+	RedactedInsults    int64 `json:"redacted_insults"`
+	PurgedNotification int64 `json:"purged_notifications"`
+	Users              int   `json:"users"`
+}
+
+// enforceRetention はデータ保持の設定をしているユーザーのデータを減らす
+func enforceRetention() (RetentionReport, error) {
+	var report RetentionReport
+	resp, _, err := execute(supabaseClient.From("users").Select(privacyColumns, "", false).
+		Or("retain_insult_history.eq.false,notification_log_retention_days.not.is.null", ""))
+	if err != nil {
+		return report, err
+	}
+	var users []PrivacySettings
+	if err := json.Unmarshal(resp, &users); err != nil {
+		return report, err
+	}
+	report.Users = len(users)
+
+	var redact []string
+	byDays := make(map[int][]string)
+	for _, u := range users {
+		if !u.RetainInsultHistory {
+			redact = append(redact, u.UserID)
+		}
+		if u.NotificationLogRetentionDays != nil && *u.NotificationLogRetentionDays > 0 {
+			days := max(*u.NotificationLogRetentionDays, minNotificationLogRetentionDays)
+			byDays[days] = append(byDays[days], u.UserID)
+		}
+	}
+	for batch := range slices.Chunk(redact, retentionBatchSize) {
+		n, err := redactInsultHistory(batch)
+		if err != nil {
+			return report, fmt.Errorf("redact insult history: %w", err)
+		}
+		report.RedactedInsults += n
+	}
+	now := clock.Now()
+	for days, ids := range byDays {
+		for batch := range slices.Chunk(ids, retentionBatchSize) {
+			n, err := purgeNotificationLogs(batch, days, now)
+			if err != nil {
+				return report, fmt.Errorf("purge notification logs: %w", err)
+			}
+			report.PurgedNotification += n
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,180 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+const publicRecentFinishes = 5
+
+var profileSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{2,31}$`)
+
+// PublicProfile は GET /api/public/users/{slug} のレスポンス。ユーザーIDなど内部の値は含めない。
+type PublicProfile struct {
+	DisplayName    string          `json:"display_name"`
+	CompletedBooks int             `json:"completed_books"`
+	TotalPagesRead int             `json:"total_pages_read"`
+	AverageRating  float64         `json:"average_rating"`
+	CurrentStreak  int             `json:"current_streak"`
+	LongestStreak  int             `json:"longest_streak"`
+	RecentFinishes []PublicFinish  `json:"recent_finishes"`
+	Overdue        []PublicOverdue `json:"overdue,omitempty"` // show_overdue を有効にした場合のみ
+}
+
+type PublicFinish struct {
+	Title       string    `json:"title"`
+	Author      string    `json:"author"`
+	Rating      *int      `json:"rating"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+type PublicOverdue struct {
+	Title       string `json:"title"`
+	Author      string `json:"author"`
+	DaysOverdue int    `json:"days_overdue"`
+}
+
+// handlePublicProfileSettings は PUT /api/profile/public。公開プロフィールの有効化とスラッグ・期限切れリストの公開を設定する。
+func handlePublicProfileSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID      string `json:"user_id"`
+		Enabled     bool   `json:"enabled"`
+		Slug        string `json:"slug"`
+		ShowOverdue bool   `json:"show_overdue"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Slug = strings.ToLower(strings.TrimSpace(req.Slug))
+	update := map[string]interface{}{
+		"profile_public": req.Enabled,
+		"show_overdue":   req.Enabled && req.ShowOverdue,
+		"updated_at":     clock.Now(),
+	}
+	if req.Enabled {
+		if !profileSlugPattern.MatchString(req.Slug) {
+			http.Error(w, "slug must be 3-32 characters of a-z, 0-9, _ or -", http.StatusBadRequest)
+			return
+		}
+		update["public_slug"] = req.Slug
+	}
+
+	resp, _, err := execute(supabaseClient.From("users").Update(update, "", "").Eq("id", req.UserID))
+	if err != nil {
+		if strings.Contains(err.Error(), "23505") {
+			http.Error(w, "slug is already taken", http.StatusConflict)
+			return
+		}
+		log.Printf("[ERROR] handlePublicProfileSettings update error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to update profile: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var users []map[string]interface{}
+	if json.Unmarshal(resp, &users); len(users) == 0 {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Profile settings updated", "enabled": req.Enabled, "slug": req.Slug})
+}
+
+// handlePublicProfile は GET /api/public/users/{slug}。公開を選んだユーザーの読書実績を返す。
+func handlePublicProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	uResp, _, err := execute(supabaseClient.From("users").
+		Select("id, display_name, show_overdue", "", false).
+		Eq("public_slug", strings.ToLower(r.PathValue("slug"))).
+		Eq("profile_public", "true"))
+	if err != nil {
+		log.Printf("[ERROR] handlePublicProfile user lookup error: %v", err)
+		http.Error(w, "failed to load profile", http.StatusInternalServerError)
+		return
+	}
+	var users []struct {
+		ID          string `json:"id"`
+		DisplayName string `json:"display_name"`
+		ShowOverdue bool   `json:"show_overdue"`
+	}
+	if json.Unmarshal(uResp, &users); len(users) == 0 {
+		http.Error(w, "Profile not found", http.StatusNotFound)
+		return
+	}
+	user := users[0]
+
+	stats, err := loadUserStats(user.ID)
+	if err != nil {
+		log.Printf("[ERROR] handlePublicProfile stats error: %v", err)
+		http.Error(w, "failed to load profile", http.StatusInternalServerError)
+		return
+	}
+	profile := PublicProfile{
+		DisplayName:    user.DisplayName,
+		CompletedBooks: stats.StatusCounts["completed"],
+		TotalPagesRead: stats.TotalPagesRead,
+		AverageRating:  stats.AverageRating,
+		CurrentStreak:  stats.CurrentStreak,
+		LongestStreak:  stats.LongestStreak,
+		RecentFinishes: []PublicFinish{},
+	}
+
+	cResp, _, err := execute(supabaseClient.From("book_completions").
+		Select("completed_at, books(title, author, rating)", "", false).
+		Eq("user_id", user.ID).
+		Order("completed_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(publicRecentFinishes, ""))
+	if err != nil {
+		log.Printf("[ERROR] handlePublicProfile completions error: %v", err)
+		http.Error(w, "failed to load profile", http.StatusInternalServerError)
+		return
+	}
+	var completions []struct {
+		CompletedAt time.Time `json:"completed_at"`
+		Book        struct {
+			Title  string `json:"title"`
+			Author string `json:"author"`
+			Rating *int   `json:"rating"`
+		} `json:"books"`
+	}
+	json.Unmarshal(cResp, &completions)
+	for _, c := range completions {
+		profile.RecentFinishes = append(profile.RecentFinishes, PublicFinish{Title: c.Book.Title, Author: c.Book.Author, Rating: c.Book.Rating, CompletedAt: c.CompletedAt})
+	}
+
+	if user.ShowOverdue {
+		now := clock.Now()
+		bResp, _, err := execute(supabaseClient.From("books").
+			Select("title, author, deadline", "", false).
+			Eq("user_id", user.ID).
+			In("status", activeStatuses).
+			Lt("deadline", now.Format(time.RFC3339)).
+			Order("deadline", &postgrest.OrderOpts{Ascending: true}))
+		if err != nil {
+			log.Printf("[ERROR] handlePublicProfile overdue error: %v", err)
+		}
+		var overdue []Book
+		json.Unmarshal(bResp, &overdue)
+		for _, b := range overdue {
+			profile.Overdue = append(profile.Overdue, PublicOverdue{Title: b.Title, Author: b.Author, DaysOverdue: int(now.Sub(b.Deadline).Hours() / 24)})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(profile)
+}
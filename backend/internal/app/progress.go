@@ -0,0 +1,166 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+)
+
+const defaultMinutesPerPage = 2.0
+
+var validFormats = map[string]bool{
+	"paperback": true,
+	"hardcover": true,
+	"ebook":     true,
+	"audiobook": true,
+}
+
+// isTimeBased はオーディオブックのように再生時間で進捗を測る形式かを返す
+func (b Book) isTimeBased() bool {
+	return b.Format == "audiobook"
+}
+
+// validateFormat は形式と分量の組み合わせを検証する。形式が空なら paperback とみなす。
+func validateFormat(b *Book) error {
+	if b.Format == "" {
+		b.Format = "paperback"
+	}
+	if !validFormats[b.Format] {
+		return fmt.Errorf("format must be one of paperback, hardcover, ebook, audiobook")
+	}
+	if b.isTimeBased() {
+		if b.PageCount != nil {
+			return fmt.Errorf("audiobooks use duration_minutes instead of page_count")
+		}
+		if b.DurationMinutes != nil && *b.DurationMinutes <= 0 {
+			return fmt.Errorf("duration_minutes must be positive")
+		}
+	} else {
+		if b.DurationMinutes != nil {
+			return fmt.Errorf("duration_minutes is only for audiobooks")
+		}
+		if b.PageCount != nil && *b.PageCount <= 0 {
+			return fmt.Errorf("page_count must be positive")
+		}
+	}
+	return nil
+}
+
+// totalUnits は本の分量 (ページ数または分) を返す。未設定なら 0。
+func (b Book) totalUnits() int {
+	if b.isTimeBased() {
+		if b.DurationMinutes != nil {
+			return *b.DurationMinutes
+		}
+		return 0
+	}
+	if b.PageCount != nil {
+		return *b.PageCount
+	}
+	return 0
+}
+
+func (b Book) progressUnit() string {
+	if b.isTimeBased() {
+		return "minutes"
+	}
+	return "pages"
+}
+
+// progressPercent は進捗率 (0-100) を返す
+func (b Book) progressPercent() float64 {
+	total := b.totalUnits()
+	if total == 0 {
+		return 0
+	}
+	return math.Min(100, float64(b.Progress)*100/float64(total))
+}
+
+// estimateMinutesLeft は残りの読書時間 (分) を見積もる。
+// オーディオブックは残り再生時間、紙・電子書籍はユーザーのページあたり所要時間から計算する。
+func estimateMinutesLeft(b Book, minutesPerPage float64) int {
+	remaining := b.totalUnits() - b.Progress
+	if remaining <= 0 {
+		return 0
+	}
+	if b.isTimeBased() {
+		return remaining
+	}
+	if minutesPerPage <= 0 {
+		minutesPerPage = defaultMinutesPerPage
+	}
+	return int(math.Ceil(float64(remaining) * minutesPerPage))
+}
+
+// userMinutesPerPage は読書セッションの記録からページあたりの所要時間を求める
+func userMinutesPerPage(userID string) float64 {
+	stats, err := loadUserStats(userID)
+	if err != nil || stats.TotalPagesRead == 0 {
+		return defaultMinutesPerPage
+	}
+	return float64(stats.TotalReadingSeconds) / 60 / float64(stats.TotalPagesRead)
+}
+
+// handleProgress は POST /api/books/{id}/progress。読んだページ数または聴いた分数を記録する。
+func handleProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID   string `json:"user_id"`
+		Progress int    `json:"progress"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Progress < 0 {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	book, err := fetchOwnedBook(r.PathValue("id"), req.UserID)
+	if err != nil {
+		writeBookLookupError(w, err)
+		return
+	}
+	if total := book.totalUnits(); total > 0 && req.Progress > total {
+		http.Error(w, fmt.Sprintf("progress exceeds total %s (%d)", book.progressUnit(), total), http.StatusBadRequest)
+		return
+	}
+
+	rawResp, _, err := execute(supabaseClient.From("books").Update(map[string]interface{}{
+		"progress":   req.Progress,
+		"updated_at": clock.Now(),
+	}, "", "").Eq("book_id", book.BookID).Eq("user_id", book.UserID))
+	if err != nil {
+		log.Printf("[ERROR] handleProgress update error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to update progress: %v", err), http.StatusInternalServerError)
+		return
+	}
+	emitBookRows("book.updated", rawResp)
+	// ヒートマップ用の履歴。失敗しても進捗の更新自体は成功として返す。
+	if _, _, err := executeOnce(supabaseClient.From("progress_logs").Insert(map[string]interface{}{
+		"book_id":  book.BookID,
+		"user_id":  book.UserID,
+		"progress": req.Progress,
+	}, false, "", "minimal", "")); err != nil {
+		log.Printf("[WARNING] failed to log progress for book %s: %v", book.BookID, err)
+	}
+
+	book.Progress = req.Progress
+	emitBookEvent(BookEvent{Type: eventProgressLogged, BookID: book.BookID, UserID: book.UserID, Book: &book})
+	// 記録した分をペースにも反映する (次の予測から使う)
+	pace, err := recalibrateReadingPace(book.UserID)
+	if err != nil {
+		log.Printf("[WARNING] failed to recalibrate pace for user %s: %v", book.UserID, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":                "Progress updated successfully",
+		"progress":               book.Progress,
+		"unit":                   book.progressUnit(),
+		"progress_percent":       book.progressPercent(),
+		"estimated_minutes_left": estimateMinutesLeft(book, userMinutesPerPage(book.UserID)),
+		"estimated_finish":       forecastFinish(book, pace, clock.Now()),
+		"pace":                   pace,
+	})
+}
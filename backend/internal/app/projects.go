@@ -0,0 +1,441 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// 資格試験や講義など、最終期限を共有する本のまとまり (プロジェクト)。
+// 残りの日数を本ごとの残りの分量 (普段のペースで何日分か) で割り振って各本の目標日を決め、
+// 遅れているか最終期限が近いときは1日1回 cron から進み具合を知らせる。
+const (
+	jobKindProject         = "project" // プロジェクトの進み具合のお知らせ
+	maxProjectNameLength   = 100
+	projectDeadlineNearing = 7 * 24 * time.Hour
+)
+
+var errProjectNotFound = errors.New("project not found")
+
+// Project は projects の1行
+type Project struct {
+	ProjectID      string           `json:"project_id"`
+	UserID         string           `json:"user_id"`
+	Name           string           `json:"name"`
+	Deadline       time.Time        `json:"deadline"`
+	LastRemindedAt *time.Time       `json:"last_reminded_at,omitempty"`
+	CreatedAt      time.Time        `json:"created_at"`
+	UpdatedAt      time.Time        `json:"updated_at"`
+	Progress       *ProjectProgress `json:"progress,omitempty"`
+}
+
+// ProjectPlanItem は未読了の1冊の割り当て
+type ProjectPlanItem struct {
+	BookID      string    `json:"book_id"`
+	Title       string    `json:"title"`
+	Remaining   int       `json:"remaining"` // 残りの分量 (分からなければ 0)
+	Unit        string    `json:"unit"`      // pages, minutes
+	TargetDate  time.Time `json:"target_date"`
+	NeededDaily int       `json:"needed_per_day"` // 目標日に間に合わせるための1日あたりの量
+}
+
+// ProjectProgress はプロジェクト全体の進み具合
+type ProjectProgress struct {
+	TotalBooks     int               `json:"total_books"`
+	CompletedBooks int               `json:"completed_books"`
+	Percent        int               `json:"percent"` // 本ごとの進捗の平均
+	DaysLeft       int               `json:"days_left"`
+	Load           float64           `json:"load"` // 必要なペース ÷ 普段のペース。1 を超えると間に合わない
+	OnTrack        bool              `json:"on_track"`
+	Overdue        bool              `json:"overdue"`
+	Plan           []ProjectPlanItem `json:"plan,omitempty"`
+}
+
+func validateProject(p *Project) error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return fmt.Errorf("name required")
+	}
+	if len([]rune(p.Name)) > maxProjectNameLength {
+		return fmt.Errorf("name too long (max %d characters)", maxProjectNameLength)
+	}
+	if p.Deadline.IsZero() {
+		return fmt.Errorf("deadline required")
+	}
+	return nil
+}
+
+func fetchProject(projectID, userID string) (Project, error) {
+	if projectID == "" || userID == "" {
+		return Project{}, errProjectNotFound
+	}
+	resp, _, err := execute(supabaseClient.From("projects").Select("*", "", false).Eq("project_id", projectID).Eq("user_id", userID))
+	if err != nil {
+		return Project{}, err
+	}
+	var projects []Project
+	if err := json.Unmarshal(resp, &projects); err != nil {
+		return Project{}, err
+	}
+	if len(projects) == 0 {
+		return Project{}, errProjectNotFound
+	}
+	return projects[0], nil
+}
+
+func writeProjectLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errProjectNotFound) {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("[ERROR] project lookup error: %v", err)
+	http.Error(w, fmt.Sprintf("failed to fetch project: %v", err), http.StatusInternalServerError)
+}
+
+// validProjectRef は空文字 (外す) かユーザーのプロジェクトなら nil
+func validProjectRef(projectID, userID string) error {
+	if projectID == "" {
+		return nil
+	}
+	_, err := fetchProject(projectID, userID)
+	return err
+}
+
+// projectBooks はプロジェクトに入っている本 (アーカイブ・諦めた本・欲しい本・入荷待ちは除く)
+func projectBooks(books []Book, projectID string) []Book {
+	var result []Book
+	for _, b := range excludeArchived(books) {
+		if b.ProjectID != nil && *b.ProjectID == projectID && b.Status != statusAbandoned && !deadlineless(b.Status) {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+// projectProgress は books (プロジェクトの本) の進み具合と、未読了の本の目標日を求める。
+// 本の重みは残りの分量を普段のペースで読んだ日数。分量が分からない本は分かっている本の平均とみなす。
+func projectProgress(project Project, books []Book, pace readingPace, now time.Time) ProjectProgress {
+	progress := ProjectProgress{TotalBooks: len(books)}
+	var pending []Book
+	var weights []float64
+	fraction, known, knownDays := 0.0, 0, 0.0
+	for _, b := range books {
+		if b.Status == "completed" {
+			progress.CompletedBooks++
+			fraction++
+			continue
+		}
+		weight := 0.0
+		if total := b.totalUnits(); total > 0 {
+			fraction += math.Min(1, float64(b.Progress)/float64(total))
+			if remaining := total - b.Progress; remaining > 0 && pace.perDay(b) > 0 {
+				weight = float64(remaining) / pace.perDay(b)
+				known++
+				knownDays += weight
+			}
+		}
+		pending = append(pending, b)
+		weights = append(weights, weight)
+	}
+	if len(books) > 0 {
+		progress.Percent = int(math.Round(fraction / float64(len(books)) * 100))
+	}
+	progress.Overdue = !now.Before(project.Deadline) && len(pending) > 0
+	daysLeft := project.Deadline.Sub(now).Hours() / 24
+	progress.DaysLeft = max(0, int(math.Ceil(daysLeft)))
+	if len(pending) == 0 {
+		progress.OnTrack = true
+		return progress
+	}
+
+	fallback := 1.0
+	if known > 0 {
+		fallback = knownDays / float64(known)
+	}
+	total := 0.0
+	for i := range weights {
+		if weights[i] == 0 {
+			weights[i] = fallback
+		}
+		total += weights[i]
+	}
+	// 自分で決めた期限の早い順に読む前提で割り振る
+	order := make([]int, len(pending))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return pending[order[i]].Deadline.Before(pending[order[j]].Deadline) })
+
+	span := math.Max(daysLeft, 1)
+	progress.Load = math.Round(total/span*100) / 100
+	progress.OnTrack = !progress.Overdue && total <= span
+	elapsed := 0.0
+	for _, i := range order {
+		b := pending[i]
+		share := span * weights[i] / total
+		elapsed += share
+		item := ProjectPlanItem{
+			BookID:     b.BookID,
+			Title:      b.Title,
+			Unit:       "pages",
+			TargetDate: now.Add(time.Duration(elapsed * float64(24*time.Hour))),
+		}
+		if b.isTimeBased() {
+			item.Unit = "minutes"
+		}
+		if total := b.totalUnits(); total > b.Progress {
+			item.Remaining = total - b.Progress
+			item.NeededDaily = int(math.Ceil(float64(item.Remaining) / math.Max(share, 1)))
+		}
+		progress.Plan = append(progress.Plan, item)
+	}
+	return progress
+}
+
+// withProgress は本と読書ペースを読んで project.Progress を埋める
+func (p *Project) withProgress(books []Book, now time.Time) {
+	pace, err := userReadingPace(p.UserID)
+	if err != nil {
+		log.Printf("[WARNING] pace lookup failed for user %s: %v", p.UserID, err)
+	}
+	progress := projectProgress(*p, projectBooks(books, p.ProjectID), pace, now)
+	p.Progress = &progress
+}
+
+// handleProjects は /api/projects。GET ?userId で一覧 (進み具合付き)、POST {user_id, name, deadline} で作成する。
+func handleProjects(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		userId := r.URL.Query().Get("userId")
+		if userId == "" {
+			http.Error(w, "userId required", http.StatusBadRequest)
+			return
+		}
+		resp, _, err := execute(supabaseClient.From("projects").
+			Select("*", "", false).
+			Eq("user_id", userId).
+			Order("deadline", &postgrest.OrderOpts{Ascending: true}))
+		if err != nil {
+			log.Printf("[ERROR] handleProjects list error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch projects: %v", err), http.StatusInternalServerError)
+			return
+		}
+		projects := []Project{}
+		if err := json.Unmarshal(resp, &projects); err != nil {
+			http.Error(w, fmt.Sprintf("failed to parse projects: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if len(projects) > 0 {
+			books, _, err := loadUserBooks(userId)
+			if err != nil {
+				log.Printf("[ERROR] handleProjects books error: %v", err)
+				http.Error(w, fmt.Sprintf("failed to fetch books: %v", err), http.StatusInternalServerError)
+				return
+			}
+			now := clock.Now()
+			for i := range projects {
+				projects[i].withProgress(books, now)
+				projects[i].Progress.Plan = nil
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(projects)
+
+	case http.MethodPost:
+		var project Project
+		if err := json.NewDecoder(r.Body).Decode(&project); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if project.UserID == "" {
+			http.Error(w, "user_id required", http.StatusBadRequest)
+			return
+		}
+		if err := validateProject(&project); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rawResp, _, err := executeOnce(supabaseClient.From("projects").Insert(map[string]interface{}{
+			"user_id":  project.UserID,
+			"name":     project.Name,
+			"deadline": project.Deadline,
+		}, false, "", "", ""))
+		if err != nil {
+			log.Printf("[ERROR] handleProjects insert error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to create project: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(rawResp)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleProject は /api/projects/{id}。GET ?userId で進み具合と本ごとの目標日、
+// PUT {user_id, name, deadline} で更新、DELETE ?userId で削除する (本はプロジェクトから外れるだけ)。
+func handleProject(w http.ResponseWriter, r *http.Request) {
+	projectID := r.PathValue("id")
+	switch r.Method {
+	case http.MethodGet:
+		userId := r.URL.Query().Get("userId")
+		project, err := fetchProject(projectID, userId)
+		if err != nil {
+			writeProjectLookupError(w, err)
+			return
+		}
+		books, _, err := loadUserBooks(userId)
+		if err != nil {
+			log.Printf("[ERROR] handleProject books error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch books: %v", err), http.StatusInternalServerError)
+			return
+		}
+		project.withProgress(books, clock.Now())
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"project": project, "books": projectBooks(books, projectID)})
+
+	case http.MethodPut:
+		var project Project
+		if err := json.NewDecoder(r.Body).Decode(&project); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := validateProject(&project); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rawResp, _, err := execute(supabaseClient.From("projects").Update(map[string]interface{}{
+			"name":       project.Name,
+			"deadline":   project.Deadline,
+			"updated_at": clock.Now(),
+		}, "", "").Eq("project_id", projectID).Eq("user_id", project.UserID))
+		if err != nil {
+			log.Printf("[ERROR] handleProject update error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to update project: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if string(rawResp) == "[]" {
+			http.Error(w, "Project not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(rawResp)
+
+	case http.MethodDelete:
+		userId := r.URL.Query().Get("userId")
+		if userId == "" {
+			http.Error(w, "userId required", http.StatusBadRequest)
+			return
+		}
+		rawResp, _, err := execute(supabaseClient.From("projects").Delete("", "").Eq("project_id", projectID).Eq("user_id", userId))
+		if err != nil {
+			log.Printf("[ERROR] handleProject delete error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to delete project: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if string(rawResp) == "[]" {
+			http.Error(w, "Project not found", http.StatusNotFound)
+			return
+		}
+		// books.project_id は外部キーで NULL になるので、一覧のキャッシュだけ捨てる
+		appCache.Delete(booksCacheKey(userId))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Project deleted successfully"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// enqueueProjectReminders は遅れているか最終期限が1週間以内のプロジェクトについて、
+// 次に読む本と1日あたりの量を知らせる。1つのプロジェクトにつき JST で1日1回まで。
+func enqueueProjectReminders(users *userResolver, now time.Time) (int, error) {
+	resp, _, err := execute(supabaseClient.From("projects").
+		Select("*", "", false).
+		Gt("deadline", now.Format(time.RFC3339)))
+	if err != nil {
+		return 0, err
+	}
+	var projects []Project
+	if err := json.Unmarshal(resp, &projects); err != nil {
+		return 0, err
+	}
+	y, m, d := now.In(jst).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, jst)
+
+	count := 0
+	for _, project := range projects {
+		if project.LastRemindedAt != nil && !project.LastRemindedAt.Before(today) {
+			continue
+		}
+		books, _, err := loadUserBooks(project.UserID)
+		if err != nil {
+			log.Printf("[ERROR] failed to load books for project %s: %v", project.ProjectID, err)
+			continue
+		}
+		project.withProgress(books, now)
+		progress := project.Progress
+		if len(progress.Plan) == 0 || (progress.OnTrack && project.Deadline.Sub(now) > projectDeadlineNearing) {
+			continue
+		}
+		next := progress.Plan[0]
+		var book Book
+		for _, b := range books {
+			if b.BookID == next.BookID {
+				book = b
+			}
+		}
+		if book.isMuted(now) {
+			continue
+		}
+		channel, lineUserID, ok := notifyTarget(users, book)
+		if !ok {
+			continue
+		}
+		err = enqueueJob(NotificationJob{
+			Kind:       jobKindProject,
+			BookID:     book.BookID,
+			UserID:     project.UserID,
+			Channel:    channel,
+			LineUserID: lineUserID,
+			Message:    projectReminderMessage(project, next),
+			RunAt:      now,
+		})
+		if err != nil {
+			log.Printf("[ERROR] failed to enqueue project reminder for %s: %v", project.ProjectID, err)
+			continue
+		}
+		execute(supabaseClient.From("projects").Update(map[string]interface{}{"last_reminded_at": now}, "minimal", "").Eq("project_id", project.ProjectID))
+		count++
+	}
+	return count, nil
+}
+
+func projectReminderMessage(project Project, next ProjectPlanItem) string {
+	p := project.Progress
+	var b strings.Builder
+	fmt.Fprintf(&b, "📚「%s」はあと%d日で%d冊中%d冊が読了 (%d%%)。", project.Name, p.DaysLeft, p.TotalBooks, p.CompletedBooks, p.Percent)
+	if !p.OnTrack {
+		fmt.Fprintf(&b, "普段のペースの%.1f倍で読まないと間に合いません。", p.Load)
+	}
+	fmt.Fprintf(&b, "\n次は『%s』を%sまでに", next.Title, next.TargetDate.In(jst).Format("1/2"))
+	if next.NeededDaily > 0 {
+		unit := "ページ"
+		if next.Unit == "minutes" {
+			unit = "分"
+		}
+		fmt.Fprintf(&b, " (1日%d%s)", next.NeededDaily, unit)
+	}
+	b.WriteString("読み終えましょう。")
+	return b.String()
+}
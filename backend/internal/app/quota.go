@@ -0,0 +1,123 @@
+package app
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	quotaCacheKey      = "line:quota"
+	quotaCacheTTL      = 10 * time.Minute
+	quotaDegradeRatio  = 0.9 // LINE_QUOTA_DEGRADE_PERCENT の既定値 (90%)
+	quotaDegradeReason = "quota"
+)
+
+// LineQuota は今月の送信数と上限。上限のないプランでは Limited が false。
+type LineQuota struct {
+	Limited   bool      `json:"limited"`
+	Limit     int       `json:"limit"`
+	Used      int       `json:"used"`
+	Ratio     float64   `json:"ratio"`
+	Degraded  bool      `json:"degraded"` // 督促などを止めて週次レポートだけにしている
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// degradeThreshold は LINE_QUOTA_DEGRADE_PERCENT (1〜100) を割合にしたもの
+func degradeThreshold() float64 {
+	if p := envInt("LINE_QUOTA_DEGRADE_PERCENT", 0); p > 0 && p <= 100 {
+		return float64(p) / 100
+	}
+	return quotaDegradeRatio
+}
+
+// fetchLineQuota は Messaging API の quota と consumption を問い合わせる
+func fetchLineQuota() (*LineQuota, error) {
+	qBody, err := lineAPI(http.MethodGet, lineAPIBase+"/message/quota", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	var quota struct {
+		Type  string `json:"type"` // none, limited
+		Value int    `json:"value"`
+	}
+	if err := json.Unmarshal(qBody, &quota); err != nil {
+		return nil, err
+	}
+	cBody, err := lineAPI(http.MethodGet, lineAPIBase+"/message/quota/consumption", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	var consumption struct {
+		TotalUsage int `json:"totalUsage"`
+	}
+	if err := json.Unmarshal(cBody, &consumption); err != nil {
+		return nil, err
+	}
+
+	q := &LineQuota{Limited: quota.Type == "limited", Limit: quota.Value, Used: consumption.TotalUsage, CheckedAt: time.Now()}
+	if q.Limited && q.Limit > 0 {
+		q.Ratio = float64(q.Used) / float64(q.Limit)
+		q.Degraded = q.Ratio >= degradeThreshold()
+	}
+	return q, nil
+}
+
+// currentLineQuota はキャッシュ越しに quota を返す。送信のたびに LINE に問い合わせないため。
+func currentLineQuota() (*LineQuota, error) {
+	if cached, ok := appCache.Get(quotaCacheKey); ok {
+		var q LineQuota
+		if json.Unmarshal(cached, &q) == nil {
+			return &q, nil
+		}
+	}
+	q, err := fetchLineQuota()
+	if err != nil {
+		return nil, err
+	}
+	if body, err := json.Marshal(q); err == nil {
+		appCache.Set(quotaCacheKey, body, quotaCacheTTL)
+	}
+	return q, nil
+}
+
+// quotaAllows は上限が近いときに、週次・月次レポートと賭けの発動以外の送信を止める。quota が取得できなければ送る。
+func quotaAllows(kind string) bool {
+	if kind == jobKindDigest || kind == jobKindMonthly || kind == jobKindStake {
+		return true
+	}
+	q, err := currentLineQuota()
+	if err != nil {
+		log.Printf("[WARNING] failed to check LINE quota: %v", err)
+		return true
+	}
+	return !q.Degraded
+}
+
+// handleLineQuota は GET /api/admin/line/quota。今月の送信数と上限、縮退中かどうかを返す。?refresh=true でキャッシュを使わない。
+func handleLineQuota(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if refresh, _ := strconv.ParseBool(r.URL.Query().Get("refresh")); refresh {
+		appCache.Delete(quotaCacheKey)
+	}
+	q, err := currentLineQuota()
+	if err != nil {
+		log.Printf("[ERROR] handleLineQuota error: %v", err)
+		http.Error(w, "failed to fetch LINE quota", http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"quota":             q,
+		"degrade_threshold": degradeThreshold(),
+	})
+}
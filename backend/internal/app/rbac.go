@@ -0,0 +1,143 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// ロールによるアクセス制御。users.role は user < moderator < admin の順に強い。
+// 管理用 API は ADMIN_API_TOKEN (運用のスクリプト・cron 向け) か、ロールを持つユーザーのログインセッションで呼べる。
+// ADMIN_API_TOKEN が未設定なら admin ロールのセッションだけが管理用 API を呼べる。
+// ワークスペース内の役割 (workspaces.go) とは別で、こちらはインスタンス全体の権限。
+const (
+	roleUser      = "user"
+	roleModerator = "moderator" // 督促文の審査など
+	roleAdmin     = "admin"
+)
+
+var roles = []string{roleUser, roleModerator, roleAdmin}
+
+type actorRoleKey struct{}
+
+// roleAtLeast は role が min 以上の権限か
+func roleAtLeast(role, min string) bool {
+	return slices.Contains(roles, role) && slices.Index(roles, role) >= slices.Index(roles, min)
+}
+
+func fetchUserRole(userID string) (string, error) {
+	resp, _, err := execute(supabaseClient.From("users").Select("role", "", false).Eq("id", userID))
+	if err != nil {
+		return "", err
+	}
+	var users []struct {
+		Role string `json:"role"`
+	}
+	if json.Unmarshal(resp, &users); len(users) == 0 || users[0].Role == "" {
+		return roleUser, nil
+	}
+	return users[0].Role, nil
+}
+
+// actorRole はリクエストした人のロール。管理用トークンなら admin、ログインしていなければ空文字。
+// requireRole を通ったリクエストでは調べた結果を使い回す。
+func actorRole(r *http.Request) string {
+	if role, ok := r.Context().Value(actorRoleKey{}).(string); ok {
+		return role
+	}
+	if adminTokenValid(r) {
+		return roleAdmin
+	}
+	session, err := authenticateSession(r)
+	if err != nil {
+		if !errors.Is(err, errNoSession) {
+			log.Printf("[ERROR] role check session error: %v", err)
+		}
+		return ""
+	}
+	role, err := fetchUserRole(session.UserID)
+	if err != nil {
+		log.Printf("[ERROR] role lookup error for user %s: %v", session.UserID, err)
+		return ""
+	}
+	return role
+}
+
+// authorizeModerator は督促文の審査などの認証。moderator 以上なら通す。
+func authorizeModerator(r *http.Request) bool {
+	return roleAtLeast(actorRole(r), roleModerator)
+}
+
+// requireRole は min 以上のロールがなければ 401 / 403 を返すミドルウェア
+func requireRole(min string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role := actorRole(r)
+		if role == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !roleAtLeast(role, min) {
+			log.Printf("[WARNING] %s %s denied: role %s is below %s", r.Method, r.URL.Path, role, min)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), actorRoleKey{}, role)))
+	}
+}
+
+// handleRoles は GET /api/admin/roles。user 以外のロールを持つユーザーを返す。
+func handleRoles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	resp, _, err := execute(supabaseClient.From("users").
+		Select("id, display_name, role", "", false).
+		Neq("role", roleUser).
+		Order("role", &postgrest.OrderOpts{Ascending: true}))
+	if err != nil {
+		log.Printf("[ERROR] handleRoles query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch roles: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+// handleUserRole は PUT /api/admin/users/{id}/role {role}。ロールを付け替える。
+func handleUserRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !slices.Contains(roles, req.Role) {
+		http.Error(w, "role must be user, moderator or admin", http.StatusBadRequest)
+		return
+	}
+	userID := r.PathValue("id")
+	resp, _, err := execute(supabaseClient.From("users").
+		Update(map[string]interface{}{"role": req.Role, "updated_at": time.Now()}, "", "").
+		Eq("id", userID))
+	if err != nil {
+		log.Printf("[ERROR] handleUserRole update error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to update role: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if string(resp) == "[]" {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("[INFO] role of user %s set to %s", userID, req.Role)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Role updated", "user_id": userID, "role": req.Role})
+}
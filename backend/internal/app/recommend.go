@@ -0,0 +1,132 @@
+package app
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	recommendDefaultLimit = 5
+	recommendMaxLimit     = 20
+	recommendStaleDays    = 180 // これ以上寝かせた本は放置の点数が満点
+)
+
+// 次に読む本の点数の内訳の重み。期限の近さを最優先し、読み切れるか・放置期間で並びを整える。
+const (
+	recommendWeightUrgency = 0.5
+	recommendWeightFit     = 0.3
+	recommendWeightStale   = 0.2
+)
+
+// Recommendation は次に読む本の候補と、その理由
+type Recommendation struct {
+	Book       BookRef  `json:"book"`
+	Score      float64  `json:"score"`       // 0〜1
+	DaysNeeded *int     `json:"days_needed"` // 今のペースで読み切るのにかかる日数 (分量が分からなければ null)
+	Reasons    []string `json:"reasons"`
+}
+
+// recommendNext はまだ読み始めていない本を点数の高い順に並べる
+func recommendNext(books []Book, pace readingPace, now time.Time) []Recommendation {
+	recs := []Recommendation{}
+	for _, b := range books {
+		if (b.Status != "unread" && b.Status != "insulted") || b.Archived || b.Deadline.IsZero() {
+			continue
+		}
+		rec := Recommendation{Book: BookRef{BookID: b.BookID, Title: b.Title, Deadline: b.Deadline}}
+		daysLeft := b.Deadline.Sub(now).Hours() / 24
+
+		// 期限の近さ。過ぎていれば満点。
+		urgency := 1.0
+		if daysLeft >= 0 {
+			urgency = 1 / (1 + daysLeft/7)
+			rec.Reasons = append(rec.Reasons, fmt.Sprintf("期限まであと%d日", int(daysLeft)))
+		} else {
+			rec.Reasons = append(rec.Reasons, fmt.Sprintf("期限を%d日過ぎています", int(-daysLeft)))
+		}
+
+		// 残りの日数で読み切れるか。過ぎた本は1週間で読む前提で見る。
+		fit := 0.5
+		if remaining := b.totalUnits() - b.Progress; b.totalUnits() > 0 && remaining > 0 && pace.perDay(b) > 0 {
+			needed := int(math.Ceil(float64(remaining) / pace.perDay(b)))
+			rec.DaysNeeded = &needed
+			available := 7.0
+			if daysLeft >= 0 {
+				available = math.Max(1, daysLeft)
+			}
+			fit = math.Min(1, available/float64(needed))
+			if float64(needed) <= available {
+				rec.Reasons = append(rec.Reasons, fmt.Sprintf("今のペースなら約%d日で読み切れます", needed))
+			} else {
+				unit := "ページ"
+				if b.isTimeBased() {
+					unit = "分"
+				}
+				rec.Reasons = append(rec.Reasons, fmt.Sprintf("間に合わせるには1日%d%s必要です", int(math.Ceil(float64(remaining)/available)), unit))
+			}
+		}
+
+		// 放置期間
+		idle := now.Sub(b.CreatedAt).Hours() / 24
+		stale := math.Min(1, idle/recommendStaleDays)
+		if idle >= 30 {
+			rec.Reasons = append(rec.Reasons, fmt.Sprintf("登録から%d日放置しています", int(idle)))
+		}
+
+		rec.Score = math.Round((recommendWeightUrgency*urgency+recommendWeightFit*fit+recommendWeightStale*stale)*1000) / 1000
+		recs = append(recs, rec)
+	}
+	slices.SortStableFunc(recs, func(a, b Recommendation) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return a.Book.Deadline.Compare(b.Book.Deadline)
+	})
+	return recs
+}
+
+// handleRecommendNext は GET /api/books/recommend-next?userId=...&limit=...
+func handleRecommendNext(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+	limit := recommendDefaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > recommendMaxLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", recommendMaxLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	books, _, err := loadUserBooks(userId)
+	if err != nil {
+		log.Printf("[ERROR] handleRecommendNext error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	pace, err := userReadingPace(userId)
+	if err != nil {
+		log.Printf("[ERROR] handleRecommendNext pace error: %v", err)
+	}
+	recs := recommendNext(books, pace, clock.Now())
+	if len(recs) > limit {
+		recs = recs[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recommendations": recs, "pace": pace})
+}
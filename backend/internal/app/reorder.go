@@ -0,0 +1,94 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// handleReorderBooks は PUT /api/books/reorder。book_ids の並び順を「次に読む」キューとして保存する。
+func handleReorderBooks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID  string   `json:"user_id"`
+		BookIDs []string `json:"book_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" || len(req.BookIDs) == 0 {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, id := range req.BookIDs {
+		if seen[id] {
+			http.Error(w, fmt.Sprintf("duplicate book id %s", id), http.StatusBadRequest)
+			return
+		}
+		seen[id] = true
+	}
+
+	resp, _, err := execute(supabaseClient.From("books").Select("book_id", "", false).Eq("user_id", req.UserID).In("book_id", req.BookIDs))
+	if err != nil {
+		log.Printf("[ERROR] handleReorderBooks query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to reorder books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var owned []Book
+	json.Unmarshal(resp, &owned)
+	if len(owned) != len(req.BookIDs) {
+		http.Error(w, "Some books were not found", http.StatusNotFound)
+		return
+	}
+
+	if err := saveSortOrder(r.Context(), req.UserID, req.BookIDs); err != nil {
+		log.Printf("[ERROR] handleReorderBooks update error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to reorder books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	emitBookEvent(BookEvent{Type: "book.reordered", UserID: req.UserID})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Books reordered successfully"})
+}
+
+// saveSortOrder は並び順を sort_order に1文で書き込む。直接接続がなければ save_sort_order (schema.sql) を呼ぶ。
+func saveSortOrder(ctx context.Context, userID string, bookIDs []string) error {
+	now := clock.Now()
+	if sqlDB != nil {
+		return withTx(ctx, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, `SELECT save_sort_order($1, $2, $3)`, userID, bookIDs, now)
+			return err
+		})
+	}
+	_, _, err := executeOnce(rpcQuery{name: "save_sort_order", args: map[string]interface{}{
+		"p_user_id": userID, "p_book_ids": bookIDs, "p_now": now,
+	}})
+	return err
+}
+
+// nextUpBook は「次に読む」本を返す。並び順が未設定なら期限が最も近い本。
+func nextUpBook(books []Book) *Book {
+	var next *Book
+	for i := range books {
+		b := &books[i]
+		if next == nil {
+			next = b
+			continue
+		}
+		switch {
+		case b.SortOrder != nil && next.SortOrder == nil:
+			next = b
+		case b.SortOrder != nil && next.SortOrder != nil && *b.SortOrder < *next.SortOrder:
+			next = b
+		case b.SortOrder == nil && next.SortOrder == nil && b.Deadline.Before(next.Deadline):
+			next = b
+		}
+	}
+	return next
+}
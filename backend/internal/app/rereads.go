@@ -0,0 +1,134 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// ReadCycle は1冊を1回読み通した記録。完了した回は book_completions から、読書中の回は books から組み立てる。
+type ReadCycle struct {
+	Cycle       int        `json:"cycle"`
+	StartedAt   time.Time  `json:"started_at"`
+	Deadline    *time.Time `json:"deadline"`
+	Progress    int        `json:"progress"`
+	CompletedAt *time.Time `json:"completed_at"`
+	DaysEarly   *int       `json:"days_early"`
+}
+
+// readStartedAt は現在の回を読み始めた日時 (1回目は登録日時)
+func readStartedAt(book Book) time.Time {
+	if book.ReadStartedAt != nil {
+		return *book.ReadStartedAt
+	}
+	return book.CreatedAt
+}
+
+// handleReread は POST /api/books/{id}/reread。読了済みの本を新しい回として読み直す。
+// 前の回の記録は book_completions に残したまま、期限と進捗だけをリセットする。
+func handleReread(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID   string     `json:"user_id"`
+		Deadline *time.Time `json:"deadline"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	book, err := fetchOwnedBook(r.PathValue("id"), req.UserID)
+	if err != nil {
+		writeBookLookupError(w, err)
+		return
+	}
+	if book.Status != "completed" {
+		http.Error(w, "Only completed books can be re-read", http.StatusConflict)
+		return
+	}
+
+	deadline := clock.Now().Add(defaultDeadlineOffset)
+	if req.Deadline != nil {
+		if !req.Deadline.After(clock.Now()) {
+			http.Error(w, "deadline must be in the future", http.StatusBadRequest)
+			return
+		}
+		deadline = *req.Deadline
+	}
+
+	now := clock.Now()
+	// read_cycle を条件にして、二重送信で回が飛ばないようにする
+	rawResp, _, err := execute(supabaseClient.From("books").Update(map[string]interface{}{
+		"status":          "unread",
+		"deadline":        deadline,
+		"progress":        0,
+		"read_cycle":      book.ReadCycle + 1,
+		"read_started_at": now,
+		"updated_at":      now,
+	}, "", "").Eq("book_id", book.BookID).Eq("user_id", book.UserID).Eq("status", "completed").Eq("read_cycle", strconv.Itoa(book.ReadCycle)))
+	if err != nil {
+		log.Printf("[ERROR] handleReread update error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to start re-read: %v", err), http.StatusInternalServerError)
+		return
+	}
+	emitBookRows("book.updated", rawResp)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Re-read started", "cycle": book.ReadCycle + 1, "deadline": deadline})
+}
+
+// handleListReads は GET /api/books/{id}/reads。過去の回と現在の回を古い順に返す。
+func handleListReads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	book, err := fetchOwnedBook(r.PathValue("id"), r.URL.Query().Get("userId"))
+	if err != nil {
+		writeBookLookupError(w, err)
+		return
+	}
+
+	resp, _, err := execute(supabaseClient.From("book_completions").
+		Select("*", "", false).
+		Eq("book_id", book.BookID).
+		Order("read_cycle", &postgrest.OrderOpts{Ascending: true}).
+		Order("completed_at", &postgrest.OrderOpts{Ascending: true}))
+	if err != nil {
+		log.Printf("[ERROR] handleListReads query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch reads: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var completions []Completion
+	if err := json.Unmarshal(resp, &completions); err != nil {
+		log.Printf("[ERROR] handleListReads unmarshal error: %v", err)
+		http.Error(w, "failed to parse reads", http.StatusInternalServerError)
+		return
+	}
+
+	reads := make([]ReadCycle, 0, len(completions)+1)
+	for _, c := range completions {
+		started := c.CompletedAt
+		if c.StartedAt != nil {
+			started = *c.StartedAt
+		}
+		reads = append(reads, ReadCycle{Cycle: c.ReadCycle, StartedAt: started, Deadline: &c.Deadline, CompletedAt: &c.CompletedAt, DaysEarly: &c.DaysEarly})
+	}
+	if book.Status != "completed" {
+		current := ReadCycle{Cycle: book.ReadCycle, StartedAt: readStartedAt(book), Progress: book.Progress}
+		if !book.Deadline.IsZero() {
+			current.Deadline = &book.Deadline
+		}
+		reads = append(reads, current)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"book_id": book.BookID, "current_cycle": book.ReadCycle, "reads": reads})
+}
@@ -0,0 +1,282 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// executor は Supabase (PostgREST) のクエリビルダーが満たすインターフェース
+type executor interface {
+	Execute() ([]byte, int64, error)
+}
+
+// rpcQuery は Postgres 関数の呼び出し (POST /rest/v1/rpc/{name}) を executor として扱う。
+// postgrest-go の Rpc は HTTP エラーを返さないため、PostgREST を直接呼ぶ。
+type rpcQuery struct {
+	name string
+	args interface{}
+}
+
+// supabaseRequestTimeout は Supabase (PostgREST) への1回の呼び出しの上限。本文を読み終えるまでを含む。
+const supabaseRequestTimeout = 30 * time.Second
+
+// supabaseTransport は Supabase への呼び出しに上限時間を付け、ゲートウェイの 502/503/504 を
+// upstreamStatusError にする。postgrest-go はタイムアウトのない http.Client を使い、状態コードも返さないので、
+// PostgREST のクライアントは newRESTClient で作ってこれを通す。
+type supabaseTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+var defaultSupabaseTransport = &supabaseTransport{base: http.DefaultTransport, timeout: supabaseRequestTimeout}
+
+var rpcClient = &http.Client{Transport: defaultSupabaseTransport}
+
+// upstreamStatusError は Supabase やその手前のゲートウェイが返した 502/503/504。
+// ゲートウェイの本文は PostgREST の JSON ではないので読まない。
+type upstreamStatusError struct {
+	StatusCode int
+}
+
+func (e *upstreamStatusError) Error() string {
+	return fmt.Sprintf("supabase unavailable: HTTP %d", e.StatusCode)
+}
+
+func (t *supabaseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		resp.Body.Close()
+		cancel()
+		return nil, &upstreamStatusError{StatusCode: resp.StatusCode}
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose は本文を閉じたときに RoundTrip の context を解放する
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// newRESTClient は headers で認証する PostgREST のクライアントを supabaseTransport 越しに作る
+func newRESTClient(headers map[string]string) (*postgrest.Client, error) {
+	client, err := postgrest.NewClientWithError(strings.TrimRight(os.Getenv("SUPABASE_URL"), "/")+"/rest/v1", "public", headers)
+	if err != nil {
+		return nil, err
+	}
+	client.Transport.Parent = defaultSupabaseTransport
+	return client, nil
+}
+
+func (q rpcQuery) Execute() ([]byte, int64, error) {
+	payload, err := json.Marshal(q.args)
+	if err != nil {
+		return nil, 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(os.Getenv("SUPABASE_URL"), "/")+"/rest/v1/rpc/"+q.name, bytes.NewReader(payload))
+	if err != nil {
+		return nil, 0, err
+	}
+	key := os.Getenv("SUPABASE_SERVICE_ROLE_KEY")
+	req.Header.Set("apikey", key)
+	req.Header.Set("Authorization", "Bearer "+key)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := rpcClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode >= 400 {
+		var apiErr struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &apiErr) != nil || apiErr.Message == "" {
+			return nil, 0, fmt.Errorf("rpc %s: HTTP %d", q.name, resp.StatusCode)
+		}
+		return nil, 0, fmt.Errorf("(%s) %s", apiErr.Code, apiErr.Message)
+	}
+	return body, 0, nil
+}
+
+var errCircuitOpen = errors.New("supabase is unavailable (circuit breaker open)")
+
+const (
+	dbMaxAttempts      = 3
+	dbBaseBackoff      = 200 * time.Millisecond
+	dbBreakerThreshold = 5
+	dbBreakerCooldown  = 30 * time.Second
+)
+
+// circuitBreaker は連続した一時的エラーが閾値を超えると一定時間呼び出しを遮断する
+type circuitBreaker struct {
+	name      string
+	mu        sync.Mutex
+	failures  int
+	threshold int
+	cooldown  time.Duration
+	openedAt  time.Time
+	probing   bool
+}
+
+var dbBreaker = &circuitBreaker{name: "supabase", threshold: dbBreakerThreshold, cooldown: dbBreakerCooldown}
+
+// allow は呼び出しを許可するかを返す。クールダウン後は1件だけ試行 (half-open) を通す。
+func (cb *circuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.failures < cb.threshold {
+		return true
+	}
+	if time.Since(cb.openedAt) < cb.cooldown || cb.probing {
+		return false
+	}
+	cb.probing = true
+	return true
+}
+
+func (cb *circuitBreaker) success() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.failures >= cb.threshold {
+		log.Printf("[INFO] %s circuit breaker closed", cb.name)
+	}
+	cb.failures = 0
+	cb.probing = false
+}
+
+func (cb *circuitBreaker) failure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures++
+	cb.probing = false
+	if cb.failures >= cb.threshold {
+		if cb.failures == cb.threshold {
+			log.Printf("[ERROR] %s circuit breaker opened after %d consecutive failures", cb.name, cb.failures)
+		}
+		cb.openedAt = time.Now()
+	}
+}
+
+// isOpen はブレーカーが遮断中かどうかを返す (readiness 用)
+func (cb *circuitBreaker) isOpen() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.failures >= cb.threshold && time.Since(cb.openedAt) < cb.cooldown
+}
+
+// isTransient はネットワーク障害・タイムアウト・502/503/504 など、再試行で回復しうるエラーかを判定する。
+// エラーの文言ではなく型で見る。クライアントの切断 (context.Canceled) や TLS 証明書の誤りは再試行しない。
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// execute はサーキットブレーカー越しにクエリを実行し、一時的エラーは指数バックオフで再試行する
+func execute(q executor) ([]byte, int64, error) {
+	return executeWithRetry(q, dbMaxAttempts)
+}
+
+// executeOnce は再試行しない。重複登録の恐れがある INSERT 用。
+func executeOnce(q executor) ([]byte, int64, error) {
+	return executeWithRetry(q, 1)
+}
+
+func executeWithRetry(q executor, attempts int) ([]byte, int64, error) {
+	var (
+		resp  []byte
+		count int64
+		err   error
+	)
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if !dbBreaker.allow() {
+			return nil, 0, errCircuitOpen
+		}
+
+		resp, count, err = q.Execute()
+		if !isTransient(err) {
+			// 成功、またはリクエスト内容起因のエラーはブレーカーの失敗として数えない
+			dbBreaker.success()
+			return resp, count, err
+		}
+
+		dbBreaker.failure()
+		if attempt < attempts {
+			backoff := dbBaseBackoff << (attempt - 1)
+			backoff += time.Duration(rand.Int63n(int64(backoff) / 2))
+			log.Printf("[WARNING] transient supabase error (attempt %d/%d), retrying in %s: %v", attempt, attempts, backoff, err)
+			time.Sleep(backoff)
+		}
+	}
+	return resp, count, err
+}
+
+// handleReady は Supabase のブレーカーが開いている間 503 を返す (liveness は /health)。
+// LINE やモデレーション API が止まっているだけなら縮退して動けるので 200 のまま、止まっている機能を2行目に書く。
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	if dbBreaker.isOpen() {
+		http.Error(w, "supabase unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "READY")
+	if degraded := degradedDependencies(); len(degraded) > 0 {
+		fmt.Fprintf(w, "DEGRADED %s\n", strings.Join(degraded, ","))
+	}
+	if sandboxMode() {
+		fmt.Fprintln(w, "SANDBOX")
+	}
+}
@@ -0,0 +1,116 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// retentionRule は古くなったデータを消す規則。保持日数は env で変えられ、0 なら消さない。
+// 下限より短い値は下限に揃える (督促の間隔の計算や取り消しに使っている間は消さない)。
+type retentionRule struct {
+	name        string
+	env         string
+	defaultDays int
+	minDays     int
+	purge       func(cutoff time.Time) (int64, error)
+}
+
+var retentionRules = []retentionRule{
+	// 削除した本は取り消し用に audit_log の payload に全カラムが残るので、期間を過ぎたら payload だけ消す
+	{"deleted_books", "RETENTION_DELETED_BOOKS_DAYS", 30, 1, func(cutoff time.Time) (int64, error) {
+		_, n, err := execute(supabaseClient.From("audit_log").Update(map[string]interface{}{"payload": map[string]interface{}{}}, "minimal", "exact").
+			Eq("action", actionDeleteBook).
+			Not("payload->rows", "is", "null").
+			Lt("created_at", cutoff.Format(time.RFC3339)))
+		return n, err
+	}},
+	// 送信が終わったジョブ。cadence の最長間隔より短くはしない
+	{"notification_logs", "RETENTION_NOTIFICATION_DAYS", 180, minNotificationLogRetentionDays, func(cutoff time.Time) (int64, error) {
+		_, n, err := execute(supabaseClient.From("notification_jobs").Delete("minimal", "exact").
+			In("status", finishedJobStatuses).
+			Lt("created_at", cutoff.Format(time.RFC3339)))
+		return n, err
+	}},
+	// 期限切れ・失効したログインセッション
+	{"sessions", "RETENTION_SESSION_DAYS", 30, 1, func(cutoff time.Time) (int64, error) {
+		ts := cutoff.Format(time.RFC3339)
+		_, n, err := execute(supabaseClient.From("user_sessions").Delete("minimal", "exact").
+			Or(fmt.Sprintf("expires_at.lt.%s,revoked_at.lt.%s", ts, ts), ""))
+		return n, err
+	}},
+	// 重複防止キー (jobDedupeKey) は積んだ日ごとなので、送信時刻の調整で翌日に回る分を見ても2日で用済みになる
+	{"idempotency_keys", "RETENTION_IDEMPOTENCY_DAYS", 2, 2, func(cutoff time.Time) (int64, error) {
+		_, n, err := execute(supabaseClient.From("notification_jobs").Update(map[string]interface{}{"dedupe_key": nil}, "minimal", "exact").
+			Not("dedupe_key", "is", "null").
+			Lt("created_at", cutoff.Format(time.RFC3339)))
+		return n, err
+	}},
+	// 調査用に残した LINE の Webhook イベント (webhookreplay.go)。メッセージ本文を含むので長くは残さない
+	{"webhook_events", "RETENTION_WEBHOOK_EVENTS_DAYS", 14, 1, func(cutoff time.Time) (int64, error) {
+		_, n, err := execute(supabaseClient.From("line_webhook_events").Delete("minimal", "exact").
+			Lt("received_at", cutoff.Format(time.RFC3339)))
+		return n, err
+	}},
+}
+
+// days は規則の保持日数。0 なら無効。
+func (r retentionRule) days() int {
+	days := envInt(r.env, r.defaultDays)
+	if days <= 0 {
+		return 0
+	}
+	if days < r.minDays {
+		log.Printf("[WARNING] %s=%d is below the minimum; keeping %s for %d days", r.env, days, r.name, r.minDays)
+		return r.minDays
+	}
+	return days
+}
+
+// RetentionResult は規則1つ分の結果
+type RetentionResult struct {
+	Days   int    `json:"days"` // 0 なら無効
+	Purged int64  `json:"purged"`
+	Error  string `json:"error,omitempty"`
+}
+
+// purgeExpiredData はすべての規則を実行する。1つが失敗しても残りは続ける。
+func purgeExpiredData(now time.Time) map[string]RetentionResult {
+	results := make(map[string]RetentionResult, len(retentionRules))
+	for _, rule := range retentionRules {
+		res := RetentionResult{Days: rule.days()}
+		if res.Days > 0 {
+			n, err := rule.purge(now.UTC().AddDate(0, 0, -res.Days))
+			res.Purged = n
+			if err != nil {
+				log.Printf("[ERROR] retention %s failed: %v", rule.name, err)
+				res.Error = err.Error()
+			} else if n > 0 {
+				log.Printf("[INFO] retention %s: purged %d rows older than %d days", rule.name, n, res.Days)
+			}
+		}
+		results[rule.name] = res
+	}
+	return results
+}
+
+// handleRetention は /api/cron/retention。ユーザーのデータ保持の設定 (privacy.go) を反映し、全体の保持期間を過ぎたデータを消す。
+func handleRetention(w http.ResponseWriter, r *http.Request) {
+	if !authorizeCron(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	report, err := enforceRetention()
+	opsAlerts.recordCron("retention", err, clock.Now())
+	if err != nil {
+		log.Printf("[ERROR] handleRetention error: %v", err)
+		http.Error(w, fmt.Sprintf("retention failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[INFO] retention: redacted %d insults and purged %d notifications for %d users", report.RedactedInsults, report.PurgedNotification, report.Users)
+	results := purgeExpiredData(clock.Now())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"privacy": report, "rules": results})
+}
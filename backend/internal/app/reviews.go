@@ -0,0 +1,118 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	maxReviewLength  = 2000
+	reviewNudgeDelay = 48 * time.Hour
+)
+
+// handleReview は読了済みの書籍に評価 (1-5) と短いレビューを付ける
+func handleReview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID string `json:"user_id"`
+		Rating int    `json:"rating"`
+		Review string `json:"review"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Rating < 1 || req.Rating > 5 {
+		http.Error(w, "rating must be between 1 and 5", http.StatusBadRequest)
+		return
+	}
+	req.Review = strings.TrimSpace(req.Review)
+	if len([]rune(req.Review)) > maxReviewLength {
+		http.Error(w, fmt.Sprintf("review too long (max %d characters)", maxReviewLength), http.StatusBadRequest)
+		return
+	}
+
+	book, err := fetchOwnedBook(r.PathValue("id"), req.UserID)
+	if err != nil {
+		writeBookLookupError(w, err)
+		return
+	}
+	if book.Status != "completed" {
+		http.Error(w, "Only completed books can be reviewed", http.StatusConflict)
+		return
+	}
+
+	rawResp, _, err := execute(supabaseClient.From("books").Update(map[string]interface{}{
+		"rating":     req.Rating,
+		"review":     sealText("books", req.Review),
+		"updated_at": clock.Now(),
+	}, "", "").Eq("book_id", book.BookID).Eq("user_id", book.UserID))
+	if err != nil {
+		log.Printf("[ERROR] handleReview update error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to save review: %v", err), http.StatusInternalServerError)
+		return
+	}
+	emitBookRows("book.updated", rawResp)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Review saved successfully"})
+}
+
+func hasReview(bookID string) bool {
+	resp, _, err := execute(supabaseClient.From("books").Select("rating", "", false).Eq("book_id", bookID))
+	if err != nil {
+		return false
+	}
+	var books []Book
+	json.Unmarshal(resp, &books)
+	return len(books) > 0 && books[0].Rating != nil
+}
+
+// scheduleReviewNudge は読了2日後に「レビューを書きませんか」を送るジョブを積む (REVIEW_NUDGE_ENABLED=true のとき)
+func scheduleReviewNudge(book Book) {
+	if os.Getenv("REVIEW_NUDGE_ENABLED") != "true" {
+		return
+	}
+	lineUserID, err := lineUserIDFor(book.UserID)
+	if err != nil || lineUserID == "" {
+		log.Printf("[WARNING] cannot schedule review nudge for user %s: %v", book.UserID, err)
+		return
+	}
+	err = enqueueJob(NotificationJob{
+		Kind:       jobKindReviewNudge,
+		BookID:     book.BookID,
+		UserID:     book.UserID,
+		LineUserID: lineUserID,
+		Message:    renderMessage(reviewNudgeText, MessageData{Title: book.Title, Author: book.Author}, "読了から2日経ちました。忘れる前に評価と感想を残しておきませんか？"),
+		RunAt:      clock.Now().Add(reviewNudgeDelay),
+	})
+	if err != nil {
+		log.Printf("[ERROR] failed to schedule review nudge for book %s: %v", book.BookID, err)
+	}
+}
+
+// lineUserIDFor は内部ユーザーIDから LINE のユーザーIDを引く
+func lineUserIDFor(userID string) (string, error) {
+	resp, _, err := execute(supabaseClient.From("users").Select("line_user_id", "", false).Eq("id", userID))
+	if err != nil {
+		return "", err
+	}
+	var users []struct {
+		LineUserID string `json:"line_user_id"`
+	}
+	if err := json.Unmarshal(resp, &users); err != nil {
+		return "", err
+	}
+	if len(users) == 0 {
+		return "", nil
+	}
+	return users[0].LineUserID, nil
+}
@@ -0,0 +1,314 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	lineAPIBase          = "https://api.line.me/v2/bot"
+	lineDataAPIBase      = "https://api-data.line.me/v2/bot"
+	richMenuMaxImage     = 1 << 20 // LINE の上限 1MB
+	richMenuBulkLinkSize = 500     // bulk link で一度に指定できるユーザー数
+)
+
+// リッチメニューの種類。未連携のユーザーにはデフォルトメニュー (unlinked)、連携済みには linked を出す。
+const (
+	richMenuLinked   = "linked"
+	richMenuUnlinked = "unlinked"
+)
+
+var lineAPIClient = &http.Client{Timeout: 15 * time.Second}
+
+// lineAPI は default チャネルで Messaging API を呼ぶ (リッチメニューやクォータなどチャネル単位の管理用)
+func lineAPI(method, url, contentType string, body io.Reader) ([]byte, error) {
+	ch, _ := lineChannelByName(defaultLineChannel)
+	return lineChannelAPI(ch, method, url, contentType, body)
+}
+
+// liffURL は LIFF_URL (未設定なら FRONTEND_URL) に path を付けた URL
+func liffURL(path string) string {
+	base := os.Getenv("LIFF_URL")
+	if base == "" {
+		base = os.Getenv("FRONTEND_URL")
+	}
+	return strings.TrimRight(base, "/") + path
+}
+
+func richMenuArea(x, y, width, height int, label, path string) map[string]interface{} {
+	return map[string]interface{}{
+		"bounds": map[string]int{"x": x, "y": y, "width": width, "height": height},
+		"action": map[string]string{"type": "uri", "label": label, "uri": liffURL(path)},
+	}
+}
+
+// richMenuDefinition は種類ごとのメニュー構成。画像は同じレイアウトで別途アップロードする。
+//   - linked: 2500x1686 の 2x2 (本を登録・積読一覧・統計・設定)
+//   - unlinked: 2500x843 の1ボタン (アカウント連携)
+func richMenuDefinition(kind string) (map[string]interface{}, error) {
+	switch kind {
+	case richMenuLinked:
+		return map[string]interface{}{
+			"size":        map[string]int{"width": 2500, "height": 1686},
+			"selected":    true,
+			"name":        "tundoku-linked",
+			"chatBarText": "メニュー",
+			"areas": []interface{}{
+				richMenuArea(0, 0, 1250, 843, "本を登録", "/register"),
+				richMenuArea(1250, 0, 1250, 843, "積読一覧", "/books"),
+				richMenuArea(0, 843, 1250, 843, "統計", "/stats"),
+				richMenuArea(1250, 843, 1250, 843, "設定", "/settings"),
+			},
+		}, nil
+	case richMenuUnlinked:
+		return map[string]interface{}{
+			"size":        map[string]int{"width": 2500, "height": 843},
+			"selected":    true,
+			"name":        "tundoku-unlinked",
+			"chatBarText": "はじめる",
+			"areas": []interface{}{
+				richMenuArea(0, 0, 2500, 843, "アカウント連携", "/"),
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("kind must be %s or %s", richMenuLinked, richMenuUnlinked)
+}
+
+// richMenuID は line_rich_menus に保存した種類ごとのメニューID
+func richMenuID(kind string) (string, error) {
+	resp, _, err := execute(supabaseClient.From("line_rich_menus").Select("rich_menu_id", "", false).Eq("kind", kind))
+	if err != nil {
+		return "", err
+	}
+	var rows []struct {
+		RichMenuID string `json:"rich_menu_id"`
+	}
+	json.Unmarshal(resp, &rows)
+	if len(rows) == 0 {
+		return "", nil
+	}
+	return rows[0].RichMenuID, nil
+}
+
+// linkUserRichMenu は連携済みユーザーに linked メニューを表示する。メニュー未作成なら何もしない。
+func linkUserRichMenu(lineUserID string) {
+	id, err := richMenuID(richMenuLinked)
+	if err != nil || id == "" {
+		if err != nil {
+			log.Printf("[ERROR] failed to look up linked rich menu: %v", err)
+		}
+		return
+	}
+	if _, err := lineAPI(http.MethodPost, fmt.Sprintf("%s/user/%s/richmenu/%s", lineAPIBase, lineUserID, id), "", nil); err != nil {
+		log.Printf("[ERROR] failed to link rich menu for %s: %v", lineUserID, err)
+	}
+}
+
+// handleRichMenus は /api/admin/richmenus (GET: LINE 上のメニューと割り当て, POST: 種類を指定して作成)。
+// unlinked を作るとデフォルトメニューにもなる。同じ種類を作り直すと古いメニューは LINE から削除する。
+func handleRichMenus(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		menus, err := lineAPI(http.MethodGet, lineAPIBase+"/richmenu/list", "", nil)
+		if err != nil {
+			log.Printf("[ERROR] handleRichMenus list error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to list rich menus: %v", err), http.StatusBadGateway)
+			return
+		}
+		resp, _, err := execute(supabaseClient.From("line_rich_menus").Select("*", "", false))
+		if err != nil {
+			log.Printf("[ERROR] handleRichMenus query error: %v", err)
+			http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]json.RawMessage{"line": menus, "assignments": resp})
+
+	case http.MethodPost:
+		var req struct {
+			Kind string `json:"kind"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		def, err := richMenuDefinition(req.Kind)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		old, err := richMenuID(req.Kind)
+		if err != nil {
+			log.Printf("[ERROR] handleRichMenus query error: %v", err)
+			http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		body, _ := json.Marshal(def)
+		created, err := lineAPI(http.MethodPost, lineAPIBase+"/richmenu", "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("[ERROR] handleRichMenus create error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to create rich menu: %v", err), http.StatusBadGateway)
+			return
+		}
+		var result struct {
+			RichMenuID string `json:"richMenuId"`
+		}
+		json.Unmarshal(created, &result)
+
+		if _, _, err := execute(supabaseClient.From("line_rich_menus").Insert(map[string]interface{}{
+			"kind":         req.Kind,
+			"rich_menu_id": result.RichMenuID,
+			"updated_at":   clock.Now(),
+		}, true, "kind", "minimal", "")); err != nil {
+			log.Printf("[ERROR] handleRichMenus save error: %v", err)
+			http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if old != "" {
+			if _, err := lineAPI(http.MethodDelete, lineAPIBase+"/richmenu/"+old, "", nil); err != nil {
+				log.Printf("[WARNING] failed to delete old rich menu %s: %v", old, err)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{
+			"kind":         req.Kind,
+			"rich_menu_id": result.RichMenuID,
+			"message":      "Rich menu created. Upload an image before it can be shown.",
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRichMenu は DELETE /api/admin/richmenus/{id}
+func handleRichMenu(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.PathValue("id")
+	if _, err := lineAPI(http.MethodDelete, lineAPIBase+"/richmenu/"+id, "", nil); err != nil {
+		log.Printf("[ERROR] handleRichMenu delete error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to delete rich menu: %v", err), http.StatusBadGateway)
+		return
+	}
+	if _, _, err := execute(supabaseClient.From("line_rich_menus").Delete("minimal", "").Eq("rich_menu_id", id)); err != nil {
+		log.Printf("[ERROR] handleRichMenu cleanup error: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Rich menu deleted"})
+}
+
+// handleRichMenuImage は POST /api/admin/richmenus/{id}/image。本文の PNG/JPEG をそのまま LINE にアップロードし、
+// unlinked メニューならアップロード後にデフォルトメニューに設定する (画像のないメニューはデフォルトにできないため)。
+func handleRichMenuImage(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "image/png" && contentType != "image/jpeg" {
+		http.Error(w, "Content-Type must be image/png or image/jpeg", http.StatusUnsupportedMediaType)
+		return
+	}
+	img, err := io.ReadAll(io.LimitReader(r.Body, richMenuMaxImage+1))
+	if err != nil {
+		http.Error(w, "failed to read image", http.StatusBadRequest)
+		return
+	}
+	if len(img) > richMenuMaxImage {
+		http.Error(w, "image must be 1MB or smaller", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	id := r.PathValue("id")
+	if _, err := lineAPI(http.MethodPost, fmt.Sprintf("%s/richmenu/%s/content", lineDataAPIBase, id), contentType, bytes.NewReader(img)); err != nil {
+		log.Printf("[ERROR] handleRichMenuImage upload error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to upload image: %v", err), http.StatusBadGateway)
+		return
+	}
+	if unlinked, _ := richMenuID(richMenuUnlinked); unlinked == id {
+		if _, err := lineAPI(http.MethodPost, lineAPIBase+"/user/all/richmenu/"+id, "", nil); err != nil {
+			log.Printf("[ERROR] handleRichMenuImage set default error: %v", err)
+			http.Error(w, fmt.Sprintf("image uploaded but failed to set default menu: %v", err), http.StatusBadGateway)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Rich menu image uploaded"})
+}
+
+// handleRichMenuSync は POST /api/admin/richmenus/sync。連携済みの全ユーザーに linked メニューを割り当てる。
+func handleRichMenuSync(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := richMenuID(richMenuLinked)
+	if err != nil {
+		log.Printf("[ERROR] handleRichMenuSync query error: %v", err)
+		http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if id == "" {
+		http.Error(w, "linked rich menu has not been created", http.StatusConflict)
+		return
+	}
+	uResp, _, err := execute(supabaseClient.From("users").Select("line_user_id", "", false).Neq("line_user_id", ""))
+	if err != nil {
+		log.Printf("[ERROR] handleRichMenuSync user query error: %v", err)
+		http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var users []struct {
+		LineUserID string `json:"line_user_id"`
+	}
+	json.Unmarshal(uResp, &users)
+	ids := make([]string, 0, len(users))
+	for _, u := range users {
+		if u.LineUserID != "" {
+			ids = append(ids, u.LineUserID)
+		}
+	}
+
+	linked := 0
+	for start := 0; start < len(ids); start += richMenuBulkLinkSize {
+		end := min(start+richMenuBulkLinkSize, len(ids))
+		body, _ := json.Marshal(map[string]interface{}{"richMenuId": id, "userIds": ids[start:end]})
+		if _, err := lineAPI(http.MethodPost, lineAPIBase+"/richmenu/bulk/link", "application/json", bytes.NewReader(body)); err != nil {
+			log.Printf("[ERROR] handleRichMenuSync bulk link error: %v", err)
+			http.Error(w, fmt.Sprintf("failed after linking %d users: %v", linked, err), http.StatusBadGateway)
+			return
+		}
+		linked += end - start
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Rich menu linked", "count": linked})
+}
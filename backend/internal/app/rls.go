@@ -0,0 +1,99 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// dbClient は supabaseClient (service role) とユーザーの JWT 付きクライアントの共通部分
+type dbClient interface {
+	From(table string) *postgrest.QueryBuilder
+}
+
+var errNoUserJWT = errors.New("Supabase access token required in RLS mode")
+
+// rlsMode は SUPABASE_RLS_MODE=true のとき有効になる。
+// 本の CRUD (/api/books) をユーザーの JWT で PostgREST に投げ、supabase/rls.sql のポリシーで守る。
+// service role キーは cron・管理者・Webhook などサーバー内部の処理でだけ使う。
+// それ以外のルートはまだ service role でクエリの userId を信じて読み書きするので、RLS モードでは 403 にする (rlsReadyRoutes)。
+func rlsMode() bool {
+	return os.Getenv("SUPABASE_RLS_MODE") == "true"
+}
+
+// rlsReadyRoutes は RLS モードでも開けておくルート。
+// ユーザーの JWT で接続するもの (userDB)、セッション・アクセストークン・署名で呼び出し元を確かめるもの、
+// 管理者と cron 向けのもの、誰のデータも返さないものに限る。ルートを userDB に移したらここに足す。
+var rlsReadyRoutes = map[string]bool{
+	"/health":                          true,
+	"/readyz":                          true,
+	"/api/auth/liff":                   true,
+	"/api/auth/supabase":               true,
+	"/api/books":                       true,
+	"/api/events":                      true,
+	"/api/onboarding":                  true,
+	"/api/users/me/sessions":           true,
+	"/api/users/me/sessions/{id}":      true,
+	"/api/users/me/relink":             true,
+	"/api/users/me/merge":              true,
+	"/api/users/me/data-request":       true,
+	"/api/users/me/data-request/{id}":  true,
+	"/api/users/me/backup":             true,
+	"/api/users/me/restore":            true,
+	"/api/users/me/timeline":           true,
+	"/api/users/me/preferences":        true,
+	"/api/users/me/tokens":             true,
+	"/api/users/me/tokens/{id}":        true,
+	"/api/public/books":                true,
+	"/api/public/stats":                true,
+	"/api/public/triggers/{trigger}":   true,
+	"/api/public/users/{slug}":         true,
+	"/api/feeds/{token}/completed.xml": true,
+	"/api/feeds/{token}/tasks.ics":     true,
+	"/api/inbound/email":               true,
+	"/api/announcements":               true,
+	"/api/achievements":                true,
+}
+
+// rlsRouteAllowed は RLS モードで pattern のルートを通してよいか。RLS モードでなければ常に true。
+func rlsRouteAllowed(pattern string) bool {
+	if !rlsMode() || rlsReadyRoutes[pattern] {
+		return true
+	}
+	return strings.HasPrefix(pattern, "/api/admin/") || strings.HasPrefix(pattern, "/api/cron/")
+}
+
+// userDB はリクエストの権限で DB にアクセスするクライアントを返す。
+// RLS モードでなければ従来どおり supabaseClient。RLS モードでは Authorization: Bearer の
+// Supabase アクセストークンを検証し、anon キーとそのトークンで PostgREST に接続する。
+func userDB(r *http.Request) (dbClient, error) {
+	if !rlsMode() {
+		return supabaseClient, nil
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, errNoUserJWT
+	}
+	if _, err := verifySupabaseJWT(token); err != nil {
+		return nil, err
+	}
+	anonKey := os.Getenv("SUPABASE_ANON_KEY")
+	return newRESTClient(map[string]string{
+		"apikey":        anonKey,
+		"Authorization": "Bearer " + token,
+		// books の変更フィード (bookfeed.go) で API 自身の書き込みを見分ける
+		"X-Tundoku-Source": "api",
+	})
+}
+
+// writeUserDBError は userDB のエラーを 401 / 500 にする
+func writeUserDBError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNoUserJWT) || errors.Is(err, errInvalidAccessToken) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
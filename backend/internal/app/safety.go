@@ -0,0 +1,118 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const moderationTimeout = 5 * time.Second
+
+var moderationClient = &http.Client{Timeout: moderationTimeout}
+
+var (
+	errUnsafeInsult          = errors.New("insult rejected by safety filter")
+	errModerationUnavailable = errors.New("moderation API unavailable")
+)
+
+// defaultSafetyBlocklist はからかいの範囲を超える語 (暴力や自傷の示唆)。
+// INSULT_SAFETY_BLOCKLIST (カンマ区切り) で追加できる。
+var defaultSafetyBlocklist = []string{"死ね", "しね", "殺す", "殺して", "自殺", "消えろ", "生きる価値"}
+
+// blockedWord は text に含まれるブロック対象の語を返す。表記ゆれは normalizeSearchText で吸収する。
+func blockedWord(text string) string {
+	words := append([]string{}, defaultSafetyBlocklist...)
+	for _, env := range []string{"INSULT_SAFETY_BLOCKLIST", "CUSTOM_INSULT_BLOCKLIST"} {
+		words = append(words, strings.Split(os.Getenv(env), ",")...)
+	}
+	normalized := strings.ToLower(normalizeSearchText(text))
+	for _, word := range words {
+		if w := strings.ToLower(normalizeSearchText(word)); w != "" && strings.Contains(normalized, w) {
+			return word
+		}
+	}
+	return ""
+}
+
+// moderate は MODERATION_API_URL (OpenAI の moderations と同じ形式) に問い合わせ、flagged ならカテゴリを返す。
+// 未設定なら何もしない。
+func moderate(ctx context.Context, text string) ([]string, error) {
+	endpoint := os.Getenv("MODERATION_API_URL")
+	if endpoint == "" {
+		return nil, nil
+	}
+	if !moderationBreaker.allow() {
+		return nil, errors.New("circuit breaker open")
+	}
+	body, _ := json.Marshal(map[string]string{"input": text})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key := os.Getenv("MODERATION_API_KEY"); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := moderationClient.Do(req)
+	if err != nil {
+		moderationBreaker.failure()
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		moderationBreaker.failure()
+	} else {
+		moderationBreaker.success()
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("moderation API returned %d", resp.StatusCode)
+	}
+	var result struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	var flagged []string
+	for _, r := range result.Results {
+		if !r.Flagged {
+			continue
+		}
+		for category, hit := range r.Categories {
+			if hit {
+				flagged = append(flagged, category)
+			}
+		}
+		if len(flagged) == 0 {
+			flagged = append(flagged, "flagged")
+		}
+	}
+	sort.Strings(flagged)
+	return flagged, nil
+}
+
+// checkInsultSafety は定型文以外の督促 (ユーザー登録の文や生成した文) を送る前に確認する。
+// モデレーション API に問い合わせられないときは errModerationUnavailable を返す。送信時はこれも不合格として扱う。
+func checkInsultSafety(ctx context.Context, text string) error {
+	if word := blockedWord(text); word != "" {
+		return fmt.Errorf("%w: contains %q", errUnsafeInsult, word)
+	}
+	categories, err := moderate(ctx, text)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errModerationUnavailable, err)
+	}
+	if len(categories) > 0 {
+		return fmt.Errorf("%w: %s", errUnsafeInsult, strings.Join(categories, ", "))
+	}
+	return nil
+}
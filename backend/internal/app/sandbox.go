@@ -0,0 +1,175 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// サンドボックスモード (SANDBOX_MODE=true)。LINE の送信 (push, reply, multicast, broadcast) とメールを
+// 外に出さず sandbox_inbox に記録する。本番に近いデータを入れたステージングで、実在のユーザーに督促を届けずに動きを確かめる。
+// 送信したものとして扱うので、ジョブは sent になり、既読や督促の回数などの後続の処理もそのまま動く。
+const (
+	sandboxTransportLine  = "line"
+	sandboxTransportEmail = "email"
+
+	defaultSandboxLimit = 50
+	maxSandboxLimit     = 200
+)
+
+// SandboxMessage は sandbox_inbox の1行
+type SandboxMessage struct {
+	MessageID   string          `json:"message_id"`
+	Transport   string          `json:"transport"`    // line, email
+	Endpoint    string          `json:"endpoint"`     // push, reply, multicast, broadcast, email
+	LineChannel *string         `json:"line_channel"` // LINE のときの送信チャネル
+	Recipients  []string        `json:"recipients"`   // LINE ユーザーID・グループID・メールアドレス。broadcast では空
+	Subject     *string         `json:"subject"`
+	Body        json.RawMessage `json:"body"` // LINE はメッセージの配列、メールは本文の文字列
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+func sandboxMode() bool {
+	return os.Getenv("SANDBOX_MODE") == "true"
+}
+
+func captureSandboxMessage(row map[string]interface{}) error {
+	if _, _, err := executeOnce(supabaseClient.From("sandbox_inbox").Insert(row, false, "", "minimal", "")); err != nil {
+		// 記録できなくても本物は送らない。ジョブは失敗として再試行される。
+		return fmt.Errorf("sandbox: failed to capture message: %w", err)
+	}
+	return nil
+}
+
+// captureLineSend は LINE の送信 API に渡すはずだった本文を記録し、LINE の成功応答の代わりに {} を返す
+func captureLineSend(ch LineChannel, url string, body io.Reader) ([]byte, error) {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	var req struct {
+		To       json.RawMessage `json:"to"` // push は文字列、multicast は配列
+		Messages json.RawMessage `json:"messages"`
+	}
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, fmt.Errorf("sandbox: invalid LINE request body: %w", err)
+	}
+	recipients := []string{}
+	var single string
+	if json.Unmarshal(req.To, &single) == nil && single != "" {
+		recipients = append(recipients, single)
+	} else {
+		json.Unmarshal(req.To, &recipients)
+	}
+	endpoint := path.Base(url)
+	if err := captureSandboxMessage(map[string]interface{}{
+		"transport":    sandboxTransportLine,
+		"endpoint":     endpoint,
+		"line_channel": ch.Name,
+		"recipients":   recipients,
+		"body":         req.Messages,
+	}); err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] sandbox: captured LINE %s to %v", endpoint, recipients)
+	return []byte("{}"), nil
+}
+
+// captureEmail はメールを送らずに記録する
+func captureEmail(to, subject, body string) error {
+	if err := captureSandboxMessage(map[string]interface{}{
+		"transport":  sandboxTransportEmail,
+		"endpoint":   sandboxTransportEmail,
+		"recipients": []string{to},
+		"subject":    subject,
+		"body":       body,
+	}); err != nil {
+		return err
+	}
+	log.Printf("[INFO] sandbox: captured email to %s", to)
+	return nil
+}
+
+// handleSandboxInbox は /api/admin/sandbox/inbox。
+// GET ?userId=...&transport=line|email&limit=50 で新しい順に返す (userId はその人の LINE ユーザーIDと通知用アドレスで絞る)。
+// DELETE で全件消す。
+func handleSandboxInbox(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		limit := defaultSandboxLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, maxSandboxLimit)
+		}
+		q := supabaseClient.From("sandbox_inbox").Select("*", "", false)
+		if t := r.URL.Query().Get("transport"); t != "" {
+			if t != sandboxTransportLine && t != sandboxTransportEmail {
+				http.Error(w, "transport must be line or email", http.StatusBadRequest)
+				return
+			}
+			q = q.Eq("transport", t)
+		}
+		if userID := r.URL.Query().Get("userId"); userID != "" {
+			settings, err := fetchNotifySettings(userID)
+			if err != nil {
+				log.Printf("[ERROR] handleSandboxInbox user lookup error: %v", err)
+				http.Error(w, fmt.Sprintf("failed to fetch user: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if settings == nil {
+				http.Error(w, "User not found", http.StatusNotFound)
+				return
+			}
+			var addresses []string
+			if settings.LineUserID != nil {
+				addresses = append(addresses, *settings.LineUserID)
+			}
+			if settings.NotifyEmail != nil && *settings.NotifyEmail != "" {
+				addresses = append(addresses, *settings.NotifyEmail)
+			}
+			if len(addresses) == 0 {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(map[string]interface{}{"sandbox": sandboxMode(), "messages": []SandboxMessage{}})
+				return
+			}
+			q = q.Overlaps("recipients", addresses)
+		}
+		resp, _, err := execute(q.Order("created_at", &postgrest.OrderOpts{Ascending: false}).Limit(limit, ""))
+		if err != nil {
+			log.Printf("[ERROR] handleSandboxInbox query error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch inbox: %v", err), http.StatusInternalServerError)
+			return
+		}
+		messages := []SandboxMessage{}
+		json.Unmarshal(resp, &messages)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"sandbox": sandboxMode(), "messages": messages})
+
+	case http.MethodDelete:
+		resp, _, err := execute(supabaseClient.From("sandbox_inbox").Delete("", "").Gte("created_at", "-infinity"))
+		if err != nil {
+			log.Printf("[ERROR] handleSandboxInbox delete error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to clear inbox: %v", err), http.StatusInternalServerError)
+			return
+		}
+		var deleted []SandboxMessage
+		json.Unmarshal(resp, &deleted)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": "Sandbox inbox cleared", "count": len(deleted)})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
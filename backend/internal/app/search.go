@@ -0,0 +1,255 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	searchDefaultLimit = 20
+	searchMaxLimit     = 100
+)
+
+// SearchResult は検索結果の1件。Score が高いほど関連度が高い。
+type SearchResult struct {
+	Book  Book `json:"book"`
+	Score int  `json:"score"`
+}
+
+// normalizeSearchText は全角英数を半角に、カタカナをひらがなに寄せ、小文字化して空白を除く
+func normalizeSearchText(s string) string {
+	var b strings.Builder
+	for _, r := range foldHalfwidthKana(s) {
+		switch {
+		case r >= 0xFF01 && r <= 0xFF5E: // 全角英数記号
+			r -= 0xFEE0
+		case r >= 0x30A1 && r <= 0x30F6: // カタカナ
+			r -= 0x60
+		case r == 0x3000:
+			r = ' '
+		}
+		if unicode.IsSpace(r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// romajiTable はヘボン式・訓令式のローマ字とひらがなの対応 (長い綴りから照合する)
+var romajiTable = map[string]string{
+	"a": "あ", "i": "い", "u": "う", "e": "え", "o": "お",
+	"ka": "か", "ki": "き", "ku": "く", "ke": "け", "ko": "こ",
+	"sa": "さ", "si": "し", "shi": "し", "su": "す", "se": "せ", "so": "そ",
+	"ta": "た", "ti": "ち", "chi": "ち", "tu": "つ", "tsu": "つ", "te": "て", "to": "と",
+	"na": "な", "ni": "に", "nu": "ぬ", "ne": "ね", "no": "の",
+	"ha": "は", "hi": "ひ", "hu": "ふ", "fu": "ふ", "he": "へ", "ho": "ほ",
+	"ma": "ま", "mi": "み", "mu": "む", "me": "め", "mo": "も",
+	"ya": "や", "yu": "ゆ", "yo": "よ",
+	"ra": "ら", "ri": "り", "ru": "る", "re": "れ", "ro": "ろ",
+	"wa": "わ", "wo": "を", "n'": "ん",
+	"ga": "が", "gi": "ぎ", "gu": "ぐ", "ge": "げ", "go": "ご",
+	"za": "ざ", "zi": "じ", "ji": "じ", "zu": "ず", "ze": "ぜ", "zo": "ぞ",
+	"da": "だ", "di": "ぢ", "du": "づ", "de": "で", "do": "ど",
+	"ba": "ば", "bi": "び", "bu": "ぶ", "be": "べ", "bo": "ぼ",
+	"pa": "ぱ", "pi": "ぴ", "pu": "ぷ", "pe": "ぺ", "po": "ぽ",
+	"kya": "きゃ", "kyu": "きゅ", "kyo": "きょ",
+	"sha": "しゃ", "shu": "しゅ", "sho": "しょ", "sya": "しゃ", "syu": "しゅ", "syo": "しょ",
+	"cha": "ちゃ", "chu": "ちゅ", "cho": "ちょ", "tya": "ちゃ", "tyu": "ちゅ", "tyo": "ちょ",
+	"nya": "にゃ", "nyu": "にゅ", "nyo": "にょ",
+	"hya": "ひゃ", "hyu": "ひゅ", "hyo": "ひょ",
+	"mya": "みゃ", "myu": "みゅ", "myo": "みょ",
+	"rya": "りゃ", "ryu": "りゅ", "ryo": "りょ",
+	"gya": "ぎゃ", "gyu": "ぎゅ", "gyo": "ぎょ",
+	"ja": "じゃ", "ju": "じゅ", "jo": "じょ", "zya": "じゃ", "zyu": "じゅ", "zyo": "じょ",
+	"bya": "びゃ", "byu": "びゅ", "byo": "びょ",
+	"pya": "ぴゃ", "pyu": "ぴゅ", "pyo": "ぴょ",
+	"-": "ー",
+}
+
+func isRomajiVowel(c byte) bool {
+	return strings.IndexByte("aiueo", c) >= 0
+}
+
+// romajiToHiragana はローマ字をひらがなに変換する。変換できない文字が残れば ok=false。
+func romajiToHiragana(s string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		// 促音: 子音の重ね (n を除く)
+		if i+1 < len(s) && s[i] == s[i+1] && s[i] != 'n' && !isRomajiVowel(s[i]) && s[i] >= 'a' && s[i] <= 'z' {
+			b.WriteString("っ")
+			i++
+			continue
+		}
+		matched := false
+		for size := 3; size >= 1; size-- {
+			if i+size > len(s) {
+				continue
+			}
+			if kana, ok := romajiTable[s[i:i+size]]; ok {
+				b.WriteString(kana)
+				i += size
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		// 撥音: 後ろが母音・y でない n (末尾の nn は1文字の ん)
+		if s[i:] == "nn" {
+			b.WriteString("ん")
+			break
+		}
+		if s[i] == 'n' && (i+1 == len(s) || (!isRomajiVowel(s[i+1]) && s[i+1] != 'y')) {
+			b.WriteString("ん")
+			i++
+			continue
+		}
+		return "", false
+	}
+	return b.String(), true
+}
+
+// searchVariants は検索語の表記ゆれ候補を返す (正規化した語と、ローマ字ならそのひらがな)
+func searchVariants(term string) []string {
+	variants := []string{term}
+	if kana, ok := romajiToHiragana(term); ok && kana != term {
+		variants = append(variants, kana)
+	}
+	return variants
+}
+
+// matchScore は1語が文字列にどれだけ一致するかを返す (完全一致 > 前方一致 > 部分一致)
+func matchScore(field string, variants []string) int {
+	best := 0
+	for _, v := range variants {
+		score := 0
+		switch {
+		case field == v:
+			score = 3
+		case strings.HasPrefix(field, v):
+			score = 2
+		case strings.Contains(field, v):
+			score = 1
+		}
+		if score > best {
+			best = score
+		}
+	}
+	return best
+}
+
+// scoreBook は全ての語がタイトル・シリーズ・著者のどれかに含まれる本にスコアをつける。タイトルの一致を最も重く見る。
+func scoreBook(book Book, terms [][]string) int {
+	title := normalizeSearchText(book.Title)
+	author := normalizeSearchText(book.Author)
+	series := normalizeSearchText(book.Series)
+	titleYomi, authorYomi := yomiField("", book.TitleYomi), yomiField("", book.AuthorYomi)
+	total := 0
+	for _, variants := range terms {
+		t := max(matchScore(title, variants), matchScore(titleYomi, variants)) * 20
+		if s := matchScore(series, variants) * 15; s > t {
+			t = s
+		}
+		if a := max(matchScore(author, variants), matchScore(authorYomi, variants)) * 10; a > t {
+			t = a
+		}
+		if t == 0 {
+			return 0
+		}
+		total += t
+	}
+	return total
+}
+
+// searchBooks は books を検索語でふるい、関連度の高い順に並べる
+func searchBooks(books []Book, query string) []SearchResult {
+	var terms [][]string
+	for _, f := range strings.Fields(strings.ReplaceAll(query, "　", " ")) {
+		if term := normalizeSearchText(f); term != "" {
+			terms = append(terms, searchVariants(term))
+		}
+	}
+	results := []SearchResult{}
+	if len(terms) == 0 {
+		return results
+	}
+	for _, b := range books {
+		if score := scoreBook(b, terms); score > 0 {
+			results = append(results, SearchResult{Book: b, Score: score})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	return results
+}
+
+// pageParams は limit と offset のクエリパラメータを読む
+func pageParams(r *http.Request, defLimit, maxLimit int) (limit, offset int, err error) {
+	limit, offset = defLimit, 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxLimit)
+		}
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("offset must not be negative")
+		}
+	}
+	return limit, offset, nil
+}
+
+// handleSearchBooks は GET /api/books/search?userId=...&q=...。
+// ひらがな・カタカナ・ローマ字・全角半角の違いを吸収してタイトル・著者・シリーズを検索する。
+func handleSearchBooks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if userId == "" || query == "" {
+		http.Error(w, "userId and q required", http.StatusBadRequest)
+		return
+	}
+	limit, offset, err := pageParams(r, searchDefaultLimit, searchMaxLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 読みの揺れは DB の ILIKE では吸収できないため、ユーザーの蔵書 (キャッシュ済み) を手元で絞り込む
+	books, _, err := loadUserBooks(userId)
+	if err != nil {
+		log.Printf("[ERROR] handleSearchBooks error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to search books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	results := searchBooks(excludeArchived(books), query)
+	total := len(results)
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"query":   query,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+		"results": results[offset:end],
+	})
+}
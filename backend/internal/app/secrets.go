@@ -0,0 +1,129 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// シークレットは全て呼び出しのたびに os.Getenv で読んでいるので、
+// SECRETS_FILE (KEY=VALUE 形式。Kubernetes の Secret や Docker secrets をマウントしたもの) を
+// 読み直して環境変数を書き換えれば、再起動なしで LINE のトークンや CRON_SECRET などが切り替わる。
+var (
+	secretsMu       sync.Mutex
+	secretsFromFile = make(map[string]bool) // ファイルから設定したキー (ファイルから消えたら unset する)
+)
+
+// loadSecretsFile は SECRETS_FILE を読み、値が変わったキーの名前を返す (値はログに出さない)
+func loadSecretsFile() ([]string, error) {
+	path := os.Getenv("SECRETS_FILE")
+	if path == "" {
+		return nil, fmt.Errorf("SECRETS_FILE is not set")
+	}
+	values, err := parseEnvFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	var changed []string
+	for key, value := range values {
+		if current, ok := os.LookupEnv(key); !ok || current != value {
+			os.Setenv(key, value)
+			changed = append(changed, key)
+		}
+		secretsFromFile[key] = true
+	}
+	// ローテーション後に CRON_SECRET_PREVIOUS を消したときなど
+	for key := range secretsFromFile {
+		if _, ok := values[key]; !ok {
+			os.Unsetenv(key)
+			delete(secretsFromFile, key)
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// parseEnvFile は KEY=VALUE 形式のファイルを読む。空行と # の行は飛ばし、export と値の引用符は外す。
+func parseEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			continue
+		}
+		values[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"'`)
+	}
+	return values, scanner.Err()
+}
+
+// initSecrets は起動時に SECRETS_FILE を読み、SIGHUP で読み直すようにする
+func initSecrets() {
+	if os.Getenv("SECRETS_FILE") == "" {
+		return
+	}
+	if changed, err := loadSecretsFile(); err != nil {
+		log.Printf("[ERROR] failed to load SECRETS_FILE: %v", err)
+	} else {
+		log.Printf("[INFO] loaded %d secrets from SECRETS_FILE", len(changed))
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			changed, err := loadSecretsFile()
+			if err != nil {
+				log.Printf("[ERROR] SIGHUP secrets reload failed: %v", err)
+				continue
+			}
+			log.Printf("[INFO] SIGHUP secrets reloaded, changed: %v", changed)
+		}
+	}()
+}
+
+// handleReloadSecrets は POST /api/admin/secrets/reload
+func handleReloadSecrets(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	changed, err := loadSecretsFile()
+	if err != nil {
+		log.Printf("[ERROR] handleReloadSecrets error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to reload secrets: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if changed == nil {
+		changed = []string{}
+	}
+	log.Printf("[INFO] secrets reloaded via admin endpoint, changed: %v", changed)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Secrets reloaded", "changed": changed})
+}
@@ -0,0 +1,156 @@
+package app
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// 急ぎでない通知の送信時刻の最適化。LINE の Webhook に届いたユーザーの操作の時刻 (line_activity) から
+// よく反応する時間帯 (JST の時) を学習し、週報などはその時刻まで run_at を遅らせる。
+// 設定 API の send_hour (users.preferred_send_hour) があればそちらを優先する。督促など期限に関わる通知は遅らせない。
+const (
+	sendHourWindow     = 30 * 24 * time.Hour // 学習に使う期間
+	sendHourMinSamples = 5                   // これより操作が少なければ学習しない
+	sendHourMaxSamples = 1000
+)
+
+// deferrableKinds は送信時刻を遅らせてよい通知の種類
+var deferrableKinds = []string{jobKindDigest, jobKindReviewNudge, jobKindMonthly, jobKindProject, jobKindArrived, jobKindBuddyProgress}
+
+// recordLineActivity は Webhook に届いたユーザーの操作の時刻を残す。
+// 未登録の LINE ユーザーは users への外部キーで弾かれるので、そのエラーはログに残さない。
+func recordLineActivity(lineUserID, eventType string, at time.Time) {
+	row := map[string]interface{}{"line_user_id": lineUserID, "event_type": eventType, "occurred_at": at}
+	_, _, err := executeOnce(supabaseClient.From("line_activity").Insert(row, false, "", "minimal", ""))
+	if err != nil && !strings.Contains(err.Error(), "23503") {
+		log.Printf("[WARNING] failed to record LINE activity for %s: %v", lineUserID, err)
+	}
+}
+
+// lineEventTime は Webhook イベントの timestamp (ミリ秒)。無ければ受け取った時刻。
+func lineEventTime(ms int64) time.Time {
+	if ms <= 0 {
+		return clock.Now()
+	}
+	return time.UnixMilli(ms)
+}
+
+// peakActivityHour は操作の時刻から一番反応が多い JST の時を返す。
+// 前後の時も半分の重みで数え、たまたま1時間だけ多かった時刻に引きずられないようにする。
+func peakActivityHour(times []time.Time) (int, bool) {
+	if len(times) < sendHourMinSamples {
+		return 0, false
+	}
+	var counts [24]int
+	for _, t := range times {
+		counts[t.In(jst).Hour()]++
+	}
+	best, bestScore := 0, -1
+	for h := range 24 {
+		score := 2*counts[h] + counts[(h+23)%24] + counts[(h+1)%24]
+		if score > bestScore {
+			best, bestScore = h, score
+		}
+	}
+	return best, true
+}
+
+// learnedSendHour は LINE の操作履歴から学習した送信時刻。履歴が足りなければ ok = false。
+func learnedSendHour(lineUserID string, now time.Time) (int, bool, error) {
+	resp, _, err := execute(supabaseClient.From("line_activity").
+		Select("occurred_at", "", false).
+		Eq("line_user_id", lineUserID).
+		Gte("occurred_at", now.Add(-sendHourWindow).Format(time.RFC3339)).
+		Order("occurred_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(sendHourMaxSamples, ""))
+	if err != nil {
+		return 0, false, err
+	}
+	var rows []struct {
+		OccurredAt time.Time `json:"occurred_at"`
+	}
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		return 0, false, err
+	}
+	times := make([]time.Time, 0, len(rows))
+	for _, row := range rows {
+		times = append(times, row.OccurredAt)
+	}
+	hour, ok := peakActivityHour(times)
+	return hour, ok, nil
+}
+
+// sendHour はユーザーの送信時刻 (JST の時)。指定があればそれ、無ければ学習した時刻。
+// source は preferred か learned。どちらも無ければ ok = false。
+func (s NotifySettings) sendHour(now time.Time) (hour int, source string, ok bool) {
+	if s.PreferredSendHour != nil {
+		return *s.PreferredSendHour, "preferred", true
+	}
+	if s.LineUserID == nil {
+		return 0, "", false
+	}
+	hour, ok, err := learnedSendHour(*s.LineUserID, now)
+	if err != nil {
+		log.Printf("[WARNING] failed to learn send hour for user %s: %v", s.UserID, err)
+		return 0, "", false
+	}
+	// 学習した時刻がおやすみ時間帯に入るなら使わない (送っても見送られるだけ)
+	if !ok {
+		return 0, "", false
+	}
+	if s.QuietHoursStart != nil && s.QuietHoursEnd != nil &&
+		inQuietHours(*s.QuietHoursStart, *s.QuietHoursEnd, time.Date(2000, 1, 1, hour, 0, 0, 0, jst)) {
+		return 0, "", false
+	}
+	return hour, "learned", true
+}
+
+// sendHourJSON は設定 API で返す送信時刻
+func (s NotifySettings) sendHourJSON(now time.Time) map[string]interface{} {
+	hour, source, ok := s.sendHour(now)
+	if !ok {
+		return map[string]interface{}{"hour": nil, "source": nil}
+	}
+	return map[string]interface{}{"hour": hour, "source": source}
+}
+
+// nextSendTime は at 以降で最初に hour 時 (JST) になる時刻。at がその時の間ならそのまま返す。
+func nextSendTime(at time.Time, hour int) time.Time {
+	local := at.In(jst)
+	if local.Hour() == hour {
+		return at
+	}
+	next := time.Date(local.Year(), local.Month(), local.Day(), hour, 0, 0, 0, jst)
+	if !next.After(local) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// scheduleForUser は急ぎでない通知の run_at をユーザーの送信時刻まで遅らせる
+func scheduleForUser(job NotificationJob) time.Time {
+	runAt := job.RunAt
+	if runAt.IsZero() {
+		runAt = clock.Now()
+	}
+	if !slices.Contains(deferrableKinds, job.Kind) || job.UserID == "" {
+		return runAt
+	}
+	settings, err := fetchNotifySettings(job.UserID)
+	if err != nil || settings == nil {
+		if err != nil {
+			log.Printf("[WARNING] failed to fetch send hour for user %s: %v", job.UserID, err)
+		}
+		return runAt
+	}
+	hour, _, ok := settings.sendHour(runAt)
+	if !ok {
+		return runAt
+	}
+	return nextSendTime(runAt, hour)
+}
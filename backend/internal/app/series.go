@@ -0,0 +1,107 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// SeriesGroup はシリーズ単位でまとめた書籍
+type SeriesGroup struct {
+	Series string `json:"series"`
+	Books  []Book `json:"books"`
+}
+
+// sortByVolume は巻数順 (巻数未設定は末尾) に並べる
+func sortByVolume(books []Book) {
+	sort.SliceStable(books, func(i, j int) bool {
+		vi, vj := books[i].Volume, books[j].Volume
+		if vi == nil || vj == nil {
+			return vi != nil
+		}
+		return *vi < *vj
+	})
+}
+
+// groupBySeries は同じユーザー・同じシリーズの書籍を1グループにまとめる。
+// シリーズ未設定の書籍はそれぞれ単独のグループになる。
+func groupBySeries(books []Book) [][]Book {
+	var groups [][]Book
+	index := make(map[string]int)
+	for _, b := range books {
+		if b.Series == "" {
+			groups = append(groups, []Book{b})
+			continue
+		}
+		key := b.UserID + "\x00" + b.Series
+		if i, ok := index[key]; ok {
+			groups[i] = append(groups[i], b)
+			continue
+		}
+		index[key] = len(groups)
+		groups = append(groups, []Book{b})
+	}
+	for _, g := range groups {
+		sortByVolume(g)
+	}
+	return groups
+}
+
+const seriesInsultText = "「{{.Series}}」シリーズ、{{.Volumes}} の{{.Count}}冊がまとめて期限切れです。シリーズごと本棚の肥やしにするつもりですか？"
+
+// seriesInsult は複数巻がまとめて期限切れになったときの督促文
+func seriesInsult(books []Book, data MessageData) string {
+	volumes := make([]string, 0, len(books))
+	for _, b := range books {
+		if b.Volume != nil {
+			volumes = append(volumes, fmt.Sprintf("%d巻", *b.Volume))
+		} else {
+			volumes = append(volumes, "「"+b.Title+"」")
+		}
+	}
+	data.Series = books[0].Series
+	data.Volumes = strings.Join(volumes, "・")
+	data.Count = len(books)
+	return renderMessage(seriesInsultText, data, fmt.Sprintf("「%s」シリーズが期限切れです。", data.Series))
+}
+
+// handleListSeries は GET /api/series。ユーザーの書籍をシリーズごとに返す。
+func handleListSeries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+
+	resp, _, err := execute(supabaseClient.From("books").
+		Select("*", "", false).
+		Eq("user_id", userId).
+		Not("series", "is", "null"))
+	if err != nil {
+		log.Printf("[ERROR] handleListSeries error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch series: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var books []Book
+	if err := json.Unmarshal(resp, &books); err != nil {
+		log.Printf("[ERROR] handleListSeries unmarshal error: %v", err)
+	}
+
+	groups := []SeriesGroup{}
+	for _, g := range groupBySeries(books) {
+		if g[0].Series != "" {
+			groups = append(groups, SeriesGroup{Series: g[0].Series, Books: g})
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Series < groups[j].Series })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
@@ -0,0 +1,194 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// ReadingSession は読書タイマーの1回分の記録
+type ReadingSession struct {
+	SessionID       string     `json:"session_id"`
+	BookID          string     `json:"book_id"`
+	UserID          string     `json:"user_id"`
+	StartedAt       time.Time  `json:"started_at"`
+	EndedAt         *time.Time `json:"ended_at"`
+	DurationSeconds int        `json:"duration_seconds"`
+	Pages           int        `json:"pages"`
+	ReadCycle       int        `json:"read_cycle"`
+	Focus           bool       `json:"focus"` // 集中読書モードで始めたタイマー (focus.go)
+}
+
+func handleStartSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	bookID := r.PathValue("id")
+	book, err := fetchOwnedBook(bookID, req.UserID)
+	if err != nil {
+		writeBookLookupError(w, err)
+		return
+	}
+
+	open, err := openSession(bookID)
+	if err != nil {
+		log.Printf("[ERROR] handleStartSession query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to start session: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if open != nil {
+		http.Error(w, "Session already running", http.StatusConflict)
+		return
+	}
+
+	rawResp, _, err := executeOnce(supabaseClient.From("reading_sessions").Insert(map[string]interface{}{
+		"book_id":    bookID,
+		"user_id":    req.UserID,
+		"started_at": time.Now(),
+		"read_cycle": book.ReadCycle,
+	}, false, "", "", ""))
+	if err != nil {
+		log.Printf("[ERROR] handleStartSession insert error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to start session: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var sessions []ReadingSession
+	json.Unmarshal(rawResp, &sessions)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Session started", "sessions": sessions})
+}
+
+func handleStopSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID string `json:"user_id"`
+		Pages  int    `json:"pages"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" || req.Pages < 0 {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	bookID := r.PathValue("id")
+	if _, err := fetchOwnedBook(bookID, req.UserID); err != nil {
+		writeBookLookupError(w, err)
+		return
+	}
+
+	open, err := openSession(bookID)
+	if err != nil {
+		log.Printf("[ERROR] handleStopSession query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to stop session: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if open == nil {
+		http.Error(w, "No running session", http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	rawResp, _, err := execute(supabaseClient.From("reading_sessions").Update(map[string]interface{}{
+		"ended_at":         now,
+		"duration_seconds": int(now.Sub(open.StartedAt).Seconds()),
+		"pages":            req.Pages,
+	}, "", "").Eq("session_id", open.SessionID))
+	if err != nil {
+		log.Printf("[ERROR] handleStopSession update error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to stop session: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var sessions []ReadingSession
+	json.Unmarshal(rawResp, &sessions)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Session stopped", "sessions": sessions})
+}
+
+func handleListSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+	bookID := r.PathValue("id")
+	if _, err := fetchOwnedBook(bookID, userId); err != nil {
+		writeBookLookupError(w, err)
+		return
+	}
+
+	resp, _, err := execute(supabaseClient.From("reading_sessions").
+		Select("*", "", false).
+		Eq("book_id", bookID).
+		Order("started_at", &postgrest.OrderOpts{Ascending: false}))
+	if err != nil {
+		log.Printf("[ERROR] handleListSessions error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch sessions: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+// openSession は終了していないセッションを返す。なければ nil。
+func openSession(bookID string) (*ReadingSession, error) {
+	resp, _, err := execute(supabaseClient.From("reading_sessions").
+		Select("*", "", false).
+		Eq("book_id", bookID).
+		Is("ended_at", "null"))
+	if err != nil {
+		return nil, err
+	}
+	var sessions []ReadingSession
+	if err := json.Unmarshal(resp, &sessions); err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, nil
+	}
+	return &sessions[0], nil
+}
+
+// lastReadAt は書籍ごとの最後の読書開始時刻を1クエリで取得する
+func lastReadAt(bookIDs []string) (map[string]time.Time, error) {
+	last := make(map[string]time.Time)
+	if len(bookIDs) == 0 {
+		return last, nil
+	}
+	resp, _, err := execute(supabaseClient.From("reading_sessions").
+		Select("book_id, started_at", "", false).
+		In("book_id", bookIDs))
+	if err != nil {
+		return nil, err
+	}
+	var sessions []ReadingSession
+	if err := json.Unmarshal(resp, &sessions); err != nil {
+		return nil, err
+	}
+	for _, s := range sessions {
+		if s.StartedAt.After(last[s.BookID]) {
+			last[s.BookID] = s.StartedAt
+		}
+	}
+	return last, nil
+}
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// 期限の一括ずらし。入院・繁忙期などで全部の期限を2週間後ろにしたいときに、30冊を1冊ずつ編集しなくて済むようにする。
+// 対象は期限のある進行中の本 (アーカイブ済みは除く) で、book_ids・tags・project_id・statuses で絞り込める。
+// 書き込みは shift_book_deadlines (schema.sql) の1つの UPDATE で行うので、途中で失敗しても一部だけずれることはない。
+const maxShiftDays = 365
+
+// deadlineSnapshot はずらす前の期限とステータス (取り消し用)
+type deadlineSnapshot struct {
+	BookID   string    `json:"book_id"`
+	Deadline time.Time `json:"deadline"`
+	Status   string    `json:"status"`
+}
+
+// shiftFilter は一括ずらしの対象の絞り込み。空の項目は絞り込まない。
+type shiftFilter struct {
+	BookIDs   []string `json:"book_ids"`
+	Tags      []string `json:"tags"` // いずれかのタグが付いた本
+	ProjectID string   `json:"project_id"`
+	Statuses  []string `json:"statuses"` // unread, reading, insulted のうち
+}
+
+func (f shiftFilter) matches(b Book) bool {
+	if b.Archived || b.Deadline.IsZero() || !slices.Contains(activeStatuses, b.Status) {
+		return false
+	}
+	if len(f.BookIDs) > 0 && !slices.Contains(f.BookIDs, b.BookID) {
+		return false
+	}
+	if len(f.Statuses) > 0 && !slices.Contains(f.Statuses, b.Status) {
+		return false
+	}
+	if f.ProjectID != "" && (b.ProjectID == nil || *b.ProjectID != f.ProjectID) {
+		return false
+	}
+	if len(f.Tags) > 0 && !slices.ContainsFunc(f.Tags, func(t string) bool { return slices.Contains(b.Tags, t) }) {
+		return false
+	}
+	return true
+}
+
+// shiftDeadlines は対象の本の期限を days 日ずらし、更新後の行 (PostgREST の representation と同じ形) を返す。
+// 督促済みの本は新しい期限が先になれば未読に戻す。
+func shiftDeadlines(userID string, bookIDs []string, days int) ([]byte, error) {
+	// 同じ本を2回ずらさないよう再試行しない
+	resp, _, err := executeOnce(rpcQuery{name: "shift_book_deadlines", args: map[string]interface{}{
+		"p_user_id": userID, "p_book_ids": bookIDs, "p_days": days,
+	}})
+	return resp, err
+}
+
+// handleShiftDeadlines は POST /api/books/shift-deadlines {user_id, days, book_ids, tags, project_id, statuses}。
+// days は負にすると前倒しになる。dry_run なら書き込まずに対象と新しい期限だけ返す。
+func handleShiftDeadlines(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID string `json:"user_id"`
+		Days   int    `json:"days"`
+		DryRun bool   `json:"dry_run"`
+		shiftFilter
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Days == 0 || req.Days < -maxShiftDays || req.Days > maxShiftDays {
+		http.Error(w, fmt.Sprintf("days must be between -%d and %d and not 0", maxShiftDays, maxShiftDays), http.StatusBadRequest)
+		return
+	}
+	for _, s := range req.Statuses {
+		if !slices.Contains(activeStatuses, s) {
+			http.Error(w, fmt.Sprintf("unsupported status %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Tags != nil {
+		req.Tags = normalizeTags(req.Tags)
+	}
+
+	books, _, err := loadUserBooks(req.UserID)
+	if err != nil {
+		log.Printf("[ERROR] handleShiftDeadlines query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to shift deadlines: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var (
+		bookIDs []string
+		undo    undoPayload
+		preview []map[string]interface{}
+	)
+	for _, b := range books {
+		if !req.matches(b) {
+			continue
+		}
+		bookIDs = append(bookIDs, b.BookID)
+		undo.Deadlines = append(undo.Deadlines, deadlineSnapshot{BookID: b.BookID, Deadline: b.Deadline, Status: b.Status})
+		preview = append(preview, map[string]interface{}{"book_id": b.BookID, "title": b.Title, "deadline": b.Deadline.AddDate(0, 0, req.Days)})
+	}
+	if len(bookIDs) == 0 || req.DryRun {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"shifted": 0, "books": preview, "dry_run": req.DryRun})
+		return
+	}
+
+	rawResp, err := shiftDeadlines(req.UserID, bookIDs, req.Days)
+	if err != nil {
+		log.Printf("[ERROR] handleShiftDeadlines update error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to shift deadlines: %v", err), http.StatusInternalServerError)
+		return
+	}
+	emitBookRows("book.updated", rawResp)
+	var shifted []Book
+	json.Unmarshal(rawResp, &shifted)
+	log.Printf("[INFO] shifted deadlines of %d books by %d days for user %s", len(shifted), req.Days, req.UserID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"shifted":   len(shifted),
+		"books":     preview,
+		"action_id": nullIfEmpty(recordAction(req.UserID, actionShiftDeadlines, undo)),
+	})
+}
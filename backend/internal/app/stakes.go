@@ -0,0 +1,345 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 賭け (stake) は「期限を破ったら○○に知らせる / ○円寄付する」という本ごとの約束。
+// 知らせる相手 (立会人) は LINE で「立会人 <コード>」と送って同意した場合だけ通知を受け取る。
+const (
+	stakeKindPartner  = "partner"  // 立会人に期限切れを知らせる
+	stakeKindDonation = "donation" // 寄付の約束を思い出させる (立会人がいればその人にも知らせる)
+
+	stakeAcceptCommand  = "立会人"
+	stakeDeclineCommand = "立会人をやめる"
+
+	maxStakeNoteLength = 200
+	maxStakeAmount     = 1000000
+)
+
+// Stake は book_stakes の1行
+type Stake struct {
+	StakeID       string     `json:"stake_id"`
+	BookID        string     `json:"book_id"`
+	UserID        string     `json:"user_id"`
+	Kind          string     `json:"kind"`
+	Amount        *int       `json:"amount"` // 寄付額 (円)。donation のときだけ
+	Note          string     `json:"note"`
+	ConsentCode   string     `json:"consent_code"` // 立会人が LINE で送るコード
+	ContactUserID *string    `json:"contact_user_id"`
+	ConsentedAt   *time.Time `json:"consented_at"`
+	TriggeredAt   *time.Time `json:"triggered_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+func newStakeCode() (string, error) {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return strings.ToUpper(hex.EncodeToString(buf)), nil
+}
+
+func fetchStake(bookID string) (*Stake, error) {
+	resp, _, err := execute(supabaseClient.From("book_stakes").Select("*", "", false).Eq("book_id", bookID))
+	if err != nil {
+		return nil, err
+	}
+	var stakes []Stake
+	if err := json.Unmarshal(resp, &stakes); err != nil {
+		return nil, err
+	}
+	if len(stakes) == 0 {
+		return nil, nil
+	}
+	return &stakes[0], nil
+}
+
+// handleStake は /api/books/{id}/stake。
+// GET ?userId=... で確認、POST で賭けを設定 (同意前なら作り直せる)、DELETE で取り下げる。期限切れで発動した後は変更できない。
+func handleStake(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID string `json:"user_id"`
+		Kind   string `json:"kind"`
+		Amount *int   `json:"amount"`
+		Note   string `json:"note"`
+	}
+	switch r.Method {
+	case http.MethodGet:
+		req.UserID = r.URL.Query().Get("userId")
+	case http.MethodPost, http.MethodDelete:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	book, err := fetchOwnedBook(r.PathValue("id"), req.UserID)
+	if err != nil {
+		writeBookLookupError(w, err)
+		return
+	}
+	stake, err := fetchStake(book.BookID)
+	if err != nil {
+		log.Printf("[ERROR] handleStake query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch stake: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		if stake == nil {
+			http.Error(w, "Stake not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stake)
+		return
+	}
+	if stake != nil && stake.TriggeredAt != nil {
+		http.Error(w, "Stake has already been triggered", http.StatusConflict)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if stake == nil {
+			http.Error(w, "Stake not found", http.StatusNotFound)
+			return
+		}
+		if _, _, err := execute(supabaseClient.From("book_stakes").Delete("minimal", "").Eq("stake_id", stake.StakeID)); err != nil {
+			log.Printf("[ERROR] handleStake delete error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to delete stake: %v", err), http.StatusInternalServerError)
+			return
+		}
+		// 同意済みの立会人には取り下げたことを伝える
+		if stake.ConsentedAt != nil {
+			if err := notifyStakeContact(*stake, fmt.Sprintf("%sさんが『%s』の賭けを取り下げました。立会人の役目は終わりです。", stakeOwnerName(book.UserID), book.Title), clock.Now()); err != nil {
+				log.Printf("[ERROR] failed to notify stake contact for book %s: %v", book.BookID, err)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Stake deleted"})
+		return
+	}
+
+	switch req.Kind {
+	case stakeKindPartner:
+		req.Amount = nil
+	case stakeKindDonation:
+		if req.Amount == nil || *req.Amount <= 0 || *req.Amount > maxStakeAmount {
+			http.Error(w, fmt.Sprintf("amount must be between 1 and %d", maxStakeAmount), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "kind must be partner or donation", http.StatusBadRequest)
+		return
+	}
+	if book.Deadline.IsZero() || !slices.Contains(activeStatuses, book.Status) {
+		http.Error(w, "Stakes can only be set on unfinished books with a deadline", http.StatusBadRequest)
+		return
+	}
+	if stake != nil && stake.ConsentedAt != nil {
+		// 立会人が同意した後に条件を変えると同意の意味がなくなる
+		http.Error(w, "Stake already has a consenting contact; delete it first", http.StatusConflict)
+		return
+	}
+	note := strings.TrimSpace(req.Note)
+	if runes := []rune(note); len(runes) > maxStakeNoteLength {
+		note = string(runes[:maxStakeNoteLength])
+	}
+	code, err := newStakeCode()
+	if err != nil {
+		log.Printf("[ERROR] handleStake code error: %v", err)
+		http.Error(w, "failed to create stake", http.StatusInternalServerError)
+		return
+	}
+	resp, _, err := executeOnce(supabaseClient.From("book_stakes").Insert(map[string]interface{}{
+		"book_id":      book.BookID,
+		"user_id":      book.UserID,
+		"kind":         req.Kind,
+		"amount":       req.Amount,
+		"note":         note,
+		"consent_code": code,
+		"created_at":   clock.Now(),
+	}, true, "book_id", "", ""))
+	var rows []Stake
+	if err == nil {
+		err = json.Unmarshal(resp, &rows)
+	}
+	if err != nil || len(rows) == 0 {
+		log.Printf("[ERROR] handleStake upsert error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to save stake: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[INFO] stake %s set on book %s (%s)", rows[0].StakeID, book.BookID, req.Kind)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"stake":        rows[0],
+		"instructions": fmt.Sprintf("立会人に、積読キラーの LINE で「%s %s」と送ってもらってください。同意があるまで立会人には何も送りません。", stakeAcceptCommand, code),
+	})
+}
+
+// stakeOwnerName は立会人への通知で使う本人の表示名
+func stakeOwnerName(userID string) string {
+	resp, _, err := execute(supabaseClient.From("users").Select("display_name", "", false).Eq("id", userID))
+	if err == nil {
+		var users []struct {
+			DisplayName string `json:"display_name"`
+		}
+		if json.Unmarshal(resp, &users); len(users) > 0 && users[0].DisplayName != "" {
+			return users[0].DisplayName
+		}
+	}
+	return "積読キラーのユーザー"
+}
+
+// notifyStakeContact は同意済みの立会人に送るジョブを積む
+func notifyStakeContact(stake Stake, message string, now time.Time) error {
+	if stake.ContactUserID == nil || stake.ConsentedAt == nil {
+		return nil
+	}
+	lineUserID, err := lineUserIDFor(*stake.ContactUserID)
+	if err != nil || lineUserID == "" {
+		return err
+	}
+	return enqueueJob(NotificationJob{Kind: jobKindStake, BookID: stake.BookID, UserID: stake.UserID, LineUserID: lineUserID, Message: message, RunAt: now})
+}
+
+// triggerStake は期限切れの督促を積んだときに賭けを発動する。発動は1冊につき1回だけ。
+func triggerStake(ev BookEvent) {
+	if ev.Book == nil {
+		return
+	}
+	stake, err := fetchStake(ev.BookID)
+	if err != nil {
+		log.Printf("[ERROR] stake lookup error for book %s: %v", ev.BookID, err)
+		return
+	}
+	if stake == nil || stake.TriggeredAt != nil {
+		return
+	}
+	// 先に発動済みにして、次の cron で二重に送らないようにする
+	rawResp, _, err := execute(supabaseClient.From("book_stakes").
+		Update(map[string]interface{}{"triggered_at": ev.At}, "", "").
+		Eq("stake_id", stake.StakeID).
+		Is("triggered_at", "null"))
+	if err != nil {
+		log.Printf("[ERROR] failed to mark stake %s as triggered: %v", stake.StakeID, err)
+		return
+	}
+	if string(rawResp) == "[]" {
+		return
+	}
+
+	book, owner := *ev.Book, stakeOwnerName(stake.UserID)
+	var contactMsg string
+	switch stake.Kind {
+	case stakeKindDonation:
+		msg := fmt.Sprintf("💸 『%s』の期限を破りました。約束どおり%d円を寄付してください。", book.Title, *stake.Amount)
+		if stake.Note != "" {
+			msg += "\n約束: " + stake.Note
+		}
+		if lineUserID, err := lineUserIDFor(stake.UserID); err == nil && lineUserID != "" {
+			if err := enqueueJob(NotificationJob{Kind: jobKindStake, BookID: book.BookID, UserID: stake.UserID, LineUserID: lineUserID, Message: msg, RunAt: ev.At}); err != nil {
+				log.Printf("[ERROR] failed to enqueue donation reminder for book %s: %v", book.BookID, err)
+			}
+		}
+		contactMsg = fmt.Sprintf("%sさんが『%s』の期限を破りました。%d円を寄付する約束です。見届けてあげてください。", owner, book.Title, *stake.Amount)
+	default:
+		contactMsg = fmt.Sprintf("%sさんが『%s』の期限を破りました。立会人として、何か言ってあげてください。", owner, book.Title)
+	}
+	if stake.Note != "" && stake.Kind == stakeKindPartner {
+		contactMsg += "\n約束: " + stake.Note
+	}
+	if err := notifyStakeContact(*stake, contactMsg, ev.At); err != nil {
+		log.Printf("[ERROR] failed to enqueue stake notice for book %s: %v", book.BookID, err)
+	}
+	log.Printf("[INFO] stake %s triggered for book %s", stake.StakeID, book.BookID)
+}
+
+// parseStakeCommand は「立会人 <コード>」「立会人をやめる <コード>」を読み取る
+func parseStakeCommand(text string) (code string, accept, ok bool) {
+	fields := strings.Fields(strings.ReplaceAll(text, "　", " "))
+	if len(fields) != 2 {
+		return "", false, false
+	}
+	switch fields[0] {
+	case stakeAcceptCommand:
+		return strings.ToUpper(fields[1]), true, true
+	case stakeDeclineCommand:
+		return strings.ToUpper(fields[1]), false, true
+	}
+	return "", false, false
+}
+
+// stakeCommandReply は立会人の同意・辞退を記録して返信文を返す
+func stakeCommandReply(lineUserID, code string, accept bool) string {
+	resp, _, err := execute(supabaseClient.From("users").Select("id", "", false).Eq("line_user_id", lineUserID))
+	if err != nil {
+		log.Printf("[ERROR] stake contact lookup error: %v", err)
+		return "エラーが発生しました。時間をおいてもう一度送ってください。"
+	}
+	var users []struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(resp, &users); len(users) == 0 {
+		return "まだ積読キラーに登録されていないようです。先にメニューからアカウントを連携してください。"
+	}
+	contactID := users[0].ID
+
+	sResp, _, err := execute(supabaseClient.From("book_stakes").Select("*, books(title)", "", false).Eq("consent_code", code).Is("triggered_at", "null"))
+	if err != nil {
+		log.Printf("[ERROR] stake code lookup error: %v", err)
+		return "エラーが発生しました。時間をおいてもう一度送ってください。"
+	}
+	var stakes []struct {
+		Stake
+		Book struct {
+			Title string `json:"title"`
+		} `json:"books"`
+	}
+	if json.Unmarshal(sResp, &stakes); len(stakes) == 0 {
+		return "そのコードの賭けは見つかりませんでした。"
+	}
+	stake := stakes[0]
+	if stake.UserID == contactID {
+		return "自分の賭けの立会人にはなれません。"
+	}
+	owner := stakeOwnerName(stake.UserID)
+
+	if !accept {
+		if stake.ContactUserID == nil || *stake.ContactUserID != contactID {
+			return "この賭けの立会人にはなっていません。"
+		}
+		if _, _, err := execute(supabaseClient.From("book_stakes").
+			Update(map[string]interface{}{"contact_user_id": nil, "consented_at": nil}, "minimal", "").
+			Eq("stake_id", stake.StakeID)); err != nil {
+			log.Printf("[ERROR] stake decline error: %v", err)
+			return "エラーが発生しました。時間をおいてもう一度送ってください。"
+		}
+		log.Printf("[INFO] contact withdrew from stake %s", stake.StakeID)
+		return fmt.Sprintf("%sさんの『%s』の立会人をやめました。もう通知は届きません。", owner, stake.Book.Title)
+	}
+
+	if stake.ContactUserID != nil && *stake.ContactUserID != contactID {
+		return "この賭けには別の立会人がいます。"
+	}
+	if _, _, err := execute(supabaseClient.From("book_stakes").
+		Update(map[string]interface{}{"contact_user_id": contactID, "consented_at": clock.Now()}, "minimal", "").
+		Eq("stake_id", stake.StakeID)); err != nil {
+		log.Printf("[ERROR] stake consent error: %v", err)
+		return "エラーが発生しました。時間をおいてもう一度送ってください。"
+	}
+	log.Printf("[INFO] contact consented to stake %s", stake.StakeID)
+	return fmt.Sprintf("%sさんの『%s』の立会人になりました。期限を破ったらお知らせします。\nやめるときは「%s %s」と送ってください。", owner, stake.Book.Title, stakeDeclineCommand, code)
+}
@@ -0,0 +1,193 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// UserStats はユーザーの読書統計
+type UserStats struct {
+	StatusCounts        map[string]int `json:"status_counts"`
+	TotalBooks          int            `json:"total_books"` // 欲しい本・入荷待ち・諦めた本は含まない
+	WishlistBooks       int            `json:"wishlist_books"`
+	WaitingBooks        int            `json:"waiting_books"`   // 入荷待ち。total_books には含まない
+	AbandonedBooks      int            `json:"abandoned_books"` // total_books には含まない
+	ArchivedBooks       int            `json:"archived_books"`  // status_counts・total_books には含まない
+	TotalReadingSeconds int            `json:"total_reading_seconds"`
+	TotalPagesRead      int            `json:"total_pages_read"`
+	RatedBooks          int            `json:"rated_books"`
+	AverageRating       float64        `json:"average_rating"`
+	CurrentStreak       int            `json:"current_streak"`
+	LongestStreak       int            `json:"longest_streak"`
+	Finishing           FinishingStats `json:"finishing"`
+	Pace                readingPace    `json:"pace"` // プロフィールに保存した読書ペース
+}
+
+// FinishingStats は読了までの日数の集計 (再読も1回として数える)
+type FinishingStats struct {
+	Completions  int     `json:"completions"`
+	AvgDaysHeld  float64 `json:"avg_days_held"`  // 登録から読了までの平均日数
+	MaxDaysHeld  int     `json:"max_days_held"`  // 最も寝かせていた本の日数
+	OnTime       int     `json:"on_time"`        // 期限内に読了した回数
+	Late         int     `json:"late"`           // 期限を過ぎて読了した回数
+	AvgDaysEarly float64 `json:"avg_days_early"` // 負の値は平均して遅れている
+	AvgDaysLate  float64 `json:"avg_days_late"`  // 遅れた回だけの平均遅延日数
+}
+
+// summarizeFinishing は読了記録の所要日数をまとめる
+func summarizeFinishing(completions []Completion) FinishingStats {
+	var f FinishingStats
+	held, early, late := 0, 0, 0
+	for _, c := range completions {
+		t := c.timing()
+		f.Completions++
+		held += t.DaysHeld
+		early += t.DaysEarly
+		f.MaxDaysHeld = max(f.MaxDaysHeld, t.DaysHeld)
+		if t.DaysEarly >= 0 {
+			f.OnTime++
+		} else {
+			f.Late++
+			late -= t.DaysEarly
+		}
+	}
+	if f.Completions > 0 {
+		f.AvgDaysHeld = float64(held) / float64(f.Completions)
+		f.AvgDaysEarly = float64(early) / float64(f.Completions)
+	}
+	if f.Late > 0 {
+		f.AvgDaysLate = float64(late) / float64(f.Late)
+	}
+	return f
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+
+	stats, err := loadUserStats(userId)
+	if err != nil {
+		log.Printf("[ERROR] handleStats error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to compute stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// loadBookStatusCounts は直接接続があれば集計クエリで、なければ PostgREST で取った行から数える
+func loadBookStatusCounts(userID string) ([]bookStatusCount, error) {
+	if sqlDB != nil {
+		return countBooksByStatus(context.Background(), userID)
+	}
+	resp, _, err := execute(supabaseClient.From("books").Select("status, rating, archived", "", false).Eq("user_id", userID))
+	if err != nil {
+		return nil, err
+	}
+	var books []Book
+	if err := json.Unmarshal(resp, &books); err != nil {
+		return nil, err
+	}
+	// PostgREST では GROUP BY できないので1冊ずつの集計として返す
+	counts := make([]bookStatusCount, 0, len(books))
+	for _, b := range books {
+		c := bookStatusCount{Status: b.Status, Archived: b.Archived, Books: 1}
+		if b.Rating != nil {
+			c.Rated, c.RatingSum = 1, *b.Rating
+		}
+		counts = append(counts, c)
+	}
+	return counts, nil
+}
+
+func loadUserStats(userID string) (*UserStats, error) {
+	stats := &UserStats{}
+
+	counts, err := loadBookStatusCounts(userID)
+	if err != nil {
+		return nil, err
+	}
+	stats.StatusCounts = make(map[string]int)
+	ratingSum := 0
+	for _, c := range counts {
+		// 評価は記録として残すので、アーカイブした本も平均に含める
+		if c.Archived {
+			stats.ArchivedBooks += c.Books
+		} else {
+			stats.StatusCounts[c.Status] += c.Books
+		}
+		stats.RatedBooks += c.Rated
+		ratingSum += c.RatingSum
+	}
+	if stats.RatedBooks > 0 {
+		stats.AverageRating = float64(ratingSum) / float64(stats.RatedBooks)
+	}
+	for status, n := range stats.StatusCounts {
+		switch status {
+		case statusWishlist:
+			stats.WishlistBooks += n
+			continue
+		case statusAbandoned:
+			stats.AbandonedBooks += n
+			continue
+		case statusWaiting:
+			stats.WaitingBooks += n
+			continue
+		}
+		stats.TotalBooks += n
+	}
+
+	sResp, _, err := execute(supabaseClient.From("reading_sessions").Select("duration_seconds, pages", "", false).Eq("user_id", userID))
+	if err != nil {
+		return nil, err
+	}
+	var sessions []ReadingSession
+	if err := json.Unmarshal(sResp, &sessions); err != nil {
+		return nil, err
+	}
+	for _, s := range sessions {
+		stats.TotalReadingSeconds += s.DurationSeconds
+		stats.TotalPagesRead += s.Pages
+	}
+
+	cResp, _, err := execute(supabaseClient.From("book_completions").Select("completed_at, started_at, days_early, read_cycle", "", false).Eq("user_id", userID))
+	if err != nil {
+		return nil, err
+	}
+	var completions []Completion
+	if err := json.Unmarshal(cResp, &completions); err != nil {
+		return nil, err
+	}
+	stats.Finishing = summarizeFinishing(completions)
+	if stats.Pace, err = userReadingPace(userID); err != nil {
+		log.Printf("[ERROR] pace lookup failed for user %s: %v", userID, err)
+	}
+
+	uResp, _, err := execute(supabaseClient.From("users").Select("current_streak, longest_streak", "", false).Eq("id", userID))
+	if err != nil {
+		return nil, err
+	}
+	var users []struct {
+		CurrentStreak int `json:"current_streak"`
+		LongestStreak int `json:"longest_streak"`
+	}
+	if err := json.Unmarshal(uResp, &users); err != nil {
+		return nil, err
+	}
+	if len(users) > 0 {
+		stats.CurrentStreak = users[0].CurrentStreak
+		stats.LongestStreak = users[0].LongestStreak
+	}
+	return stats, nil
+}
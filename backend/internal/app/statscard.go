@@ -0,0 +1,245 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/image/font"
+)
+
+// OG 画像の推奨サイズ
+const (
+	cardWidth  = 1200
+	cardHeight = 630
+)
+
+var (
+	cardBackground = color.RGBA{0x1f, 0x2a, 0x44, 0xff}
+	cardAccent     = color.RGBA{0xf2, 0x5f, 0x5c, 0xff}
+	cardText       = color.RGBA{0xf7, 0xf7, 0xf2, 0xff}
+	cardMuted      = color.RGBA{0x9a, 0xa5, 0xb8, 0xff}
+)
+
+// cardGlyphs は 5x7 のビットマップフォント (英大文字・数字・一部の記号)。CARD_FONT_PATH のフォントが読めないときに使う (cardfont.go)。
+// どちらのフォントでも描けるよう、カードの決まった文言は英語にしている。
+var cardGlyphs = map[rune][7]string{
+	'A': {".###.", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+	'B': {"####.", "#...#", "#...#", "####.", "#...#", "#...#", "####."},
+	'C': {".###.", "#...#", "#....", "#....", "#....", "#...#", ".###."},
+	'D': {"####.", "#...#", "#...#", "#...#", "#...#", "#...#", "####."},
+	'E': {"#####", "#....", "#....", "####.", "#....", "#....", "#####"},
+	'F': {"#####", "#....", "#....", "####.", "#....", "#....", "#...."},
+	'G': {".###.", "#...#", "#....", "#.###", "#...#", "#...#", ".####"},
+	'H': {"#...#", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+	'I': {".###.", "..#..", "..#..", "..#..", "..#..", "..#..", ".###."},
+	'J': {"..###", "...#.", "...#.", "...#.", "...#.", "#..#.", ".##.."},
+	'K': {"#...#", "#..#.", "#.#..", "##...", "#.#..", "#..#.", "#...#"},
+	'L': {"#....", "#....", "#....", "#....", "#....", "#....", "#####"},
+	'M': {"#...#", "##.##", "#.#.#", "#.#.#", "#...#", "#...#", "#...#"},
+	'N': {"#...#", "#...#", "##..#", "#.#.#", "#..##", "#...#", "#...#"},
+	'O': {".###.", "#...#", "#...#", "#...#", "#...#", "#...#", ".###."},
+	'P': {"####.", "#...#", "#...#", "####.", "#....", "#....", "#...."},
+	'Q': {".###.", "#...#", "#...#", "#...#", "#.#.#", "#..#.", ".##.#"},
+	'R': {"####.", "#...#", "#...#", "####.", "#.#..", "#..#.", "#...#"},
+	'S': {".####", "#....", "#....", ".###.", "....#", "....#", "####."},
+	'T': {"#####", "..#..", "..#..", "..#..", "..#..", "..#..", "..#.."},
+	'U': {"#...#", "#...#", "#...#", "#...#", "#...#", "#...#", ".###."},
+	'V': {"#...#", "#...#", "#...#", "#...#", "#...#", ".#.#.", "..#.."},
+	'W': {"#...#", "#...#", "#...#", "#.#.#", "#.#.#", "#.#.#", ".#.#."},
+	'X': {"#...#", "#...#", ".#.#.", "..#..", ".#.#.", "#...#", "#...#"},
+	'Y': {"#...#", "#...#", ".#.#.", "..#..", "..#..", "..#..", "..#.."},
+	'Z': {"#####", "....#", "...#.", "..#..", ".#...", "#....", "#####"},
+	'0': {".###.", "#...#", "#..##", "#.#.#", "##..#", "#...#", ".###."},
+	'1': {"..#..", ".##..", "..#..", "..#..", "..#..", "..#..", ".###."},
+	'2': {".###.", "#...#", "....#", "...#.", "..#..", ".#...", "#####"},
+	'3': {"#####", "...#.", "..#..", "...#.", "....#", "#...#", ".###."},
+	'4': {"...#.", "..##.", ".#.#.", "#..#.", "#####", "...#.", "...#."},
+	'5': {"#####", "#....", "####.", "....#", "....#", "#...#", ".###."},
+	'6': {"..##.", ".#...", "#....", "####.", "#...#", "#...#", ".###."},
+	'7': {"#####", "....#", "...#.", "..#..", ".#...", ".#...", ".#..."},
+	'8': {".###.", "#...#", "#...#", ".###.", "#...#", "#...#", ".###."},
+	'9': {".###.", "#...#", "#...#", ".####", "....#", "...#.", ".##.."},
+	'-': {".....", ".....", ".....", "#####", ".....", ".....", "....."},
+	'/': {"....#", "....#", "...#.", "..#..", ".#...", "#....", "#...."},
+	':': {".....", "..#..", "..#..", ".....", "..#..", "..#..", "....."},
+	'.': {".....", ".....", ".....", ".....", ".....", ".##..", ".##.."},
+	' ': {".....", ".....", ".....", ".....", ".....", ".....", "....."},
+}
+
+// textWidth は scale 倍で描いたときの幅 (文字間は1ドット)
+func textWidth(s string, scale int) int {
+	if face := cardFace(scale); face != nil {
+		return font.MeasureString(face, s).Ceil()
+	}
+	n := len([]rune(s))
+	if n == 0 {
+		return 0
+	}
+	return (n*6 - 1) * scale
+}
+
+// drawText は (x, y) を左上に scale 倍で描く。ビットマップフォントにない文字は空白になる。
+func drawText(img draw.Image, s string, x, y, scale int, c color.Color) {
+	if face := cardFace(scale); face != nil {
+		drawCardFontText(img, face, s, x, y, scale, c)
+		return
+	}
+	src := image.NewUniform(c)
+	for _, r := range strings.ToUpper(s) {
+		glyph := cardGlyphs[r]
+		for row, line := range glyph {
+			for col, bit := range line {
+				if bit != '#' {
+					continue
+				}
+				rect := image.Rect(x+col*scale, y+row*scale, x+(col+1)*scale, y+(row+1)*scale)
+				draw.Draw(img, rect, src, image.Point{}, draw.Src)
+			}
+		}
+		x += 6 * scale
+	}
+}
+
+// MonthlyStats はカードに載せる月間の数字
+type MonthlyStats struct {
+	Month         time.Time
+	BooksFinished int
+	PagesRead     int
+	CurrentStreak int
+}
+
+// loadMonthlyStats は JST の暦月で読了数とページ数を集計する
+func loadMonthlyStats(userID string, month time.Time) (*MonthlyStats, error) {
+	start := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, jst)
+	end := start.AddDate(0, 1, 0)
+	ms := &MonthlyStats{Month: start}
+
+	_, finished, err := execute(supabaseClient.From("book_completions").
+		Select("completion_id", "exact", true).
+		Eq("user_id", userID).
+		Gte("completed_at", start.Format(time.RFC3339)).
+		Lt("completed_at", end.Format(time.RFC3339)))
+	if err != nil {
+		return nil, err
+	}
+	ms.BooksFinished = int(finished)
+
+	sResp, _, err := execute(supabaseClient.From("reading_sessions").
+		Select("pages", "", false).
+		Eq("user_id", userID).
+		Gte("started_at", start.Format(time.RFC3339)).
+		Lt("started_at", end.Format(time.RFC3339)))
+	if err != nil {
+		return nil, err
+	}
+	var sessions []ReadingSession
+	if err := json.Unmarshal(sResp, &sessions); err != nil {
+		return nil, err
+	}
+	for _, s := range sessions {
+		ms.PagesRead += s.Pages
+	}
+
+	uResp, _, err := execute(supabaseClient.From("users").Select("current_streak", "", false).Eq("id", userID))
+	if err != nil {
+		return nil, err
+	}
+	var users []struct {
+		CurrentStreak int `json:"current_streak"`
+	}
+	json.Unmarshal(uResp, &users)
+	if len(users) > 0 {
+		ms.CurrentStreak = users[0].CurrentStreak
+	}
+	return ms, nil
+}
+
+// renderStatsCard は月間の数字を 1200x630 の PNG にする
+func renderStatsCard(ms *MonthlyStats) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, cardWidth, cardHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(cardBackground), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, cardWidth, 16), image.NewUniform(cardAccent), image.Point{}, draw.Src)
+
+	drawText(img, "TSUNDOKU KILLER", 80, 70, 6, cardText)
+	drawText(img, strings.ToUpper(ms.Month.Format("January 2006")), 80, 140, 4, cardMuted)
+
+	columns := []struct {
+		label string
+		value int
+	}{
+		{"BOOKS FINISHED", ms.BooksFinished},
+		{"PAGES READ", ms.PagesRead},
+		{"ON-TIME STREAK", ms.CurrentStreak},
+	}
+	colWidth := (cardWidth - 160) / len(columns)
+	for i, col := range columns {
+		cx := 80 + i*colWidth + colWidth/2
+		value := strconv.Itoa(col.value)
+		scale := 18
+		for scale > 6 && textWidth(value, scale) > colWidth-40 {
+			scale--
+		}
+		drawText(img, value, cx-textWidth(value, scale)/2, 300, scale, cardAccent)
+		labelScale := 4
+		for labelScale > 2 && textWidth(col.label, labelScale) > colWidth-10 {
+			labelScale--
+		}
+		drawText(img, col.label, cx-textWidth(col.label, labelScale)/2, 480, labelScale, cardText)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// handleStatsCard は GET /api/stats/card.png?userId=...&month=YYYY-MM。
+// LINE からそのままシェアできるよう、月間の実績を OG 画像として返す。
+func handleStatsCard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+	month := clock.Now().In(jst)
+	if m := r.URL.Query().Get("month"); m != "" {
+		parsed, err := time.ParseInLocation("2006-01", m, jst)
+		if err != nil {
+			http.Error(w, "month must be YYYY-MM", http.StatusBadRequest)
+			return
+		}
+		month = parsed
+	}
+
+	ms, err := loadMonthlyStats(userId, month)
+	if err != nil {
+		log.Printf("[ERROR] handleStatsCard stats error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to load stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+	card, err := renderStatsCard(ms)
+	if err != nil {
+		log.Printf("[ERROR] handleStatsCard render error: %v", err)
+		http.Error(w, "failed to render card", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=600")
+	w.Write(card)
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const maxLineEmojis = 20 // 1つのテキストメッセージに入れられる LINE 絵文字の上限
+
+// テンプレートの {{emoji "productId" "emojiId"}} は本文にこの形で残し、送信時に "$" と emojis に変換する。
+// DB の message 列にそのまま保存できるよう、制御文字ではなく普通の文字で囲む。
+var (
+	emojiMarkerPattern = regexp.MustCompile(`⟦emoji:([0-9a-f]{24}):([0-9]{3})⟧`)
+	emojiProductID     = regexp.MustCompile(`^[0-9a-f]{24}$`)
+	emojiID            = regexp.MustCompile(`^[0-9]{3}$`)
+	stickerSpecPattern = regexp.MustCompile(`^[0-9]+:[0-9]+$`)
+)
+
+// emojiMarker はテンプレート関数 emoji の実体
+func emojiMarker(productID, id string) (string, error) {
+	if !emojiProductID.MatchString(productID) || !emojiID.MatchString(id) {
+		return "", fmt.Errorf("invalid LINE emoji %s/%s", productID, id)
+	}
+	return "⟦emoji:" + productID + ":" + id + "⟧", nil
+}
+
+// lineTextMessage は本文の絵文字マーカーを LINE 絵文字に置き換えたテキストメッセージを作る。
+// index は UTF-16 の位置で数える。上限を超えた絵文字は削る。
+func lineTextMessage(text string) LineTextMessage {
+	var (
+		b      strings.Builder
+		emojis []LineEmoji
+		last   int
+		pos    int // これまでに書いた UTF-16 の長さ
+	)
+	for _, m := range emojiMarkerPattern.FindAllStringSubmatchIndex(text, -1) {
+		chunk := text[last:m[0]]
+		b.WriteString(chunk)
+		pos += len(utf16.Encode([]rune(chunk)))
+		last = m[1]
+		if len(emojis) == maxLineEmojis {
+			continue
+		}
+		emojis = append(emojis, LineEmoji{Index: pos, ProductID: text[m[2]:m[3]], EmojiID: text[m[4]:m[5]]})
+		b.WriteString("$")
+		pos++
+	}
+	b.WriteString(text[last:])
+
+	return LineTextMessage{Type: "text", Text: b.String(), Emojis: emojis}
+}
+
+// plainText は通知の代替テキストやログ向けに絵文字マーカーを取り除く
+func plainText(text string) string {
+	return emojiMarkerPattern.ReplaceAllString(text, "")
+}
+
+// stickerForLevel は INSULT_STICKERS (例: "1=446:1988,5=789:10855") から、
+// insult_level に対応するスタンプ "packageId:stickerId" を返す。設定がなければ空文字。
+func stickerForLevel(level int) string {
+	level = clampInsultLevel(level)
+	for _, pair := range strings.Split(os.Getenv("INSULT_STICKERS"), ",") {
+		key, spec, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(key)
+		if err != nil || !stickerSpecPattern.MatchString(spec) {
+			log.Printf("[WARNING] ignoring invalid INSULT_STICKERS entry %q", pair)
+			continue
+		}
+		if n == level {
+			return spec
+		}
+	}
+	return ""
+}
+
+// lineStickerMessage は "packageId:stickerId" をスタンプメッセージにする
+func lineStickerMessage(spec string) LineStickerMessage {
+	packageID, stickerID, _ := strings.Cut(spec, ":")
+	return LineStickerMessage{Type: "sticker", PackageID: packageID, StickerID: stickerID}
+}
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	storageBucketDefault = "exports"
+	storageTimeout       = 60 * time.Second
+)
+
+// Supabase Storage への書き込みと署名付き URL の発行。
+// storage-go はアップロード時の Content-Type をクライアントに残してしまい後続の JSON リクエストが壊れるので、REST API を直接呼ぶ。
+
+// storageBucket は書き出し先のバケット (STORAGE_BUCKET)。公開しないバケットを前提にしている。
+func storageBucket() string {
+	if b := os.Getenv("STORAGE_BUCKET"); b != "" {
+		return b
+	}
+	return storageBucketDefault
+}
+
+func storageRequest(method, path, contentType string, body io.Reader, extra map[string]string) ([]byte, error) {
+	base := strings.TrimSuffix(os.Getenv("SUPABASE_URL"), "/") + "/storage/v1"
+	req, err := http.NewRequest(method, base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	key := os.Getenv("SUPABASE_SERVICE_ROLE_KEY")
+	req.Header.Set("Authorization", "Bearer "+key)
+	req.Header.Set("apikey", key)
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range extra {
+		req.Header.Set(k, v)
+	}
+	client := &http.Client{Timeout: storageTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("storage %s %s: %d %s", method, path, resp.StatusCode, snippet(string(respBody), 200))
+	}
+	return respBody, nil
+}
+
+// uploadToStorage は path にファイルを置く。同じパスがあれば上書きする。
+func uploadToStorage(path, contentType string, data []byte) error {
+	_, err := storageRequest(http.MethodPost, "/object/"+storageBucket()+"/"+path, contentType, bytes.NewReader(data), map[string]string{"x-upsert": "true"})
+	return err
+}
+
+// downloadFromStorage はサーバー側でファイルを読む
+func downloadFromStorage(path string) ([]byte, error) {
+	return storageRequest(http.MethodGet, "/object/authenticated/"+storageBucket()+"/"+path, "application/json", nil, nil)
+}
+
+// signedStorageURL は ttl だけ有効なダウンロード URL を返す
+func signedStorageURL(path string, ttl time.Duration) (string, error) {
+	body, _ := json.Marshal(map[string]int{"expiresIn": int(ttl.Seconds())})
+	resp, err := storageRequest(http.MethodPost, "/object/sign/"+storageBucket()+"/"+path, "application/json", bytes.NewReader(body), nil)
+	if err != nil {
+		return "", err
+	}
+	var signed struct {
+		SignedURL string `json:"signedURL"`
+	}
+	if err := json.Unmarshal(resp, &signed); err != nil || signed.SignedURL == "" {
+		return "", fmt.Errorf("unexpected sign response: %s", snippet(string(resp), 200))
+	}
+	return strings.TrimSuffix(os.Getenv("SUPABASE_URL"), "/") + "/storage/v1" + signed.SignedURL, nil
+}
+
+// storeAndSign はアップロードして ttl だけ有効な URL を返す。download を指定すると保存時のファイル名になる。
+func storeAndSign(path, contentType string, data []byte, ttl time.Duration, download string) (string, error) {
+	if err := uploadToStorage(path, contentType, data); err != nil {
+		return "", err
+	}
+	u, err := signedStorageURL(path, ttl)
+	if err != nil || download == "" {
+		return u, err
+	}
+	return u + "&download=" + url.QueryEscape(download), nil
+}
+
+// removeFromStorage は期限切れのファイルを消す
+func removeFromStorage(paths ...string) error {
+	body, _ := json.Marshal(map[string][]string{"prefixes": paths})
+	_, err := storageRequest(http.MethodDelete, "/object/"+storageBucket(), "application/json", bytes.NewReader(body), nil)
+	return err
+}
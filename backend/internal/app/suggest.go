@@ -0,0 +1,110 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPagesPerDay   = 20.0
+	defaultMinutesPerDay = 30.0
+	paceWindow           = 90 * 24 * time.Hour
+	minPaceWindowDays    = 7.0
+	deadlineBuffer       = 1.2 // 見積もりに2割の余裕を持たせる
+)
+
+// readingPace はユーザーの1日あたりの読書量
+type readingPace struct {
+	PagesPerDay   float64    `json:"pages_per_day"`
+	MinutesPerDay float64    `json:"minutes_per_day"`
+	FromHistory   bool       `json:"from_history"`
+	CalibratedAt  *time.Time `json:"calibrated_at,omitempty"` // プロフィールに保存した日時 (pace.go)
+}
+
+// handleSuggestDeadline は GET /api/books/suggest-deadline?userId=&pages= (オーディオブックは minutes=)。
+// 分量・読書ペース・他の進行中の本の残量から現実的な期限を提案する。
+func handleSuggestDeadline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	userId := q.Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+	timeBased := q.Get("minutes") != ""
+	amountParam := q.Get("pages")
+	if timeBased {
+		amountParam = q.Get("minutes")
+	}
+	amount, err := strconv.Atoi(amountParam)
+	if err != nil || amount <= 0 {
+		http.Error(w, "pages or minutes must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	pace, err := userReadingPace(userId)
+	if err != nil {
+		log.Printf("[ERROR] handleSuggestDeadline pace error: %v", err)
+	}
+
+	active, err := activeBooksWithDeadline(userId)
+	if err != nil {
+		log.Printf("[ERROR] handleSuggestDeadline query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to suggest deadline: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// 先に期限が来る本を読み終える前提で、同じ単位の残量を積み上げる
+	load := float64(amount)
+	for _, b := range active {
+		if b.isTimeBased() == timeBased {
+			load += float64(max(0, b.totalUnits()-b.Progress))
+		}
+	}
+	perDay := pace.PagesPerDay
+	if timeBased {
+		perDay = pace.MinutesPerDay
+	}
+	daysNeeded := int(math.Ceil(load / perDay * deadlineBuffer))
+	suggested := clock.Now().AddDate(0, 0, max(1, daysNeeded))
+
+	overlapping := 0
+	for _, b := range active {
+		if b.Deadline.Before(suggested) {
+			overlapping++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"suggested_deadline": suggested,
+		"days_needed":        daysNeeded,
+		"pace":               pace,
+		"overlapping_books":  overlapping,
+	})
+}
+
+// activeBooksWithDeadline は期限がまだ来ていない進行中の本を返す
+func activeBooksWithDeadline(userID string) ([]Book, error) {
+	resp, _, err := execute(supabaseClient.From("books").
+		Select("*", "", false).
+		Eq("user_id", userID).
+		In("status", activeStatuses).
+		Gte("deadline", clock.Now().Format(time.RFC3339)))
+	if err != nil {
+		return nil, err
+	}
+	var books []Book
+	if err := json.Unmarshal(resp, &books); err != nil {
+		return nil, err
+	}
+	return books, nil
+}
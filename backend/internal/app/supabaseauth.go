@@ -0,0 +1,188 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var errInvalidAccessToken = errors.New("invalid access token")
+
+// SupabaseClaims は Supabase Auth が発行するアクセストークン (JWT) のクレーム
+type SupabaseClaims struct {
+	Subject  string `json:"sub"` // auth.uid()
+	Audience string `json:"aud"`
+	Role     string `json:"role"`
+	Email    string `json:"email"`
+	Expires  int64  `json:"exp"`
+}
+
+// verifySupabaseJWT は SUPABASE_JWT_SECRET で HS256 の署名を検証し、ログイン済みユーザーのクレームを返す
+func verifySupabaseJWT(token string) (*SupabaseClaims, error) {
+	secret := os.Getenv("SUPABASE_JWT_SECRET")
+	if secret == "" {
+		return nil, fmt.Errorf("SUPABASE_JWT_SECRET is not set")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", errInvalidAccessToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, fmt.Errorf("%w: unsupported header", errInvalidAccessToken)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, fmt.Errorf("%w: bad signature", errInvalidAccessToken)
+	}
+
+	var claims SupabaseClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: bad claims", errInvalidAccessToken)
+	}
+	// anon キーもこの秘密鍵で署名されているので、ログイン済みユーザーのトークンだけを通す
+	if claims.Role != "authenticated" || claims.Audience != "authenticated" || claims.Subject == "" {
+		return nil, fmt.Errorf("%w: not an authenticated user", errInvalidAccessToken)
+	}
+	if time.Unix(claims.Expires, 0).Before(time.Now()) {
+		return nil, fmt.Errorf("%w: expired", errInvalidAccessToken)
+	}
+	return &claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+var errAuthUserConflict = errors.New("Supabase account already belongs to another user")
+
+// userIDForAuth は auth.uid に対応する users の行を返す。なければ LINE アカウントなしのユーザーを作る。
+// linkTo が指定されていれば (LINE でログイン済みのユーザー)、その行に auth.uid を紐付ける。
+func userIDForAuth(claims *SupabaseClaims, linkTo string) (string, error) {
+	resp, _, err := execute(supabaseClient.From("users").Select("id", "", false).Eq("auth_user_id", claims.Subject))
+	if err != nil {
+		return "", err
+	}
+	var users []struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(resp, &users)
+	if len(users) > 0 {
+		if linkTo != "" && users[0].ID != linkTo {
+			return "", errAuthUserConflict
+		}
+		return users[0].ID, nil
+	}
+
+	if linkTo != "" {
+		if _, _, err := execute(supabaseClient.From("users").
+			Update(map[string]interface{}{"auth_user_id": claims.Subject, "updated_at": time.Now()}, "minimal", "").
+			Eq("id", linkTo)); err != nil {
+			return "", err
+		}
+		return linkTo, nil
+	}
+
+	name, _, _ := strings.Cut(claims.Email, "@")
+	if name == "" {
+		name = "Web User"
+	}
+	insertResp, _, err := executeOnce(supabaseClient.From("users").Insert(map[string]interface{}{
+		"auth_user_id": claims.Subject,
+		"display_name": name,
+	}, false, "", "", ""))
+	if err != nil {
+		return "", err
+	}
+	var created []struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(insertResp, &created); len(created) == 0 {
+		return "", fmt.Errorf("failed to create user for %s", claims.Subject)
+	}
+	log.Printf("[INFO] created user %s for Supabase account %s", created[0].ID, claims.Subject)
+	return created[0].ID, nil
+}
+
+// handleSupabaseAuth は POST /api/auth/supabase。Supabase Auth のアクセストークン (Authorization: Bearer) を
+// バックエンドのセッショントークンと交換する。LINE なしで Web アプリだけを使う場合の入口。
+// body の sessionToken に LINE ログインのセッションを渡すと、そのユーザーに Supabase アカウントを紐付ける。
+func handleSupabaseAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	accessToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || accessToken == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	claims, err := verifySupabaseJWT(accessToken)
+	if err != nil {
+		if errors.Is(err, errInvalidAccessToken) {
+			http.Error(w, "Invalid access token", http.StatusUnauthorized)
+			return
+		}
+		log.Printf("[ERROR] handleSupabaseAuth verify error: %v", err)
+		http.Error(w, "failed to verify access token", http.StatusInternalServerError)
+		return
+	}
+
+	var req struct {
+		SessionToken string `json:"sessionToken"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	var linkTo string
+	if req.SessionToken != "" {
+		sr := r.Clone(r.Context())
+		sr.Header.Set("Authorization", "Bearer "+req.SessionToken)
+		current, err := authenticateSession(sr)
+		if err != nil {
+			writeSessionError(w, err)
+			return
+		}
+		linkTo = current.UserID
+	}
+
+	internalID, err := userIDForAuth(claims, linkTo)
+	if err != nil {
+		if errors.Is(err, errAuthUserConflict) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.Printf("[ERROR] handleSupabaseAuth user error: %v", err)
+		http.Error(w, "failed to resolve user", http.StatusInternalServerError)
+		return
+	}
+	token, expiresAt, err := createSession(internalID, r)
+	if err != nil {
+		log.Printf("[ERROR] handleSupabaseAuth session error: %v", err)
+		http.Error(w, "failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":      "Auth successful",
+		"userId":       internalID,
+		"sessionToken": token,
+		"expiresAt":    expiresAt,
+	})
+}
@@ -0,0 +1,151 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// サポート対応のための閲覧専用のなりすまし。「督促が届かない」といった問い合わせを調べるため、
+// 管理者が /api/admin/users/{id}/… でユーザーの本・通知の履歴・設定を見られる。
+// 書き込みはできず、閲覧のたびに理由 (?reason=) と閲覧者を support_access_log に残す。記録できなければ見せない。
+// 記録はユーザー本人の個人データ一式 (datarequest.go) にも入る。
+const (
+	maxSupportReasonLength = 500
+	defaultSupportLogLimit = 100
+)
+
+// requireSupportAccess は閲覧を記録してから h を呼ぶ。requireRole(roleAdmin, …) の内側で使う。
+func requireSupportAccess(resource string, h func(w http.ResponseWriter, r *http.Request, userID string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		reason := strings.TrimSpace(r.URL.Query().Get("reason"))
+		if reason == "" || len([]rune(reason)) > maxSupportReasonLength {
+			http.Error(w, fmt.Sprintf("reason required (max %d characters), e.g. the support ticket", maxSupportReasonLength), http.StatusBadRequest)
+			return
+		}
+		userID := r.PathValue("id")
+		_, n, err := execute(supabaseClient.From("users").Select("id", "exact", true).Eq("id", userID))
+		if err != nil {
+			log.Printf("[ERROR] support access user lookup error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch user: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if n == 0 {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+
+		row := map[string]interface{}{"user_id": userID, "resource": resource, "reason": reason, "via_token": adminTokenValid(r)}
+		actor := "admin token"
+		if session, err := authenticateSession(r); err == nil {
+			row["actor_id"], row["via_token"], actor = session.UserID, false, session.UserID
+		}
+		if _, _, err := executeOnce(supabaseClient.From("support_access_log").Insert(row, false, "", "minimal", "")); err != nil {
+			log.Printf("[ERROR] failed to record support access to user %s: %v", userID, err)
+			http.Error(w, "failed to record access", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[INFO] support access: %s viewed %s of user %s (%s)", actor, resource, userID, reason)
+		w.Header().Set("Cache-Control", "no-store")
+		h(w, r, userID)
+	}
+}
+
+// handleSupportBooks は GET /api/admin/users/{id}/books?reason=…。アーカイブ済みも含め、キャッシュを通さずに返す。
+func handleSupportBooks(w http.ResponseWriter, r *http.Request, userID string) {
+	_, resp, err := queryUserBooks(supabaseClient, userID)
+	if err != nil {
+		log.Printf("[ERROR] handleSupportBooks query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+// handleSupportNotifications は GET /api/admin/users/{id}/notifications?reason=…[&bookId=…][&limit=…]。
+// ユーザーが見る配信記録 (handleNotifications) と同じものを返す。
+func handleSupportNotifications(w http.ResponseWriter, r *http.Request, userID string) {
+	q := r.URL.Query()
+	q.Set("userId", userID)
+	r2 := r.Clone(r.Context())
+	r2.URL = &url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+	handleNotifications(w, r2)
+}
+
+// handleSupportSettings は GET /api/admin/users/{id}/settings?reason=…。
+// 送り先の設定に加え、今送るとしたらどのチャネルになるか・届けられるかを返す。
+func handleSupportSettings(w http.ResponseWriter, r *http.Request, userID string) {
+	settings, err := fetchNotifySettings(userID)
+	if err != nil || settings == nil {
+		log.Printf("[ERROR] handleSupportSettings settings error: %v", err)
+		http.Error(w, "failed to fetch settings", http.StatusInternalServerError)
+		return
+	}
+	defaults, err := fetchUserDefaults(userID)
+	if err != nil {
+		log.Printf("[WARNING] handleSupportSettings defaults error: %v", err)
+	}
+	role, err := fetchUserRole(userID)
+	if err != nil {
+		log.Printf("[WARNING] handleSupportSettings role error: %v", err)
+	}
+	now := time.Now()
+	channel := settings.resolve(nil)
+	inQuiet := settings.QuietHoursStart != nil && settings.QuietHoursEnd != nil && inQuietHours(*settings.QuietHoursStart, *settings.QuietHoursEnd, now)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"notify_settings": settings,
+		"preferences":     defaults,
+		"role":            role,
+		"resolved_channel": map[string]interface{}{
+			"channel":     channel,
+			"deliverable": channel != "" && settings.available(channel),
+		},
+		"line_deliverable":  settings.lineUserID() != "" && lineDeliverable(settings.lineUserID()),
+		"in_quiet_hours":    inQuiet,
+		"reminder_cadence":  resolveCadence(nil, settings.ReminderCadence),
+		"degraded_features": degradedFeatures(),
+	})
+}
+
+// handleSupportAccessLog は GET /api/admin/support-access?userId=…&limit=…。閲覧の記録を新しい順に返す。
+func handleSupportAccessLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := defaultSupportLogLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, 1000)
+	}
+	q := supabaseClient.From("support_access_log").Select("*", "", false)
+	if userID := r.URL.Query().Get("userId"); userID != "" {
+		q = q.Eq("user_id", userID)
+	}
+	resp, _, err := execute(q.Order("created_at", &postgrest.OrderOpts{Ascending: false}).Limit(limit, ""))
+	if err != nil {
+		log.Printf("[ERROR] handleSupportAccessLog query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch access log: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
@@ -0,0 +1,289 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 期限を iCalendar の VTODO として書き出す。CalDAV 対応のタスクアプリ (Thunderbird、DAVx⁵ + ICSx⁵ など) で
+// /api/feeds/{token}/tasks.ics を購読すると積読がタスクとして並ぶ。購読は読み取り専用なので、
+// アプリで完了にしたタスクは書き出した .ics を POST /api/books/tasks/import に送ると次の同期で読了に反映される。
+const (
+	taskUIDSuffix          = "@tundoku-killer"
+	taskCompletedWindow    = 30 * 24 * time.Hour // 最近の読了も COMPLETED として載せ、アプリ側の表示を揃える
+	maxTaskImportBytes     = 2 << 20
+	icalLineLimit          = 75
+	icalTimestampFormat    = "20060102T150405Z"
+	icalTimestampLocalForm = "20060102T150405"
+)
+
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func taskUID(bookID string) string { return "book-" + bookID + taskUIDSuffix }
+
+// bookIDFromTaskUID は taskUID の逆。積読キラーが書き出したタスクでなければ空文字。
+func bookIDFromTaskUID(uid string) string {
+	id, ok := strings.CutPrefix(strings.TrimSuffix(uid, taskUIDSuffix), "book-")
+	if !ok || !strings.HasSuffix(uid, taskUIDSuffix) {
+		return ""
+	}
+	return id
+}
+
+// icalWriter は CRLF 改行と75オクテットでの折り返し (RFC 5545 3.1) をしながら書く
+type icalWriter struct {
+	b strings.Builder
+}
+
+func (w *icalWriter) line(name, value string) {
+	s := name + ":" + value
+	for len(s) > icalLineLimit {
+		cut := icalLineLimit
+		for cut > 0 && !utf8RuneStart(s[cut]) {
+			cut-- // マルチバイト文字の途中では折り返さない
+		}
+		w.b.WriteString(s[:cut] + "\r\n")
+		s = " " + s[cut:]
+	}
+	w.b.WriteString(s + "\r\n")
+}
+
+func utf8RuneStart(b byte) bool { return b&0xC0 != 0x80 }
+
+func icalTime(t time.Time) string { return t.UTC().Format(icalTimestampFormat) }
+
+// buildTaskCalendar は books を VTODO にする
+func buildTaskCalendar(name string, books []Book, now time.Time) string {
+	var w icalWriter
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", "-//tundoku-killer//tasks//JA")
+	w.line("CALSCALE", "GREGORIAN")
+	w.line("X-WR-CALNAME", icalEscaper.Replace(name))
+	for _, b := range books {
+		w.line("BEGIN", "VTODO")
+		w.line("UID", taskUID(b.BookID))
+		w.line("DTSTAMP", icalTime(now))
+		w.line("LAST-MODIFIED", icalTime(b.UpdatedAt))
+		w.line("CREATED", icalTime(b.CreatedAt))
+		summary := "『" + b.Title + "』"
+		if b.Author != "" {
+			summary += " " + b.Author
+		}
+		w.line("SUMMARY", icalEscaper.Replace(summary))
+		if !b.Deadline.IsZero() {
+			w.line("DUE", icalTime(b.Deadline))
+		}
+		if len(b.Tags) > 0 {
+			tags := make([]string, len(b.Tags))
+			for i, t := range b.Tags {
+				tags[i] = icalEscaper.Replace(t)
+			}
+			w.line("CATEGORIES", strings.Join(tags, ","))
+		}
+		switch {
+		case b.Status == "completed":
+			w.line("STATUS", "COMPLETED")
+			w.line("PERCENT-COMPLETE", "100")
+			if b.CompletedAt != nil {
+				w.line("COMPLETED", icalTime(*b.CompletedAt))
+			}
+		case b.Status == "reading":
+			w.line("STATUS", "IN-PROCESS")
+			if total := b.totalUnits(); total > 0 {
+				w.line("PERCENT-COMPLETE", strconv.Itoa(min(99, b.Progress*100/total)))
+			}
+		default:
+			w.line("STATUS", "NEEDS-ACTION")
+		}
+		// 期限切れの本ほど優先度を上げる (1 が最高)
+		priority := 5
+		if !b.Deadline.IsZero() && b.Deadline.Before(now) {
+			priority = 1
+		}
+		w.line("PRIORITY", strconv.Itoa(priority))
+		w.line("END", "VTODO")
+	}
+	w.line("END", "VCALENDAR")
+	return w.b.String()
+}
+
+// taskBooks はタスクとして載せる本。積読と最近の読了 (アーカイブは除く)。
+func taskBooks(books []Book, now time.Time) []Book {
+	var result []Book
+	for _, b := range excludeArchived(books) {
+		if slices.Contains(activeStatuses, b.Status) ||
+			(b.Status == "completed" && b.CompletedAt != nil && now.Sub(*b.CompletedAt) < taskCompletedWindow) {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+// handleTaskFeed は GET /api/feeds/{token}/tasks.ics。フィードのトークン (feeds.go) で購読する。
+func handleTaskFeed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	uResp, _, err := execute(supabaseClient.From("users").Select("id, display_name", "", false).Eq("feed_token", r.PathValue("token")))
+	if err != nil {
+		log.Printf("[ERROR] handleTaskFeed user lookup error: %v", err)
+		http.Error(w, "failed to load feed", http.StatusInternalServerError)
+		return
+	}
+	var users []struct {
+		ID          string `json:"id"`
+		DisplayName string `json:"display_name"`
+	}
+	if json.Unmarshal(uResp, &users); len(users) == 0 || r.PathValue("token") == "" {
+		http.NotFound(w, r)
+		return
+	}
+	books, _, err := loadUserBooks(users[0].ID)
+	if err != nil {
+		log.Printf("[ERROR] handleTaskFeed books error: %v", err)
+		http.Error(w, "failed to load feed", http.StatusInternalServerError)
+		return
+	}
+	now := clock.Now()
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(feedCacheMaxAge.Seconds())))
+	io.WriteString(w, buildTaskCalendar(users[0].DisplayName+"の積読", taskBooks(books, now), now))
+}
+
+// icalTodo は取り込んだ VTODO のうち照合に使う項目
+type icalTodo struct {
+	UID       string
+	Status    string
+	Completed *time.Time
+}
+
+// parseTaskCalendar は .ics から VTODO を読む。折り返しを戻し、プロパティのパラメータは無視する。
+func parseTaskCalendar(r io.Reader) ([]icalTodo, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxTaskImportBytes)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || !strings.EqualFold(strings.TrimSpace(lines[0]), "BEGIN:VCALENDAR") {
+		return nil, errors.New("not an iCalendar file")
+	}
+
+	var todos []icalTodo
+	var cur *icalTodo
+	for _, line := range lines {
+		nameParams, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.ToUpper(nameParams), ";")
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VTODO"):
+			cur = &icalTodo{}
+		case name == "END" && strings.EqualFold(value, "VTODO") && cur != nil:
+			todos = append(todos, *cur)
+			cur = nil
+		case cur == nil:
+		case name == "UID":
+			cur.UID = strings.TrimSpace(value)
+		case name == "STATUS":
+			cur.Status = strings.ToUpper(strings.TrimSpace(value))
+		case name == "COMPLETED":
+			if t, err := parseICalTime(value); err == nil {
+				cur.Completed = &t
+			}
+		}
+	}
+	return todos, nil
+}
+
+func parseICalTime(v string) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if t, err := time.Parse(icalTimestampFormat, v); err == nil {
+		return t, nil
+	}
+	// TZID 付きや浮動時刻は JST とみなす
+	return time.ParseInLocation(icalTimestampLocalForm, v, jst)
+}
+
+// handleTaskImport は POST /api/books/tasks/import?userId=…。タスクアプリから書き出した .ics (text/calendar) を受け取り、
+// 完了になっているタスクの本を読了にする。積読キラーが書き出していないタスクと、読了済みの本は無視する。
+func handleTaskImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxTaskImportBytes)
+	todos, err := parseTaskCalendar(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid calendar: %v", err), http.StatusBadRequest)
+		return
+	}
+	books, _, err := loadUserBooks(userId)
+	if err != nil {
+		log.Printf("[ERROR] handleTaskImport books error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	owned := make(map[string]Book, len(books))
+	for _, b := range books {
+		owned[b.BookID] = b
+	}
+
+	completed := []string{}
+	var snaps []completionSnapshot
+	unchanged, ignored := 0, 0
+	for _, todo := range todos {
+		book, ok := owned[bookIDFromTaskUID(todo.UID)]
+		if !ok {
+			ignored++
+			continue
+		}
+		if (todo.Status != "COMPLETED" && todo.Completed == nil) || !slices.Contains(activeStatuses, book.Status) {
+			unchanged++
+			continue
+		}
+		_, snap, err := completeBookUndoable(r.Context(), book.BookID)
+		if errors.Is(err, errAlreadyCompleted) {
+			unchanged++
+			continue
+		}
+		if err != nil {
+			log.Printf("[ERROR] handleTaskImport failed to complete book %s: %v", book.BookID, err)
+			continue
+		}
+		completed = append(completed, book.BookID)
+		snaps = append(snaps, snap)
+	}
+	result := map[string]interface{}{"completed": completed, "unchanged": unchanged, "ignored": ignored}
+	if len(snaps) > 0 {
+		log.Printf("[INFO] task import completed %d books for user %s", len(snaps), userId)
+		result["action_id"] = nullIfEmpty(recordAction(userId, actionCompleteBook, undoPayload{Completions: snaps}))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,205 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// 履歴画面向けのアクティビティのタイムライン。本の登録・読了、届いた督促、実績、達成した中間目標を
+// それぞれのテーブルから新しい順に読み、1本の時系列にまとめる。ページングは before (前のページの next_before) で行う。
+const (
+	timelineBookAdded     = "book.created"
+	timelineBookCompleted = "book.completed"
+	timelineInsult        = "insult.received"
+	timelineAchievement   = "achievement.unlocked"
+	timelineMilestone     = "milestone.completed"
+
+	defaultTimelineLimit = 30
+	maxTimelineLimit     = 100
+)
+
+// TimelineItem はタイムラインの1件
+type TimelineItem struct {
+	Type      string    `json:"type"`
+	At        time.Time `json:"at"`
+	BookID    string    `json:"book_id,omitempty"`
+	BookTitle string    `json:"book_title,omitempty"`
+	Title     string    `json:"title"`            // 表示する見出し
+	Detail    string    `json:"detail,omitempty"` // 督促の本文など
+}
+
+// timelineSource は1つのテーブルから before より前の limit 件を読む
+type timelineSource func(userID string, before time.Time, limit int) ([]TimelineItem, error)
+
+func timelineQuery(table, columns, timeColumn, userID string, before time.Time, limit int, filter func(*postgrest.FilterBuilder) *postgrest.FilterBuilder, rows interface{}) error {
+	q := supabaseClient.From(table).Select(columns, "", false).Eq("user_id", userID).Lt(timeColumn, before.Format(time.RFC3339Nano))
+	if filter != nil {
+		q = filter(q)
+	}
+	resp, _, err := execute(q.Order(timeColumn, &postgrest.OrderOpts{Ascending: false}).Limit(limit, ""))
+	if err != nil {
+		return fmt.Errorf("%s: %w", table, err)
+	}
+	return json.Unmarshal(resp, rows)
+}
+
+var timelineSources = []timelineSource{
+	func(userID string, before time.Time, limit int) ([]TimelineItem, error) {
+		var rows []struct {
+			BookID    string    `json:"book_id"`
+			Title     string    `json:"title"`
+			CreatedAt time.Time `json:"created_at"`
+		}
+		err := timelineQuery("books", "book_id, title, created_at", "created_at", userID, before, limit, nil, &rows)
+		items := make([]TimelineItem, 0, len(rows))
+		for _, row := range rows {
+			items = append(items, TimelineItem{Type: timelineBookAdded, At: row.CreatedAt, BookID: row.BookID, Title: fmt.Sprintf("『%s』を登録しました", row.Title)})
+		}
+		return items, err
+	},
+	func(userID string, before time.Time, limit int) ([]TimelineItem, error) {
+		var rows []struct {
+			BookID      string    `json:"book_id"`
+			CompletedAt time.Time `json:"completed_at"`
+			DaysEarly   int       `json:"days_early"`
+		}
+		err := timelineQuery("book_completions", "book_id, completed_at, days_early", "completed_at", userID, before, limit, nil, &rows)
+		items := make([]TimelineItem, 0, len(rows))
+		for _, row := range rows {
+			detail := fmt.Sprintf("期限の%d日前", row.DaysEarly)
+			if row.DaysEarly < 0 {
+				detail = fmt.Sprintf("期限から%d日遅れ", -row.DaysEarly)
+			}
+			items = append(items, TimelineItem{Type: timelineBookCompleted, At: row.CompletedAt, BookID: row.BookID, Title: "読了しました", Detail: detail})
+		}
+		return items, err
+	},
+	func(userID string, before time.Time, limit int) ([]TimelineItem, error) {
+		var rows []NotificationJob
+		err := timelineQuery("notification_jobs", "book_id, message, sent_at", "sent_at", userID, before, limit, func(q *postgrest.FilterBuilder) *postgrest.FilterBuilder {
+			return q.Eq("kind", jobKindInsult).Eq("status", "sent")
+		}, &rows)
+		items := make([]TimelineItem, 0, len(rows))
+		for _, row := range rows {
+			if row.SentAt != nil {
+				items = append(items, TimelineItem{Type: timelineInsult, At: *row.SentAt, BookID: row.BookID, Title: "督促が届きました", Detail: plainText(row.Message)})
+			}
+		}
+		return items, err
+	},
+	func(userID string, before time.Time, limit int) ([]TimelineItem, error) {
+		var rows []struct {
+			Code       string    `json:"code"`
+			UnlockedAt time.Time `json:"unlocked_at"`
+		}
+		err := timelineQuery("user_achievements", "code, unlocked_at", "unlocked_at", userID, before, limit, nil, &rows)
+		items := make([]TimelineItem, 0, len(rows))
+		for _, row := range rows {
+			name := row.Code
+			for _, def := range achievementDefs {
+				if def.Code == row.Code {
+					name = def.Name
+				}
+			}
+			items = append(items, TimelineItem{Type: timelineAchievement, At: row.UnlockedAt, Title: "実績「" + name + "」を解除しました"})
+		}
+		return items, err
+	},
+	func(userID string, before time.Time, limit int) ([]TimelineItem, error) {
+		var rows []struct {
+			BookID      string     `json:"book_id"`
+			Title       string     `json:"title"`
+			CompletedAt *time.Time `json:"completed_at"`
+		}
+		err := timelineQuery("book_milestones", "book_id, title, completed_at", "completed_at", userID, before, limit, func(q *postgrest.FilterBuilder) *postgrest.FilterBuilder {
+			return q.Eq("completed", "true")
+		}, &rows)
+		items := make([]TimelineItem, 0, len(rows))
+		for _, row := range rows {
+			if row.CompletedAt != nil {
+				items = append(items, TimelineItem{Type: timelineMilestone, At: *row.CompletedAt, BookID: row.BookID, Title: "中間目標「" + row.Title + "」を達成しました"})
+			}
+		}
+		return items, err
+	},
+}
+
+// buildTimeline は各ソースから limit 件ずつ読んで新しい順に並べ、先頭の limit 件を返す
+func buildTimeline(userID string, before time.Time, limit int) ([]TimelineItem, error) {
+	var items []TimelineItem
+	for _, source := range timelineSources {
+		got, err := source(userID, before, limit)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, got...)
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].At.After(items[j].At) })
+	if len(items) > limit {
+		items = items[:limit]
+	}
+
+	// 本のタイトルは書籍一覧のキャッシュから付ける (削除済みの本は付かない)
+	books, _, err := loadUserBooks(userID)
+	if err != nil {
+		log.Printf("[ERROR] timeline book titles error for user %s: %v", userID, err)
+	}
+	titles := make(map[string]string, len(books))
+	for _, b := range books {
+		titles[b.BookID] = b.Title
+	}
+	for i := range items {
+		items[i].BookTitle = titles[items[i].BookID]
+	}
+	return items, nil
+}
+
+// handleTimeline は GET /api/users/me/timeline?before=RFC3339&limit=30。
+// next_before を次の before に渡すと続きを返す。続きがなければ next_before は null。
+func handleTimeline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session, err := authenticateSession(r)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	before := clock.Now()
+	if v := r.URL.Query().Get("before"); v != "" {
+		if before, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			http.Error(w, "before must be RFC3339", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := defaultTimelineLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxTimelineLimit)
+	}
+
+	items, err := buildTimeline(session.UserID, before, limit)
+	if err != nil {
+		log.Printf("[ERROR] handleTimeline query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to build timeline: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var next *time.Time
+	if len(items) == limit {
+		next = &items[len(items)-1].At
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": items, "next_before": next})
+}
@@ -0,0 +1,248 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// 連携向けの読み取り専用のアクセストークン (PAT)。ログインセッションを渡さずに、自作のダッシュボードや
+// ウィジェットから /api/public/* を読めるようにする。DB にはトークンのハッシュだけを保存する。
+const (
+	scopeReadBooks = "read:books"
+	scopeReadStats = "read:stats"
+
+	accessTokenPrefix    = "tkp_"
+	maxAccessTokens      = 20
+	maxAccessTokenName   = 60
+	accessTokenColumns   = "token_id, name, scopes, created_at, last_used_at, expires_at, revoked_at"
+	tokenLastUsedRefresh = 5 * time.Minute
+)
+
+var accessTokenScopes = []string{scopeReadBooks, scopeReadStats}
+
+var errNoAccessToken = errors.New("no valid access token")
+
+// AccessToken は発行済みのトークン (トークン自体は発行時にしか返さない)
+type AccessToken struct {
+	TokenID    string     `json:"token_id"`
+	UserID     string     `json:"user_id,omitempty"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// authenticateAccessToken は Authorization: Bearer tkp_... のトークンに scope があれば返す
+func authenticateAccessToken(r *http.Request, scope string) (*AccessToken, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || !strings.HasPrefix(token, accessTokenPrefix) {
+		return nil, errNoAccessToken
+	}
+	resp, _, err := execute(supabaseClient.From("personal_access_tokens").
+		Select("user_id, "+accessTokenColumns, "", false).
+		Eq("token_hash", hashSessionToken(token)).
+		Is("revoked_at", "null"))
+	if err != nil {
+		return nil, err
+	}
+	var tokens []AccessToken
+	if json.Unmarshal(resp, &tokens); len(tokens) == 0 {
+		return nil, errNoAccessToken
+	}
+	t := &tokens[0]
+	if (t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)) || !slices.Contains(t.Scopes, scope) {
+		return nil, errNoAccessToken
+	}
+	if t.LastUsedAt == nil || time.Since(*t.LastUsedAt) > tokenLastUsedRefresh {
+		if _, _, err := execute(supabaseClient.From("personal_access_tokens").
+			Update(map[string]interface{}{"last_used_at": time.Now()}, "minimal", "").
+			Eq("token_id", t.TokenID)); err != nil {
+			log.Printf("[WARNING] failed to touch access token %s: %v", t.TokenID, err)
+		}
+	}
+	return t, nil
+}
+
+// requireAccessToken は scope を持つトークンのユーザーIDを渡してハンドラーを呼ぶ
+func requireAccessToken(scope string, next func(w http.ResponseWriter, r *http.Request, userID string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		t, err := authenticateAccessToken(r, scope)
+		if errors.Is(err, errNoAccessToken) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer scope=%q`, scope))
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] access token lookup error: %v", err)
+			http.Error(w, "failed to authenticate", http.StatusInternalServerError)
+			return
+		}
+		next(w, r, t.UserID)
+	}
+}
+
+// handleMyTokens は /api/users/me/tokens。
+// GET: 有効なトークンの一覧、POST {name, scopes, expires_at?}: 発行 (token はこの応答でしか返さない)
+func handleMyTokens(w http.ResponseWriter, r *http.Request) {
+	session, err := authenticateSession(r)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		resp, _, err := execute(supabaseClient.From("personal_access_tokens").
+			Select(accessTokenColumns, "", false).
+			Eq("user_id", session.UserID).
+			Is("revoked_at", "null").
+			Order("created_at", &postgrest.OrderOpts{Ascending: false}))
+		if err != nil {
+			log.Printf("[ERROR] handleMyTokens list error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch tokens: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+
+	case http.MethodPost:
+		var req struct {
+			Name      string     `json:"name"`
+			Scopes    []string   `json:"scopes"`
+			ExpiresAt *time.Time `json:"expires_at"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || len([]rune(req.Name)) > maxAccessTokenName {
+			http.Error(w, fmt.Sprintf("name must be 1-%d characters", maxAccessTokenName), http.StatusBadRequest)
+			return
+		}
+		slices.Sort(req.Scopes)
+		req.Scopes = slices.Compact(req.Scopes)
+		if len(req.Scopes) == 0 || slices.ContainsFunc(req.Scopes, func(s string) bool { return !slices.Contains(accessTokenScopes, s) }) {
+			http.Error(w, "scopes must be read:books and/or read:stats", http.StatusBadRequest)
+			return
+		}
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+			return
+		}
+		_, active, err := execute(supabaseClient.From("personal_access_tokens").
+			Select("token_id", "exact", true).
+			Eq("user_id", session.UserID).
+			Is("revoked_at", "null"))
+		if err != nil {
+			log.Printf("[ERROR] handleMyTokens count error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to count tokens: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if active >= maxAccessTokens {
+			http.Error(w, fmt.Sprintf("too many tokens (max %d); revoke one first", maxAccessTokens), http.StatusConflict)
+			return
+		}
+
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			http.Error(w, "failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		token := accessTokenPrefix + hex.EncodeToString(buf)
+		resp, _, err := executeOnce(supabaseClient.From("personal_access_tokens").Insert(map[string]interface{}{
+			"user_id":    session.UserID,
+			"name":       req.Name,
+			"scopes":     req.Scopes,
+			"token_hash": hashSessionToken(token),
+			"expires_at": req.ExpiresAt,
+		}, false, "", "", ""))
+		if err != nil {
+			log.Printf("[ERROR] handleMyTokens insert error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to create token: %v", err), http.StatusInternalServerError)
+			return
+		}
+		var created []AccessToken
+		json.Unmarshal(resp, &created)
+		if len(created) == 0 {
+			http.Error(w, "failed to create token", http.StatusInternalServerError)
+			return
+		}
+		created[0].UserID = ""
+		log.Printf("[INFO] access token %s (%v) created for user %s", created[0].TokenID, req.Scopes, session.UserID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"token": token, "access_token": created[0]})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleMyToken は DELETE /api/users/me/tokens/{id}。トークンを失効させる。
+func handleMyToken(w http.ResponseWriter, r *http.Request) {
+	session, err := authenticateSession(r)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	resp, _, err := execute(supabaseClient.From("personal_access_tokens").
+		Update(map[string]interface{}{"revoked_at": time.Now()}, "", "").
+		Eq("token_id", r.PathValue("id")).
+		Eq("user_id", session.UserID).
+		Is("revoked_at", "null"))
+	if err != nil {
+		log.Printf("[ERROR] handleMyToken revoke error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to revoke token: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if string(resp) == "[]" {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Token revoked"})
+}
+
+// handlePublicBooks は GET /api/public/books (read:books)。アーカイブしていない本を返す。
+func handlePublicBooks(w http.ResponseWriter, r *http.Request, userID string) {
+	books, _, err := loadUserBooks(userID)
+	if err != nil {
+		log.Printf("[ERROR] handlePublicBooks error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(excludeArchived(books))
+}
+
+// handlePublicStats は GET /api/public/stats (read:stats)
+func handlePublicStats(w http.ResponseWriter, r *http.Request, userID string) {
+	stats, err := loadUserStats(userID)
+	if err != nil {
+		log.Printf("[ERROR] handlePublicStats error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to compute stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
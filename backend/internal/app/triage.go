@@ -0,0 +1,151 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 積読のトリアージ。期限のある進行中の本ごとに、期限までの日数と残りの分量を読書ペースと比べた「健康度」(0〜100) を出し、
+// 間に合いそう (on_track)・危ない (at_risk)・手遅れ (doomed) に分ける。
+// 健康度は (期限までの日数 ÷ ペースで読み終えるのに要る日数) × 50 で、ちょうど間に合うなら 50、期限切れなら 0。
+// 分量が分からない本は期限までの日数だけで見て、triageHorizonDays 日で 50 とする。
+const (
+	triageOnTrack = "on_track"
+	triageAtRisk  = "at_risk"
+	triageDoomed  = "doomed"
+
+	triageOnTrackScore = 60 // deadlineBuffer (2割の余裕) があれば間に合う側
+	triageDoomedScore  = 25 // 普段の2倍以上のペースが要る
+	triageHorizonDays  = 7.0
+	triageDigestCount  = 3
+)
+
+var triageBuckets = []string{triageOnTrack, triageAtRisk, triageDoomed}
+
+// BookHealth は1冊分の健康度
+type BookHealth struct {
+	Book         Book    `json:"book"`
+	Score        int     `json:"score"`
+	Bucket       string  `json:"bucket"`
+	DaysLeft     int     `json:"days_left"`      // 負なら期限を過ぎた日数
+	Remaining    int     `json:"remaining"`      // 残りのページ (オーディオブックは分)。0 なら分量不明
+	NeededPerDay float64 `json:"needed_per_day"` // 期限までに読み終えるのに要る1日あたりの量
+	Reason       string  `json:"reason"`
+}
+
+// bookHealth は b の健康度を出す。期限のない本・進行中でない本は ok = false。
+func bookHealth(b Book, pace readingPace, now time.Time) (h BookHealth, ok bool) {
+	if b.Archived || b.Deadline.IsZero() || !slices.Contains(activeStatuses, b.Status) {
+		return h, false
+	}
+	h = BookHealth{Book: b}
+	daysLeft := b.Deadline.Sub(now).Hours() / 24
+	h.DaysLeft = int(math.Ceil(daysLeft))
+	unit := "ページ"
+	if b.isTimeBased() {
+		unit = "分"
+	}
+	if total := b.totalUnits(); total > 0 {
+		h.Remaining = max(0, total-b.Progress)
+	}
+
+	var score float64
+	switch {
+	case daysLeft <= 0:
+		h.DaysLeft = -max(1, int(math.Ceil(-daysLeft))) // 1日未満の超過も「1日過ぎています」とする
+		h.Reason = fmt.Sprintf("期限を%d日過ぎています", -h.DaysLeft)
+	case h.Remaining > 0 && pace.perDay(b) > 0:
+		h.NeededPerDay = float64(h.Remaining) / math.Max(1, daysLeft)
+		score = 50 * daysLeft / (float64(h.Remaining) / pace.perDay(b))
+		h.Reason = fmt.Sprintf("残り%d%sを%d日で (1日%.0f%s、最近のペースは1日%.0f%s)", h.Remaining, unit, h.DaysLeft, math.Ceil(h.NeededPerDay), unit, pace.perDay(b), unit)
+	default:
+		score = 50 * daysLeft / triageHorizonDays
+		h.Reason = fmt.Sprintf("期限まであと%d日", h.DaysLeft)
+	}
+	h.Score = int(math.Round(math.Min(100, score)))
+	switch {
+	case h.Score >= triageOnTrackScore:
+		h.Bucket = triageOnTrack
+	case h.Score >= triageDoomedScore:
+		h.Bucket = triageAtRisk
+	default:
+		h.Bucket = triageDoomed
+	}
+	return h, true
+}
+
+// triageBooks は健康度を出して分け、それぞれ健康度の低い順 (同じなら期限の早い順) に並べる
+func triageBooks(books []Book, pace readingPace, now time.Time) map[string][]BookHealth {
+	out := make(map[string][]BookHealth, len(triageBuckets))
+	for _, bucket := range triageBuckets {
+		out[bucket] = []BookHealth{}
+	}
+	for _, b := range books {
+		if h, ok := bookHealth(b, pace, now); ok {
+			out[h.Bucket] = append(out[h.Bucket], h)
+		}
+	}
+	for _, hs := range out {
+		slices.SortFunc(hs, func(a, b BookHealth) int {
+			if a.Score != b.Score {
+				return a.Score - b.Score
+			}
+			return a.Book.Deadline.Compare(b.Book.Deadline)
+		})
+	}
+	return out
+}
+
+// triageDigestSection は週報の先頭に付ける危ない本の一覧。なければ空文字。
+func triageDigestSection(books []Book, pace readingPace, now time.Time) string {
+	atRisk := triageBooks(books, pace, now)[triageAtRisk]
+	if len(atRisk) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("⚠️ 今のうちに手を付けたい本")
+	for _, h := range atRisk[:min(len(atRisk), triageDigestCount)] {
+		fmt.Fprintf(&b, "\n・『%s』(%s)", h.Book.Title, h.Reason)
+	}
+	if len(atRisk) > triageDigestCount {
+		fmt.Fprintf(&b, "\nほか%d冊", len(atRisk)-triageDigestCount)
+	}
+	return b.String() + "\n\n"
+}
+
+// handleBookTriage は GET /api/books/triage?userId=...。期限のある進行中の本を on_track・at_risk・doomed に分けて返す。
+func handleBookTriage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+	books, _, err := loadUserBooks(userId)
+	if err != nil {
+		log.Printf("[ERROR] handleBookTriage error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	pace, err := userReadingPace(userId)
+	if err != nil {
+		log.Printf("[ERROR] handleBookTriage pace error: %v", err)
+	}
+	buckets := triageBooks(books, pace, clock.Now())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		triageOnTrack: buckets[triageOnTrack],
+		triageAtRisk:  buckets[triageAtRisk],
+		triageDoomed:  buckets[triageDoomed],
+		"pace":        pace,
+	})
+}
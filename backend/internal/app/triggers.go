@@ -0,0 +1,262 @@
+package app
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// ノーコードツール向けのポーリング型トリガー。IFTTT のサービスプロトコル (/ifttt/v1/…) の形で、
+// 新しいものから limit 件と meta {id, timestamp} を返す。Make などからは GET /api/public/triggers/{trigger}?limit&after で読める。
+// 署名付き Webhook (domainevents.go) の受け口を用意できない人向け。認証は read:books のアクセストークン (tokens.go)。
+const (
+	triggerOverdueBook   = "overdue_book"
+	triggerBookCompleted = "book_completed"
+
+	triggerDefaultLimit = 50 // IFTTT の既定
+	triggerMaxLimit     = 100
+)
+
+// TriggerEvent はトリガーの1件。meta.id が同じものはツール側で重複として捨てられる。
+type TriggerEvent struct {
+	BookID      string      `json:"book_id"`
+	Title       string      `json:"title"`
+	Author      string      `json:"author"`
+	Deadline    *time.Time  `json:"deadline,omitempty"`
+	DaysOverdue *int        `json:"days_overdue,omitempty"`
+	CompletedAt *time.Time  `json:"completed_at,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"` // 出来事の日時 (期限切れなら期限、読了なら読了日時)
+	Meta        TriggerMeta `json:"meta"`
+}
+
+type TriggerMeta struct {
+	ID        string `json:"id"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// writeTriggerError は IFTTT が読める {errors: [{message}]} で返す
+func writeTriggerError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"errors": []map[string]string{{"message": message}}})
+}
+
+// iftttServiceKeyValid は IFTTT_SERVICE_KEY と IFTTT-Service-Key ヘッダーを比べる。未設定なら IFTTT 連携は無効。
+func iftttServiceKeyValid(r *http.Request) bool {
+	key := os.Getenv("IFTTT_SERVICE_KEY")
+	return key != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("IFTTT-Service-Key")), []byte(key)) == 1
+}
+
+// triggerParams は limit と after (RFC3339 か UNIX 秒) を IFTTT の JSON 本文かクエリから読む
+func triggerParams(r *http.Request) (limit int, after time.Time, err error) {
+	limit = triggerDefaultLimit
+	var body struct {
+		Limit *int   `json:"limit"`
+		After string `json:"after"`
+	}
+	if r.Method == http.MethodPost && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return 0, after, errors.New("invalid request body")
+		}
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, after, errors.New("invalid limit")
+		}
+		body.Limit = &n
+	}
+	if body.Limit != nil {
+		if *body.Limit < 0 {
+			return 0, after, errors.New("invalid limit")
+		}
+		limit = min(*body.Limit, triggerMaxLimit)
+	}
+	if v := r.URL.Query().Get("after"); v != "" {
+		body.After = v
+	}
+	if body.After != "" {
+		if t, err := time.Parse(time.RFC3339, body.After); err == nil {
+			after = t
+		} else if sec, err := strconv.ParseInt(body.After, 10, 64); err == nil {
+			after = time.Unix(sec, 0)
+		} else {
+			return 0, after, errors.New("after must be RFC3339 or a UNIX timestamp")
+		}
+	}
+	return limit, after, nil
+}
+
+// overdueTriggerEvents は期限切れになった積読。期限を延ばして再び切れたら別の出来事として数える。
+func overdueTriggerEvents(userID string, limit int, after, now time.Time) ([]TriggerEvent, error) {
+	q := supabaseClient.From("books").
+		Select("book_id, title, author, deadline", "", false).
+		Eq("user_id", userID).
+		In("status", activeStatuses).
+		Eq("archived", "false").
+		Lt("deadline", now.Format(time.RFC3339))
+	if !after.IsZero() {
+		q = q.Gt("deadline", after.Format(time.RFC3339))
+	}
+	resp, _, err := execute(q.Order("deadline", &postgrest.OrderOpts{Ascending: false}).Limit(limit, ""))
+	if err != nil {
+		return nil, err
+	}
+	var books []Book
+	if err := json.Unmarshal(resp, &books); err != nil {
+		return nil, err
+	}
+	events := make([]TriggerEvent, 0, len(books))
+	for _, b := range books {
+		deadline := b.Deadline
+		days := int(math.Floor(now.Sub(deadline).Hours() / 24))
+		events = append(events, TriggerEvent{
+			BookID: b.BookID, Title: b.Title, Author: b.Author, Deadline: &deadline, DaysOverdue: &days, CreatedAt: deadline,
+			Meta: TriggerMeta{ID: fmt.Sprintf("%s:%d", b.BookID, deadline.Unix()), Timestamp: deadline.Unix()},
+		})
+	}
+	return events, nil
+}
+
+// completedTriggerEvents は読了記録 (再読も1件ずつ)
+func completedTriggerEvents(userID string, limit int, after time.Time) ([]TriggerEvent, error) {
+	q := supabaseClient.From("book_completions").
+		Select("completion_id, book_id, completed_at, books(title, author)", "", false).
+		Eq("user_id", userID)
+	if !after.IsZero() {
+		q = q.Gt("completed_at", after.Format(time.RFC3339))
+	}
+	resp, _, err := execute(q.Order("completed_at", &postgrest.OrderOpts{Ascending: false}).Limit(limit, ""))
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		CompletionID string    `json:"completion_id"`
+		BookID       string    `json:"book_id"`
+		CompletedAt  time.Time `json:"completed_at"`
+		Book         struct {
+			Title  string `json:"title"`
+			Author string `json:"author"`
+		} `json:"books"`
+	}
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		return nil, err
+	}
+	events := make([]TriggerEvent, 0, len(rows))
+	for _, row := range rows {
+		at := row.CompletedAt
+		events = append(events, TriggerEvent{
+			BookID: row.BookID, Title: row.Book.Title, Author: row.Book.Author, CompletedAt: &at, CreatedAt: at,
+			Meta: TriggerMeta{ID: row.CompletionID, Timestamp: at.Unix()},
+		})
+	}
+	return events, nil
+}
+
+// handleTrigger は POST /ifttt/v1/triggers/{trigger} と GET /api/public/triggers/{trigger}。
+// 応答の cursor を次の after に渡すと、それより新しいものだけが返る。
+func handleTrigger(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	t, err := authenticateAccessToken(r, scopeReadBooks)
+	if errors.Is(err, errNoAccessToken) {
+		writeTriggerError(w, http.StatusUnauthorized, "invalid access token")
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] trigger token lookup error: %v", err)
+		writeTriggerError(w, http.StatusInternalServerError, "failed to authenticate")
+		return
+	}
+	limit, after, err := triggerParams(r)
+	if err != nil {
+		writeTriggerError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	events := []TriggerEvent{}
+	if limit > 0 {
+		switch trigger := r.PathValue("trigger"); trigger {
+		case triggerOverdueBook:
+			events, err = overdueTriggerEvents(t.UserID, limit, after, clock.Now())
+		case triggerBookCompleted:
+			events, err = completedTriggerEvents(t.UserID, limit, after)
+		default:
+			writeTriggerError(w, http.StatusNotFound, fmt.Sprintf("unknown trigger %q", trigger))
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] handleTrigger query error: %v", err)
+			writeTriggerError(w, http.StatusInternalServerError, "failed to fetch events")
+			return
+		}
+	}
+	result := map[string]interface{}{"data": events}
+	if len(events) > 0 {
+		result["cursor"] = events[0].CreatedAt.UTC().Format(time.RFC3339)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleIFTTTStatus は GET /ifttt/v1/status。IFTTT からの死活確認。
+func handleIFTTTStatus(w http.ResponseWriter, r *http.Request) {
+	if !iftttServiceKeyValid(r) {
+		writeTriggerError(w, http.StatusUnauthorized, "invalid service key")
+		return
+	}
+	if dbBreaker.isOpen() {
+		writeTriggerError(w, http.StatusServiceUnavailable, "service unavailable")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleIFTTTUserInfo は GET /ifttt/v1/user/info。トークンの持ち主を返す。
+func handleIFTTTUserInfo(w http.ResponseWriter, r *http.Request) {
+	t, err := authenticateAccessToken(r, scopeReadBooks)
+	if err != nil {
+		writeTriggerError(w, http.StatusUnauthorized, "invalid access token")
+		return
+	}
+	resp, _, err := execute(supabaseClient.From("users").Select("id, display_name", "", false).Eq("id", t.UserID))
+	var users []struct {
+		ID          string `json:"id"`
+		DisplayName string `json:"display_name"`
+	}
+	if err != nil || json.Unmarshal(resp, &users) != nil || len(users) == 0 {
+		writeTriggerError(w, http.StatusInternalServerError, "failed to fetch user")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"id": users[0].ID, "name": users[0].DisplayName}})
+}
+
+// handleIFTTTTestSetup は POST /ifttt/v1/test/setup。IFTTT のエンドポイントテストに IFTTT_TEST_ACCESS_TOKEN を渡す。
+func handleIFTTTTestSetup(w http.ResponseWriter, r *http.Request) {
+	if !iftttServiceKeyValid(r) {
+		writeTriggerError(w, http.StatusUnauthorized, "invalid service key")
+		return
+	}
+	token := os.Getenv("IFTTT_TEST_ACCESS_TOKEN")
+	if token == "" {
+		writeTriggerError(w, http.StatusNotFound, "test access token is not configured")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+		"accessToken": token,
+		"samples":     map[string]interface{}{"triggers": map[string]interface{}{}},
+	}})
+}
@@ -0,0 +1,282 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const undoWindowDefault = 10 // 分。UNDO_WINDOW_MINUTES で変更できる
+
+// audit_log に残す取り消し可能な操作
+const (
+	actionDeleteBook     = "book.delete"
+	actionCompleteBook   = "book.complete"
+	actionBulkStatus     = "books.bulk_status"
+	actionShiftDeadlines = "books.shift_deadlines"
+)
+
+var (
+	errUndoExpired  = errors.New("action can no longer be undone")
+	errUndoConflict = errors.New("book has changed since the action")
+	errNotUndoable  = errors.New("action cannot be undone")
+)
+
+// AuditAction は audit_log の1行。Payload に取り消しに必要な変更前の状態を持つ。
+type AuditAction struct {
+	ActionID  string          `json:"action_id"`
+	UserID    string          `json:"user_id"`
+	Action    string          `json:"action"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+	UndoneAt  *time.Time      `json:"undone_at"`
+}
+
+type undoPayload struct {
+	Rows        []json.RawMessage    `json:"rows,omitempty"`   // 削除した書籍の行 (全カラム)
+	Status      string               `json:"status,omitempty"` // 一括変更後のステータス
+	Books       []statusSnapshot     `json:"books,omitempty"`  // 一括変更前のステータス (読了以外)
+	Completions []completionSnapshot `json:"completions,omitempty"`
+	Deadlines   []deadlineSnapshot   `json:"deadlines,omitempty"` // 一括ずらし前の期限
+}
+
+type statusSnapshot struct {
+	BookID      string     `json:"book_id"`
+	Status      string     `json:"status"`
+	AbandonedAt *time.Time `json:"abandoned_at"`
+}
+
+// userStreak は読了で更新される users の列
+type userStreak struct {
+	CurrentStreak   int        `json:"current_streak"`
+	LongestStreak   int        `json:"longest_streak"`
+	LastCompletedAt *time.Time `json:"last_completed_at"`
+}
+
+// completionSnapshot は読了1件を取り消すための情報
+type completionSnapshot struct {
+	BookID       string     `json:"book_id"`
+	PrevStatus   string     `json:"prev_status"`
+	CompletionID string     `json:"completion_id"`
+	Achievements []string   `json:"achievements"` // この読了で解除した実績
+	Streak       userStreak `json:"streak"`       // 読了前の値
+}
+
+func undoWindow() time.Duration {
+	return time.Duration(envInt("UNDO_WINDOW_MINUTES", undoWindowDefault)) * time.Minute
+}
+
+// recordAction は操作を audit_log に記録して action_id を返す。記録の失敗で本来の操作は失敗させない。
+func recordAction(userID, action string, p undoPayload) string {
+	payload, _ := json.Marshal(p)
+	resp, _, err := executeOnce(supabaseClient.From("audit_log").Insert(map[string]interface{}{
+		"user_id": userID,
+		"action":  action,
+		"payload": json.RawMessage(payload),
+	}, false, "", "", ""))
+	var rows []AuditAction
+	if err == nil {
+		err = json.Unmarshal(resp, &rows)
+	}
+	if err != nil || len(rows) == 0 {
+		log.Printf("[ERROR] failed to record %s for user %s: %v", action, userID, err)
+		return ""
+	}
+	return rows[0].ActionID
+}
+
+// completeBookUndoable は読了前のステータスと連続記録を控えてから completeBook を呼ぶ
+func completeBookUndoable(ctx context.Context, bookID string) (*completionResult, completionSnapshot, error) {
+	snap := completionSnapshot{BookID: bookID}
+	resp, _, err := execute(supabaseClient.From("books").Select("status, user_id", "", false).Eq("book_id", bookID))
+	if err != nil {
+		return nil, snap, err
+	}
+	var books []Book
+	json.Unmarshal(resp, &books)
+	if len(books) == 0 {
+		return nil, snap, errBookNotFound
+	}
+	snap.PrevStatus = books[0].Status
+	uResp, _, err := execute(supabaseClient.From("users").Select("current_streak, longest_streak, last_completed_at", "", false).Eq("id", books[0].UserID))
+	if err != nil {
+		return nil, snap, err
+	}
+	var users []userStreak
+	json.Unmarshal(uResp, &users)
+	if len(users) > 0 {
+		snap.Streak = users[0]
+	}
+
+	result, err := completeBook(ctx, bookID)
+	if err != nil {
+		return nil, snap, err
+	}
+	snap.CompletionID = result.Completion.CompletionID
+	snap.Achievements = result.Achievements
+	return result, snap, nil
+}
+
+// undoCompletion は読了を取り消し、読了記録・連続記録・解除した実績・レビュー依頼を元に戻す
+func undoCompletion(userID string, c completionSnapshot) error {
+	rawResp, _, err := execute(supabaseClient.From("books").
+		Update(map[string]interface{}{"status": c.PrevStatus, "updated_at": time.Now()}, "", "").
+		Eq("book_id", c.BookID).
+		Eq("user_id", userID).
+		Eq("status", "completed"))
+	if err != nil {
+		return err
+	}
+	var rows []Book
+	if json.Unmarshal(rawResp, &rows); len(rows) == 0 {
+		return errUndoConflict
+	}
+	if c.CompletionID != "" {
+		if _, _, err := execute(supabaseClient.From("book_completions").Delete("minimal", "").Eq("completion_id", c.CompletionID)); err != nil {
+			return err
+		}
+	}
+	if _, _, err := execute(supabaseClient.From("users").Update(map[string]interface{}{
+		"current_streak":    c.Streak.CurrentStreak,
+		"longest_streak":    c.Streak.LongestStreak,
+		"last_completed_at": c.Streak.LastCompletedAt,
+		"updated_at":        time.Now(),
+	}, "minimal", "").Eq("id", userID)); err != nil {
+		return err
+	}
+	if len(c.Achievements) > 0 {
+		if _, _, err := execute(supabaseClient.From("user_achievements").Delete("minimal", "").Eq("user_id", userID).In("code", c.Achievements)); err != nil {
+			return err
+		}
+	}
+	execute(supabaseClient.From("notification_jobs").
+		Update(map[string]interface{}{"status": "skipped", "last_error": "undone"}, "minimal", "").
+		Eq("book_id", c.BookID).
+		Eq("kind", jobKindReviewNudge).
+		Eq("status", "pending"))
+	emitBookRows("book.updated", rawResp)
+	return nil
+}
+
+// undoAction は操作の種類に応じて変更前の状態に戻す
+func undoAction(a AuditAction) error {
+	var p undoPayload
+	if err := json.Unmarshal(a.Payload, &p); err != nil {
+		return err
+	}
+	switch a.Action {
+	case actionDeleteBook:
+		// 削除と一緒に消えた読書記録やメモは戻らない。書籍の行だけを同じ book_id で戻す。
+		rawResp, _, err := executeOnce(supabaseClient.From("books").Insert(p.Rows, false, "", "", ""))
+		if err != nil {
+			return err
+		}
+		emitBookRows(eventBookCreated, rawResp)
+	case actionCompleteBook, actionBulkStatus:
+		for _, c := range slices.Backward(p.Completions) {
+			err := undoCompletion(a.UserID, c)
+			if errors.Is(err, errUndoConflict) && a.Action == actionBulkStatus {
+				// 一括変更ではその後に触った本だけ残して、他は戻す
+				log.Printf("[INFO] skipping undo of book %s: %v", c.BookID, err)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("book %s: %w", c.BookID, err)
+			}
+		}
+		for _, b := range p.Books {
+			rawResp, _, err := execute(supabaseClient.From("books").
+				Update(map[string]interface{}{"status": b.Status, "abandoned_at": b.AbandonedAt, "updated_at": time.Now()}, "", "").
+				Eq("book_id", b.BookID).
+				Eq("user_id", a.UserID).
+				Eq("status", p.Status))
+			if err != nil {
+				return fmt.Errorf("book %s: %w", b.BookID, err)
+			}
+			emitBookRows("book.updated", rawResp)
+		}
+	case actionShiftDeadlines:
+		// その後に読了・中断した本はそのままにする
+		for _, d := range p.Deadlines {
+			rawResp, _, err := execute(supabaseClient.From("books").
+				Update(map[string]interface{}{"deadline": d.Deadline, "status": d.Status, "updated_at": time.Now()}, "", "").
+				Eq("book_id", d.BookID).
+				Eq("user_id", a.UserID).
+				In("status", activeStatuses))
+			if err != nil {
+				return fmt.Errorf("book %s: %w", d.BookID, err)
+			}
+			emitBookRows("book.updated", rawResp)
+		}
+	case actionMergeUsers:
+		// 統合は audit_log に記録するが、元の2つのアカウントには戻せない
+		return errNotUndoable
+	default:
+		return fmt.Errorf("unknown action %q", a.Action)
+	}
+	return nil
+}
+
+// handleUndoAction は POST /api/actions/{id}/undo。
+// 削除・読了・一括変更・期限の一括ずらしを UNDO_WINDOW_MINUTES 以内なら1回だけ取り消せる。
+// LINE のクイックリプライは誤タップが多いので、応答に含めた action_id からすぐ戻せるようにしている。
+func handleUndoAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	actionID := r.PathValue("id")
+
+	// 先に undone_at を埋めて取り合いを防ぐ。戻せなかったら外す。
+	now := time.Now()
+	resp, _, err := execute(supabaseClient.From("audit_log").
+		Update(map[string]interface{}{"undone_at": now}, "", "").
+		Eq("action_id", actionID).
+		Eq("user_id", req.UserID).
+		Is("undone_at", "null").
+		Gte("created_at", now.Add(-undoWindow()).Format(time.RFC3339)))
+	if err != nil {
+		log.Printf("[ERROR] handleUndoAction claim error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to undo: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var actions []AuditAction
+	json.Unmarshal(resp, &actions)
+	if len(actions) == 0 {
+		_, n, err := execute(supabaseClient.From("audit_log").Select("action_id", "exact", true).Eq("action_id", actionID).Eq("user_id", req.UserID))
+		if err == nil && n == 0 {
+			http.Error(w, "Action not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, errUndoExpired.Error(), http.StatusGone)
+		return
+	}
+
+	action := actions[0]
+	if err := undoAction(action); err != nil {
+		execute(supabaseClient.From("audit_log").Update(map[string]interface{}{"undone_at": nil}, "minimal", "").Eq("action_id", actionID))
+		if errors.Is(err, errUndoConflict) || errors.Is(err, errNotUndoable) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.Printf("[ERROR] handleUndoAction %s (%s): %v", actionID, action.Action, err)
+		http.Error(w, fmt.Sprintf("failed to undo: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[INFO] undid %s %s for user %s", action.Action, actionID, req.UserID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Action undone", "action": action.Action})
+}
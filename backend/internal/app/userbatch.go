@@ -0,0 +1,72 @@
+package app
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+)
+
+// userResolver は cron 1回の実行の中でユーザーの送り先設定を使い回す。
+// 実行の最初に prefetch で対象ユーザーを In() でまとめて引き、後から出てきたユーザーは1件ずつ引いて覚える。
+// 見つからなかったユーザーも nil で覚えるので、同じユーザーを何度も問い合わせない。
+type userResolver struct {
+	mu       sync.Mutex
+	settings map[string]*NotifySettings
+}
+
+func newUserResolver() *userResolver {
+	return &userResolver{settings: make(map[string]*NotifySettings)}
+}
+
+// prefetch はまだ引いていないユーザーの設定を1回のクエリで読む
+func (u *userResolver) prefetch(userIDs []string) error {
+	u.mu.Lock()
+	var missing []string
+	for _, id := range userIDs {
+		if _, ok := u.settings[id]; !ok && id != "" {
+			missing = append(missing, id)
+		}
+	}
+	u.mu.Unlock()
+	slices.Sort(missing)
+	missing = slices.Compact(missing)
+	if len(missing) == 0 {
+		return nil
+	}
+
+	resp, _, err := execute(supabaseClient.From("users").Select(notifySettingsColumns, "", false).In("id", missing))
+	if err != nil {
+		return err
+	}
+	var users []NotifySettings
+	if err := json.Unmarshal(resp, &users); err != nil {
+		return err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, id := range missing {
+		u.settings[id] = nil
+	}
+	for i := range users {
+		u.settings[users[i].UserID] = &users[i]
+	}
+	return nil
+}
+
+// notifySettings はユーザーの送り先設定。ユーザーがいなければ nil, nil。
+func (u *userResolver) notifySettings(userID string) (*NotifySettings, error) {
+	u.mu.Lock()
+	settings, ok := u.settings[userID]
+	u.mu.Unlock()
+	if ok {
+		return settings, nil
+	}
+	settings, err := fetchNotifySettings(userID)
+	if err != nil {
+		return nil, err
+	}
+	u.mu.Lock()
+	u.settings[userID] = settings
+	u.mu.Unlock()
+	return settings, nil
+}
@@ -0,0 +1,233 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 本を登録するときの既定値。リクエストで期限・insult_level・タグを省いたときに使う。
+// 期限は "+30 days" のような登録日からの相対指定で、未設定なら defaultDeadlineOffset (30日後)。
+var deadlineOffsetPattern = regexp.MustCompile(`^\+?\s*(\d{1,3})\s*(d|days?|w|weeks?|m|months?)$`)
+
+const maxDeadlineOffsetDays = 365
+
+// UserDefaults は users の default_* 列
+type UserDefaults struct {
+	DeadlineOffset *string  `json:"default_deadline_offset"`
+	InsultLevel    *int     `json:"default_insult_level"`
+	Tags           []string `json:"default_tags"`
+}
+
+// parseDeadlineOffset は "+30 days" / "2w" / "+1 month" を正規化した表記と期限の計算関数にする
+func parseDeadlineOffset(s string) (string, func(time.Time) time.Time, error) {
+	m := deadlineOffsetPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return "", nil, fmt.Errorf("deadline offset must look like +30 days, +2 weeks or +1 month")
+	}
+	n, _ := strconv.Atoi(m[1])
+	var days, months int
+	var unit string
+	switch m[2][0] {
+	case 'd':
+		days, unit = n, "days"
+	case 'w':
+		days, unit = n*7, "weeks"
+	case 'm':
+		months, unit = n, "months"
+	}
+	if n == 0 || days > maxDeadlineOffsetDays || months*31 > maxDeadlineOffsetDays {
+		return "", nil, fmt.Errorf("deadline offset must be between 1 day and %d days", maxDeadlineOffsetDays)
+	}
+	if n == 1 {
+		unit = strings.TrimSuffix(unit, "s")
+	}
+	return fmt.Sprintf("+%d %s", n, unit), func(t time.Time) time.Time { return t.AddDate(0, months, days) }, nil
+}
+
+func fetchUserDefaults(userID string) (UserDefaults, error) {
+	resp, _, err := execute(supabaseClient.From("users").
+		Select("default_deadline_offset, default_insult_level, default_tags", "", false).
+		Eq("id", userID))
+	if err != nil {
+		return UserDefaults{}, err
+	}
+	var users []UserDefaults
+	if err := json.Unmarshal(resp, &users); err != nil || len(users) == 0 {
+		return UserDefaults{}, err
+	}
+	return users[0], nil
+}
+
+// deadline は now に既定の期限のずれを足す。保存値が壊れていれば defaultDeadlineOffset を使う。
+func (d UserDefaults) deadline(now time.Time) time.Time {
+	if d.DeadlineOffset != nil {
+		if _, add, err := parseDeadlineOffset(*d.DeadlineOffset); err == nil {
+			return add(now)
+		}
+	}
+	return now.Add(defaultDeadlineOffset)
+}
+
+func (d UserDefaults) insultLevel() int {
+	if d.InsultLevel != nil {
+		return clampInsultLevel(*d.InsultLevel)
+	}
+	return insultLevelDefault
+}
+
+// applyTo は book で省かれている項目に既定値を入れる。欲しい本・入荷待ちには期限を付けない。
+func (d UserDefaults) applyTo(book *Book, now time.Time) {
+	if book.Deadline.IsZero() && !deadlineless(book.Status) {
+		book.Deadline = d.deadline(now)
+	}
+	if book.InsultLevel == 0 {
+		book.InsultLevel = d.insultLevel()
+	}
+	if book.Tags == nil {
+		book.Tags = d.Tags
+	}
+}
+
+// handleMyPreferences は /api/users/me/preferences。
+// GET: 登録時の既定値とデータ保持の設定 (privacy.go)、
+// PUT {deadline_offset, insult_level, tags, retain_insult_history, notification_log_retention_days, extension_penalty, family_safe, timezone}:
+// 送られてきた項目だけ更新する (空文字・0・[] で消す)。
+func handleMyPreferences(w http.ResponseWriter, r *http.Request) {
+	session, err := authenticateSession(r)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req struct {
+			DeadlineOffset *string   `json:"deadline_offset"`
+			InsultLevel    *int      `json:"insult_level"`
+			Tags           *[]string `json:"tags"`
+
+			RetainInsultHistory          *bool `json:"retain_insult_history"`
+			NotificationLogRetentionDays *int  `json:"notification_log_retention_days"`
+
+			ExtensionPenalty *string `json:"extension_penalty"` // 期限を延長したときのペナルティ (extension.go)
+			FamilySafe       *bool   `json:"family_safe"`       // 家族向けモード (familymode.go)
+			Timezone         *string `json:"timezone"`          // 期限の日付を読むタイムゾーン (deadlineinput.go)。IANA の名前
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		update := map[string]interface{}{"updated_at": clock.Now()}
+		if req.DeadlineOffset != nil {
+			update["default_deadline_offset"] = nil
+			if *req.DeadlineOffset != "" {
+				offset, _, err := parseDeadlineOffset(*req.DeadlineOffset)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				update["default_deadline_offset"] = offset
+			}
+		}
+		if req.InsultLevel != nil {
+			if *req.InsultLevel < 0 || *req.InsultLevel > insultLevelMax {
+				http.Error(w, fmt.Sprintf("insult_level must be 1-%d (0 to clear)", insultLevelMax), http.StatusBadRequest)
+				return
+			}
+			update["default_insult_level"] = nil
+			if *req.InsultLevel > 0 {
+				update["default_insult_level"] = *req.InsultLevel
+			}
+		}
+		if req.Tags != nil {
+			update["default_tags"] = nil
+			if tags := normalizeTags(*req.Tags); len(tags) > 0 {
+				update["default_tags"] = tags
+			}
+		}
+		if req.RetainInsultHistory != nil {
+			update["retain_insult_history"] = *req.RetainInsultHistory
+		}
+		if req.NotificationLogRetentionDays != nil {
+			days := *req.NotificationLogRetentionDays
+			if !validNotificationLogRetention(days) {
+				http.Error(w, fmt.Sprintf("notification_log_retention_days must be %d-%d (0 to keep forever)", minNotificationLogRetentionDays, maxNotificationLogRetentionDays), http.StatusBadRequest)
+				return
+			}
+			update["notification_log_retention_days"] = nil
+			if days > 0 {
+				update["notification_log_retention_days"] = days
+			}
+		}
+		if req.ExtensionPenalty != nil {
+			if *req.ExtensionPenalty != "" && !validExtensionPenalties[*req.ExtensionPenalty] {
+				http.Error(w, "extension_penalty must be none or insult_up", http.StatusBadRequest)
+				return
+			}
+			update["extension_penalty"] = nullIfEmpty(*req.ExtensionPenalty)
+		}
+		if req.FamilySafe != nil {
+			update["family_safe"] = *req.FamilySafe
+		}
+		if req.Timezone != nil {
+			if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "Local" {
+				http.Error(w, "timezone must be an IANA time zone such as Asia/Tokyo", http.StatusBadRequest)
+				return
+			}
+			update["timezone"] = nullIfEmpty(*req.Timezone)
+		}
+		if _, _, err := execute(supabaseClient.From("users").Update(update, "minimal", "").Eq("id", session.UserID)); err != nil {
+			log.Printf("[ERROR] handleMyPreferences update error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to update preferences: %v", err), http.StatusInternalServerError)
+			return
+		}
+		appCache.Delete(familySafeCacheKey(session.UserID))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	defaults, err := fetchUserDefaults(session.UserID)
+	if err != nil {
+		log.Printf("[ERROR] handleMyPreferences query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch preferences: %v", err), http.StatusInternalServerError)
+		return
+	}
+	privacy, err := fetchPrivacySettings(session.UserID)
+	if err != nil {
+		log.Printf("[ERROR] handleMyPreferences privacy query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch preferences: %v", err), http.StatusInternalServerError)
+		return
+	}
+	penalty, err := userExtensionPenalty(session.UserID)
+	if err != nil {
+		log.Printf("[ERROR] handleMyPreferences penalty query error: %v", err)
+	}
+	timezone := defaultTimezone
+	if loc := userLocation(session.UserID); loc != jst {
+		timezone = loc.String()
+	}
+	now := clock.Now()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"privacy":           privacy.settingsJSON(),
+		"extension_penalty": penalty,
+		"family_safe":       familySafe(session.UserID), // ワークスペースの設定で有効になっている場合も true
+		"timezone":          timezone,
+		"deadline_offset":   defaults.DeadlineOffset,
+		"insult_level":      defaults.InsultLevel,
+		"tags":              defaults.Tags,
+		// 今登録したときに入る値
+		"effective": map[string]interface{}{
+			"deadline":     defaults.deadline(now),
+			"insult_level": defaults.insultLevel(),
+			"tags":         normalizeTags(defaults.Tags),
+		},
+	})
+}
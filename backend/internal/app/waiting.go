@@ -0,0 +1,144 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// 手元にまだない本 (図書館の予約・予約購入) の入荷待ち。期限は持たず、available_at (届く予定日) が来たら
+// cron が積読 (unread) に戻して新しい期限を付け、ユーザーに知らせる。予定より早く届いたら /arrived で切り替える。
+const (
+	statusWaiting  = "waiting"
+	jobKindArrived = "arrived" // 入荷待ちの本が届く日になったお知らせ
+)
+
+var waitingReasons = []string{"library", "preorder"}
+
+// validWaitingFor は空文字 (指定なし) か既知の理由なら true
+func validWaitingFor(s string) bool {
+	return s == "" || slices.Contains(waitingReasons, s)
+}
+
+// deadlineless は期限を持たないステータス (欲しい本・入荷待ち) か
+func deadlineless(status string) bool {
+	return status == statusWishlist || status == statusWaiting
+}
+
+// activateWaitingBooks は届く予定日を過ぎた入荷待ちの本を積読にし、ユーザーの既定の期限を付けて知らせる
+func activateWaitingBooks(users *userResolver, now time.Time) (int, error) {
+	resp, _, err := execute(supabaseClient.From("books").
+		Select("*", "", false).
+		Eq("status", statusWaiting).
+		Eq("archived", "false").
+		Lte("available_at", now.Format(time.RFC3339)))
+	if err != nil {
+		return 0, err
+	}
+	var books []Book
+	if err := json.Unmarshal(resp, &books); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, book := range books {
+		defaults, err := fetchUserDefaults(book.UserID)
+		if err != nil {
+			log.Printf("[WARNING] defaults lookup failed for user %s: %v", book.UserID, err)
+		}
+		deadline := defaults.deadline(now)
+		rawResp, _, err := execute(supabaseClient.From("books").
+			Update(map[string]interface{}{"status": "unread", "deadline": deadline, "updated_at": now}, "", "").
+			Eq("book_id", book.BookID).
+			Eq("status", statusWaiting))
+		if err != nil {
+			log.Printf("[ERROR] failed to activate waiting book %s: %v", book.BookID, err)
+			continue
+		}
+		if string(rawResp) == "[]" {
+			continue
+		}
+		emitBookRows("book.updated", rawResp)
+		log.Printf("[INFO] waiting book %s is now available, deadline %s", book.BookID, deadline.Format(time.RFC3339))
+
+		channel, lineUserID, ok := notifyTarget(users, book)
+		if !ok {
+			continue
+		}
+		err = enqueueJob(NotificationJob{
+			Kind:       jobKindArrived,
+			BookID:     book.BookID,
+			UserID:     book.UserID,
+			Channel:    channel,
+			LineUserID: lineUserID,
+			Message:    fmt.Sprintf("📦 『%s』が届く予定日になりました。積読に入れたので、%sまでに読みましょう。", book.Title, deadline.In(jst).Format("1/2")),
+			RunAt:      now,
+		})
+		if err != nil {
+			log.Printf("[ERROR] failed to enqueue arrival notice for book %s: %v", book.BookID, err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// handleArrived は POST /api/books/{id}/arrived {user_id, deadline}。入荷待ちの本が届いたので積読にする。
+// deadline を省くとユーザーの既定の期限を付ける。
+func handleArrived(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID   string     `json:"user_id"`
+		Deadline *time.Time `json:"deadline"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	book, err := fetchOwnedBook(r.PathValue("id"), req.UserID)
+	if err != nil {
+		writeBookLookupError(w, err)
+		return
+	}
+	if book.Status != statusWaiting {
+		http.Error(w, "Book is not waiting", http.StatusConflict)
+		return
+	}
+
+	now := clock.Now()
+	var deadline time.Time
+	if req.Deadline != nil {
+		if !req.Deadline.After(now) {
+			http.Error(w, "deadline must be in the future", http.StatusBadRequest)
+			return
+		}
+		deadline = *req.Deadline
+	} else {
+		defaults, err := fetchUserDefaults(book.UserID)
+		if err != nil {
+			log.Printf("[WARNING] handleArrived defaults lookup failed for user %s: %v", book.UserID, err)
+		}
+		deadline = defaults.deadline(now)
+	}
+
+	rawResp, _, err := execute(supabaseClient.From("books").Update(map[string]interface{}{
+		"status":     "unread",
+		"deadline":   deadline,
+		"updated_at": now,
+	}, "", "").Eq("book_id", book.BookID).Eq("user_id", book.UserID).Eq("status", statusWaiting))
+	if err != nil {
+		log.Printf("[ERROR] handleArrived update error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to mark as arrived: %v", err), http.StatusInternalServerError)
+		return
+	}
+	emitBookRows("book.updated", rawResp)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Book arrived", "deadline": deadline})
+}
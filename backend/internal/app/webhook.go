@@ -0,0 +1,239 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	maxWebhookBody        = 1 << 20
+	deliverableCacheTTL   = 5 * time.Minute
+	deliverableCacheValue = "1"
+)
+
+// lineWebhookEvent は Webhook イベントのうち使う項目だけ
+type lineWebhookEvent struct {
+	Type       string `json:"type"` // follow, unfollow, message, ...
+	ReplyToken string `json:"replyToken"`
+	Timestamp  int64  `json:"timestamp"` // ミリ秒
+	Source     struct {
+		Type    string `json:"type"` // user, group, room
+		UserID  string `json:"userId"`
+		GroupID string `json:"groupId"`
+	} `json:"source"`
+	Message struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"message"`
+	Left struct {
+		Members []struct {
+			UserID string `json:"userId"`
+		} `json:"members"`
+	} `json:"left"` // memberLeft
+}
+
+func deliverableCacheKey(lineUserID string) string { return "line:blocked:" + lineUserID }
+
+// verifyLineSignature は X-Line-Signature (チャネルシークレットによる本文の HMAC-SHA256) を確認する
+func verifyLineSignature(ch LineChannel, body []byte, signature string) bool {
+	if ch.Secret == "" || signature == "" {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(ch.Secret))
+	mac.Write(body)
+	expected, err := base64.StdEncoding.DecodeString(signature)
+	return err == nil && hmac.Equal(mac.Sum(nil), expected)
+}
+
+// lineDeliverable はブロックされていない (push してよい) かを返す。問い合わせに失敗したら送る側に倒す。
+func lineDeliverable(lineUserID string) bool {
+	if v, ok := appCache.Get(deliverableCacheKey(lineUserID)); ok {
+		return string(v) != deliverableCacheValue
+	}
+	resp, _, err := execute(supabaseClient.From("users").Select("line_blocked_at", "", false).Eq("line_user_id", lineUserID))
+	if err != nil {
+		log.Printf("[ERROR] failed to check block status for %s: %v", lineUserID, err)
+		return true
+	}
+	var users []struct {
+		LineBlockedAt *time.Time `json:"line_blocked_at"`
+	}
+	json.Unmarshal(resp, &users)
+	blocked := len(users) > 0 && users[0].LineBlockedAt != nil
+	value := "0"
+	if blocked {
+		value = deliverableCacheValue
+	}
+	appCache.Set(deliverableCacheKey(lineUserID), []byte(value), deliverableCacheTTL)
+	return !blocked
+}
+
+// setLineBlocked は unfollow / follow に合わせて users.line_blocked_at を更新する
+func setLineBlocked(lineUserID string, blocked bool) error {
+	var blockedAt interface{}
+	if blocked {
+		blockedAt = clock.Now()
+	}
+	_, _, err := execute(supabaseClient.From("users").
+		Update(map[string]interface{}{"line_blocked_at": blockedAt, "updated_at": clock.Now()}, "minimal", "").
+		Eq("line_user_id", lineUserID))
+	appCache.Delete(deliverableCacheKey(lineUserID))
+	return err
+}
+
+// onboardingMessage は友だち追加時に送る使い方の案内
+func onboardingMessage() string {
+	msg := "友だち追加ありがとうございます。積読キラーです。\n" +
+		"買ったまま読んでいない本を登録すると、期限を過ぎたときに容赦なく督促します。\n\n" +
+		"1. 下のメニューから「アカウント連携」\n" +
+		"2. 「本を登録」でタイトル・著者・読了期限を入力\n" +
+		"3. 読み終えたら「読了」にするだけ"
+	if url := liffURL("/register"); url != "/register" {
+		msg += "\n\n登録はこちら: " + url
+	}
+	return msg
+}
+
+func replyLineMessage(ch LineChannel, replyToken, message string) error {
+	messages := []LineMessage{LineTextMessage{Type: "text", Text: message}}
+	if err := validateLineMessages(messages); err != nil {
+		return err
+	}
+	if captureReplayReply(replyToken, message) {
+		return nil
+	}
+	body, _ := json.Marshal(LineReplyRequest{ReplyToken: replyToken, Messages: messages})
+	_, err := lineChannelAPI(ch, http.MethodPost, lineAPIBase+"/message/reply", "application/json", bytes.NewReader(body))
+	return err
+}
+
+// handleLineWebhook は POST /api/line/webhook (default チャネル) と /api/line/webhook/{channel}。
+// follow で案内を返信してブロック状態を解除し、そのチャネルをユーザーの送信チャネルにする。
+// unfollow で送信不可として記録する (以降の push は processNotificationJob で skipped になる)。
+func handleLineWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ch, err := lineChannelByName(r.PathValue("channel"))
+	if err != nil {
+		http.Error(w, "Unknown channel", http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	signatureValid := verifyLineSignature(ch, body, r.Header.Get("X-Line-Signature"))
+	// 署名が合わなかったものも調査用に残す (webhookreplay.go)
+	recordWebhookEvents(ch, body, signatureValid)
+	if !signatureValid {
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+	var payload struct {
+		Events []lineWebhookEvent `json:"events"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	for _, ev := range payload.Events {
+		processLineEvent(ch, ev)
+	}
+
+	// LINE は 2xx 以外を再送するので、個々の失敗はログに残して 200 を返す
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": fmt.Sprintf("Processed %d events", len(payload.Events))})
+}
+
+// processLineEvent は Webhook イベント1件を処理する。管理画面からの再生 (webhookreplay.go) もここを通す。
+func processLineEvent(ch LineChannel, ev lineWebhookEvent) {
+	if ev.Source.Type == "group" {
+		handleGroupEvent(ch, ev)
+		return
+	}
+	userID := ev.Source.UserID
+	if ev.Source.Type != "user" || userID == "" {
+		return
+	}
+	// 自分から操作した時刻は急ぎでない通知の送信時刻の学習に使う (sendtime.go)
+	if ev.Type == "message" || ev.Type == "postback" || ev.Type == "follow" {
+		recordLineActivity(userID, ev.Type, lineEventTime(ev.Timestamp))
+	}
+	switch ev.Type {
+	case "follow":
+		if err := setLineBlocked(userID, false); err != nil {
+			log.Printf("[ERROR] failed to clear block status for %s: %v", userID, err)
+		}
+		if err := assignLineChannel(userID, ch.Name); err != nil {
+			log.Printf("[ERROR] failed to assign LINE channel %s to %s: %v", ch.Name, userID, err)
+		}
+		// 連携済みのユーザーが友だち追加し直したときは、はじめかたの続きも案内する
+		welcome := onboardingMessage()
+		if next, ok := onboardingLineReply(userID); ok {
+			welcome += "\n\n" + next
+		}
+		if err := replyLineMessage(ch, ev.ReplyToken, welcome); err != nil {
+			log.Printf("[ERROR] failed to send onboarding message to %s: %v", userID, err)
+		}
+		log.Printf("[INFO] LINE follow (%s): %s", ch.Name, userID)
+	case "unfollow":
+		if err := setLineBlocked(userID, true); err != nil {
+			log.Printf("[ERROR] failed to mark %s as blocked: %v", userID, err)
+		}
+		log.Printf("[INFO] LINE unfollow: %s", userID)
+	case "message":
+		// 「立会人 <コード>」で賭けの立会人になり、督促への「あと1週間」で期限を延ばし、
+		// 「読書開始」と数字の返信で集中読書を記録し、「〇〇 どこ」で置き場所を答え、「はじめかた」で初回の案内の続きを返す。
+		// それ以外のメッセージには返信しない。
+		if ev.Message.Type != "text" {
+			return
+		}
+		if strings.TrimSpace(ev.Message.Text) == onboardingLineCommand {
+			reply, ok := onboardingLineReply(userID)
+			if !ok {
+				reply = onboardingMessage()
+			}
+			if err := replyLineMessage(ch, ev.ReplyToken, reply); err != nil {
+				log.Printf("[ERROR] failed to reply onboarding to %s: %v", userID, err)
+			}
+			return
+		}
+		if code, accept, ok := parseStakeCommand(ev.Message.Text); ok {
+			if err := replyLineMessage(ch, ev.ReplyToken, stakeCommandReply(userID, code, accept)); err != nil {
+				log.Printf("[ERROR] failed to reply stake command to %s: %v", userID, err)
+			}
+			return
+		}
+		if reply, ok := extensionCommandReply(userID, ev.Message.Text); ok {
+			if err := replyLineMessage(ch, ev.ReplyToken, reply); err != nil {
+				log.Printf("[ERROR] failed to reply extension command to %s: %v", userID, err)
+			}
+			return
+		}
+		if reply, ok := focusCommandReply(userID, ev.Message.Text); ok {
+			if err := replyLineMessage(ch, ev.ReplyToken, reply); err != nil {
+				log.Printf("[ERROR] failed to reply focus command to %s: %v", userID, err)
+			}
+			return
+		}
+		query, ok := parseWhereQuery(ev.Message.Text)
+		if !ok {
+			return
+		}
+		if err := replyLineMessage(ch, ev.ReplyToken, whereIsReply(userID, query)); err != nil {
+			log.Printf("[ERROR] failed to reply where-is to %s: %v", userID, err)
+		}
+	}
+}
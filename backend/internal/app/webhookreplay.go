@@ -0,0 +1,338 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// 受け取った LINE の Webhook イベントを line_webhook_events に残し、管理画面から見直したり
+// 処理をもう一度通したりできるようにする。チャットのコマンドが思った通りに解釈されないときの調査用。
+// イベントとして読めなかったものは本文をそのまま (先頭だけ) 残す。
+// 署名が合わなかったものは誰でも送れるので、1分に invalidWebhookSamplesPerMinute 件まで本文の先頭 1KiB だけを残し、
+// それを超えた分は数だけログに出す。
+const (
+	maxStoredWebhookBody           = 64 << 10
+	maxStoredInvalidWebhookBody    = 1 << 10
+	invalidWebhookSamplesPerMinute = 10
+	defaultWebhookEventLimit       = 50
+	maxWebhookEventLimit           = 200
+	replayReplyTokenPrefix         = "replay:"
+)
+
+// WebhookEventRecord は line_webhook_events の1行
+type WebhookEventRecord struct {
+	EventID        string          `json:"event_id"`
+	Channel        string          `json:"channel"`
+	SignatureValid bool            `json:"signature_valid"`
+	EventType      *string         `json:"event_type"`
+	SourceType     *string         `json:"source_type"`
+	LineUserID     *string         `json:"line_user_id"`
+	GroupID        *string         `json:"group_id"`
+	Event          json.RawMessage `json:"event"` // イベント1件。読めなかったときは null で Body に本文が入る
+	Body           *string         `json:"body"`
+	ReceivedAt     time.Time       `json:"received_at"`
+	ReplayCount    int             `json:"replay_count"`
+	LastReplayedAt *time.Time      `json:"last_replayed_at"`
+}
+
+func (rec WebhookEventRecord) hasEvent() bool {
+	return len(rec.Event) > 0 && string(rec.Event) != "null"
+}
+
+// recordWebhookEvents は受け取った本文をイベントごとに保存する。失敗しても Webhook の処理は続ける。
+func recordWebhookEvents(ch LineChannel, body []byte, signatureValid bool) {
+	now := clock.Now()
+	if !signatureValid {
+		if invalidWebhookSamples.allow(now) {
+			insertWebhookEventRows(ch, []map[string]interface{}{{
+				"channel":         ch.Name,
+				"signature_valid": false,
+				"body":            string(body[:min(len(body), maxStoredInvalidWebhookBody)]),
+				"received_at":     now,
+			}})
+		}
+		return
+	}
+	var payload struct {
+		Events []json.RawMessage `json:"events"`
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err == nil {
+		for _, raw := range payload.Events {
+			var ev lineWebhookEvent
+			if err := json.Unmarshal(raw, &ev); err != nil {
+				continue
+			}
+			rows = append(rows, map[string]interface{}{
+				"channel":         ch.Name,
+				"signature_valid": true,
+				"event_type":      nullIfEmpty(ev.Type),
+				"source_type":     nullIfEmpty(ev.Source.Type),
+				"line_user_id":    nullIfEmpty(ev.Source.UserID),
+				"group_id":        nullIfEmpty(ev.Source.GroupID),
+				"event":           raw,
+				"received_at":     now,
+			})
+		}
+	}
+	if len(rows) == 0 {
+		// 疎通確認の空の events は残さない
+		if payload.Events != nil {
+			return
+		}
+		rows = append(rows, map[string]interface{}{
+			"channel":         ch.Name,
+			"signature_valid": true,
+			"body":            string(body[:min(len(body), maxStoredWebhookBody)]),
+			"received_at":     now,
+		})
+	}
+	insertWebhookEventRows(ch, rows)
+}
+
+func insertWebhookEventRows(ch LineChannel, rows []map[string]interface{}) {
+	if _, _, err := executeOnce(supabaseClient.From("line_webhook_events").Insert(rows, false, "", "minimal", "")); err != nil {
+		log.Printf("[ERROR] failed to record %d webhook events (%s): %v", len(rows), ch.Name, err)
+	}
+}
+
+// webhookSampler は署名が合わない Webhook を1分あたり limit 件まで通し、捨てた数は次の分の最初にログに出す
+type webhookSampler struct {
+	mu      sync.Mutex
+	limit   int
+	minute  time.Time
+	stored  int
+	dropped int
+}
+
+var invalidWebhookSamples = &webhookSampler{limit: invalidWebhookSamplesPerMinute}
+
+func (s *webhookSampler) allow(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if minute := now.Truncate(time.Minute); !minute.Equal(s.minute) {
+		if s.dropped > 0 {
+			log.Printf("[WARNING] dropped %d webhook requests with an invalid signature in the minute from %s", s.dropped, s.minute.Format(time.RFC3339))
+		}
+		s.minute, s.stored, s.dropped = minute, 0, 0
+	}
+	if s.stored >= s.limit {
+		s.dropped++
+		return false
+	}
+	s.stored++
+	return true
+}
+
+// 再生中の返信は LINE に送らずここに集める。キーは再生ごとに作る返信トークン。
+var replayReplies = struct {
+	sync.Mutex
+	m map[string][]string
+}{m: make(map[string][]string)}
+
+// captureReplayReply は再生用の返信トークンなら返信文を記録して true を返す (replyLineMessage から呼ぶ)
+func captureReplayReply(replyToken, message string) bool {
+	replayReplies.Lock()
+	defer replayReplies.Unlock()
+	replies, ok := replayReplies.m[replyToken]
+	if !ok {
+		return false
+	}
+	replayReplies.m[replyToken] = append(replies, message)
+	return true
+}
+
+// replayLineEvent は ev を Webhook と同じ処理に通し、返すはずだった返信を集めて返す。
+// 返信以外の処理 (ブロック状態や集中読書の記録など) は本当に行われる。
+func replayLineEvent(ch LineChannel, ev lineWebhookEvent) []string {
+	token := replayReplyTokenPrefix + newEventID()
+	replayReplies.Lock()
+	replayReplies.m[token] = []string{}
+	replayReplies.Unlock()
+	defer func() {
+		replayReplies.Lock()
+		delete(replayReplies.m, token)
+		replayReplies.Unlock()
+	}()
+	ev.ReplyToken = token
+	processLineEvent(ch, ev)
+	replayReplies.Lock()
+	defer replayReplies.Unlock()
+	return replayReplies.m[token]
+}
+
+// describeLineCommand はメッセージがどのコマンドとして解釈されるかを、処理を実行せずに返す。
+// processLineEvent と同じ順で判定するので、コマンドを足したらここにも足す。
+func describeLineCommand(ev lineWebhookEvent) map[string]interface{} {
+	switch {
+	case ev.Type != "message":
+		return map[string]interface{}{"command": ev.Type}
+	case ev.Message.Type != "text":
+		return map[string]interface{}{"command": nil, "reason": "not a text message"}
+	case ev.Source.Type == "group":
+		if text := strings.TrimSpace(ev.Message.Text); text == groupShameOptIn || text == groupShameOptOut {
+			return map[string]interface{}{"command": "group_shame", "opt_in": text == groupShameOptIn}
+		}
+		return map[string]interface{}{"command": nil}
+	}
+	if strings.TrimSpace(ev.Message.Text) == onboardingLineCommand {
+		return map[string]interface{}{"command": "onboarding"}
+	}
+	if code, accept, ok := parseStakeCommand(ev.Message.Text); ok {
+		return map[string]interface{}{"command": "stake", "code": code, "accept": accept}
+	}
+	if days, ok := parseExtensionCommand(ev.Message.Text); ok {
+		// 直近の督促がなければ実際には延長されない
+		return map[string]interface{}{"command": "extend", "days": days}
+	}
+	if input, ok := parseDeadlineCommand(ev.Message.Text); ok {
+		return map[string]interface{}{"command": "extend", "deadline": input}
+	}
+	if query, isStart, pages, ok := parseFocusCommand(ev.Message.Text); ok {
+		if isStart {
+			return map[string]interface{}{"command": "focus_start", "query": query}
+		}
+		// 集中読書中でなければ実際には無視される
+		return map[string]interface{}{"command": "focus_pages", "pages": pages}
+	}
+	if query, ok := parseWhereQuery(ev.Message.Text); ok {
+		return map[string]interface{}{"command": "where", "query": query}
+	}
+	return map[string]interface{}{"command": nil}
+}
+
+func fetchWebhookEvent(eventID string) (*WebhookEventRecord, error) {
+	resp, _, err := execute(supabaseClient.From("line_webhook_events").Select("*", "", false).Eq("event_id", eventID))
+	if err != nil {
+		return nil, err
+	}
+	var rows []WebhookEventRecord
+	if err := json.Unmarshal(resp, &rows); err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	return &rows[0], nil
+}
+
+// handleAdminWebhookEvents は GET /api/admin/webhook-events。新しい順に返す。
+// ?channel=, ?type=, ?line_user_id=, ?group_id= で絞り込み、?invalid=true で署名が合わなかったものだけにする。
+func handleAdminWebhookEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := defaultWebhookEventLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxWebhookEventLimit)
+	}
+	q := supabaseClient.From("line_webhook_events").Select("*", "", false)
+	for param, column := range map[string]string{"channel": "channel", "type": "event_type", "line_user_id": "line_user_id", "group_id": "group_id"} {
+		if v := r.URL.Query().Get(param); v != "" {
+			q = q.Eq(column, v)
+		}
+	}
+	if r.URL.Query().Get("invalid") == "true" {
+		q = q.Eq("signature_valid", "false")
+	}
+	resp, _, err := execute(q.Order("received_at", &postgrest.OrderOpts{Ascending: false}).Limit(limit, ""))
+	if err != nil {
+		log.Printf("[ERROR] handleAdminWebhookEvents query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch webhook events: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+// handleAdminWebhookEvent は GET /api/admin/webhook-events/{id}。保存したイベントと、メッセージがどのコマンドとして解釈されるかを返す。
+func handleAdminWebhookEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rec, err := fetchWebhookEvent(r.PathValue("id"))
+	if err != nil {
+		log.Printf("[ERROR] handleAdminWebhookEvent query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch webhook event: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if rec == nil {
+		http.Error(w, "Webhook event not found", http.StatusNotFound)
+		return
+	}
+	result := map[string]interface{}{"event": rec}
+	var ev lineWebhookEvent
+	if rec.hasEvent() && json.Unmarshal(rec.Event, &ev) == nil {
+		result["parsed"] = describeLineCommand(ev)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleAdminWebhookEventReplay は POST /api/admin/webhook-events/{id}/replay。
+// 保存したイベントを Webhook と同じ処理にもう一度通し、返信するはずだった文を返す (LINE には送らない)。
+// {"dry_run": true} ならコマンドの解釈だけを返して何も実行しない。署名が合わなかったイベントは再生しない。
+func handleAdminWebhookEventReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		DryRun bool `json:"dry_run"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+	}
+	rec, err := fetchWebhookEvent(r.PathValue("id"))
+	if err != nil {
+		log.Printf("[ERROR] handleAdminWebhookEventReplay query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch webhook event: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if rec == nil {
+		http.Error(w, "Webhook event not found", http.StatusNotFound)
+		return
+	}
+	if !rec.SignatureValid {
+		http.Error(w, "Refusing to replay an event with an invalid signature", http.StatusConflict)
+		return
+	}
+	var ev lineWebhookEvent
+	if !rec.hasEvent() || json.Unmarshal(rec.Event, &ev) != nil {
+		http.Error(w, "Stored payload is not a webhook event", http.StatusConflict)
+		return
+	}
+	result := map[string]interface{}{"event_id": rec.EventID, "dry_run": req.DryRun, "parsed": describeLineCommand(ev)}
+	if !req.DryRun {
+		ch, err := lineChannelByName(rec.Channel)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		result["replies"] = replayLineEvent(ch, ev)
+		if _, _, err := execute(supabaseClient.From("line_webhook_events").Update(map[string]interface{}{
+			"replay_count":     rec.ReplayCount + 1,
+			"last_replayed_at": clock.Now(),
+		}, "minimal", "").Eq("event_id", rec.EventID)); err != nil {
+			log.Printf("[ERROR] failed to record replay of webhook event %s: %v", rec.EventID, err)
+		}
+		log.Printf("[INFO] replayed webhook event %s (%s %s)", rec.EventID, rec.Channel, ev.Type)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	statusWishlist        = "wishlist"
+	defaultDeadlineOffset = 30 * 24 * time.Hour
+)
+
+// filterByTier は一覧を wishlist (欲しい本) と owned (手元の積読) に分ける
+func filterByTier(books []Book, tier string) []Book {
+	filtered := []Book{}
+	for _, b := range books {
+		if (tier == "wishlist") == (b.Status == statusWishlist) {
+			filtered = append(filtered, b)
+		}
+	}
+	return filtered
+}
+
+// handlePurchase は POST /api/books/{id}/purchase。欲しい本を購入済みにして期限のカウントを始める。
+func handlePurchase(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID   string     `json:"user_id"`
+		Deadline *time.Time `json:"deadline"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	book, err := fetchOwnedBook(r.PathValue("id"), req.UserID)
+	if err != nil {
+		writeBookLookupError(w, err)
+		return
+	}
+	if book.Status != statusWishlist {
+		http.Error(w, "Book is not on the wishlist", http.StatusConflict)
+		return
+	}
+
+	deadline := clock.Now().Add(defaultDeadlineOffset)
+	if req.Deadline != nil {
+		if !req.Deadline.After(clock.Now()) {
+			http.Error(w, "deadline must be in the future", http.StatusBadRequest)
+			return
+		}
+		deadline = *req.Deadline
+	}
+
+	rawResp, _, err := execute(supabaseClient.From("books").Update(map[string]interface{}{
+		"status":     "unread",
+		"deadline":   deadline,
+		"updated_at": clock.Now(),
+	}, "", "").Eq("book_id", book.BookID).Eq("user_id", book.UserID).Eq("status", statusWishlist))
+	if err != nil {
+		log.Printf("[ERROR] handlePurchase update error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to mark as purchased: %v", err), http.StatusInternalServerError)
+		return
+	}
+	emitBookRows("book.updated", rawResp)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Book purchased, the clock is ticking", "deadline": deadline})
+}
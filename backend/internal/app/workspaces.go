@@ -0,0 +1,558 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	postgrest "github.com/supabase-community/postgrest-go"
+)
+
+// ワークスペースは研究室や読書会など、1つのバックエンドを使うコミュニティの単位。
+// ユーザーは1つのワークスペースにだけ入れる。ワークスペースの管理者はメンバーの本を見られ、
+// メンバー全員の督促に混ざる督促文 (workspace_insults) を管理できる。他のワークスペースのデータは見えない。
+const (
+	workspaceRoleMember = "member"
+	workspaceRoleAdmin  = "admin"
+
+	// workspaceInsultPrefix を付けたキーで notification_jobs.template に記録する
+	workspaceInsultPrefix = "workspace:"
+	maxWorkspaceInsults   = 200
+)
+
+var (
+	errWorkspaceNotFound = errors.New("workspace not found")
+
+	workspaceSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,39}$`)
+)
+
+// Workspace は workspaces の1行。join_code はワークスペースの管理者にだけ返す。
+type Workspace struct {
+	WorkspaceID string    `json:"workspace_id"`
+	Slug        string    `json:"slug"`
+	Name        string    `json:"name"`
+	JoinCode    string    `json:"join_code,omitempty"`
+	FamilySafe  bool      `json:"family_safe"` // メンバー全員を家族向けモードにする (familymode.go)
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// WorkspaceMember は workspace_members の1行
+type WorkspaceMember struct {
+	WorkspaceID string    `json:"workspace_id"`
+	UserID      string    `json:"user_id"`
+	Role        string    `json:"role"`
+	JoinedAt    time.Time `json:"joined_at"`
+}
+
+// WorkspaceInsult はワークスペースの督促文。CustomInsult と同じテンプレート変数が使える。
+type WorkspaceInsult struct {
+	InsultID    string    `json:"insult_id"`
+	WorkspaceID string    `json:"workspace_id"`
+	Text        string    `json:"text"`
+	CreatedBy   *string   `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (i WorkspaceInsult) template() insultTemplate {
+	return insultTemplate{Key: workspaceInsultPrefix + i.InsultID, Weight: 1, Text: i.Text}
+}
+
+func newJoinCode() (string, error) {
+	token, err := newFeedToken()
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(token[:10]), nil
+}
+
+func fetchWorkspace(workspaceID string) (*Workspace, error) {
+	resp, _, err := execute(supabaseClient.From("workspaces").Select("*", "", false).Eq("workspace_id", workspaceID))
+	if err != nil {
+		return nil, err
+	}
+	var rows []Workspace
+	if err := json.Unmarshal(resp, &rows); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errWorkspaceNotFound
+	}
+	return &rows[0], nil
+}
+
+// workspaceMembership はユーザーの所属 (どこにも入っていなければ nil)
+func workspaceMembership(userID string) (*WorkspaceMember, error) {
+	resp, _, err := execute(supabaseClient.From("workspace_members").Select("*", "", false).Eq("user_id", userID))
+	if err != nil {
+		return nil, err
+	}
+	var rows []WorkspaceMember
+	if err := json.Unmarshal(resp, &rows); err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	return &rows[0], nil
+}
+
+// requireWorkspaceRole は userID が workspaceID のメンバー (admin なら管理者) か確かめ、違えばエラーを書いて false を返す
+func requireWorkspaceRole(w http.ResponseWriter, workspaceID, userID string, admin bool) (*WorkspaceMember, bool) {
+	if userID == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return nil, false
+	}
+	m, err := workspaceMembership(userID)
+	if err != nil {
+		log.Printf("[ERROR] workspace membership lookup error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch membership: %v", err), http.StatusInternalServerError)
+		return nil, false
+	}
+	// 他のワークスペースの存在も漏らさないよう、メンバーでなければ 404 にする
+	if m == nil || m.WorkspaceID != workspaceID {
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+		return nil, false
+	}
+	if admin && m.Role != workspaceRoleAdmin {
+		http.Error(w, "Workspace admin only", http.StatusForbidden)
+		return nil, false
+	}
+	return m, true
+}
+
+func workspaceMembers(workspaceID string) ([]WorkspaceMember, error) {
+	resp, _, err := execute(supabaseClient.From("workspace_members").
+		Select("*", "", false).
+		Eq("workspace_id", workspaceID).
+		Order("joined_at", &postgrest.OrderOpts{Ascending: true}))
+	if err != nil {
+		return nil, err
+	}
+	var rows []WorkspaceMember
+	err = json.Unmarshal(resp, &rows)
+	return rows, err
+}
+
+// loadWorkspaceInsults は userIDs それぞれの所属するワークスペースの督促文を返す
+func loadWorkspaceInsults(userIDs []string) (map[string][]insultTemplate, error) {
+	result := make(map[string][]insultTemplate)
+	if len(userIDs) == 0 {
+		return result, nil
+	}
+	resp, _, err := execute(supabaseClient.From("workspace_members").Select("workspace_id, user_id", "", false).In("user_id", userIDs))
+	if err != nil {
+		return nil, err
+	}
+	var members []WorkspaceMember
+	if err := json.Unmarshal(resp, &members); err != nil || len(members) == 0 {
+		return result, err
+	}
+	var workspaceIDs []string
+	for _, m := range members {
+		if !slices.Contains(workspaceIDs, m.WorkspaceID) {
+			workspaceIDs = append(workspaceIDs, m.WorkspaceID)
+		}
+	}
+	iResp, _, err := execute(supabaseClient.From("workspace_insults").Select("insult_id, workspace_id, text", "", false).In("workspace_id", workspaceIDs))
+	if err != nil {
+		return nil, err
+	}
+	var insults []WorkspaceInsult
+	if err := json.Unmarshal(iResp, &insults); err != nil {
+		return nil, err
+	}
+	byWorkspace := make(map[string][]insultTemplate)
+	for _, i := range insults {
+		byWorkspace[i.WorkspaceID] = append(byWorkspace[i.WorkspaceID], i.template())
+	}
+	for _, m := range members {
+		result[m.UserID] = byWorkspace[m.WorkspaceID]
+	}
+	return result, nil
+}
+
+// handleAdminWorkspaces は /api/admin/workspaces。GET で一覧、POST {name, slug, admin_user_id} で作成する。
+func handleAdminWorkspaces(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		resp, _, err := execute(supabaseClient.From("workspaces").Select("*", "", false).Order("created_at", &postgrest.OrderOpts{Ascending: true}))
+		if err != nil {
+			log.Printf("[ERROR] handleAdminWorkspaces list error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch workspaces: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+
+	case http.MethodPost:
+		var req struct {
+			Name        string `json:"name"`
+			Slug        string `json:"slug"`
+			AdminUserID string `json:"admin_user_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" || req.AdminUserID == "" {
+			http.Error(w, "name and admin_user_id required", http.StatusBadRequest)
+			return
+		}
+		if !workspaceSlugPattern.MatchString(req.Slug) {
+			http.Error(w, "slug must be 2-40 lowercase letters, digits or hyphens", http.StatusBadRequest)
+			return
+		}
+		if m, err := workspaceMembership(req.AdminUserID); err != nil {
+			log.Printf("[ERROR] handleAdminWorkspaces membership error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to create workspace: %v", err), http.StatusInternalServerError)
+			return
+		} else if m != nil {
+			http.Error(w, "User already belongs to a workspace", http.StatusConflict)
+			return
+		}
+		code, err := newJoinCode()
+		if err != nil {
+			http.Error(w, "failed to generate join code", http.StatusInternalServerError)
+			return
+		}
+		rawResp, _, err := executeOnce(supabaseClient.From("workspaces").Insert(map[string]interface{}{
+			"name":      strings.TrimSpace(req.Name),
+			"slug":      req.Slug,
+			"join_code": code,
+		}, false, "", "", ""))
+		if err != nil {
+			log.Printf("[ERROR] handleAdminWorkspaces insert error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to create workspace (slug may be taken): %v", err), http.StatusConflict)
+			return
+		}
+		var rows []Workspace
+		if json.Unmarshal(rawResp, &rows); len(rows) == 0 {
+			http.Error(w, "failed to create workspace", http.StatusInternalServerError)
+			return
+		}
+		ws := rows[0]
+		if _, _, err := executeOnce(supabaseClient.From("workspace_members").Insert(map[string]interface{}{
+			"workspace_id": ws.WorkspaceID,
+			"user_id":      req.AdminUserID,
+			"role":         workspaceRoleAdmin,
+		}, false, "", "minimal", "")); err != nil {
+			log.Printf("[ERROR] handleAdminWorkspaces admin insert error: %v", err)
+			execute(supabaseClient.From("workspaces").Delete("minimal", "").Eq("workspace_id", ws.WorkspaceID))
+			http.Error(w, fmt.Sprintf("failed to add workspace admin: %v", err), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[INFO] workspace %s (%s) created with admin %s", ws.Slug, ws.WorkspaceID, req.AdminUserID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(ws)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleMyWorkspace は GET /api/workspaces/me?userId=...。所属するワークスペースと役割を返す (未所属なら workspace は null)。
+func handleMyWorkspace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userId := r.URL.Query().Get("userId")
+	if userId == "" {
+		http.Error(w, "userId required", http.StatusBadRequest)
+		return
+	}
+	m, err := workspaceMembership(userId)
+	if err != nil {
+		log.Printf("[ERROR] handleMyWorkspace membership error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch membership: %v", err), http.StatusInternalServerError)
+		return
+	}
+	result := map[string]interface{}{"workspace": nil, "role": nil}
+	if m != nil {
+		ws, err := fetchWorkspace(m.WorkspaceID)
+		if err != nil {
+			log.Printf("[ERROR] handleMyWorkspace workspace error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch workspace: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if m.Role != workspaceRoleAdmin {
+			ws.JoinCode = ""
+		}
+		result["workspace"], result["role"] = ws, m.Role
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleJoinWorkspace は POST /api/workspaces/join {user_id, code}。管理者から聞いた参加コードでメンバーになる。
+func handleJoinWorkspace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID string `json:"user_id"`
+		Code   string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" || req.Code == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if m, err := workspaceMembership(req.UserID); err != nil {
+		log.Printf("[ERROR] handleJoinWorkspace membership error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to join workspace: %v", err), http.StatusInternalServerError)
+		return
+	} else if m != nil {
+		http.Error(w, "User already belongs to a workspace", http.StatusConflict)
+		return
+	}
+	resp, _, err := execute(supabaseClient.From("workspaces").Select("*", "", false).Eq("join_code", strings.ToUpper(strings.TrimSpace(req.Code))))
+	if err != nil {
+		log.Printf("[ERROR] handleJoinWorkspace lookup error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to join workspace: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var rows []Workspace
+	if json.Unmarshal(resp, &rows); len(rows) == 0 {
+		http.Error(w, "Unknown join code", http.StatusNotFound)
+		return
+	}
+	ws := rows[0]
+	if _, _, err := executeOnce(supabaseClient.From("workspace_members").Insert(map[string]interface{}{
+		"workspace_id": ws.WorkspaceID,
+		"user_id":      req.UserID,
+		"role":         workspaceRoleMember,
+	}, false, "", "minimal", "")); err != nil {
+		log.Printf("[ERROR] handleJoinWorkspace insert error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to join workspace: %v", err), http.StatusInternalServerError)
+		return
+	}
+	appCache.Delete(familySafeCacheKey(req.UserID))
+	ws.JoinCode = ""
+	log.Printf("[INFO] user %s joined workspace %s", req.UserID, ws.WorkspaceID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"workspace": ws, "role": workspaceRoleMember})
+}
+
+// handleWorkspaceMembers は GET /api/workspaces/{id}/members?userId=...。メンバーなら誰でも見られる。
+func handleWorkspaceMembers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	workspaceID := r.PathValue("id")
+	if _, ok := requireWorkspaceRole(w, workspaceID, r.URL.Query().Get("userId"), false); !ok {
+		return
+	}
+	members, err := workspaceMembers(workspaceID)
+	if err != nil {
+		log.Printf("[ERROR] handleWorkspaceMembers error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch members: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// handleWorkspaceMember は /api/workspaces/{id}/members/{userId}?actorId=...。
+// PUT {role} は管理者が役割を変え、DELETE は管理者が外すか本人が抜ける。最後の管理者は外せない。
+func handleWorkspaceMember(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	workspaceID, targetID, actorID := r.PathValue("id"), r.PathValue("userId"), r.URL.Query().Get("actorId")
+	self := actorID == targetID
+	if _, ok := requireWorkspaceRole(w, workspaceID, actorID, !(self && r.Method == http.MethodDelete)); !ok {
+		return
+	}
+	members, err := workspaceMembers(workspaceID)
+	if err != nil {
+		log.Printf("[ERROR] handleWorkspaceMember members error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch members: %v", err), http.StatusInternalServerError)
+		return
+	}
+	i := slices.IndexFunc(members, func(m WorkspaceMember) bool { return m.UserID == targetID })
+	if i < 0 {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	admins := 0
+	for _, m := range members {
+		if m.Role == workspaceRoleAdmin {
+			admins++
+		}
+	}
+
+	var role string
+	if r.Method == http.MethodPut {
+		var req struct {
+			Role string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Role != workspaceRoleMember && req.Role != workspaceRoleAdmin) {
+			http.Error(w, "role must be member or admin", http.StatusBadRequest)
+			return
+		}
+		role = req.Role
+	}
+	if members[i].Role == workspaceRoleAdmin && role != workspaceRoleAdmin && admins == 1 {
+		http.Error(w, "Workspace needs at least one admin", http.StatusConflict)
+		return
+	}
+
+	q := supabaseClient.From("workspace_members")
+	if r.Method == http.MethodPut {
+		_, _, err = execute(q.Update(map[string]interface{}{"role": role}, "minimal", "").Eq("workspace_id", workspaceID).Eq("user_id", targetID))
+	} else {
+		_, _, err = execute(q.Delete("minimal", "").Eq("workspace_id", workspaceID).Eq("user_id", targetID))
+	}
+	if err != nil {
+		log.Printf("[ERROR] handleWorkspaceMember update error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to update member: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodDelete {
+		appCache.Delete(familySafeCacheKey(targetID))
+		json.NewEncoder(w).Encode(map[string]string{"message": "Member removed"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"message": "Member updated", "role": role})
+}
+
+// handleWorkspaceInsults は /api/workspaces/{id}/insults。
+// GET ?userId=... でメンバーが一覧を見て、POST {user_id, text} で管理者が追加する。
+func handleWorkspaceInsults(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.PathValue("id")
+	switch r.Method {
+	case http.MethodGet:
+		if _, ok := requireWorkspaceRole(w, workspaceID, r.URL.Query().Get("userId"), false); !ok {
+			return
+		}
+		resp, _, err := execute(supabaseClient.From("workspace_insults").
+			Select("*", "", false).
+			Eq("workspace_id", workspaceID).
+			Order("created_at", &postgrest.OrderOpts{Ascending: true}))
+		if err != nil {
+			log.Printf("[ERROR] handleWorkspaceInsults list error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to fetch insults: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+
+	case http.MethodPost:
+		var req struct {
+			UserID string `json:"user_id"`
+			Text   string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if _, ok := requireWorkspaceRole(w, workspaceID, req.UserID, true); !ok {
+			return
+		}
+		text, err := validateCustomInsult(r.Context(), req.Text)
+		if errors.Is(err, errModerationUnavailable) {
+			http.Error(w, "content check is temporarily unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, count, err := execute(supabaseClient.From("workspace_insults").Select("insult_id", "exact", true).Eq("workspace_id", workspaceID))
+		if err != nil {
+			log.Printf("[ERROR] handleWorkspaceInsults count error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to create insult: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if count >= maxWorkspaceInsults {
+			http.Error(w, fmt.Sprintf("too many workspace insults (max %d)", maxWorkspaceInsults), http.StatusConflict)
+			return
+		}
+		rawResp, _, err := executeOnce(supabaseClient.From("workspace_insults").Insert(map[string]interface{}{
+			"workspace_id": workspaceID,
+			"text":         text,
+			"created_by":   req.UserID,
+		}, false, "", "", ""))
+		if err != nil {
+			log.Printf("[ERROR] handleWorkspaceInsults insert error: %v", err)
+			http.Error(w, fmt.Sprintf("failed to create insult: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(rawResp)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleWorkspaceInsult は DELETE /api/workspaces/{id}/insults/{insultId}?userId=... (管理者のみ)
+func handleWorkspaceInsult(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	workspaceID := r.PathValue("id")
+	if _, ok := requireWorkspaceRole(w, workspaceID, r.URL.Query().Get("userId"), true); !ok {
+		return
+	}
+	rawResp, _, err := execute(supabaseClient.From("workspace_insults").
+		Delete("", "").
+		Eq("insult_id", r.PathValue("insultId")).
+		Eq("workspace_id", workspaceID))
+	if err != nil {
+		log.Printf("[ERROR] handleWorkspaceInsult delete error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to delete insult: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if string(rawResp) == "[]" {
+		http.Error(w, "Insult not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Insult deleted"})
+}
+
+// handleWorkspaceBooks は GET /api/workspaces/{id}/books?userId=... (管理者のみ)。メンバーのアーカイブしていない本を返す。
+func handleWorkspaceBooks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	workspaceID := r.PathValue("id")
+	if _, ok := requireWorkspaceRole(w, workspaceID, r.URL.Query().Get("userId"), true); !ok {
+		return
+	}
+	members, err := workspaceMembers(workspaceID)
+	if err != nil {
+		log.Printf("[ERROR] handleWorkspaceBooks members error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch members: %v", err), http.StatusInternalServerError)
+		return
+	}
+	userIDs := make([]string, 0, len(members))
+	for _, m := range members {
+		userIDs = append(userIDs, m.UserID)
+	}
+	resp, _, err := execute(supabaseClient.From("books").
+		Select("*", "", false).
+		In("user_id", userIDs).
+		Eq("archived", "false").
+		Order("deadline", &postgrest.OrderOpts{Ascending: true}))
+	if err != nil {
+		log.Printf("[ERROR] handleWorkspaceBooks query error: %v", err)
+		http.Error(w, fmt.Sprintf("failed to fetch books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
@@ -0,0 +1,136 @@
+package app
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// 日本語の書名・著者名の表記ゆれと読み (yomi)。
+// 保存時に半角カナ・全角英数を揃え、読みがあれば並べ替えと検索に使う。
+// 読みのない漢字の書名は読みが分からないので、並べ替えではかなの後ろに回す。
+
+// halfwidthKana は半角カタカナ (U+FF66〜U+FF9D) から全角カタカナへの対応
+var halfwidthKana = []rune("ヲァィゥェォャュョッーアイウエオカキクケコサシスセソタチツテトナニヌネノハヒフヘホマミムメモヤユヨラリルレロワン")
+
+// foldHalfwidthKana は半角カタカナを全角にし、濁点・半濁点を前の文字と合成する
+func foldHalfwidthKana(s string) string {
+	if !strings.ContainsFunc(s, func(r rune) bool { return r >= 0xFF61 && r <= 0xFF9F }) {
+		return s
+	}
+	out := make([]rune, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r >= 0xFF66 && r <= 0xFF9D:
+			out = append(out, halfwidthKana[r-0xFF66])
+		case r == 0xFF9E && len(out) > 0: // ﾞ
+			prev := out[len(out)-1]
+			switch {
+			case prev == 'ウ':
+				out[len(out)-1] = 'ヴ'
+			case strings.ContainsRune("カキクケコサシスセソタチツテトハヒフヘホ", prev):
+				out[len(out)-1] = prev + 1
+			default:
+				out = append(out, '゛')
+			}
+		case r == 0xFF9F && len(out) > 0: // ﾟ
+			if prev := out[len(out)-1]; strings.ContainsRune("ハヒフヘホ", prev) {
+				out[len(out)-1] = prev + 2
+			} else {
+				out = append(out, '゜')
+			}
+		case r == 0xFF61:
+			out = append(out, '。')
+		case r == 0xFF62:
+			out = append(out, '「')
+		case r == 0xFF63:
+			out = append(out, '」')
+		case r == 0xFF64:
+			out = append(out, '、')
+		case r == 0xFF65:
+			out = append(out, '・')
+		default:
+			out = append(out, r)
+		}
+	}
+	return string(out)
+}
+
+// normalizeBookText は保存する書名・著者名を揃える。
+// 半角カナは全角に、全角英数は半角にし、全角スペースと連続する空白を1つの半角スペースにする。
+func normalizeBookText(s string) string {
+	var b strings.Builder
+	for _, r := range foldHalfwidthKana(s) {
+		switch {
+		case r >= 0xFF10 && r <= 0xFF19, r >= 0xFF21 && r <= 0xFF3A, r >= 0xFF41 && r <= 0xFF5A: // 全角英数
+			r -= 0xFEE0
+		case r == 0x3000:
+			r = ' '
+		}
+		b.WriteRune(r)
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// normalizeYomi は読みをひらがなに揃え、空白と記号を除く
+func normalizeYomi(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			return -1
+		}
+		return r
+	}, normalizeSearchText(s))
+}
+
+// yomiField は読みが登録されていれば読みを、なければ表記そのものを正規化して返す
+func yomiField(text string, yomi *string) string {
+	if yomi != nil && *yomi != "" {
+		return normalizeYomi(*yomi)
+	}
+	return normalizeSearchText(text)
+}
+
+// yomiRank は並べ替えでの文字の種類の順 (英数 → かな → その他・漢字)
+func yomiRank(r rune) int {
+	switch {
+	case r < 0x80:
+		return 0
+	case r >= 0x3041 && r <= 0x309F, r == 'ー':
+		return 1
+	default:
+		return 2
+	}
+}
+
+// compareYomi はあいうえお順で比べる。ひらがなのコードポイント順は五十音順に沿っていて、
+// 清音と濁音 (か・が) が隣り合う。
+func compareYomi(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	for i := 0; i < len(ar) && i < len(br); i++ {
+		if c := cmp.Compare(yomiRank(ar[i]), yomiRank(br[i])); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(ar[i], br[i]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(ar), len(br))
+}
+
+// sortBooksByYomi は書名 (field == "title") か著者名 (field == "author") の読みで並べる
+func sortBooksByYomi(books []Book, field string) {
+	key := func(b Book) string {
+		if field == "author" {
+			return yomiField(b.Author, b.AuthorYomi)
+		}
+		return yomiField(b.Title, b.TitleYomi)
+	}
+	slices.SortStableFunc(books, func(a, b Book) int {
+		if c := compareYomi(key(a), key(b)); c != 0 {
+			return c
+		}
+		// 著者順では同じ著者の本を書名順に
+		return compareYomi(yomiField(a.Title, a.TitleYomi), yomiField(b.Title, b.TitleYomi))
+	})
+}
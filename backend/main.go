@@ -1,395 +1,9 @@
 package main
 
-import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"log"
-	"math/rand"
-	"net/http"
-	"os"
-	"time"
-
-	"github.com/supabase-community/supabase-go"
-)
-
-var supabaseClient *supabase.Client
-
-type LineAuthRequest struct {
-	LineAccessToken string `json:"lineAccessToken"`
-	LineUserID      string `json:"lineUserID"`
-}
-
-// Book は書籍データを表す構造体 (Supabase/PostgreSQL用)
-type Book struct {
-	BookID      string    `json:"book_id" db:"book_id"`
-	UserID      string    `json:"user_id" db:"user_id"`
-	Title       string    `json:"title" db:"title"`
-	Author      string    `json:"author" db:"author"`
-	Deadline    time.Time `json:"deadline" db:"deadline"`
-	Status      string    `json:"status" db:"status"`
-	InsultLevel int       `json:"insult_level" db:"insult_level"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
-}
+import "tundoku-killer/backend/internal/app"
 
+// API サーバー。処理の本体は internal/app にあり、運用者向けの CLI (cmd/tundokuctl) と共有する。
 func main() {
-	// Supabase クライアントの初期化
-	supabaseURL := os.Getenv("SUPABASE_URL")
-	supabaseKey := os.Getenv("SUPABASE_SERVICE_ROLE_KEY")
-
-	if supabaseURL == "" || supabaseKey == "" {
-		log.Fatalf("SUPABASE_URL and SUPABASE_SERVICE_ROLE_KEY environment variables must be set")
-	}
-
-	var err error
-	supabaseClient, err = supabase.NewClient(supabaseURL, supabaseKey, nil)
-	if err != nil {
-		log.Fatalf("cannot initialize supabase client: %v", err)
-	}
-
-	http.HandleFunc("/", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, "Hello from Backend (Supabase Edition)!")
-	}))
-
-	http.HandleFunc("/health", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintln(w, "OK")
-	}))
-
-	http.HandleFunc("/api/auth/line", corsMiddleware(handleLineAuth))
-	http.HandleFunc("/api/books", corsMiddleware(handleBooks))
-	http.HandleFunc("/api/books/complete", corsMiddleware(handleCompleteBook))
-	http.HandleFunc("/api/cron/check", corsMiddleware(handleCheckDeadlines))
-
-	rand.Seed(time.Now().UnixNano())
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8081"
-	}
-
-	fmt.Printf("Server starting on port %s...\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
-}
-
-func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("[INFO] %s %s", r.Method, r.URL.Path)
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		next(w, r)
-	}
-}
-
-func handleLineAuth(w http.ResponseWriter, r *http.Request) {
-	var req LineAuthRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		return
-	}
-
-	resp, _, err := supabaseClient.From("users").Select("*", "exact", false).Eq("line_user_id", req.LineUserID).Execute()
-	if err != nil {
-		log.Printf("[ERROR] handleLineAuth query error: %v", err)
-		http.Error(w, fmt.Sprintf("failed to query user: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	var results []map[string]interface{}
-	json.Unmarshal(resp, &results)
-	log.Printf("[DEBUG] Results from users table: %+v", results)
-
-	var internalID string
-	if len(results) == 0 {
-		newUser := map[string]interface{}{
-			"line_user_id": req.LineUserID,
-			"display_name": "LINE User",
-		}
-		log.Printf("[DEBUG] Creating new user: %+v", newUser)
-		insertResp, _, err := supabaseClient.From("users").Insert(newUser, false, "", "", "").Execute()
-		if err != nil {
-			log.Printf("[ERROR] handleLineAuth insert error: %v", err)
-			http.Error(w, fmt.Sprintf("failed to create user: %v", err), http.StatusInternalServerError)
-			return
-		}
-		var insertResults []map[string]interface{}
-		json.Unmarshal(insertResp, &insertResults)
-		log.Printf("[DEBUG] Insert results: %+v", insertResults)
-		if len(insertResults) > 0 {
-			internalID = insertResults[0]["id"].(string)
-		} else {
-			fResp, _, _ := supabaseClient.From("users").Select("id", "exact", false).Eq("line_user_id", req.LineUserID).Execute()
-			var fResults []map[string]interface{}
-			json.Unmarshal(fResp, &fResults)
-			log.Printf("[DEBUG] Fallback fetch results: %+v", fResults)
-			if len(fResults) > 0 {
-				internalID = fResults[0]["id"].(string)
-			}
-		}
-	} else {
-		internalID = results[0]["id"].(string)
-	}
-
-	log.Printf("[DEBUG] handleLineAuth returning internalID: %s for lineUserID: %s", internalID, req.LineUserID)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Auth successful",
-		"userId":  internalID,
-	})
-}
-
-func handleBooks(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		handleGetBooks(w, r)
-	case http.MethodPost:
-		handleRegisterBook(w, r)
-	case http.MethodPut:
-		handleUpdateBook(w, r)
-	case http.MethodDelete:
-		handleDeleteBook(w, r)
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	}
-}
-
-func handleGetBooks(w http.ResponseWriter, r *http.Request) {
-	userId := r.URL.Query().Get("userId")
-	if userId == "" {
-		http.Error(w, "userId required", http.StatusBadRequest)
-		return
-	}
-
-	resp, _, err := supabaseClient.From("books").Select("*", "exact", false).Eq("user_id", userId).Execute()
-	if err != nil {
-		log.Printf("[ERROR] handleGetBooks error: %v", err)
-		http.Error(w, fmt.Sprintf("failed to fetch books: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(resp)
-}
-
-func handleRegisterBook(w http.ResponseWriter, r *http.Request) {
-	var book Book
-	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
-		log.Printf("[ERROR] handleRegisterBook decode error: %v", err)
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		return
-	}
-
-	log.Printf("[DEBUG] handleRegisterBook received: %+v", book)
-
-	if book.Title == "" || book.Author == "" || book.UserID == "" {
-		log.Printf("[ERROR] handleRegisterBook missing fields: title=%s, author=%s, userId=%s", book.Title, book.Author, book.UserID)
-		http.Error(w, "Missing required fields", http.StatusBadRequest)
-		return
-	}
-
-	if book.Status == "" {
-		book.Status = "unread"
-	}
-
-	insertData := map[string]interface{}{
-		"user_id":      book.UserID,
-		"title":        book.Title,
-		"author":       book.Author,
-		"deadline":     book.Deadline,
-		"status":       book.Status,
-		"insult_level": book.InsultLevel,
-	}
-
-	rawResp, _, err := supabaseClient.From("books").Insert(insertData, false, "", "", "").Execute()
-	if err != nil {
-		log.Printf("[ERROR] handleRegisterBook database error: %v, body: %s", err, string(rawResp))
-		http.Error(w, fmt.Sprintf("failed to register book: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Book registered successfully"})
-}
-
-func handleUpdateBook(w http.ResponseWriter, r *http.Request) {
-	var book Book
-	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		return
-	}
-
-	updateData := map[string]interface{}{
-		"title":        book.Title,
-		"author":       book.Author,
-		"deadline":     book.Deadline,
-		"status":       book.Status,
-		"insult_level": book.InsultLevel,
-		"updated_at":   time.Now(),
-	}
-
-	_, _, err := supabaseClient.From("books").Update(updateData, "", "").Eq("book_id", book.BookID).Eq("user_id", book.UserID).Execute()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to update book: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Book updated successfully"})
-}
-
-func handleDeleteBook(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		BookID string `json:"book_id"`
-		UserID string `json:"user_id"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("[ERROR] handleDeleteBook decode error: %v", err)
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		return
-	}
-	log.Printf("[DEBUG] handleDeleteBook received: %+v", req)
-
-	rawResp, _, err := supabaseClient.From("books").Delete("", "").Eq("book_id", req.BookID).Eq("user_id", req.UserID).Execute()
-	if err != nil {
-		log.Printf("[ERROR] handleDeleteBook database error: %v, body: %s", err, string(rawResp))
-		http.Error(w, fmt.Sprintf("failed to delete book: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Book deleted successfully"})
-}
-
-func handleCompleteBook(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		BookID string `json:"book_id"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("[ERROR] handleCompleteBook decode error: %v", err)
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		return
-	}
-	log.Printf("[DEBUG] handleCompleteBook received: %+v", req)
-
-	rawResp, _, err := supabaseClient.From("books").Update(map[string]interface{}{"status": "completed", "updated_at": time.Now()}, "", "").Eq("book_id", req.BookID).Execute()
-	if err != nil {
-		log.Printf("[ERROR] handleCompleteBook database error: %v, body: %s", err, string(rawResp))
-		http.Error(w, fmt.Sprintf("failed to complete book: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Book marked as completed"})
-}
-
-func handleCheckDeadlines(w http.ResponseWriter, r *http.Request) {
-	cronSecret := os.Getenv("CRON_SECRET")
-	if cronSecret != "" && r.Header.Get("Authorization") != "Bearer "+cronSecret {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	resp, _, err := supabaseClient.From("books").
-		Select("*", "exact", false).
-		In("status", []string{"unread", "insulted"}).
-		Lt("deadline", time.Now().Format(time.RFC3339)).
-		Execute()
-	if err != nil {
-		log.Printf("[ERROR] handleCheckDeadlines query error: %v", err)
-		http.Error(w, fmt.Sprintf("database error: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	log.Printf("[DEBUG] handleCheckDeadlines raw response: %s", string(resp))
-
-	var books []Book
-	if err := json.Unmarshal(resp, &books); err != nil {
-		log.Printf("[ERROR] handleCheckDeadlines unmarshal error: %v", err)
-	}
-	log.Printf("[DEBUG] handleCheckDeadlines found %d books in unmarshaled slice", len(books))
-
-	count := 0
-	for _, book := range books {
-		log.Printf("[DEBUG] Processing book: %s (ID: %s) for UserID: %s", book.Title, book.BookID, book.UserID)
-		insultMsg, _ := generateInsult(book)
-
-		uResp, _, err := supabaseClient.From("users").Select("line_user_id", "exact", false).Eq("id", book.UserID).Execute()
-		if err != nil {
-			log.Printf("[ERROR] Failed to fetch user %s: %v", book.UserID, err)
-			continue
-		}
-
-		var users []map[string]interface{}
-		json.Unmarshal(uResp, &users)
-		log.Printf("[DEBUG] User query result for %s: %+v", book.UserID, users)
-
-		if len(users) > 0 {
-			lineUserID := users[0]["line_user_id"].(string)
-			log.Printf("[DEBUG] Sending LINE message to %s: %s", lineUserID, insultMsg)
-			if err := sendLineMessage(lineUserID, insultMsg); err == nil {
-				log.Printf("[DEBUG] Message sent. Updating book %s to status 'insulted'", book.BookID)
-				supabaseClient.From("books").Update(map[string]interface{}{"status": "insulted"}, "", "").Eq("book_id", book.BookID).Execute()
-				count++
-			} else {
-				log.Printf("[ERROR] Failed to send LINE message: %v", err)
-			}
-		} else {
-			log.Printf("[WARNING] User %s not found in users table", book.UserID)
-		}
-	}
-
-	log.Printf("[INFO] handleCheckDeadlines completed. Found %d books, processed %d success messages.", len(books), count)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": fmt.Sprintf("Checked deadlines. Found %d expired books.", count)})
-}
-
-func generateInsult(book Book) (string, error) {
-	insultMessages := []string{
-		"その本、まだ読んでないんですか？時間の無駄ですね。",
-		"積読ですか。残念ですね。その本は二度と読まれないでしょう。",
-		"知識は鮮度が命。その本はもう腐っています。",
-		fmt.Sprintf("「%s」を読むというタスクは、あなたの優先順位リストに存在しないようですね。", book.Title),
-		"あなたの本棚、もはや墓場ですね。未完の志が眠る場所。",
-	}
-	randomIndex := rand.Intn(len(insultMessages))
-	return insultMessages[randomIndex], nil
-}
-
-func sendLineMessage(lineUserID, message string) error {
-	accessToken := os.Getenv("LINE_CHANNEL_ACCESS_TOKEN")
-	if accessToken == "" {
-		return fmt.Errorf("LINE_CHANNEL_ACCESS_TOKEN is not set")
-	}
-
-	url := "https://api.line.me/v2/bot/message/push"
-	requestBody, _ := json.Marshal(map[string]interface{}{
-		"to": lineUserID,
-		"messages": []interface{}{
-			map[string]interface{}{"type": "text", "text": message},
-		},
-	})
-
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-
-	resp, err := (&http.Client{}).Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("LINE API error")
-	}
-	return nil
+	app.Init()
+	app.Serve(frontendHandler())
 }
@@ -2,6 +2,7 @@ import { useState, useEffect } from "react";
 import type { FormEvent } from "react";
 import liff from "@line/liff";
 import LineAddFriendButton from './components/LineAddFriendButton';
+import AnnouncementBanner from './components/AnnouncementBanner';
 
 interface LineUserProfile {
     userId: string;
@@ -20,7 +21,7 @@ interface Book {
     book_id: string;
 }
 
-const BACKEND_URL = import.meta.env.VITE_BACKEND_URL || "https://tundoku-killer.onrender.com"; // 必要に応じて環境変数化
+const BACKEND_URL = import.meta.env.VITE_BACKEND_URL ?? "https://tundoku-killer.onrender.com"; // 空文字なら同じオリジン (バックエンドに同梱した場合)
 
 function App() {
     const [isLoggedIn, setIsLoggedIn] = useState(false);
@@ -217,12 +218,18 @@ function App() {
     }
 
     const completedBooks = books.filter(b => b.status === "completed");
-    const unreadBooks = books.filter(b => b.status !== "completed");
+    // 督促のまとめ通知 (ワースト5) の「全部見る」から開いたときは期限切れの本だけを古い順に出す
+    const overdueOnly = new URLSearchParams(window.location.search).get("filter") === "overdue";
+    const unreadBooks = books
+        .filter(b => b.status !== "completed")
+        .filter(b => !overdueOnly || new Date(b.deadline) < new Date())
+        .sort((a, b) => overdueOnly ? new Date(a.deadline).getTime() - new Date(b.deadline).getTime() : 0);
 
     return (
         <div className="min-h-screen flex flex-col items-center justify-center p-4 bg-gradient-to-br from-pink-400 via-purple-500 to-indigo-600 text-white">
             <h1 className="text-5xl md:text-6xl font-black text-transparent bg-clip-text bg-gradient-to-r from-yellow-100 via-pink-200 to-purple-300 mb-8 drop-shadow-lg animate-pulse">ツンドク・キラー🔥</h1>
             <div className="mb-8"><LineAddFriendButton lineId="@566nverw" /></div>
+            <AnnouncementBanner backendUrl={BACKEND_URL} />
 
             {isLoggedIn && supabaseUser ? (
                 <div className="bg-pink-700 p-8 rounded-xl shadow-lg drop-shadow-md w-full max-w-md border-2 border-pink-300 transform transition-transform duration-300" style={{ boxShadow: '0 0 10px #ff00ff, 0 0 20px #ff00ff, 0 0 30px #ff00ff' }}>
@@ -263,7 +270,7 @@ function App() {
                     </form>
 
                     <div className="mt-10 p-6 bg-pink-700 rounded-xl shadow-lg drop-shadow-md border-2 border-pink-300">
-                        <h2 className="text-3xl font-black text-pink-200 mb-6 text-center drop-shadow-md">💖未読・読書中の本💖</h2>
+                        <h2 className="text-3xl font-black text-pink-200 mb-6 text-center drop-shadow-md">{overdueOnly ? "🔥期限切れの本🔥" : "💖未読・読書中の本💖"}</h2>
                         {unreadBooks.length > 0 ? (
                             <ul className="space-y-6">
                                 {unreadBooks.map((book) => (
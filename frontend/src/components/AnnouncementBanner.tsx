@@ -0,0 +1,61 @@
+import { useEffect, useState } from 'react';
+
+interface Announcement {
+  announcement_id: string;
+  severity: 'info' | 'warning' | 'critical';
+  locale: string;
+  text: string;
+  starts_at: string;
+  ends_at: string;
+}
+
+// メンテナンスや新機能のお知らせを5分ごとに取りにいくよ
+const POLL_INTERVAL_MS = 5 * 60 * 1000;
+
+const severityStyles: Record<Announcement['severity'], string> = {
+  info: 'bg-indigo-700 border-indigo-300',
+  warning: 'bg-yellow-600 border-yellow-200',
+  critical: 'bg-red-700 border-red-300',
+};
+
+const AnnouncementBanner = ({ backendUrl }: { backendUrl: string }) => {
+  const [announcements, setAnnouncements] = useState<Announcement[]>([]);
+  // 閉じたお知らせはこのタブの間だけ隠す
+  const [dismissed, setDismissed] = useState<string[]>([]);
+
+  useEffect(() => {
+    const load = async () => {
+      try {
+        const response = await fetch(`${backendUrl}/api/announcements?locale=${encodeURIComponent(navigator.language)}`);
+        if (response.ok) {
+          setAnnouncements(await response.json());
+        }
+      } catch (e) {
+        // お知らせが取れなくても画面は使えるので黙っておく
+        console.error('お知らせの取得に失敗したよ🥺', e);
+      }
+    };
+    load();
+    const timer = setInterval(load, POLL_INTERVAL_MS);
+    return () => clearInterval(timer);
+  }, [backendUrl]);
+
+  const visible = announcements.filter(a => !dismissed.includes(a.announcement_id));
+  if (visible.length === 0) {
+    return null;
+  }
+  return (
+    <div className="w-full max-w-md mb-6 space-y-2">
+      {visible.map(a => (
+        <div key={a.announcement_id} role={a.severity === 'critical' ? 'alert' : 'status'} className={`flex items-start gap-3 p-3 rounded-lg border-2 shadow-lg text-sm font-bold ${severityStyles[a.severity] ?? severityStyles.info}`}>
+          <p className="flex-1 whitespace-pre-wrap">{a.text}</p>
+          {a.severity !== 'critical' && (
+            <button type="button" aria-label="閉じる" onClick={() => setDismissed([...dismissed, a.announcement_id])} className="text-white/80 hover:text-white">✕</button>
+          )}
+        </div>
+      ))}
+    </div>
+  );
+};
+
+export default AnnouncementBanner;
@@ -0,0 +1,74 @@
+-- Row Level Security policies for SUPABASE_RLS_MODE=true.
+-- schema.sql creates permissive "Enable all" policies because the backend historically used the
+-- service-role key for everything. In RLS mode the anon key is handed to clients and /api/books is called
+-- with the end user's Supabase JWT, so every permissive policy is replaced here. Apply after schema.sql
+-- (and again after schema.sql adds a table). The service role bypasses RLS, so cron, admin and webhook
+-- paths keep working unchanged; API routes that still run on the service role are closed in RLS mode (rls.go).
+
+-- Maps auth.uid() (Supabase Auth) to users.id
+CREATE OR REPLACE FUNCTION current_app_user_id() RETURNS UUID
+LANGUAGE sql STABLE SECURITY DEFINER SET search_path = public AS $$
+    SELECT id FROM users WHERE auth_user_id = auth.uid()
+$$;
+
+-- Drop the permissive policies from schema.sql on every table, including ones added later.
+-- The users policies compare auth.uid() with users.id, which never matches since auth_user_id was added.
+DO $$
+DECLARE
+    p RECORD;
+BEGIN
+    FOR p IN
+        SELECT schemaname, tablename, policyname FROM pg_policies
+        WHERE schemaname = 'public'
+            AND (policyname LIKE 'Enable all for %'
+                OR policyname IN ('Users can view own data', 'Users can update own data', 'Users can insert own data'))
+    LOOP
+        EXECUTE format('DROP POLICY IF EXISTS %I ON %I.%I', p.policyname, p.schemaname, p.tablename);
+    END LOOP;
+END;
+$$;
+
+DROP POLICY IF EXISTS "Users read own row" ON users;
+CREATE POLICY "Users read own row" ON users FOR SELECT TO authenticated
+    USING (auth_user_id = auth.uid());
+
+-- Replaced by "Users manage own rows" below
+DROP POLICY IF EXISTS "Users manage own books" ON books;
+
+-- Tables the user edits directly: full access to their own rows
+DO $$
+DECLARE
+    t TEXT;
+BEGIN
+    FOREACH t IN ARRAY ARRAY[
+        'books', 'book_notes', 'book_milestones', 'book_attachments', 'reading_sessions', 'progress_logs',
+        'import_drafts', 'projects', 'reading_buddies', 'user_onboarding'
+    ] LOOP
+        EXECUTE format('DROP POLICY IF EXISTS "Users manage own rows" ON %I', t);
+        EXECUTE format('CREATE POLICY "Users manage own rows" ON %I FOR ALL TO authenticated
+            USING (user_id = current_app_user_id()) WITH CHECK (user_id = current_app_user_id())', t);
+    END LOOP;
+END;
+$$;
+
+-- Tables the server writes (streaks, leaderboards, stakes, moderated insults, queued messages): the user may only read their own rows
+DO $$
+DECLARE
+    t TEXT;
+BEGIN
+    FOREACH t IN ARRAY ARRAY[
+        'book_completions', 'user_achievements', 'book_stakes', 'custom_insults', 'notification_jobs', 'data_requests',
+        'challenge_participants', 'group_shame_members', 'workspace_members', 'book_club_votes'
+    ] LOOP
+        EXECUTE format('DROP POLICY IF EXISTS "Users read own rows" ON %I', t);
+        EXECUTE format('CREATE POLICY "Users read own rows" ON %I FOR SELECT TO authenticated
+            USING (user_id = current_app_user_id())', t);
+    END LOOP;
+END;
+$$;
+
+-- No policy, so only the service role can reach these:
+--   credentials: user_sessions, personal_access_tokens, notion_connections (token hashes and Notion access tokens)
+--   operator data: audit_log, support_access_log, api_usage, line_activity, line_webhook_events, sandbox_inbox,
+--     admin_broadcasts, feature_flags, line_rich_menus, announcements, books_catalog
+--   shared rows read through the API: challenges, workspaces, workspace_insults, book_club_polls, book_club_candidates
@@ -41,3 +41,1099 @@ CREATE POLICY "Enable all for users" ON users FOR ALL USING (true) WITH CHECK (t
 CREATE INDEX IF NOT EXISTS idx_books_user_id ON books(user_id);
 CREATE INDEX IF NOT EXISTS idx_books_status ON books(status);
 CREATE INDEX IF NOT EXISTS idx_books_deadline ON books(deadline);
+
+-- Create Notification jobs table (cron が積み、ワーカーが送信する)
+CREATE TABLE IF NOT EXISTS notification_jobs (
+    job_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    book_id UUID REFERENCES books(book_id) ON DELETE CASCADE NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    line_user_id TEXT NOT NULL,
+    message TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending', -- 'pending', 'processing', 'sent', 'failed'
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    run_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    locked_at TIMESTAMP WITH TIME ZONE,
+    sent_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE notification_jobs ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for notification_jobs" ON notification_jobs FOR ALL USING (true) WITH CHECK (true);
+
+CREATE INDEX IF NOT EXISTS idx_notification_jobs_status_run_at ON notification_jobs(status, run_at);
+CREATE INDEX IF NOT EXISTS idx_notification_jobs_book_id ON notification_jobs(book_id);
+
+-- Streak columns on users (期限内読了の連続数)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS current_streak INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS longest_streak INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_completed_at TIMESTAMP WITH TIME ZONE;
+
+-- Create Book completions table (読了記録)
+CREATE TABLE IF NOT EXISTS book_completions (
+    completion_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    book_id UUID REFERENCES books(book_id) ON DELETE CASCADE NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    completed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    deadline TIMESTAMP WITH TIME ZONE NOT NULL,
+    days_early INTEGER NOT NULL, -- 負の値は期限超過日数
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE book_completions ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for book_completions" ON book_completions FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_book_completions_user_id ON book_completions(user_id);
+
+-- Create User achievements table
+CREATE TABLE IF NOT EXISTS user_achievements (
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    code TEXT NOT NULL,
+    unlocked_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, code)
+);
+
+ALTER TABLE user_achievements ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for user_achievements" ON user_achievements FOR ALL USING (true) WITH CHECK (true);
+
+-- Create Reading sessions table (読書タイマー)
+CREATE TABLE IF NOT EXISTS reading_sessions (
+    session_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    book_id UUID REFERENCES books(book_id) ON DELETE CASCADE NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    ended_at TIMESTAMP WITH TIME ZONE,
+    duration_seconds INTEGER NOT NULL DEFAULT 0,
+    pages INTEGER NOT NULL DEFAULT 0
+);
+
+ALTER TABLE reading_sessions ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for reading_sessions" ON reading_sessions FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_reading_sessions_book_id ON reading_sessions(book_id);
+CREATE INDEX IF NOT EXISTS idx_reading_sessions_user_id ON reading_sessions(user_id);
+
+-- Create Book notes table (メモ・引用)
+CREATE TABLE IF NOT EXISTS book_notes (
+    note_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    book_id UUID REFERENCES books(book_id) ON DELETE CASCADE NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    kind TEXT NOT NULL DEFAULT 'note', -- 'note', 'quote'
+    content TEXT NOT NULL,
+    page INTEGER,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE book_notes ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for book_notes" ON book_notes FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_book_notes_book_id ON book_notes(book_id);
+
+-- Ratings and reviews (読了後のみ設定可能)
+ALTER TABLE books ADD COLUMN IF NOT EXISTS rating INTEGER CHECK (rating BETWEEN 1 AND 5);
+ALTER TABLE books ADD COLUMN IF NOT EXISTS review TEXT;
+
+-- Notification job kinds ('insult', 'review_nudge')
+ALTER TABLE notification_jobs ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'insult';
+
+-- Reading order ("次に読む" キュー)
+ALTER TABLE books ADD COLUMN IF NOT EXISTS sort_order INTEGER;
+CREATE INDEX IF NOT EXISTS idx_books_user_sort_order ON books(user_id, sort_order);
+
+-- Digest jobs are not tied to a single book
+ALTER TABLE notification_jobs ALTER COLUMN book_id DROP NOT NULL;
+
+-- Book format and progress (オーディオブックは page_count の代わりに duration_minutes)
+ALTER TABLE books ADD COLUMN IF NOT EXISTS format TEXT NOT NULL DEFAULT 'paperback'; -- 'paperback', 'hardcover', 'ebook', 'audiobook'
+ALTER TABLE books ADD COLUMN IF NOT EXISTS page_count INTEGER CHECK (page_count > 0);
+ALTER TABLE books ADD COLUMN IF NOT EXISTS duration_minutes INTEGER CHECK (duration_minutes > 0);
+ALTER TABLE books ADD COLUMN IF NOT EXISTS progress INTEGER NOT NULL DEFAULT 0; -- pages read, or minutes listened for audiobooks
+
+-- Series grouping (シリーズ名 + 巻数)
+ALTER TABLE books ADD COLUMN IF NOT EXISTS series TEXT;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS volume INTEGER CHECK (volume > 0);
+CREATE INDEX IF NOT EXISTS idx_books_user_series ON books(user_id, series);
+
+-- Jobs covering several books (シリーズまとめ督促)
+ALTER TABLE notification_jobs ADD COLUMN IF NOT EXISTS book_ids UUID[];
+CREATE INDEX IF NOT EXISTS idx_notification_jobs_user_id ON notification_jobs(user_id);
+
+-- Wishlist items have no deadline until purchased ('wishlist' status)
+ALTER TABLE books ALTER COLUMN deadline DROP NOT NULL;
+
+-- Create Book milestones table (章ごとの中間目標)
+CREATE TABLE IF NOT EXISTS book_milestones (
+    milestone_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    book_id UUID REFERENCES books(book_id) ON DELETE CASCADE NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    title TEXT NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    target_date TIMESTAMP WITH TIME ZONE NOT NULL,
+    completed BOOLEAN NOT NULL DEFAULT FALSE,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    reminded_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE book_milestones ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for book_milestones" ON book_milestones FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_book_milestones_book_id ON book_milestones(book_id);
+CREATE INDEX IF NOT EXISTS idx_book_milestones_due ON book_milestones(target_date) WHERE completed = FALSE;
+
+ALTER TABLE notification_jobs ADD COLUMN IF NOT EXISTS milestone_id UUID REFERENCES book_milestones(milestone_id) ON DELETE CASCADE;
+
+-- Abandon / DNF ('abandoned' status)
+ALTER TABLE books ADD COLUMN IF NOT EXISTS abandon_reason TEXT;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS abandoned_at TIMESTAMP WITH TIME ZONE;
+
+-- Re-read cycles (前の回の記録は book_completions に残す)
+ALTER TABLE books ADD COLUMN IF NOT EXISTS read_cycle INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS read_started_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE book_completions ADD COLUMN IF NOT EXISTS read_cycle INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE book_completions ADD COLUMN IF NOT EXISTS started_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE reading_sessions ADD COLUMN IF NOT EXISTS read_cycle INTEGER NOT NULL DEFAULT 1;
+
+-- Notion sync
+CREATE TABLE IF NOT EXISTS notion_connections (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    access_token TEXT NOT NULL,
+    database_id TEXT NOT NULL,
+    field_mapping JSONB NOT NULL DEFAULT '{}'::jsonb,
+    last_synced_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE notion_connections ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for notion_connections" ON notion_connections FOR ALL USING (true) WITH CHECK (true);
+
+ALTER TABLE books ADD COLUMN IF NOT EXISTS notion_page_id TEXT;
+CREATE INDEX IF NOT EXISTS idx_books_notion_page_id ON books(notion_page_id);
+
+-- Completed books feed (/api/feeds/{token}/completed.xml)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS feed_token TEXT UNIQUE;
+
+-- Public profile (opt-in)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS profile_public BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS public_slug TEXT UNIQUE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS show_overdue BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Flex Message payload (monthly_report)
+ALTER TABLE notification_jobs ADD COLUMN IF NOT EXISTS payload JSONB;
+
+-- Progress history (activity heatmap)
+CREATE TABLE IF NOT EXISTS progress_logs (
+    log_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    book_id UUID REFERENCES books(book_id) ON DELETE CASCADE NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    progress INTEGER NOT NULL,
+    logged_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE progress_logs ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for progress_logs" ON progress_logs FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_progress_logs_user_logged_at ON progress_logs(user_id, logged_at);
+
+-- 日ごとの読了数と進捗記録数 (読書タイマーの終了も進捗として数える)。日付は JST で区切る。
+CREATE OR REPLACE FUNCTION activity_heatmap(p_user_id UUID, p_since TIMESTAMP WITH TIME ZONE)
+RETURNS TABLE (day DATE, completions BIGINT, progress_updates BIGINT)
+LANGUAGE sql STABLE AS $$
+    SELECT a.day,
+           COUNT(*) FILTER (WHERE a.kind = 'completion'),
+           COUNT(*) FILTER (WHERE a.kind = 'progress')
+    FROM (
+        SELECT (completed_at AT TIME ZONE 'Asia/Tokyo')::date AS day, 'completion' AS kind
+        FROM book_completions WHERE user_id = p_user_id AND completed_at >= p_since
+        UNION ALL
+        SELECT (logged_at AT TIME ZONE 'Asia/Tokyo')::date, 'progress'
+        FROM progress_logs WHERE user_id = p_user_id AND logged_at >= p_since
+        UNION ALL
+        SELECT (ended_at AT TIME ZONE 'Asia/Tokyo')::date, 'progress'
+        FROM reading_sessions WHERE user_id = p_user_id AND ended_at >= p_since
+    ) a
+    GROUP BY a.day
+    ORDER BY a.day;
+$$;
+
+-- Tags (genre statistics)
+ALTER TABLE books ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+CREATE INDEX IF NOT EXISTS idx_books_tags ON books USING GIN (tags);
+
+-- Insult effectiveness analytics
+ALTER TABLE notification_jobs ADD COLUMN IF NOT EXISTS template TEXT;
+ALTER TABLE notification_jobs ADD COLUMN IF NOT EXISTS insult_level INTEGER;
+CREATE INDEX IF NOT EXISTS idx_book_completions_completed_at ON book_completions(completed_at);
+
+-- User-submitted insult phrases
+CREATE TABLE IF NOT EXISTS custom_insults (
+    insult_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    text TEXT NOT NULL,
+    flagged BOOLEAN NOT NULL DEFAULT FALSE,
+    flag_reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE custom_insults ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for custom_insults" ON custom_insults FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_custom_insults_user_id ON custom_insults(user_id);
+
+-- Insult tone presets ('standard', 'polite', 'kansai', 'sadistic', 'corporate', 'gentle'); NULL falls back to the user's tone
+ALTER TABLE users ADD COLUMN IF NOT EXISTS insult_tone TEXT;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS insult_tone TEXT;
+
+-- Book price in yen (message variable {{.MoneyWasted}})
+ALTER TABLE books ADD COLUMN IF NOT EXISTS price INTEGER CHECK (price >= 0);
+
+-- LINE rich menus ('linked', 'unlinked')
+CREATE TABLE IF NOT EXISTS line_rich_menus (
+    kind TEXT PRIMARY KEY,
+    rich_menu_id TEXT NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE line_rich_menus ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for line_rich_menus" ON line_rich_menus FOR ALL USING (true) WITH CHECK (true);
+
+-- Backend sessions issued after LIFF ID token verification (only the SHA-256 of the token is stored)
+CREATE TABLE IF NOT EXISTS user_sessions (
+    session_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    user_agent TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+ALTER TABLE user_sessions ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for user_sessions" ON user_sessions FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_user_sessions_user_id ON user_sessions(user_id);
+
+-- Set on LINE unfollow (blocked); pushes are skipped until the user follows again
+ALTER TABLE users ADD COLUMN IF NOT EXISTS line_blocked_at TIMESTAMP WITH TIME ZONE;
+
+-- Admin announcements
+ALTER TABLE users ADD COLUMN IF NOT EXISTS announcements_opt_in BOOLEAN NOT NULL DEFAULT TRUE;
+
+CREATE TABLE IF NOT EXISTS admin_broadcasts (
+    broadcast_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message TEXT NOT NULL,
+    mode TEXT NOT NULL, -- 'broadcast', 'multicast'
+    filter JSONB,
+    recipients INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE admin_broadcasts ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for admin_broadcasts" ON admin_broadcasts FOR ALL USING (true) WITH CHECK (true);
+
+-- Sticker sent after the text ("packageId:stickerId")
+ALTER TABLE notification_jobs ADD COLUMN IF NOT EXISTS sticker TEXT;
+
+-- Group shame mode: users who agreed (in the group) to have overdue books posted there
+CREATE TABLE IF NOT EXISTS group_shame_members (
+    group_id TEXT NOT NULL, -- LINE groupId
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    display_name TEXT,
+    consented_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (group_id, user_id)
+);
+
+ALTER TABLE group_shame_members ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for group_shame_members" ON group_shame_members FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_group_shame_members_user_id ON group_shame_members(user_id);
+
+-- Supabase Auth (email/password, OAuth) as an alternative to LINE login; web-only users have no LINE account
+ALTER TABLE users ADD COLUMN IF NOT EXISTS auth_user_id UUID UNIQUE;
+ALTER TABLE users ALTER COLUMN line_user_id DROP NOT NULL;
+
+-- Multiple LINE channels (LINE_CHANNELS); NULL means the default channel
+ALTER TABLE users ADD COLUMN IF NOT EXISTS line_channel TEXT;
+ALTER TABLE group_shame_members ADD COLUMN IF NOT EXISTS line_channel TEXT;
+
+-- Feature flags (kill switch, per-user and percentage rollouts)
+CREATE TABLE IF NOT EXISTS feature_flags (
+    key TEXT PRIMARY KEY,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    rollout_percent INTEGER NOT NULL DEFAULT 0 CHECK (rollout_percent BETWEEN 0 AND 100),
+    user_ids UUID[] NOT NULL DEFAULT '{}',
+    description TEXT,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE feature_flags ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for feature_flags" ON feature_flags FOR ALL USING (true) WITH CHECK (true);
+
+-- Per-user daily API usage (calls per endpoint; rejected = requests refused by the quota)
+CREATE TABLE IF NOT EXISTS api_usage (
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    endpoint TEXT NOT NULL,
+    calls INTEGER NOT NULL DEFAULT 0,
+    rejected INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, day, endpoint)
+);
+
+ALTER TABLE api_usage ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for api_usage" ON api_usage FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_api_usage_day ON api_usage(day);
+
+-- Audit log of destructive actions; payload holds the previous state for POST /api/actions/{id}/undo
+CREATE TABLE IF NOT EXISTS audit_log (
+    action_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    action TEXT NOT NULL, -- book.delete, book.complete, books.bulk_status, user.merge
+    payload JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    undone_at TIMESTAMP WITH TIME ZONE
+);
+
+ALTER TABLE audit_log ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for audit_log" ON audit_log FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_audit_log_user_id ON audit_log(user_id, created_at);
+
+-- Personal data package requests (POST /api/users/me/data-request); archives live in the private STORAGE_BUCKET
+CREATE TABLE IF NOT EXISTS data_requests (
+    request_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    status TEXT NOT NULL DEFAULT 'processing', -- processing, ready, failed
+    storage_path TEXT,
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    completed_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE
+);
+
+ALTER TABLE data_requests ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for data_requests" ON data_requests FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_data_requests_user_id ON data_requests(user_id);
+
+-- Status timestamps: started_at (first moved to 'reading' in the current cycle) and completed_at.
+-- Status changes come from many endpoints (update, bulk, complete, re-read, undo, import), so a trigger keeps them consistent.
+ALTER TABLE books ADD COLUMN IF NOT EXISTS started_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS completed_at TIMESTAMP WITH TIME ZONE;
+
+CREATE OR REPLACE FUNCTION books_status_timestamps() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND NEW.read_cycle IS DISTINCT FROM OLD.read_cycle THEN
+        NEW.started_at := NULL; -- re-read: a new cycle starts from scratch
+    END IF;
+    IF NEW.status = 'reading' AND NEW.started_at IS NULL THEN
+        NEW.started_at := NOW();
+    END IF;
+    IF NEW.status = 'completed' THEN
+        IF TG_OP = 'INSERT' OR OLD.status IS DISTINCT FROM 'completed' THEN
+            NEW.completed_at := COALESCE(NEW.completed_at, NOW());
+        END IF;
+    ELSE
+        NEW.completed_at := NULL; -- undo / re-read
+    END IF;
+    RETURN NEW;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS books_status_timestamps ON books;
+CREATE TRIGGER books_status_timestamps BEFORE INSERT OR UPDATE OF status, read_cycle ON books
+    FOR EACH ROW EXECUTE FUNCTION books_status_timestamps();
+
+UPDATE books b SET completed_at = c.completed_at
+FROM (SELECT book_id, MAX(completed_at) AS completed_at FROM book_completions GROUP BY book_id) c
+WHERE b.book_id = c.book_id AND b.status = 'completed' AND b.completed_at IS NULL;
+
+-- Archive (POST /api/books/{id}/archive): kept as history but hidden from listings, stats counts and the deadline cron
+ALTER TABLE books ADD COLUMN IF NOT EXISTS archived BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS idx_books_active_deadline ON books(deadline) WHERE NOT archived;
+
+-- Shelf location ("room/shelf/box"); filtered by hierarchy prefix and looked up from LINE with 「〇〇 どこ」
+ALTER TABLE books ADD COLUMN IF NOT EXISTS location TEXT;
+
+-- Reading pace learned from sessions, progress updates and completions (NULL until there is history)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS reading_pages_per_day NUMERIC;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS reading_minutes_per_day NUMERIC;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pace_calibrated_at TIMESTAMP WITH TIME ZONE;
+
+-- Time-boxed reading challenges ("finish 3 books in October"); progress counts book_completions inside the window
+CREATE TABLE IF NOT EXISTS challenges (
+    challenge_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    title TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    goal_books INTEGER NOT NULL CHECK (goal_books > 0),
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ends_at TIMESTAMP WITH TIME ZONE NOT NULL CHECK (ends_at > starts_at),
+    reward_code TEXT GENERATED ALWAYS AS ('challenge:' || challenge_id::text) STORED,
+    finalized_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE challenges ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for challenges" ON challenges FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_challenges_ends_at ON challenges(ends_at);
+
+CREATE TABLE IF NOT EXISTS challenge_participants (
+    challenge_id UUID REFERENCES challenges(challenge_id) ON DELETE CASCADE NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    progress INTEGER NOT NULL DEFAULT 0,
+    joined_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (challenge_id, user_id)
+);
+
+ALTER TABLE challenge_participants ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for challenge_participants" ON challenge_participants FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_challenge_participants_user ON challenge_participants(user_id);
+
+-- Per-book stakes ("if I miss this deadline, tell my partner / I donate ¥1000").
+-- The contact only receives messages after consenting from LINE with 「立会人 <consent_code>」.
+CREATE TABLE IF NOT EXISTS book_stakes (
+    stake_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    book_id UUID REFERENCES books(book_id) ON DELETE CASCADE NOT NULL UNIQUE,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('partner', 'donation')),
+    amount INTEGER CHECK (amount > 0),
+    note TEXT NOT NULL DEFAULT '',
+    consent_code TEXT NOT NULL UNIQUE,
+    contact_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    consented_at TIMESTAMP WITH TIME ZONE,
+    triggered_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE book_stakes ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for book_stakes" ON book_stakes FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_book_stakes_user ON book_stakes(user_id);
+
+-- Focused reading mode: NULL = off, otherwise minutes until the 「何ページ読みましたか？」 check-in
+ALTER TABLE users ADD COLUMN IF NOT EXISTS focus_minutes INTEGER CHECK (focus_minutes BETWEEN 5 AND 180);
+ALTER TABLE reading_sessions ADD COLUMN IF NOT EXISTS focus BOOLEAN NOT NULL DEFAULT false;
+CREATE INDEX IF NOT EXISTS idx_reading_sessions_open_focus ON reading_sessions(user_id) WHERE focus AND ended_at IS NULL;
+
+-- Email-to-register: order confirmations forwarded to register+<inbound_email_token>@INBOUND_EMAIL_DOMAIN
+ALTER TABLE users ADD COLUMN IF NOT EXISTS inbound_email_token TEXT UNIQUE;
+
+-- Draft registrations from Amazon order history CSVs, confirmed one by one (POST /api/import/drafts/{id})
+CREATE TABLE IF NOT EXISTS import_drafts (
+    draft_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    source TEXT NOT NULL,
+    source_key TEXT NOT NULL,
+    title TEXT NOT NULL,
+    isbn TEXT,
+    format TEXT NOT NULL DEFAULT 'paperback',
+    price INTEGER,
+    purchased_at TIMESTAMP WITH TIME ZONE,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'confirmed', 'dismissed')),
+    book_id UUID REFERENCES books(book_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, source, source_key)
+);
+
+ALTER TABLE import_drafts ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for import_drafts" ON import_drafts FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_import_drafts_user_pending ON import_drafts(user_id) WHERE status = 'pending';
+
+-- Shared ISBN metadata cache across users (METADATA_CATALOG_TTL_DAYS, default 30; misses are kept for a day)
+CREATE TABLE IF NOT EXISTS books_catalog (
+    isbn TEXT PRIMARY KEY,
+    title TEXT NOT NULL DEFAULT '',
+    author TEXT NOT NULL DEFAULT '',
+    page_count INTEGER,
+    cover_url TEXT NOT NULL DEFAULT '',
+    source TEXT NOT NULL DEFAULT '',
+    not_found BOOLEAN NOT NULL DEFAULT false,
+    fetched_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE books_catalog ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for books_catalog" ON books_catalog FOR ALL USING (true) WITH CHECK (true);
+
+-- Hiragana readings (yomi) of titles and authors for gojūon sorting and reading-based search
+ALTER TABLE books ADD COLUMN IF NOT EXISTS title_yomi TEXT;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS author_yomi TEXT;
+ALTER TABLE books_catalog ADD COLUMN IF NOT EXISTS title_yomi TEXT NOT NULL DEFAULT '';
+ALTER TABLE books_catalog ADD COLUMN IF NOT EXISTS author_yomi TEXT NOT NULL DEFAULT '';
+
+-- Notification channel per book, falling back to the user's preference and then NOTIFY_DEFAULT_CHANNEL (line)
+ALTER TABLE books ADD COLUMN IF NOT EXISTS notify_channel TEXT CHECK (notify_channel IN ('line', 'email'));
+ALTER TABLE users ADD COLUMN IF NOT EXISTS notify_channel TEXT CHECK (notify_channel IN ('line', 'email'));
+ALTER TABLE users ADD COLUMN IF NOT EXISTS notify_email TEXT;
+ALTER TABLE notification_jobs ADD COLUMN IF NOT EXISTS channel TEXT NOT NULL DEFAULT 'line';
+ALTER TABLE notification_jobs ALTER COLUMN line_user_id DROP NOT NULL;
+
+-- Quiet hours (JST hour of day); reminders due inside the window are skipped with last_error 'quiet_hours'
+ALTER TABLE users ADD COLUMN IF NOT EXISTS quiet_hours_start INTEGER CHECK (quiet_hours_start BETWEEN 0 AND 23);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS quiet_hours_end INTEGER CHECK (quiet_hours_end BETWEEN 0 AND 23);
+CREATE INDEX IF NOT EXISTS idx_notification_jobs_user_created ON notification_jobs(user_id, created_at DESC);
+
+-- Escalation: after escalate_after ignored insults on the primary channel, notify escalate_to once per overdue period
+ALTER TABLE users ADD COLUMN IF NOT EXISTS escalate_after INTEGER CHECK (escalate_after >= 1);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS escalate_to TEXT CHECK (escalate_to IN ('line', 'email', 'partner'));
+
+-- Post-deadline reminder cadence (daily, every_3_days, weekly, backoff, once); NULL falls back to the user, then REMINDER_CADENCE
+ALTER TABLE books ADD COLUMN IF NOT EXISTS reminder_cadence TEXT CHECK (reminder_cadence IN ('daily', 'every_3_days', 'weekly', 'backoff', 'once'));
+ALTER TABLE users ADD COLUMN IF NOT EXISTS reminder_cadence TEXT CHECK (reminder_cadence IN ('daily', 'every_3_days', 'weekly', 'backoff', 'once'));
+CREATE INDEX IF NOT EXISTS idx_notification_jobs_kind_sent ON notification_jobs(kind, status, sent_at);
+
+-- Per-book reminder mute; muted_until NULL means muted until explicitly unmuted
+ALTER TABLE books ADD COLUMN IF NOT EXISTS muted_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS muted_until TIMESTAMP WITH TIME ZONE;
+
+-- Workspaces (labs, book clubs) sharing one backend; a user belongs to at most one workspace
+CREATE TABLE IF NOT EXISTS workspaces (
+    workspace_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    slug TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    join_code TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE workspaces ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for workspaces" ON workspaces FOR ALL USING (true) WITH CHECK (true);
+
+CREATE TABLE IF NOT EXISTS workspace_members (
+    workspace_id UUID REFERENCES workspaces(workspace_id) ON DELETE CASCADE NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL UNIQUE,
+    role TEXT NOT NULL DEFAULT 'member' CHECK (role IN ('member', 'admin')),
+    joined_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (workspace_id, user_id)
+);
+
+ALTER TABLE workspace_members ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for workspace_members" ON workspace_members FOR ALL USING (true) WITH CHECK (true);
+
+-- Insult templates shared by every member of a workspace
+CREATE TABLE IF NOT EXISTS workspace_insults (
+    insult_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    workspace_id UUID REFERENCES workspaces(workspace_id) ON DELETE CASCADE NOT NULL,
+    text TEXT NOT NULL,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE workspace_insults ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for workspace_insults" ON workspace_insults FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_workspace_insults_workspace_id ON workspace_insults(workspace_id);
+
+-- Instance-wide roles for admin APIs (user < moderator < admin); ADMIN_API_TOKEN, when set, still acts as admin
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'moderator', 'admin'));
+CREATE INDEX IF NOT EXISTS idx_users_role ON users(role) WHERE role <> 'user';
+
+-- Change feed for book rows written outside this API (Supabase client, dashboard, SQL editor).
+-- The backend LISTENs on book_changes (BOOK_CHANGE_FEED=true, built with -tags pgx) and feeds its event bus.
+-- Writes by the API itself are skipped: service role via PostgREST, X-Tundoku-Source header in RLS mode, tundoku.source in direct transactions.
+CREATE OR REPLACE FUNCTION notify_book_change() RETURNS trigger
+LANGUAGE plpgsql AS $$
+DECLARE
+    claims JSON := NULLIF(current_setting('request.jwt.claims', true), '')::JSON;
+    headers JSON := NULLIF(current_setting('request.headers', true), '')::JSON;
+BEGIN
+    IF COALESCE(claims->>'role', '') = 'service_role'
+        OR COALESCE(headers->>'x-tundoku-source', '') = 'api'
+        OR COALESCE(current_setting('tundoku.source', true), '') = 'api' THEN
+        RETURN NULL;
+    END IF;
+    -- Only ids and statuses: NOTIFY payloads are capped at 8000 bytes, the backend refetches the row
+    PERFORM pg_notify('book_changes', json_build_object(
+        'op', TG_OP,
+        'book_id', CASE WHEN TG_OP = 'DELETE' THEN OLD.book_id ELSE NEW.book_id END,
+        'user_id', CASE WHEN TG_OP = 'DELETE' THEN OLD.user_id ELSE NEW.user_id END,
+        'old_status', CASE WHEN TG_OP = 'INSERT' THEN NULL ELSE OLD.status END,
+        'status', CASE WHEN TG_OP = 'DELETE' THEN NULL ELSE NEW.status END
+    )::TEXT);
+    RETURN NULL;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS books_notify_change ON books;
+CREATE TRIGGER books_notify_change AFTER INSERT OR UPDATE OR DELETE ON books
+    FOR EACH ROW EXECUTE FUNCTION notify_book_change();
+
+-- Outgoing message format version of a notification job; older instances leave newer jobs to newer ones during rolling deploys
+ALTER TABLE notification_jobs ADD COLUMN IF NOT EXISTS payload_version INT NOT NULL DEFAULT 1;
+
+-- Classification of the last LINE API error of a notification job (invalid_token, blocked_user, rate_limited, malformed_message, server_error)
+ALTER TABLE notification_jobs ADD COLUMN IF NOT EXISTS error_class TEXT;
+
+-- Read-only personal access tokens for integrations (/api/public/*); only the SHA-256 of the token is stored
+CREATE TABLE IF NOT EXISTS personal_access_tokens (
+    token_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    name TEXT NOT NULL,
+    scopes TEXT[] NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+ALTER TABLE personal_access_tokens ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for personal_access_tokens" ON personal_access_tokens FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_personal_access_tokens_user_id ON personal_access_tokens(user_id);
+
+-- Per-user defaults applied when a registration omits the deadline, insult level or tags (deadline offset like '+30 days')
+ALTER TABLE users ADD COLUMN IF NOT EXISTS default_deadline_offset TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS default_insult_level INTEGER CHECK (default_insult_level BETWEEN 1 AND 5);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS default_tags TEXT[];
+
+-- Waiting status for library holds and preorders: books become unread with a fresh deadline on available_at
+ALTER TABLE books ADD COLUMN IF NOT EXISTS available_at TIMESTAMPTZ;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS waiting_for TEXT CHECK (waiting_for IN ('library', 'preorder'));
+CREATE INDEX IF NOT EXISTS idx_books_waiting_available_at ON books(available_at) WHERE status = 'waiting';
+
+-- Files attached to a book (receipts, syllabus pages); the content lives in Storage under attachments/
+CREATE TABLE IF NOT EXISTS book_attachments (
+    attachment_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    book_id UUID REFERENCES books(book_id) ON DELETE CASCADE NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    file_name TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size_bytes INTEGER NOT NULL,
+    storage_path TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE book_attachments ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for book_attachments" ON book_attachments FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_book_attachments_book_id ON book_attachments(book_id);
+
+-- Projects: books sharing a final deadline (e.g. an exam); the cron paces the books against it
+CREATE TABLE IF NOT EXISTS projects (
+    project_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    name TEXT NOT NULL,
+    deadline TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_reminded_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE projects ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for projects" ON projects FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_projects_user_id ON projects(user_id);
+CREATE INDEX IF NOT EXISTS idx_projects_deadline ON projects(deadline);
+
+ALTER TABLE books ADD COLUMN IF NOT EXISTS project_id UUID REFERENCES projects(project_id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_books_project_id ON books(project_id) WHERE project_id IS NOT NULL;
+
+-- Idempotency guard: one live notification per (JST day, kind, user, book, milestone, recipient)
+ALTER TABLE notification_jobs ADD COLUMN IF NOT EXISTS dedupe_key TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notification_jobs_dedupe_key ON notification_jobs(dedupe_key)
+    WHERE dedupe_key IS NOT NULL AND status NOT IN ('failed', 'skipped');
+
+-- Read-only support access by admins (/api/admin/users/{id}/...); every view is logged with its reason
+CREATE TABLE IF NOT EXISTS support_access_log (
+    access_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL, -- the user whose data was viewed
+    actor_id UUID REFERENCES users(id) ON DELETE SET NULL,        -- the admin; NULL when ADMIN_API_TOKEN was used
+    via_token BOOLEAN NOT NULL DEFAULT FALSE,
+    resource TEXT NOT NULL, -- books, notifications, settings
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE support_access_log ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for support_access_log" ON support_access_log FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_support_access_log_user_id ON support_access_log(user_id, created_at DESC);
+
+-- LINE activity timestamps used to learn each user's best send hour, plus an explicit override
+CREATE TABLE IF NOT EXISTS line_activity (
+    activity_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    line_user_id TEXT REFERENCES users(line_user_id) ON DELETE CASCADE ON UPDATE CASCADE NOT NULL,
+    event_type TEXT NOT NULL, -- message, postback, follow
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE line_activity ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for line_activity" ON line_activity FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_line_activity_user ON line_activity(line_user_id, occurred_at DESC);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS preferred_send_hour INTEGER CHECK (preferred_send_hour BETWEEN 0 AND 23);
+
+-- Account merge (POST /api/admin/users/merge, POST /api/users/me/merge): moves everything owned by p_source to p_target
+-- in one transaction, records user.merge in audit_log and deletes p_source. Returns the audit action_id and moved row counts.
+CREATE OR REPLACE FUNCTION merge_users(p_source UUID, p_target UUID, p_actor UUID DEFAULT NULL, p_via TEXT DEFAULT 'user')
+RETURNS JSONB
+LANGUAGE plpgsql AS $$
+DECLARE
+    src users%ROWTYPE;
+    dst users%ROWTYPE;
+    moved JSONB := '{}'::JSONB;
+    n BIGINT;
+    t TEXT;
+    activity line_activity[];
+    v_action_id UUID;
+BEGIN
+    IF p_source = p_target THEN
+        RAISE EXCEPTION 'cannot merge a user into itself' USING ERRCODE = '22023';
+    END IF;
+    -- lock both rows (in a fixed order) so concurrent merges or logins cannot interleave
+    PERFORM 1 FROM users WHERE id IN (p_source, p_target) ORDER BY id FOR UPDATE;
+    SELECT * INTO src FROM users WHERE id = p_source;
+    SELECT * INTO dst FROM users WHERE id = p_target;
+    IF src.id IS NULL OR dst.id IS NULL THEN
+        RAISE EXCEPTION 'user not found' USING ERRCODE = 'P0002';
+    END IF;
+
+    -- plain reassignment for tables keyed only by their own id
+    FOREACH t IN ARRAY ARRAY['books', 'notification_jobs', 'book_completions', 'reading_sessions', 'book_notes',
+        'book_milestones', 'progress_logs', 'custom_insults', 'user_sessions', 'audit_log', 'data_requests',
+        'book_stakes', 'personal_access_tokens', 'book_attachments', 'projects', 'support_access_log', 'reading_buddies'] LOOP
+        EXECUTE format('UPDATE %I SET user_id = $1 WHERE user_id = $2', t) USING p_target, p_source;
+        GET DIAGNOSTICS n = ROW_COUNT;
+        moved := moved || jsonb_build_object(t, n);
+    END LOOP;
+    UPDATE book_stakes SET contact_user_id = p_target WHERE contact_user_id = p_source;
+    UPDATE workspace_insults SET created_by = p_target WHERE created_by = p_source;
+    UPDATE support_access_log SET actor_id = p_target WHERE actor_id = p_source;
+    UPDATE book_club_polls SET created_by = p_target WHERE created_by = p_source;
+    UPDATE book_club_candidates SET proposed_by = p_target WHERE proposed_by = p_source;
+
+    -- drafts: keep the target's draft when both imported the same source item
+    DELETE FROM import_drafts s USING import_drafts d
+        WHERE s.user_id = p_source AND d.user_id = p_target AND s.source = d.source AND s.source_key = d.source_key;
+    UPDATE import_drafts SET user_id = p_target WHERE user_id = p_source;
+
+    -- composite keys: the earliest unlock / join wins, usage counters add up
+    INSERT INTO user_achievements (user_id, code, unlocked_at)
+        SELECT p_target, code, unlocked_at FROM user_achievements WHERE user_id = p_source
+        ON CONFLICT (user_id, code) DO UPDATE SET unlocked_at = LEAST(user_achievements.unlocked_at, EXCLUDED.unlocked_at);
+    GET DIAGNOSTICS n = ROW_COUNT;
+    moved := moved || jsonb_build_object('user_achievements', n);
+    DELETE FROM user_achievements WHERE user_id = p_source;
+
+    INSERT INTO challenge_participants (challenge_id, user_id, progress, joined_at, completed_at)
+        SELECT challenge_id, p_target, progress, joined_at, completed_at FROM challenge_participants WHERE user_id = p_source
+        ON CONFLICT (challenge_id, user_id) DO UPDATE SET
+            progress = challenge_participants.progress + EXCLUDED.progress,
+            joined_at = LEAST(challenge_participants.joined_at, EXCLUDED.joined_at),
+            completed_at = LEAST(challenge_participants.completed_at, EXCLUDED.completed_at);
+    DELETE FROM challenge_participants WHERE user_id = p_source;
+
+    INSERT INTO group_shame_members (group_id, user_id, display_name, consented_at)
+        SELECT group_id, p_target, display_name, consented_at FROM group_shame_members WHERE user_id = p_source
+        ON CONFLICT (group_id, user_id) DO NOTHING;
+    DELETE FROM group_shame_members WHERE user_id = p_source;
+
+    -- book club votes: the target's own vote in a poll wins
+    DELETE FROM book_club_votes s USING book_club_votes d
+        WHERE s.user_id = p_source AND d.user_id = p_target AND s.poll_id = d.poll_id;
+    UPDATE book_club_votes SET user_id = p_target WHERE user_id = p_source;
+
+    INSERT INTO api_usage (user_id, day, endpoint, calls, rejected, updated_at)
+        SELECT p_target, day, endpoint, calls, rejected, updated_at FROM api_usage WHERE user_id = p_source
+        ON CONFLICT (user_id, day, endpoint) DO UPDATE SET
+            calls = api_usage.calls + EXCLUDED.calls, rejected = api_usage.rejected + EXCLUDED.rejected;
+    DELETE FROM api_usage WHERE user_id = p_source;
+
+    -- one-per-user rows: the target's own row wins
+    IF NOT EXISTS (SELECT 1 FROM workspace_members WHERE user_id = p_target) THEN
+        UPDATE workspace_members SET user_id = p_target WHERE user_id = p_source;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM notion_connections WHERE user_id = p_target) THEN
+        UPDATE notion_connections SET user_id = p_target WHERE user_id = p_source;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM user_onboarding WHERE user_id = p_target) THEN
+        UPDATE user_onboarding SET user_id = p_target WHERE user_id = p_source;
+    END IF;
+
+    -- identities the target lacks move over; the unique columns are cleared on the source first.
+    -- line_activity would cascade that NULL into its NOT NULL column, so it is set aside and restored
+    -- only when the source's LINE account moves to the target.
+    activity := ARRAY(SELECT a FROM line_activity a WHERE a.line_user_id = src.line_user_id);
+    DELETE FROM line_activity WHERE line_user_id = src.line_user_id;
+    UPDATE users SET line_user_id = NULL, auth_user_id = NULL, feed_token = NULL, public_slug = NULL, inbound_email_token = NULL
+        WHERE id = p_source;
+    UPDATE users SET
+        line_user_id = COALESCE(dst.line_user_id, src.line_user_id),
+        line_blocked_at = CASE WHEN dst.line_user_id IS NULL THEN src.line_blocked_at ELSE dst.line_blocked_at END,
+        line_channel = COALESCE(dst.line_channel, src.line_channel),
+        auth_user_id = COALESCE(dst.auth_user_id, src.auth_user_id),
+        notify_email = COALESCE(dst.notify_email, src.notify_email),
+        feed_token = COALESCE(dst.feed_token, src.feed_token),
+        public_slug = COALESCE(dst.public_slug, src.public_slug),
+        inbound_email_token = COALESCE(dst.inbound_email_token, src.inbound_email_token),
+        longest_streak = GREATEST(dst.longest_streak, src.longest_streak),
+        current_streak = CASE WHEN src.last_completed_at > dst.last_completed_at OR dst.last_completed_at IS NULL
+            THEN src.current_streak ELSE dst.current_streak END,
+        last_completed_at = GREATEST(dst.last_completed_at, src.last_completed_at),
+        role = CASE WHEN dst.role = 'admin' OR src.role = 'admin' THEN 'admin'
+            WHEN dst.role = 'moderator' OR src.role = 'moderator' THEN 'moderator' ELSE 'user' END,
+        updated_at = NOW()
+        WHERE id = p_target;
+    IF dst.line_user_id IS NULL THEN
+        INSERT INTO line_activity SELECT * FROM unnest(activity);
+    END IF;
+
+    INSERT INTO audit_log (user_id, action, payload)
+        VALUES (p_target, 'user.merge', jsonb_build_object(
+            'source_id', p_source, 'source_display_name', src.display_name,
+            'source_line_user_id', src.line_user_id, 'source_auth_user_id', src.auth_user_id,
+            'actor_id', p_actor, 'via', p_via, 'moved', moved))
+        RETURNING action_id INTO v_action_id;
+
+    DELETE FROM users WHERE id = p_source;
+    RETURN jsonb_build_object('action_id', v_action_id, 'moved', moved);
+END;
+$$;
+
+-- Sandbox mode (SANDBOX_MODE=true): LINE and email sends are captured here instead of being delivered
+CREATE TABLE IF NOT EXISTS sandbox_inbox (
+    message_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    transport TEXT NOT NULL CHECK (transport IN ('line', 'email')),
+    endpoint TEXT NOT NULL, -- push, reply, multicast, broadcast, email
+    line_channel TEXT,
+    recipients TEXT[] NOT NULL DEFAULT '{}', -- empty for LINE broadcasts
+    subject TEXT,
+    body JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE sandbox_inbox ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for sandbox_inbox" ON sandbox_inbox FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_sandbox_inbox_created_at ON sandbox_inbox(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_sandbox_inbox_recipients ON sandbox_inbox USING GIN (recipients);
+
+-- Completion certificates: opt-in image sent over LINE when a book is completed
+ALTER TABLE users ADD COLUMN IF NOT EXISTS completion_certificates BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE notification_jobs ADD COLUMN IF NOT EXISTS image_url TEXT;
+
+-- Reading buddies: opt-in pairing of two users reading the same ISBN or tag; paired rows point at each other
+CREATE TABLE IF NOT EXISTS reading_buddies (
+    entry_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    book_id UUID REFERENCES books(book_id) ON DELETE CASCADE NOT NULL,
+    match_key TEXT NOT NULL,
+    match_label TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'waiting' CHECK (status IN ('waiting', 'matched', 'ended')),
+    partner_entry_id UUID REFERENCES reading_buddies(entry_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    matched_at TIMESTAMP WITH TIME ZONE,
+    ended_at TIMESTAMP WITH TIME ZONE
+);
+
+ALTER TABLE reading_buddies ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for reading_buddies" ON reading_buddies FOR ALL USING (true) WITH CHECK (true);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reading_buddies_active_book ON reading_buddies(book_id) WHERE status IN ('waiting', 'matched');
+CREATE INDEX IF NOT EXISTS idx_reading_buddies_waiting ON reading_buddies(match_key, created_at) WHERE status = 'waiting';
+
+-- Book club polls: workspace members propose and vote on the next book until closes_at; /api/cron/book-club
+-- closes due polls and registers the winner for every member with the shared reading_deadline
+CREATE TABLE IF NOT EXISTS book_club_polls (
+    poll_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    workspace_id UUID REFERENCES workspaces(workspace_id) ON DELETE CASCADE NOT NULL,
+    title TEXT NOT NULL,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    closes_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    reading_deadline TIMESTAMP WITH TIME ZONE NOT NULL,
+    status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'closed')),
+    winner_candidate_id UUID,
+    closed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CHECK (reading_deadline > closes_at)
+);
+
+CREATE TABLE IF NOT EXISTS book_club_candidates (
+    candidate_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    poll_id UUID REFERENCES book_club_polls(poll_id) ON DELETE CASCADE NOT NULL,
+    title TEXT NOT NULL,
+    author TEXT NOT NULL,
+    page_count INTEGER CHECK (page_count > 0),
+    proposed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS book_club_votes (
+    poll_id UUID REFERENCES book_club_polls(poll_id) ON DELETE CASCADE NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    candidate_id UUID REFERENCES book_club_candidates(candidate_id) ON DELETE CASCADE NOT NULL,
+    voted_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (poll_id, user_id)
+);
+
+ALTER TABLE book_club_polls ENABLE ROW LEVEL SECURITY;
+ALTER TABLE book_club_candidates ENABLE ROW LEVEL SECURITY;
+ALTER TABLE book_club_votes ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for book_club_polls" ON book_club_polls FOR ALL USING (true) WITH CHECK (true);
+CREATE POLICY "Enable all for book_club_candidates" ON book_club_candidates FOR ALL USING (true) WITH CHECK (true);
+CREATE POLICY "Enable all for book_club_votes" ON book_club_votes FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_book_club_polls_workspace ON book_club_polls(workspace_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_book_club_polls_open ON book_club_polls(closes_at) WHERE status = 'open';
+CREATE INDEX IF NOT EXISTS idx_book_club_candidates_poll ON book_club_candidates(poll_id);
+
+-- Data minimization: per-user retention enforced by /api/cron/retention. Without insult history the text of
+-- finished insult jobs is blanked (timestamps stay for cadence); finished notification jobs older than the
+-- retention are deleted (at least 30 days so the longest reminder backoff still works)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS retain_insult_history BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS notification_log_retention_days INTEGER CHECK (notification_log_retention_days BETWEEN 30 AND 3650);
+
+-- Time-boxed announcement banners polled by the frontend (GET /api/announcements); messages maps locale to text
+CREATE TABLE IF NOT EXISTS announcements (
+    announcement_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    severity TEXT NOT NULL DEFAULT 'info' CHECK (severity IN ('info', 'warning', 'critical')),
+    messages JSONB NOT NULL,
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    ends_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CHECK (ends_at > starts_at)
+);
+
+ALTER TABLE announcements ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for announcements" ON announcements FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_announcements_ends_at ON announcements(ends_at);
+
+-- Raw LINE webhook events kept for debugging chat commands (inspect/replay via /api/admin/webhook-events).
+-- Bodies that failed signature verification or could not be parsed are stored truncated in body;
+-- purged by /api/cron/retention after RETENTION_WEBHOOK_EVENTS_DAYS (default 14)
+CREATE TABLE IF NOT EXISTS line_webhook_events (
+    event_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    channel TEXT NOT NULL,
+    signature_valid BOOLEAN NOT NULL,
+    event_type TEXT,
+    source_type TEXT,
+    line_user_id TEXT,
+    group_id TEXT,
+    event JSONB,
+    body TEXT,
+    received_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    replay_count INTEGER NOT NULL DEFAULT 0,
+    last_replayed_at TIMESTAMP WITH TIME ZONE,
+    CHECK (event IS NOT NULL OR body IS NOT NULL)
+);
+
+ALTER TABLE line_webhook_events ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for line_webhook_events" ON line_webhook_events FOR ALL USING (true) WITH CHECK (true);
+CREATE INDEX IF NOT EXISTS idx_line_webhook_events_received ON line_webhook_events(received_at DESC);
+CREATE INDEX IF NOT EXISTS idx_line_webhook_events_line_user ON line_webhook_events(line_user_id, received_at DESC);
+
+-- Deadline extensions ("あと1週間" reply to an insult, or POST /api/books/{id}/extend).
+-- extension_penalty NULL means insult_up (raise insult_level by one per extension)
+ALTER TABLE books ADD COLUMN IF NOT EXISTS extension_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS extension_penalty TEXT CHECK (extension_penalty IN ('none', 'insult_up'));
+
+-- Guided onboarding progress (GET/POST /api/onboarding, "はじめかた" on LINE).
+-- Steps: link_line, preferences, register_books, first_deadline; current_step is 'done' once all are completed or skipped
+CREATE TABLE IF NOT EXISTS user_onboarding (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    current_step TEXT NOT NULL CHECK (current_step IN ('link_line', 'preferences', 'register_books', 'first_deadline', 'done')),
+    completed_steps TEXT[] NOT NULL DEFAULT '{}',
+    skipped_steps TEXT[] NOT NULL DEFAULT '{}',
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+ALTER TABLE user_onboarding ENABLE ROW LEVEL SECURITY;
+CREATE POLICY "Enable all for user_onboarding" ON user_onboarding FOR ALL USING (true) WITH CHECK (true);
+
+-- Bulk deadline shift (POST /api/books/shift-deadlines): moves the given books' deadlines by p_days in one statement.
+-- Insulted books whose new deadline is in the future go back to unread. Returns the updated rows.
+CREATE OR REPLACE FUNCTION shift_book_deadlines(p_user_id UUID, p_book_ids UUID[], p_days INTEGER)
+RETURNS SETOF books
+LANGUAGE sql AS $$
+    UPDATE books SET
+        deadline = deadline + make_interval(days => p_days),
+        status = CASE WHEN status = 'insulted' AND deadline + make_interval(days => p_days) > NOW() THEN 'unread' ELSE status END,
+        updated_at = NOW()
+    WHERE user_id = p_user_id
+        AND book_id = ANY(p_book_ids)
+        AND deadline IS NOT NULL
+        AND status IN ('unread', 'reading', 'insulted')
+    RETURNING *;
+$$;
+
+-- Family-safe mode: only gentle reminders, no group shaming, stakes or escalation.
+-- Applies when either the user or the user's workspace turns it on
+ALTER TABLE users ADD COLUMN IF NOT EXISTS family_safe BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE workspaces ADD COLUMN IF NOT EXISTS family_safe BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- IANA time zone used to read date-only deadlines ("2026-10-20", "来週末"); NULL means Asia/Tokyo
+ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone TEXT;
+
+-- Completing a book over PostgREST in one transaction: flip the status, record the completion,
+-- extend or reset the streak and unlock achievements (completion.go). The achievement rules come from
+-- achievements.go as [{code, stat, min}] so the thresholds live in one place.
+-- Raises P0002 when the book does not exist and 55000 when it is already completed.
+-- p_now is the backend's clock so completions line up with the pgx path and with time-shifted test runs.
+DROP FUNCTION IF EXISTS complete_book(UUID, JSONB);
+CREATE OR REPLACE FUNCTION complete_book(p_book_id UUID, p_achievements JSONB DEFAULT '[]'::JSONB, p_now TIMESTAMP WITH TIME ZONE DEFAULT NOW())
+RETURNS JSONB
+LANGUAGE plpgsql AS $$
+DECLARE
+    b books%ROWTYPE;
+    c book_completions%ROWTYPE;
+    v_now TIMESTAMP WITH TIME ZONE := COALESCE(p_now, NOW());
+    v_deadline TIMESTAMP WITH TIME ZONE;
+    v_current INTEGER;
+    v_longest INTEGER;
+    v_streak INTEGER;
+    v_completed BIGINT;
+    v_unlocked TEXT[];
+BEGIN
+    UPDATE books SET status = 'completed', updated_at = v_now
+        WHERE book_id = p_book_id AND status <> 'completed'
+        RETURNING * INTO b;
+    IF NOT FOUND THEN
+        IF EXISTS (SELECT 1 FROM books WHERE book_id = p_book_id) THEN
+            RAISE EXCEPTION 'book is already completed' USING ERRCODE = '55000';
+        END IF;
+        RAISE EXCEPTION 'book not found' USING ERRCODE = 'P0002';
+    END IF;
+
+    -- books without a deadline (wishlist) count as finished on the day
+    v_deadline := COALESCE(b.deadline, v_now);
+    INSERT INTO book_completions (book_id, user_id, completed_at, deadline, days_early, read_cycle, started_at)
+        VALUES (b.book_id, b.user_id, v_now, v_deadline,
+            FLOOR(EXTRACT(EPOCH FROM (v_deadline - v_now)) / 86400)::INTEGER,
+            GREATEST(b.read_cycle, 1), COALESCE(b.read_started_at, b.created_at))
+        RETURNING * INTO c;
+
+    -- lock the user row so two completions at once cannot both extend the same streak
+    SELECT current_streak, longest_streak INTO v_current, v_longest FROM users WHERE id = b.user_id FOR UPDATE;
+    v_streak := CASE WHEN c.days_early >= 0 THEN COALESCE(v_current, 0) + 1 ELSE 0 END;
+    UPDATE users SET
+        current_streak = v_streak,
+        longest_streak = GREATEST(COALESCE(v_longest, 0), v_streak),
+        last_completed_at = v_now,
+        updated_at = v_now
+        WHERE id = b.user_id;
+
+    SELECT COUNT(*) INTO v_completed FROM book_completions WHERE user_id = b.user_id;
+    WITH eligible AS (
+        SELECT r.code FROM jsonb_to_recordset(p_achievements) AS r(code TEXT, stat TEXT, min INTEGER)
+        WHERE CASE r.stat
+            WHEN 'completed' THEN v_completed >= r.min
+            WHEN 'streak' THEN v_streak >= r.min
+            WHEN 'days_early' THEN c.days_early >= r.min
+            ELSE FALSE
+        END
+    ), inserted AS (
+        INSERT INTO user_achievements (user_id, code, unlocked_at)
+            SELECT b.user_id, code, v_now FROM eligible
+            ON CONFLICT (user_id, code) DO NOTHING
+            RETURNING code
+    )
+    SELECT COALESCE(array_agg(code), ARRAY[]::TEXT[]) INTO v_unlocked FROM inserted;
+
+    RETURN jsonb_build_object(
+        'book', to_jsonb(b),
+        'completion', to_jsonb(c),
+        'streak', v_streak,
+        'achievements', to_jsonb(v_unlocked)
+    );
+END;
+$$;
+
+-- Reading queue order (PUT /api/books/reorder): writes sort_order 0, 1, 2, ... in the order of p_book_ids in one statement.
+-- Books that do not belong to p_user_id are left alone.
+CREATE OR REPLACE FUNCTION save_sort_order(p_user_id UUID, p_book_ids UUID[], p_now TIMESTAMP WITH TIME ZONE DEFAULT NOW())
+RETURNS VOID
+LANGUAGE sql AS $$
+    UPDATE books SET sort_order = q.ord - 1, updated_at = p_now
+    FROM unnest(p_book_ids) WITH ORDINALITY AS q(book_id, ord)
+    WHERE books.book_id = q.book_id AND books.user_id = p_user_id;
+$$;